@@ -51,3 +51,19 @@ const (
 	// and user intervention is required to get them fixed.
 	CertificatesGenerationFailedReason string = "CertificateGenerationFailed"
 )
+
+const (
+	// ControlPlaneEndpointResolvedCondition documents that the host of the control plane endpoint resolves, from the
+	// management cluster, to an address of the control plane. It is only reported when the validation of the control
+	// plane endpoint is enabled, it doesn't prevent the bootstrap data from being generated.
+	ControlPlaneEndpointResolvedCondition clusterv1.ConditionType = "ControlPlaneEndpointResolved"
+
+	// ControlPlaneEndpointUnresolvedReason (Severity=Warning) documents a control plane endpoint whose host doesn't
+	// resolve.
+	ControlPlaneEndpointUnresolvedReason = "ControlPlaneEndpointUnresolved"
+
+	// ControlPlaneEndpointMismatchReason (Severity=Warning) documents a control plane endpoint pointing at none of the
+	// addresses of the control plane: the addresses of its servers, and its registration addresses, e.g. of the load
+	// balancers in front of the servers.
+	ControlPlaneEndpointMismatchReason = "ControlPlaneEndpointMismatch"
+)
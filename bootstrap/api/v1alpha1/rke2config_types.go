@@ -17,6 +17,8 @@ limitations under the License.
 package v1alpha1
 
 import (
+	"sort"
+
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
@@ -24,7 +26,7 @@ import (
 )
 
 // Format specifies the output format of the bootstrap data
-// +kubebuilder:validation:Enum=cloud-config;ignition
+// +kubebuilder:validation:Enum=cloud-config;ignition;combustion
 type Format string
 
 const (
@@ -33,6 +35,47 @@ const (
 
 	// Ignition make the bootstrap data to be of Ignition format.
 	Ignition Format = "ignition"
+
+	// Combustion make the bootstrap data to be a Combustion script, used to bootstrap transactional-update hosts
+	// like openSUSE MicroOS and SLE Micro.
+	Combustion Format = "combustion"
+)
+
+// DataSecretEncoding specifies the encoding of the bootstrap data under the key of the DataSecret.
+// +kubebuilder:validation:Enum=base64;gzip+base64
+type DataSecretEncoding string
+
+const (
+	// Base64DataSecretEncoding encodes the bootstrap data in base64.
+	Base64DataSecretEncoding DataSecretEncoding = "base64"
+
+	// GzipBase64DataSecretEncoding compresses the bootstrap data with gzip and encodes it in base64.
+	GzipBase64DataSecretEncoding DataSecretEncoding = "gzip+base64"
+)
+
+// DataSecret defines the key and the encoding of the bootstrap data in its secret.
+type DataSecret struct {
+	// Key is the additional key the bootstrap data is stored under. The data is always stored under "value" as well,
+	// as required by Cluster API.
+	// +kubebuilder:validation:MinLength=1
+	// +kubebuilder:validation:Pattern=`^[-._a-zA-Z0-9]+$`
+	Key string `json:"key"`
+
+	// Encoding is the encoding of the bootstrap data under Key, it is stored as is when unset.
+	// +optional
+	Encoding DataSecretEncoding `json:"encoding,omitempty"`
+}
+
+// ConfigurationMode specifies how the configuration is passed to rke2.
+// +kubebuilder:validation:Enum=File;Flags
+type ConfigurationMode string
+
+const (
+	// FileConfigurationMode writes the configuration to the /etc/rancher/rke2/config.yaml file.
+	FileConfigurationMode ConfigurationMode = "File"
+
+	// FlagsConfigurationMode passes the configuration as command line flags, in a drop-in of the rke2 systemd unit.
+	FlagsConfigurationMode ConfigurationMode = "Flags"
 )
 
 // RKE2ConfigSpec defines the desired state of RKE2Config.
@@ -56,6 +99,24 @@ type RKE2ConfigSpec struct {
 	// PrivateRegistriesConfig defines the containerd configuration for private registries and local registry mirrors.
 	//+optional
 	PrivateRegistriesConfig Registry `json:"privateRegistriesConfig,omitempty"`
+
+	// MachineIdentity mints a client certificate identifying the machine, placed on the node at bootstrap, so that
+	// the node can authenticate to internal services without shared credentials.
+	//+optional
+	MachineIdentity *MachineIdentity `json:"machineIdentity,omitempty"`
+
+	// Attestation writes attestation data held by a secret to the node at bootstrap, e.g. the configuration of a
+	// keylime agent or TPM enrollment data, for measured boot and confidential deployments.
+	//+optional
+	Attestation *Attestation `json:"attestation,omitempty"`
+
+	// JoinTokenTTL makes a worker machine join the cluster with a bootstrap token of its own instead of the token of
+	// the cluster: the token expires after the given duration and is deleted once the node of the machine joined, so
+	// that leaked bootstrap data can't be used to join other nodes. The RKE2 version must support joining agents with
+	// bootstrap tokens, as created by "rke2 token create". Control plane machines always join with the token of the
+	// cluster.
+	//+optional
+	JoinTokenTTL *metav1.Duration `json:"joinTokenTTL,omitempty"`
 }
 
 // RKE2AgentConfig describes some attributes that are common to agent and server nodes.
@@ -80,6 +141,11 @@ type RKE2AgentConfig struct {
 	// +optional
 	NTP *NTP `json:"ntp,omitempty"`
 
+	// NodePreparation prepares the kernel of the node before RKE2 is installed: the kernel modules and parameters
+	// needed by the container runtime and the CNI are set, and swap can be disabled.
+	// +optional
+	NodePreparation *NodePreparation `json:"nodePreparation,omitempty"`
+
 	// ImageCredentialProviderConfigMap is a reference to the ConfigMap that contains credential provider plugin config
 	// The config map should contain a key "credential-config.yaml" with YAML file content and
 	// a key "credential-provider-binaries" with the a path to the binaries for the credential provider.
@@ -103,12 +169,18 @@ type RKE2AgentConfig struct {
 	//+optional
 	ResolvConf *corev1.ObjectReference `json:"resolvConf,omitempty"`
 
+	// HostAliases are entries added to /etc/hosts of the node before RKE2 is installed, and again at every boot, so
+	// that e.g. the control plane endpoint and the registries resolve without an internal DNS.
+	//+optional
+	HostAliases []corev1.HostAlias `json:"hostAliases,omitempty"`
+
 	// ProtectKernelDefaults defines Kernel tuning behavior. If true, error if kernel tunables are different than kubelet defaults.
 	// if false, kernel tunable can be different from kubelet defaults
 	//+optional
 	ProtectKernelDefaults bool `json:"protectKernelDefaults,omitempty"`
 
 	// SystemDefaultRegistry Private registry to be used for all system images.
+	// It must be declared in the PrivateRegistriesConfig mirrors or configs, if any.
 	//+optional
 	SystemDefaultRegistry string `json:"systemDefaultRegistry,omitempty"`
 
@@ -122,6 +194,19 @@ type RKE2AgentConfig struct {
 	//+optional
 	KubeletPath string `json:"kubeletPath,omitempty"`
 
+	// CgroupDriver specifies the cgroup driver used by the kubelet and containerd, it must match the cgroup
+	// hierarchy of the node OS, declared in CgroupVersion. Defaults to the driver detected by RKE2.
+	// +kubebuilder:validation:Enum=systemd;cgroupfs
+	//+optional
+	CgroupDriver CgroupDriver `json:"cgroupDriver,omitempty"`
+
+	// CgroupVersion declares the cgroup version of the node OS family, "v1" for OS images using the
+	// legacy or hybrid cgroup hierarchy and "v2" for OS images using the unified cgroup hierarchy.
+	// It is required when CgroupDriver is set.
+	// +kubebuilder:validation:Enum=v1;v2
+	//+optional
+	CgroupVersion CgroupVersion `json:"cgroupVersion,omitempty"`
+
 	// KubeletArgs Customized flag for kubelet process.
 	//+optional
 	Kubelet *ComponentConfig `json:"kubelet,omitempty"`
@@ -130,6 +215,14 @@ type RKE2AgentConfig struct {
 	//+optional
 	KubeProxy *ComponentConfig `json:"kubeProxy,omitempty"`
 
+	// KubeProxyMode is the mode kube-proxy proxies the services traffic with, "iptables" or "ipvs", rendered
+	// as the proxy-mode argument of kube-proxy. "disabled" is for eBPF CNIs replacing kube-proxy, Cilium or Calico:
+	// kube-proxy is then disabled on all the nodes by the control plane, which must use the same mode.
+	// Defaults to the kube-proxy default mode.
+	// +kubebuilder:validation:Enum=iptables;ipvs;disabled
+	//+optional
+	KubeProxyMode KubeProxyMode `json:"kubeProxyMode,omitempty"`
+
 	// RuntimeImage override image to use for runtime binaries (containerd, kubectl, crictl, etc).
 	//+optional
 	RuntimeImage string `json:"runtimeImage,omitempty"`
@@ -145,12 +238,26 @@ type RKE2AgentConfig struct {
 
 	// AirGapped is a boolean value to define if the bootstrapping should be air-gapped,
 	// basically supposing that online container registries and RKE2 install scripts are not reachable.
+	// The version must then be an exact RKE2 release, the registries of the image overrides must be declared in
+	// privateRegistriesConfig, and the systemDefaultRegistry, if any, must be mirrored.
 	AirGapped bool `json:"airGapped,omitempty"`
 
 	// Format specifies the output format of the bootstrap data. Defaults to cloud-config.
 	// +optional
 	Format Format `json:"format,omitempty"`
 
+	// DataSecret shapes the bootstrap data secret for infrastructure providers expecting the bootstrap data under
+	// another key or encoding than the "value" key of Cluster API, e.g. "userData" or "ignition".
+	// +optional
+	DataSecret *DataSecret `json:"dataSecret,omitempty"`
+
+	// ConfigurationMode specifies how the configuration is passed to rke2, one of File, the
+	// /etc/rancher/rke2/config.yaml file, or Flags, the command line flags set on the rke2-server or rke2-agent
+	// systemd unit by a drop-in, e.g. for images whose build tooling manages the configuration file (default: File).
+	// Note that the flags, including the token, are visible in the process list of the machine.
+	// +optional
+	ConfigurationMode ConfigurationMode `json:"configurationMode,omitempty"`
+
 	// AdditionalUserData is a field that allows users to specify additional cloud-init or ignition configuration to be included in the
 	// generated cloud-init/ignition script.
 	//+optional
@@ -160,6 +267,7 @@ type RKE2AgentConfig struct {
 // AdditionalUserData is a field that allows users to specify additional cloud-init configuration .
 type AdditionalUserData struct {
 	// In case of using ignition, the data format is documented here: https://kinvolk.io/docs/flatcar-container-linux/latest/provisioning/cl-config/
+	// In case of using combustion, the data is a bash script snippet appended to the generated Combustion script.
 	// NOTE: All fields of the UserData that are managed by the RKE2Config controller will be ignored, this include "write_files", "runcmd", "ntp".
 	// +optional
 	Config string `json:"config,omitempty"`
@@ -180,6 +288,24 @@ type NTP struct {
 	Enabled *bool `json:"enabled,omitempty"`
 }
 
+// NodePreparation defines the kernel preparation of a node.
+type NodePreparation struct {
+	// DisableSwap turns swap off and keeps it off across reboots: the swap entries of /etc/fstab are commented out,
+	// the swap units are masked and zram swap, e.g. set up by zram-generator on Fedora, is disabled.
+	// +optional
+	DisableSwap bool `json:"disableSwap,omitempty"`
+
+	// Sysctls are kernel parameters set at every boot, they override the defaults: net.bridge.bridge-nf-call-iptables,
+	// net.bridge.bridge-nf-call-ip6tables and net.ipv4.ip_forward set to 1, vm.overcommit_memory set to 1,
+	// fs.inotify.max_user_watches set to 524288 and fs.inotify.max_user_instances set to 8192.
+	// +optional
+	Sysctls map[string]string `json:"sysctls,omitempty"`
+
+	// KernelModules are kernel modules loaded at every boot, in addition to br_netfilter and overlay.
+	// +optional
+	KernelModules []string `json:"kernelModules,omitempty"`
+}
+
 // RKE2ConfigStatus defines the observed state of RKE2Config.
 type RKE2ConfigStatus struct {
 	// Ready indicates the BootstrapData field is ready to be consumed.
@@ -189,6 +315,25 @@ type RKE2ConfigStatus struct {
 	//+optional
 	DataSecretName *string `json:"dataSecretName,omitempty"`
 
+	// DataSecretGeneration is the generation of the RKE2Config the bootstrap data was generated from. The bootstrap
+	// data is generated again when the spec changes before the infrastructure of the machine is provisioned.
+	//+optional
+	DataSecretGeneration int64 `json:"dataSecretGeneration,omitempty"`
+
+	// DataSecretRegistriesHash is the hash of the registry secrets the bootstrap data was generated with. The bootstrap
+	// data is generated again when they are rotated before the infrastructure of the machine is provisioned.
+	//+optional
+	DataSecretRegistriesHash string `json:"dataSecretRegistriesHash,omitempty"`
+
+	// JoinTokenID is the ID of the bootstrap token the machine joins the cluster with, until the token is deleted once
+	// the node of the machine joined.
+	//+optional
+	JoinTokenID string `json:"joinTokenID,omitempty"`
+
+	// JoinTokenExpiration is the time the bootstrap token the machine joins the cluster with expires.
+	//+optional
+	JoinTokenExpiration *metav1.Time `json:"joinTokenExpiration,omitempty"`
+
 	// FailureReason will be set on non-retryable errors.
 	//+optional
 	FailureReason string `json:"failureReason,omitempty"`
@@ -251,6 +396,42 @@ const (
 	CIS1_6 CISProfile = "cis-1.6"
 )
 
+// CgroupDriver defines the cgroup driver used by the kubelet and containerd.
+type CgroupDriver string
+
+const (
+	// SystemdCgroupDriver references the "systemd" cgroup driver.
+	SystemdCgroupDriver CgroupDriver = "systemd"
+
+	// CgroupfsCgroupDriver references the "cgroupfs" cgroup driver.
+	CgroupfsCgroupDriver CgroupDriver = "cgroupfs"
+)
+
+// KubeProxyMode defines the mode kube-proxy proxies the services traffic with.
+type KubeProxyMode string
+
+const (
+	// IPTablesKubeProxyMode references the "iptables" kube-proxy mode.
+	IPTablesKubeProxyMode KubeProxyMode = "iptables"
+
+	// IPVSKubeProxyMode references the "ipvs" kube-proxy mode.
+	IPVSKubeProxyMode KubeProxyMode = "ipvs"
+
+	// DisabledKubeProxyMode disables kube-proxy, for CNIs replacing it.
+	DisabledKubeProxyMode KubeProxyMode = "disabled"
+)
+
+// CgroupVersion defines the cgroup version of the node OS.
+type CgroupVersion string
+
+const (
+	// CgroupV1 references the legacy (or hybrid) cgroup v1 hierarchy.
+	CgroupV1 CgroupVersion = "v1"
+
+	// CgroupV2 references the unified cgroup v2 hierarchy.
+	CgroupV2 CgroupVersion = "v2"
+)
+
 // Encoding specifies the cloud-init file encoding.
 type Encoding string
 
@@ -310,6 +491,46 @@ type SecretFileSource struct {
 	Key string `json:"key"`
 }
 
+// MachineIdentity defines the client certificate identifying a machine.
+type MachineIdentity struct {
+	// CASecretName is the name of the secret, in the namespace of the RKE2Config, holding the certificate authority
+	// signing the machine certificates in its tls.crt and tls.key keys.
+	CASecretName string `json:"caSecretName"`
+
+	// TrustDomain is the SPIFFE trust domain of the machines, the certificate of a machine has the
+	// spiffe://<trustDomain>/ns/<namespace>/machine/<machine name> URI SAN and the machine name as common name.
+	// +kubebuilder:validation:Pattern=`^[a-z0-9._-]+$`
+	TrustDomain string `json:"trustDomain"`
+
+	// Directory is the directory on the node where the certificate, its key and the certificate authority are written
+	// as tls.crt, tls.key and ca.crt (default: "/etc/rancher/machine-identity").
+	//+optional
+	Directory string `json:"directory,omitempty"`
+
+	// Validity is the validity of the certificate, it isn't renewed and the machine must be replaced before it
+	// expires (default: "8760h").
+	//+optional
+	Validity *metav1.Duration `json:"validity,omitempty"`
+}
+
+// Attestation defines the attestation data written to a node.
+type Attestation struct {
+	// SecretName is the name of the secret, in the namespace of the RKE2Config, holding the attestation data. Like the
+	// other secrets of the configuration, its data is only written to the bootstrap data secret, and never to the
+	// RKE2Config, the logs, the events or the conditions.
+	SecretName string `json:"secretName"`
+
+	// Items are the keys of the secret written to the node, with their paths relative to the directory and their
+	// modes (default: 0600). All the keys of the secret are written to files named after them when empty.
+	//+optional
+	Items []corev1.KeyToPath `json:"items,omitempty"`
+
+	// Directory is the directory on the node where the attestation data is written
+	// (default: "/etc/rancher/attestation").
+	//+optional
+	Directory string `json:"directory,omitempty"`
+}
+
 // Registry is registry settings including mirrors, TLS, and credentials.
 type Registry struct {
 	// Mirrors are namespace to mirror mapping for all namespaces.
@@ -322,6 +543,60 @@ type Registry struct {
 	Configs map[string]RegistryConfig `json:"configs,omitempty"`
 }
 
+// Declares returns true if the registry has a mirror or a configuration, or if no private registry is configured at all.
+func (r Registry) Declares(registry string) bool {
+	if len(r.Mirrors) == 0 && len(r.Configs) == 0 {
+		return true
+	}
+
+	if _, ok := r.Mirrors[registry]; ok {
+		return true
+	}
+
+	if _, ok := r.Mirrors["*"]; ok {
+		return true
+	}
+
+	_, ok := r.Configs[registry]
+
+	return ok
+}
+
+// Mirrored returns true if the registry has a mirror, explicitly or through the "*" wildcard.
+func (r Registry) Mirrored(registry string) bool {
+	if _, ok := r.Mirrors[registry]; ok {
+		return true
+	}
+
+	_, ok := r.Mirrors["*"]
+
+	return ok
+}
+
+// SecretRefs returns the references of the secrets of the registry configurations, ordered by registry.
+func (r Registry) SecretRefs() []corev1.ObjectReference {
+	registries := make([]string, 0, len(r.Configs))
+	for registry := range r.Configs {
+		registries = append(registries, registry)
+	}
+
+	sort.Strings(registries)
+
+	refs := []corev1.ObjectReference{}
+
+	for _, registry := range registries {
+		config := r.Configs[registry]
+
+		for _, ref := range []corev1.ObjectReference{config.AuthSecret, config.TLS.TLSConfigSecret} {
+			if ref.Name != "" {
+				refs = append(refs, ref)
+			}
+		}
+	}
+
+	return refs
+}
+
 // Mirror contains the config related to the registry mirror.
 type Mirror struct {
 	// Endpoints are endpoints for a namespace. CRI plugin will try the endpoints
@@ -18,11 +18,17 @@ package v1alpha1
 
 import (
 	"fmt"
+	"net"
+	"path"
+	"regexp"
+	"strings"
 
 	clct "github.com/flatcar/container-linux-config-transpiler/config"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/apimachinery/pkg/util/version"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
 )
@@ -86,10 +92,169 @@ func (s *RKE2ConfigSpec) validate(pathPrefix *field.Path) field.ErrorList {
 	var allErrs field.ErrorList
 
 	allErrs = append(allErrs, s.validateIgnition(pathPrefix)...)
+	allErrs = append(allErrs, s.validateCgroup(pathPrefix)...)
+	allErrs = append(allErrs, s.validateRegistries(pathPrefix)...)
+	allErrs = append(allErrs, s.validateKubeProxy(pathPrefix)...)
+	allErrs = append(allErrs, s.validateMachineIdentity(pathPrefix)...)
+	allErrs = append(allErrs, s.validateAttestation(pathPrefix)...)
+	allErrs = append(allErrs, s.validateAirGapped(pathPrefix)...)
+	allErrs = append(allErrs, s.validateNodePreparation(pathPrefix)...)
+	allErrs = append(allErrs, s.validateHostAliases(pathPrefix)...)
+
+	if s.JoinTokenTTL != nil && s.JoinTokenTTL.Duration <= 0 {
+		allErrs = append(allErrs, field.Invalid(pathPrefix.Child("joinTokenTTL"), s.JoinTokenTTL.String(),
+			"must be greater than 0"))
+	}
+
+	// The bootstrap data and its format are always stored under these keys.
+	if dataSecret := s.AgentConfig.DataSecret; dataSecret != nil && (dataSecret.Key == "value" || dataSecret.Key == "format") {
+		allErrs = append(allErrs, field.Invalid(pathPrefix.Child("agentConfig", "dataSecret", "key"), dataSecret.Key,
+			"must not be value or format, which are reserved"))
+	}
+
+	return allErrs
+}
+
+var (
+	// sysctlKeyRegexp matches the kernel parameters, in the dotted or slashed notation of sysctl.
+	sysctlKeyRegexp = regexp.MustCompile(`^[a-zA-Z0-9_-]+([./][a-zA-Z0-9_*-]+)+$`)
+
+	// kernelModuleRegexp matches the names of the kernel modules.
+	kernelModuleRegexp = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+)
+
+// validateNodePreparation validates that the kernel parameters and modules can be written to the sysctl.d and
+// modules-load.d configuration files.
+func (s *RKE2ConfigSpec) validateNodePreparation(pathPrefix *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+
+	nodePreparation := s.AgentConfig.NodePreparation
+	if nodePreparation == nil {
+		return allErrs
+	}
+
+	nodePreparationPath := pathPrefix.Child("agentConfig", "nodePreparation")
+
+	for key, value := range nodePreparation.Sysctls {
+		if !sysctlKeyRegexp.MatchString(key) {
+			allErrs = append(allErrs, field.Invalid(nodePreparationPath.Child("sysctls").Key(key), key,
+				"must be a kernel parameter, e.g. net.ipv4.ip_forward"))
+		}
+
+		if value == "" || strings.ContainsAny(value, "\n\r") {
+			allErrs = append(allErrs, field.Invalid(nodePreparationPath.Child("sysctls").Key(key), value,
+				"must be a non empty single line value"))
+		}
+	}
+
+	for i, module := range nodePreparation.KernelModules {
+		if !kernelModuleRegexp.MatchString(module) {
+			allErrs = append(allErrs, field.Invalid(nodePreparationPath.Child("kernelModules").Index(i), module,
+				"must be the name of a kernel module, e.g. br_netfilter"))
+		}
+	}
+
+	return allErrs
+}
+
+// validateHostAliases validates that the host aliases can be written to /etc/hosts: each alias maps an IP address to
+// at least one host name.
+func (s *RKE2ConfigSpec) validateHostAliases(pathPrefix *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+
+	for i, hostAlias := range s.AgentConfig.HostAliases {
+		hostAliasPath := pathPrefix.Child("agentConfig", "hostAliases").Index(i)
+
+		if net.ParseIP(hostAlias.IP) == nil {
+			allErrs = append(allErrs, field.Invalid(hostAliasPath.Child("ip"), hostAlias.IP, "must be an IP address"))
+		}
+
+		if len(hostAlias.Hostnames) == 0 {
+			allErrs = append(allErrs, field.Required(hostAliasPath.Child("hostnames"), "at least one host name is required"))
+		}
+
+		for j, hostname := range hostAlias.Hostnames {
+			for _, msg := range validation.IsDNS1123Subdomain(hostname) {
+				allErrs = append(allErrs, field.Invalid(hostAliasPath.Child("hostnames").Index(j), hostname, msg))
+			}
+		}
+	}
 
 	return allErrs
 }
 
+// validateAirGapped validates that the settings of air-gapped nodes don't require online resources: the version must
+// be an exact release, as the nodes install the artifacts of their image and can't resolve a channel, the system
+// images must be pulled from a mirror, and the image overrides from private registries.
+func (s *RKE2ConfigSpec) validateAirGapped(pathPrefix *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+
+	if !s.AgentConfig.AirGapped {
+		return allErrs
+	}
+
+	agentConfigPath := pathPrefix.Child("agentConfig")
+
+	if s.AgentConfig.Version != "" && !IsRKE2Release(s.AgentConfig.Version) {
+		allErrs = append(
+			allErrs,
+			field.Invalid(
+				agentConfigPath.Child("version"),
+				s.AgentConfig.Version,
+				"must be an exact RKE2 release, e.g. v1.26.4+rke2r1, when airGapped is true: air-gapped nodes can't resolve a channel",
+			),
+		)
+	}
+
+	if registry := s.AgentConfig.SystemDefaultRegistry; registry != "" && !s.PrivateRegistriesConfig.Mirrored(registry) {
+		allErrs = append(
+			allErrs,
+			field.Invalid(
+				agentConfigPath.Child("systemDefaultRegistry"),
+				registry,
+				"must have a mirror in privateRegistriesConfig when airGapped is true: air-gapped nodes pull the system images from it",
+			),
+		)
+	}
+
+	if err := ValidateAirGappedImage(agentConfigPath.Child("runtimeImage"), s.AgentConfig.RuntimeImage, s); err != nil {
+		allErrs = append(allErrs, err)
+	}
+
+	if s.AgentConfig.KubeProxy != nil {
+		if err := ValidateAirGappedImage(agentConfigPath.Child("kubeProxy", "overrideImage"),
+			s.AgentConfig.KubeProxy.OverrideImage, s); err != nil {
+			allErrs = append(allErrs, err)
+		}
+	}
+
+	return allErrs
+}
+
+// ValidateAirGappedImage validates that an image override of air-gapped nodes is pulled from a private registry, as
+// the online registries aren't reachable: the registry of the image must have a mirror or a configuration.
+func ValidateAirGappedImage(fldPath *field.Path, image string, spec *RKE2ConfigSpec) *field.Error {
+	if image == "" || !spec.AgentConfig.AirGapped {
+		return nil
+	}
+
+	registry := ImageRegistry(image)
+	if _, ok := spec.PrivateRegistriesConfig.Configs[registry]; ok || spec.PrivateRegistriesConfig.Mirrored(registry) {
+		return nil
+	}
+
+	return field.Invalid(fldPath, image, fmt.Sprintf(
+		"air-gapped nodes can't pull images from online registries, registry %q must be declared in privateRegistriesConfig", registry))
+}
+
+// IsRKE2Release returns whether a version is an exact RKE2 release, e.g. v1.26.4+rke2r1, rather than a channel or a
+// partial version.
+func IsRKE2Release(rke2Version string) bool {
+	parsed, err := version.ParseSemantic(rke2Version)
+
+	return err == nil && strings.HasPrefix(rke2Version, "v") && strings.HasPrefix(parsed.BuildMetadata(), "rke2r")
+}
+
 func (s *RKE2ConfigSpec) validateIgnition(pathPrefix *field.Path) field.ErrorList {
 	var allErrs field.ErrorList
 
@@ -121,3 +286,231 @@ func (s *RKE2ConfigSpec) validateIgnition(pathPrefix *field.Path) field.ErrorLis
 
 	return allErrs
 }
+
+func (s *RKE2ConfigSpec) validateCgroup(pathPrefix *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+
+	if s.AgentConfig.CgroupDriver == "" {
+		return allErrs
+	}
+
+	if s.AgentConfig.CgroupVersion == "" {
+		allErrs = append(
+			allErrs,
+			field.Required(
+				pathPrefix.Child("agentConfig", "cgroupVersion"),
+				"must be specified when cgroupDriver is set",
+			),
+		)
+
+		return allErrs
+	}
+
+	// On cgroup v2 hosts, containerd and the kubelet are expected to delegate cgroup management to systemd,
+	// using cgroupfs makes the kubelet crash-loop on a driver mismatch.
+	if s.AgentConfig.CgroupDriver == CgroupfsCgroupDriver && s.AgentConfig.CgroupVersion == CgroupV2 {
+		allErrs = append(
+			allErrs,
+			field.Invalid(
+				pathPrefix.Child("agentConfig", "cgroupDriver"),
+				s.AgentConfig.CgroupDriver,
+				fmt.Sprintf("not supported when cgroupVersion is %q, use %q instead", CgroupV2, SystemdCgroupDriver),
+			),
+		)
+	}
+
+	return allErrs
+}
+
+// validateKubeProxy validates that the kube-proxy mode isn't overridden by the kube-proxy arguments, and that
+// kube-proxy isn't configured when it is disabled.
+func (s *RKE2ConfigSpec) validateKubeProxy(pathPrefix *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+
+	if s.AgentConfig.KubeProxyMode == "" || s.AgentConfig.KubeProxy == nil {
+		return allErrs
+	}
+
+	kubeProxyPath := pathPrefix.Child("agentConfig", "kubeProxy")
+
+	if s.AgentConfig.KubeProxyMode == DisabledKubeProxyMode {
+		allErrs = append(
+			allErrs,
+			field.Forbidden(kubeProxyPath, fmt.Sprintf("can't be set when kubeProxyMode is %q", DisabledKubeProxyMode)),
+		)
+
+		return allErrs
+	}
+
+	for i, arg := range s.AgentConfig.KubeProxy.ExtraArgs {
+		if strings.HasPrefix(arg, "proxy-mode=") {
+			allErrs = append(
+				allErrs,
+				field.Forbidden(
+					kubeProxyPath.Child("extraArgs").Index(i),
+					"the proxy mode must be set in agentConfig.kubeProxyMode",
+				),
+			)
+		}
+	}
+
+	return allErrs
+}
+
+func (s *RKE2ConfigSpec) validateMachineIdentity(pathPrefix *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+
+	identity := s.MachineIdentity
+	if identity == nil {
+		return allErrs
+	}
+
+	identityPath := pathPrefix.Child("machineIdentity")
+
+	if identity.CASecretName == "" {
+		allErrs = append(allErrs, field.Required(identityPath.Child("caSecretName"), "must be specified"))
+	}
+
+	if identity.TrustDomain == "" {
+		allErrs = append(allErrs, field.Required(identityPath.Child("trustDomain"), "must be specified"))
+	}
+
+	if identity.Directory != "" && !path.IsAbs(identity.Directory) {
+		allErrs = append(
+			allErrs,
+			field.Invalid(identityPath.Child("directory"), identity.Directory, "must be an absolute path"),
+		)
+	}
+
+	if identity.Validity != nil && identity.Validity.Duration <= 0 {
+		allErrs = append(
+			allErrs,
+			field.Invalid(identityPath.Child("validity"), identity.Validity.String(), "must be greater than 0"),
+		)
+	}
+
+	return allErrs
+}
+
+func (s *RKE2ConfigSpec) validateAttestation(pathPrefix *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+
+	attestation := s.Attestation
+	if attestation == nil {
+		return allErrs
+	}
+
+	attestationPath := pathPrefix.Child("attestation")
+
+	if attestation.SecretName == "" {
+		allErrs = append(allErrs, field.Required(attestationPath.Child("secretName"), "must be specified"))
+	}
+
+	if attestation.Directory != "" && !path.IsAbs(attestation.Directory) {
+		allErrs = append(
+			allErrs,
+			field.Invalid(attestationPath.Child("directory"), attestation.Directory, "must be an absolute path"),
+		)
+	}
+
+	paths := map[string]bool{}
+
+	for i, item := range attestation.Items {
+		itemPath := attestationPath.Child("items").Index(i)
+
+		if item.Key == "" {
+			allErrs = append(allErrs, field.Required(itemPath.Child("key"), "must be specified"))
+		}
+
+		itemFile := item.Path
+		if itemFile == "" {
+			itemFile = item.Key
+		}
+
+		itemFile = path.Clean(itemFile)
+		if path.IsAbs(itemFile) || itemFile == ".." || strings.HasPrefix(itemFile, "../") {
+			allErrs = append(allErrs, field.Invalid(itemPath.Child("path"), item.Path,
+				"must be a path relative to the directory, within it"))
+		}
+
+		if paths[itemFile] {
+			allErrs = append(allErrs, field.Duplicate(itemPath.Child("path"), itemFile))
+		}
+
+		paths[itemFile] = true
+
+		if item.Mode != nil && (*item.Mode < 0 || *item.Mode > 0o777) {
+			allErrs = append(allErrs, field.Invalid(itemPath.Child("mode"), *item.Mode,
+				"must be between 0 and 0777 (511)"))
+		}
+	}
+
+	return allErrs
+}
+
+func (s *RKE2ConfigSpec) validateRegistries(pathPrefix *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+
+	agentConfigPath := pathPrefix.Child("agentConfig")
+
+	if registry := s.AgentConfig.SystemDefaultRegistry; registry != "" {
+		if strings.Contains(registry, "/") {
+			allErrs = append(
+				allErrs,
+				field.Invalid(
+					agentConfigPath.Child("systemDefaultRegistry"),
+					registry,
+					"must be a registry host, optionally with a port, without scheme or path",
+				),
+			)
+		} else if !s.PrivateRegistriesConfig.Declares(registry) {
+			allErrs = append(
+				allErrs,
+				field.Invalid(
+					agentConfigPath.Child("systemDefaultRegistry"),
+					registry,
+					"must be declared in privateRegistriesConfig mirrors or configs",
+				),
+			)
+		}
+	}
+
+	if err := ValidateImageRegistry(agentConfigPath.Child("runtimeImage"), s.AgentConfig.RuntimeImage, s.PrivateRegistriesConfig); err != nil {
+		allErrs = append(allErrs, err)
+	}
+
+	if s.AgentConfig.KubeProxy != nil {
+		if err := ValidateImageRegistry(agentConfigPath.Child("kubeProxy", "overrideImage"),
+			s.AgentConfig.KubeProxy.OverrideImage, s.PrivateRegistriesConfig); err != nil {
+			allErrs = append(allErrs, err)
+		}
+	}
+
+	return allErrs
+}
+
+// ValidateImageRegistry validates that the registry of an image override is declared in the registries configuration,
+// when the nodes are configured to use private registries.
+func ValidateImageRegistry(fldPath *field.Path, image string, registries Registry) *field.Error {
+	if image == "" {
+		return nil
+	}
+
+	if registry := ImageRegistry(image); !registries.Declares(registry) {
+		return field.Invalid(fldPath, image,
+			fmt.Sprintf("registry %q must be declared in privateRegistriesConfig mirrors or configs", registry))
+	}
+
+	return nil
+}
+
+// ImageRegistry returns the registry host of an image reference, following the docker conventions: the first
+// component of the reference is a registry if it contains a dot, a port, or is localhost.
+func ImageRegistry(image string) string {
+	component, _, found := strings.Cut(image, "/")
+	if !found || (!strings.ContainsAny(component, ".:") && component != "localhost") {
+		return "docker.io"
+	}
+
+	return component
+}
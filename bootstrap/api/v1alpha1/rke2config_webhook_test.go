@@ -0,0 +1,81 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+var _ = Describe("RKE2Config air-gapped validation", func() {
+	var spec *RKE2ConfigSpec
+
+	BeforeEach(func() {
+		spec = &RKE2ConfigSpec{
+			AgentConfig: RKE2AgentConfig{
+				AirGapped: true,
+				Version:   "v1.26.4+rke2r1",
+			},
+		}
+	})
+
+	It("should reject a channel or a partial version", func() {
+		Expect(spec.validateAirGapped(field.NewPath("spec"))).To(BeEmpty())
+
+		spec.AgentConfig.Version = "v1.26"
+		Expect(spec.validateAirGapped(field.NewPath("spec"))).To(HaveLen(1))
+	})
+
+	It("should require a mirror for the system default registry", func() {
+		spec.AgentConfig.SystemDefaultRegistry = "registry.example.com"
+		spec.PrivateRegistriesConfig.Configs = map[string]RegistryConfig{"registry.example.com": {}}
+		Expect(spec.validateAirGapped(field.NewPath("spec"))).To(HaveLen(1))
+
+		spec.PrivateRegistriesConfig.Mirrors = map[string]Mirror{"registry.example.com": {}}
+		Expect(spec.validateAirGapped(field.NewPath("spec"))).To(BeEmpty())
+
+		spec.PrivateRegistriesConfig.Mirrors = map[string]Mirror{"*": {}}
+		Expect(spec.validateAirGapped(field.NewPath("spec"))).To(BeEmpty())
+	})
+
+	It("should require the registry of an image override to be declared", func() {
+		spec.AgentConfig.RuntimeImage = "registry.example.com/rancher/rke2-runtime:v1.26.4-rke2r1"
+		spec.PrivateRegistriesConfig.Mirrors = map[string]Mirror{"other.example.com": {}}
+		Expect(spec.validateAirGapped(field.NewPath("spec"))).To(HaveLen(1))
+
+		spec.PrivateRegistriesConfig.Configs = map[string]RegistryConfig{"registry.example.com": {}}
+		Expect(spec.validateAirGapped(field.NewPath("spec"))).To(BeEmpty())
+	})
+
+	It("should reject an image override pulled from docker.io without a mirror", func() {
+		spec.AgentConfig.RuntimeImage = "rancher/rke2-runtime:v1.26.4-rke2r1"
+		Expect(spec.validateAirGapped(field.NewPath("spec"))).To(HaveLen(1))
+
+		spec.PrivateRegistriesConfig.Mirrors = map[string]Mirror{"docker.io": {}}
+		Expect(spec.validateAirGapped(field.NewPath("spec"))).To(BeEmpty())
+	})
+
+	It("should not validate the nodes that aren't air-gapped", func() {
+		spec.AgentConfig.AirGapped = false
+		spec.AgentConfig.Version = "v1.26"
+		spec.AgentConfig.SystemDefaultRegistry = "registry.example.com"
+		spec.AgentConfig.RuntimeImage = "rancher/rke2-runtime:v1.26.4-rke2r1"
+		Expect(spec.validateAirGapped(field.NewPath("spec"))).To(BeEmpty())
+	})
+})
@@ -22,7 +22,8 @@ limitations under the License.
 package v1alpha1
 
 import (
-	"k8s.io/api/core/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"sigs.k8s.io/cluster-api/api/v1beta1"
 )
@@ -42,6 +43,28 @@ func (in *AdditionalUserData) DeepCopy() *AdditionalUserData {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Attestation) DeepCopyInto(out *Attestation) {
+	*out = *in
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]corev1.KeyToPath, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Attestation.
+func (in *Attestation) DeepCopy() *Attestation {
+	if in == nil {
+		return nil
+	}
+	out := new(Attestation)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ComponentConfig) DeepCopyInto(out *ComponentConfig) {
 	*out = *in
@@ -76,6 +99,21 @@ func (in *ComponentConfig) DeepCopy() *ComponentConfig {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DataSecret) DeepCopyInto(out *DataSecret) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DataSecret.
+func (in *DataSecret) DeepCopy() *DataSecret {
+	if in == nil {
+		return nil
+	}
+	out := new(DataSecret)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *File) DeepCopyInto(out *File) {
 	*out = *in
@@ -112,6 +150,26 @@ func (in *FileSource) DeepCopy() *FileSource {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MachineIdentity) DeepCopyInto(out *MachineIdentity) {
+	*out = *in
+	if in.Validity != nil {
+		in, out := &in.Validity, &out.Validity
+		*out = new(v1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MachineIdentity.
+func (in *MachineIdentity) DeepCopy() *MachineIdentity {
+	if in == nil {
+		return nil
+	}
+	out := new(MachineIdentity)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Mirror) DeepCopyInto(out *Mirror) {
 	*out = *in
@@ -164,6 +222,33 @@ func (in *NTP) DeepCopy() *NTP {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodePreparation) DeepCopyInto(out *NodePreparation) {
+	*out = *in
+	if in.Sysctls != nil {
+		in, out := &in.Sysctls, &out.Sysctls
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.KernelModules != nil {
+		in, out := &in.KernelModules, &out.KernelModules
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodePreparation.
+func (in *NodePreparation) DeepCopy() *NodePreparation {
+	if in == nil {
+		return nil
+	}
+	out := new(NodePreparation)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *RKE2AgentConfig) DeepCopyInto(out *RKE2AgentConfig) {
 	*out = *in
@@ -182,16 +267,28 @@ func (in *RKE2AgentConfig) DeepCopyInto(out *RKE2AgentConfig) {
 		*out = new(NTP)
 		(*in).DeepCopyInto(*out)
 	}
+	if in.NodePreparation != nil {
+		in, out := &in.NodePreparation, &out.NodePreparation
+		*out = new(NodePreparation)
+		(*in).DeepCopyInto(*out)
+	}
 	if in.ImageCredentialProviderConfigMap != nil {
 		in, out := &in.ImageCredentialProviderConfigMap, &out.ImageCredentialProviderConfigMap
-		*out = new(v1.ObjectReference)
+		*out = new(corev1.ObjectReference)
 		**out = **in
 	}
 	if in.ResolvConf != nil {
 		in, out := &in.ResolvConf, &out.ResolvConf
-		*out = new(v1.ObjectReference)
+		*out = new(corev1.ObjectReference)
 		**out = **in
 	}
+	if in.HostAliases != nil {
+		in, out := &in.HostAliases, &out.HostAliases
+		*out = make([]corev1.HostAlias, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 	if in.Kubelet != nil {
 		in, out := &in.Kubelet, &out.Kubelet
 		*out = new(ComponentConfig)
@@ -202,6 +299,11 @@ func (in *RKE2AgentConfig) DeepCopyInto(out *RKE2AgentConfig) {
 		*out = new(ComponentConfig)
 		(*in).DeepCopyInto(*out)
 	}
+	if in.DataSecret != nil {
+		in, out := &in.DataSecret, &out.DataSecret
+		*out = new(DataSecret)
+		**out = **in
+	}
 	out.AdditionalUserData = in.AdditionalUserData
 }
 
@@ -296,6 +398,21 @@ func (in *RKE2ConfigSpec) DeepCopyInto(out *RKE2ConfigSpec) {
 	}
 	in.AgentConfig.DeepCopyInto(&out.AgentConfig)
 	in.PrivateRegistriesConfig.DeepCopyInto(&out.PrivateRegistriesConfig)
+	if in.MachineIdentity != nil {
+		in, out := &in.MachineIdentity, &out.MachineIdentity
+		*out = new(MachineIdentity)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Attestation != nil {
+		in, out := &in.Attestation, &out.Attestation
+		*out = new(Attestation)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.JoinTokenTTL != nil {
+		in, out := &in.JoinTokenTTL, &out.JoinTokenTTL
+		*out = new(v1.Duration)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RKE2ConfigSpec.
@@ -316,6 +433,10 @@ func (in *RKE2ConfigStatus) DeepCopyInto(out *RKE2ConfigStatus) {
 		*out = new(string)
 		**out = **in
 	}
+	if in.JoinTokenExpiration != nil {
+		in, out := &in.JoinTokenExpiration, &out.JoinTokenExpiration
+		*out = (*in).DeepCopy()
+	}
 	if in.Conditions != nil {
 		in, out := &in.Conditions, &out.Conditions
 		*out = make(v1beta1.Conditions, len(*in))
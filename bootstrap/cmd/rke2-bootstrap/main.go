@@ -0,0 +1,213 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Command rke2-bootstrap renders the bootstrap data the RKE2 bootstrap provider would generate for a machine, from
+// the RKE2Config and RKE2ControlPlane manifests, without a management cluster.
+//
+//	rke2-bootstrap render -f cluster.yaml --role init
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/pflag"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+
+	bootstrapv1 "github.com/rancher-sandbox/cluster-api-provider-rke2/bootstrap/api/v1alpha1"
+	"github.com/rancher-sandbox/cluster-api-provider-rke2/bootstrap/internal/controllers"
+	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+)
+
+const usage = `Usage: rke2-bootstrap render -f <file> [flags]
+
+Renders the bootstrap data of a machine from a YAML file containing an RKE2ControlPlane and/or an RKE2Config, with
+optionally the Cluster and the Secrets and ConfigMaps they reference.
+
+Flags:
+`
+
+var scheme = runtime.NewScheme()
+
+func init() {
+	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(bootstrapv1.AddToScheme(scheme))
+	utilruntime.Must(controlplanev1.AddToScheme(scheme))
+	utilruntime.Must(clusterv1.AddToScheme(scheme))
+}
+
+func main() {
+	if len(os.Args) < 2 || os.Args[1] != "render" {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	fs := pflag.NewFlagSet("render", pflag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		fs.PrintDefaults()
+	}
+
+	filename := fs.StringP("filename", "f", "", "The YAML file to render the bootstrap data from, - for the standard input.")
+	role := fs.String("role", string(controllers.InitControlPlaneRole),
+		"The role of the machine, one of init, join or worker.")
+	endpoint := fs.String("control-plane-endpoint", "<control-plane-endpoint>",
+		"The control plane endpoint host, used when the file doesn't contain a Cluster.")
+	serverIP := fs.String("server-ip", "<server-ip>", "The address of the control plane machine joining machines register with.")
+
+	_ = fs.Parse(os.Args[2:])
+
+	if *filename == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	if err := render(*filename, controllers.RenderRole(*role), *endpoint, *serverIP, os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func render(filename string, role controllers.RenderRole, endpoint, serverIP string, out io.Writer) error {
+	in := os.Stdin
+
+	if filename != "-" {
+		f, err := os.Open(filename) //nolint:gosec
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		in = f
+	}
+
+	opts, err := readObjects(in)
+	if err != nil {
+		return err
+	}
+
+	opts.Role = role
+	opts.ServerIP = serverIP
+
+	if opts.ControlPlane == nil {
+		if role != controllers.WorkerRole {
+			return errors.New("an RKE2ControlPlane is required to render the bootstrap data of a control plane machine")
+		}
+
+		opts.ControlPlane = &controlplanev1.RKE2ControlPlane{}
+	}
+
+	if opts.Config == nil {
+		if role == controllers.WorkerRole {
+			return errors.New("an RKE2Config is required to render the bootstrap data of a worker machine")
+		}
+
+		opts.Config = &bootstrapv1.RKE2Config{Spec: opts.ControlPlane.Spec.RKE2ConfigSpec}
+		opts.Config.Name = opts.ControlPlane.Name
+		opts.Config.Namespace = opts.ControlPlane.Namespace
+	}
+
+	if opts.Cluster == nil {
+		opts.Cluster = &clusterv1.Cluster{}
+		opts.Cluster.Name = opts.Config.Labels[clusterv1.ClusterNameLabel]
+		opts.Cluster.Spec.ControlPlaneEndpoint.Host = endpoint
+
+		if opts.Cluster.Name == "" {
+			opts.Cluster.Name = "cluster"
+		}
+	}
+
+	userData, err := controllers.RenderBootstrapData(context.Background(), *opts)
+	if err != nil {
+		return err
+	}
+
+	_, err = out.Write(userData)
+
+	return err
+}
+
+// readObjects decodes the objects of a multi document YAML stream.
+func readObjects(in io.Reader) (*controllers.RenderOptions, error) {
+	decoder := serializer.NewCodecFactory(scheme).UniversalDeserializer()
+	reader := utilyaml.NewYAMLReader(bufio.NewReader(in))
+	opts := &controllers.RenderOptions{}
+
+	for {
+		doc, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if len(bytes.TrimSpace(doc)) == 0 {
+			continue
+		}
+
+		obj, _, err := decoder.Decode(doc, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		switch o := obj.(type) {
+		case *bootstrapv1.RKE2Config:
+			if opts.Config != nil {
+				return nil, errors.New("only one RKE2Config is supported")
+			}
+
+			opts.Config = o
+		case *controlplanev1.RKE2ControlPlane:
+			if opts.ControlPlane != nil {
+				return nil, errors.New("only one RKE2ControlPlane is supported")
+			}
+
+			opts.ControlPlane = o
+		case *clusterv1.Cluster:
+			opts.Cluster = o
+		case *corev1.Secret:
+			// The string data is merged into the data by the API server.
+			for key, value := range o.StringData {
+				if o.Data == nil {
+					o.Data = map[string][]byte{}
+				}
+
+				o.Data[key] = []byte(value)
+			}
+
+			opts.Objects = append(opts.Objects, o)
+		case client.Object:
+			opts.Objects = append(opts.Objects, o)
+		}
+	}
+
+	return opts, nil
+}
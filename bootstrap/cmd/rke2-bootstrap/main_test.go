@@ -0,0 +1,121 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/rancher-sandbox/cluster-api-provider-rke2/bootstrap/internal/controllers"
+)
+
+const (
+	controlPlaneManifest = `apiVersion: controlplane.cluster.x-k8s.io/v1alpha1
+kind: RKE2ControlPlane
+metadata:
+  name: control-plane
+spec:
+  agentConfig:
+    version: v1.26.4+rke2r1
+`
+
+	workerManifest = `apiVersion: bootstrap.cluster.x-k8s.io/v1alpha1
+kind: RKE2Config
+metadata:
+  name: worker
+  labels:
+    cluster.x-k8s.io/cluster-name: my-cluster
+spec:
+  agentConfig:
+    version: v1.26.4+rke2r1
+`
+
+	tokenManifest = `apiVersion: v1
+kind: Secret
+metadata:
+  name: my-cluster-token
+stringData:
+  value: my-cluster-token-value
+`
+)
+
+var _ = Describe("render", func() {
+	var out *bytes.Buffer
+
+	BeforeEach(func() {
+		out = &bytes.Buffer{}
+	})
+
+	// write writes the manifests to a multi document YAML file.
+	write := func(manifests ...string) string {
+		filename := filepath.Join(GinkgoT().TempDir(), "cluster.yaml")
+		Expect(os.WriteFile(filename, []byte(joinDocuments(manifests)), 0o600)).To(Succeed())
+
+		return filename
+	}
+
+	It("should render the bootstrap data of the first control plane machine from the RKE2ControlPlane", func() {
+		Expect(render(write(controlPlaneManifest), controllers.InitControlPlaneRole, "cluster.example.com", "10.0.0.1", out)).
+			To(Succeed())
+		Expect(out.String()).To(ContainSubstring("INSTALL_RKE2_VERSION=v1.26.4+rke2r1 sh -s - server"))
+		Expect(out.String()).To(ContainSubstring("- cluster.example.com"))
+	})
+
+	It("should render the bootstrap data of a worker machine from its RKE2Config", func() {
+		Expect(render(write(controlPlaneManifest, workerManifest), controllers.WorkerRole, "", "10.0.0.1", out)).
+			To(Succeed())
+		Expect(out.String()).To(ContainSubstring(`INSTALL_RKE2_TYPE="agent"`))
+		Expect(out.String()).To(ContainSubstring("server: https://10.0.0.1:9345"))
+		Expect(out.String()).To(ContainSubstring("token: " + controllers.RenderedToken))
+	})
+
+	It("should use the string data of the secrets of the file", func() {
+		Expect(render(write(controlPlaneManifest, workerManifest, tokenManifest), controllers.WorkerRole, "", "10.0.0.1", out)).
+			To(Succeed())
+		Expect(out.String()).To(ContainSubstring("token: my-cluster-token-value"))
+	})
+
+	It("should require an RKE2Config to render the bootstrap data of a worker machine", func() {
+		Expect(render(write(controlPlaneManifest), controllers.WorkerRole, "", "10.0.0.1", out)).
+			To(MatchError(ContainSubstring("an RKE2Config is required")))
+		Expect(out.Len()).To(BeZero())
+	})
+
+	It("should require an RKE2ControlPlane to render the bootstrap data of a control plane machine", func() {
+		Expect(render(write(workerManifest), controllers.JoinControlPlaneRole, "", "10.0.0.1", out)).
+			To(MatchError(ContainSubstring("an RKE2ControlPlane is required")))
+	})
+
+	It("should reject several RKE2Configs", func() {
+		Expect(render(write(workerManifest, workerManifest), controllers.WorkerRole, "", "10.0.0.1", out)).
+			To(MatchError("only one RKE2Config is supported"))
+	})
+})
+
+func joinDocuments(manifests []string) string {
+	var b bytes.Buffer
+
+	for _, manifest := range manifests {
+		b.WriteString("---\n" + manifest)
+	}
+
+	return b.String()
+}
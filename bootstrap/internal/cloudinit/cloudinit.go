@@ -91,18 +91,21 @@ ntp:
 
 // BaseUserData is shared across all the various types of files written to disk.
 type BaseUserData struct {
-	Header              string
-	PreRKE2Commands     []string
-	DeployRKE2Commands  []string
-	PostRKE2Commands    []string
-	WriteFiles          []bootstrapv1.File
-	ConfigFile          bootstrapv1.File
-	RKE2Version         string
-	SentinelFileCommand string
-	AirGapped           bool
-	NTPServers          []string
-	CISEnabled          bool
-	AdditionalCloudInit string
+	Header                 string
+	PreRKE2Commands        []string
+	DeployRKE2Commands     []string
+	PostRKE2Commands       []string
+	WriteFiles             []bootstrapv1.File
+	ConfigFile             bootstrapv1.File
+	RKE2Version            string
+	SentinelFileCommand    string
+	AirGapped              bool
+	NTPServers             []string
+	CISEnabled             bool
+	NodePreparationEnabled bool
+	HostAliasesEnabled     bool
+	EtcdDiskSetupEnabled   bool
+	AdditionalCloudInit    string
 }
 
 func generate(kind string, tpl string, data interface{}) ([]byte, error) {
@@ -156,6 +156,27 @@ runcmd:
 	})
 })
 
+var _ = Describe("WorkerNodePreparationTest", func() {
+	var input *BaseUserData
+
+	BeforeEach(func() {
+		input = &BaseUserData{
+			AirGapped:              false,
+			NodePreparationEnabled: true,
+			PreRKE2Commands:        []string{"echo pre"},
+			RKE2Version:            "v1.25.6+rke2r1",
+		}
+	})
+	It("Should prepare the node after the pre commands", func() {
+		workerCloudInitData, err := NewJoinWorker(input)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(workerCloudInitData)).To(ContainSubstring(`runcmd:
+  - "echo pre"
+  - '/opt/rke2-node-preparation.sh'
+  - 'curl -sfL https://get.rke2.io`))
+	})
+})
+
 var _ = Describe("CleanupCloudInit test", func() {
 	cloudInitData := `## template: jinja
 #cloud-config
@@ -28,7 +28,13 @@ const (
 {{template "files" .WriteFiles}}
 {{template "ntp" .NTPServers}}
 runcmd:
+{{- if .HostAliasesEnabled }}
+  - '/opt/rke2-host-aliases.sh'{{ end }}
 {{- template "commands" .PreRKE2Commands }}
+{{- if .NodePreparationEnabled }}
+  - '/opt/rke2-node-preparation.sh'{{ end }}
+{{- if .EtcdDiskSetupEnabled }}
+  - '/opt/rke2-etcd-disk-setup.sh'{{ end }}
   - {{ if .AirGapped }}INSTALL_RKE2_ARTIFACT_PATH=/opt/rke2-artifacts sh /opt/install.sh{{ else }}'curl -sfL https://get.rke2.io | INSTALL_RKE2_VERSION=%[1]s sh -s - server'{{ end }} 
 {{- if .CISEnabled }}
   - '/opt/rke2-cis-script.sh'{{ end }}
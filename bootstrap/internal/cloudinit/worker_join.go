@@ -26,7 +26,11 @@ const (
 {{template "files" .WriteFiles}}
 {{template "ntp" .NTPServers}}
 runcmd:
+{{- if .HostAliasesEnabled }}
+  - '/opt/rke2-host-aliases.sh'{{ end }}
 {{- template "commands" .PreRKE2Commands }}
+{{- if .NodePreparationEnabled }}
+  - '/opt/rke2-node-preparation.sh'{{ end }}
   - '{{ if .AirGapped }}INSTALL_RKE2_ARTIFACT_PATH=/opt/rke2-artifacts INSTALL_RKE2_TYPE="agent" sh /opt/install.sh{{ else }}curl -sfL https://get.rke2.io | INSTALL_RKE2_VERSION=%[1]s INSTALL_RKE2_TYPE="agent" sh -s -{{end}}'
 {{- if .CISEnabled }}
   - '/opt/rke2-cis-script.sh'{{ end }}
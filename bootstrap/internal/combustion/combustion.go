@@ -0,0 +1,274 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package combustion generates Combustion scripts to bootstrap RKE2 on transactional-update hosts, like openSUSE MicroOS
+// and SLE Micro, by exposing an API similar to 'bootstrap/internal/ignition' package.
+package combustion
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+
+	bootstrapv1 "github.com/rancher-sandbox/cluster-api-provider-rke2/bootstrap/api/v1alpha1"
+	"github.com/rancher-sandbox/cluster-api-provider-rke2/bootstrap/internal/cloudinit"
+)
+
+const (
+	airGappedControlPlaneCommand = "INSTALL_RKE2_ARTIFACT_PATH=/opt/rke2-artifacts sh /opt/install.sh"
+	controlPlaneCommand          = "curl -sfL https://get.rke2.io | INSTALL_RKE2_VERSION=%[1]s sh -s - server"
+	airGappedWorkerCommand       = "INSTALL_RKE2_ARTIFACT_PATH=/opt/rke2-artifacts INSTALL_RKE2_TYPE=\"agent\" sh /opt/install.sh"
+	workerCommand                = "curl -sfL https://get.rke2.io | INSTALL_RKE2_VERSION=%[1]s INSTALL_RKE2_TYPE=\"agent\" sh -s -"
+
+	serverService = "rke2-server.service"
+	agentService  = "rke2-agent.service"
+)
+
+// The template is a Combustion script, executed once on first boot from the initrd, with the new root file system
+// mounted read-write. It writes the node files and installs the rke2-install.service unit, which runs the
+// /etc/rke2-install.sh script in two phases:
+//   - On first boot, it runs the pre-installation commands and installs RKE2. On transactional-update hosts the
+//     install script installs RKE2 into a new read-only snapshot, so the node reboots to apply it.
+//   - On the following boot, it starts RKE2 and runs the post-installation commands.
+//
+// The /var/lib/rke2-install directory keeps track of the phases, as /var is writable and shared across snapshots.
+const combustionTemplate = `#!/bin/bash
+# combustion: network
+set -e
+{{ range .WriteFiles }}
+mkdir -p "$(dirname '{{ .Path }}')"
+echo '{{ Base64 .Content }}' | base64 -d{{ Decoder .Encoding }} > '{{ .Path }}'
+{{- if ne .Owner "" }}
+chown '{{ .Owner }}' '{{ .Path }}'
+{{- end }}
+{{- if ne .Permissions "" }}
+chmod '{{ .Permissions }}' '{{ .Path }}'
+{{- end }}
+{{ end }}
+{{- if .NTPServers }}
+mkdir -p /etc/chrony.d
+cat > /etc/chrony.d/rke2-ntp.conf <<'EOF'
+{{- range .NTPServers }}
+server {{ . }} iburst
+{{- end }}
+EOF
+systemctl enable chronyd.service
+{{ end }}
+cat > /etc/rke2-install.sh <<'EOF'
+#!/bin/bash
+set -e
+
+if [ -f /var/lib/rke2-install/done ]; then
+  exit 0
+fi
+
+if [ ! -f /var/lib/rke2-install/installed ]; then
+{{- if .HostAliasesEnabled }}
+  /opt/rke2-host-aliases.sh
+{{- end }}
+{{- range .PreRKE2Commands }}
+  {{ . }}
+{{- end }}
+{{- if .NodePreparationEnabled }}
+  /opt/rke2-node-preparation.sh
+{{- end }}
+{{- if .EtcdDiskSetupEnabled }}
+  /opt/rke2-etcd-disk-setup.sh
+{{- end }}
+{{- if .CISEnabled }}
+  /opt/rke2-cis-script.sh
+{{- end }}
+{{- range .DeployRKE2Commands }}
+  {{ . }}
+{{- end }}
+  mkdir -p /var/lib/rke2-install
+  touch /var/lib/rke2-install/installed
+  # RKE2 has been installed into a new snapshot, it is only available after a reboot.
+  systemctl reboot
+  exit 0
+fi
+
+systemctl enable --now {{ .Service }}
+
+mkdir -p /run/cluster-api && echo success > /run/cluster-api/bootstrap-success.complete
+{{- range .PostRKE2Commands }}
+{{ . }}
+{{- end }}
+touch /var/lib/rke2-install/done
+EOF
+chmod 0700 /etc/rke2-install.sh
+
+cat > /etc/systemd/system/rke2-install.service <<'EOF'
+[Unit]
+Description=rke2-install
+Wants=network-online.target
+After=network-online.target
+[Service]
+# To not restart the unit when it exits, as it is expected.
+Type=oneshot
+ExecStart=/etc/rke2-install.sh
+[Install]
+WantedBy=multi-user.target
+EOF
+systemctl enable rke2-install.service
+{{- if .AdditionalScript }}
+
+{{ .AdditionalScript }}
+{{- end }}
+`
+
+// JoinWorkerInput defines the context to generate a node user data.
+type JoinWorkerInput struct {
+	*cloudinit.BaseUserData
+
+	AdditionalCombustion *bootstrapv1.AdditionalUserData
+}
+
+// ControlPlaneInput defines the context to generate a controlplane instance user data.
+type ControlPlaneInput struct {
+	*cloudinit.ControlPlaneInput
+
+	AdditionalCombustion *bootstrapv1.AdditionalUserData
+}
+
+type templateInput struct {
+	*cloudinit.BaseUserData
+
+	Service          string
+	AdditionalScript string
+}
+
+// NewJoinWorker returns a Combustion script for new worker node joining the cluster.
+func NewJoinWorker(input *JoinWorkerInput) ([]byte, error) {
+	if input == nil {
+		return nil, fmt.Errorf("input can't be nil")
+	}
+
+	if input.BaseUserData == nil {
+		return nil, fmt.Errorf("base userdata can't be nil")
+	}
+
+	deployRKE2Command, err := getRKE2Commands(input.BaseUserData, workerCommand, airGappedWorkerCommand)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get rke2 command: %w", err)
+	}
+
+	input.DeployRKE2Commands = deployRKE2Command
+	input.WriteFiles = append(input.WriteFiles, input.ConfigFile)
+
+	return render(input.BaseUserData, agentService, input.AdditionalCombustion)
+}
+
+// NewJoinControlPlane returns a Combustion script for new controlplane node joining the cluster.
+func NewJoinControlPlane(input *ControlPlaneInput) ([]byte, error) {
+	processedInput, err := controlPlaneConfigInput(input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to process controlplane input: %w", err)
+	}
+
+	return render(&processedInput.BaseUserData, serverService, processedInput.AdditionalCombustion)
+}
+
+// NewInitControlPlane returns a Combustion script for bootstrapping new cluster.
+func NewInitControlPlane(input *ControlPlaneInput) ([]byte, error) {
+	processedInput, err := controlPlaneConfigInput(input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to process controlplane input: %w", err)
+	}
+
+	return render(&processedInput.BaseUserData, serverService, processedInput.AdditionalCombustion)
+}
+
+func controlPlaneConfigInput(input *ControlPlaneInput) (*ControlPlaneInput, error) {
+	if input == nil {
+		return nil, fmt.Errorf("input can't be nil")
+	}
+
+	if input.ControlPlaneInput == nil {
+		return nil, fmt.Errorf("controlplane input can't be nil")
+	}
+
+	deployRKE2Command, err := getRKE2Commands(&input.BaseUserData, controlPlaneCommand, airGappedControlPlaneCommand)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get rke2 command: %w", err)
+	}
+
+	input.DeployRKE2Commands = deployRKE2Command
+	input.WriteFiles = append(input.WriteFiles, input.Certificates.AsFiles()...)
+	input.WriteFiles = append(input.WriteFiles, input.ConfigFile)
+
+	return input, nil
+}
+
+func getRKE2Commands(baseUserData *cloudinit.BaseUserData, command, airgappedCommand string) ([]string, error) {
+	if baseUserData == nil {
+		return nil, fmt.Errorf("base user data can't be nil")
+	}
+
+	if baseUserData.RKE2Version == "" {
+		return nil, fmt.Errorf("rke2 version can't be empty")
+	}
+
+	if baseUserData.AirGapped {
+		return []string{airgappedCommand}, nil
+	}
+
+	return []string{fmt.Sprintf(command, baseUserData.RKE2Version)}, nil
+}
+
+func render(input *cloudinit.BaseUserData, service string, additionalConfig *bootstrapv1.AdditionalUserData) ([]byte, error) {
+	data := templateInput{
+		BaseUserData: input,
+		Service:      service,
+	}
+
+	if additionalConfig != nil {
+		data.AdditionalScript = strings.TrimSpace(additionalConfig.Config)
+	}
+
+	t, err := template.New("combustion").Funcs(template.FuncMap{
+		"Base64":  func(content string) string { return base64.StdEncoding.EncodeToString([]byte(content)) },
+		"Decoder": decoder,
+	}).Parse(combustionTemplate)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse combustion template")
+	}
+
+	var out bytes.Buffer
+	if err := t.Execute(&out, data); err != nil {
+		return nil, errors.Wrap(err, "failed to render combustion template")
+	}
+
+	return out.Bytes(), nil
+}
+
+// decoder returns the commands to pipe the file contents through to decode them, according to their encoding.
+func decoder(encoding bootstrapv1.Encoding) string {
+	switch encoding {
+	case bootstrapv1.Base64:
+		return " | base64 -d"
+	case bootstrapv1.Gzip:
+		return " | gunzip"
+	case bootstrapv1.GzipBase64:
+		return " | base64 -d | gunzip"
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,123 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package combustion
+
+import (
+	"encoding/base64"
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	bootstrapv1 "github.com/rancher-sandbox/cluster-api-provider-rke2/bootstrap/api/v1alpha1"
+	"github.com/rancher-sandbox/cluster-api-provider-rke2/bootstrap/internal/cloudinit"
+)
+
+func TestCombustion(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Combustion Suite")
+}
+
+var _ = Describe("NewJoinWorker", func() {
+	var input *JoinWorkerInput
+
+	BeforeEach(func() {
+		input = &JoinWorkerInput{
+			BaseUserData: &cloudinit.BaseUserData{
+				RKE2Version:     "v1.21.3+rke2r1",
+				PreRKE2Commands: []string{"echo pre"},
+				WriteFiles: []bootstrapv1.File{
+					{
+						Path:        "/test/file",
+						Content:     "dGVzdA==",
+						Encoding:    bootstrapv1.Base64,
+						Owner:       "root:root",
+						Permissions: "0644",
+					},
+				},
+				ConfigFile: bootstrapv1.File{
+					Path:        "/test/config",
+					Content:     "test",
+					Permissions: "0644",
+				},
+				NTPServers: []string{"ntp.example.com"},
+			},
+			AdditionalCombustion: &bootstrapv1.AdditionalUserData{
+				Config: "echo additional",
+			},
+		}
+	})
+
+	It("should return a combustion script for worker", func() {
+		script, err := NewJoinWorker(input)
+		Expect(err).ToNot(HaveOccurred())
+
+		content := string(script)
+		Expect(content).To(HavePrefix("#!/bin/bash\n# combustion: network\n"))
+		Expect(content).To(ContainSubstring("echo '" + base64.StdEncoding.EncodeToString([]byte("dGVzdA==")) + "' | base64 -d | base64 -d > '/test/file'"))
+		Expect(content).To(ContainSubstring("chown 'root:root' '/test/file'"))
+		Expect(content).To(ContainSubstring("echo '" + base64.StdEncoding.EncodeToString([]byte("test")) + "' | base64 -d > '/test/config'"))
+		Expect(content).To(ContainSubstring("server ntp.example.com iburst"))
+		Expect(content).To(ContainSubstring("  echo pre\n"))
+		Expect(content).To(ContainSubstring(`INSTALL_RKE2_VERSION=v1.21.3+rke2r1 INSTALL_RKE2_TYPE="agent" sh -s -`))
+		Expect(content).To(ContainSubstring("systemctl reboot"))
+		Expect(content).To(ContainSubstring("systemctl enable --now rke2-agent.service"))
+		Expect(content).To(ContainSubstring("systemctl enable rke2-install.service"))
+		Expect(content).To(HaveSuffix("echo additional\n"))
+	})
+
+	It("should return error if input is nil", func() {
+		input = nil
+		script, err := NewJoinWorker(input)
+		Expect(err).To(HaveOccurred())
+		Expect(script).To(BeNil())
+	})
+
+	It("should return error if rke2 version is empty", func() {
+		input.RKE2Version = ""
+		script, err := NewJoinWorker(input)
+		Expect(err).To(HaveOccurred())
+		Expect(script).To(BeNil())
+	})
+})
+
+var _ = Describe("NewInitControlPlane", func() {
+	It("should return a combustion script starting the rke2 server", func() {
+		input := &ControlPlaneInput{
+			ControlPlaneInput: &cloudinit.ControlPlaneInput{
+				BaseUserData: cloudinit.BaseUserData{
+					RKE2Version: "v1.21.3+rke2r1",
+					AirGapped:   true,
+					CISEnabled:  true,
+					ConfigFile: bootstrapv1.File{
+						Path:        "/test/config",
+						Content:     "test",
+						Permissions: "0644",
+					},
+				},
+			},
+		}
+
+		script, err := NewInitControlPlane(input)
+		Expect(err).ToNot(HaveOccurred())
+
+		content := string(script)
+		Expect(content).To(ContainSubstring("/opt/rke2-cis-script.sh"))
+		Expect(content).To(ContainSubstring("INSTALL_RKE2_ARTIFACT_PATH=/opt/rke2-artifacts sh /opt/install.sh"))
+		Expect(content).To(ContainSubstring("systemctl enable --now rke2-server.service"))
+	})
+})
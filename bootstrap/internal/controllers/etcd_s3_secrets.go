@@ -0,0 +1,109 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apiserver/pkg/authentication/serviceaccount"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util/conditions"
+
+	bootstrapv1 "github.com/rancher-sandbox/cluster-api-provider-rke2/bootstrap/api/v1alpha1"
+)
+
+// checkEtcdS3Secrets checks that the secrets of the etcd S3 backups can be copied into the bootstrap data of the
+// control plane machines. A secret in another namespace than the cluster's is only used if this namespace is in the
+// allow-list of the controller, and the service accounts of the cluster namespace are granted the permission to get
+// the secret. This lets platform teams share the S3 credentials from a central namespace with a RoleBinding for the
+// "system:serviceaccounts:<cluster namespace>" group, instead of duplicating them into every cluster namespace.
+func (r *RKE2ConfigReconciler) checkEtcdS3Secrets(ctx context.Context, scope *Scope) error {
+	s3 := scope.ControlPlane.Spec.ServerConfig.Etcd.BackupConfig.S3
+	if s3 == nil {
+		return nil
+	}
+
+	refs := []corev1.ObjectReference{}
+	if !s3.IAMAuthentication {
+		refs = append(refs, s3.S3CredentialSecret)
+	}
+
+	if s3.EndpointCASecret != nil {
+		refs = append(refs, *s3.EndpointCASecret)
+	}
+
+	for _, ref := range refs {
+		if err := r.checkEtcdS3Secret(ctx, scope.Cluster.Namespace, ref); err != nil {
+			conditions.MarkFalse(scope.Config, bootstrapv1.DataSecretAvailableCondition,
+				bootstrapv1.DataSecretGenerationFailedReason, clusterv1.ConditionSeverityWarning, err.Error())
+
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *RKE2ConfigReconciler) checkEtcdS3Secret(ctx context.Context, namespace string, ref corev1.ObjectReference) error {
+	if ref.Namespace == "" || ref.Namespace == namespace {
+		return nil
+	}
+
+	if !r.isEtcdS3SecretNamespaceAllowed(ref.Namespace) {
+		return fmt.Errorf("etcd S3 secrets can't be referenced from namespace %q, it is not allowed by the controller",
+			ref.Namespace)
+	}
+
+	sar := &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			User:   serviceaccount.MakeUsername(namespace, "default"),
+			Groups: serviceaccount.MakeGroupNames(namespace),
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Namespace: ref.Namespace,
+				Verb:      "get",
+				Version:   "v1",
+				Resource:  "secrets",
+				Name:      ref.Name,
+			},
+		},
+	}
+
+	if err := r.Client.Create(ctx, sar); err != nil {
+		return errors.Wrap(err, "failed to review the access to the etcd S3 secret")
+	}
+
+	if !sar.Status.Allowed {
+		return fmt.Errorf("namespace %q is not allowed to use secret %s/%s", namespace, ref.Namespace, ref.Name)
+	}
+
+	return nil
+}
+
+func (r *RKE2ConfigReconciler) isEtcdS3SecretNamespaceAllowed(namespace string) bool {
+	for _, allowed := range r.AllowedEtcdS3SecretNamespaces {
+		if allowed == namespace {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,138 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util/conditions"
+
+	bootstrapv1 "github.com/rancher-sandbox/cluster-api-provider-rke2/bootstrap/api/v1alpha1"
+	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+)
+
+// accessReviewClient answers the subject access reviews with allowed, and records them.
+type accessReviewClient struct {
+	client.Client
+
+	allowed bool
+	reviews []authorizationv1.SubjectAccessReviewSpec
+}
+
+func (c *accessReviewClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	if sar, ok := obj.(*authorizationv1.SubjectAccessReview); ok {
+		c.reviews = append(c.reviews, sar.Spec)
+		sar.Status.Allowed = c.allowed
+
+		return nil
+	}
+
+	return c.Client.Create(ctx, obj, opts...)
+}
+
+var _ = Describe("etcd S3 secrets", func() {
+	var (
+		reviews *accessReviewClient
+		r       *RKE2ConfigReconciler
+		scope   *Scope
+		s3      *controlplanev1.EtcdS3
+	)
+
+	ctx := context.Background()
+
+	BeforeEach(func() {
+		env := newTestEnvironment()
+		reviews = &accessReviewClient{Client: env.Client, allowed: true}
+		r = env.Reconciler
+		r.Client = reviews
+		r.AllowedEtcdS3SecretNamespaces = []string{"backups"}
+
+		s3 = &controlplanev1.EtcdS3{
+			Endpoint:           "s3.example.com",
+			S3CredentialSecret: corev1.ObjectReference{Namespace: "backups", Name: "s3-credentials"},
+		}
+		env.ControlPlane.Spec.ServerConfig.Etcd.BackupConfig.S3 = s3
+
+		scope = &Scope{
+			Logger:               log.FromContext(ctx),
+			Config:               &bootstrapv1.RKE2Config{},
+			Cluster:              env.Cluster,
+			HasControlPlaneOwner: true,
+			ControlPlane:         env.ControlPlane,
+		}
+	})
+
+	It("should use the secrets of the namespace of the cluster without reviewing the access", func() {
+		s3.S3CredentialSecret.Namespace = ""
+		s3.EndpointCASecret = &corev1.ObjectReference{Namespace: scope.Cluster.Namespace, Name: "s3-ca"}
+
+		Expect(r.checkEtcdS3Secrets(ctx, scope)).To(Succeed())
+		Expect(reviews.reviews).To(BeEmpty())
+	})
+
+	It("should review the access of the service accounts of the cluster namespace to a secret of an allowed namespace", func() {
+		Expect(r.checkEtcdS3Secrets(ctx, scope)).To(Succeed())
+
+		Expect(reviews.reviews).To(HaveLen(1))
+		Expect(reviews.reviews[0].User).To(Equal("system:serviceaccount:default:default"))
+		Expect(reviews.reviews[0].Groups).To(ContainElement("system:serviceaccounts:default"))
+		Expect(*reviews.reviews[0].ResourceAttributes).To(Equal(authorizationv1.ResourceAttributes{
+			Namespace: "backups",
+			Verb:      "get",
+			Version:   "v1",
+			Resource:  "secrets",
+			Name:      "s3-credentials",
+		}))
+	})
+
+	It("should reject a secret the service accounts of the cluster namespace can't get", func() {
+		reviews.allowed = false
+
+		Expect(r.checkEtcdS3Secrets(ctx, scope)).To(MatchError(`namespace "default" is not allowed to use secret backups/s3-credentials`))
+		Expect(conditions.GetReason(scope.Config, bootstrapv1.DataSecretAvailableCondition)).
+			To(Equal(bootstrapv1.DataSecretGenerationFailedReason))
+		Expect(*conditions.GetSeverity(scope.Config, bootstrapv1.DataSecretAvailableCondition)).
+			To(Equal(clusterv1.ConditionSeverityWarning))
+	})
+
+	It("should reject a secret of a namespace the controller doesn't allow without reviewing the access", func() {
+		s3.S3CredentialSecret.Namespace = "other"
+
+		Expect(r.checkEtcdS3Secrets(ctx, scope)).To(MatchError(ContainSubstring(`namespace "other"`)))
+		Expect(conditions.IsFalse(scope.Config, bootstrapv1.DataSecretAvailableCondition)).To(BeTrue())
+		Expect(reviews.reviews).To(BeEmpty())
+	})
+
+	It("should only check the endpoint CA secret with IAM authentication", func() {
+		s3.IAMAuthentication = true
+		s3.S3CredentialSecret = corev1.ObjectReference{Namespace: "other", Name: "s3-credentials"}
+		s3.EndpointCASecret = &corev1.ObjectReference{Namespace: "backups", Name: "s3-ca"}
+
+		Expect(r.checkEtcdS3Secrets(ctx, scope)).To(Succeed())
+		Expect(reviews.reviews).To(HaveLen(1))
+		Expect(reviews.reviews[0].ResourceAttributes.Name).To(Equal("s3-ca"))
+	})
+})
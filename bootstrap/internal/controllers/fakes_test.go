@@ -0,0 +1,191 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util/conditions"
+
+	bootstrapv1 "github.com/rancher-sandbox/cluster-api-provider-rke2/bootstrap/api/v1alpha1"
+	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+)
+
+// fakeInitLock always grants the lock initializing the control plane.
+type fakeInitLock struct{}
+
+func (fakeInitLock) Lock(_ context.Context, _ *clusterv1.Cluster, _ *clusterv1.Machine) bool {
+	return true
+}
+
+func (fakeInitLock) Unlock(_ context.Context, _ *clusterv1.Cluster) bool {
+	return true
+}
+
+// fakeWorkloadClients returns the same client for all the workload clusters.
+type fakeWorkloadClients struct {
+	Client client.Client
+}
+
+func (f *fakeWorkloadClients) GetClient(_ context.Context, _ client.ObjectKey) (client.Client, error) {
+	return f.Client, nil
+}
+
+// testEnvironment is an initialized control plane of a cluster, whose worker RKE2Configs are reconciled by a
+// reconciler reading a fake client. The workload cluster is a fake client too.
+type testEnvironment struct {
+	Client         client.Client
+	WorkloadClient client.Client
+	Reconciler     *RKE2ConfigReconciler
+	Cluster        *clusterv1.Cluster
+	ControlPlane   *controlplanev1.RKE2ControlPlane
+}
+
+func newTestScheme() *runtime.Scheme {
+	scheme := runtime.NewScheme()
+
+	for _, addToScheme := range []func(*runtime.Scheme) error{
+		clientgoscheme.AddToScheme, clusterv1.AddToScheme, bootstrapv1.AddToScheme, controlplanev1.AddToScheme,
+	} {
+		Expect(addToScheme(scheme)).To(Succeed())
+	}
+
+	return scheme
+}
+
+// newTestEnvironment returns the environment of a cluster whose control plane is initialized and available at
+// 10.0.0.1.
+func newTestEnvironment(objs ...client.Object) *testEnvironment {
+	cluster := &clusterv1.Cluster{
+		ObjectMeta: metav1.ObjectMeta{Namespace: metav1.NamespaceDefault, Name: "cluster", UID: "cluster-uid"},
+		Spec: clusterv1.ClusterSpec{
+			ControlPlaneEndpoint: clusterv1.APIEndpoint{Host: "cluster.example.com", Port: 6443},
+			ControlPlaneRef: &corev1.ObjectReference{
+				APIVersion: controlplanev1.GroupVersion.String(),
+				Kind:       "RKE2ControlPlane",
+				Name:       "control-plane",
+			},
+		},
+		Status: clusterv1.ClusterStatus{InfrastructureReady: true},
+	}
+	conditions.MarkTrue(cluster, clusterv1.ControlPlaneInitializedCondition)
+
+	rcp := &controlplanev1.RKE2ControlPlane{
+		ObjectMeta: metav1.ObjectMeta{Namespace: metav1.NamespaceDefault, Name: "control-plane", UID: "control-plane-uid"},
+		Spec: controlplanev1.RKE2ControlPlaneSpec{
+			RKE2ConfigSpec: bootstrapv1.RKE2ConfigSpec{
+				AgentConfig: bootstrapv1.RKE2AgentConfig{Version: "v1.26.4+rke2r1"},
+			},
+		},
+		Status: controlplanev1.RKE2ControlPlaneStatus{
+			Initialized:        true,
+			Ready:              true,
+			AvailableServerIPs: []string{"10.0.0.1"},
+		},
+	}
+
+	token := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: metav1.NamespaceDefault, Name: "cluster-token"},
+		Data:       map[string][]byte{"value": []byte("token")},
+	}
+
+	cl := fake.NewClientBuilder().WithScheme(newTestScheme()).
+		WithObjects(append([]client.Object{cluster, rcp, token}, objs...)...).Build()
+	workloadClient := fake.NewClientBuilder().WithScheme(newTestScheme()).Build()
+
+	return &testEnvironment{
+		Client:         cl,
+		WorkloadClient: workloadClient,
+		Reconciler: &RKE2ConfigReconciler{
+			RKE2InitLock:    fakeInitLock{},
+			Client:          cl,
+			Scheme:          cl.Scheme(),
+			WorkloadClients: &fakeWorkloadClients{Client: workloadClient},
+		},
+		Cluster:      cluster,
+		ControlPlane: rcp,
+	}
+}
+
+// createWorker creates a worker machine of the test environment, and its RKE2Config of the given spec.
+func (env *testEnvironment) createWorker(name string, spec bootstrapv1.RKE2ConfigSpec) (*clusterv1.Machine, *bootstrapv1.RKE2Config) {
+	machine := &clusterv1.Machine{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: env.Cluster.Namespace,
+			Name:      name,
+			UID:       "machine-uid-" + types.UID(name),
+			Labels:    map[string]string{clusterv1.ClusterNameLabel: env.Cluster.Name},
+		},
+		Spec: clusterv1.MachineSpec{
+			ClusterName: env.Cluster.Name,
+			Bootstrap: clusterv1.Bootstrap{ConfigRef: &corev1.ObjectReference{
+				APIVersion: bootstrapv1.GroupVersion.String(),
+				Kind:       "RKE2Config",
+				Name:       name,
+			}},
+		},
+	}
+	Expect(env.Client.Create(context.Background(), machine)).To(Succeed())
+
+	config := &bootstrapv1.RKE2Config{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:       env.Cluster.Namespace,
+			Name:            name,
+			UID:             "config-uid-" + types.UID(name),
+			Generation:      1,
+			OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(machine, clusterv1.GroupVersion.WithKind("Machine"))},
+		},
+		Spec: spec,
+	}
+	Expect(env.Client.Create(context.Background(), config)).To(Succeed())
+
+	return machine, config
+}
+
+// reconcile reconciles the RKE2Config, and returns it once reconciled.
+func (env *testEnvironment) reconcile(config *bootstrapv1.RKE2Config) *bootstrapv1.RKE2Config {
+	_, err := env.Reconciler.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKeyFromObject(config)})
+	Expect(err).ToNot(HaveOccurred())
+
+	reconciled := &bootstrapv1.RKE2Config{}
+	Expect(env.Client.Get(context.Background(), client.ObjectKeyFromObject(config), reconciled)).To(Succeed())
+
+	return reconciled
+}
+
+// bootstrapData returns the bootstrap data generated for the RKE2Config.
+func (env *testEnvironment) bootstrapData(config *bootstrapv1.RKE2Config) string {
+	Expect(config.Status.DataSecretName).ToNot(BeNil())
+
+	secret := &corev1.Secret{}
+	Expect(env.Client.Get(context.Background(),
+		client.ObjectKey{Namespace: config.Namespace, Name: *config.Status.DataSecretName}, secret)).To(Succeed())
+
+	return string(secret.Data["value"])
+}
@@ -0,0 +1,101 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	"sigs.k8s.io/cluster-api/util"
+
+	"github.com/rancher-sandbox/cluster-api-provider-rke2/pkg/rke2"
+)
+
+// rke2ConfigControllerName is the name of the controller used when creating clients of the workload clusters.
+const rke2ConfigControllerName = "rke2-config-controller"
+
+// createJoinToken creates a bootstrap token in the workload cluster for the worker machine to join the cluster with,
+// expiring after the TTL of the config. The token previously created for the machine, if any, is deleted, as it is
+// replaced by the new bootstrap data.
+func (r *RKE2ConfigReconciler) createJoinToken(ctx context.Context, scope *Scope) (string, error) {
+	workloadClient, err := r.WorkloadClients.GetClient(ctx, util.ObjectKey(scope.Cluster))
+	if err != nil {
+		return "", err
+	}
+
+	if scope.Config.Status.JoinTokenID != "" {
+		if err := rke2.DeleteJoinToken(ctx, workloadClient, scope.Config.Status.JoinTokenID); err != nil {
+			return "", err
+		}
+
+		scope.Config.Status.JoinTokenID = ""
+		scope.Config.Status.JoinTokenExpiration = nil
+	}
+
+	expiration := metav1.NewTime(time.Now().Add(scope.Config.Spec.JoinTokenTTL.Duration).Truncate(time.Second))
+
+	token, tokenID, tokenSecret, err := rke2.NewJoinToken(scope.Machine, expiration.Time)
+	if err != nil {
+		return "", err
+	}
+
+	if err := workloadClient.Create(ctx, tokenSecret); err != nil {
+		return "", errors.Wrap(err, "failed to create the bootstrap token of the machine")
+	}
+
+	scope.Config.Status.JoinTokenID = tokenID
+	scope.Config.Status.JoinTokenExpiration = &expiration
+
+	scope.Logger.Info("Created a bootstrap token for the machine to join the cluster with", "tokenID", tokenID,
+		"expiration", expiration)
+
+	return token, nil
+}
+
+// reconcileJoinToken deletes the bootstrap token the machine joins the cluster with once its node joined, or once the
+// token expired, requeuing until then.
+func (r *RKE2ConfigReconciler) reconcileJoinToken(ctx context.Context, scope *Scope) (ctrl.Result, error) {
+	tokenID := scope.Config.Status.JoinTokenID
+	if tokenID == "" {
+		return ctrl.Result{}, nil
+	}
+
+	expired := scope.Config.Status.JoinTokenExpiration == nil || !time.Now().Before(scope.Config.Status.JoinTokenExpiration.Time)
+	if scope.Machine.Status.NodeRef == nil && !expired {
+		return ctrl.Result{RequeueAfter: DefaultRequeueAfter}, nil
+	}
+
+	workloadClient, err := r.WorkloadClients.GetClient(ctx, util.ObjectKey(scope.Cluster))
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if err := rke2.DeleteJoinToken(ctx, workloadClient, tokenID); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	scope.Logger.Info("Deleted the bootstrap token the machine joined the cluster with", "tokenID", tokenID)
+
+	scope.Config.Status.JoinTokenID = ""
+	scope.Config.Status.JoinTokenExpiration = nil
+
+	return ctrl.Result{}, nil
+}
@@ -0,0 +1,121 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	bootstraputil "k8s.io/cluster-bootstrap/token/util"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+
+	bootstrapv1 "github.com/rancher-sandbox/cluster-api-provider-rke2/bootstrap/api/v1alpha1"
+)
+
+var _ = Describe("join tokens of the workers", func() {
+	var (
+		env     *testEnvironment
+		machine *clusterv1.Machine
+		config  *bootstrapv1.RKE2Config
+	)
+
+	ctx := context.Background()
+
+	// tokenExists returns whether the bootstrap token exists in the workload cluster.
+	tokenExists := func(tokenID string) bool {
+		err := env.WorkloadClient.Get(ctx, client.ObjectKey{
+			Namespace: metav1.NamespaceSystem,
+			Name:      bootstraputil.BootstrapTokenSecretName(tokenID),
+		}, &corev1.Secret{})
+		if apierrors.IsNotFound(err) {
+			return false
+		}
+
+		Expect(err).ToNot(HaveOccurred())
+
+		return true
+	}
+
+	BeforeEach(func() {
+		env = newTestEnvironment()
+		machine, config = env.createWorker("worker-0", bootstrapv1.RKE2ConfigSpec{
+			JoinTokenTTL: &metav1.Duration{Duration: time.Hour},
+		})
+
+		config = env.reconcile(config)
+		Expect(config.Status.Ready).To(BeTrue())
+		Expect(config.Status.JoinTokenID).ToNot(BeEmpty())
+	})
+
+	It("should join the worker with a bootstrap token of its own expiring after the TTL", func() {
+		Expect(tokenExists(config.Status.JoinTokenID)).To(BeTrue())
+		Expect(config.Status.JoinTokenExpiration.Time).To(BeTemporally("~", time.Now().Add(time.Hour), time.Minute))
+
+		data := env.bootstrapData(config)
+		Expect(data).To(ContainSubstring("token: " + config.Status.JoinTokenID + "."))
+		Expect(data).ToNot(ContainSubstring("token: token"))
+	})
+
+	It("should replace the token when the bootstrap data is generated again", func() {
+		previous := config.Status.JoinTokenID
+
+		config.Spec.PreRKE2Commands = []string{"echo updated"}
+		config.Generation = 2
+		Expect(env.Client.Update(ctx, config)).To(Succeed())
+
+		config = env.reconcile(config)
+		Expect(config.Status.JoinTokenID).ToNot(Equal(previous))
+		Expect(tokenExists(config.Status.JoinTokenID)).To(BeTrue())
+		Expect(tokenExists(previous)).To(BeFalse())
+	})
+
+	It("should keep the token until the node of the machine joined", func() {
+		tokenID := config.Status.JoinTokenID
+
+		config = env.reconcile(config)
+		Expect(config.Status.JoinTokenID).To(Equal(tokenID))
+		Expect(tokenExists(tokenID)).To(BeTrue())
+
+		machine.Status.NodeRef = &corev1.ObjectReference{Kind: "Node", Name: "worker-0"}
+		Expect(env.Client.Status().Update(ctx, machine)).To(Succeed())
+
+		config = env.reconcile(config)
+		Expect(config.Status.JoinTokenID).To(BeEmpty())
+		Expect(config.Status.JoinTokenExpiration).To(BeNil())
+		Expect(tokenExists(tokenID)).To(BeFalse())
+	})
+
+	It("should delete the expired token of a machine that didn't join", func() {
+		tokenID := config.Status.JoinTokenID
+
+		config.Status.JoinTokenExpiration = &metav1.Time{Time: time.Now().Add(-time.Minute)}
+		Expect(env.Client.Status().Update(ctx, config)).To(Succeed())
+
+		config = env.reconcile(config)
+		Expect(config.Status.JoinTokenID).To(BeEmpty())
+		Expect(tokenExists(tokenID)).To(BeFalse())
+		Expect(env.bootstrapData(config)).To(ContainSubstring("token: " + tokenID + "."))
+	})
+})
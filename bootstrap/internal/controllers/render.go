@@ -0,0 +1,218 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+
+	bootstrapv1 "github.com/rancher-sandbox/cluster-api-provider-rke2/bootstrap/api/v1alpha1"
+	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+	"github.com/rancher-sandbox/cluster-api-provider-rke2/pkg/rke2"
+	bsutil "github.com/rancher-sandbox/cluster-api-provider-rke2/pkg/util"
+)
+
+// RenderRole is the role of the machine the bootstrap data is rendered for.
+type RenderRole string
+
+const (
+	// InitControlPlaneRole renders the bootstrap data of the first control plane machine of the cluster.
+	InitControlPlaneRole RenderRole = "init"
+
+	// JoinControlPlaneRole renders the bootstrap data of a control plane machine joining the cluster.
+	JoinControlPlaneRole RenderRole = "join"
+
+	// WorkerRole renders the bootstrap data of a worker machine joining the cluster.
+	WorkerRole RenderRole = "worker"
+
+	// RenderedToken is the placeholder token used in rendered bootstrap data when the objects don't provide one.
+	RenderedToken = "<token>"
+)
+
+// RenderOptions are the inputs of RenderBootstrapData, Config, ControlPlane and Cluster are required.
+type RenderOptions struct {
+	// Role is the role of the machine.
+	Role RenderRole
+
+	// Config is the RKE2Config of the machine.
+	Config *bootstrapv1.RKE2Config
+
+	// ControlPlane is the RKE2ControlPlane of the cluster.
+	ControlPlane *controlplanev1.RKE2ControlPlane
+
+	// Cluster is the Cluster of the machine.
+	Cluster *clusterv1.Cluster
+
+	// Objects are the other objects referenced by the configuration, like Secrets and ConfigMaps.
+	Objects []client.Object
+
+	// ServerIP is the address of the control plane machine joining machines register with.
+	ServerIP string
+}
+
+// RenderBootstrapData generates the bootstrap data of a machine without a management cluster, the way the controller
+// would generate it. The objects are read from an in memory client, certificates and the token are generated when
+// they are not provided.
+func RenderBootstrapData(ctx context.Context, opts RenderOptions) ([]byte, error) {
+	scheme := runtime.NewScheme()
+
+	for _, addToScheme := range []func(*runtime.Scheme) error{
+		clientgoscheme.AddToScheme, clusterv1.AddToScheme, bootstrapv1.AddToScheme, controlplanev1.AddToScheme,
+	} {
+		if err := addToScheme(scheme); err != nil {
+			return nil, err
+		}
+	}
+
+	config := opts.Config.DeepCopy()
+	cluster := opts.Cluster.DeepCopy()
+	controlPlane := opts.ControlPlane.DeepCopy()
+
+	if config.Namespace == "" {
+		config.Namespace = metav1.NamespaceDefault
+	}
+
+	if cluster.Namespace == "" {
+		cluster.Namespace = config.Namespace
+	}
+
+	if controlPlane.Namespace == "" {
+		controlPlane.Namespace = cluster.Namespace
+	}
+
+	if len(controlPlane.Status.AvailableServerIPs) == 0 {
+		controlPlane.Status.AvailableServerIPs = []string{opts.ServerIP}
+	}
+
+	// The objects without a namespace are in the namespace of the config, as when they are applied.
+	objects := make([]client.Object, 0, len(opts.Objects))
+
+	for _, o := range opts.Objects {
+		obj, ok := o.DeepCopyObject().(client.Object)
+		if !ok {
+			return nil, fmt.Errorf("unsupported object %s", o.GetName())
+		}
+
+		if obj.GetNamespace() == "" {
+			obj.SetNamespace(config.Namespace)
+		}
+
+		objects = append(objects, obj)
+	}
+
+	cl := fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build()
+
+	if opts.Role != InitControlPlaneRole {
+		if err := createRenderedToken(ctx, cl, cluster); err != nil {
+			return nil, err
+		}
+	}
+
+	r := &RKE2ConfigReconciler{
+		Client:          cl,
+		Scheme:          scheme,
+		RKE2InitLock:    renderInitLock{},
+		WorkloadClients: &rke2.WorkloadClients{Client: cl, ControllerName: rke2ConfigControllerName},
+	}
+
+	scope := &Scope{
+		Logger: log.FromContext(ctx),
+		Config: config,
+		Machine: &clusterv1.Machine{
+			ObjectMeta: metav1.ObjectMeta{Name: config.Name, Namespace: config.Namespace},
+		},
+		Cluster:              cluster,
+		HasControlPlaneOwner: opts.Role != WorkerRole,
+		ControlPlane:         controlPlane,
+	}
+
+	var (
+		res ctrl.Result
+		err error
+	)
+
+	switch opts.Role {
+	case InitControlPlaneRole:
+		res, err = r.handleClusterNotInitialized(ctx, scope)
+	case JoinControlPlaneRole:
+		res, err = r.joinControlplane(ctx, scope)
+	case WorkerRole:
+		res, err = r.joinWorker(ctx, scope)
+	default:
+		return nil, fmt.Errorf("unknown role %q", opts.Role)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !scope.Config.Status.Ready {
+		return nil, fmt.Errorf("bootstrap data was not generated, requeued after %s", res.RequeueAfter)
+	}
+
+	dataSecret := &corev1.Secret{}
+	if err := cl.Get(ctx, types.NamespacedName{Namespace: config.Namespace, Name: *config.Status.DataSecretName}, dataSecret); err != nil {
+		return nil, errors.Wrap(err, "failed to get the bootstrap data secret")
+	}
+
+	return dataSecret.Data["value"], nil
+}
+
+// createRenderedToken creates the token secret of the cluster with a placeholder token, unless the objects already
+// provide it.
+func createRenderedToken(ctx context.Context, cl client.Client, cluster *clusterv1.Cluster) error {
+	tokenSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      bsutil.TokenName(cluster.Name),
+			Namespace: cluster.Namespace,
+		},
+		Data: map[string][]byte{
+			"value": []byte(RenderedToken),
+		},
+	}
+
+	if err := cl.Get(ctx, client.ObjectKeyFromObject(tokenSecret), &corev1.Secret{}); !apierrors.IsNotFound(err) {
+		return err
+	}
+
+	return cl.Create(ctx, tokenSecret)
+}
+
+// renderInitLock is the RKE2InitLock used to render bootstrap data, there is a single machine to initialize.
+type renderInitLock struct{}
+
+func (renderInitLock) Lock(_ context.Context, _ *clusterv1.Cluster, _ *clusterv1.Machine) bool {
+	return true
+}
+
+func (renderInitLock) Unlock(_ context.Context, _ *clusterv1.Cluster) bool {
+	return true
+}
@@ -0,0 +1,130 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+
+	bootstrapv1 "github.com/rancher-sandbox/cluster-api-provider-rke2/bootstrap/api/v1alpha1"
+	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+)
+
+var _ = Describe("RenderBootstrapData", func() {
+	var opts RenderOptions
+
+	BeforeEach(func() {
+		opts = RenderOptions{
+			Config: &bootstrapv1.RKE2Config{
+				ObjectMeta: metav1.ObjectMeta{Name: "server"},
+				Spec: bootstrapv1.RKE2ConfigSpec{
+					AgentConfig: bootstrapv1.RKE2AgentConfig{
+						Version:         "v1.26.4+rke2r1",
+						NodePreparation: &bootstrapv1.NodePreparation{DisableSwap: true},
+					},
+				},
+			},
+			ControlPlane: &controlplanev1.RKE2ControlPlane{ObjectMeta: metav1.ObjectMeta{Name: "control-plane"}},
+			Cluster: &clusterv1.Cluster{
+				ObjectMeta: metav1.ObjectMeta{Name: "cluster"},
+				Spec: clusterv1.ClusterSpec{
+					ControlPlaneEndpoint: clusterv1.APIEndpoint{Host: "cluster.example.com", Port: 6443},
+				},
+			},
+			ServerIP: "10.0.0.1",
+		}
+	})
+
+	for _, role := range []RenderRole{InitControlPlaneRole, JoinControlPlaneRole, WorkerRole} {
+		role := role
+
+		It("should prepare the node of a "+string(role)+" machine", func() {
+			opts.Role = role
+
+			userData, err := RenderBootstrapData(context.Background(), opts)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(userData)).To(ContainSubstring("- '/opt/rke2-node-preparation.sh'"))
+		})
+	}
+
+	It("should not prepare the node of a joining server without node preparation", func() {
+		opts.Role = JoinControlPlaneRole
+		opts.Config.Spec.AgentConfig.NodePreparation = nil
+
+		userData, err := RenderBootstrapData(context.Background(), opts)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(userData)).ToNot(ContainSubstring("- '/opt/rke2-node-preparation.sh'"))
+	})
+
+	It("should install the server of the first control plane machine with generated certificates", func() {
+		opts.Role = InitControlPlaneRole
+
+		userData, err := RenderBootstrapData(context.Background(), opts)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(userData)).To(ContainSubstring("sh -s - server"))
+		Expect(string(userData)).To(ContainSubstring("path: /var/lib/rancher/rke2/server/tls/server-ca.key"))
+		Expect(string(userData)).ToNot(ContainSubstring("server: https://"))
+	})
+
+	It("should register a worker with the server address and a placeholder token", func() {
+		opts.Role = WorkerRole
+
+		userData, err := RenderBootstrapData(context.Background(), opts)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(userData)).To(ContainSubstring(`INSTALL_RKE2_TYPE="agent"`))
+		Expect(string(userData)).To(ContainSubstring("server: https://10.0.0.1:9345"))
+		Expect(string(userData)).To(ContainSubstring("token: " + RenderedToken))
+	})
+
+	It("should use the token of the objects", func() {
+		opts.Role = JoinControlPlaneRole
+		opts.Objects = []client.Object{&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: metav1.NamespaceDefault, Name: "cluster-token"},
+			Data:       map[string][]byte{"value": []byte("cluster-token-value")},
+		}}
+
+		userData, err := RenderBootstrapData(context.Background(), opts)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(userData)).To(ContainSubstring("token: cluster-token-value"))
+		Expect(string(userData)).ToNot(ContainSubstring(RenderedToken))
+	})
+
+	It("should render the format of the config", func() {
+		opts.Role = WorkerRole
+		opts.Config.Spec.AgentConfig.Format = bootstrapv1.Ignition
+
+		userData, err := RenderBootstrapData(context.Background(), opts)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(userData)).To(HavePrefix("{"))
+		Expect(string(userData)).To(ContainSubstring(`"ignition"`))
+	})
+
+	It("should fail to render an unknown role", func() {
+		opts.Role = "etcd"
+
+		_, err := RenderBootstrapData(context.Background(), opts)
+		Expect(err).To(MatchError(`unknown role "etcd"`))
+	})
+})
@@ -18,7 +18,9 @@ package controllers
 
 import (
 	"context"
+	"crypto/sha256"
 	"fmt"
+	"net"
 	"time"
 
 	"github.com/go-logr/logr"
@@ -32,7 +34,9 @@ import (
 	"k8s.io/utils/pointer"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 	kubeyaml "sigs.k8s.io/yaml"
 
 	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
@@ -43,6 +47,7 @@ import (
 
 	bootstrapv1 "github.com/rancher-sandbox/cluster-api-provider-rke2/bootstrap/api/v1alpha1"
 	"github.com/rancher-sandbox/cluster-api-provider-rke2/bootstrap/internal/cloudinit"
+	"github.com/rancher-sandbox/cluster-api-provider-rke2/bootstrap/internal/combustion"
 	"github.com/rancher-sandbox/cluster-api-provider-rke2/bootstrap/internal/ignition"
 	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
 	"github.com/rancher-sandbox/cluster-api-provider-rke2/pkg/consts"
@@ -55,7 +60,6 @@ import (
 const (
 	filePermissions  string = "0640"
 	registrationPort int    = 9345
-	serverURLFormat  string = "https://%v:%v"
 )
 
 // RKE2ConfigReconciler reconciles a Rke2Config object.
@@ -63,6 +67,24 @@ type RKE2ConfigReconciler struct {
 	RKE2InitLock RKE2InitLock
 	client.Client
 	Scheme *runtime.Scheme
+
+	// WorkloadClients returns the clients of the workload clusters, the join tokens of the workers are created in.
+	WorkloadClients WorkloadClients
+
+	// ValidateControlPlaneEndpoint resolves the control plane endpoint before generating the join configurations,
+	// and reports whether it points at the control plane in the ControlPlaneEndpointResolved condition.
+	ValidateControlPlaneEndpoint bool
+
+	// Resolver resolves the control plane endpoint, net.DefaultResolver is used when nil.
+	Resolver rke2.Resolver
+
+	// ShareWorkerBootstrapData stores identical worker bootstrap data, e.g. of the machines of a MachineDeployment, in
+	// a single secret shared by their RKE2Configs instead of a secret per RKE2Config.
+	ShareWorkerBootstrapData bool
+
+	// AllowedEtcdS3SecretNamespaces are the namespaces the etcd S3 credential and endpoint CA secrets can be referenced
+	// from, in addition to the namespace of the cluster.
+	AllowedEtcdS3SecretNamespaces []string
 }
 
 const (
@@ -76,8 +98,11 @@ const (
 
 //+kubebuilder:rbac:groups=bootstrap.cluster.x-k8s.io,resources=rke2configs;rke2configs/status;rke2configs/finalizers,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=controlplane.cluster.x-k8s.io,resources=rke2controlplanes;rke2controlplanes/status,verbs=get;list;watch
-//+kubebuilder:rbac:groups=cluster.x-k8s.io,resources=clusters;clusters/status;machinesets;machines;machines/status;machinepools;machinepools/status,verbs=get;list;watch
-//+kubebuilder:rbac:groups="",resources=secrets;events;configmaps,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=cluster.x-k8s.io,resources=clusters;machines,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch;create;delete
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+//+kubebuilder:rbac:groups="authorization.k8s.io",resources=subjectaccessreviews,verbs=create
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -150,28 +175,53 @@ func (r *RKE2ConfigReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 		return ctrl.Result{Requeue: true}, nil
 	}
 
-	if scope.Machine.Spec.Bootstrap.DataSecretName != nil && (!scope.Config.Status.Ready || scope.Config.Status.DataSecretName == nil) {
+	// The machine boots with the bootstrap data generated when its infrastructure is provisioned, until then it is
+	// generated again from the updated spec.
+	bootstrapDataOutdated := isBootstrapDataOutdated(scope) || r.isRegistriesOutdated(ctx, scope)
+
+	if scope.Machine.Spec.Bootstrap.DataSecretName != nil && !bootstrapDataOutdated &&
+		(!scope.Config.Status.Ready || scope.Config.Status.DataSecretName == nil) {
 		scope.Config.Status.Ready = true
 		scope.Config.Status.DataSecretName = scope.Machine.Spec.Bootstrap.DataSecretName
 		conditions.MarkTrue(scope.Config, bootstrapv1.DataSecretAvailableCondition)
 
 		return ctrl.Result{}, nil
 	}
+
+	if scope.Config.Status.Ready && bootstrapDataOutdated {
+		scope.Logger.Info("RKE2Config or its registry secrets changed before the machine was provisioned, "+
+			"generating the bootstrap data again",
+			"generation", scope.Config.Generation, "dataSecretGeneration", scope.Config.Status.DataSecretGeneration)
+
+		scope.Config.Status.Ready = false
+	}
+
 	// Status is ready means a config has been generated.
 	if scope.Config.Status.Ready {
 		// In any other case just return as the config is already generated and need not be generated again.
 		conditions.MarkTrue(scope.Config, bootstrapv1.DataSecretAvailableCondition)
 
-		return ctrl.Result{}, nil
+		return r.reconcileJoinToken(ctx, scope)
 	}
 
+	// The first machine of a control plane which lost all its machines initializes it again from an etcd snapshot,
+	// while the cluster keeps its control plane initialized condition.
+	_, recovering := scope.Config.Annotations[controlplanev1.ClusterResetRestorePathAnnotation]
+	initialized := conditions.IsTrue(scope.Cluster, clusterv1.ControlPlaneInitializedCondition)
+
+	// When the roles are split, the control plane is initialized once a control plane machine joins the etcd machines.
+	joiningEtcd := scope.HasControlPlaneOwner &&
+		scope.Config.Labels[controlplanev1.MachineRoleLabel] == string(controlplanev1.ControlPlaneMachineRole)
+
 	// Note: can't use IsFalse here because we need to handle the absence of the condition as well as false.
-	if !conditions.IsTrue(scope.Cluster, clusterv1.ControlPlaneInitializedCondition) {
+	if (!initialized && !joiningEtcd) || (recovering && scope.HasControlPlaneOwner) {
 		return r.handleClusterNotInitialized(ctx, scope)
 	}
 
 	// Unlock any locks that might have been set during init process
-	r.RKE2InitLock.Unlock(ctx, scope.Cluster)
+	if initialized {
+		r.RKE2InitLock.Unlock(ctx, scope.Cluster)
+	}
 
 	// it's a control plane join
 	if scope.HasControlPlaneOwner {
@@ -182,7 +232,7 @@ func (r *RKE2ConfigReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 	// GetTheControlPlane for the worker
 	wkControlPlane := controlplanev1.RKE2ControlPlane{}
 	err = r.Client.Get(ctx, types.NamespacedName{
-		Namespace: scope.Cluster.Spec.ControlPlaneRef.Namespace,
+		Namespace: scope.Cluster.Namespace,
 		Name:      scope.Cluster.Spec.ControlPlaneRef.Name,
 	}, &wkControlPlane)
 
@@ -287,11 +337,43 @@ func (r *RKE2ConfigReconciler) SetupWithManager(mgr ctrl.Manager) error {
 		r.RKE2InitLock = locking.NewControlPlaneInitMutex(mgr.GetClient())
 	}
 
+	if r.WorkloadClients == nil {
+		r.WorkloadClients = &rke2.WorkloadClients{Client: mgr.GetClient(), ControllerName: rke2ConfigControllerName}
+	}
+
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&bootstrapv1.RKE2Config{}).
+		Watches(
+			&source.Kind{Type: &corev1.Secret{}},
+			handler.EnqueueRequestsFromMapFunc(r.registrySecretToRKE2Configs),
+		).
 		Complete(r)
 }
 
+// registrySecretToRKE2Configs is a handler.ToRequestsFunc to be used to enqueue requests for the RKE2Configs
+// referencing a registry secret, so that the bootstrap data of the machines not provisioned yet is generated again
+// when the secret is rotated.
+func (r *RKE2ConfigReconciler) registrySecretToRKE2Configs(o client.Object) []ctrl.Request {
+	configs := &bootstrapv1.RKE2ConfigList{}
+	if err := r.Client.List(context.Background(), configs); err != nil {
+		return nil
+	}
+
+	requests := []ctrl.Request{}
+
+	for i := range configs.Items {
+		for _, ref := range configs.Items[i].Spec.PrivateRegistriesConfig.SecretRefs() {
+			if ref.Namespace == o.GetNamespace() && ref.Name == o.GetName() {
+				requests = append(requests, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(&configs.Items[i])})
+
+				break
+			}
+		}
+	}
+
+	return requests
+}
+
 // handleClusterNotInitialized handles the first control plane node.
 func (r *RKE2ConfigReconciler) handleClusterNotInitialized(ctx context.Context, scope *Scope) (res ctrl.Result, reterr error) { //nolint:funlen
 	if !scope.HasControlPlaneOwner {
@@ -315,6 +397,11 @@ func (r *RKE2ConfigReconciler) handleClusterNotInitialized(ctx context.Context,
 	}()
 
 	certificates := secret.NewCertificatesForInitialControlPlane()
+	// The etcd metrics are scraped with a client certificate signed by the etcd CA, unless the datastore is external.
+	if scope.ControlPlane.Spec.ServerConfig.Metrics != nil && scope.ControlPlane.Spec.ServerConfig.ExternalDatastore == nil {
+		certificates = append(certificates, secret.NewEtcdCACertificate())
+	}
+
 	if err := certificates.LookupOrGenerate(
 		ctx,
 		r.Client,
@@ -342,35 +429,36 @@ func (r *RKE2ConfigReconciler) handleClusterNotInitialized(ctx context.Context,
 
 	scope.Logger.Info("RKE2 server token generated and stored in Secret!")
 
+	if err := r.checkEtcdS3Secrets(ctx, scope); err != nil {
+		return ctrl.Result{}, err
+	}
+
 	configStruct, configFiles, err := rke2.GenerateInitControlPlaneConfig(
 		rke2.ServerConfigOpts{
 			Cluster:              *scope.Cluster,
 			ControlPlaneEndpoint: scope.Cluster.Spec.ControlPlaneEndpoint.Host,
 			Token:                token,
-			ServerURL:            fmt.Sprintf(serverURLFormat, scope.Cluster.Spec.ControlPlaneEndpoint.Host, registrationPort),
+			ServerURL:            rke2.ServerURL(scope.Cluster.Spec.ControlPlaneEndpoint.Host, registrationPort),
 			ServerConfig:         scope.ControlPlane.Spec.ServerConfig,
 			AgentConfig:          scope.Config.Spec.AgentConfig,
 			Ctx:                  ctx,
 			Client:               r.Client,
+			Role:                 controlplanev1.MachineRole(scope.Config.Labels[controlplanev1.MachineRoleLabel]),
+
+			ClusterResetRestorePath: scope.Config.Annotations[controlplanev1.ClusterResetRestorePathAnnotation],
 		})
 	if err != nil {
 		return ctrl.Result{}, err
 	}
 
-	b, err := kubeyaml.Marshal(configStruct)
+	initConfigFile, err := rke2.GenerateConfigFile(
+		scope.Config.Spec.AgentConfig.ConfigurationMode, rke2.ServerUnit, configStruct, filePermissions)
 	if err != nil {
 		return ctrl.Result{}, err
 	}
 
 	scope.Logger.Info("Server config marshalled successfully")
 
-	initConfigFile := bootstrapv1.File{
-		Path:        rke2.DefaultRKE2ConfigLocation,
-		Content:     string(b),
-		Owner:       consts.DefaultFileOwner,
-		Permissions: filePermissions,
-	}
-
 	files, err := r.generateFileListIncludingRegistries(ctx, scope, configFiles)
 	if err != nil {
 		return ctrl.Result{}, err
@@ -401,15 +489,18 @@ func (r *RKE2ConfigReconciler) handleClusterNotInitialized(ctx context.Context,
 
 	cpinput := &cloudinit.ControlPlaneInput{
 		BaseUserData: cloudinit.BaseUserData{
-			AirGapped:           scope.Config.Spec.AgentConfig.AirGapped,
-			CISEnabled:          scope.Config.Spec.AgentConfig.CISProfile != "",
-			PreRKE2Commands:     scope.Config.Spec.PreRKE2Commands,
-			PostRKE2Commands:    scope.Config.Spec.PostRKE2Commands,
-			ConfigFile:          initConfigFile,
-			RKE2Version:         scope.Config.Spec.AgentConfig.Version,
-			WriteFiles:          files,
-			NTPServers:          ntpServers,
-			AdditionalCloudInit: scope.Config.Spec.AgentConfig.AdditionalUserData.Config,
+			AirGapped:              scope.Config.Spec.AgentConfig.AirGapped,
+			CISEnabled:             scope.Config.Spec.AgentConfig.CISProfile != "",
+			NodePreparationEnabled: scope.Config.Spec.AgentConfig.NodePreparation != nil,
+			HostAliasesEnabled:     len(scope.Config.Spec.AgentConfig.HostAliases) > 0,
+			EtcdDiskSetupEnabled:   scope.ControlPlane.Spec.ServerConfig.Etcd.DiskSetup != nil,
+			PreRKE2Commands:        scope.Config.Spec.PreRKE2Commands,
+			PostRKE2Commands:       scope.Config.Spec.PostRKE2Commands,
+			ConfigFile:             initConfigFile,
+			RKE2Version:            scope.Config.Spec.AgentConfig.Version,
+			WriteFiles:             files,
+			NTPServers:             ntpServers,
+			AdditionalCloudInit:    scope.Config.Spec.AgentConfig.AdditionalUserData.Config,
 		},
 		Certificates: certificates,
 	}
@@ -422,6 +513,11 @@ func (r *RKE2ConfigReconciler) handleClusterNotInitialized(ctx context.Context,
 			ControlPlaneInput:  cpinput,
 			AdditionalIgnition: &scope.Config.Spec.AgentConfig.AdditionalUserData,
 		})
+	case bootstrapv1.Combustion:
+		userData, err = combustion.NewInitControlPlane(&combustion.ControlPlaneInput{
+			ControlPlaneInput:    cpinput,
+			AdditionalCombustion: &scope.Config.Spec.AgentConfig.AdditionalUserData,
+		})
 	default:
 		userData, err = cloudinit.NewInitControlPlane(cpinput)
 	}
@@ -438,8 +534,8 @@ func (r *RKE2ConfigReconciler) handleClusterNotInitialized(ctx context.Context,
 }
 
 // generateFileListIncludingRegistries generates a list of files to be written to disk on the node
-// This list includes a registries.yaml file if the user has provided a PrivateRegistriesConfig
-// and the files fields provided in the RKE2Config.
+// This list includes a registries.yaml file if the user has provided a PrivateRegistriesConfig,
+// the machine identity certificate if requested, and the files fields provided in the RKE2Config.
 func (r *RKE2ConfigReconciler) generateFileListIncludingRegistries(
 	ctx context.Context,
 	scope *Scope,
@@ -457,6 +553,13 @@ func (r *RKE2ConfigReconciler) generateFileListIncludingRegistries(
 		return nil, err
 	}
 
+	registriesHash, err := rke2.RegistrySecretsHash(ctx, r.Client, scope.Config.Spec.PrivateRegistriesConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	scope.Config.Status.DataSecretRegistriesHash = registriesHash
+
 	registriesYAML, err := kubeyaml.Marshal(registries)
 	if err != nil {
 		scope.Logger.Error(err, "unable to marshall registries.yaml")
@@ -476,6 +579,41 @@ func (r *RKE2ConfigReconciler) generateFileListIncludingRegistries(
 	files := configFiles
 	files = append(files, registryFiles...)
 	files = append(files, initRegistriesFile)
+
+	if identity := scope.Config.Spec.MachineIdentity; identity != nil {
+		caSecret := &corev1.Secret{}
+		if err := r.Client.Get(ctx, types.NamespacedName{
+			Name:      identity.CASecretName,
+			Namespace: scope.Config.Namespace,
+		}, caSecret); err != nil {
+			return nil, errors.Wrap(err, "failed to get machine identity certificate authority")
+		}
+
+		identityFiles, err := secret.NewMachineIdentityFiles(identity, caSecret, scope.Machine)
+		if err != nil {
+			return nil, err
+		}
+
+		files = append(files, identityFiles...)
+	}
+
+	if attestation := scope.Config.Spec.Attestation; attestation != nil {
+		attestationSecret := &corev1.Secret{}
+		if err := r.Client.Get(ctx, types.NamespacedName{
+			Name:      attestation.SecretName,
+			Namespace: scope.Config.Namespace,
+		}, attestationSecret); err != nil {
+			return nil, errors.Wrap(err, "failed to get attestation secret")
+		}
+
+		attestationFiles, err := secret.NewAttestationFiles(attestation, attestationSecret)
+		if err != nil {
+			return nil, err
+		}
+
+		files = append(files, attestationFiles...)
+	}
+
 	files = append(files, scope.Config.Spec.Files...)
 
 	return files, nil
@@ -488,6 +626,11 @@ type RKE2InitLock interface {
 	Lock(ctx context.Context, cluster *clusterv1.Cluster, machine *clusterv1.Machine) bool
 }
 
+// WorkloadClients returns the clients of the workload clusters.
+type WorkloadClients interface {
+	GetClient(ctx context.Context, clusterKey client.ObjectKey) (client.Client, error)
+}
+
 // joinControlPlane implements the part of the Reconciler which bootstraps a secondary
 // Control Plane machine joining a cluster that is already initialized.
 func (r *RKE2ConfigReconciler) joinControlplane(ctx context.Context, scope *Scope) (res ctrl.Result, rerr error) {
@@ -506,22 +649,30 @@ func (r *RKE2ConfigReconciler) joinControlplane(ctx context.Context, scope *Scop
 
 	scope.Logger.Info("RKE2 server token found in Secret!")
 
-	if len(scope.ControlPlane.Status.AvailableServerIPs) == 0 {
+	r.validateControlPlaneEndpoint(ctx, scope)
+
+	registrationAddress := scope.ControlPlane.RegistrationAddress(scope.Machine.Spec.FailureDomain)
+	if registrationAddress == "" {
 		scope.Logger.Info("No ControlPlane IP Address found for node registration")
 
 		return ctrl.Result{RequeueAfter: DefaultRequeueAfter}, nil
 	}
 
+	if err := r.checkEtcdS3Secrets(ctx, scope); err != nil {
+		return ctrl.Result{}, err
+	}
+
 	configStruct, configFiles, err := rke2.GenerateJoinControlPlaneConfig(
 		rke2.ServerConfigOpts{
 			Cluster:              *scope.Cluster,
 			Token:                token,
 			ControlPlaneEndpoint: scope.Cluster.Spec.ControlPlaneEndpoint.Host,
-			ServerURL:            fmt.Sprintf(serverURLFormat, scope.ControlPlane.Status.AvailableServerIPs[0], registrationPort),
+			ServerURL:            rke2.ServerURL(registrationAddress, registrationPort),
 			ServerConfig:         scope.ControlPlane.Spec.ServerConfig,
 			AgentConfig:          scope.Config.Spec.AgentConfig,
 			Ctx:                  ctx,
 			Client:               r.Client,
+			Role:                 controlplanev1.MachineRole(scope.Config.Labels[controlplanev1.MachineRoleLabel]),
 		},
 	)
 	if err != nil {
@@ -530,22 +681,15 @@ func (r *RKE2ConfigReconciler) joinControlplane(ctx context.Context, scope *Scop
 		return ctrl.Result{}, err
 	}
 
-	b, err := kubeyaml.Marshal(configStruct)
-
-	scope.Logger.Info("Showing marshalled config.yaml", "config.yaml", string(b))
-
+	initConfigFile, err := rke2.GenerateConfigFile(
+		scope.Config.Spec.AgentConfig.ConfigurationMode, rke2.ServerUnit, configStruct, filePermissions)
 	if err != nil {
 		return ctrl.Result{}, err
 	}
 
-	scope.Logger.Info("Joining Server config marshalled successfully")
+	scope.Logger.Info("Showing marshalled config", "path", initConfigFile.Path, "config", initConfigFile.Content)
 
-	initConfigFile := bootstrapv1.File{
-		Path:        rke2.DefaultRKE2ConfigLocation,
-		Content:     string(b),
-		Owner:       consts.DefaultFileOwner,
-		Permissions: filePermissions,
-	}
+	scope.Logger.Info("Joining Server config marshalled successfully")
 
 	files, err := r.generateFileListIncludingRegistries(ctx, scope, configFiles)
 	if err != nil {
@@ -577,15 +721,18 @@ func (r *RKE2ConfigReconciler) joinControlplane(ctx context.Context, scope *Scop
 
 	cpinput := &cloudinit.ControlPlaneInput{
 		BaseUserData: cloudinit.BaseUserData{
-			AirGapped:           scope.Config.Spec.AgentConfig.AirGapped,
-			CISEnabled:          scope.Config.Spec.AgentConfig.CISProfile != "",
-			PreRKE2Commands:     scope.Config.Spec.PreRKE2Commands,
-			PostRKE2Commands:    scope.Config.Spec.PostRKE2Commands,
-			ConfigFile:          initConfigFile,
-			RKE2Version:         scope.Config.Spec.AgentConfig.Version,
-			WriteFiles:          files,
-			NTPServers:          ntpServers,
-			AdditionalCloudInit: scope.Config.Spec.AgentConfig.AdditionalUserData.Config,
+			AirGapped:              scope.Config.Spec.AgentConfig.AirGapped,
+			CISEnabled:             scope.Config.Spec.AgentConfig.CISProfile != "",
+			NodePreparationEnabled: scope.Config.Spec.AgentConfig.NodePreparation != nil,
+			HostAliasesEnabled:     len(scope.Config.Spec.AgentConfig.HostAliases) > 0,
+			EtcdDiskSetupEnabled:   scope.ControlPlane.Spec.ServerConfig.Etcd.DiskSetup != nil,
+			PreRKE2Commands:        scope.Config.Spec.PreRKE2Commands,
+			PostRKE2Commands:       scope.Config.Spec.PostRKE2Commands,
+			ConfigFile:             initConfigFile,
+			RKE2Version:            scope.Config.Spec.AgentConfig.Version,
+			WriteFiles:             files,
+			NTPServers:             ntpServers,
+			AdditionalCloudInit:    scope.Config.Spec.AgentConfig.AdditionalUserData.Config,
 		},
 	}
 
@@ -601,6 +748,11 @@ func (r *RKE2ConfigReconciler) joinControlplane(ctx context.Context, scope *Scop
 			ControlPlaneInput:  cpinput,
 			AdditionalIgnition: &scope.Config.Spec.AgentConfig.AdditionalUserData,
 		})
+	case bootstrapv1.Combustion:
+		userData, err = combustion.NewJoinControlPlane(&combustion.ControlPlaneInput{
+			ControlPlaneInput:    cpinput,
+			AdditionalCombustion: &scope.Config.Spec.AgentConfig.AdditionalUserData,
+		})
 	default:
 		userData, err = cloudinit.NewJoinControlPlane(cpinput)
 	}
@@ -636,42 +788,48 @@ func (r *RKE2ConfigReconciler) joinWorker(ctx context.Context, scope *Scope) (re
 
 	scope.Logger.Info("RKE2 server token found in Secret!")
 
-	if len(scope.ControlPlane.Status.AvailableServerIPs) == 0 {
+	r.validateControlPlaneEndpoint(ctx, scope)
+
+	registrationAddress := scope.ControlPlane.RegistrationAddress(scope.Machine.Spec.FailureDomain)
+	if registrationAddress == "" {
 		scope.Logger.V(1).Info("No ControlPlane IP Address found for node registration")
 
 		return ctrl.Result{RequeueAfter: DefaultRequeueAfter}, nil
 	}
 
+	if scope.Config.Spec.JoinTokenTTL != nil {
+		joinToken, err := r.createJoinToken(ctx, scope)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+
+		token = joinToken
+	}
+
 	configStruct, configFiles, err := rke2.GenerateWorkerConfig(
 		rke2.AgentConfigOpts{
-			ServerURL:              fmt.Sprintf(serverURLFormat, scope.ControlPlane.Status.AvailableServerIPs[0], registrationPort),
+			Cluster:                *scope.Cluster,
+			ServerURL:              rke2.ServerURL(registrationAddress, registrationPort),
 			Token:                  token,
 			AgentConfig:            scope.Config.Spec.AgentConfig,
 			Ctx:                    ctx,
 			Client:                 r.Client,
-			CloudProviderName:      scope.ControlPlane.Spec.ServerConfig.CloudProviderName,
+			CloudProviderName:      scope.ControlPlane.Spec.ServerConfig.NodeCloudProviderName(),
 			CloudProviderConfigMap: scope.ControlPlane.Spec.ServerConfig.CloudProviderConfigMap,
 		})
 	if err != nil {
 		return ctrl.Result{}, err
 	}
 
-	b, err := kubeyaml.Marshal(configStruct)
-
-	scope.Logger.V(5).Info("Showing marshalled config.yaml", "config.yaml", string(b))
-
+	wkJoinConfigFile, err := rke2.GenerateConfigFile(
+		scope.Config.Spec.AgentConfig.ConfigurationMode, rke2.AgentUnit, configStruct, filePermissions)
 	if err != nil {
 		return ctrl.Result{}, err
 	}
 
-	scope.Logger.Info("Joining Worker config marshalled successfully")
+	scope.Logger.V(5).Info("Showing marshalled config", "path", wkJoinConfigFile.Path, "config", wkJoinConfigFile.Content)
 
-	wkJoinConfigFile := bootstrapv1.File{
-		Path:        rke2.DefaultRKE2ConfigLocation,
-		Content:     string(b),
-		Owner:       consts.DefaultFileOwner,
-		Permissions: filePermissions,
-	}
+	scope.Logger.Info("Joining Worker config marshalled successfully")
 
 	files, err := r.generateFileListIncludingRegistries(ctx, scope, configFiles)
 	if err != nil {
@@ -684,15 +842,17 @@ func (r *RKE2ConfigReconciler) joinWorker(ctx context.Context, scope *Scope) (re
 	}
 
 	wkInput := &cloudinit.BaseUserData{
-		PreRKE2Commands:     scope.Config.Spec.PreRKE2Commands,
-		AirGapped:           scope.Config.Spec.AgentConfig.AirGapped,
-		CISEnabled:          scope.Config.Spec.AgentConfig.CISProfile != "",
-		PostRKE2Commands:    scope.Config.Spec.PostRKE2Commands,
-		ConfigFile:          wkJoinConfigFile,
-		RKE2Version:         scope.Config.Spec.AgentConfig.Version,
-		WriteFiles:          files,
-		NTPServers:          ntpServers,
-		AdditionalCloudInit: scope.Config.Spec.AgentConfig.AdditionalUserData.Config,
+		PreRKE2Commands:        scope.Config.Spec.PreRKE2Commands,
+		AirGapped:              scope.Config.Spec.AgentConfig.AirGapped,
+		CISEnabled:             scope.Config.Spec.AgentConfig.CISProfile != "",
+		NodePreparationEnabled: scope.Config.Spec.AgentConfig.NodePreparation != nil,
+		HostAliasesEnabled:     len(scope.Config.Spec.AgentConfig.HostAliases) > 0,
+		PostRKE2Commands:       scope.Config.Spec.PostRKE2Commands,
+		ConfigFile:             wkJoinConfigFile,
+		RKE2Version:            scope.Config.Spec.AgentConfig.Version,
+		WriteFiles:             files,
+		NTPServers:             ntpServers,
+		AdditionalCloudInit:    scope.Config.Spec.AgentConfig.AdditionalUserData.Config,
 	}
 
 	var userData []byte
@@ -703,6 +863,11 @@ func (r *RKE2ConfigReconciler) joinWorker(ctx context.Context, scope *Scope) (re
 			BaseUserData:       wkInput,
 			AdditionalIgnition: &scope.Config.Spec.AgentConfig.AdditionalUserData,
 		})
+	case bootstrapv1.Combustion:
+		userData, err = combustion.NewJoinWorker(&combustion.JoinWorkerInput{
+			BaseUserData:         wkInput,
+			AdditionalCombustion: &scope.Config.Spec.AgentConfig.AdditionalUserData,
+		})
 	default:
 		userData, err = cloudinit.NewJoinWorker(wkInput)
 	}
@@ -711,6 +876,15 @@ func (r *RKE2ConfigReconciler) joinWorker(ctx context.Context, scope *Scope) (re
 		return ctrl.Result{}, err
 	}
 
+	// The machine identity certificates are the only bootstrap data specific to a worker machine.
+	if r.ShareWorkerBootstrapData && scope.Config.Spec.MachineIdentity == nil {
+		if err := r.storeSharedBootstrapData(ctx, scope, userData); err != nil {
+			return ctrl.Result{}, err
+		}
+
+		return ctrl.Result{}, nil
+	}
+
 	if err := r.storeBootstrapData(ctx, scope, userData); err != nil {
 		return ctrl.Result{}, err
 	}
@@ -718,6 +892,35 @@ func (r *RKE2ConfigReconciler) joinWorker(ctx context.Context, scope *Scope) (re
 	return ctrl.Result{}, nil
 }
 
+// validateControlPlaneEndpoint reports whether the control plane endpoint resolves to an address of the control plane
+// in the ControlPlaneEndpointResolved condition, when enabled. The most common reason for machines failing to join is
+// a control plane endpoint that doesn't resolve, or that points at outdated addresses.
+func (r *RKE2ConfigReconciler) validateControlPlaneEndpoint(ctx context.Context, scope *Scope) {
+	if !r.ValidateControlPlaneEndpoint {
+		return
+	}
+
+	var resolver rke2.Resolver = net.DefaultResolver
+	if r.Resolver != nil {
+		resolver = r.Resolver
+	}
+
+	err := rke2.ValidateControlPlaneEndpoint(ctx, resolver, scope.Cluster.Spec.ControlPlaneEndpoint.Host, scope.ControlPlane)
+
+	switch {
+	case err == nil:
+		conditions.MarkTrue(scope.Config, bootstrapv1.ControlPlaneEndpointResolvedCondition)
+	case errors.Is(err, rke2.ErrControlPlaneEndpointMismatch):
+		scope.Logger.Info("Control plane endpoint points at no control plane address", "reason", err.Error())
+		conditions.MarkFalse(scope.Config, bootstrapv1.ControlPlaneEndpointResolvedCondition,
+			bootstrapv1.ControlPlaneEndpointMismatchReason, clusterv1.ConditionSeverityWarning, err.Error())
+	default:
+		scope.Logger.Info("Control plane endpoint doesn't resolve", "reason", err.Error())
+		conditions.MarkFalse(scope.Config, bootstrapv1.ControlPlaneEndpointResolvedCondition,
+			bootstrapv1.ControlPlaneEndpointUnresolvedReason, clusterv1.ConditionSeverityWarning, err.Error())
+	}
+}
+
 // generateAndStoreToken generates a random token with 16 characters then stores it in a Secret in the API.
 func (r *RKE2ConfigReconciler) generateAndStoreToken(ctx context.Context, scope *Scope) (string, error) {
 	token, err := bsutil.Random(defaultTokenLength)
@@ -757,9 +960,43 @@ func (r *RKE2ConfigReconciler) generateAndStoreToken(ctx context.Context, scope
 	return token, nil
 }
 
+// isBootstrapDataOutdated returns true if the bootstrap data was generated by this controller from a previous
+// generation of the RKE2Config, and the infrastructure of the machine is not provisioned yet.
+func isBootstrapDataOutdated(scope *Scope) bool {
+	status := scope.Config.Status
+
+	return status.DataSecretGeneration != 0 &&
+		status.DataSecretGeneration != scope.Config.Generation &&
+		!scope.Machine.Status.InfrastructureReady &&
+		scope.Machine.Status.NodeRef == nil
+}
+
+// isRegistriesOutdated returns true if the registry secrets were rotated since the bootstrap data was generated, before
+// the infrastructure of the machine is provisioned.
+func (r *RKE2ConfigReconciler) isRegistriesOutdated(ctx context.Context, scope *Scope) bool {
+	status := scope.Config.Status
+	if status.DataSecretGeneration == 0 || scope.Machine.Status.InfrastructureReady || scope.Machine.Status.NodeRef != nil {
+		return false
+	}
+
+	hash, err := rke2.RegistrySecretsHash(ctx, r.Client, scope.Config.Spec.PrivateRegistriesConfig)
+	if err != nil {
+		scope.Logger.Info("Failed to hash the registry secrets", "error", err.Error())
+
+		return false
+	}
+
+	return hash != status.DataSecretRegistriesHash
+}
+
 // storeBootstrapData creates a new secret with the data passed in as input,
 // sets the reference in the configuration status and ready to true.
 func (r *RKE2ConfigReconciler) storeBootstrapData(ctx context.Context, scope *Scope, data []byte) error {
+	secretData, err := secret.BootstrapData(data, scope.Config.Spec.AgentConfig.Format, scope.Config.Spec.AgentConfig.DataSecret)
+	if err != nil {
+		return err
+	}
+
 	secret := &corev1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      scope.Config.Name,
@@ -777,10 +1014,7 @@ func (r *RKE2ConfigReconciler) storeBootstrapData(ctx context.Context, scope *Sc
 				},
 			},
 		},
-		Data: map[string][]byte{
-			"value":  data,
-			"format": []byte(scope.Config.Spec.AgentConfig.Format),
-		},
+		Data: secretData,
 		Type: clusterv1.ClusterSecretType,
 	}
 
@@ -789,11 +1023,139 @@ func (r *RKE2ConfigReconciler) storeBootstrapData(ctx context.Context, scope *Sc
 	}
 
 	scope.Config.Status.DataSecretName = pointer.String(secret.Name)
+	scope.Config.Status.DataSecretGeneration = scope.Config.Generation
 	scope.Config.Status.Ready = true
 	//	conditions.MarkTrue(scope.Config, bootstrapv1.DataSecretAvailableCondition)
 	return nil
 }
 
+// storeSharedBootstrapData stores the bootstrap data in a secret shared by the RKE2Configs generating the same data,
+// sets the reference in the configuration status and ready to true. The secret is named after the hash of the data and
+// owned by all the RKE2Configs referencing it, so that it is garbage collected with the last of them.
+func (r *RKE2ConfigReconciler) storeSharedBootstrapData(ctx context.Context, scope *Scope, data []byte) error {
+	// The configs sharing the data must shape the secret the same way.
+	shape := []byte(scope.Config.Spec.AgentConfig.Format)
+	if dataSecret := scope.Config.Spec.AgentConfig.DataSecret; dataSecret != nil {
+		shape = append(shape, []byte("/"+dataSecret.Key+"/"+string(dataSecret.Encoding))...)
+	}
+
+	hash := sha256.Sum256(append(shape, data...))
+
+	secretData, err := secret.BootstrapData(data, scope.Config.Spec.AgentConfig.Format, scope.Config.Spec.AgentConfig.DataSecret)
+	if err != nil {
+		return err
+	}
+
+	owner := metav1.OwnerReference{
+		APIVersion: scope.Config.APIVersion,
+		Kind:       scope.Config.Kind,
+		Name:       scope.Config.Name,
+		UID:        scope.Config.UID,
+	}
+
+	secret := &corev1.Secret{}
+	key := types.NamespacedName{
+		Namespace: scope.Config.Namespace,
+		Name:      fmt.Sprintf("%s-worker-%x", scope.Cluster.Name, hash[:8]),
+	}
+
+	err = r.Client.Get(ctx, key, secret)
+
+	switch {
+	case apierrors.IsNotFound(err):
+		secret = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      key.Name,
+				Namespace: key.Namespace,
+				Labels: map[string]string{
+					clusterv1.ClusterNameLabel: scope.Cluster.Name,
+				},
+				OwnerReferences: []metav1.OwnerReference{owner},
+			},
+			Data: secretData,
+			Type: clusterv1.ClusterSecretType,
+		}
+
+		if err := r.Client.Create(ctx, secret); err != nil {
+			return errors.Wrapf(err, "failed to create shared bootstrap data secret %s", key.Name)
+		}
+
+		scope.Logger.Info("Created shared bootstrap data secret", "secret", key.Name)
+	case err != nil:
+		return errors.Wrapf(err, "failed to get shared bootstrap data secret %s", key.Name)
+	case !util.HasOwnerRef(secret.OwnerReferences, owner):
+		// The owners are patched with an optimistic lock, so that concurrent owners are not lost.
+		patch := client.MergeFromWithOptions(secret.DeepCopy(), client.MergeFromWithOptimisticLock{})
+		secret.OwnerReferences = append(secret.OwnerReferences, owner)
+
+		if err := r.Client.Patch(ctx, secret, patch); err != nil {
+			return errors.Wrapf(err, "failed to add owner to shared bootstrap data secret %s", key.Name)
+		}
+
+		scope.Logger.Info("Reusing shared bootstrap data secret", "secret", key.Name)
+	}
+
+	// The bootstrap data generated again from an updated config is stored in another secret.
+	if previous := scope.Config.Status.DataSecretName; previous != nil && *previous != key.Name {
+		if err := r.releaseSharedBootstrapData(ctx, scope, owner, *previous); err != nil {
+			return err
+		}
+	}
+
+	scope.Config.Status.DataSecretName = pointer.String(key.Name)
+	scope.Config.Status.DataSecretGeneration = scope.Config.Generation
+	scope.Config.Status.Ready = true
+
+	return nil
+}
+
+// releaseSharedBootstrapData removes the owner reference of the RKE2Config from a shared bootstrap data secret it no
+// longer references, the secret is deleted once it has no owners left, as it holds the join token.
+func (r *RKE2ConfigReconciler) releaseSharedBootstrapData(
+	ctx context.Context,
+	scope *Scope,
+	owner metav1.OwnerReference,
+	name string,
+) error {
+	secret := &corev1.Secret{}
+	if err := r.Client.Get(ctx, types.NamespacedName{Namespace: scope.Config.Namespace, Name: name}, secret); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+
+		return errors.Wrapf(err, "failed to get previous bootstrap data secret %s", name)
+	}
+
+	if !util.HasOwnerRef(secret.OwnerReferences, owner) {
+		return nil
+	}
+
+	if len(secret.OwnerReferences) == 1 {
+		// The secret is only deleted if no owner was added meanwhile.
+		if err := r.Client.Delete(ctx, secret, client.Preconditions{
+			UID:             &secret.UID,
+			ResourceVersion: &secret.ResourceVersion,
+		}); err != nil && !apierrors.IsNotFound(err) {
+			return errors.Wrapf(err, "failed to delete previous bootstrap data secret %s", name)
+		}
+
+		scope.Logger.Info("Deleted previous bootstrap data secret", "secret", name)
+
+		return nil
+	}
+
+	patch := client.MergeFromWithOptions(secret.DeepCopy(), client.MergeFromWithOptimisticLock{})
+	secret.OwnerReferences = util.RemoveOwnerRef(secret.OwnerReferences, owner)
+
+	if err := r.Client.Patch(ctx, secret, patch); err != nil {
+		return errors.Wrapf(err, "failed to remove owner from previous bootstrap data secret %s", name)
+	}
+
+	scope.Logger.Info("Released previous bootstrap data secret", "secret", name)
+
+	return nil
+}
+
 // createOrUpdateSecret tries to create the given secret in the API, if that secret exists it will update it.
 func (r *RKE2ConfigReconciler) createOrUpdateSecretFromObject(
 	ctx context.Context,
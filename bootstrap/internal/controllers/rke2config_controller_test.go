@@ -0,0 +1,270 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+
+	bootstrapv1 "github.com/rancher-sandbox/cluster-api-provider-rke2/bootstrap/api/v1alpha1"
+	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+	"github.com/rancher-sandbox/cluster-api-provider-rke2/pkg/secret"
+)
+
+var _ = Describe("RKE2Config changing before the machine is provisioned", func() {
+	var (
+		env     *testEnvironment
+		machine *clusterv1.Machine
+		config  *bootstrapv1.RKE2Config
+	)
+
+	ctx := context.Background()
+
+	BeforeEach(func() {
+		env = newTestEnvironment()
+		machine, config = env.createWorker("worker-0", bootstrapv1.RKE2ConfigSpec{
+			PreRKE2Commands: []string{"echo first"},
+		})
+
+		config = env.reconcile(config)
+		Expect(config.Status.Ready).To(BeTrue())
+		Expect(config.Status.DataSecretGeneration).To(BeEquivalentTo(1))
+		Expect(env.bootstrapData(config)).To(ContainSubstring("echo first"))
+	})
+
+	update := func() {
+		config.Spec.PreRKE2Commands = []string{"echo second"}
+		config.Generation = 2
+		Expect(env.Client.Update(ctx, config)).To(Succeed())
+	}
+
+	It("should generate the bootstrap data again from the updated spec", func() {
+		update()
+
+		config = env.reconcile(config)
+		Expect(config.Status.Ready).To(BeTrue())
+		Expect(config.Status.DataSecretGeneration).To(BeEquivalentTo(2))
+		Expect(env.bootstrapData(config)).To(ContainSubstring("echo second"))
+		Expect(env.bootstrapData(config)).ToNot(ContainSubstring("echo first"))
+	})
+
+	It("should keep the bootstrap data once the infrastructure of the machine is provisioned", func() {
+		machine.Status.InfrastructureReady = true
+		Expect(env.Client.Status().Update(ctx, machine)).To(Succeed())
+
+		update()
+
+		config = env.reconcile(config)
+		Expect(config.Status.DataSecretGeneration).To(BeEquivalentTo(1))
+		Expect(env.bootstrapData(config)).To(ContainSubstring("echo first"))
+	})
+
+	It("should keep the bootstrap data once the machine has a node", func() {
+		machine.Status.NodeRef = &corev1.ObjectReference{Kind: "Node", Name: "worker-0"}
+		Expect(env.Client.Status().Update(ctx, machine)).To(Succeed())
+
+		update()
+
+		config = env.reconcile(config)
+		Expect(config.Status.DataSecretGeneration).To(BeEquivalentTo(1))
+		Expect(env.bootstrapData(config)).To(ContainSubstring("echo first"))
+	})
+
+	It("should keep the bootstrap data it didn't generate", func() {
+		config.Status.DataSecretGeneration = 0
+		Expect(env.Client.Status().Update(ctx, config)).To(Succeed())
+
+		update()
+
+		config = env.reconcile(config)
+		Expect(config.Status.DataSecretGeneration).To(BeZero())
+		Expect(env.bootstrapData(config)).To(ContainSubstring("echo first"))
+	})
+})
+
+var _ = Describe("registration addresses of the failure domains", func() {
+	var env *testEnvironment
+
+	ctx := context.Background()
+
+	// worker returns the bootstrap data of a worker machine of the failure domain.
+	worker := func(name string, failureDomain *string) string {
+		machine, config := env.createWorker(name, bootstrapv1.RKE2ConfigSpec{})
+		machine.Spec.FailureDomain = failureDomain
+		Expect(env.Client.Update(ctx, machine)).To(Succeed())
+
+		config = env.reconcile(config)
+		Expect(config.Status.Ready).To(BeTrue())
+
+		return env.bootstrapData(config)
+	}
+
+	BeforeEach(func() {
+		env = newTestEnvironment()
+
+		env.ControlPlane.Spec.RegistrationAddresses = []controlplanev1.RegistrationAddress{
+			{FailureDomain: "zone-a", Address: "zone-a.cluster.example.com"},
+		}
+		Expect(env.Client.Update(ctx, env.ControlPlane)).To(Succeed())
+	})
+
+	It("should register the machines of a failure domain with its registration address", func() {
+		Expect(worker("worker-0", pointer.String("zone-a"))).To(ContainSubstring("server: https://zone-a.cluster.example.com:9345"))
+	})
+
+	It("should register the machines of the other failure domains with the first available server", func() {
+		Expect(worker("worker-0", pointer.String("zone-b"))).To(ContainSubstring("server: https://10.0.0.1:9345"))
+		Expect(worker("worker-1", nil)).To(ContainSubstring("server: https://10.0.0.1:9345"))
+	})
+
+	It("should register the machines of a failure domain before any server is available", func() {
+		env.ControlPlane.Status.AvailableServerIPs = nil
+		Expect(env.Client.Status().Update(ctx, env.ControlPlane)).To(Succeed())
+
+		Expect(worker("worker-0", pointer.String("zone-a"))).To(ContainSubstring("server: https://zone-a.cluster.example.com:9345"))
+
+		_, config := env.createWorker("worker-1", bootstrapv1.RKE2ConfigSpec{})
+		Expect(env.reconcile(config).Status.Ready).To(BeFalse())
+	})
+})
+
+var _ = Describe("sharing the worker bootstrap data", func() {
+	var env *testEnvironment
+
+	ctx := context.Background()
+
+	// worker returns the reconciled RKE2Config of a new worker machine.
+	worker := func(name string, spec bootstrapv1.RKE2ConfigSpec) *bootstrapv1.RKE2Config {
+		_, config := env.createWorker(name, spec)
+
+		config = env.reconcile(config)
+		Expect(config.Status.Ready).To(BeTrue())
+
+		return config
+	}
+
+	// owners returns the names of the owners of the bootstrap data secret of the RKE2Config.
+	owners := func(config *bootstrapv1.RKE2Config) []string {
+		dataSecret := &corev1.Secret{}
+		Expect(env.Client.Get(ctx, client.ObjectKey{Namespace: config.Namespace, Name: *config.Status.DataSecretName},
+			dataSecret)).To(Succeed())
+
+		names := []string{}
+		for _, owner := range dataSecret.OwnerReferences {
+			names = append(names, owner.Name)
+		}
+
+		return names
+	}
+
+	BeforeEach(func() {
+		env = newTestEnvironment()
+		env.Reconciler.ShareWorkerBootstrapData = true
+	})
+
+	It("should store the identical bootstrap data of the workers in a secret owned by their configs", func() {
+		first := worker("worker-0", bootstrapv1.RKE2ConfigSpec{PreRKE2Commands: []string{"echo worker"}})
+		second := worker("worker-1", bootstrapv1.RKE2ConfigSpec{PreRKE2Commands: []string{"echo worker"}})
+
+		Expect(*first.Status.DataSecretName).To(HavePrefix("cluster-worker-"))
+		Expect(*second.Status.DataSecretName).To(Equal(*first.Status.DataSecretName))
+		Expect(owners(first)).To(ConsistOf("worker-0", "worker-1"))
+		Expect(env.bootstrapData(second)).To(ContainSubstring("echo worker"))
+	})
+
+	It("should store different bootstrap data in different secrets", func() {
+		first := worker("worker-0", bootstrapv1.RKE2ConfigSpec{PreRKE2Commands: []string{"echo first"}})
+		second := worker("worker-1", bootstrapv1.RKE2ConfigSpec{PreRKE2Commands: []string{"echo second"}})
+
+		Expect(*second.Status.DataSecretName).ToNot(Equal(*first.Status.DataSecretName))
+		Expect(owners(first)).To(ConsistOf("worker-0"))
+		Expect(owners(second)).To(ConsistOf("worker-1"))
+	})
+
+	It("should store the bootstrap data identifying the machine in a secret of its config", func() {
+		ca := secret.NewEtcdCACertificate()
+		Expect(ca.Generate()).To(Succeed())
+		Expect(env.Client.Create(ctx, &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: env.Cluster.Namespace, Name: "machine-identity-ca"},
+			Data:       map[string][]byte{secret.TLSCrtDataName: ca.KeyPair.Cert, secret.TLSKeyDataName: ca.KeyPair.Key},
+		})).To(Succeed())
+
+		spec := bootstrapv1.RKE2ConfigSpec{
+			MachineIdentity: &bootstrapv1.MachineIdentity{CASecretName: "machine-identity-ca", TrustDomain: "example.com"},
+		}
+
+		Expect(*worker("worker-0", spec).Status.DataSecretName).To(Equal("worker-0"))
+		Expect(*worker("worker-1", spec).Status.DataSecretName).To(Equal("worker-1"))
+	})
+
+	Context("generating the bootstrap data again before the machine is provisioned", func() {
+		regenerate := func(config *bootstrapv1.RKE2Config) *bootstrapv1.RKE2Config {
+			config.Spec.PreRKE2Commands = []string{"echo updated"}
+			config.Generation = 2
+			Expect(env.Client.Update(ctx, config)).To(Succeed())
+
+			config = env.reconcile(config)
+			Expect(config.Status.DataSecretGeneration).To(BeEquivalentTo(2))
+			Expect(env.bootstrapData(config)).To(ContainSubstring("echo updated"))
+
+			return config
+		}
+
+		It("should release the previous secret still shared with other configs", func() {
+			first := worker("worker-0", bootstrapv1.RKE2ConfigSpec{PreRKE2Commands: []string{"echo worker"}})
+			second := worker("worker-1", bootstrapv1.RKE2ConfigSpec{PreRKE2Commands: []string{"echo worker"}})
+			previous := *first.Status.DataSecretName
+
+			first = regenerate(first)
+
+			Expect(*first.Status.DataSecretName).ToNot(Equal(previous))
+			Expect(owners(first)).To(ConsistOf("worker-0"))
+			Expect(*second.Status.DataSecretName).To(Equal(previous))
+			Expect(owners(second)).To(ConsistOf("worker-1"))
+		})
+
+		It("should delete the previous secret once no config owns it", func() {
+			config := worker("worker-0", bootstrapv1.RKE2ConfigSpec{PreRKE2Commands: []string{"echo worker"}})
+			previous := *config.Status.DataSecretName
+
+			config = regenerate(config)
+
+			Expect(*config.Status.DataSecretName).ToNot(Equal(previous))
+			Expect(owners(config)).To(ConsistOf("worker-0"))
+
+			err := env.Client.Get(ctx, client.ObjectKey{Namespace: config.Namespace, Name: previous}, &corev1.Secret{})
+			Expect(apierrors.IsNotFound(err)).To(BeTrue())
+		})
+	})
+
+	It("should store the bootstrap data in a secret per config unless enabled", func() {
+		env.Reconciler.ShareWorkerBootstrapData = false
+
+		Expect(*worker("worker-0", bootstrapv1.RKE2ConfigSpec{}).Status.DataSecretName).To(Equal("worker-0"))
+		Expect(*worker("worker-1", bootstrapv1.RKE2ConfigSpec{}).Status.DataSecretName).To(Equal("worker-1"))
+	})
+})
@@ -37,8 +37,8 @@ import (
 // The rke2-install.service unit is enabled and is executed only once during the boot process to run the /etc/rke2-install.sh script.
 // This script installs and deploys RKE2, and performs pre and post-installation commands.
 // The ntpd.service unit is enabled only if NTP servers are specified.
-// The second section defines storage files for the system. It creates a file at /etc/rke2-install.sh. If CISEnabled is set to true,
-// it runs an additional CIS script to enforce system security standards. If NTP servers are specified,
+// The second section defines storage files for the system. It creates a file at /etc/rke2-install.sh. If NodePreparationEnabled
+// is set to true, it prepares the kernel of the node first. If CISEnabled is set to true, it runs an additional CIS script to enforce system security standards. If NTP servers are specified,
 // it creates an NTP configuration file at /etc/ntp.conf.
 const (
 	clcTemplate = `---
@@ -101,8 +101,17 @@ storage:
         inline: |
           #!/bin/bash
           set -e
+          {{- if .HostAliasesEnabled }}
+          /opt/rke2-host-aliases.sh
+          {{- end }}
           {{ range .PreRKE2Commands }}
           {{ . | Indent 10 }}
+          {{- end }}
+          {{- if .NodePreparationEnabled }}
+          /opt/rke2-node-preparation.sh
+          {{- end }}
+          {{- if .EtcdDiskSetupEnabled }}
+          /opt/rke2-etcd-disk-setup.sh
           {{- end }}
 
 		  {{- if .CISEnabled }}
@@ -17,6 +17,7 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"net/http"
@@ -29,6 +30,7 @@ import (
 
 	"github.com/spf13/pflag"
 	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
@@ -36,13 +38,16 @@ import (
 	"k8s.io/klog/v2/klogr"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/cluster"
 
 	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
 
 	bootstrapv1 "github.com/rancher-sandbox/cluster-api-provider-rke2/bootstrap/api/v1alpha1"
 	"github.com/rancher-sandbox/cluster-api-provider-rke2/bootstrap/internal/controllers"
 	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+	"github.com/rancher-sandbox/cluster-api-provider-rke2/pkg/certrotation"
 	"github.com/rancher-sandbox/cluster-api-provider-rke2/pkg/consts"
+	"github.com/rancher-sandbox/cluster-api-provider-rke2/pkg/observer"
 )
 
 var (
@@ -52,6 +57,7 @@ var (
 	// flags.
 	metricsBindAddr             string
 	enableLeaderElection        bool
+	observerMode                bool
 	leaderElectionLeaseDuration time.Duration
 	leaderElectionRenewDeadline time.Duration
 	leaderElectionRetryPeriod   time.Duration
@@ -61,13 +67,22 @@ var (
 	syncPeriod                  time.Duration
 	webhookPort                 int
 	webhookCertDir              string
+	webhookCertRotation         bool
+	webhookServiceName          string
+	webhookCertSecretName       string
 	healthAddr                  string
+
+	validateControlPlaneEndpoint bool
+	shareWorkerBootstrapData     bool
+
+	allowedEtcdS3SecretNamespaces []string
 )
 
 func init() {
 	klog.InitFlags(nil)
 
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(apiextensionsv1.AddToScheme(scheme))
 	utilruntime.Must(bootstrapv1.AddToScheme(scheme))
 	utilruntime.Must(controlplanev1.AddToScheme(scheme))
 	utilruntime.Must(clusterv1.AddToScheme(scheme))
@@ -108,8 +123,28 @@ func InitFlags(fs *pflag.FlagSet) {
 	fs.StringVar(&webhookCertDir, "webhook-cert-dir", "/tmp/k8s-webhook-server/serving-certs/",
 		"Webhook cert dir, only used when webhook-port is specified.")
 
+	fs.BoolVar(&webhookCertRotation, "webhook-cert-rotation", false,
+		"Generate and renew the webhook serving certificate and its CA, injecting the CA in the webhook configurations and the conversion webhooks of the CRDs, instead of using the certificate issued by cert-manager. The webhook cert dir must be writable and the POD_NAMESPACE environment variable set.") //nolint:lll
+
+	fs.StringVar(&webhookServiceName, "webhook-service-name", "rke2-bootstrap-webhook-service",
+		"The service of the webhook server, in the namespace of the pod, only used with --webhook-cert-rotation.")
+
+	fs.StringVar(&webhookCertSecretName, "webhook-cert-secret-name", "rke2-bootstrap-webhook-rotated-cert",
+		"The secret storing the webhook serving certificate and its CA, in the namespace of the pod, only used with --webhook-cert-rotation.")
+
 	fs.StringVar(&healthAddr, "health-addr", ":9440",
 		"The address the health endpoint binds to.")
+
+	fs.BoolVar(&validateControlPlaneEndpoint, "validate-control-plane-endpoint", false,
+		"Resolve the control plane endpoint before generating the join configurations, and warn in the ControlPlaneEndpointResolved condition of the RKE2Configs if it doesn't resolve or points at no control plane address.") //nolint:lll
+
+	fs.BoolVar(&shareWorkerBootstrapData, "share-worker-bootstrap-data", false,
+		"Store identical worker bootstrap data, e.g. of the machines of a MachineDeployment, in a single secret shared by their RKE2Configs.") //nolint:lll
+
+	fs.StringSliceVar(&allowedEtcdS3SecretNamespaces, "allowed-etcd-s3-secret-namespaces", []string{},
+		"Namespaces the etcd S3 credential and endpoint CA secrets can be referenced from, in addition to the namespace of the cluster. The service accounts of the cluster namespace must also be allowed to get the secrets.") //nolint:lll
+	fs.BoolVar(&observerMode, "observer", false,
+		"Run in read-only observer mode, for audit environments: the changes the controllers would make are logged instead of being made. Leader election is disabled.") //nolint:lll
 }
 
 func main() {
@@ -127,10 +162,17 @@ func main() {
 		}()
 	}
 
+	newClient := cluster.DefaultNewClient
+	if observerMode {
+		setupLog.Info("Running in observer mode, the changes are logged instead of being made")
+
+		newClient = observer.NewClientFunc()
+	}
+
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
 		Scheme:             scheme,
 		MetricsBindAddress: metricsBindAddr,
-		LeaderElection:     enableLeaderElection,
+		LeaderElection:     enableLeaderElection && !observerMode,
 		LeaderElectionID:   "rke2-bootstrap-manager-leader-election-capi",
 		LeaseDuration:      &leaderElectionLeaseDuration,
 		RenewDeadline:      &leaderElectionRenewDeadline,
@@ -143,6 +185,7 @@ func main() {
 		Port:                   webhookPort,
 		CertDir:                webhookCertDir,
 		HealthProbeBindAddress: healthAddr,
+		NewClient:              newClient,
 	})
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
@@ -150,6 +193,7 @@ func main() {
 	}
 
 	setupChecks(mgr)
+	setupCertRotation(mgr)
 	setupReconcilers(mgr)
 	setupWebhooks(mgr)
 	//+kubebuilder:scaffold:builder
@@ -174,10 +218,34 @@ func setupChecks(mgr ctrl.Manager) {
 	}
 }
 
+func setupCertRotation(mgr ctrl.Manager) {
+	if !webhookCertRotation {
+		return
+	}
+
+	namespace := os.Getenv("POD_NAMESPACE")
+	if namespace == "" {
+		setupLog.Error(nil, "--webhook-cert-rotation requires the POD_NAMESPACE environment variable")
+		os.Exit(1)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	service := client.ObjectKey{Namespace: namespace, Name: webhookServiceName}
+	if err := certrotation.Setup(ctx, mgr, service, webhookCertSecretName, webhookCertDir); err != nil {
+		setupLog.Error(err, "unable to set up the webhook certificate rotation")
+		os.Exit(1)
+	}
+}
+
 func setupReconcilers(mgr ctrl.Manager) {
 	if err := (&controllers.RKE2ConfigReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:                        mgr.GetClient(),
+		Scheme:                        mgr.GetScheme(),
+		ValidateControlPlaneEndpoint:  validateControlPlaneEndpoint,
+		ShareWorkerBootstrapData:      shareWorkerBootstrapData,
+		AllowedEtcdS3SecretNamespaces: allowedEtcdS3SecretNamespaces,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Rke2Config")
 		os.Exit(1)
@@ -70,15 +70,42 @@ const (
 	// RollingUpdateInProgressReason (Severity=Warning) documents a RKE2ControlPlane object executing a
 	// rolling upgrade for aligning the machines spec to the desired state.
 	RollingUpdateInProgressReason = "RollingUpdateInProgress"
+
+	// WaitingForOutdatedMachinesDeletionReason (Severity=Warning) documents a RKE2ControlPlane with the OnDelete
+	// rollout strategy waiting for its outdated machines to be deleted to replace them.
+	WaitingForOutdatedMachinesDeletionReason = "WaitingForOutdatedMachinesDeletion"
+
+	// UnsupportedVersionReason (Severity=Error) documents a RKE2ControlPlane not rolling out its machines because
+	// its RKE2 version is not supported by the compatibility matrix.
+	UnsupportedVersionReason = "UnsupportedVersion"
 )
 
 const (
-	// EtcdClusterHealthyCondition documents the overall etcd cluster's health.
+	// EtcdClusterHealthyCondition documents the overall etcd cluster's health, as reported by the etcd members: the
+	// message holds the number of members, whether the quorum is kept and the raised alarms.
 	EtcdClusterHealthyCondition clusterv1.ConditionType = "EtcdClusterHealthyCondition"
 
 	// EtcdClusterInspectionFailedReason documents a failure in inspecting the etcd cluster status.
 	EtcdClusterInspectionFailedReason = "EtcdClusterInspectionFailed"
 
+	// EtcdClientUnavailableReason documents an etcd cluster which can't be inspected, as the etcd certificate authority
+	// is not provided by the management cluster.
+	EtcdClientUnavailableReason = "EtcdClientUnavailable"
+
+	// EtcdQuorumLostReason (Severity=Error) documents an etcd cluster whose healthy members are not a majority.
+	EtcdQuorumLostReason = "EtcdQuorumLost"
+
+	// EtcdAlarmsRaisedReason (Severity=Error) documents an etcd cluster with raised alarms, e.g. NOSPACE.
+	EtcdAlarmsRaisedReason = "EtcdAlarmsRaised"
+
+	// EtcdMembersUnhealthyReason (Severity=Warning) documents an etcd cluster keeping its quorum with unhealthy members.
+	EtcdMembersUnhealthyReason = "EtcdMembersUnhealthy"
+
+	// EtcdAlarmsClearedCondition documents that no alarm, e.g. NOSPACE or CORRUPT, is raised in the etcd cluster. When
+	// it is false, with the EtcdAlarmsRaisedReason, the control plane is neither scaled nor rolled out unless the
+	// RKE2ControlPlane has the IgnoreEtcdAlarmsAnnotation.
+	EtcdAlarmsClearedCondition clusterv1.ConditionType = "EtcdAlarmsCleared"
+
 	// MachineEtcdMemberHealthyCondition report the machine's etcd member's health status.
 	// NOTE: This conditions exists only if a stacked etcd cluster is used.
 	MachineEtcdMemberHealthyCondition clusterv1.ConditionType = "EtcdMemberHealthy"
@@ -86,6 +113,9 @@ const (
 	// EtcdMemberInspectionFailedReason documents a failure in inspecting the etcd member status.
 	EtcdMemberInspectionFailedReason = "MemberInspectionFailed"
 
+	// EtcdMemberUnhealthyReason (Severity=Error) documents an etcd member reported unhealthy by etcd.
+	EtcdMemberUnhealthyReason = "EtcdMemberUnhealthy"
+
 	// ResizedCondition documents a RKE2ControlPlane that is resizing the set of controlled machines.
 	ResizedCondition clusterv1.ConditionType = "Resized"
 
@@ -94,6 +124,83 @@ const (
 
 	// ScalingDownReason (Severity=Info) documents a RKE2ControlPlane that is decreasing the number of replicas.
 	ScalingDownReason = "ScalingDown"
+
+	// ScaleDownQueuedReason (Severity=Info) documents a RKE2ControlPlane that is scaled down once its ongoing rollout
+	// completes.
+	ScaleDownQueuedReason = "ScaleDownQueued"
+)
+
+const (
+	// MachinesCreatedCondition documents that the machines controlled by the RKE2ControlPlane are created.
+	// When this condition is false, it indicates that there was an error when cloning the infrastructure/bootstrap template or
+	// when generating the machine object.
+	MachinesCreatedCondition clusterv1.ConditionType = "MachinesCreated"
+
+	// InfrastructureTemplateCloningFailedReason (Severity=Error) documents a RKE2ControlPlane failing to
+	// clone the infrastructure template.
+	InfrastructureTemplateCloningFailedReason = "InfrastructureTemplateCloningFailed"
+
+	// MachineGenerationFailedReason (Severity=Error) documents a RKE2ControlPlane failing to
+	// generate a machine object.
+	MachineGenerationFailedReason = "MachineGenerationFailed"
+
+	// WaitingForInfrastructureCapacityReason (Severity=Info) documents a RKE2ControlPlane waiting for the
+	// infrastructure quota or capacity to allow creating a machine.
+	WaitingForInfrastructureCapacityReason = "WaitingForInfrastructureCapacity"
+
+	// WaitingForInfrastructureProviderReason (Severity=Warning) documents a RKE2ControlPlane waiting for the provider
+	// of its infrastructure template to be available again, e.g. while the management cluster is upgraded: its CRDs
+	// are not installed, its webhooks don't respond or the template is paused. It is reported by the
+	// MachinesCreatedCondition and the InfrastructureReferenceValidCondition.
+	WaitingForInfrastructureProviderReason = "WaitingForInfrastructureProvider"
+
+	// EtcdSnapshotUnavailableReason (Severity=Error) documents a RKE2ControlPlane which lost all its machines and can't
+	// be recovered, as no etcd snapshot stored in S3 is known.
+	EtcdSnapshotUnavailableReason = "EtcdSnapshotUnavailable"
+)
+
+const (
+	// MachinesProvisionedCondition documents that the machines controlled by the RKE2ControlPlane are provisioned,
+	// their nodes having registered with the workload cluster. When this condition is false, its reason tells which
+	// provisioning step the machines wait for, hence whether this provider or the infrastructure provider is the
+	// bottleneck.
+	MachinesProvisionedCondition clusterv1.ConditionType = "MachinesProvisioned"
+
+	// MachineProvisionedCondition documents the provisioning of a control plane machine, with the same reasons as
+	// MachinesProvisionedCondition.
+	MachineProvisionedCondition clusterv1.ConditionType = "Provisioned"
+
+	// WaitingForBootstrapDataReason (Severity=Info) documents machines waiting for this provider to generate their
+	// bootstrap data.
+	WaitingForBootstrapDataReason = "WaitingForBootstrapData"
+
+	// WaitingForInfrastructureReason (Severity=Info) documents machines waiting for the infrastructure provider to
+	// provision their infrastructure machines.
+	WaitingForInfrastructureReason = "WaitingForInfrastructure"
+
+	// WaitingForNodeRefReason (Severity=Info) documents machines whose infrastructure is provisioned, waiting for RKE2
+	// to be installed and their nodes to register with the workload cluster.
+	WaitingForNodeRefReason = "WaitingForNodeRef"
+)
+
+const (
+	// InfrastructureReferenceValidCondition documents that the infrastructure template referenced by the
+	// RKE2ControlPlane exists and is an infrastructure machine template the machines can be cloned from.
+	InfrastructureReferenceValidCondition clusterv1.ConditionType = "InfrastructureReferenceValid"
+
+	// InvalidInfrastructureReferenceReason (Severity=Error) documents a RKE2ControlPlane referencing an
+	// infrastructure template that doesn't exist or doesn't implement the infrastructure machine template contract.
+	InvalidInfrastructureReferenceReason = "InvalidInfrastructureReference"
+)
+
+const (
+	// TLSSanCoveredCondition documents that the registration addresses of the RKE2ControlPlane are subject
+	// alternative names of the server certificate, so that the machines registering with them can join.
+	TLSSanCoveredCondition clusterv1.ConditionType = "TLSSanCovered"
+
+	// RegistrationAddressNotCoveredReason (Severity=Warning) documents registration addresses missing from the
+	// TLSSan of the RKE2ControlPlane.
+	RegistrationAddressNotCoveredReason = "RegistrationAddressNotCovered"
 )
 
 const (
@@ -103,3 +210,166 @@ const (
 	// CertificatesGenerationFailedReason documents a failure in generating the certificates.
 	CertificatesGenerationFailedReason string = "CertificateGenerationFailed"
 )
+
+const (
+	// HibernatedCondition documents that the control plane has been stopped after taking an etcd snapshot.
+	HibernatedCondition clusterv1.ConditionType = "Hibernated"
+
+	// HibernatingReason (Severity=Info) documents a RKE2ControlPlane taking an etcd snapshot and stopping rke2-server
+	// on the control plane machines.
+	HibernatingReason = "Hibernating"
+
+	// HibernationFailedReason (Severity=Warning) documents a failure in hibernating the control plane.
+	HibernationFailedReason = "HibernationFailed"
+
+	// ResumingReason (Severity=Info) documents a RKE2ControlPlane waiting for the control plane machines to be powered
+	// on to resume from hibernation.
+	ResumingReason = "Resuming"
+)
+
+const (
+	// UpgradeApprovedCondition documents whether the desired version of a RKE2ControlPlane is approved. It is only set
+	// while the desired version differs from the version.
+	UpgradeApprovedCondition clusterv1.ConditionType = "UpgradeApproved"
+
+	// WaitingForApprovalReason (Severity=Info) documents a RKE2ControlPlane whose desired version waits for the
+	// approval annotation or the VersionApproved condition.
+	WaitingForApprovalReason = "WaitingForApproval"
+
+	// VersionApprovedCondition approves the desired version of a RKE2ControlPlane when it is true, as an alternative
+	// to the approved version annotation. It is set by a human or a change management controller, and removed by the
+	// controller once the desired version is approved, so that it doesn't approve the versions proposed later.
+	VersionApprovedCondition clusterv1.ConditionType = "VersionApproved"
+)
+
+const (
+	// VersionDriftCondition documents control plane nodes whose kubelet runs another version than agentConfig.version
+	// for longer than the version drift tolerance, e.g. because of a stuck or manually tampered upgrade. It is only set
+	// while such nodes exist.
+	VersionDriftCondition clusterv1.ConditionType = "VersionDrift"
+
+	// VersionDriftDetectedReason documents control plane nodes running another version than agentConfig.version.
+	VersionDriftDetectedReason = "VersionDriftDetected"
+)
+
+const (
+	// MaintenanceCondition documents that the control plane machines are frozen, no machine is created, deleted or
+	// rolled out until the maintenance mode is disabled.
+	MaintenanceCondition clusterv1.ConditionType = "Maintenance"
+)
+
+const (
+	// AddOnAppliedCondition documents that the manifests of a RKE2AddOn are applied to the workload cluster.
+	AddOnAppliedCondition clusterv1.ConditionType = "Applied"
+
+	// WaitingForControlPlaneReason (Severity=Info) documents a RKE2AddOn waiting for the control plane of the workload
+	// cluster to be ready before applying its manifests.
+	WaitingForControlPlaneReason = "WaitingForControlPlane"
+
+	// AddOnApplyFailedReason (Severity=Warning) documents a failure in applying the manifests of a RKE2AddOn.
+	AddOnApplyFailedReason = "ApplyFailed"
+)
+
+const (
+	// UpgradeCompletedCondition documents that all the control planes of a RKE2UpgradeGroup run its version.
+	UpgradeCompletedCondition clusterv1.ConditionType = "UpgradeCompleted"
+
+	// UpgradingReason (Severity=Info) documents a RKE2UpgradeGroup upgrading its control planes.
+	UpgradingReason = "Upgrading"
+
+	// UpgradePausedReason (Severity=Info) documents a paused RKE2UpgradeGroup.
+	UpgradePausedReason = "UpgradePaused"
+
+	// UpgradeHaltedReason (Severity=Error) documents a RKE2UpgradeGroup halted because more control plane upgrades
+	// failed than tolerated.
+	UpgradeHaltedReason = "UpgradeHalted"
+)
+
+const (
+	// EtcdQuorumRecoveredCondition documents the recovery of the etcd cluster requested by the
+	// RecoverEtcdQuorumAnnotation, after the loss of its quorum, or the reset requested by the ClusterResetAnnotation.
+	EtcdQuorumRecoveredCondition clusterv1.ConditionType = "EtcdQuorumRecovered"
+
+	// EtcdRecoveryRefusedReason (Severity=Warning) documents a recovery request refused because the etcd quorum is not
+	// lost, or because no member can be elected to recover it, or a reset request refused by its guards.
+	EtcdRecoveryRefusedReason = "EtcdRecoveryRefused"
+
+	// WaitingForClusterResetReason (Severity=Warning) documents a recovery waiting for etcd to be reset on the elected
+	// member.
+	WaitingForClusterResetReason = "WaitingForClusterReset"
+
+	// RecreatingEtcdMembersReason (Severity=Info) documents a recovery deleting the control plane machines other than
+	// the elected member, so that they are recreated and join the reset etcd cluster.
+	RecreatingEtcdMembersReason = "RecreatingEtcdMembers"
+
+	// ClusterResetFailedReason (Severity=Error) documents a recovery whose reset of etcd failed on the elected member.
+	// The reset is tried again once the ClusterResetRequestedAnnotation of the member is removed.
+	ClusterResetFailedReason = "ClusterResetFailed"
+)
+
+const (
+	// EtcdSnapshotRestoredCondition documents that the control plane of a RKE2EtcdSnapshotRestore is restored from its
+	// etcd snapshot.
+	EtcdSnapshotRestoredCondition clusterv1.ConditionType = "EtcdSnapshotRestored"
+
+	// VerifyingEtcdSnapshotReason (Severity=Info) documents a RKE2EtcdSnapshotRestore checking that its etcd snapshot
+	// is found, before stopping the control plane machines.
+	VerifyingEtcdSnapshotReason = "VerifyingEtcdSnapshot"
+
+	// RestoringEtcdSnapshotReason (Severity=Info) documents a RKE2EtcdSnapshotRestore waiting for etcd to be restored
+	// on its machine.
+	RestoringEtcdSnapshotReason = "RestoringEtcdSnapshot"
+
+	// RejoiningControlPlaneMachinesReason (Severity=Info) documents a RKE2EtcdSnapshotRestore waiting for the other
+	// control plane machines to be recreated.
+	RejoiningControlPlaneMachinesReason = "RejoiningControlPlaneMachines"
+
+	// EtcdSnapshotRestoreFailedReason (Severity=Error) documents a RKE2EtcdSnapshotRestore that can't be completed.
+	EtcdSnapshotRestoreFailedReason = "EtcdSnapshotRestoreFailed"
+)
+
+const (
+	// EtcdDefragmentedCondition documents the periodic defragmentation of the ETCD members, when it is enabled.
+	EtcdDefragmentedCondition clusterv1.ConditionType = "EtcdDefragmented"
+
+	// DefragmentingEtcdReason (Severity=Info) documents a RKE2ControlPlane defragmenting its ETCD members, one at a
+	// time.
+	DefragmentingEtcdReason = "DefragmentingEtcd"
+
+	// EtcdDefragmentationFailedReason (Severity=Warning) documents a RKE2ControlPlane failing to defragment its ETCD
+	// members, e.g. because some of them are unhealthy. The defragmentation is tried again.
+	EtcdDefragmentationFailedReason = "EtcdDefragmentationFailed"
+
+	// EtcdDefragmentationSkippedReason (Severity=Info) documents a RKE2ControlPlane not defragmenting its ETCD members
+	// because the etcd certificate authority is not provided by the management cluster, so etcd can't be reached.
+	EtcdDefragmentationSkippedReason = "EtcdDefragmentationSkipped"
+)
+
+const (
+	// SecretsEncryptionKeyRotatedCondition documents the rotation of the secrets encryption key requested with the
+	// rotate-secrets-encryption-key annotation.
+	SecretsEncryptionKeyRotatedCondition clusterv1.ConditionType = "SecretsEncryptionKeyRotated"
+
+	// RotatingSecretsEncryptionKeyReason (Severity=Info) documents a RKE2ControlPlane rotating its secrets encryption
+	// key.
+	RotatingSecretsEncryptionKeyReason = "RotatingSecretsEncryptionKey"
+
+	// SecretsEncryptionKeyRotationFailedReason (Severity=Error) documents a RKE2ControlPlane failing to rotate its
+	// secrets encryption key. The rotation is not tried again, another one is requested by changing the value of the
+	// annotation.
+	SecretsEncryptionKeyRotationFailedReason = "SecretsEncryptionKeyRotationFailed"
+)
+
+const (
+	// RegistriesRefreshedCondition documents the refresh of the registries configuration on the nodes of the servers,
+	// when refreshRegistries is set.
+	RegistriesRefreshedCondition clusterv1.ConditionType = "RegistriesRefreshed"
+
+	// RefreshingRegistriesReason (Severity=Info) documents a RKE2ControlPlane writing the registries configuration on
+	// the nodes of its servers, one node at a time.
+	RefreshingRegistriesReason = "RefreshingRegistries"
+
+	// RegistriesRefreshFailedReason (Severity=Warning) documents a RKE2ControlPlane failing to refresh the registries
+	// configuration, e.g. because a registry secret is invalid. The refresh is tried again.
+	RegistriesRefreshFailedReason = "RegistriesRefreshFailed"
+)
@@ -0,0 +1,168 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+)
+
+const (
+	// RKE2AddOnFinalizer allows the controller to remove the manifests from the workload cluster
+	// before the RKE2AddOn is deleted.
+	RKE2AddOnFinalizer = "rke2addon.controlplane.cluster.x-k8s.io"
+)
+
+// RKE2AddOnSpec defines the manifests applied to the workload cluster, exactly one source must be set.
+type RKE2AddOnSpec struct {
+	// ClusterName is the name of the Cluster, in the same namespace, the manifests are applied to.
+	// +kubebuilder:validation:MinLength=1
+	ClusterName string `json:"clusterName"`
+
+	// Inline contains the manifests, as multiple YAML documents.
+	//+optional
+	Inline string `json:"inline,omitempty"`
+
+	// ConfigMapRef is a reference to a ConfigMap, in the same namespace, each data entry of which contains manifests.
+	//+optional
+	ConfigMapRef *corev1.LocalObjectReference `json:"configMapRef,omitempty"`
+
+	// Chart is a Helm chart installed by the RKE2 Helm controller.
+	//+optional
+	Chart *RKE2AddOnChart `json:"chart,omitempty"`
+
+	// Scheduling is added to the pods of the workloads of the manifests, or to the values of the charts.
+	//+optional
+	Scheduling *WorkloadScheduling `json:"scheduling,omitempty"`
+}
+
+// WorkloadScheduling defines the scheduling of the pods of the manifests the provider deploys to a workload cluster, so
+// that they fit clusters with strict admission policies. It is added to the pod templates of the workloads, and as the
+// conventional tolerations, nodeSelector and priorityClassName values of the Helm charts.
+type WorkloadScheduling struct {
+	// Tolerations are added to the tolerations of the pods.
+	//+optional
+	Tolerations []corev1.Toleration `json:"tolerations,omitempty"`
+
+	// NodeSelector is merged into the node selector of the pods, its labels override the ones of the manifests.
+	//+optional
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+
+	// PriorityClassName overrides the priority class of the pods.
+	//+optional
+	PriorityClassName string `json:"priorityClassName,omitempty"`
+}
+
+// RKE2AddOnChart defines a Helm chart, stored in an OCI registry, installed by the RKE2 Helm controller.
+type RKE2AddOnChart struct {
+	// Name is the name of the Helm release.
+	// +kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+
+	// Chart is the OCI reference of the chart, e.g. "oci://registry.example.com/charts/app".
+	// +kubebuilder:validation:Pattern=`^oci://`
+	Chart string `json:"chart"`
+
+	// Version is the version of the chart.
+	//+optional
+	Version string `json:"version,omitempty"`
+
+	// TargetNamespace is the namespace the chart is installed in (default: "default").
+	//+optional
+	TargetNamespace string `json:"targetNamespace,omitempty"`
+
+	// ValuesContent is the values of the chart, in YAML.
+	//+optional
+	ValuesContent string `json:"valuesContent,omitempty"`
+}
+
+// RKE2AddOnResource is a reference to a resource applied to the workload cluster.
+type RKE2AddOnResource struct {
+	// APIVersion of the resource.
+	APIVersion string `json:"apiVersion"`
+
+	// Kind of the resource.
+	Kind string `json:"kind"`
+
+	// Namespace of the resource, empty for cluster scoped resources.
+	//+optional
+	Namespace string `json:"namespace,omitempty"`
+
+	// Name of the resource.
+	Name string `json:"name"`
+}
+
+// RKE2AddOnStatus defines the observed state of RKE2AddOn.
+type RKE2AddOnStatus struct {
+	// Ready denotes that the manifests have been applied to the workload cluster.
+	//+optional
+	Ready bool `json:"ready,omitempty"`
+
+	// Resources are the resources applied to the workload cluster, they are removed from the workload cluster
+	// when they are removed from the manifests or when the RKE2AddOn is deleted.
+	//+optional
+	Resources []RKE2AddOnResource `json:"resources,omitempty"`
+
+	// ObservedGeneration is the latest generation observed by the controller.
+	//+optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Conditions defines current service state of the RKE2AddOn.
+	//+optional
+	Conditions clusterv1.Conditions `json:"conditions,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Cluster",type="string",JSONPath=".spec.clusterName"
+//+kubebuilder:printcolumn:name="Ready",type="boolean",JSONPath=".status.ready"
+//+kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+
+// RKE2AddOn is the Schema for the rke2addons API, it applies, upgrades and removes manifests on a workload cluster
+// once its control plane is initialized.
+type RKE2AddOn struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   RKE2AddOnSpec   `json:"spec,omitempty"`
+	Status RKE2AddOnStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// RKE2AddOnList contains a list of RKE2AddOn.
+type RKE2AddOnList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []RKE2AddOn `json:"items"`
+}
+
+// GetConditions returns the list of conditions for a RKE2AddOn object.
+func (r *RKE2AddOn) GetConditions() clusterv1.Conditions {
+	return r.Status.Conditions
+}
+
+// SetConditions sets the list of conditions for a RKE2AddOn object.
+func (r *RKE2AddOn) SetConditions(conditions clusterv1.Conditions) {
+	r.Status.Conditions = conditions
+}
+
+func init() { //nolint:gochecknoinits
+	SchemeBuilder.Register(&RKE2AddOn{}, &RKE2AddOnList{})
+}
@@ -0,0 +1,179 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1validation "k8s.io/apimachinery/pkg/apis/meta/v1/validation"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+// log is for logging in this package.
+var rke2addonlog = logf.Log.WithName("rke2addon-resource")
+
+// SetupWebhookWithManager sets up the Controller Manager for the Webhook for the RKE2AddOn resource.
+func (r *RKE2AddOn) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/validate-controlplane-cluster-x-k8s-io-v1alpha1-rke2addon,mutating=false,failurePolicy=fail,sideEffects=None,groups=controlplane.cluster.x-k8s.io,resources=rke2addons,verbs=create;update,versions=v1alpha1,name=vrke2addon.kb.io,admissionReviewVersions=v1
+
+var _ webhook.Validator = &RKE2AddOn{}
+
+// ValidateCreate implements webhook.Validator so a webhook will be registered for the type.
+func (r *RKE2AddOn) ValidateCreate() error {
+	return r.validate(r.Spec.validate())
+}
+
+// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type.
+func (r *RKE2AddOn) ValidateUpdate(old runtime.Object) error {
+	oldAddOn, ok := old.(*RKE2AddOn)
+	if !ok {
+		return apierrors.NewBadRequest(fmt.Sprintf("expected a RKE2AddOn but got a %T", old))
+	}
+
+	allErrs := r.Spec.validate()
+
+	// The resources applied to the previous cluster would be left behind.
+	if oldAddOn.Spec.ClusterName != r.Spec.ClusterName {
+		allErrs = append(allErrs, field.Forbidden(field.NewPath("spec", "clusterName"), "cannot be changed"))
+	}
+
+	return r.validate(allErrs)
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type.
+func (r *RKE2AddOn) ValidateDelete() error {
+	rke2addonlog.Info("validate delete", "name", r.Name)
+
+	return nil
+}
+
+func (r *RKE2AddOn) validate(allErrs field.ErrorList) error {
+	if len(allErrs) == 0 {
+		return nil
+	}
+
+	return apierrors.NewInvalid(GroupVersion.WithKind("RKE2AddOn").GroupKind(), r.Name, allErrs)
+}
+
+func (s *RKE2AddOnSpec) validate() field.ErrorList {
+	var allErrs field.ErrorList
+
+	specPath := field.NewPath("spec")
+	sources := 0
+
+	if s.Inline != "" {
+		sources++
+	}
+
+	if s.ConfigMapRef != nil {
+		sources++
+	}
+
+	if s.Chart != nil {
+		sources++
+	}
+
+	if sources != 1 {
+		allErrs = append(allErrs, field.Invalid(specPath, sources,
+			"exactly one of inline, configMapRef or chart must be set"))
+	}
+
+	allErrs = append(allErrs, validateWorkloadScheduling(s.Scheduling, specPath.Child("scheduling"))...)
+
+	return allErrs
+}
+
+// validateWorkloadScheduling validates the scheduling of the pods of the manifests deployed to a workload cluster as
+// the API server of the workload cluster would, so that invalid settings are rejected before they are applied.
+func validateWorkloadScheduling(scheduling *WorkloadScheduling, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+
+	if scheduling == nil {
+		return allErrs
+	}
+
+	for i, toleration := range scheduling.Tolerations {
+		allErrs = append(allErrs, validateToleration(toleration, fldPath.Child("tolerations").Index(i))...)
+	}
+
+	allErrs = append(allErrs, metav1validation.ValidateLabels(scheduling.NodeSelector, fldPath.Child("nodeSelector"))...)
+
+	if name := scheduling.PriorityClassName; name != "" {
+		for _, msg := range validation.IsDNS1123Subdomain(name) {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("priorityClassName"), name, msg))
+		}
+	}
+
+	return allErrs
+}
+
+func validateToleration(toleration corev1.Toleration, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+
+	if toleration.Key != "" {
+		for _, msg := range validation.IsQualifiedName(toleration.Key) {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("key"), toleration.Key, msg))
+		}
+	}
+
+	switch toleration.Operator {
+	case corev1.TolerationOpEqual, "":
+		if toleration.Key == "" {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("operator"), toleration.Operator,
+				"must be Exists when key is empty"))
+		}
+
+		for _, msg := range validation.IsValidLabelValue(toleration.Value) {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("value"), toleration.Value, msg))
+		}
+	case corev1.TolerationOpExists:
+		if toleration.Value != "" {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("value"), toleration.Value,
+				"must be empty when operator is Exists"))
+		}
+	default:
+		allErrs = append(allErrs, field.NotSupported(fldPath.Child("operator"), toleration.Operator,
+			[]string{string(corev1.TolerationOpEqual), string(corev1.TolerationOpExists)}))
+	}
+
+	switch toleration.Effect {
+	case corev1.TaintEffectNoExecute:
+	case corev1.TaintEffectNoSchedule, corev1.TaintEffectPreferNoSchedule, "":
+		if toleration.TolerationSeconds != nil {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("effect"), toleration.Effect,
+				"must be NoExecute when tolerationSeconds is set"))
+		}
+	default:
+		allErrs = append(allErrs, field.NotSupported(fldPath.Child("effect"), toleration.Effect,
+			[]string{string(corev1.TaintEffectNoSchedule), string(corev1.TaintEffectPreferNoSchedule),
+				string(corev1.TaintEffectNoExecute)}))
+	}
+
+	return allErrs
+}
@@ -17,26 +17,154 @@ limitations under the License.
 package v1alpha1
 
 import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	admissionv1 "k8s.io/api/admission/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	apivalidation "k8s.io/apimachinery/pkg/api/validation"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/apimachinery/pkg/util/validation/field"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
 	ctrl "sigs.k8s.io/controller-runtime"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+	"sigs.k8s.io/yaml"
 
 	bootstrapv1 "github.com/rancher-sandbox/cluster-api-provider-rke2/bootstrap/api/v1alpha1"
+	"github.com/rancher-sandbox/cluster-api-provider-rke2/pkg/compatibility"
 )
 
 // log is for logging in this package.
 var rke2controlplanelog = logf.Log.WithName("rke2controlplane-resource")
 
-// SetupWebhookWithManager sets up the Controller Manager for the Webhook for the RKE2ControlPlane resource.
-func (r *RKE2ControlPlane) SetupWebhookWithManager(mgr ctrl.Manager) error {
+// etcdDiskPathRegexp matches the paths of the etcd disk device and mount point, they are written to the setup script
+// run as root on the nodes, so only the characters of plain paths are allowed.
+var etcdDiskPathRegexp = regexp.MustCompile(`^/[A-Za-z0-9._/-]+$`)
+
+// VersionDefaults configures the RKE2 version set by the defaulting webhook on the RKE2ControlPlane objects created
+// without one, by the cluster operator on the controller manager.
+// +kubebuilder:object:generate=false
+type VersionDefaults struct {
+	// Version is the default RKE2 version, e.g. v1.26.4+rke2r1.
+	Version string
+
+	// Channel is the RKE2 release channel, e.g. stable or v1.26, whose latest release is the default version when
+	// Version is empty. It is resolved with ResolveChannel when a control plane is created.
+	Channel string
+
+	// ResolveChannel returns the latest RKE2 release of a channel.
+	ResolveChannel func(ctx context.Context, channel string) (string, error)
+}
+
+// ClusterDomainResolver returns the DNS domain of the Cluster of a control plane, or an empty domain when the Cluster
+// isn't known yet.
+// +kubebuilder:object:generate=false
+type ClusterDomainResolver func(ctx context.Context, rcp *RKE2ControlPlane) (string, error)
+
+// SetupWebhookWithManager sets up the Controller Manager for the Webhook for the RKE2ControlPlane resource. The RKE2
+// versions are validated against the compatibility matrix of the source.
+func (r *RKE2ControlPlane) SetupWebhookWithManager(
+	mgr ctrl.Manager,
+	versionDefaults VersionDefaults,
+	resolveClusterDomain ClusterDomainResolver,
+	compatibilitySource *compatibility.Source,
+) error {
 	return ctrl.NewWebhookManagedBy(mgr).
 		For(r).
+		WithDefaulter(&rke2ControlPlaneDefaulter{
+			versionDefaults:      versionDefaults,
+			resolveClusterDomain: resolveClusterDomain,
+		}).
+		WithValidator(&rke2ControlPlaneValidator{compatibilitySource: compatibilitySource}).
 		Complete()
 }
 
+// rke2ControlPlaneDefaulter defaults the RKE2ControlPlane objects, and their version and cluster domain on creation.
+type rke2ControlPlaneDefaulter struct {
+	versionDefaults      VersionDefaults
+	resolveClusterDomain ClusterDomainResolver
+}
+
+var _ admission.CustomDefaulter = &rke2ControlPlaneDefaulter{}
+
+// Default implements admission.CustomDefaulter. The version and the cluster domain are only defaulted when the control
+// plane is created, so that the existing control planes aren't rolled out to another version or domain.
+func (d *rke2ControlPlaneDefaulter) Default(ctx context.Context, obj runtime.Object) error {
+	rcp, ok := obj.(*RKE2ControlPlane)
+	if !ok {
+		return apierrors.NewBadRequest(fmt.Sprintf("expected a RKE2ControlPlane but got a %T", obj))
+	}
+
+	rcp.Default()
+
+	req, err := admission.RequestFromContext(ctx)
+	if err != nil || req.Operation != admissionv1.Create {
+		return nil
+	}
+
+	if err := d.defaultClusterDomain(ctx, rcp); err != nil {
+		return err
+	}
+
+	return d.defaultVersion(ctx, rcp)
+}
+
+// defaultClusterDomain defaults the cluster domain of a created control plane to the DNS domain of its Cluster.
+func (d *rke2ControlPlaneDefaulter) defaultClusterDomain(ctx context.Context, rcp *RKE2ControlPlane) error {
+	if rcp.Spec.ServerConfig.ClusterDomain != "" || d.resolveClusterDomain == nil {
+		return nil
+	}
+
+	domain, err := d.resolveClusterDomain(ctx, rcp)
+	if err != nil {
+		return errors.Wrap(err, "failed to default the cluster domain, set spec.serverConfig.clusterDomain")
+	}
+
+	if domain != "" {
+		rke2controlplanelog.Info("defaulting cluster domain", "name", rcp.Name, "domain", domain)
+		rcp.Spec.ServerConfig.ClusterDomain = domain
+	}
+
+	return nil
+}
+
+// defaultVersion defaults the version of a created control plane.
+func (d *rke2ControlPlaneDefaulter) defaultVersion(ctx context.Context, rcp *RKE2ControlPlane) error {
+	if rcp.Spec.AgentConfig.Version != "" {
+		return nil
+	}
+
+	switch {
+	case d.versionDefaults.Version != "":
+		rcp.Spec.AgentConfig.Version = d.versionDefaults.Version
+	case d.versionDefaults.Channel != "" && d.versionDefaults.ResolveChannel != nil:
+		release, err := d.versionDefaults.ResolveChannel(ctx, d.versionDefaults.Channel)
+		if err != nil {
+			return errors.Wrap(err, "failed to default the rke2 version, set spec.agentConfig.version")
+		}
+
+		rcp.Spec.AgentConfig.Version = release
+	default:
+		return nil
+	}
+
+	rke2controlplanelog.Info("defaulting rke2 version", "name", rcp.Name, "version", rcp.Spec.AgentConfig.Version)
+
+	return nil
+}
+
 //+kubebuilder:webhook:path=/mutate-controlplane-cluster-x-k8s-io-v1alpha1-rke2controlplane,mutating=true,failurePolicy=fail,sideEffects=None,groups=controlplane.cluster.x-k8s.io,resources=rke2controlplanes,verbs=create;update,versions=v1alpha1,name=mrke2controlplane.kb.io,admissionReviewVersions=v1
 
 var _ webhook.Defaulter = &RKE2ControlPlane{}
@@ -44,37 +172,289 @@ var _ webhook.Defaulter = &RKE2ControlPlane{}
 // Default implements webhook.Defaulter so a webhook will be registered for the type.
 func (r *RKE2ControlPlane) Default() {
 	bootstrapv1.DefaultRKE2ConfigSpec(&r.Spec.RKE2ConfigSpec)
+
+	// The desired version is approved by the creation of the control plane.
+	if r.Spec.AgentConfig.Version == "" && r.Spec.DesiredVersion != "" {
+		r.Spec.AgentConfig.Version = r.Spec.DesiredVersion
+	}
+}
+
+//+kubebuilder:webhook:path=/validate-controlplane-cluster-x-k8s-io-v1alpha1-rke2controlplane,mutating=false,failurePolicy=fail,sideEffects=None,groups=controlplane.cluster.x-k8s.io,resources=rke2controlplanes,verbs=create;update;delete,versions=v1alpha1,name=vrke2controlplane.kb.io,admissionReviewVersions=v1
+
+// rke2ControlPlaneValidator validates the RKE2ControlPlane objects, and their RKE2 versions against the compatibility
+// matrix.
+type rke2ControlPlaneValidator struct {
+	compatibilitySource *compatibility.Source
+}
+
+var _ admission.CustomValidator = &rke2ControlPlaneValidator{}
+
+// ValidateCreate implements admission.CustomValidator.
+func (v *rke2ControlPlaneValidator) ValidateCreate(ctx context.Context, obj runtime.Object) error {
+	rcp, ok := obj.(*RKE2ControlPlane)
+	if !ok {
+		return apierrors.NewBadRequest(fmt.Sprintf("expected a RKE2ControlPlane but got a %T", obj))
+	}
+
+	if err := rcp.ValidateCreate(); err != nil {
+		return err
+	}
+
+	return v.validateVersions(ctx, rcp, nil)
+}
+
+// ValidateUpdate implements admission.CustomValidator.
+func (v *rke2ControlPlaneValidator) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) error {
+	rcp, ok := newObj.(*RKE2ControlPlane)
+	if !ok {
+		return apierrors.NewBadRequest(fmt.Sprintf("expected a RKE2ControlPlane but got a %T", newObj))
+	}
+
+	if err := rcp.ValidateUpdate(oldObj); err != nil {
+		return err
+	}
+
+	old, _ := oldObj.(*RKE2ControlPlane)
+
+	return v.validateVersions(ctx, rcp, old)
 }
 
-//+kubebuilder:webhook:path=/validate-controlplane-cluster-x-k8s-io-v1alpha1-rke2controlplane,mutating=false,failurePolicy=fail,sideEffects=None,groups=controlplane.cluster.x-k8s.io,resources=rke2controlplanes,verbs=create;update,versions=v1alpha1,name=vrke2controlplane.kb.io,admissionReviewVersions=v1
+// ValidateDelete implements admission.CustomValidator.
+func (v *rke2ControlPlaneValidator) ValidateDelete(_ context.Context, obj runtime.Object) error {
+	rcp, ok := obj.(*RKE2ControlPlane)
+	if !ok {
+		return apierrors.NewBadRequest(fmt.Sprintf("expected a RKE2ControlPlane but got a %T", obj))
+	}
+
+	return rcp.ValidateDelete()
+}
+
+// validateVersions validates the version and the desired version of the control plane against the compatibility
+// matrix, and rejects the version changes the matrix doesn't support, like downgrades.
+func (v *rke2ControlPlaneValidator) validateVersions(ctx context.Context, rcp, old *RKE2ControlPlane) error {
+	versionPath := field.NewPath("spec", "agentConfig", "version")
+
+	matrix, err := v.compatibilitySource.Matrix(ctx)
+	if err != nil {
+		return apierrors.NewInvalid(GroupVersion.WithKind("RKE2ControlPlane").GroupKind(), rcp.Name, field.ErrorList{
+			field.InternalError(versionPath, err),
+		})
+	}
+
+	allErrs := rcp.Spec.validateVersions(matrix)
+
+	// The machines may already run the approved desired version, changing or removing the desired version before
+	// setting the version to it would roll them back.
+	if old != nil {
+		from, to := old.TargetVersion(), rcp.Spec.targetVersion(old.Status.ApprovedVersion)
+		if from != "" && to != "" && from != to {
+			if err := matrix.ValidateUpgrade(from, to); err != nil {
+				allErrs = append(allErrs, field.Invalid(versionPath, rcp.Spec.AgentConfig.Version, err.Error()))
+			}
+		}
+	}
+
+	if len(allErrs) == 0 {
+		return nil
+	}
 
-var _ webhook.Validator = &RKE2ControlPlane{}
+	return apierrors.NewInvalid(GroupVersion.WithKind("RKE2ControlPlane").GroupKind(), rcp.Name, allErrs)
+}
 
-// ValidateCreate implements webhook.Validator so a webhook will be registered for the type.
+// ValidateCreate validates the control plane, the RKE2 versions aside, which the webhook validates against the
+// compatibility matrix.
 func (r *RKE2ControlPlane) ValidateCreate() error {
 	if bootstrapv1.ValidateRKE2ConfigSpec(r.Name, &r.Spec.RKE2ConfigSpec) != nil {
 		return bootstrapv1.ValidateRKE2ConfigSpec(r.Name, &r.Spec.RKE2ConfigSpec)
 	}
 
+	if err := r.validateReplicas(); err != nil {
+		return err
+	}
+
+	if err := r.validateRestart(); err != nil {
+		return err
+	}
+
 	return ValidateRKE2ControlPlaneSpec(r.Name, &r.Spec)
 }
 
-// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type.
+// ValidateUpdate validates the update of the control plane, the RKE2 versions aside, which the webhook validates
+// against the compatibility matrix.
 func (r *RKE2ControlPlane) ValidateUpdate(old runtime.Object) error {
 	if bootstrapv1.ValidateRKE2ConfigSpec(r.Name, &r.Spec.RKE2ConfigSpec) != nil {
 		return bootstrapv1.ValidateRKE2ConfigSpec(r.Name, &r.Spec.RKE2ConfigSpec)
 	}
 
+	oldControlPlane, ok := old.(*RKE2ControlPlane)
+	if !ok {
+		return apierrors.NewBadRequest(fmt.Sprintf("expected a RKE2ControlPlane but got a %T", old))
+	}
+
+	// The ETCD CA is only provided by the management cluster when the metrics are enabled before initialization.
+	if oldControlPlane.Status.Initialized && oldControlPlane.Spec.ServerConfig.Metrics == nil && r.Spec.ServerConfig.Metrics != nil {
+		return apierrors.NewInvalid(GroupVersion.WithKind("RKE2ControlPlane").GroupKind(), r.Name, field.ErrorList{
+			field.Forbidden(field.NewPath("spec", "serverConfig", "metrics"),
+				"cannot be enabled once the control plane is initialized"),
+		})
+	}
+
+	// The cluster state can't be moved between etcd and an external datastore, nor between external datastores.
+	if oldControlPlane.Status.Initialized &&
+		!reflect.DeepEqual(oldControlPlane.Spec.ServerConfig.ExternalDatastore, r.Spec.ServerConfig.ExternalDatastore) {
+		oldEndpoint, endpoint := "", ""
+		if oldControlPlane.Spec.ServerConfig.ExternalDatastore != nil {
+			oldEndpoint = oldControlPlane.Spec.ServerConfig.ExternalDatastore.Location()
+		}
+
+		if r.Spec.ServerConfig.ExternalDatastore != nil {
+			endpoint = r.Spec.ServerConfig.ExternalDatastore.Location()
+		}
+
+		// The certificates, credentials and connection parameters of the datastore can be changed.
+		if oldEndpoint != endpoint {
+			return apierrors.NewInvalid(GroupVersion.WithKind("RKE2ControlPlane").GroupKind(), r.Name, field.ErrorList{
+				field.Forbidden(field.NewPath("spec", "serverConfig", "externalDatastore"),
+					"cannot be set, unset or moved to another endpoint once the control plane is initialized"),
+			})
+		}
+	}
+
+	// The machines would have to be replaced by machines of another role all at once.
+	if (oldControlPlane.Spec.EtcdReplicas == nil) != (r.Spec.EtcdReplicas == nil) {
+		return apierrors.NewInvalid(GroupVersion.WithKind("RKE2ControlPlane").GroupKind(), r.Name, field.ErrorList{
+			field.Forbidden(field.NewPath("spec", "etcdReplicas"),
+				"cannot be set or unset once the control plane is created"),
+		})
+	}
+
+	if err := r.validateReplicas(); err != nil {
+		return err
+	}
+
+	if err := r.validateRestart(); err != nil {
+		return err
+	}
+
+	if err := r.validateProtection(oldControlPlane); err != nil {
+		return err
+	}
+
 	return ValidateRKE2ControlPlaneSpec(r.Name, &r.Spec)
 }
 
-// ValidateDelete implements webhook.Validator so a webhook will be registered for the type.
+// ValidateDelete rejects the deletion of a protected control plane.
 func (r *RKE2ControlPlane) ValidateDelete() error {
 	rke2controlplanelog.Info("validate delete", "name", r.Name)
 
+	if _, ok := r.Annotations[BreakGlassAnnotation]; !r.Spec.Protected || ok {
+		return nil
+	}
+
+	// The control plane was moved to another management cluster by clusterctl.
+	if _, ok := r.Annotations[DeleteForMoveAnnotation]; ok {
+		return nil
+	}
+
+	return apierrors.NewForbidden(GroupVersion.WithResource("rke2controlplanes").GroupResource(), r.Name,
+		fmt.Errorf("the control plane is protected, set the %s annotation to delete it", BreakGlassAnnotation))
+}
+
+// validateProtection rejects unprotecting a protected control plane, and scaling it down below its minimum protected
+// replicas, unless the break glass annotation is set. The minimum protected replicas can't be lowered along with the
+// replicas, the highest of the old and new minimums applies.
+func (r *RKE2ControlPlane) validateProtection(old *RKE2ControlPlane) error {
+	if _, ok := r.Annotations[BreakGlassAnnotation]; ok || !old.Spec.Protected {
+		return nil
+	}
+
+	var allErrs field.ErrorList
+
+	if !r.Spec.Protected {
+		allErrs = append(allErrs, field.Forbidden(field.NewPath("spec", "protected"),
+			fmt.Sprintf("cannot be unset unless the %s annotation is set", BreakGlassAnnotation)))
+	}
+
+	minReplicas := int32(1)
+
+	for _, spec := range []*RKE2ControlPlaneSpec{&old.Spec, &r.Spec} {
+		if spec.MinProtectedReplicas != nil && *spec.MinProtectedReplicas > minReplicas {
+			minReplicas = *spec.MinProtectedReplicas
+		}
+	}
+
+	// A protected control plane below its minimum replicas can still be scaled up.
+	if r.Spec.Replicas != nil && old.Spec.Replicas != nil &&
+		*r.Spec.Replicas < *old.Spec.Replicas && *r.Spec.Replicas < minReplicas {
+		allErrs = append(allErrs, field.Forbidden(field.NewPath("spec", "replicas"),
+			fmt.Sprintf("cannot be scaled below %d replicas on a protected control plane unless the %s annotation is set",
+				minReplicas, BreakGlassAnnotation)))
+	}
+
+	if len(allErrs) == 0 {
+		return nil
+	}
+
+	return apierrors.NewInvalid(GroupVersion.WithKind("RKE2ControlPlane").GroupKind(), r.Name, allErrs)
+}
+
+// validateReplicas rejects scaling the control plane to zero replicas, unless explicitly allowed by an annotation.
+func (r *RKE2ControlPlane) validateReplicas() error {
+	if r.Spec.Replicas == nil || *r.Spec.Replicas != 0 {
+		return nil
+	}
+
+	if _, ok := r.Annotations[AllowScaleToZeroAnnotation]; ok {
+		return nil
+	}
+
+	return apierrors.NewInvalid(GroupVersion.WithKind("RKE2ControlPlane").GroupKind(), r.Name, field.ErrorList{
+		field.Forbidden(field.NewPath("spec", "replicas"),
+			fmt.Sprintf("cannot be 0 unless the %s annotation is set, scaling to zero deletes all the control plane machines",
+				AllowScaleToZeroAnnotation)),
+	})
+}
+
+// validateRestart rejects a restart annotation which doesn't hold a RFC3339 time, the restart would be ignored.
+func (r *RKE2ControlPlane) validateRestart() error {
+	value, ok := r.Annotations[RestartAnnotation]
+	if !ok {
+		return nil
+	}
+
+	if _, err := time.Parse(time.RFC3339, value); err != nil {
+		return apierrors.NewInvalid(GroupVersion.WithKind("RKE2ControlPlane").GroupKind(), r.Name, field.ErrorList{
+			field.Invalid(field.NewPath("metadata", "annotations").Key(RestartAnnotation), value, "must be a RFC3339 time"),
+		})
+	}
+
 	return nil
 }
 
+// validateVersions validates the version and the desired version against the compatibility matrix.
+func (s *RKE2ControlPlaneSpec) validateVersions(matrix *compatibility.Matrix) field.ErrorList {
+	var allErrs field.ErrorList
+
+	if version := s.AgentConfig.Version; version != "" {
+		if err := matrix.Validate(version); err != nil {
+			allErrs = append(allErrs, field.Invalid(field.NewPath("spec", "agentConfig", "version"), version, err.Error()))
+		}
+	}
+
+	// The desired version is rejected when proposed, rather than when approved.
+	if desired, version := s.DesiredVersion, s.AgentConfig.Version; desired != "" && version != "" && desired != version {
+		desiredPath := field.NewPath("spec", "desiredVersion")
+
+		if err := matrix.Validate(desired); err != nil {
+			allErrs = append(allErrs, field.Invalid(desiredPath, desired, err.Error()))
+		} else if err := matrix.ValidateUpgrade(version, desired); err != nil {
+			allErrs = append(allErrs, field.Invalid(desiredPath, desired, err.Error()))
+		}
+	}
+
+	return allErrs
+}
+
 // ValidateRKE2ControlPlaneSpec validates the RKE2ControlPlaneSpec Object.
 func ValidateRKE2ControlPlaneSpec(name string, spec *RKE2ControlPlaneSpec) error {
 	allErrs := spec.validate()
@@ -89,11 +469,633 @@ func ValidateRKE2ControlPlaneSpec(name string, spec *RKE2ControlPlaneSpec) error
 func (s *RKE2ControlPlaneSpec) validate() field.ErrorList {
 	var allErrs field.ErrorList
 
+	if kind := s.InfrastructureRef.Kind; !strings.HasSuffix(kind, "MachineTemplate") {
+		allErrs = append(allErrs,
+			field.Invalid(field.NewPath("spec", "infrastructureRef", "kind"),
+				kind, "must be an infrastructure machine template kind, e.g. DockerMachineTemplate"))
+	}
+
 	if s.ServerConfig.CNIMultusEnable && s.ServerConfig.CNI == "" {
 		allErrs = append(allErrs,
 			field.Invalid(field.NewPath("spec", "serverConfig", "cni"),
 				s.ServerConfig.CNI, "must be specified when cniMultusEnable is true"))
 	}
 
+	allErrs = append(allErrs, s.validateEtcdDiskSetup()...)
+
+	if s3 := s.ServerConfig.Etcd.BackupConfig.S3; s3 != nil {
+		allErrs = append(allErrs, validateEtcdS3(s3, field.NewPath("spec", "serverConfig", "etcd", "backupConfig", "s3"))...)
+	}
+
+	allErrs = append(allErrs, s.validateCloudController()...)
+	allErrs = append(allErrs, s.validateExternalDatastore()...)
+	allErrs = append(allErrs, s.validateKubeProxy()...)
+
+	if s.ServerConfig.OIDC != nil && s.ServerConfig.KubeAPIServer != nil {
+		for i, arg := range s.ServerConfig.KubeAPIServer.ExtraArgs {
+			if strings.HasPrefix(arg, "oidc-") {
+				allErrs = append(allErrs,
+					field.Forbidden(field.NewPath("spec", "serverConfig", "kubeAPIServer", "extraArgs").Index(i),
+						"oidc arguments must be set in serverConfig.oidc"))
+			}
+		}
+	}
+
+	allErrs = append(allErrs, s.validateEtcdReplicas()...)
+
+	if s.Maintenance && s.Hibernate {
+		allErrs = append(allErrs,
+			field.Forbidden(field.NewPath("spec", "hibernate"), "can't be set when maintenance is true"))
+	}
+
+	allErrs = append(allErrs, s.validateReconcilePeriods()...)
+
+	if tolerance := s.VersionDriftTolerance; tolerance != nil && tolerance.Duration <= 0 {
+		allErrs = append(allErrs,
+			field.Invalid(field.NewPath("spec", "versionDriftTolerance"), tolerance.String(), "must be greater than 0"))
+	}
+
+	if defragmentation := s.ServerConfig.Etcd.Defragmentation; defragmentation != nil &&
+		defragmentation.Interval != nil && defragmentation.Interval.Duration < time.Hour {
+		allErrs = append(allErrs, field.Invalid(field.NewPath("spec", "serverConfig", "etcd", "defragmentation", "interval"),
+			defragmentation.Interval.String(), "must be at least 1h, as the members are unavailable while defragmented"))
+	}
+
+	allErrs = append(allErrs, s.validateImageOverrides()...)
+	allErrs = append(allErrs, s.validateInfrastructureMachineAnnotations()...)
+	allErrs = append(allErrs, s.validateInfrastructureMachineHostname()...)
+	allErrs = append(allErrs, s.validateTLSSan()...)
+	allErrs = append(allErrs, s.validateObservability()...)
+	allErrs = append(allErrs, s.validateRolloutStrategy()...)
+	allErrs = append(allErrs, s.validateHealthCheck()...)
+	allErrs = append(allErrs, s.validateProfile()...)
+
+	return allErrs
+}
+
+// validateProfile validates that the SingleNode profile is only set for a single server, whose roles aren't split.
+func (s *RKE2ControlPlaneSpec) validateProfile() field.ErrorList {
+	var allErrs field.ErrorList
+
+	if !s.IsSingleNode() {
+		return allErrs
+	}
+
+	profilePath := field.NewPath("spec", "profile")
+
+	if s.Replicas != nil && *s.Replicas > 1 {
+		allErrs = append(allErrs, field.Forbidden(profilePath,
+			fmt.Sprintf("%s can't be set with more than 1 replica", SingleNodeControlPlaneProfile)))
+	}
+
+	if s.EtcdReplicas != nil {
+		allErrs = append(allErrs, field.Forbidden(profilePath,
+			fmt.Sprintf("%s can't be set with etcdReplicas, the roles of the server can't be split", SingleNodeControlPlaneProfile)))
+	}
+
+	return allErrs
+}
+
+// validateReconcilePeriods validates that the reconcile periods are positive.
+func (s *RKE2ControlPlaneSpec) validateReconcilePeriods() field.ErrorList {
+	var allErrs field.ErrorList
+
+	periods := s.ReconcilePeriods
+	if periods == nil {
+		return nil
+	}
+
+	if periods.NotReady != nil && periods.NotReady.Duration <= 0 {
+		allErrs = append(allErrs,
+			field.Invalid(field.NewPath("spec", "reconcilePeriods", "notReady"), periods.NotReady.String(), "must be greater than 0"))
+	}
+
+	if periods.Ready != nil && periods.Ready.Duration <= 0 {
+		allErrs = append(allErrs,
+			field.Invalid(field.NewPath("spec", "reconcilePeriods", "ready"), periods.Ready.String(), "must be greater than 0"))
+	}
+
+	return allErrs
+}
+
+// validateEtcdReplicas validates the replicas of the roles when they are split: the API server runs on the replicas
+// only, and etcd keeps its quorum on an odd number of dedicated machines. Both replicas are 0 to scale to zero.
+func (s *RKE2ControlPlaneSpec) validateEtcdReplicas() field.ErrorList {
+	var allErrs field.ErrorList
+
+	if s.EtcdReplicas == nil {
+		return allErrs
+	}
+
+	replicas := s.RoleReplicas(ControlPlaneMachineRole)
+	etcdReplicas := *s.EtcdReplicas
+
+	switch {
+	case replicas == 0 && etcdReplicas != 0:
+		allErrs = append(allErrs,
+			field.Forbidden(field.NewPath("spec", "replicas"), "can't be 0 when etcdReplicas is set, unless etcdReplicas is 0 too"))
+	case etcdReplicas == 0 && replicas != 0:
+		allErrs = append(allErrs,
+			field.Forbidden(field.NewPath("spec", "etcdReplicas"), "can't be 0 unless replicas is 0 too"))
+	case etcdReplicas%2 == 0 && etcdReplicas != 0:
+		allErrs = append(allErrs,
+			field.Invalid(field.NewPath("spec", "etcdReplicas"), etcdReplicas,
+				"must be odd, an even number of etcd members tolerates no more failures than one member less"))
+	}
+
+	return allErrs
+}
+
+// validateHealthCheck validates the settings of the MachineHealthCheck of the control plane machines, ahead of the
+// MachineHealthCheck webhook.
+func (s *RKE2ControlPlaneSpec) validateHealthCheck() field.ErrorList {
+	var allErrs field.ErrorList
+
+	if s.HealthCheck == nil {
+		return allErrs
+	}
+
+	healthCheckPath := field.NewPath("spec", "healthCheck")
+
+	if maxUnhealthy := s.HealthCheck.MaxUnhealthy; maxUnhealthy != nil {
+		if _, err := intstr.GetScaledValueFromIntOrPercent(maxUnhealthy, 0, false); err != nil {
+			allErrs = append(allErrs, field.Invalid(healthCheckPath.Child("maxUnhealthy"), maxUnhealthy.String(), err.Error()))
+		}
+	}
+
+	if timeout := s.HealthCheck.NodeStartupTimeout; timeout != nil && timeout.Duration < 0 {
+		allErrs = append(allErrs,
+			field.Invalid(healthCheckPath.Child("nodeStartupTimeout"), timeout.String(), "must not be negative"))
+	}
+
+	for i, condition := range s.HealthCheck.UnhealthyConditions {
+		if condition.Timeout.Duration < 0 {
+			allErrs = append(allErrs, field.Invalid(healthCheckPath.Child("unhealthyConditions").Index(i).Child("timeout"),
+				condition.Timeout.String(), "must not be negative"))
+		}
+	}
+
+	return allErrs
+}
+
+// validateRolloutStrategy validates that the rolling update is only configured with the RollingUpdate type, that its
+// surge is 0 or 1, as the machines are replaced one at a time, and that the machines running etcd are enough to keep
+// its quorum while one is deleted before its replacement is created.
+func (s *RKE2ControlPlaneSpec) validateRolloutStrategy() field.ErrorList {
+	var allErrs field.ErrorList
+
+	if s.RolloutStrategy != nil && s.RolloutStrategy.RollingUpdate != nil &&
+		s.RolloutStrategyType() != RollingUpdateStrategyType {
+		return append(allErrs, field.Forbidden(field.NewPath("spec", "rolloutStrategy", "rollingUpdate"),
+			fmt.Sprintf("can only be set with the %s type", RollingUpdateStrategyType)))
+	}
+
+	if s.RolloutStrategy == nil || s.RolloutStrategy.RollingUpdate == nil || s.RolloutStrategy.RollingUpdate.MaxSurge == nil {
+		return allErrs
+	}
+
+	maxSurgePath := field.NewPath("spec", "rolloutStrategy", "rollingUpdate", "maxSurge")
+	maxSurge := s.RolloutStrategy.RollingUpdate.MaxSurge
+
+	if maxSurge.Type != intstr.Int || maxSurge.IntValue() < 0 || maxSurge.IntValue() > 1 {
+		return append(allErrs, field.Invalid(maxSurgePath, maxSurge.String(), "must be 0 or 1"))
+	}
+
+	if maxSurge.IntValue() == 0 {
+		if s.Replicas == nil || *s.Replicas < 3 {
+			allErrs = append(allErrs, field.Forbidden(maxSurgePath, "can't be 0 unless replicas is at least 3"))
+		}
+
+		if s.EtcdReplicas != nil && *s.EtcdReplicas < 3 {
+			allErrs = append(allErrs, field.Forbidden(maxSurgePath, "can't be 0 unless etcdReplicas is at least 3"))
+		}
+	}
+
+	return allErrs
+}
+
+// validateObservability validates the scheduling of the observability add-ons, and that their charts can be resolved: a
+// chart name requires its repository, and air-gapped clusters can't reach the default repositories.
+func (s *RKE2ControlPlaneSpec) validateObservability() field.ErrorList {
+	var allErrs field.ErrorList
+
+	if s.Observability == nil {
+		return allErrs
+	}
+
+	observabilityPath := field.NewPath("spec", "observability")
+	addOns := map[string]*ObservabilityAddOn{
+		"nodeProblemDetector": s.Observability.NodeProblemDetector,
+		"logShipping":         s.Observability.LogShipping,
+	}
+
+	for name, addOn := range addOns {
+		if addOn == nil || !addOn.Enabled {
+			continue
+		}
+
+		addOnPath := observabilityPath.Child(name)
+		allErrs = append(allErrs, validateWorkloadScheduling(addOn.Scheduling, addOnPath.Child("scheduling"))...)
+
+		if addOn.Repo != "" || strings.HasPrefix(addOn.Chart, "oci://") {
+			continue
+		}
+
+		switch {
+		case addOn.Chart != "":
+			allErrs = append(allErrs,
+				field.Required(addOnPath.Child("repo"), "must be set when chart is not an OCI reference"))
+		case s.AgentConfig.AirGapped:
+			allErrs = append(allErrs,
+				field.Required(addOnPath.Child("repo"),
+					"must be set to a reachable repository, or chart to an OCI reference, when airGapped is true"))
+		}
+	}
+
+	return allErrs
+}
+
+// validateTLSSan validates that the additional subject alternative names of the server certificate are IP addresses
+// or DNS names, without duplicates. A wildcard is only allowed as the leftmost label of a DNS name with at least two
+// other labels, e.g. *.example.com, as it only matches a single label.
+func (s *RKE2ControlPlaneSpec) validateTLSSan() field.ErrorList {
+	var allErrs field.ErrorList
+
+	tlsSanPath := field.NewPath("spec", "serverConfig", "tlsSan")
+	seen := map[string]bool{}
+
+	for i, san := range s.ServerConfig.TLSSan {
+		normalized := strings.ToLower(san)
+
+		if ip := net.ParseIP(san); ip != nil {
+			normalized = ip.String()
+		} else if strings.HasPrefix(san, "*.") {
+			if errs := validation.IsWildcardDNS1123Subdomain(normalized); len(errs) > 0 {
+				allErrs = append(allErrs, field.Invalid(tlsSanPath.Index(i), san, strings.Join(errs, ", ")))
+
+				continue
+			}
+
+			if strings.Count(san, ".") < 2 {
+				allErrs = append(allErrs, field.Invalid(tlsSanPath.Index(i), san,
+					"a wildcard must be followed by at least two labels, e.g. *.example.com"))
+
+				continue
+			}
+		} else if errs := validation.IsDNS1123Subdomain(normalized); len(errs) > 0 {
+			allErrs = append(allErrs, field.Invalid(tlsSanPath.Index(i), san,
+				"must be an IP address, a DNS name or a wildcard DNS name, e.g. *.example.com"))
+
+			continue
+		}
+
+		if seen[normalized] {
+			allErrs = append(allErrs, field.Duplicate(tlsSanPath.Index(i), san))
+		}
+
+		seen[normalized] = true
+	}
+
+	return allErrs
+}
+
+// validateInfrastructureMachineAnnotations validates the annotations set on the infrastructure machines, the
+// annotations of Cluster API and of the controller can't be overridden.
+func (s *RKE2ControlPlaneSpec) validateInfrastructureMachineAnnotations() field.ErrorList {
+	annotationsPath := field.NewPath("spec", "infrastructureMachineAnnotations")
+	allErrs := apivalidation.ValidateAnnotations(s.InfrastructureMachineAnnotations, annotationsPath)
+
+	for key := range s.InfrastructureMachineAnnotations {
+		domain, _, found := strings.Cut(key, "/")
+		if found && (domain == "cluster.x-k8s.io" || strings.HasSuffix(domain, ".cluster.x-k8s.io")) {
+			allErrs = append(allErrs, field.Forbidden(annotationsPath.Key(key), "Cluster API annotations can't be set"))
+		}
+	}
+
+	return allErrs
+}
+
+// validateInfrastructureMachineHostname validates that the hostname of the machines is passed to the infrastructure
+// machines, and that its format gives valid hostnames.
+func (s *RKE2ControlPlaneSpec) validateInfrastructureMachineHostname() field.ErrorList {
+	var allErrs field.ErrorList
+
+	hostname := s.InfrastructureMachineHostname
+	if hostname == nil {
+		return allErrs
+	}
+
+	hostnamePath := field.NewPath("spec", "infrastructureMachineHostname")
+
+	if hostname.FieldPath == "" && hostname.Annotation == "" {
+		allErrs = append(allErrs, field.Required(hostnamePath, "fieldPath or annotation must be set"))
+	}
+
+	if format := hostname.Format; format != "" {
+		if !strings.Contains(format, "%s") {
+			allErrs = append(allErrs, field.Invalid(hostnamePath.Child("format"), format, "must contain %s"))
+		} else if errs := validation.IsDNS1123Subdomain(strings.ReplaceAll(format, "%s", "machine")); len(errs) > 0 {
+			allErrs = append(allErrs, field.Invalid(hostnamePath.Child("format"), format,
+				"must format a valid hostname: "+strings.Join(errs, ", ")))
+		}
+	}
+
+	if annotation := hostname.Annotation; annotation != "" {
+		annotationPath := hostnamePath.Child("annotation")
+
+		for _, msg := range validation.IsQualifiedName(annotation) {
+			allErrs = append(allErrs, field.Invalid(annotationPath, annotation, msg))
+		}
+
+		if _, found := s.InfrastructureMachineAnnotations[annotation]; found {
+			allErrs = append(allErrs, field.Forbidden(annotationPath, "can't be set in infrastructureMachineAnnotations as well"))
+		}
+	}
+
+	return allErrs
+}
+
+// validateCloudController validates that the cloud provider and the disabled components match the cloud controller
+// manager, so that the nodes aren't left tainted as uninitialized.
+func (s *RKE2ControlPlaneSpec) validateCloudController() field.ErrorList {
+	var allErrs field.ErrorList
+
+	serverConfigPath := field.NewPath("spec", "serverConfig")
+
+	switch s.ServerConfig.CloudController {
+	case EmbeddedCloudController:
+		if s.ServerConfig.CloudProviderName != "" {
+			allErrs = append(allErrs, field.Forbidden(serverConfigPath.Child("cloudProviderName"),
+				"can't be set when the cloud controller is Embedded"))
+		}
+
+		for i, component := range s.ServerConfig.DisableComponents.KubernetesComponents {
+			if component == CloudController {
+				allErrs = append(allErrs, field.Forbidden(
+					serverConfigPath.Child("disableComponents", "kubernetesComponents").Index(i),
+					"the cloud controller can't be disabled when it is Embedded"))
+			}
+		}
+	case ExternalCloudController:
+		if name := s.ServerConfig.CloudProviderName; name != "" && name != ExternalCloudProviderName {
+			allErrs = append(allErrs, field.Invalid(serverConfigPath.Child("cloudProviderName"),
+				name, "must be external or unset when the cloud controller is External"))
+		}
+	}
+
+	if s.ServerConfig.ExternalCloudControllerManifests != nil && s.ServerConfig.CloudController != ExternalCloudController {
+		allErrs = append(allErrs, field.Forbidden(serverConfigPath.Child("externalCloudControllerManifests"),
+			"can only be set when the cloud controller is External"))
+	}
+
+	if s.ServerConfig.ExternalCloudControllerScheduling != nil && s.ServerConfig.ExternalCloudControllerManifests == nil {
+		allErrs = append(allErrs, field.Forbidden(serverConfigPath.Child("externalCloudControllerScheduling"),
+			"can only be set with externalCloudControllerManifests"))
+	}
+
+	allErrs = append(allErrs, validateWorkloadScheduling(s.ServerConfig.ExternalCloudControllerScheduling,
+		serverConfigPath.Child("externalCloudControllerScheduling"))...)
+
+	return allErrs
+}
+
+// validateExternalDatastore validates that the external datastore is either an endpoint or a SQL database, and that
+// etcd isn't configured along with it, as the servers don't run it.
+func (s *RKE2ControlPlaneSpec) validateExternalDatastore() field.ErrorList {
+	var allErrs field.ErrorList
+
+	datastore := s.ServerConfig.ExternalDatastore
+	if datastore == nil {
+		return allErrs
+	}
+
+	serverConfigPath := field.NewPath("spec", "serverConfig")
+	datastorePath := serverConfigPath.Child("externalDatastore")
+
+	configured := 0
+	if datastore.Endpoint != "" {
+		configured++
+	}
+
+	if datastore.Postgres != nil {
+		configured++
+	}
+
+	if datastore.MySQL != nil {
+		configured++
+	}
+
+	switch {
+	case configured == 0:
+		allErrs = append(allErrs, field.Required(datastorePath.Child("endpoint"),
+			"must be specified unless postgres or mysql is"))
+	case configured > 1:
+		allErrs = append(allErrs, field.Forbidden(datastorePath,
+			"only one of endpoint, postgres and mysql can be specified"))
+	}
+
+	if !reflect.DeepEqual(s.ServerConfig.Etcd, EtcdConfig{}) {
+		allErrs = append(allErrs, field.Forbidden(serverConfigPath.Child("etcd"),
+			"can't be set with an external datastore"))
+	}
+
+	if s.ServerConfig.Metrics != nil && len(s.ServerConfig.Metrics.EtcdClientSecrets) > 0 {
+		allErrs = append(allErrs, field.Forbidden(serverConfigPath.Child("metrics", "etcdClientSecrets"),
+			"can't be set with an external datastore, the servers don't run etcd"))
+	}
+
+	if s.EtcdReplicas != nil {
+		allErrs = append(allErrs, field.Forbidden(field.NewPath("spec", "etcdReplicas"),
+			"can't be set with an external datastore, the servers don't run etcd"))
+	}
+
+	return allErrs
+}
+
+// validateKubeProxy validates that the kube-proxy mode matches the disabled components and the CNI, so that the
+// services traffic is proxied either by kube-proxy or by a CNI replacing it, never by both nor by none.
+func (s *RKE2ControlPlaneSpec) validateKubeProxy() field.ErrorList {
+	var allErrs field.ErrorList
+
+	serverConfigPath := field.NewPath("spec", "serverConfig")
+	mode := s.AgentConfig.KubeProxyMode
+
+	if mode != "" && mode != bootstrapv1.DisabledKubeProxyMode {
+		for i, component := range s.ServerConfig.DisableComponents.KubernetesComponents {
+			if component == KubeProxy {
+				allErrs = append(allErrs, field.Forbidden(
+					serverConfigPath.Child("disableComponents", "kubernetesComponents").Index(i),
+					fmt.Sprintf("kube-proxy can't be disabled when agentConfig.kubeProxyMode is %q", mode)))
+			}
+		}
+	}
+
+	if s.ServerConfig.KubeProxyDisabled(mode) {
+		switch s.ServerConfig.CNI {
+		case Cilium, Calico, None:
+		default:
+			allErrs = append(allErrs, field.Invalid(serverConfigPath.Child("cni"), s.ServerConfig.CNI,
+				fmt.Sprintf("must be %s, %s with its eBPF dataplane, or %s for a CNI deployed separately, replacing kube-proxy "+
+					"when it is disabled", Cilium, Calico, None)))
+		}
+
+		return allErrs
+	}
+
+	// The CNI charts configured by the files to replace kube-proxy would proxy the services traffic along with it.
+	for i, file := range s.Files {
+		if replacesKubeProxy(s.ServerConfig.CNI, file.Content) {
+			allErrs = append(allErrs, field.Forbidden(field.NewPath("spec", "files").Index(i).Child("content"),
+				fmt.Sprintf("configures %s to replace kube-proxy, which must be disabled with agentConfig.kubeProxyMode %q",
+					s.ServerConfig.CNI, bootstrapv1.DisabledKubeProxyMode)))
+		}
+	}
+
+	return allErrs
+}
+
+// replacesKubeProxy returns whether the manifest configures the RKE2 chart of the CNI to replace kube-proxy, with the
+// kube-proxy replacement of Cilium or the eBPF dataplane of Calico.
+func replacesKubeProxy(cni CNI, manifest string) bool {
+	if cni != Cilium && cni != Calico {
+		return false
+	}
+
+	reader := utilyaml.NewYAMLReader(bufio.NewReader(strings.NewReader(manifest)))
+
+	for {
+		document, err := reader.Read()
+		if err != nil {
+			// The files that aren't YAML manifests don't configure any chart.
+			return false
+		}
+
+		chartConfig := struct {
+			Kind     string            `json:"kind"`
+			Metadata metav1.ObjectMeta `json:"metadata"`
+			Spec     struct {
+				ValuesContent string `json:"valuesContent"`
+			} `json:"spec"`
+		}{}
+
+		if yaml.Unmarshal(document, &chartConfig) != nil || chartConfig.Kind != "HelmChartConfig" ||
+			chartConfig.Metadata.Name != "rke2-"+string(cni) {
+			continue
+		}
+
+		values := struct {
+			KubeProxyReplacement interface{} `json:"kubeProxyReplacement"`
+			Installation         struct {
+				CalicoNetwork struct {
+					LinuxDataplane string `json:"linuxDataplane"`
+				} `json:"calicoNetwork"`
+			} `json:"installation"`
+		}{}
+
+		if yaml.Unmarshal([]byte(chartConfig.Spec.ValuesContent), &values) != nil {
+			continue
+		}
+
+		switch cni {
+		case Cilium:
+			switch replacement := fmt.Sprint(values.KubeProxyReplacement); replacement {
+			case "true", "strict":
+				return true
+			}
+		case Calico:
+			if strings.EqualFold(values.Installation.CalicoNetwork.LinuxDataplane, "BPF") {
+				return true
+			}
+		}
+	}
+}
+
+// validateImageOverrides validates that the control plane components images are pulled from declared registries.
+func (s *RKE2ControlPlaneSpec) validateImageOverrides() field.ErrorList {
+	var allErrs field.ErrorList
+
+	serverConfigPath := field.NewPath("spec", "serverConfig")
+	overrides := map[*field.Path]string{
+		serverConfigPath.Child("pauseImage"): s.ServerConfig.PauseImage,
+	}
+
+	if s.ServerConfig.Etcd.CustomConfig != nil {
+		overrides[serverConfigPath.Child("etcd", "customConfig", "overrideImage")] = s.ServerConfig.Etcd.CustomConfig.OverrideImage
+	}
+
+	components := map[string]*bootstrapv1.ComponentConfig{
+		"kubeAPIServer":          s.ServerConfig.KubeAPIServer,
+		"kubeControllerManager":  s.ServerConfig.KubeControllerManager,
+		"kubeScheduler":          s.ServerConfig.KubeScheduler,
+		"cloudControllerManager": s.ServerConfig.CloudControllerManager,
+	}
+
+	for name, component := range components {
+		if component != nil {
+			overrides[serverConfigPath.Child(name, "overrideImage")] = component.OverrideImage
+		}
+	}
+
+	for fldPath, image := range overrides {
+		if err := bootstrapv1.ValidateImageRegistry(fldPath, image, s.PrivateRegistriesConfig); err != nil {
+			allErrs = append(allErrs, err)
+		}
+
+		if err := bootstrapv1.ValidateAirGappedImage(fldPath, image, &s.RKE2ConfigSpec); err != nil {
+			allErrs = append(allErrs, err)
+		}
+	}
+
+	return allErrs
+}
+
+// validateEtcdDiskSetup checks that the device and the mount point of the etcd disk are plain paths.
+func (s *RKE2ControlPlaneSpec) validateEtcdDiskSetup() field.ErrorList {
+	diskSetup := s.ServerConfig.Etcd.DiskSetup
+	if diskSetup == nil {
+		return nil
+	}
+
+	allErrs := field.ErrorList{}
+	diskSetupPath := field.NewPath("spec", "serverConfig", "etcd", "diskSetup")
+
+	if !strings.HasPrefix(diskSetup.Device, "/dev/") || !etcdDiskPathRegexp.MatchString(diskSetup.Device) {
+		allErrs = append(allErrs, field.Invalid(diskSetupPath.Child("device"),
+			diskSetup.Device, "must be the path to a block device under /dev/, e.g. /dev/nvme1n1"))
+	}
+
+	if diskSetup.MountPoint != "" && !etcdDiskPathRegexp.MatchString(diskSetup.MountPoint) {
+		allErrs = append(allErrs, field.Invalid(diskSetupPath.Child("mountPoint"),
+			diskSetup.MountPoint, "must be an absolute path made of letters, digits, '.', '_', '-' and '/'"))
+	}
+
+	return allErrs
+}
+
+func validateEtcdS3(s3 *EtcdS3, s3Path *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	if s3.Insecure {
+		if s3.EndpointCASecret != nil {
+			allErrs = append(allErrs, field.Forbidden(s3Path.Child("endpointCAsecret"), "can't be set when insecure is true"))
+		}
+
+		if s3.EnforceSSLVerify {
+			allErrs = append(allErrs, field.Forbidden(s3Path.Child("enforceSslVerify"), "can't be set when insecure is true"))
+		}
+	}
+
+	switch {
+	case s3.IAMAuthentication && s3.S3CredentialSecret.Name != "":
+		allErrs = append(allErrs, field.Forbidden(s3Path.Child("s3CredentialSecret"), "can't be set when iamAuthentication is true"))
+	case !s3.IAMAuthentication && s3.S3CredentialSecret.Name == "":
+		allErrs = append(allErrs, field.Required(s3Path.Child("s3CredentialSecret", "name"),
+			"must be set unless iamAuthentication is true"))
+	}
+
+	if s3.AutoDetectRegion && s3.Region != "" {
+		allErrs = append(allErrs, field.Forbidden(s3Path.Child("region"), "can't be set when autoDetectRegion is true"))
+	}
+
 	return allErrs
 }
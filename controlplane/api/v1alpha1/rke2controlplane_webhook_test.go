@@ -0,0 +1,602 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pkg/errors"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	bootstrapv1 "github.com/rancher-sandbox/cluster-api-provider-rke2/bootstrap/api/v1alpha1"
+	"github.com/rancher-sandbox/cluster-api-provider-rke2/pkg/compatibility"
+)
+
+var _ = Describe("RKE2ControlPlane protection", func() {
+	var old, updated *RKE2ControlPlane
+
+	BeforeEach(func() {
+		old = &RKE2ControlPlane{
+			ObjectMeta: metav1.ObjectMeta{Name: "control-plane"},
+			Spec: RKE2ControlPlaneSpec{
+				Replicas:             pointer.Int32(5),
+				Protected:            true,
+				MinProtectedReplicas: pointer.Int32(3),
+			},
+		}
+		updated = old.DeepCopy()
+	})
+
+	It("should reject scaling below the minimum protected replicas", func() {
+		updated.Spec.Replicas = pointer.Int32(1)
+		Expect(updated.validateProtection(old)).ToNot(Succeed())
+
+		updated.Spec.Replicas = pointer.Int32(3)
+		Expect(updated.validateProtection(old)).To(Succeed())
+	})
+
+	It("should reject lowering the minimum protected replicas along with the replicas", func() {
+		updated.Spec.Replicas = pointer.Int32(1)
+		updated.Spec.MinProtectedReplicas = pointer.Int32(1)
+		Expect(updated.validateProtection(old)).ToNot(Succeed())
+
+		old.Spec.MinProtectedReplicas = pointer.Int32(1)
+		Expect(updated.validateProtection(old)).To(Succeed())
+	})
+
+	It("should reject unprotecting the control plane unless the break glass annotation is set", func() {
+		updated.Spec.Protected = false
+		Expect(updated.validateProtection(old)).ToNot(Succeed())
+
+		updated.Annotations = map[string]string{BreakGlassAnnotation: ""}
+		Expect(updated.validateProtection(old)).To(Succeed())
+	})
+
+	It("should allow scaling up a control plane below its minimum protected replicas", func() {
+		old.Spec.Replicas = pointer.Int32(1)
+		updated.Spec.Replicas = pointer.Int32(2)
+		Expect(updated.validateProtection(old)).To(Succeed())
+	})
+
+	It("should reject deleting the control plane unless it is broken glass or moved by clusterctl", func() {
+		Expect(old.ValidateDelete()).ToNot(Succeed())
+
+		old.Annotations = map[string]string{DeleteForMoveAnnotation: ""}
+		Expect(old.ValidateDelete()).To(Succeed())
+
+		old.Annotations = map[string]string{BreakGlassAnnotation: ""}
+		Expect(old.ValidateDelete()).To(Succeed())
+
+		old.Annotations = nil
+		old.Spec.Protected = false
+		Expect(old.ValidateDelete()).To(Succeed())
+	})
+})
+
+var _ = Describe("RKE2ControlPlane rollout strategy", func() {
+	var spec *RKE2ControlPlaneSpec
+
+	withMaxSurge := func(maxSurge intstr.IntOrString) *RKE2ControlPlaneSpec {
+		spec.RolloutStrategy = &RolloutStrategy{RollingUpdate: &RollingUpdate{MaxSurge: &maxSurge}}
+
+		return spec
+	}
+
+	BeforeEach(func() {
+		spec = &RKE2ControlPlaneSpec{Replicas: pointer.Int32(3)}
+	})
+
+	It("should allow a surge of 0 or 1 with 3 replicas", func() {
+		Expect(withMaxSurge(intstr.FromInt(1)).validateRolloutStrategy()).To(BeEmpty())
+		Expect(withMaxSurge(intstr.FromInt(0)).validateRolloutStrategy()).To(BeEmpty())
+	})
+
+	It("should reject the surges other than 0 or 1", func() {
+		Expect(withMaxSurge(intstr.FromInt(2)).validateRolloutStrategy()).To(HaveLen(1))
+		Expect(withMaxSurge(intstr.FromString("50%")).validateRolloutStrategy()).To(HaveLen(1))
+	})
+
+	It("should reject a surge of 0 when etcd would lose its quorum", func() {
+		spec.Replicas = pointer.Int32(1)
+		Expect(withMaxSurge(intstr.FromInt(0)).validateRolloutStrategy()).To(HaveLen(1))
+		Expect(withMaxSurge(intstr.FromInt(1)).validateRolloutStrategy()).To(BeEmpty())
+
+		spec.Replicas = pointer.Int32(3)
+		spec.EtcdReplicas = pointer.Int32(1)
+		Expect(withMaxSurge(intstr.FromInt(0)).validateRolloutStrategy()).To(HaveLen(1))
+	})
+
+	It("should reject a rolling update with the OnDelete type", func() {
+		spec.RolloutStrategy = &RolloutStrategy{Type: OnDeleteStrategyType, RollingUpdate: &RollingUpdate{}}
+		Expect(spec.validateRolloutStrategy()).To(HaveLen(1))
+	})
+})
+
+var _ = Describe("RKE2ControlPlane version defaulting", func() {
+	var (
+		rcp       *RKE2ControlPlane
+		defaulter *rke2ControlPlaneDefaulter
+	)
+
+	requestContext := func(operation admissionv1.Operation) context.Context {
+		return admission.NewContextWithRequest(context.Background(),
+			admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{Operation: operation}})
+	}
+
+	BeforeEach(func() {
+		rcp = &RKE2ControlPlane{ObjectMeta: metav1.ObjectMeta{Name: "control-plane"}}
+		defaulter = &rke2ControlPlaneDefaulter{versionDefaults: VersionDefaults{
+			Channel: "stable",
+			ResolveChannel: func(_ context.Context, channel string) (string, error) {
+				Expect(channel).To(Equal("stable"))
+
+				return "v1.26.4+rke2r1", nil
+			},
+		}}
+	})
+
+	It("should default the version of a created control plane to the latest release of the channel", func() {
+		Expect(defaulter.Default(requestContext(admissionv1.Create), rcp)).To(Succeed())
+		Expect(rcp.Spec.AgentConfig.Version).To(Equal("v1.26.4+rke2r1"))
+	})
+
+	It("should prefer the default version to the channel", func() {
+		defaulter.versionDefaults.Version = "v1.25.9+rke2r1"
+
+		Expect(defaulter.Default(requestContext(admissionv1.Create), rcp)).To(Succeed())
+		Expect(rcp.Spec.AgentConfig.Version).To(Equal("v1.25.9+rke2r1"))
+	})
+
+	It("should keep the version of the control plane", func() {
+		rcp.Spec.AgentConfig.Version = "v1.24.13+rke2r1"
+
+		Expect(defaulter.Default(requestContext(admissionv1.Create), rcp)).To(Succeed())
+		Expect(rcp.Spec.AgentConfig.Version).To(Equal("v1.24.13+rke2r1"))
+	})
+
+	It("should not default the version of an existing control plane", func() {
+		Expect(defaulter.Default(requestContext(admissionv1.Update), rcp)).To(Succeed())
+		Expect(rcp.Spec.AgentConfig.Version).To(BeEmpty())
+		Expect(rcp.ValidateUpdate(rcp.DeepCopy())).ToNot(MatchError(ContainSubstring("spec.agentConfig.version")))
+	})
+
+	It("should reject the creation when the channel can't be resolved", func() {
+		defaulter.versionDefaults.ResolveChannel = func(context.Context, string) (string, error) {
+			return "", errors.New("connection refused")
+		}
+
+		Expect(defaulter.Default(requestContext(admissionv1.Create), rcp)).To(MatchError(ContainSubstring("connection refused")))
+	})
+
+	It("should accept a created control plane without a version nor defaults", func() {
+		defaulter.versionDefaults = VersionDefaults{}
+
+		Expect(defaulter.Default(requestContext(admissionv1.Create), rcp)).To(Succeed())
+		Expect(rcp.Spec.AgentConfig.Version).To(BeEmpty())
+		Expect(rcp.ValidateCreate()).ToNot(MatchError(ContainSubstring("spec.agentConfig.version")))
+	})
+})
+
+var _ = Describe("RKE2ControlPlane cluster domain defaulting", func() {
+	var (
+		rcp       *RKE2ControlPlane
+		defaulter *rke2ControlPlaneDefaulter
+	)
+
+	requestContext := func(operation admissionv1.Operation) context.Context {
+		return admission.NewContextWithRequest(context.Background(),
+			admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{Operation: operation}})
+	}
+
+	BeforeEach(func() {
+		rcp = &RKE2ControlPlane{ObjectMeta: metav1.ObjectMeta{Name: "control-plane"}}
+		rcp.Spec.AgentConfig.Version = "v1.26.4+rke2r1"
+		defaulter = &rke2ControlPlaneDefaulter{
+			resolveClusterDomain: func(context.Context, *RKE2ControlPlane) (string, error) {
+				return "cluster.example", nil
+			},
+		}
+	})
+
+	It("should default the cluster domain of a created control plane to the domain of its cluster", func() {
+		Expect(defaulter.Default(requestContext(admissionv1.Create), rcp)).To(Succeed())
+		Expect(rcp.Spec.ServerConfig.ClusterDomain).To(Equal("cluster.example"))
+	})
+
+	It("should keep the cluster domain of the control plane", func() {
+		rcp.Spec.ServerConfig.ClusterDomain = "k8s.example"
+
+		Expect(defaulter.Default(requestContext(admissionv1.Create), rcp)).To(Succeed())
+		Expect(rcp.Spec.ServerConfig.ClusterDomain).To(Equal("k8s.example"))
+	})
+
+	It("should not default the cluster domain of an existing control plane", func() {
+		Expect(defaulter.Default(requestContext(admissionv1.Update), rcp)).To(Succeed())
+		Expect(rcp.Spec.ServerConfig.ClusterDomain).To(BeEmpty())
+	})
+
+	It("should reject the creation when the cluster can't be read", func() {
+		defaulter.resolveClusterDomain = func(context.Context, *RKE2ControlPlane) (string, error) {
+			return "", errors.New("connection refused")
+		}
+
+		Expect(defaulter.Default(requestContext(admissionv1.Create), rcp)).To(MatchError(ContainSubstring("connection refused")))
+	})
+})
+
+var _ = Describe("RKE2ControlPlane compatibility", func() {
+	var (
+		ctx    context.Context
+		source *compatibility.Source
+		rcp    *RKE2ControlPlane
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		key := client.ObjectKey{Namespace: "rke2-control-plane-system", Name: "compatibility-matrix"}
+		source = &compatibility.Source{
+			Reader: fake.NewClientBuilder().WithObjects(&corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Namespace: key.Namespace, Name: key.Name},
+				Data:       map[string]string{compatibility.ConfigMapKey: `{minKubernetes: v1.25, maxKubernetes: v1.27}`},
+			}).Build(),
+			ConfigMap: key,
+		}
+
+		rcp = &RKE2ControlPlane{
+			ObjectMeta: metav1.ObjectMeta{Name: "control-plane"},
+			Spec: RKE2ControlPlaneSpec{
+				Replicas:          pointer.Int32(3),
+				InfrastructureRef: corev1.ObjectReference{Kind: "DockerMachineTemplate", Name: "template"},
+			},
+		}
+		rcp.Spec.AgentConfig.Version = "v1.26.4+rke2r1"
+	})
+
+	It("should validate the version against the matrix of the source", func() {
+		validator := &rke2ControlPlaneValidator{compatibilitySource: source}
+		Expect(validator.ValidateCreate(ctx, rcp)).To(Succeed())
+
+		rcp.Spec.AgentConfig.Version = "v1.28.1+rke2r1"
+		Expect(validator.ValidateCreate(ctx, rcp)).To(MatchError(ContainSubstring("newest supported version is v1.27")))
+
+		// The embedded matrix supports the newer versions.
+		Expect((&rke2ControlPlaneValidator{}).ValidateCreate(ctx, rcp)).To(Succeed())
+	})
+
+	It("should reject the upgrades the matrix doesn't support", func() {
+		validator := &rke2ControlPlaneValidator{compatibilitySource: source}
+
+		updated := rcp.DeepCopy()
+		updated.Spec.AgentConfig.Version = "v1.27.1+rke2r1"
+		Expect(validator.ValidateUpdate(ctx, rcp, updated)).To(Succeed())
+
+		updated.Spec.AgentConfig.Version = "v1.25.9+rke2r1"
+		Expect(validator.ValidateUpdate(ctx, rcp, updated)).To(MatchError(ContainSubstring("downgrading")))
+
+		updated.Spec.AgentConfig.Version = rcp.Spec.AgentConfig.Version
+		updated.Spec.DesiredVersion = "v1.28.1+rke2r1"
+		Expect(validator.ValidateUpdate(ctx, rcp, updated)).To(MatchError(ContainSubstring("spec.desiredVersion")))
+	})
+
+	It("should reject removing the approved desired version before the version is set to it", func() {
+		validator := &rke2ControlPlaneValidator{compatibilitySource: source}
+
+		rcp.Spec.DesiredVersion = "v1.27.1+rke2r1"
+		rcp.Status.ApprovedVersion = "v1.27.1+rke2r1"
+
+		updated := rcp.DeepCopy()
+		updated.Spec.DesiredVersion = ""
+		Expect(validator.ValidateUpdate(ctx, rcp, updated)).To(MatchError(ContainSubstring("downgrading")))
+
+		updated.Spec.AgentConfig.Version = "v1.27.1+rke2r1"
+		Expect(validator.ValidateUpdate(ctx, rcp, updated)).To(Succeed())
+	})
+
+	It("should validate the version of an upgrade group against the matrix of the source", func() {
+		group := &RKE2UpgradeGroup{
+			ObjectMeta: metav1.ObjectMeta{Name: "upgrade"},
+			Spec: RKE2UpgradeGroupSpec{
+				Version:  "v1.27.1+rke2r1",
+				Selector: metav1.LabelSelector{MatchLabels: map[string]string{"environment": "staging"}},
+			},
+		}
+
+		validator := &rke2UpgradeGroupValidator{compatibilitySource: source}
+		Expect(validator.ValidateCreate(ctx, group)).To(Succeed())
+
+		group.Spec.Version = "v1.24.13+rke2r1"
+		Expect(validator.ValidateCreate(ctx, group)).To(MatchError(ContainSubstring("oldest supported version is v1.25")))
+	})
+})
+
+var _ = Describe("RKE2ControlPlane kube-proxy", func() {
+	var spec *RKE2ControlPlaneSpec
+
+	BeforeEach(func() {
+		spec = &RKE2ControlPlaneSpec{}
+		spec.AgentConfig.KubeProxyMode = bootstrapv1.DisabledKubeProxyMode
+	})
+
+	It("should allow disabling kube-proxy with the CNIs replacing it", func() {
+		for _, cni := range []CNI{Cilium, Calico, None} {
+			spec.ServerConfig.CNI = cni
+			Expect(spec.validateKubeProxy()).To(BeEmpty(), string(cni))
+		}
+
+		spec.ServerConfig.CNI = Canal
+		Expect(spec.validateKubeProxy()).To(HaveLen(1))
+	})
+
+	It("should reject a CNI configured to replace kube-proxy while kube-proxy is enabled", func() {
+		spec.AgentConfig.KubeProxyMode = bootstrapv1.IPTablesKubeProxyMode
+		spec.ServerConfig.CNI = Cilium
+		spec.Files = []bootstrapv1.File{
+			{Path: "/etc/motd", Content: "welcome"},
+			{Path: "/var/lib/rancher/rke2/server/manifests/rke2-cilium-config.yaml", Content: `---
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: monitoring
+---
+apiVersion: helm.cattle.io/v1
+kind: HelmChartConfig
+metadata:
+  name: rke2-cilium
+  namespace: kube-system
+spec:
+  valuesContent: |-
+    kubeProxyReplacement: strict
+`},
+		}
+
+		errs := spec.validateKubeProxy()
+		Expect(errs).To(HaveLen(1))
+		Expect(errs[0].Field).To(Equal("spec.files[1].content"))
+
+		spec.AgentConfig.KubeProxyMode = bootstrapv1.DisabledKubeProxyMode
+		Expect(spec.validateKubeProxy()).To(BeEmpty())
+	})
+
+	It("should reject the eBPF dataplane of calico while kube-proxy is enabled", func() {
+		spec.AgentConfig.KubeProxyMode = ""
+		spec.ServerConfig.CNI = Calico
+		spec.Files = []bootstrapv1.File{{Path: "/var/lib/rancher/rke2/server/manifests/rke2-calico-config.yaml", Content: `
+apiVersion: helm.cattle.io/v1
+kind: HelmChartConfig
+metadata:
+  name: rke2-calico
+  namespace: kube-system
+spec:
+  valuesContent: |-
+    installation:
+      calicoNetwork:
+        linuxDataplane: BPF
+`}}
+
+		Expect(spec.validateKubeProxy()).To(HaveLen(1))
+
+		spec.Files[0].Content = strings.Replace(spec.Files[0].Content, "BPF", "Iptables", 1)
+		Expect(spec.validateKubeProxy()).To(BeEmpty())
+	})
+})
+
+var _ = Describe("RKE2ControlPlane split roles", func() {
+	var spec *RKE2ControlPlaneSpec
+
+	BeforeEach(func() {
+		spec = &RKE2ControlPlaneSpec{Replicas: pointer.Int32(2), EtcdReplicas: pointer.Int32(3)}
+	})
+
+	It("should require an odd number of etcd replicas", func() {
+		Expect(spec.validateEtcdReplicas()).To(BeEmpty())
+
+		spec.EtcdReplicas = pointer.Int32(4)
+		Expect(spec.validateEtcdReplicas()).To(HaveLen(1))
+	})
+
+	It("should scale both roles to zero together", func() {
+		spec.Replicas = pointer.Int32(0)
+		Expect(spec.validateEtcdReplicas()).To(HaveLen(1))
+
+		spec.EtcdReplicas = pointer.Int32(0)
+		Expect(spec.validateEtcdReplicas()).To(BeEmpty())
+
+		spec.Replicas = pointer.Int32(2)
+		Expect(spec.validateEtcdReplicas()).To(HaveLen(1))
+	})
+})
+
+var _ = Describe("RKE2ControlPlane maintenance", func() {
+	hibernateErrors := func(spec *RKE2ControlPlaneSpec) field.ErrorList {
+		errs := field.ErrorList{}
+
+		for _, err := range spec.validate() {
+			if err.Field == "spec.hibernate" {
+				errs = append(errs, err)
+			}
+		}
+
+		return errs
+	}
+
+	It("should reject hibernating a control plane in maintenance", func() {
+		spec := &RKE2ControlPlaneSpec{Replicas: pointer.Int32(3), Maintenance: true}
+		Expect(hibernateErrors(spec)).To(BeEmpty())
+
+		spec.Hibernate = true
+		Expect(hibernateErrors(spec)).To(HaveLen(1))
+
+		spec.Maintenance = false
+		Expect(hibernateErrors(spec)).To(BeEmpty())
+	})
+})
+
+var _ = Describe("RKE2ControlPlane reconcile periods", func() {
+	It("should allow positive reconcile periods", func() {
+		spec := &RKE2ControlPlaneSpec{ReconcilePeriods: &ReconcilePeriods{
+			NotReady: &metav1.Duration{Duration: 5 * time.Second},
+			Ready:    &metav1.Duration{Duration: time.Hour},
+		}}
+		Expect(spec.validateReconcilePeriods()).To(BeEmpty())
+	})
+
+	It("should reject the reconcile periods that are not positive", func() {
+		spec := &RKE2ControlPlaneSpec{ReconcilePeriods: &ReconcilePeriods{
+			NotReady: &metav1.Duration{},
+			Ready:    &metav1.Duration{Duration: -time.Minute},
+		}}
+
+		errs := spec.validateReconcilePeriods()
+		Expect(errs).To(HaveLen(2))
+		Expect(errs[0].Field).To(Equal("spec.reconcilePeriods.notReady"))
+		Expect(errs[1].Field).To(Equal("spec.reconcilePeriods.ready"))
+	})
+})
+
+var _ = Describe("RKE2ControlPlane external datastore", func() {
+	var spec *RKE2ControlPlaneSpec
+
+	BeforeEach(func() {
+		spec = &RKE2ControlPlaneSpec{
+			ServerConfig: RKE2ServerConfig{
+				ExternalDatastore: &ExternalDatastore{Endpoint: "https://etcd.example.com:2379"},
+			},
+		}
+	})
+
+	It("should allow the metrics of the other components", func() {
+		spec.ServerConfig.Metrics = &ControlPlaneMetrics{}
+		Expect(spec.validateExternalDatastore()).To(BeEmpty())
+	})
+
+	It("should reject exporting the etcd client certificate", func() {
+		spec.ServerConfig.Metrics = &ControlPlaneMetrics{
+			EtcdClientSecrets: []EtcdClientSecret{{Name: "etcd-client", Namespace: "monitoring"}},
+		}
+		Expect(spec.validateExternalDatastore()).To(HaveLen(1))
+	})
+})
+
+var _ = Describe("RKE2ControlPlane etcd disk setup", func() {
+	var spec *RKE2ControlPlaneSpec
+
+	BeforeEach(func() {
+		spec = &RKE2ControlPlaneSpec{}
+		spec.ServerConfig.Etcd.DiskSetup = &EtcdDiskSetup{Device: "/dev/nvme1n1", MountPoint: "/var/lib/etcd-disk"}
+	})
+
+	It("should allow the paths of a device and a mount point", func() {
+		Expect(spec.validateEtcdDiskSetup()).To(BeEmpty())
+
+		spec.ServerConfig.Etcd.DiskSetup.Device = "/dev/disk/by-id/nvme-Amazon_EBS_vol-0123_1"
+		spec.ServerConfig.Etcd.DiskSetup.MountPoint = ""
+		Expect(spec.validateEtcdDiskSetup()).To(BeEmpty())
+	})
+
+	It("should reject a device outside of /dev/", func() {
+		spec.ServerConfig.Etcd.DiskSetup.Device = "/tmp/disk"
+
+		errs := spec.validateEtcdDiskSetup()
+		Expect(errs).To(HaveLen(1))
+		Expect(errs[0].Field).To(Equal("spec.serverConfig.etcd.diskSetup.device"))
+	})
+
+	for _, tc := range []struct {
+		name         string
+		device       string
+		mountPoint   string
+		invalidField string
+	}{
+		{"command substitution in the device", "/dev/$(reboot)", "", "spec.serverConfig.etcd.diskSetup.device"},
+		{"backticks in the device", "/dev/`reboot`", "", "spec.serverConfig.etcd.diskSetup.device"},
+		{"command substitution in the mount point", "/dev/sdb", "/mnt/$(reboot)", "spec.serverConfig.etcd.diskSetup.mountPoint"},
+		{"backticks in the mount point", "/dev/sdb", "/mnt/`reboot`", "spec.serverConfig.etcd.diskSetup.mountPoint"},
+		{"quotes in the mount point", "/dev/sdb", "/mnt/'etcd'", "spec.serverConfig.etcd.diskSetup.mountPoint"},
+		{"a relative mount point", "/dev/sdb", "etcd", "spec.serverConfig.etcd.diskSetup.mountPoint"},
+	} {
+		tc := tc
+
+		It("should reject "+tc.name, func() {
+			spec.ServerConfig.Etcd.DiskSetup.Device = tc.device
+			spec.ServerConfig.Etcd.DiskSetup.MountPoint = tc.mountPoint
+
+			errs := spec.validateEtcdDiskSetup()
+			Expect(errs).To(HaveLen(1))
+			Expect(errs[0].Field).To(Equal(tc.invalidField))
+		})
+	}
+})
+
+var _ = Describe("RKE2ControlPlane etcd S3 backups", func() {
+	var s3 *EtcdS3
+
+	s3Path := field.NewPath("spec", "serverConfig", "etcd", "backupConfig", "s3")
+
+	BeforeEach(func() {
+		s3 = &EtcdS3{
+			Endpoint:           "s3.amazonaws.com",
+			Bucket:             "backups",
+			S3CredentialSecret: corev1.ObjectReference{Name: "s3-credentials", Namespace: "default"},
+		}
+	})
+
+	It("should authenticate with either the IAM credentials or the credentials secret", func() {
+		Expect(validateEtcdS3(s3, s3Path)).To(BeEmpty())
+
+		s3.IAMAuthentication = true
+		errs := validateEtcdS3(s3, s3Path)
+		Expect(errs).To(HaveLen(1))
+		Expect(errs[0].Type).To(Equal(field.ErrorTypeForbidden))
+		Expect(errs[0].Field).To(Equal("spec.serverConfig.etcd.backupConfig.s3.s3CredentialSecret"))
+
+		s3.S3CredentialSecret = corev1.ObjectReference{}
+		Expect(validateEtcdS3(s3, s3Path)).To(BeEmpty())
+
+		s3.IAMAuthentication = false
+		errs = validateEtcdS3(s3, s3Path)
+		Expect(errs).To(HaveLen(1))
+		Expect(errs[0].Type).To(Equal(field.ErrorTypeRequired))
+		Expect(errs[0].Field).To(Equal("spec.serverConfig.etcd.backupConfig.s3.s3CredentialSecret.name"))
+	})
+
+	It("should either detect the region or use the configured one", func() {
+		s3.Region = "eu-west-1"
+		Expect(validateEtcdS3(s3, s3Path)).To(BeEmpty())
+
+		s3.AutoDetectRegion = true
+		errs := validateEtcdS3(s3, s3Path)
+		Expect(errs).To(HaveLen(1))
+		Expect(errs[0].Type).To(Equal(field.ErrorTypeForbidden))
+		Expect(errs[0].Field).To(Equal("spec.serverConfig.etcd.backupConfig.s3.region"))
+
+		s3.Region = ""
+		Expect(validateEtcdS3(s3, s3Path)).To(BeEmpty())
+	})
+})
@@ -20,23 +20,21 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
-// EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
-// NOTE: json tags are required.  Any new fields you add must have json tags for the fields to be serialized.
-
 // RKE2ControlPlaneTemplateSpec defines the desired state of RKE2ControlPlaneTemplate.
 type RKE2ControlPlaneTemplateSpec struct {
-	// INSERT ADDITIONAL SPEC FIELDS - desired state of cluster
-	// Important: Run "make" to regenerate code after modifying this file
+	// Template is the RKE2ControlPlane template, the ClusterClass patches of the control plane target its spec, e.g.
+	// /spec/template/spec/serverConfig/cni.
+	Template RKE2ControlPlaneTemplateResource `json:"template"`
+}
 
-	// Foo is an example field of RKE2ControlPlaneTemplate. Edit rke2controlplanetemplate_types.go to remove/update
-	Foo string `json:"foo,omitempty"`
+// RKE2ControlPlaneTemplateResource describes the data needed to create a RKE2ControlPlane from a template.
+type RKE2ControlPlaneTemplateResource struct {
+	// Spec is the specification of the RKE2ControlPlane created from the template.
+	Spec RKE2ControlPlaneSpec `json:"spec"`
 }
 
 // RKE2ControlPlaneTemplateStatus defines the observed state of RKE2ControlPlaneTemplate.
-type RKE2ControlPlaneTemplateStatus struct {
-	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
-	// Important: Run "make" to regenerate code after modifying this file
-}
+type RKE2ControlPlaneTemplateStatus struct{}
 
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
@@ -0,0 +1,142 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+)
+
+// EtcdSnapshotSource is the location of an etcd snapshot.
+// +kubebuilder:validation:Enum=Local;S3
+type EtcdSnapshotSource string
+
+const (
+	// EtcdSnapshotSourceLocal is a snapshot saved in the etcd snapshot directory of the control plane machine.
+	EtcdSnapshotSourceLocal EtcdSnapshotSource = "Local"
+
+	// EtcdSnapshotSourceS3 is a snapshot uploaded to the S3 bucket of the etcd backup configuration of the control plane.
+	EtcdSnapshotSourceS3 EtcdSnapshotSource = "S3"
+)
+
+// RKE2EtcdSnapshotRestorePhase is the phase of a RKE2EtcdSnapshotRestore.
+type RKE2EtcdSnapshotRestorePhase string
+
+const (
+	// RKE2EtcdSnapshotRestorePending is the phase of a restore that is not started yet.
+	RKE2EtcdSnapshotRestorePending RKE2EtcdSnapshotRestorePhase = "Pending"
+
+	// RKE2EtcdSnapshotRestoreRestoring is the phase of a restore waiting for etcd to be restored on its machine.
+	RKE2EtcdSnapshotRestoreRestoring RKE2EtcdSnapshotRestorePhase = "Restoring"
+
+	// RKE2EtcdSnapshotRestoreRejoiningMachines is the phase of a restore waiting for the other control plane machines to
+	// be recreated and to join the restored etcd.
+	RKE2EtcdSnapshotRestoreRejoiningMachines RKE2EtcdSnapshotRestorePhase = "RejoiningMachines"
+
+	// RKE2EtcdSnapshotRestoreCompleted is the phase of a completed restore.
+	RKE2EtcdSnapshotRestoreCompleted RKE2EtcdSnapshotRestorePhase = "Completed"
+
+	// RKE2EtcdSnapshotRestoreFailed is the phase of a restore that can't be completed.
+	RKE2EtcdSnapshotRestoreFailed RKE2EtcdSnapshotRestorePhase = "Failed"
+)
+
+// RKE2EtcdSnapshotRestoreSpec defines the etcd snapshot a control plane is restored from, the spec is immutable.
+type RKE2EtcdSnapshotRestoreSpec struct {
+	// ClusterName is the name of the Cluster, in the same namespace, whose control plane is restored.
+	// +kubebuilder:validation:MinLength=1
+	ClusterName string `json:"clusterName"`
+
+	// SnapshotName is the name of the snapshot, as listed by "rke2 etcd-snapshot list".
+	// +kubebuilder:validation:Pattern=`^[a-zA-Z0-9][a-zA-Z0-9._-]*$`
+	SnapshotName string `json:"snapshotName"`
+
+	// Source is the location of the snapshot (default: "Local").
+	//+optional
+	Source EtcdSnapshotSource `json:"source,omitempty"`
+
+	// MachineName is the name of the control plane machine etcd is restored on, a local snapshot must be saved on it
+	// (default: the oldest control plane machine with a node).
+	//+optional
+	MachineName string `json:"machineName,omitempty"`
+}
+
+// RKE2EtcdSnapshotRestoreStatus defines the observed state of RKE2EtcdSnapshotRestore.
+type RKE2EtcdSnapshotRestoreStatus struct {
+	// Phase is the phase of the restore.
+	//+optional
+	Phase RKE2EtcdSnapshotRestorePhase `json:"phase,omitempty"`
+
+	// MachineName is the name of the control plane machine etcd is restored on.
+	//+optional
+	MachineName string `json:"machineName,omitempty"`
+
+	// Message explains why the restore failed.
+	//+optional
+	Message string `json:"message,omitempty"`
+
+	// ObservedGeneration is the latest generation observed by the controller.
+	//+optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Conditions defines current service state of the RKE2EtcdSnapshotRestore.
+	//+optional
+	Conditions clusterv1.Conditions `json:"conditions,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Cluster",type="string",JSONPath=".spec.clusterName"
+//+kubebuilder:printcolumn:name="Snapshot",type="string",JSONPath=".spec.snapshotName"
+//+kubebuilder:printcolumn:name="Machine",type="string",JSONPath=".status.machineName"
+//+kubebuilder:printcolumn:name="Phase",type="string",JSONPath=".status.phase"
+//+kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+
+// RKE2EtcdSnapshotRestore is the Schema for the rke2etcdsnapshotrestores API, it restores the control plane of a
+// cluster from an etcd snapshot: rke2-server is stopped on all the control plane machines, etcd is restored on one of
+// them with "rke2 server --cluster-reset", and the other machines are recreated to join the restored etcd. The snapshot
+// is verified before any machine is stopped, and the restore is failed when it isn't completed within 30 minutes.
+type RKE2EtcdSnapshotRestore struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   RKE2EtcdSnapshotRestoreSpec   `json:"spec,omitempty"`
+	Status RKE2EtcdSnapshotRestoreStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// RKE2EtcdSnapshotRestoreList contains a list of RKE2EtcdSnapshotRestore.
+type RKE2EtcdSnapshotRestoreList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []RKE2EtcdSnapshotRestore `json:"items"`
+}
+
+// GetConditions returns the list of conditions for a RKE2EtcdSnapshotRestore object.
+func (r *RKE2EtcdSnapshotRestore) GetConditions() clusterv1.Conditions {
+	return r.Status.Conditions
+}
+
+// SetConditions sets the list of conditions for a RKE2EtcdSnapshotRestore object.
+func (r *RKE2EtcdSnapshotRestore) SetConditions(conditions clusterv1.Conditions) {
+	r.Status.Conditions = conditions
+}
+
+func init() { //nolint:gochecknoinits
+	SchemeBuilder.Register(&RKE2EtcdSnapshotRestore{}, &RKE2EtcdSnapshotRestoreList{})
+}
@@ -0,0 +1,69 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+// log is for logging in this package.
+var rke2etcdsnapshotrestorelog = logf.Log.WithName("rke2etcdsnapshotrestore-resource")
+
+// SetupWebhookWithManager sets up the Controller Manager for the Webhook for the RKE2EtcdSnapshotRestore resource.
+func (r *RKE2EtcdSnapshotRestore) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/validate-controlplane-cluster-x-k8s-io-v1alpha1-rke2etcdsnapshotrestore,mutating=false,failurePolicy=fail,sideEffects=None,groups=controlplane.cluster.x-k8s.io,resources=rke2etcdsnapshotrestores,verbs=create;update,versions=v1alpha1,name=vrke2etcdsnapshotrestore.kb.io,admissionReviewVersions=v1
+
+var _ webhook.Validator = &RKE2EtcdSnapshotRestore{}
+
+// ValidateCreate implements webhook.Validator so a webhook will be registered for the type.
+func (r *RKE2EtcdSnapshotRestore) ValidateCreate() error {
+	return nil
+}
+
+// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type.
+func (r *RKE2EtcdSnapshotRestore) ValidateUpdate(old runtime.Object) error {
+	oldRestore, ok := old.(*RKE2EtcdSnapshotRestore)
+	if !ok {
+		return apierrors.NewBadRequest("expected a RKE2EtcdSnapshotRestore")
+	}
+
+	// A restore in progress can't be redirected to another snapshot or machine.
+	if oldRestore.Spec != r.Spec {
+		return apierrors.NewInvalid(GroupVersion.WithKind("RKE2EtcdSnapshotRestore").GroupKind(), r.Name, field.ErrorList{
+			field.Forbidden(field.NewPath("spec"), "is immutable"),
+		})
+	}
+
+	return nil
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type.
+func (r *RKE2EtcdSnapshotRestore) ValidateDelete() error {
+	rke2etcdsnapshotrestorelog.Info("validate delete", "name", r.Name)
+
+	return nil
+}
@@ -0,0 +1,164 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+)
+
+// RKE2UpgradeGroupSpec defines the RKE2 version rolled out across the selected RKE2ControlPlanes.
+type RKE2UpgradeGroupSpec struct {
+	// Version is the RKE2 version the control planes are upgraded to.
+	// +kubebuilder:validation:MinLength=1
+	Version string `json:"version"`
+
+	// Selector selects the RKE2ControlPlanes, in the same namespace, to upgrade.
+	Selector metav1.LabelSelector `json:"selector"`
+
+	// Waves orders the upgrade of the selected control planes: a control plane belongs to the first wave selecting it,
+	// and a wave is started once all the control planes of the previous waves are upgraded. The control planes no
+	// wave selects are upgraded last.
+	//+optional
+	Waves []RKE2UpgradeWave `json:"waves,omitempty"`
+
+	// MaxConcurrency is the maximum number of control planes upgraded at the same time (default: 1).
+	// +kubebuilder:validation:Minimum=1
+	//+optional
+	MaxConcurrency *int32 `json:"maxConcurrency,omitempty"`
+
+	// MaxFailures is the number of failed control plane upgrades tolerated before the upgrade is halted, no other
+	// control plane is upgraded while the upgrade is halted, even if the failed upgrades recover or complete afterwards.
+	// Raising it, or any other change of the spec, resumes the upgrade (default: 0).
+	// +kubebuilder:validation:Minimum=0
+	//+optional
+	MaxFailures *int32 `json:"maxFailures,omitempty"`
+
+	// UpgradeTimeout is the duration after which the upgrade of a control plane is considered failed if it isn't
+	// completed (default: "1h").
+	//+optional
+	UpgradeTimeout *metav1.Duration `json:"upgradeTimeout,omitempty"`
+
+	// Paused stops upgrading new control planes, the upgrades in progress are not interrupted.
+	//+optional
+	Paused bool `json:"paused,omitempty"`
+}
+
+// RKE2UpgradeWave is a set of control planes upgraded together.
+type RKE2UpgradeWave struct {
+	// Name of the wave.
+	// +kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+
+	// Selector selects the control planes of the wave, among the control planes of the RKE2UpgradeGroup.
+	Selector metav1.LabelSelector `json:"selector"`
+}
+
+// RKE2UpgradeGroupMember is the upgrade state of a control plane of a RKE2UpgradeGroup.
+type RKE2UpgradeGroupMember struct {
+	// Name of the RKE2ControlPlane.
+	Name string `json:"name"`
+
+	// Wave is the name of the wave of the control plane, empty for the control planes no wave selects.
+	//+optional
+	Wave string `json:"wave,omitempty"`
+
+	// StartedAt is the time the upgrade of the control plane started.
+	//+optional
+	StartedAt *metav1.Time `json:"startedAt,omitempty"`
+
+	// Message explains why the upgrade of the control plane failed.
+	//+optional
+	Message string `json:"message,omitempty"`
+}
+
+// RKE2UpgradeGroupStatus defines the observed state of RKE2UpgradeGroup.
+type RKE2UpgradeGroupStatus struct {
+	// Pending are the control planes that are not upgraded yet.
+	//+optional
+	Pending []RKE2UpgradeGroupMember `json:"pending,omitempty"`
+
+	// InProgress are the control planes being upgraded.
+	//+optional
+	InProgress []RKE2UpgradeGroupMember `json:"inProgress,omitempty"`
+
+	// Upgraded are the control planes running the version.
+	//+optional
+	Upgraded []RKE2UpgradeGroupMember `json:"upgraded,omitempty"`
+
+	// Failed are the control planes whose upgrade failed or timed out.
+	//+optional
+	Failed []RKE2UpgradeGroupMember `json:"failed,omitempty"`
+
+	// CurrentWave is the name of the wave being upgraded.
+	//+optional
+	CurrentWave string `json:"currentWave,omitempty"`
+
+	// Halted denotes that the upgrade is halted, as more control plane upgrades failed than tolerated. It is kept
+	// until the spec changes.
+	//+optional
+	Halted bool `json:"halted,omitempty"`
+
+	// ObservedGeneration is the latest generation observed by the controller.
+	//+optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Conditions defines current service state of the RKE2UpgradeGroup.
+	//+optional
+	Conditions clusterv1.Conditions `json:"conditions,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Version",type="string",JSONPath=".spec.version"
+//+kubebuilder:printcolumn:name="Wave",type="string",JSONPath=".status.currentWave"
+//+kubebuilder:printcolumn:name="Halted",type="boolean",JSONPath=".status.halted"
+//+kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+
+// RKE2UpgradeGroup is the Schema for the rke2upgradegroups API, it rolls a RKE2 version out across the
+// RKE2ControlPlanes selected by labels.
+type RKE2UpgradeGroup struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   RKE2UpgradeGroupSpec   `json:"spec,omitempty"`
+	Status RKE2UpgradeGroupStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// RKE2UpgradeGroupList contains a list of RKE2UpgradeGroup.
+type RKE2UpgradeGroupList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []RKE2UpgradeGroup `json:"items"`
+}
+
+// GetConditions returns the list of conditions for a RKE2UpgradeGroup object.
+func (r *RKE2UpgradeGroup) GetConditions() clusterv1.Conditions {
+	return r.Status.Conditions
+}
+
+// SetConditions sets the list of conditions for a RKE2UpgradeGroup object.
+func (r *RKE2UpgradeGroup) SetConditions(conditions clusterv1.Conditions) {
+	r.Status.Conditions = conditions
+}
+
+func init() { //nolint:gochecknoinits
+	SchemeBuilder.Register(&RKE2UpgradeGroup{}, &RKE2UpgradeGroupList{})
+}
@@ -0,0 +1,123 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+	"fmt"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	"github.com/rancher-sandbox/cluster-api-provider-rke2/pkg/compatibility"
+)
+
+// SetupWebhookWithManager sets up the Controller Manager for the Webhook for the RKE2UpgradeGroup resource. The RKE2
+// version is validated against the compatibility matrix of the source.
+func (r *RKE2UpgradeGroup) SetupWebhookWithManager(mgr ctrl.Manager, compatibilitySource *compatibility.Source) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		WithValidator(&rke2UpgradeGroupValidator{compatibilitySource: compatibilitySource}).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/validate-controlplane-cluster-x-k8s-io-v1alpha1-rke2upgradegroup,mutating=false,failurePolicy=fail,sideEffects=None,groups=controlplane.cluster.x-k8s.io,resources=rke2upgradegroups,verbs=create;update,versions=v1alpha1,name=vrke2upgradegroup.kb.io,admissionReviewVersions=v1
+
+// rke2UpgradeGroupValidator validates the RKE2UpgradeGroup objects, and their RKE2 version against the compatibility
+// matrix.
+type rke2UpgradeGroupValidator struct {
+	compatibilitySource *compatibility.Source
+}
+
+var _ admission.CustomValidator = &rke2UpgradeGroupValidator{}
+
+// ValidateCreate implements admission.CustomValidator.
+func (v *rke2UpgradeGroupValidator) ValidateCreate(ctx context.Context, obj runtime.Object) error {
+	return v.validate(ctx, obj)
+}
+
+// ValidateUpdate implements admission.CustomValidator.
+func (v *rke2UpgradeGroupValidator) ValidateUpdate(ctx context.Context, _, newObj runtime.Object) error {
+	return v.validate(ctx, newObj)
+}
+
+// ValidateDelete implements admission.CustomValidator.
+func (v *rke2UpgradeGroupValidator) ValidateDelete(_ context.Context, _ runtime.Object) error {
+	return nil
+}
+
+func (v *rke2UpgradeGroupValidator) validate(ctx context.Context, obj runtime.Object) error {
+	group, ok := obj.(*RKE2UpgradeGroup)
+	if !ok {
+		return apierrors.NewBadRequest(fmt.Sprintf("expected a RKE2UpgradeGroup but got a %T", obj))
+	}
+
+	allErrs := group.Spec.validate()
+	versionPath := field.NewPath("spec", "version")
+
+	if matrix, err := v.compatibilitySource.Matrix(ctx); err != nil {
+		allErrs = append(allErrs, field.InternalError(versionPath, err))
+	} else if err := matrix.Validate(group.Spec.Version); err != nil {
+		allErrs = append(allErrs, field.Invalid(versionPath, group.Spec.Version, err.Error()))
+	}
+
+	if len(allErrs) == 0 {
+		return nil
+	}
+
+	return apierrors.NewInvalid(GroupVersion.WithKind("RKE2UpgradeGroup").GroupKind(), group.Name, allErrs)
+}
+
+func (s *RKE2UpgradeGroupSpec) validate() field.ErrorList {
+	var allErrs field.ErrorList
+
+	specPath := field.NewPath("spec")
+
+	// An empty selector would upgrade all the control planes of the namespace.
+	if len(s.Selector.MatchLabels) == 0 && len(s.Selector.MatchExpressions) == 0 {
+		allErrs = append(allErrs, field.Required(specPath.Child("selector"), "must select the control planes by label"))
+	} else if _, err := metav1.LabelSelectorAsSelector(&s.Selector); err != nil {
+		allErrs = append(allErrs, field.Invalid(specPath.Child("selector"), s.Selector, err.Error()))
+	}
+
+	names := map[string]bool{}
+
+	for i, wave := range s.Waves {
+		wavePath := specPath.Child("waves").Index(i)
+
+		if names[wave.Name] {
+			allErrs = append(allErrs, field.Duplicate(wavePath.Child("name"), wave.Name))
+		}
+
+		names[wave.Name] = true
+
+		if _, err := metav1.LabelSelectorAsSelector(&wave.Selector); err != nil {
+			allErrs = append(allErrs, field.Invalid(wavePath.Child("selector"), wave.Selector, err.Error()))
+		}
+	}
+
+	if s.UpgradeTimeout != nil && s.UpgradeTimeout.Duration <= 0 {
+		allErrs = append(allErrs,
+			field.Invalid(specPath.Child("upgradeTimeout"), s.UpgradeTimeout.String(), "must be greater than 0"))
+	}
+
+	return allErrs
+}
@@ -101,7 +101,7 @@ var _ = BeforeSuite(func() {
 	})
 	Expect(err).NotTo(HaveOccurred())
 
-	err = (&RKE2ControlPlane{}).SetupWebhookWithManager(mgr)
+	err = (&RKE2ControlPlane{}).SetupWebhookWithManager(mgr, VersionDefaults{}, nil, nil)
 	Expect(err).NotTo(HaveOccurred())
 
 	err = (&RKE2ControlPlaneTemplate{}).SetupWebhookWithManager(mgr)
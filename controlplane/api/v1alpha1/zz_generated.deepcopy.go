@@ -23,12 +23,63 @@ package v1alpha1
 
 import (
 	apiv1alpha1 "github.com/rancher-sandbox/cluster-api-provider-rke2/bootstrap/api/v1alpha1"
-	corev1 "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
 	"sigs.k8s.io/cluster-api/api/v1beta1"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ControlPlaneHealthCheck) DeepCopyInto(out *ControlPlaneHealthCheck) {
+	*out = *in
+	if in.UnhealthyConditions != nil {
+		in, out := &in.UnhealthyConditions, &out.UnhealthyConditions
+		*out = make([]v1beta1.UnhealthyCondition, len(*in))
+		copy(*out, *in)
+	}
+	if in.MaxUnhealthy != nil {
+		in, out := &in.MaxUnhealthy, &out.MaxUnhealthy
+		*out = new(intstr.IntOrString)
+		**out = **in
+	}
+	if in.NodeStartupTimeout != nil {
+		in, out := &in.NodeStartupTimeout, &out.NodeStartupTimeout
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ControlPlaneHealthCheck.
+func (in *ControlPlaneHealthCheck) DeepCopy() *ControlPlaneHealthCheck {
+	if in == nil {
+		return nil
+	}
+	out := new(ControlPlaneHealthCheck)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ControlPlaneMetrics) DeepCopyInto(out *ControlPlaneMetrics) {
+	*out = *in
+	if in.EtcdClientSecrets != nil {
+		in, out := &in.EtcdClientSecrets, &out.EtcdClientSecrets
+		*out = make([]EtcdClientSecret, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ControlPlaneMetrics.
+func (in *ControlPlaneMetrics) DeepCopy() *ControlPlaneMetrics {
+	if in == nil {
+		return nil
+	}
+	out := new(ControlPlaneMetrics)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *DisableComponents) DeepCopyInto(out *DisableComponents) {
 	*out = *in
@@ -79,6 +130,21 @@ func (in *EtcdBackupConfig) DeepCopy() *EtcdBackupConfig {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *EtcdClientSecret) DeepCopyInto(out *EtcdClientSecret) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EtcdClientSecret.
+func (in *EtcdClientSecret) DeepCopy() *EtcdClientSecret {
+	if in == nil {
+		return nil
+	}
+	out := new(EtcdClientSecret)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *EtcdConfig) DeepCopyInto(out *EtcdConfig) {
 	*out = *in
@@ -88,6 +154,16 @@ func (in *EtcdConfig) DeepCopyInto(out *EtcdConfig) {
 		*out = new(apiv1alpha1.ComponentConfig)
 		(*in).DeepCopyInto(*out)
 	}
+	if in.DiskSetup != nil {
+		in, out := &in.DiskSetup, &out.DiskSetup
+		*out = new(EtcdDiskSetup)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Defragmentation != nil {
+		in, out := &in.Defragmentation, &out.Defragmentation
+		*out = new(EtcdDefragmentation)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EtcdConfig.
@@ -100,12 +176,72 @@ func (in *EtcdConfig) DeepCopy() *EtcdConfig {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *EtcdDefragmentation) DeepCopyInto(out *EtcdDefragmentation) {
+	*out = *in
+	if in.Interval != nil {
+		in, out := &in.Interval, &out.Interval
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EtcdDefragmentation.
+func (in *EtcdDefragmentation) DeepCopy() *EtcdDefragmentation {
+	if in == nil {
+		return nil
+	}
+	out := new(EtcdDefragmentation)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *EtcdDiskSetup) DeepCopyInto(out *EtcdDiskSetup) {
+	*out = *in
+	if in.IONice != nil {
+		in, out := &in.IONice, &out.IONice
+		*out = new(EtcdIONice)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EtcdDiskSetup.
+func (in *EtcdDiskSetup) DeepCopy() *EtcdDiskSetup {
+	if in == nil {
+		return nil
+	}
+	out := new(EtcdDiskSetup)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *EtcdIONice) DeepCopyInto(out *EtcdIONice) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EtcdIONice.
+func (in *EtcdIONice) DeepCopy() *EtcdIONice {
+	if in == nil {
+		return nil
+	}
+	out := new(EtcdIONice)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *EtcdS3) DeepCopyInto(out *EtcdS3) {
 	*out = *in
 	if in.EndpointCASecret != nil {
 		in, out := &in.EndpointCASecret, &out.EndpointCASecret
-		*out = new(corev1.ObjectReference)
+		*out = new(v1.ObjectReference)
+		**out = **in
+	}
+	if in.Timeout != nil {
+		in, out := &in.Timeout, &out.Timeout
+		*out = new(metav1.Duration)
 		**out = **in
 	}
 	out.S3CredentialSecret = in.S3CredentialSecret
@@ -122,146 +258,171 @@ func (in *EtcdS3) DeepCopy() *EtcdS3 {
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *RKE2ControlPlane) DeepCopyInto(out *RKE2ControlPlane) {
+func (in *ExternalDatastore) DeepCopyInto(out *ExternalDatastore) {
 	*out = *in
-	out.TypeMeta = in.TypeMeta
-	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
-	in.Spec.DeepCopyInto(&out.Spec)
-	in.Status.DeepCopyInto(&out.Status)
+	if in.Postgres != nil {
+		in, out := &in.Postgres, &out.Postgres
+		*out = new(SQLDatastore)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.MySQL != nil {
+		in, out := &in.MySQL, &out.MySQL
+		*out = new(SQLDatastore)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RKE2ControlPlane.
-func (in *RKE2ControlPlane) DeepCopy() *RKE2ControlPlane {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ExternalDatastore.
+func (in *ExternalDatastore) DeepCopy() *ExternalDatastore {
 	if in == nil {
 		return nil
 	}
-	out := new(RKE2ControlPlane)
+	out := new(ExternalDatastore)
 	in.DeepCopyInto(out)
 	return out
 }
 
-// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
-func (in *RKE2ControlPlane) DeepCopyObject() runtime.Object {
-	if c := in.DeepCopy(); c != nil {
-		return c
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *InfrastructureMachineHostname) DeepCopyInto(out *InfrastructureMachineHostname) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new InfrastructureMachineHostname.
+func (in *InfrastructureMachineHostname) DeepCopy() *InfrastructureMachineHostname {
+	if in == nil {
+		return nil
 	}
-	return nil
+	out := new(InfrastructureMachineHostname)
+	in.DeepCopyInto(out)
+	return out
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *RKE2ControlPlaneList) DeepCopyInto(out *RKE2ControlPlaneList) {
+func (in *MachineNode) DeepCopyInto(out *MachineNode) {
 	*out = *in
-	out.TypeMeta = in.TypeMeta
-	in.ListMeta.DeepCopyInto(&out.ListMeta)
-	if in.Items != nil {
-		in, out := &in.Items, &out.Items
-		*out = make([]RKE2ControlPlane, len(*in))
-		for i := range *in {
-			(*in)[i].DeepCopyInto(&(*out)[i])
-		}
+	if in.JoinedAt != nil {
+		in, out := &in.JoinedAt, &out.JoinedAt
+		*out = (*in).DeepCopy()
+	}
+	if in.ProvisioningDuration != nil {
+		in, out := &in.ProvisioningDuration, &out.ProvisioningDuration
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	if in.VersionDriftSince != nil {
+		in, out := &in.VersionDriftSince, &out.VersionDriftSince
+		*out = (*in).DeepCopy()
 	}
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RKE2ControlPlaneList.
-func (in *RKE2ControlPlaneList) DeepCopy() *RKE2ControlPlaneList {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MachineNode.
+func (in *MachineNode) DeepCopy() *MachineNode {
 	if in == nil {
 		return nil
 	}
-	out := new(RKE2ControlPlaneList)
+	out := new(MachineNode)
 	in.DeepCopyInto(out)
 	return out
 }
 
-// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
-func (in *RKE2ControlPlaneList) DeepCopyObject() runtime.Object {
-	if c := in.DeepCopy(); c != nil {
-		return c
-	}
-	return nil
-}
-
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *RKE2ControlPlaneSpec) DeepCopyInto(out *RKE2ControlPlaneSpec) {
+func (in *OIDCConfig) DeepCopyInto(out *OIDCConfig) {
 	*out = *in
-	in.RKE2ConfigSpec.DeepCopyInto(&out.RKE2ConfigSpec)
-	if in.Replicas != nil {
-		in, out := &in.Replicas, &out.Replicas
-		*out = new(int32)
-		**out = **in
+	if in.SigningAlgs != nil {
+		in, out := &in.SigningAlgs, &out.SigningAlgs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
 	}
-	in.ServerConfig.DeepCopyInto(&out.ServerConfig)
-	out.ManifestsConfigMapReference = in.ManifestsConfigMapReference
-	out.InfrastructureRef = in.InfrastructureRef
-	if in.NodeDrainTimeout != nil {
-		in, out := &in.NodeDrainTimeout, &out.NodeDrainTimeout
-		*out = new(v1.Duration)
+	if in.RequiredClaims != nil {
+		in, out := &in.RequiredClaims, &out.RequiredClaims
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.CASecret != nil {
+		in, out := &in.CASecret, &out.CASecret
+		*out = new(v1.ObjectReference)
 		**out = **in
 	}
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RKE2ControlPlaneSpec.
-func (in *RKE2ControlPlaneSpec) DeepCopy() *RKE2ControlPlaneSpec {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OIDCConfig.
+func (in *OIDCConfig) DeepCopy() *OIDCConfig {
 	if in == nil {
 		return nil
 	}
-	out := new(RKE2ControlPlaneSpec)
+	out := new(OIDCConfig)
 	in.DeepCopyInto(out)
 	return out
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *RKE2ControlPlaneStatus) DeepCopyInto(out *RKE2ControlPlaneStatus) {
+func (in *Observability) DeepCopyInto(out *Observability) {
 	*out = *in
-	if in.DataSecretName != nil {
-		in, out := &in.DataSecretName, &out.DataSecretName
-		*out = new(string)
-		**out = **in
+	if in.NodeProblemDetector != nil {
+		in, out := &in.NodeProblemDetector, &out.NodeProblemDetector
+		*out = new(ObservabilityAddOn)
+		(*in).DeepCopyInto(*out)
 	}
-	if in.Conditions != nil {
-		in, out := &in.Conditions, &out.Conditions
-		*out = make(v1beta1.Conditions, len(*in))
-		for i := range *in {
-			(*in)[i].DeepCopyInto(&(*out)[i])
-		}
+	if in.LogShipping != nil {
+		in, out := &in.LogShipping, &out.LogShipping
+		*out = new(ObservabilityAddOn)
+		(*in).DeepCopyInto(*out)
 	}
-	if in.AvailableServerIPs != nil {
-		in, out := &in.AvailableServerIPs, &out.AvailableServerIPs
-		*out = make([]string, len(*in))
-		copy(*out, *in)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Observability.
+func (in *Observability) DeepCopy() *Observability {
+	if in == nil {
+		return nil
 	}
+	out := new(Observability)
+	in.DeepCopyInto(out)
+	return out
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RKE2ControlPlaneStatus.
-func (in *RKE2ControlPlaneStatus) DeepCopy() *RKE2ControlPlaneStatus {
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ObservabilityAddOn) DeepCopyInto(out *ObservabilityAddOn) {
+	*out = *in
+	if in.Scheduling != nil {
+		in, out := &in.Scheduling, &out.Scheduling
+		*out = new(WorkloadScheduling)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ObservabilityAddOn.
+func (in *ObservabilityAddOn) DeepCopy() *ObservabilityAddOn {
 	if in == nil {
 		return nil
 	}
-	out := new(RKE2ControlPlaneStatus)
+	out := new(ObservabilityAddOn)
 	in.DeepCopyInto(out)
 	return out
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *RKE2ControlPlaneTemplate) DeepCopyInto(out *RKE2ControlPlaneTemplate) {
+func (in *RKE2AddOn) DeepCopyInto(out *RKE2AddOn) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
-	out.Spec = in.Spec
-	out.Status = in.Status
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RKE2ControlPlaneTemplate.
-func (in *RKE2ControlPlaneTemplate) DeepCopy() *RKE2ControlPlaneTemplate {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RKE2AddOn.
+func (in *RKE2AddOn) DeepCopy() *RKE2AddOn {
 	if in == nil {
 		return nil
 	}
-	out := new(RKE2ControlPlaneTemplate)
+	out := new(RKE2AddOn)
 	in.DeepCopyInto(out)
 	return out
 }
 
 // DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
-func (in *RKE2ControlPlaneTemplate) DeepCopyObject() runtime.Object {
+func (in *RKE2AddOn) DeepCopyObject() runtime.Object {
 	if c := in.DeepCopy(); c != nil {
 		return c
 	}
@@ -269,31 +430,46 @@ func (in *RKE2ControlPlaneTemplate) DeepCopyObject() runtime.Object {
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *RKE2ControlPlaneTemplateList) DeepCopyInto(out *RKE2ControlPlaneTemplateList) {
+func (in *RKE2AddOnChart) DeepCopyInto(out *RKE2AddOnChart) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RKE2AddOnChart.
+func (in *RKE2AddOnChart) DeepCopy() *RKE2AddOnChart {
+	if in == nil {
+		return nil
+	}
+	out := new(RKE2AddOnChart)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RKE2AddOnList) DeepCopyInto(out *RKE2AddOnList) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ListMeta.DeepCopyInto(&out.ListMeta)
 	if in.Items != nil {
 		in, out := &in.Items, &out.Items
-		*out = make([]RKE2ControlPlaneTemplate, len(*in))
+		*out = make([]RKE2AddOn, len(*in))
 		for i := range *in {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RKE2ControlPlaneTemplateList.
-func (in *RKE2ControlPlaneTemplateList) DeepCopy() *RKE2ControlPlaneTemplateList {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RKE2AddOnList.
+func (in *RKE2AddOnList) DeepCopy() *RKE2AddOnList {
 	if in == nil {
 		return nil
 	}
-	out := new(RKE2ControlPlaneTemplateList)
+	out := new(RKE2AddOnList)
 	in.DeepCopyInto(out)
 	return out
 }
 
 // DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
-func (in *RKE2ControlPlaneTemplateList) DeepCopyObject() runtime.Object {
+func (in *RKE2AddOnList) DeepCopyObject() runtime.Object {
 	if c := in.DeepCopy(); c != nil {
 		return c
 	}
@@ -301,75 +477,588 @@ func (in *RKE2ControlPlaneTemplateList) DeepCopyObject() runtime.Object {
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *RKE2ControlPlaneTemplateSpec) DeepCopyInto(out *RKE2ControlPlaneTemplateSpec) {
+func (in *RKE2AddOnResource) DeepCopyInto(out *RKE2AddOnResource) {
 	*out = *in
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RKE2ControlPlaneTemplateSpec.
-func (in *RKE2ControlPlaneTemplateSpec) DeepCopy() *RKE2ControlPlaneTemplateSpec {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RKE2AddOnResource.
+func (in *RKE2AddOnResource) DeepCopy() *RKE2AddOnResource {
 	if in == nil {
 		return nil
 	}
-	out := new(RKE2ControlPlaneTemplateSpec)
+	out := new(RKE2AddOnResource)
 	in.DeepCopyInto(out)
 	return out
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *RKE2ControlPlaneTemplateStatus) DeepCopyInto(out *RKE2ControlPlaneTemplateStatus) {
+func (in *RKE2AddOnSpec) DeepCopyInto(out *RKE2AddOnSpec) {
 	*out = *in
+	if in.ConfigMapRef != nil {
+		in, out := &in.ConfigMapRef, &out.ConfigMapRef
+		*out = new(v1.LocalObjectReference)
+		**out = **in
+	}
+	if in.Chart != nil {
+		in, out := &in.Chart, &out.Chart
+		*out = new(RKE2AddOnChart)
+		**out = **in
+	}
+	if in.Scheduling != nil {
+		in, out := &in.Scheduling, &out.Scheduling
+		*out = new(WorkloadScheduling)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RKE2ControlPlaneTemplateStatus.
-func (in *RKE2ControlPlaneTemplateStatus) DeepCopy() *RKE2ControlPlaneTemplateStatus {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RKE2AddOnSpec.
+func (in *RKE2AddOnSpec) DeepCopy() *RKE2AddOnSpec {
 	if in == nil {
 		return nil
 	}
-	out := new(RKE2ControlPlaneTemplateStatus)
+	out := new(RKE2AddOnSpec)
 	in.DeepCopyInto(out)
 	return out
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *RKE2ServerConfig) DeepCopyInto(out *RKE2ServerConfig) {
+func (in *RKE2AddOnStatus) DeepCopyInto(out *RKE2AddOnStatus) {
 	*out = *in
-	if in.AuditPolicySecret != nil {
-		in, out := &in.AuditPolicySecret, &out.AuditPolicySecret
-		*out = new(corev1.ObjectReference)
-		**out = **in
-	}
-	if in.TLSSan != nil {
-		in, out := &in.TLSSan, &out.TLSSan
-		*out = make([]string, len(*in))
+	if in.Resources != nil {
+		in, out := &in.Resources, &out.Resources
+		*out = make([]RKE2AddOnResource, len(*in))
 		copy(*out, *in)
 	}
-	in.DisableComponents.DeepCopyInto(&out.DisableComponents)
-	in.Etcd.DeepCopyInto(&out.Etcd)
-	if in.KubeAPIServer != nil {
-		in, out := &in.KubeAPIServer, &out.KubeAPIServer
-		*out = new(apiv1alpha1.ComponentConfig)
-		(*in).DeepCopyInto(*out)
-	}
-	if in.KubeControllerManager != nil {
-		in, out := &in.KubeControllerManager, &out.KubeControllerManager
-		*out = new(apiv1alpha1.ComponentConfig)
-		(*in).DeepCopyInto(*out)
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make(v1beta1.Conditions, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
 	}
-	if in.KubeScheduler != nil {
-		in, out := &in.KubeScheduler, &out.KubeScheduler
-		*out = new(apiv1alpha1.ComponentConfig)
-		(*in).DeepCopyInto(*out)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RKE2AddOnStatus.
+func (in *RKE2AddOnStatus) DeepCopy() *RKE2AddOnStatus {
+	if in == nil {
+		return nil
 	}
-	if in.CloudControllerManager != nil {
+	out := new(RKE2AddOnStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RKE2ControlPlane) DeepCopyInto(out *RKE2ControlPlane) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RKE2ControlPlane.
+func (in *RKE2ControlPlane) DeepCopy() *RKE2ControlPlane {
+	if in == nil {
+		return nil
+	}
+	out := new(RKE2ControlPlane)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *RKE2ControlPlane) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RKE2ControlPlaneList) DeepCopyInto(out *RKE2ControlPlaneList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]RKE2ControlPlane, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RKE2ControlPlaneList.
+func (in *RKE2ControlPlaneList) DeepCopy() *RKE2ControlPlaneList {
+	if in == nil {
+		return nil
+	}
+	out := new(RKE2ControlPlaneList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *RKE2ControlPlaneList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RKE2ControlPlaneSpec) DeepCopyInto(out *RKE2ControlPlaneSpec) {
+	*out = *in
+	in.RKE2ConfigSpec.DeepCopyInto(&out.RKE2ConfigSpec)
+	if in.Replicas != nil {
+		in, out := &in.Replicas, &out.Replicas
+		*out = new(int32)
+		**out = **in
+	}
+	if in.EtcdReplicas != nil {
+		in, out := &in.EtcdReplicas, &out.EtcdReplicas
+		*out = new(int32)
+		**out = **in
+	}
+	in.ServerConfig.DeepCopyInto(&out.ServerConfig)
+	out.ManifestsConfigMapReference = in.ManifestsConfigMapReference
+	out.InfrastructureRef = in.InfrastructureRef
+	if in.NodeDrainTimeout != nil {
+		in, out := &in.NodeDrainTimeout, &out.NodeDrainTimeout
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	if in.RolloutAfter != nil {
+		in, out := &in.RolloutAfter, &out.RolloutAfter
+		*out = (*in).DeepCopy()
+	}
+	if in.RolloutBefore != nil {
+		in, out := &in.RolloutBefore, &out.RolloutBefore
+		*out = new(RolloutBefore)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.InfrastructureMachineAnnotations != nil {
+		in, out := &in.InfrastructureMachineAnnotations, &out.InfrastructureMachineAnnotations
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.InfrastructureMachineHostname != nil {
+		in, out := &in.InfrastructureMachineHostname, &out.InfrastructureMachineHostname
+		*out = new(InfrastructureMachineHostname)
+		**out = **in
+	}
+	if in.VersionDriftTolerance != nil {
+		in, out := &in.VersionDriftTolerance, &out.VersionDriftTolerance
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	if in.RolloutStrategy != nil {
+		in, out := &in.RolloutStrategy, &out.RolloutStrategy
+		*out = new(RolloutStrategy)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ReconcilePeriods != nil {
+		in, out := &in.ReconcilePeriods, &out.ReconcilePeriods
+		*out = new(ReconcilePeriods)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.RegistrationAddresses != nil {
+		in, out := &in.RegistrationAddresses, &out.RegistrationAddresses
+		*out = make([]RegistrationAddress, len(*in))
+		copy(*out, *in)
+	}
+	if in.MinProtectedReplicas != nil {
+		in, out := &in.MinProtectedReplicas, &out.MinProtectedReplicas
+		*out = new(int32)
+		**out = **in
+	}
+	if in.Observability != nil {
+		in, out := &in.Observability, &out.Observability
+		*out = new(Observability)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.HealthCheck != nil {
+		in, out := &in.HealthCheck, &out.HealthCheck
+		*out = new(ControlPlaneHealthCheck)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RKE2ControlPlaneSpec.
+func (in *RKE2ControlPlaneSpec) DeepCopy() *RKE2ControlPlaneSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(RKE2ControlPlaneSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RKE2ControlPlaneStatus) DeepCopyInto(out *RKE2ControlPlaneStatus) {
+	*out = *in
+	if in.DataSecretName != nil {
+		in, out := &in.DataSecretName, &out.DataSecretName
+		*out = new(string)
+		**out = **in
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make(v1beta1.Conditions, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Version != nil {
+		in, out := &in.Version, &out.Version
+		*out = new(string)
+		**out = **in
+	}
+	if in.RolloutReplicas != nil {
+		in, out := &in.RolloutReplicas, &out.RolloutReplicas
+		*out = new(int32)
+		**out = **in
+	}
+	if in.QueuedReplicas != nil {
+		in, out := &in.QueuedReplicas, &out.QueuedReplicas
+		*out = new(int32)
+		**out = **in
+	}
+	if in.EtcdRolloutReplicas != nil {
+		in, out := &in.EtcdRolloutReplicas, &out.EtcdRolloutReplicas
+		*out = new(int32)
+		**out = **in
+	}
+	if in.QueuedEtcdReplicas != nil {
+		in, out := &in.QueuedEtcdReplicas, &out.QueuedEtcdReplicas
+		*out = new(int32)
+		**out = **in
+	}
+	if in.AvailableServerIPs != nil {
+		in, out := &in.AvailableServerIPs, &out.AvailableServerIPs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.LastEtcdDefragmentationTime != nil {
+		in, out := &in.LastEtcdDefragmentationTime, &out.LastEtcdDefragmentationTime
+		*out = (*in).DeepCopy()
+	}
+	if in.EtcdDefragmentedMembers != nil {
+		in, out := &in.EtcdDefragmentedMembers, &out.EtcdDefragmentedMembers
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.MachineNodes != nil {
+		in, out := &in.MachineNodes, &out.MachineNodes
+		*out = make([]MachineNode, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.LastPhaseDurations != nil {
+		in, out := &in.LastPhaseDurations, &out.LastPhaseDurations
+		*out = make(map[ReconcilePhase]metav1.Duration, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.SecretsEncryptionKeyRotation != nil {
+		in, out := &in.SecretsEncryptionKeyRotation, &out.SecretsEncryptionKeyRotation
+		*out = new(SecretsEncryptionKeyRotation)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.LastRolloutTrigger != nil {
+		in, out := &in.LastRolloutTrigger, &out.LastRolloutTrigger
+		*out = new(RolloutTrigger)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RKE2ControlPlaneStatus.
+func (in *RKE2ControlPlaneStatus) DeepCopy() *RKE2ControlPlaneStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(RKE2ControlPlaneStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RKE2ControlPlaneTemplate) DeepCopyInto(out *RKE2ControlPlaneTemplate) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	out.Status = in.Status
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RKE2ControlPlaneTemplate.
+func (in *RKE2ControlPlaneTemplate) DeepCopy() *RKE2ControlPlaneTemplate {
+	if in == nil {
+		return nil
+	}
+	out := new(RKE2ControlPlaneTemplate)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *RKE2ControlPlaneTemplate) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RKE2ControlPlaneTemplateList) DeepCopyInto(out *RKE2ControlPlaneTemplateList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]RKE2ControlPlaneTemplate, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RKE2ControlPlaneTemplateList.
+func (in *RKE2ControlPlaneTemplateList) DeepCopy() *RKE2ControlPlaneTemplateList {
+	if in == nil {
+		return nil
+	}
+	out := new(RKE2ControlPlaneTemplateList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *RKE2ControlPlaneTemplateList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RKE2ControlPlaneTemplateResource) DeepCopyInto(out *RKE2ControlPlaneTemplateResource) {
+	*out = *in
+	in.Spec.DeepCopyInto(&out.Spec)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RKE2ControlPlaneTemplateResource.
+func (in *RKE2ControlPlaneTemplateResource) DeepCopy() *RKE2ControlPlaneTemplateResource {
+	if in == nil {
+		return nil
+	}
+	out := new(RKE2ControlPlaneTemplateResource)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RKE2ControlPlaneTemplateSpec) DeepCopyInto(out *RKE2ControlPlaneTemplateSpec) {
+	*out = *in
+	in.Template.DeepCopyInto(&out.Template)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RKE2ControlPlaneTemplateSpec.
+func (in *RKE2ControlPlaneTemplateSpec) DeepCopy() *RKE2ControlPlaneTemplateSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(RKE2ControlPlaneTemplateSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RKE2ControlPlaneTemplateStatus) DeepCopyInto(out *RKE2ControlPlaneTemplateStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RKE2ControlPlaneTemplateStatus.
+func (in *RKE2ControlPlaneTemplateStatus) DeepCopy() *RKE2ControlPlaneTemplateStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(RKE2ControlPlaneTemplateStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RKE2EtcdSnapshotRestore) DeepCopyInto(out *RKE2EtcdSnapshotRestore) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	out.Spec = in.Spec
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RKE2EtcdSnapshotRestore.
+func (in *RKE2EtcdSnapshotRestore) DeepCopy() *RKE2EtcdSnapshotRestore {
+	if in == nil {
+		return nil
+	}
+	out := new(RKE2EtcdSnapshotRestore)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *RKE2EtcdSnapshotRestore) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RKE2EtcdSnapshotRestoreList) DeepCopyInto(out *RKE2EtcdSnapshotRestoreList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]RKE2EtcdSnapshotRestore, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RKE2EtcdSnapshotRestoreList.
+func (in *RKE2EtcdSnapshotRestoreList) DeepCopy() *RKE2EtcdSnapshotRestoreList {
+	if in == nil {
+		return nil
+	}
+	out := new(RKE2EtcdSnapshotRestoreList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *RKE2EtcdSnapshotRestoreList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RKE2EtcdSnapshotRestoreSpec) DeepCopyInto(out *RKE2EtcdSnapshotRestoreSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RKE2EtcdSnapshotRestoreSpec.
+func (in *RKE2EtcdSnapshotRestoreSpec) DeepCopy() *RKE2EtcdSnapshotRestoreSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(RKE2EtcdSnapshotRestoreSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RKE2EtcdSnapshotRestoreStatus) DeepCopyInto(out *RKE2EtcdSnapshotRestoreStatus) {
+	*out = *in
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make(v1beta1.Conditions, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RKE2EtcdSnapshotRestoreStatus.
+func (in *RKE2EtcdSnapshotRestoreStatus) DeepCopy() *RKE2EtcdSnapshotRestoreStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(RKE2EtcdSnapshotRestoreStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RKE2ServerConfig) DeepCopyInto(out *RKE2ServerConfig) {
+	*out = *in
+	if in.AuditPolicySecret != nil {
+		in, out := &in.AuditPolicySecret, &out.AuditPolicySecret
+		*out = new(v1.ObjectReference)
+		**out = **in
+	}
+	if in.TLSSan != nil {
+		in, out := &in.TLSSan, &out.TLSSan
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	in.DisableComponents.DeepCopyInto(&out.DisableComponents)
+	in.Etcd.DeepCopyInto(&out.Etcd)
+	if in.ExternalDatastore != nil {
+		in, out := &in.ExternalDatastore, &out.ExternalDatastore
+		*out = new(ExternalDatastore)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.KubeAPIServer != nil {
+		in, out := &in.KubeAPIServer, &out.KubeAPIServer
+		*out = new(apiv1alpha1.ComponentConfig)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.OIDC != nil {
+		in, out := &in.OIDC, &out.OIDC
+		*out = new(OIDCConfig)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.KubeControllerManager != nil {
+		in, out := &in.KubeControllerManager, &out.KubeControllerManager
+		*out = new(apiv1alpha1.ComponentConfig)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.KubeScheduler != nil {
+		in, out := &in.KubeScheduler, &out.KubeScheduler
+		*out = new(apiv1alpha1.ComponentConfig)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.KubeSchedulerConfigMap != nil {
+		in, out := &in.KubeSchedulerConfigMap, &out.KubeSchedulerConfigMap
+		*out = new(v1.ObjectReference)
+		**out = **in
+	}
+	if in.CloudControllerManager != nil {
 		in, out := &in.CloudControllerManager, &out.CloudControllerManager
 		*out = new(apiv1alpha1.ComponentConfig)
 		(*in).DeepCopyInto(*out)
 	}
+	if in.ExternalCloudControllerManifests != nil {
+		in, out := &in.ExternalCloudControllerManifests, &out.ExternalCloudControllerManifests
+		*out = new(v1.ObjectReference)
+		**out = **in
+	}
+	if in.ExternalCloudControllerScheduling != nil {
+		in, out := &in.ExternalCloudControllerScheduling, &out.ExternalCloudControllerScheduling
+		*out = new(WorkloadScheduling)
+		(*in).DeepCopyInto(*out)
+	}
 	if in.CloudProviderConfigMap != nil {
 		in, out := &in.CloudProviderConfigMap, &out.CloudProviderConfigMap
-		*out = new(corev1.ObjectReference)
+		*out = new(v1.ObjectReference)
 		**out = **in
 	}
+	if in.Metrics != nil {
+		in, out := &in.Metrics, &out.Metrics
+		*out = new(ControlPlaneMetrics)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RKE2ServerConfig.
@@ -381,3 +1070,384 @@ func (in *RKE2ServerConfig) DeepCopy() *RKE2ServerConfig {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RKE2UpgradeGroup) DeepCopyInto(out *RKE2UpgradeGroup) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RKE2UpgradeGroup.
+func (in *RKE2UpgradeGroup) DeepCopy() *RKE2UpgradeGroup {
+	if in == nil {
+		return nil
+	}
+	out := new(RKE2UpgradeGroup)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *RKE2UpgradeGroup) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RKE2UpgradeGroupList) DeepCopyInto(out *RKE2UpgradeGroupList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]RKE2UpgradeGroup, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RKE2UpgradeGroupList.
+func (in *RKE2UpgradeGroupList) DeepCopy() *RKE2UpgradeGroupList {
+	if in == nil {
+		return nil
+	}
+	out := new(RKE2UpgradeGroupList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *RKE2UpgradeGroupList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RKE2UpgradeGroupMember) DeepCopyInto(out *RKE2UpgradeGroupMember) {
+	*out = *in
+	if in.StartedAt != nil {
+		in, out := &in.StartedAt, &out.StartedAt
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RKE2UpgradeGroupMember.
+func (in *RKE2UpgradeGroupMember) DeepCopy() *RKE2UpgradeGroupMember {
+	if in == nil {
+		return nil
+	}
+	out := new(RKE2UpgradeGroupMember)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RKE2UpgradeGroupSpec) DeepCopyInto(out *RKE2UpgradeGroupSpec) {
+	*out = *in
+	in.Selector.DeepCopyInto(&out.Selector)
+	if in.Waves != nil {
+		in, out := &in.Waves, &out.Waves
+		*out = make([]RKE2UpgradeWave, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.MaxConcurrency != nil {
+		in, out := &in.MaxConcurrency, &out.MaxConcurrency
+		*out = new(int32)
+		**out = **in
+	}
+	if in.MaxFailures != nil {
+		in, out := &in.MaxFailures, &out.MaxFailures
+		*out = new(int32)
+		**out = **in
+	}
+	if in.UpgradeTimeout != nil {
+		in, out := &in.UpgradeTimeout, &out.UpgradeTimeout
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RKE2UpgradeGroupSpec.
+func (in *RKE2UpgradeGroupSpec) DeepCopy() *RKE2UpgradeGroupSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(RKE2UpgradeGroupSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RKE2UpgradeGroupStatus) DeepCopyInto(out *RKE2UpgradeGroupStatus) {
+	*out = *in
+	if in.Pending != nil {
+		in, out := &in.Pending, &out.Pending
+		*out = make([]RKE2UpgradeGroupMember, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.InProgress != nil {
+		in, out := &in.InProgress, &out.InProgress
+		*out = make([]RKE2UpgradeGroupMember, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Upgraded != nil {
+		in, out := &in.Upgraded, &out.Upgraded
+		*out = make([]RKE2UpgradeGroupMember, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Failed != nil {
+		in, out := &in.Failed, &out.Failed
+		*out = make([]RKE2UpgradeGroupMember, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make(v1beta1.Conditions, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RKE2UpgradeGroupStatus.
+func (in *RKE2UpgradeGroupStatus) DeepCopy() *RKE2UpgradeGroupStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(RKE2UpgradeGroupStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RKE2UpgradeWave) DeepCopyInto(out *RKE2UpgradeWave) {
+	*out = *in
+	in.Selector.DeepCopyInto(&out.Selector)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RKE2UpgradeWave.
+func (in *RKE2UpgradeWave) DeepCopy() *RKE2UpgradeWave {
+	if in == nil {
+		return nil
+	}
+	out := new(RKE2UpgradeWave)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ReconcilePeriods) DeepCopyInto(out *ReconcilePeriods) {
+	*out = *in
+	if in.NotReady != nil {
+		in, out := &in.NotReady, &out.NotReady
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	if in.Ready != nil {
+		in, out := &in.Ready, &out.Ready
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ReconcilePeriods.
+func (in *ReconcilePeriods) DeepCopy() *ReconcilePeriods {
+	if in == nil {
+		return nil
+	}
+	out := new(ReconcilePeriods)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RegistrationAddress) DeepCopyInto(out *RegistrationAddress) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RegistrationAddress.
+func (in *RegistrationAddress) DeepCopy() *RegistrationAddress {
+	if in == nil {
+		return nil
+	}
+	out := new(RegistrationAddress)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RollingUpdate) DeepCopyInto(out *RollingUpdate) {
+	*out = *in
+	if in.MaxSurge != nil {
+		in, out := &in.MaxSurge, &out.MaxSurge
+		*out = new(intstr.IntOrString)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RollingUpdate.
+func (in *RollingUpdate) DeepCopy() *RollingUpdate {
+	if in == nil {
+		return nil
+	}
+	out := new(RollingUpdate)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RolloutBefore) DeepCopyInto(out *RolloutBefore) {
+	*out = *in
+	if in.CertificatesExpiryDays != nil {
+		in, out := &in.CertificatesExpiryDays, &out.CertificatesExpiryDays
+		*out = new(int32)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RolloutBefore.
+func (in *RolloutBefore) DeepCopy() *RolloutBefore {
+	if in == nil {
+		return nil
+	}
+	out := new(RolloutBefore)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RolloutStrategy) DeepCopyInto(out *RolloutStrategy) {
+	*out = *in
+	if in.RollingUpdate != nil {
+		in, out := &in.RollingUpdate, &out.RollingUpdate
+		*out = new(RollingUpdate)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RolloutStrategy.
+func (in *RolloutStrategy) DeepCopy() *RolloutStrategy {
+	if in == nil {
+		return nil
+	}
+	out := new(RolloutStrategy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RolloutTrigger) DeepCopyInto(out *RolloutTrigger) {
+	*out = *in
+	if in.ChangeTime != nil {
+		in, out := &in.ChangeTime, &out.ChangeTime
+		*out = (*in).DeepCopy()
+	}
+	in.RolloutTime.DeepCopyInto(&out.RolloutTime)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RolloutTrigger.
+func (in *RolloutTrigger) DeepCopy() *RolloutTrigger {
+	if in == nil {
+		return nil
+	}
+	out := new(RolloutTrigger)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SQLDatastore) DeepCopyInto(out *SQLDatastore) {
+	*out = *in
+	if in.Parameters != nil {
+		in, out := &in.Parameters, &out.Parameters
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SQLDatastore.
+func (in *SQLDatastore) DeepCopy() *SQLDatastore {
+	if in == nil {
+		return nil
+	}
+	out := new(SQLDatastore)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SecretsEncryptionKeyRotation) DeepCopyInto(out *SecretsEncryptionKeyRotation) {
+	*out = *in
+	if in.RestartedMachines != nil {
+		in, out := &in.RestartedMachines, &out.RestartedMachines
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.StartTime != nil {
+		in, out := &in.StartTime, &out.StartTime
+		*out = (*in).DeepCopy()
+	}
+	if in.CompletionTime != nil {
+		in, out := &in.CompletionTime, &out.CompletionTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SecretsEncryptionKeyRotation.
+func (in *SecretsEncryptionKeyRotation) DeepCopy() *SecretsEncryptionKeyRotation {
+	if in == nil {
+		return nil
+	}
+	out := new(SecretsEncryptionKeyRotation)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WorkloadScheduling) DeepCopyInto(out *WorkloadScheduling) {
+	*out = *in
+	if in.Tolerations != nil {
+		in, out := &in.Tolerations, &out.Tolerations
+		*out = make([]v1.Toleration, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.NodeSelector != nil {
+		in, out := &in.NodeSelector, &out.NodeSelector
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WorkloadScheduling.
+func (in *WorkloadScheduling) DeepCopy() *WorkloadScheduling {
+	if in == nil {
+		return nil
+	}
+	out := new(WorkloadScheduling)
+	in.DeepCopyInto(out)
+	return out
+}
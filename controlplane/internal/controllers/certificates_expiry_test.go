@@ -0,0 +1,132 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+
+	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+	"github.com/rancher-sandbox/cluster-api-provider-rke2/pkg/rke2"
+	"github.com/rancher-sandbox/cluster-api-provider-rke2/pkg/secret"
+)
+
+var _ = Describe("certificates expiry", func() {
+	var (
+		env            *testEnvironment
+		workloadClient client.Client
+		servingCert    []byte
+		notAfter       time.Time
+	)
+
+	ctx := context.Background()
+
+	BeforeEach(func() {
+		caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		Expect(err).ToNot(HaveOccurred())
+
+		ca := &x509.Certificate{
+			SerialNumber:          big.NewInt(1),
+			Subject:               pkix.Name{CommonName: "rke2-server-ca"},
+			NotBefore:             time.Now().Add(-time.Hour),
+			NotAfter:              time.Now().Add(10 * 365 * 24 * time.Hour),
+			IsCA:                  true,
+			BasicConstraintsValid: true,
+			KeyUsage:              x509.KeyUsageCertSign,
+		}
+		caCert, err := x509.CreateCertificate(rand.Reader, ca, ca, &caKey.PublicKey, caKey)
+		Expect(err).ToNot(HaveOccurred())
+
+		notAfter = time.Now().Add(365 * 24 * time.Hour).Truncate(time.Second).UTC()
+		serving := &x509.Certificate{
+			SerialNumber: big.NewInt(2),
+			Subject:      pkix.Name{CommonName: "kube-apiserver"},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     notAfter,
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		}
+		servingRaw, err := x509.CreateCertificate(rand.Reader, serving, ca, &caKey.PublicKey, caKey)
+		Expect(err).ToNot(HaveOccurred())
+		servingCert = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: servingRaw})
+
+		workloadClient = fake.NewClientBuilder().WithScheme(newTestScheme()).Build()
+		env = newTestEnvironment(2, workloadClient, &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: metav1.NamespaceDefault, Name: secret.Name("cluster", secret.ClusterCA)},
+			Data:       map[string][]byte{secret.TLSCrtDataName: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caCert})},
+		})
+	})
+
+	It("should read the certificates expiry of the machines not checked recently", func() {
+		checked := newControlPlaneMachine(env, "machine-1")
+		checked.Annotations = map[string]string{
+			controlplanev1.CertificatesExpiryCheckedAtAnnotation: time.Now().Add(-time.Hour).UTC().Format(time.RFC3339),
+		}
+		env.createMachines(checked, newControlPlaneMachine(env, "machine-2"))
+
+		Expect(env.Reconciler.reconcileCertificatesExpiry(ctx, env.controlPlane())).To(Succeed())
+
+		jobs := &batchv1.JobList{}
+		Expect(workloadClient.List(ctx, jobs, client.InNamespace(rke2.HibernationNamespace))).To(Succeed())
+		Expect(jobs.Items).To(HaveLen(1))
+		Expect(jobs.Items[0].Spec.Template.Spec.NodeName).To(Equal("machine-2"))
+
+		completeJob(workloadClient, &jobs.Items[0], string(servingCert))
+
+		Expect(env.Reconciler.reconcileCertificatesExpiry(ctx, env.controlPlane())).To(Succeed())
+		Expect(env.machine("machine-2").Annotations).To(And(
+			HaveKeyWithValue(clusterv1.MachineCertificatesExpiryDateAnnotation, notAfter.Format(time.RFC3339)),
+			HaveKey(controlplanev1.CertificatesExpiryCheckedAtAnnotation),
+		))
+		Expect(env.machine("machine-1").Annotations).ToNot(HaveKey(clusterv1.MachineCertificatesExpiryDateAnnotation))
+	})
+
+	It("should not read the certificates of a machine again while its failed job is kept", func() {
+		env.createMachines(newControlPlaneMachine(env, "machine-1"))
+
+		Expect(env.Reconciler.reconcileCertificatesExpiry(ctx, env.controlPlane())).To(Succeed())
+
+		jobs := &batchv1.JobList{}
+		Expect(workloadClient.List(ctx, jobs, client.InNamespace(rke2.HibernationNamespace))).To(Succeed())
+		Expect(jobs.Items).To(HaveLen(1))
+
+		jobs.Items[0].Status.Failed = 1
+		Expect(workloadClient.Status().Update(ctx, &jobs.Items[0])).To(Succeed())
+
+		Expect(env.Reconciler.reconcileCertificatesExpiry(ctx, env.controlPlane())).To(Succeed())
+		Expect(workloadClient.List(ctx, jobs, client.InNamespace(rke2.HibernationNamespace))).To(Succeed())
+		Expect(jobs.Items).To(HaveLen(1))
+		Expect(jobs.Items[0].Status.Failed).To(BeEquivalentTo(1))
+	})
+})
@@ -16,7 +16,11 @@ limitations under the License.
 
 package controllers
 
-import "time"
+import (
+	"time"
+
+	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+)
 
 const (
 	// deleteRequeueAfter is how long to wait before checking again to see if
@@ -26,4 +30,60 @@ const (
 	// preflightFailedRequeueAfter is how long to wait before trying to scale
 	// up/down if some preflight check for those operation has failed.
 	preflightFailedRequeueAfter = 15 * time.Second
+
+	// infrastructureMinRequeueAfter and infrastructureMaxRequeueAfter bound how long to wait before trying again to
+	// create a control plane machine when the infrastructure quota or capacity is exhausted, or when the
+	// infrastructure provider is not available.
+	infrastructureMinRequeueAfter = 30 * time.Second
+	infrastructureMaxRequeueAfter = 10 * time.Minute
+
+	// kubeletServingCertificatesRequeueAfter is how long to wait before checking again for kubelet serving
+	// certificate signing requests to approve.
+	kubeletServingCertificatesRequeueAfter = time.Minute
+
+	// singleNodeRequeueAfter is how long to wait before checking again a single node control plane, whose server is
+	// replaced or brought up at the pace of the edge infrastructure.
+	singleNodeRequeueAfter = time.Minute
 )
+
+// requeueTime returns how long to wait before checking again a control plane that is waiting for its machines.
+func requeueTime(rcp *controlplanev1.RKE2ControlPlane) time.Duration {
+	if rcp.Spec.IsSingleNode() {
+		return singleNodeRequeueAfter
+	}
+
+	return DefaultRequeueTime
+}
+
+// reconcilePeriod returns how long to wait before reconciling again a control plane none of whose watched objects
+// changed, from its reconcile periods, or 0 to wait for the sync period of the controller.
+func reconcilePeriod(rcp *controlplanev1.RKE2ControlPlane) time.Duration {
+	periods := rcp.Spec.ReconcilePeriods
+	if periods == nil {
+		periods = &controlplanev1.ReconcilePeriods{}
+	}
+
+	switch {
+	case !rcp.Status.Ready && periods.NotReady != nil:
+		return periods.NotReady.Duration
+	case !rcp.Status.Ready:
+		return requeueTime(rcp)
+	case rcp.Spec.ServerConfig.ApproveKubeletServingCertificates &&
+		(periods.Ready == nil || periods.Ready.Duration > kubeletServingCertificatesRequeueAfter):
+		// Nodes joining the cluster don't trigger a reconciliation, check for new certificate signing requests.
+		return kubeletServingCertificatesRequeueAfter
+	case periods.Ready != nil:
+		return periods.Ready.Duration
+	}
+
+	return 0
+}
+
+// preflightRequeueTime returns how long to wait before checking again a control plane that failed its preflight checks.
+func preflightRequeueTime(rcp *controlplanev1.RKE2ControlPlane) time.Duration {
+	if rcp.Spec.IsSingleNode() {
+		return singleNodeRequeueAfter
+	}
+
+	return preflightFailedRequeueAfter
+}
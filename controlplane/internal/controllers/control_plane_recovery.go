@@ -0,0 +1,138 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util"
+	"sigs.k8s.io/cluster-api/util/conditions"
+
+	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+	"github.com/rancher-sandbox/cluster-api-provider-rke2/pkg/rke2"
+)
+
+// reconcileLastEtcdS3Snapshot records the most recent etcd snapshot stored in S3 in the status of the control plane,
+// to restore it if all the control plane machines are lost. A failure is only logged, the snapshot recorded last is
+// kept.
+func (r *RKE2ControlPlaneReconciler) reconcileLastEtcdS3Snapshot(ctx context.Context, controlPlane *rke2.ControlPlane) {
+	logger := log.FromContext(ctx)
+	rcp := controlPlane.RCP
+
+	if !rcp.Status.Initialized || !controlPlane.IsEtcdManaged() || rcp.Spec.ServerConfig.Etcd.BackupConfig.S3 == nil {
+		return
+	}
+
+	workloadCluster, err := r.managementCluster.GetWorkloadCluster(ctx, util.ObjectKey(controlPlane.Cluster))
+	if err != nil {
+		logger.Info("Failed to get the workload cluster to list the etcd snapshots", "reason", err.Error())
+
+		return
+	}
+
+	snapshotName, err := workloadCluster.LatestEtcdS3Snapshot(ctx)
+	if err != nil {
+		logger.Info("Failed to list the etcd snapshots", "reason", err.Error())
+
+		return
+	}
+
+	if snapshotName != "" {
+		rcp.Status.LastEtcdS3SnapshotName = snapshotName
+	}
+}
+
+// recoverControlPlane initializes a control plane which lost all its machines again, on a new machine restoring an
+// etcd snapshot stored in S3 before rke2-server starts: the snapshot taken before the control plane was scaled to zero
+// replicas, or else the last one. The control plane is then scaled up as usual. The control plane can't be recovered
+// when no snapshot is known, unless the servers use an external datastore, which keeps the state of the cluster, or
+// the control plane was scaled to zero without S3: its snapshot was lost along with the machines, and etcd is
+// initialized again.
+func (r *RKE2ControlPlaneReconciler) recoverControlPlane(
+	ctx context.Context,
+	cluster *clusterv1.Cluster,
+	rcp *controlplanev1.RKE2ControlPlane,
+	controlPlane *rke2.ControlPlane,
+) (ctrl.Result, error) {
+	restorePath := ""
+	scaledToZero := rcp.Status.ScaleToZeroSnapshotName != ""
+	s3 := rcp.Spec.ServerConfig.Etcd.BackupConfig.S3 != nil
+
+	if controlPlane.IsEtcdManaged() && (s3 || !scaledToZero) {
+		snapshotName := rcp.Status.ScaleToZeroSnapshotName
+		if snapshotName == "" {
+			snapshotName = rcp.Status.LastEtcdS3SnapshotName
+		}
+
+		if snapshotName == "" || !s3 {
+			if conditions.GetReason(rcp, controlplanev1.MachinesCreatedCondition) != controlplanev1.EtcdSnapshotUnavailableReason {
+				r.recorder.Eventf(rcp, corev1.EventTypeWarning, controlplanev1.EtcdSnapshotUnavailableReason,
+					"All the control plane machines are lost and no etcd snapshot stored in S3 is known, "+
+						"the control plane can't be recovered")
+			}
+
+			conditions.MarkFalse(rcp, controlplanev1.MachinesCreatedCondition, controlplanev1.EtcdSnapshotUnavailableReason,
+				clusterv1.ConditionSeverityError,
+				"All the control plane machines are lost and no etcd snapshot stored in S3 is known")
+
+			return ctrl.Result{}, nil
+		}
+
+		var err error
+
+		restorePath, err = rke2.EtcdSnapshotRestorePath(rcp, controlplanev1.EtcdSnapshotSourceS3, snapshotName)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	result, err := r.initializeControlPlane(ctx, cluster, rcp, controlPlane,
+		map[string]string{controlplanev1.ClusterResetRestorePathAnnotation: restorePath})
+	if err != nil || !conditions.IsTrue(rcp, controlplanev1.MachinesCreatedCondition) {
+		return result, err
+	}
+
+	switch {
+	case restorePath != "":
+		r.lifecycleEventf(cluster, rcp, corev1.EventTypeWarning, "RecoveringControlPlane",
+			"All the control plane machines are lost, initializing the control plane again from etcd snapshot %q",
+			restorePath)
+	case controlPlane.IsEtcdManaged():
+		r.lifecycleEventf(cluster, rcp, corev1.EventTypeWarning, "RecoveringControlPlane",
+			"Scaling up the control plane from zero replicas without an etcd snapshot stored in S3, "+
+				"the control plane is initialized again with an empty etcd")
+	default:
+		r.lifecycleEventf(cluster, rcp, corev1.EventTypeWarning, "RecoveringControlPlane",
+			"All the control plane machines are lost, initializing the control plane again from the external datastore")
+	}
+
+	// The snapshot is kept as the last one, in case the first machine is lost before a new snapshot is listed.
+	if scaledToZero {
+		if s3 {
+			rcp.Status.LastEtcdS3SnapshotName = rcp.Status.ScaleToZeroSnapshotName
+		}
+
+		rcp.Status.ScaleToZeroSnapshotName = ""
+	}
+
+	return result, nil
+}
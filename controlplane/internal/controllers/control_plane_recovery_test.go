@@ -0,0 +1,107 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util/conditions"
+
+	bootstrapv1 "github.com/rancher-sandbox/cluster-api-provider-rke2/bootstrap/api/v1alpha1"
+	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+)
+
+var _ = Describe("control plane recovery", func() {
+	var env *testEnvironment
+
+	ctx := context.Background()
+
+	BeforeEach(func() {
+		template := &unstructured.Unstructured{}
+		template.SetAPIVersion("infrastructure.cluster.x-k8s.io/v1beta1")
+		template.SetKind("DockerMachineTemplate")
+		template.SetNamespace(metav1.NamespaceDefault)
+		template.SetName("template")
+		Expect(unstructured.SetNestedMap(template.Object, map[string]interface{}{}, "spec", "template", "spec")).To(Succeed())
+
+		env = newTestEnvironment(3, nil, template)
+		env.RCP.Spec.InfrastructureRef = corev1.ObjectReference{
+			APIVersion: "infrastructure.cluster.x-k8s.io/v1beta1",
+			Kind:       "DockerMachineTemplate",
+			Name:       "template",
+		}
+		env.RCP.Status.LastEtcdS3SnapshotName = "etcd-snapshot-older"
+		conditions.MarkTrue(env.Cluster, clusterv1.ControlPlaneInitializedCondition)
+	})
+
+	restorePath := func() (string, bool) {
+		configs := &bootstrapv1.RKE2ConfigList{}
+		Expect(env.Client.List(ctx, configs)).To(Succeed())
+		Expect(configs.Items).To(HaveLen(1))
+
+		path, ok := configs.Items[0].Annotations[controlplanev1.ClusterResetRestorePathAnnotation]
+
+		return path, ok
+	}
+
+	It("should restore the snapshot taken before scaling to zero rather than the last one", func() {
+		env.RCP.Spec.ServerConfig.Etcd.BackupConfig.S3 = &controlplanev1.EtcdS3{Bucket: "bucket"}
+		env.RCP.Status.ScaleToZeroSnapshotName = "etcd-snapshot-scale-to-zero"
+
+		_, err := env.Reconciler.recoverControlPlane(ctx, env.Cluster, env.RCP, env.controlPlane())
+		Expect(err).ToNot(HaveOccurred())
+
+		path, ok := restorePath()
+		Expect(ok).To(BeTrue())
+		Expect(path).To(Equal("etcd-snapshot-scale-to-zero"))
+
+		Expect(env.RCP.Status.ScaleToZeroSnapshotName).To(BeEmpty())
+		Expect(env.RCP.Status.LastEtcdS3SnapshotName).To(Equal("etcd-snapshot-scale-to-zero"))
+	})
+
+	It("should initialize etcd again when scaled up from zero without S3", func() {
+		env.RCP.Status.ScaleToZeroSnapshotName = "etcd-snapshot-scale-to-zero"
+
+		_, err := env.Reconciler.recoverControlPlane(ctx, env.Cluster, env.RCP, env.controlPlane())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(conditions.IsTrue(env.RCP, controlplanev1.MachinesCreatedCondition)).To(BeTrue())
+
+		path, ok := restorePath()
+		Expect(ok).To(BeTrue())
+		Expect(path).To(BeEmpty())
+		Expect(env.RCP.Status.ScaleToZeroSnapshotName).To(BeEmpty())
+	})
+
+	It("should not recover lost machines without an etcd snapshot stored in S3", func() {
+		_, err := env.Reconciler.recoverControlPlane(ctx, env.Cluster, env.RCP, env.controlPlane())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(conditions.GetReason(env.RCP, controlplanev1.MachinesCreatedCondition)).
+			To(Equal(controlplanev1.EtcdSnapshotUnavailableReason))
+
+		configs := &bootstrapv1.RKE2ConfigList{}
+		Expect(env.Client.List(ctx, configs)).To(Succeed())
+		Expect(configs.Items).To(BeEmpty())
+	})
+})
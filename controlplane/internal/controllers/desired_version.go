@@ -0,0 +1,89 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util/conditions"
+
+	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+)
+
+// reconcileDesiredVersion approves the desired version of the control plane once the approved version annotation is
+// set to it, or the VersionApproved condition is true. The approved version is recorded in the status, the machines
+// are then rolled out to it without changing the version of the spec, which is owned by the GitOps tooling.
+func (r *RKE2ControlPlaneReconciler) reconcileDesiredVersion(ctx context.Context, rcp *controlplanev1.RKE2ControlPlane) error {
+	logger := log.FromContext(ctx)
+	desired, version := rcp.Spec.DesiredVersion, rcp.Spec.AgentConfig.Version
+
+	if desired == "" || desired == version {
+		rcp.Status.ApprovedVersion = ""
+		conditions.Delete(rcp, controlplanev1.UpgradeApprovedCondition)
+		conditions.Delete(rcp, controlplanev1.VersionApprovedCondition)
+
+		return nil
+	}
+
+	if rcp.Status.ApprovedVersion == desired {
+		return nil
+	}
+
+	if rcp.Annotations[controlplanev1.ApprovedVersionAnnotation] != desired &&
+		!conditions.IsTrue(rcp, controlplanev1.VersionApprovedCondition) {
+		conditions.MarkFalse(rcp, controlplanev1.UpgradeApprovedCondition, controlplanev1.WaitingForApprovalReason,
+			clusterv1.ConditionSeverityInfo, "Upgrade to %s waits for the %s annotation or the %s condition", desired,
+			controlplanev1.ApprovedVersionAnnotation, controlplanev1.VersionApprovedCondition)
+
+		return nil
+	}
+
+	// The compatibility matrix may have changed since the desired version was admitted.
+	matrix, err := r.CompatibilitySource.Matrix(ctx)
+	if err != nil {
+		return err
+	}
+
+	from := rcp.TargetVersion()
+
+	err = matrix.Validate(desired)
+	if err == nil {
+		err = matrix.ValidateUpgrade(from, desired)
+	}
+
+	if err != nil {
+		logger.Info("Not upgrading the control plane to an unsupported version", "version", desired, "reason", err.Error())
+		conditions.MarkFalse(rcp, controlplanev1.UpgradeApprovedCondition, controlplanev1.UnsupportedVersionReason,
+			clusterv1.ConditionSeverityError, "Not upgrading to %s: %v", desired, err)
+
+		return nil
+	}
+
+	logger.Info("Upgrading the control plane to the approved version", "from", from, "to", desired)
+	r.recorder.Eventf(rcp, corev1.EventTypeNormal, "UpgradeApproved", "Upgrading from %s to the approved version %s",
+		from, desired)
+
+	rcp.Status.ApprovedVersion = desired
+	conditions.MarkTrue(rcp, controlplanev1.UpgradeApprovedCondition)
+	conditions.Delete(rcp, controlplanev1.VersionApprovedCondition)
+
+	return nil
+}
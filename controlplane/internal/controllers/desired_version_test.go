@@ -0,0 +1,111 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"sigs.k8s.io/cluster-api/util/conditions"
+
+	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+)
+
+var _ = Describe("desired version", func() {
+	const (
+		version = "v1.26.4+rke2r1"
+		desired = "v1.27.2+rke2r1"
+	)
+
+	var env *testEnvironment
+
+	reconcileDesiredVersion := func() {
+		Expect(env.Reconciler.reconcileDesiredVersion(context.Background(), env.RCP)).To(Succeed())
+
+		// The version of the spec is owned by the GitOps tooling.
+		Expect(env.RCP.Spec.AgentConfig.Version).To(Equal(version))
+	}
+
+	BeforeEach(func() {
+		env = newTestEnvironment(3, nil)
+		env.RCP.Spec.DesiredVersion = desired
+	})
+
+	It("should wait for the approval of the desired version", func() {
+		env.RCP.Annotations = map[string]string{controlplanev1.ApprovedVersionAnnotation: "v1.27.1+rke2r1"}
+
+		reconcileDesiredVersion()
+
+		Expect(env.RCP.Status.ApprovedVersion).To(BeEmpty())
+		Expect(env.RCP.TargetVersion()).To(Equal(version))
+		Expect(conditions.GetReason(env.RCP, controlplanev1.UpgradeApprovedCondition)).
+			To(Equal(controlplanev1.WaitingForApprovalReason))
+	})
+
+	It("should roll the machines out to the version approved by the annotation", func() {
+		env.RCP.Annotations = map[string]string{controlplanev1.ApprovedVersionAnnotation: desired}
+
+		reconcileDesiredVersion()
+
+		Expect(env.RCP.Status.ApprovedVersion).To(Equal(desired))
+		Expect(env.RCP.TargetVersion()).To(Equal(desired))
+		Expect(env.RCP.TargetAgentConfig().Version).To(Equal(desired))
+		Expect(*env.controlPlane().Version()).To(Equal(desired))
+		Expect(conditions.IsTrue(env.RCP, controlplanev1.UpgradeApprovedCondition)).To(BeTrue())
+	})
+
+	It("should consume the VersionApproved condition", func() {
+		conditions.MarkTrue(env.RCP, controlplanev1.VersionApprovedCondition)
+
+		reconcileDesiredVersion()
+
+		Expect(env.RCP.Status.ApprovedVersion).To(Equal(desired))
+		Expect(conditions.Has(env.RCP, controlplanev1.VersionApprovedCondition)).To(BeFalse())
+
+		// The approval of a version doesn't approve the versions proposed later.
+		env.RCP.Spec.DesiredVersion = "v1.27.3+rke2r1"
+
+		reconcileDesiredVersion()
+
+		Expect(env.RCP.Status.ApprovedVersion).To(Equal(desired))
+		Expect(conditions.GetReason(env.RCP, controlplanev1.UpgradeApprovedCondition)).
+			To(Equal(controlplanev1.WaitingForApprovalReason))
+	})
+
+	It("should not approve a version the compatibility matrix doesn't support", func() {
+		env.RCP.Spec.DesiredVersion = "v1.25.9+rke2r1"
+		env.RCP.Annotations = map[string]string{controlplanev1.ApprovedVersionAnnotation: "v1.25.9+rke2r1"}
+
+		reconcileDesiredVersion()
+
+		Expect(env.RCP.Status.ApprovedVersion).To(BeEmpty())
+		Expect(conditions.GetReason(env.RCP, controlplanev1.UpgradeApprovedCondition)).
+			To(Equal(controlplanev1.UnsupportedVersionReason))
+	})
+
+	It("should forget the approved version once the version of the spec is set to it", func() {
+		env.RCP.Status.ApprovedVersion = desired
+		env.RCP.Spec.DesiredVersion = ""
+
+		Expect(env.Reconciler.reconcileDesiredVersion(context.Background(), env.RCP)).To(Succeed())
+
+		Expect(env.RCP.Status.ApprovedVersion).To(BeEmpty())
+		Expect(conditions.Has(env.RCP, controlplanev1.UpgradeApprovedCondition)).To(BeFalse())
+	})
+})
@@ -0,0 +1,101 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util/conditions"
+
+	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+)
+
+var _ = Describe("etcd alarms", func() {
+	var env *testEnvironment
+
+	ctx := context.Background()
+
+	raise := func() {
+		conditions.MarkFalse(env.RCP, controlplanev1.EtcdAlarmsClearedCondition, controlplanev1.EtcdAlarmsRaisedReason,
+			clusterv1.ConditionSeverityError, "etcd alarms NOSPACE")
+	}
+
+	BeforeEach(func() {
+		env = newTestEnvironment(3, fake.NewClientBuilder().WithScheme(newTestScheme()).Build())
+
+		for _, name := range []string{"machine-1", "machine-2"} {
+			machine := newControlPlaneMachine(env, name)
+			conditions.MarkTrue(machine, controlplanev1.MachineAgentHealthyCondition)
+			conditions.MarkTrue(machine, controlplanev1.MachineEtcdMemberHealthyCondition)
+			env.createMachines(machine)
+		}
+	})
+
+	It("should pass the preflight checks of a healthy control plane without alarms", func() {
+		conditions.MarkTrue(env.RCP, controlplanev1.EtcdAlarmsClearedCondition)
+
+		Expect(env.Reconciler.preflightChecks(ctx, env.controlPlane())).To(BeZero())
+	})
+
+	It("should hold the scale operations while alarms are raised", func() {
+		raise()
+
+		Expect(env.Reconciler.preflightChecks(ctx, env.controlPlane()).RequeueAfter).To(Equal(preflightFailedRequeueAfter))
+	})
+
+	It("should not hold the scale operations when the alarms are ignored", func() {
+		raise()
+		env.RCP.Annotations = map[string]string{controlplanev1.IgnoreEtcdAlarmsAnnotation: ""}
+
+		Expect(env.Reconciler.preflightChecks(ctx, env.controlPlane())).To(BeZero())
+	})
+
+	It("should still hold the scale operations of a control plane without etcd quorum when the alarms are ignored", func() {
+		raise()
+		env.RCP.Annotations = map[string]string{controlplanev1.IgnoreEtcdAlarmsAnnotation: ""}
+		conditions.MarkFalse(env.RCP, controlplanev1.EtcdClusterHealthyCondition, controlplanev1.EtcdQuorumLostReason,
+			clusterv1.ConditionSeverityError, "etcd quorum lost")
+
+		Expect(env.Reconciler.preflightChecks(ctx, env.controlPlane()).RequeueAfter).To(Equal(preflightFailedRequeueAfter))
+	})
+
+	It("should record an event when the alarms are raised, change and are cleared", func() {
+		raise()
+		env.Reconciler.recordEtcdAlarms(env.RCP, nil)
+		Expect(env.Recorder.Events).To(Receive(And(ContainSubstring("EtcdAlarmsRaised"), ContainSubstring("NOSPACE"))))
+
+		previous := conditions.Get(env.RCP, controlplanev1.EtcdAlarmsClearedCondition)
+		env.Reconciler.recordEtcdAlarms(env.RCP, previous)
+		Expect(env.Recorder.Events).ToNot(Receive())
+
+		conditions.MarkFalse(env.RCP, controlplanev1.EtcdAlarmsClearedCondition, controlplanev1.EtcdAlarmsRaisedReason,
+			clusterv1.ConditionSeverityError, "etcd alarms NOSPACE, CORRUPT")
+		env.Reconciler.recordEtcdAlarms(env.RCP, previous)
+		Expect(env.Recorder.Events).To(Receive(ContainSubstring("CORRUPT")))
+
+		previous = conditions.Get(env.RCP, controlplanev1.EtcdAlarmsClearedCondition)
+		conditions.MarkTrue(env.RCP, controlplanev1.EtcdAlarmsClearedCondition)
+		env.Reconciler.recordEtcdAlarms(env.RCP, previous)
+		Expect(env.Recorder.Events).To(Receive(ContainSubstring("EtcdAlarmsCleared")))
+	})
+})
@@ -0,0 +1,112 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util"
+	"sigs.k8s.io/cluster-api/util/conditions"
+
+	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+	"github.com/rancher-sandbox/cluster-api-provider-rke2/pkg/rke2"
+)
+
+// etcdDefragmentationRequeueAfter is how long to wait before defragmenting the next etcd member, or trying again after
+// a failure.
+const etcdDefragmentationRequeueAfter = 30 * time.Second
+
+// etcdDefragmentationSkippedRequeueAfter is how long to wait before trying again to defragment the etcd members when
+// etcd can't be reached by the management cluster.
+const etcdDefragmentationSkippedRequeueAfter = 5 * time.Minute
+
+// reconcileEtcdDefragmentation defragments the etcd members periodically when it is enabled, one member per
+// reconciliation and the leader last. The members already defragmented are recorded in the status, so that the
+// defragmentation carries on where it stopped, once all the members are healthy again.
+func (r *RKE2ControlPlaneReconciler) reconcileEtcdDefragmentation(
+	ctx context.Context,
+	controlPlane *rke2.ControlPlane,
+) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+	rcp := controlPlane.RCP
+
+	if rcp.Spec.ServerConfig.Etcd.Defragmentation == nil || !controlPlane.IsEtcdManaged() {
+		rcp.Status.EtcdDefragmentedMembers = nil
+		conditions.Delete(rcp, controlplanev1.EtcdDefragmentedCondition)
+
+		return ctrl.Result{}, nil
+	}
+
+	due, wait := rke2.EtcdDefragmentationDue(rcp, time.Now())
+	if !due {
+		return ctrl.Result{RequeueAfter: wait}, nil
+	}
+
+	workloadCluster, err := r.managementCluster.GetWorkloadCluster(ctx, util.ObjectKey(controlPlane.Cluster))
+	if err != nil {
+		return ctrl.Result{}, errors.Wrap(err, "cannot get remote client to workload cluster")
+	}
+
+	member, err := workloadCluster.DefragmentEtcd(ctx, rcp.Status.EtcdDefragmentedMembers)
+	if errors.Is(err, rke2.ErrEtcdClientUnavailable) {
+		conditions.MarkFalse(rcp, controlplanev1.EtcdDefragmentedCondition, controlplanev1.EtcdDefragmentationSkippedReason,
+			clusterv1.ConditionSeverityInfo, "The etcd certificate authority is not provided by the management cluster, "+
+				"etcd can't be defragmented")
+
+		return ctrl.Result{RequeueAfter: etcdDefragmentationSkippedRequeueAfter}, nil
+	}
+
+	if err != nil {
+		logger.Info("Failed to defragment the etcd members", "reason", err.Error())
+		conditions.MarkFalse(rcp, controlplanev1.EtcdDefragmentedCondition, controlplanev1.EtcdDefragmentationFailedReason,
+			clusterv1.ConditionSeverityWarning, "Failed to defragment the etcd members: %v", err)
+
+		return ctrl.Result{RequeueAfter: etcdDefragmentationRequeueAfter}, nil
+	}
+
+	if member != "" {
+		logger.Info("Defragmented etcd member", "member", member)
+
+		rcp.Status.EtcdDefragmentedMembers = append(rcp.Status.EtcdDefragmentedMembers, member)
+		conditions.MarkFalse(rcp, controlplanev1.EtcdDefragmentedCondition, controlplanev1.DefragmentingEtcdReason,
+			clusterv1.ConditionSeverityInfo, "Defragmented etcd members %s",
+			strings.Join(rcp.Status.EtcdDefragmentedMembers, ", "))
+
+		return ctrl.Result{RequeueAfter: etcdDefragmentationRequeueAfter}, nil
+	}
+
+	now := metav1.Now()
+	r.recorder.Eventf(rcp, corev1.EventTypeNormal, "EtcdDefragmented", "Defragmented etcd members %s",
+		strings.Join(rcp.Status.EtcdDefragmentedMembers, ", "))
+
+	rcp.Status.LastEtcdDefragmentationTime = &now
+	rcp.Status.EtcdDefragmentedMembers = nil
+	conditions.MarkTrue(rcp, controlplanev1.EtcdDefragmentedCondition)
+
+	_, wait = rke2.EtcdDefragmentationDue(rcp, now.Time)
+
+	return ctrl.Result{RequeueAfter: wait}, nil
+}
@@ -0,0 +1,49 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"sigs.k8s.io/cluster-api/util/conditions"
+
+	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+)
+
+var _ = Describe("etcd defragmentation", func() {
+	ctx := context.Background()
+
+	It("should report the defragmentation as skipped when etcd can't be reached", func() {
+		env := newTestEnvironment(3, fake.NewClientBuilder().WithScheme(newTestScheme()).Build())
+		env.RCP.Spec.ServerConfig.Etcd.Defragmentation = &controlplanev1.EtcdDefragmentation{}
+		env.createMachines(newControlPlaneMachine(env, "machine-1"))
+
+		result, err := env.Reconciler.reconcileEtcdDefragmentation(ctx, env.controlPlane())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.RequeueAfter).To(Equal(etcdDefragmentationSkippedRequeueAfter))
+
+		Expect(conditions.GetReason(env.RCP, controlplanev1.EtcdDefragmentedCondition)).
+			To(Equal(controlplanev1.EtcdDefragmentationSkippedReason))
+		Expect(conditions.IsFalse(env.RCP, controlplanev1.EtcdDefragmentedCondition)).To(BeTrue())
+		Expect(env.RCP.Status.EtcdDefragmentedMembers).To(BeEmpty())
+	})
+})
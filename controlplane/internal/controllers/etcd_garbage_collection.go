@@ -0,0 +1,64 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strings"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"sigs.k8s.io/cluster-api/util"
+
+	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+	"github.com/rancher-sandbox/cluster-api-provider-rke2/pkg/rke2"
+)
+
+// reconcileEtcdMemberGarbageCollection removes the etcd members backed by no control plane machine, e.g. after a
+// failed scale down or the crash of an infrastructure machine, unless the control plane disables it with the
+// DisableEtcdMemberGarbageCollectionAnnotation. A failure is only logged, it is tried again on the next reconciliation.
+func (r *RKE2ControlPlaneReconciler) reconcileEtcdMemberGarbageCollection(
+	ctx context.Context,
+	controlPlane *rke2.ControlPlane,
+) error {
+	logger := log.FromContext(ctx)
+	rcp := controlPlane.RCP
+
+	if _, disabled := rcp.Annotations[controlplanev1.DisableEtcdMemberGarbageCollectionAnnotation]; disabled ||
+		!controlPlane.IsEtcdManaged() {
+		return nil
+	}
+
+	workloadCluster, err := r.managementCluster.GetWorkloadCluster(ctx, util.ObjectKey(controlPlane.Cluster))
+	if err != nil {
+		return errors.Wrap(err, "cannot get remote client to workload cluster")
+	}
+
+	removed, err := workloadCluster.RemoveOrphanedEtcdMembers(ctx, controlPlane)
+	if len(removed) > 0 {
+		r.recorder.Eventf(rcp, corev1.EventTypeNormal, "EtcdMembersRemoved",
+			"Removed etcd members backed by no control plane machine: %s", strings.Join(removed, ", "))
+	}
+
+	if err != nil && !errors.Is(err, rke2.ErrEtcdClientUnavailable) {
+		logger.Info("Failed to remove the orphaned etcd members", "reason", err.Error())
+	}
+
+	return nil
+}
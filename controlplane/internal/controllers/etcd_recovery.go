@@ -0,0 +1,344 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util"
+	"sigs.k8s.io/cluster-api/util/collections"
+	"sigs.k8s.io/cluster-api/util/conditions"
+
+	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+	"github.com/rancher-sandbox/cluster-api-provider-rke2/pkg/rke2"
+)
+
+// etcdRecoveryRequeueAfter is how long to wait before checking again the progress of an etcd quorum recovery.
+const etcdRecoveryRequeueAfter = 30 * time.Second
+
+// reconcileEtcdRecovery recovers the etcd cluster after the loss of its quorum, when requested by the
+// RecoverEtcdQuorumAnnotation: the healthiest surviving member is elected and etcd is reset on it with
+// "rke2 server --cluster-reset", the other machines are then deleted so that they are recreated and join the reset
+// etcd cluster. Once a member is elected, the recovery is carried on to its end, no recovery is started while the control
+// plane is in maintenance mode.
+// The ClusterResetAnnotation requests the same procedure on a chosen machine, even though the quorum is kept.
+// A non zero result is returned while the recovery is in progress, as the workload cluster may not be reachable and
+// the control plane must not be scaled nor rolled out meanwhile.
+func (r *RKE2ControlPlaneReconciler) reconcileEtcdRecovery(ctx context.Context, controlPlane *rke2.ControlPlane) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+	rcp := controlPlane.RCP
+
+	elected := controlPlane.Machines.Filter(collections.HasAnnotationKey(controlplanev1.ClusterResetRequestedAnnotation))
+	name, requested := rcp.Annotations[controlplanev1.RecoverEtcdQuorumAnnotation]
+	resetName, resetRequested := rcp.Annotations[controlplanev1.ClusterResetAnnotation]
+
+	switch {
+	case !controlPlane.IsEtcdManaged():
+		if requested || resetRequested {
+			conditions.MarkFalse(rcp, controlplanev1.EtcdQuorumRecoveredCondition, controlplanev1.EtcdRecoveryRefusedReason,
+				clusterv1.ConditionSeverityWarning, "The servers use an external datastore, there is no etcd to recover")
+		}
+
+		return ctrl.Result{}, nil
+	case elected.Len() > 0:
+		return r.recoverEtcdQuorum(ctx, controlPlane, elected.Oldest())
+	case (requested || resetRequested) && (rcp.Spec.Hibernate || rcp.Status.Hibernated):
+		conditions.MarkFalse(rcp, controlplanev1.EtcdQuorumRecoveredCondition, controlplanev1.EtcdRecoveryRefusedReason,
+			clusterv1.ConditionSeverityWarning, "The control plane is hibernated, etcd is not recovered")
+
+		return ctrl.Result{}, nil
+	case (requested || resetRequested) && rcp.Spec.Maintenance:
+		conditions.MarkFalse(rcp, controlplanev1.EtcdQuorumRecoveredCondition, controlplanev1.EtcdRecoveryRefusedReason,
+			clusterv1.ConditionSeverityWarning, "The control plane is in maintenance mode, etcd is not recovered")
+
+		return ctrl.Result{}, nil
+	case resetRequested:
+		return r.requestClusterReset(ctx, controlPlane, resetName)
+	case !requested:
+		// A refused request is forgotten once its annotation is removed, the outcome of a recovery is kept.
+		if conditions.GetReason(rcp, controlplanev1.EtcdQuorumRecoveredCondition) == controlplanev1.EtcdRecoveryRefusedReason {
+			conditions.Delete(rcp, controlplanev1.EtcdQuorumRecoveredCondition)
+		}
+
+		return ctrl.Result{}, nil
+	}
+
+	// The workload cluster API is served as long as etcd keeps its quorum.
+	if _, err := r.managementCluster.GetWorkloadCluster(ctx, util.ObjectKey(controlPlane.Cluster)); err == nil {
+		logger.Info("Not recovering the etcd quorum of a reachable workload cluster")
+		conditions.MarkFalse(rcp, controlplanev1.EtcdQuorumRecoveredCondition, controlplanev1.EtcdRecoveryRefusedReason,
+			clusterv1.ConditionSeverityWarning, "The workload cluster is reachable, the etcd quorum is not lost")
+
+		return ctrl.Result{}, nil
+	}
+
+	member, err := rke2.ElectEtcdRecoveryMember(controlPlane.Machines, name)
+	if err != nil {
+		logger.Info("Not recovering the etcd quorum", "reason", err.Error())
+		conditions.MarkFalse(rcp, controlplanev1.EtcdQuorumRecoveredCondition, controlplanev1.EtcdRecoveryRefusedReason,
+			clusterv1.ConditionSeverityWarning, "Not recovering the etcd quorum: %v", err)
+
+		return ctrl.Result{}, nil
+	}
+
+	if err := rke2.PatchAnnotations(ctx, r.Client, r.apiReader, member, map[string]string{
+		controlplanev1.ClusterResetRequestedAnnotation: time.Now().UTC().Format(time.RFC3339),
+	}); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	logger.Info("Elected the etcd member to recover the quorum", "machine", member.Name)
+	r.recorder.Eventf(rcp, corev1.EventTypeWarning, "EtcdRecoveryStarted",
+		"Machine %s elected to reset etcd and recover its quorum", member.Name)
+	markWaitingForClusterReset(rcp, member)
+
+	return ctrl.Result{RequeueAfter: etcdRecoveryRequeueAfter}, nil
+}
+
+// requestClusterReset requests the node automation to reset etcd on the machine chosen by the ClusterResetAnnotation,
+// once the guards of ValidateClusterResetMember pass. The reset is then carried on as a recovery of the etcd quorum.
+func (r *RKE2ControlPlaneReconciler) requestClusterReset(
+	ctx context.Context,
+	controlPlane *rke2.ControlPlane,
+	name string,
+) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+	rcp := controlPlane.RCP
+
+	member, err := rke2.ValidateClusterResetMember(controlPlane.Machines, name)
+	if err != nil {
+		logger.Info("Not resetting etcd", "reason", err.Error())
+		conditions.MarkFalse(rcp, controlplanev1.EtcdQuorumRecoveredCondition, controlplanev1.EtcdRecoveryRefusedReason,
+			clusterv1.ConditionSeverityWarning, "Not resetting etcd: %v", err)
+
+		return ctrl.Result{}, nil
+	}
+
+	if err := rke2.PatchAnnotations(ctx, r.Client, r.apiReader, member, map[string]string{
+		controlplanev1.ClusterResetRequestedAnnotation: time.Now().UTC().Format(time.RFC3339),
+	}); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	logger.Info("Requested to reset etcd", "machine", member.Name)
+	r.recorder.Eventf(rcp, corev1.EventTypeWarning, "ClusterResetStarted",
+		"Machine %s chosen to reset etcd, the other control plane machines are recreated", member.Name)
+	markWaitingForClusterReset(rcp, member)
+
+	return ctrl.Result{RequeueAfter: etcdRecoveryRequeueAfter}, nil
+}
+
+// recoverEtcdQuorum carries the recovery of the etcd quorum on once a member is elected.
+func (r *RKE2ControlPlaneReconciler) recoverEtcdQuorum(
+	ctx context.Context,
+	controlPlane *rke2.ControlPlane,
+	member *clusterv1.Machine,
+) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+	rcp := controlPlane.RCP
+
+	if _, reset := member.Annotations[controlplanev1.ClusterResetCompletedAnnotation]; !reset {
+		if !member.DeletionTimestamp.IsZero() {
+			conditions.MarkFalse(rcp, controlplanev1.EtcdQuorumRecoveredCondition,
+				controlplanev1.WaitingForClusterResetReason, clusterv1.ConditionSeverityError,
+				"Machine %s elected to reset etcd is being deleted", member.Name)
+
+			return ctrl.Result{}, nil
+		}
+
+		reset, err := r.runClusterReset(ctx, controlPlane, member)
+		if errors.Is(err, rke2.ErrClusterResetFailed) {
+			logger.Info("Failed to reset etcd on the elected member", "machine", member.Name, "reason", err.Error())
+			conditions.MarkFalse(rcp, controlplanev1.EtcdQuorumRecoveredCondition, controlplanev1.ClusterResetFailedReason,
+				clusterv1.ConditionSeverityError, "Failed to reset etcd on machine %s: %v", member.Name, err)
+
+			return ctrl.Result{RequeueAfter: etcdRecoveryRequeueAfter}, nil
+		}
+
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+
+		if !reset {
+			logger.Info("Waiting for etcd to be reset on the elected member", "machine", member.Name)
+			markWaitingForClusterReset(rcp, member)
+
+			return ctrl.Result{RequeueAfter: etcdRecoveryRequeueAfter}, nil
+		}
+
+		if err := rke2.PatchAnnotations(ctx, r.Client, r.apiReader, member, map[string]string{
+			controlplanev1.ClusterResetCompletedAnnotation: time.Now().UTC().Format(time.RFC3339),
+		}); err != nil {
+			return ctrl.Result{}, err
+		}
+
+		r.recorder.Eventf(rcp, corev1.EventTypeNormal, "ClusterResetCompleted", "Etcd was reset on machine %s", member.Name)
+	}
+
+	// The other members were removed from etcd by the reset, their machines are recreated to join it again.
+	others := controlPlane.Machines.Filter(func(machine *clusterv1.Machine) bool {
+		return machine.Name != member.Name
+	})
+
+	for _, machine := range others {
+		if !machine.DeletionTimestamp.IsZero() {
+			continue
+		}
+
+		logger.Info("Deleting the control plane machine removed from etcd by the reset", "machine", machine.Name)
+
+		if err := r.Client.Delete(ctx, machine); err != nil && !apierrors.IsNotFound(err) {
+			return ctrl.Result{}, errors.Wrapf(err, "failed to delete control plane machine %s", machine.Name)
+		}
+
+		r.recorder.Eventf(rcp, corev1.EventTypeNormal, "EtcdMemberRecreated",
+			"Deleting machine %s to recreate its etcd member", machine.Name)
+	}
+
+	if others.Len() > 0 {
+		conditions.MarkFalse(rcp, controlplanev1.EtcdQuorumRecoveredCondition,
+			controlplanev1.RecreatingEtcdMembersReason, clusterv1.ConditionSeverityInfo,
+			"Etcd was reset on machine %s, waiting for %d other machines to be deleted", member.Name, others.Len())
+
+		return ctrl.Result{RequeueAfter: etcdRecoveryRequeueAfter}, nil
+	}
+
+	if err := rke2.PatchAnnotations(ctx, r.Client, r.apiReader, member, nil, controlplanev1.ClusterResetRequestedAnnotation,
+		controlplanev1.ClusterResetCompletedAnnotation, controlplanev1.EtcdSnapshotRestoreAnnotation); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if err := r.setInfrastructureMachineClusterReset(ctx, controlPlane, member, ""); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// The jobs are left to their time to live when the workload cluster API isn't reachable yet.
+	if workloadCluster, err := r.managementCluster.GetWorkloadCluster(ctx, util.ObjectKey(controlPlane.Cluster)); err == nil {
+		if err := workloadCluster.DeleteClusterResetJobs(ctx); err != nil {
+			logger.Info("Failed to delete the cluster reset jobs", "reason", err.Error())
+		}
+	}
+
+	// The annotations are removed from the metadata patched at the end of the reconciliation.
+	delete(rcp.Annotations, controlplanev1.RecoverEtcdQuorumAnnotation)
+	delete(rcp.Annotations, controlplanev1.ClusterResetAnnotation)
+
+	logger.Info("Recovered the etcd quorum", "machine", member.Name)
+	r.recorder.Eventf(rcp, corev1.EventTypeNormal, "EtcdQuorumRecovered",
+		"Etcd quorum recovered on machine %s, the control plane is scaled up again", member.Name)
+	conditions.MarkTrue(rcp, controlplanev1.EtcdQuorumRecoveredCondition)
+
+	return ctrl.Result{}, nil
+}
+
+// runClusterReset resets etcd on the member: the reset is run by a job of the workload cluster when its API is
+// reachable, the infrastructure machine is annotated for the infrastructure hooks or node automation otherwise, as
+// the API is unreachable once the etcd quorum is lost. It returns true once etcd is reset.
+func (r *RKE2ControlPlaneReconciler) runClusterReset(
+	ctx context.Context,
+	controlPlane *rke2.ControlPlane,
+	member *clusterv1.Machine,
+) (bool, error) {
+	logger := log.FromContext(ctx)
+	id := member.Annotations[controlplanev1.ClusterResetRequestedAnnotation]
+
+	// The snapshot is restored by the reset of the RKE2EtcdSnapshotRestore.
+	if _, restoring := member.Annotations[controlplanev1.EtcdSnapshotRestoreAnnotation]; restoring {
+		return false, nil
+	}
+
+	if err := r.setInfrastructureMachineClusterReset(ctx, controlPlane, member, id); err != nil {
+		return false, err
+	}
+
+	if member.Status.NodeRef == nil {
+		return false, nil
+	}
+
+	workloadCluster, err := r.managementCluster.GetWorkloadCluster(ctx, util.ObjectKey(controlPlane.Cluster))
+	if err != nil {
+		logger.V(4).Info("The workload cluster is unreachable, waiting for the infrastructure hooks to reset etcd",
+			"machine", member.Name, "reason", err.Error())
+
+		return false, nil
+	}
+
+	reset, err := workloadCluster.ClusterReset(ctx, member.Status.NodeRef.Name, id)
+	if err != nil && !errors.Is(err, rke2.ErrClusterResetFailed) {
+		logger.V(4).Info("Failed to run the cluster reset job, waiting for the infrastructure hooks to reset etcd",
+			"machine", member.Name, "reason", err.Error())
+
+		return false, nil
+	}
+
+	return reset, err
+}
+
+// setInfrastructureMachineClusterReset sets the ClusterResetRequestedAnnotation of the infrastructure machine of the
+// member to the value, it is removed when the value is empty.
+func (r *RKE2ControlPlaneReconciler) setInfrastructureMachineClusterReset(
+	ctx context.Context,
+	controlPlane *rke2.ControlPlane,
+	member *clusterv1.Machine,
+	value string,
+) error {
+	infraMachine, ok := controlPlane.InfrastructureMachines()[member.Name]
+	if !ok {
+		return nil
+	}
+
+	annotations := infraMachine.GetAnnotations()
+	if current, set := annotations[controlplanev1.ClusterResetRequestedAnnotation]; current == value && set == (value != "") {
+		return nil
+	}
+
+	before := infraMachine.DeepCopy()
+
+	if value == "" {
+		delete(annotations, controlplanev1.ClusterResetRequestedAnnotation)
+	} else {
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+
+		annotations[controlplanev1.ClusterResetRequestedAnnotation] = value
+	}
+
+	infraMachine.SetAnnotations(annotations)
+
+	if err := r.Client.Patch(ctx, infraMachine, client.MergeFrom(before)); err != nil {
+		return errors.Wrapf(err, "failed to patch the annotations of infrastructure machine %s/%s",
+			infraMachine.GetKind(), infraMachine.GetName())
+	}
+
+	return nil
+}
+
+func markWaitingForClusterReset(rcp *controlplanev1.RKE2ControlPlane, member *clusterv1.Machine) {
+	conditions.MarkFalse(rcp, controlplanev1.EtcdQuorumRecoveredCondition, controlplanev1.WaitingForClusterResetReason,
+		clusterv1.ConditionSeverityWarning, "Waiting for etcd to be reset on machine %s, by a job of the workload cluster "+
+			"or by the infrastructure hooks setting the %s annotation", member.Name, controlplanev1.ClusterResetCompletedAnnotation)
+}
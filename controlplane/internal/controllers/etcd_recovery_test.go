@@ -0,0 +1,203 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	batchv1 "k8s.io/api/batch/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util/conditions"
+
+	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+	"github.com/rancher-sandbox/cluster-api-provider-rke2/pkg/rke2"
+)
+
+var _ = Describe("etcd quorum recovery", func() {
+	var env *testEnvironment
+
+	BeforeEach(func() {
+		// The workload cluster API is down, as it is once the etcd quorum is lost.
+		env = newTestEnvironment(3, nil)
+		env.Reconciler.ControllerPod = &rke2.ControllerPod{Namespace: "rke2-system", Name: "controller"}
+
+		failed := func(machine *clusterv1.Machine) *clusterv1.Machine {
+			machine.Status.InfrastructureReady = false
+			machine.Status.FailureReason = nil
+			machine.Status.FailureMessage = pointer.String("instance terminated")
+
+			return machine
+		}
+
+		env.createMachines(
+			newControlPlaneMachine(env, "machine-1"),
+			failed(newControlPlaneMachine(env, "machine-2")),
+			failed(newControlPlaneMachine(env, "machine-3")),
+		)
+	})
+
+	It("should elect a surviving member while the workload cluster API is unreachable", func() {
+		env.RCP.Annotations = map[string]string{controlplanev1.RecoverEtcdQuorumAnnotation: ""}
+
+		result, err := env.Reconciler.reconcileNormal(context.Background(), env.Cluster, env.RCP)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.RequeueAfter).To(Equal(etcdRecoveryRequeueAfter))
+
+		Expect(env.machine("machine-1").Annotations).To(HaveKey(controlplanev1.ClusterResetRequestedAnnotation))
+		Expect(conditions.GetReason(env.RCP, controlplanev1.EtcdQuorumRecoveredCondition)).
+			To(Equal(controlplanev1.WaitingForClusterResetReason))
+	})
+
+	It("should refuse to recover the etcd quorum of a hibernated control plane", func() {
+		env.RCP.Annotations = map[string]string{controlplanev1.RecoverEtcdQuorumAnnotation: ""}
+		env.RCP.Status.Hibernated = true
+
+		result, err := env.Reconciler.reconcileEtcdRecovery(context.Background(), env.controlPlane())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.IsZero()).To(BeTrue())
+
+		Expect(env.machine("machine-1").Annotations).ToNot(HaveKey(controlplanev1.ClusterResetRequestedAnnotation))
+		Expect(conditions.GetReason(env.RCP, controlplanev1.EtcdQuorumRecoveredCondition)).
+			To(Equal(controlplanev1.EtcdRecoveryRefusedReason))
+	})
+
+	It("should refuse to recover the etcd quorum of a control plane in maintenance mode", func() {
+		env.RCP.Annotations = map[string]string{controlplanev1.RecoverEtcdQuorumAnnotation: ""}
+		env.RCP.Spec.Maintenance = true
+
+		result, err := env.Reconciler.reconcileEtcdRecovery(context.Background(), env.controlPlane())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.IsZero()).To(BeTrue())
+
+		Expect(env.machine("machine-1").Annotations).ToNot(HaveKey(controlplanev1.ClusterResetRequestedAnnotation))
+		Expect(conditions.GetMessage(env.RCP, controlplanev1.EtcdQuorumRecoveredCondition)).
+			To(ContainSubstring("maintenance mode"))
+	})
+
+	It("should reset etcd with a job of the workload cluster when its API is reachable", func() {
+		ctx := context.Background()
+		workloadClient := fake.NewClientBuilder().WithScheme(newTestScheme()).Build()
+		env.ManagementCluster.Workload = &rke2.Workload{Client: workloadClient}
+		env.ManagementCluster.WorkloadErr = nil
+
+		env.RCP.Annotations = map[string]string{controlplanev1.RecoverEtcdQuorumAnnotation: ""}
+		Expect(rke2.PatchAnnotations(ctx, env.Client, env.Client, env.machine("machine-1"), map[string]string{
+			controlplanev1.ClusterResetRequestedAnnotation: "2023-01-01T00:00:00Z",
+		})).To(Succeed())
+
+		result, err := env.Reconciler.reconcileEtcdRecovery(ctx, env.controlPlane())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.RequeueAfter).To(Equal(etcdRecoveryRequeueAfter))
+
+		job := &batchv1.Job{}
+		Expect(workloadClient.Get(ctx, client.ObjectKey{Namespace: rke2.HibernationNamespace, Name: "rke2-cluster-reset-machine-1"},
+			job)).To(Succeed())
+		Expect(job.Spec.Template.Spec.NodeName).To(Equal("machine-1"))
+
+		job.Status.Succeeded = 1
+		Expect(workloadClient.Status().Update(ctx, job)).To(Succeed())
+
+		result, err = env.Reconciler.reconcileEtcdRecovery(ctx, env.controlPlane())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.RequeueAfter).To(Equal(etcdRecoveryRequeueAfter))
+
+		Expect(env.machine("machine-1").Annotations).To(HaveKey(controlplanev1.ClusterResetCompletedAnnotation))
+		Expect(conditions.GetReason(env.RCP, controlplanev1.EtcdQuorumRecoveredCondition)).
+			To(Equal(controlplanev1.RecreatingEtcdMembersReason))
+
+		machines := &clusterv1.MachineList{}
+		Expect(env.Client.List(ctx, machines)).To(Succeed())
+		Expect(machines.Items).To(HaveLen(1))
+		Expect(machines.Items[0].Name).To(Equal("machine-1"))
+
+		result, err = env.Reconciler.reconcileEtcdRecovery(ctx, env.controlPlane())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.IsZero()).To(BeTrue())
+		Expect(conditions.IsTrue(env.RCP, controlplanev1.EtcdQuorumRecoveredCondition)).To(BeTrue())
+
+		jobs := &batchv1.JobList{}
+		Expect(workloadClient.List(ctx, jobs)).To(Succeed())
+		Expect(jobs.Items).To(BeEmpty())
+	})
+
+	It("should report the failure of the cluster reset job", func() {
+		ctx := context.Background()
+		workloadClient := fake.NewClientBuilder().WithScheme(newTestScheme()).Build()
+		env.ManagementCluster.Workload = &rke2.Workload{Client: workloadClient}
+		env.ManagementCluster.WorkloadErr = nil
+
+		env.RCP.Annotations = map[string]string{controlplanev1.RecoverEtcdQuorumAnnotation: ""}
+		Expect(rke2.PatchAnnotations(ctx, env.Client, env.Client, env.machine("machine-1"), map[string]string{
+			controlplanev1.ClusterResetRequestedAnnotation: "2023-01-01T00:00:00Z",
+		})).To(Succeed())
+
+		_, err := env.Reconciler.reconcileEtcdRecovery(ctx, env.controlPlane())
+		Expect(err).ToNot(HaveOccurred())
+
+		job := &batchv1.Job{}
+		Expect(workloadClient.Get(ctx, client.ObjectKey{Namespace: rke2.HibernationNamespace, Name: "rke2-cluster-reset-machine-1"},
+			job)).To(Succeed())
+
+		job.Status.Failed = 1
+		Expect(workloadClient.Status().Update(ctx, job)).To(Succeed())
+
+		result, err := env.Reconciler.reconcileEtcdRecovery(ctx, env.controlPlane())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.RequeueAfter).To(Equal(etcdRecoveryRequeueAfter))
+
+		Expect(env.machine("machine-1").Annotations).ToNot(HaveKey(controlplanev1.ClusterResetCompletedAnnotation))
+		Expect(conditions.GetReason(env.RCP, controlplanev1.EtcdQuorumRecoveredCondition)).
+			To(Equal(controlplanev1.ClusterResetFailedReason))
+
+		machines := &clusterv1.MachineList{}
+		Expect(env.Client.List(ctx, machines)).To(Succeed())
+		Expect(machines.Items).To(HaveLen(3))
+	})
+
+	It("should request the reset from the infrastructure machine when the workload cluster API is unreachable", func() {
+		ctx := context.Background()
+		infraMachine := &unstructured.Unstructured{}
+		infraMachine.SetAPIVersion("infrastructure.cluster.x-k8s.io/v1beta1")
+		infraMachine.SetKind("DockerMachine")
+		infraMachine.SetNamespace(env.Cluster.Namespace)
+		infraMachine.SetName("machine-1")
+		Expect(env.Client.Create(ctx, infraMachine)).To(Succeed())
+
+		env.RCP.Annotations = map[string]string{controlplanev1.RecoverEtcdQuorumAnnotation: ""}
+		Expect(rke2.PatchAnnotations(ctx, env.Client, env.Client, env.machine("machine-1"), map[string]string{
+			controlplanev1.ClusterResetRequestedAnnotation: "2023-01-01T00:00:00Z",
+		})).To(Succeed())
+
+		result, err := env.Reconciler.reconcileEtcdRecovery(ctx, env.controlPlane())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.RequeueAfter).To(Equal(etcdRecoveryRequeueAfter))
+
+		Expect(env.Client.Get(ctx, client.ObjectKeyFromObject(infraMachine), infraMachine)).To(Succeed())
+		Expect(infraMachine.GetAnnotations()).To(HaveKeyWithValue(controlplanev1.ClusterResetRequestedAnnotation,
+			"2023-01-01T00:00:00Z"))
+		Expect(conditions.GetReason(env.RCP, controlplanev1.EtcdQuorumRecoveredCondition)).
+			To(Equal(controlplanev1.WaitingForClusterResetReason))
+	})
+})
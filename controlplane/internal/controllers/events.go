@@ -0,0 +1,39 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+
+	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+)
+
+// lifecycleEventf records an event of the lifecycle of the control plane on the RKE2ControlPlane and, when
+// ClusterEvents is enabled, on its Cluster as well, annotated with the name of the RKE2ControlPlane.
+func (r *RKE2ControlPlaneReconciler) lifecycleEventf(
+	cluster *clusterv1.Cluster,
+	rcp *controlplanev1.RKE2ControlPlane,
+	eventtype, reason, messageFmt string,
+	args ...interface{},
+) {
+	r.recorder.Eventf(rcp, eventtype, reason, messageFmt, args...)
+
+	if r.ClusterEvents && cluster != nil {
+		r.recorder.AnnotatedEventf(cluster, map[string]string{controlplanev1.ControlPlaneEventAnnotation: rcp.Name},
+			eventtype, reason, messageFmt, args...)
+	}
+}
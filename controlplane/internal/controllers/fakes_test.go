@@ -0,0 +1,263 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/gomega"
+
+	"github.com/pkg/errors"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util/collections"
+
+	bootstrapv1 "github.com/rancher-sandbox/cluster-api-provider-rke2/bootstrap/api/v1alpha1"
+	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+	"github.com/rancher-sandbox/cluster-api-provider-rke2/pkg/rke2"
+)
+
+// errWorkloadClusterUnreachable is returned by the fake management cluster when the workload cluster API is down.
+var errWorkloadClusterUnreachable = &rke2.RemoteClusterConnectionError{Name: "test/cluster", Err: errors.New("connection refused")}
+
+// fakeManagementCluster reads the objects of a fake client, and returns Workload, or WorkloadErr when it is set, as
+// the workload cluster. It records the workload clusters it forgets.
+type fakeManagementCluster struct {
+	*rke2.Management
+
+	Workload    rke2.WorkloadCluster
+	WorkloadErr error
+	Forgotten   []client.ObjectKey
+}
+
+func (f *fakeManagementCluster) GetWorkloadCluster(_ context.Context, _ client.ObjectKey) (rke2.WorkloadCluster, error) {
+	if f.WorkloadErr != nil {
+		return nil, f.WorkloadErr
+	}
+
+	return f.Workload, nil
+}
+
+func (f *fakeManagementCluster) ForgetWorkloadCluster(clusterKey client.ObjectKey) {
+	f.Forgotten = append(f.Forgotten, clusterKey)
+}
+
+// testEnvironment is a control plane of a cluster, with its machines, reconciled by a reconciler reading a fake client.
+type testEnvironment struct {
+	Client            client.Client
+	Reconciler        *RKE2ControlPlaneReconciler
+	ManagementCluster *fakeManagementCluster
+	Recorder          *record.FakeRecorder
+	Cluster           *clusterv1.Cluster
+	RCP               *controlplanev1.RKE2ControlPlane
+}
+
+func newTestScheme() *runtime.Scheme {
+	scheme := runtime.NewScheme()
+
+	for _, addToScheme := range []func(*runtime.Scheme) error{
+		clientgoscheme.AddToScheme, clusterv1.AddToScheme, bootstrapv1.AddToScheme, controlplanev1.AddToScheme,
+	} {
+		Expect(addToScheme(scheme)).To(Succeed())
+	}
+
+	return scheme
+}
+
+// newTestEnvironment returns the environment of an initialized control plane of the given replicas, whose workload
+// cluster is reachable through the workload client, when it is not nil.
+func newTestEnvironment(replicas int32, workloadClient client.Client, objs ...client.Object) *testEnvironment {
+	cluster := &clusterv1.Cluster{
+		ObjectMeta: metav1.ObjectMeta{Namespace: metav1.NamespaceDefault, Name: "cluster"},
+		Spec: clusterv1.ClusterSpec{
+			ControlPlaneEndpoint: clusterv1.APIEndpoint{Host: "cluster.example.com", Port: 6443},
+			ControlPlaneRef: &corev1.ObjectReference{
+				APIVersion: controlplanev1.GroupVersion.String(),
+				Kind:       "RKE2ControlPlane",
+				Name:       "control-plane",
+			},
+		},
+		Status: clusterv1.ClusterStatus{InfrastructureReady: true},
+	}
+
+	rcp := &controlplanev1.RKE2ControlPlane{
+		TypeMeta: metav1.TypeMeta{APIVersion: controlplanev1.GroupVersion.String(), Kind: "RKE2ControlPlane"},
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:       metav1.NamespaceDefault,
+			Name:            "control-plane",
+			UID:             "control-plane-uid",
+			OwnerReferences: []metav1.OwnerReference{{APIVersion: clusterv1.GroupVersion.String(), Kind: "Cluster", Name: "cluster"}},
+			Finalizers:      []string{controlplanev1.RKE2ControlPlaneFinalizer},
+		},
+		Spec: controlplanev1.RKE2ControlPlaneSpec{
+			Replicas: pointer.Int32(replicas),
+			RKE2ConfigSpec: bootstrapv1.RKE2ConfigSpec{
+				AgentConfig: bootstrapv1.RKE2AgentConfig{Version: "v1.26.4+rke2r1"},
+			},
+		},
+		Status: controlplanev1.RKE2ControlPlaneStatus{Initialized: true, Ready: true},
+	}
+
+	scheme := newTestScheme()
+	cl := fake.NewClientBuilder().WithScheme(scheme).WithObjects(append([]client.Object{cluster, rcp}, objs...)...).Build()
+	recorder := record.NewFakeRecorder(100)
+
+	managementCluster := &fakeManagementCluster{Management: &rke2.Management{Client: cl}}
+	if workloadClient != nil {
+		managementCluster.Workload = &rke2.Workload{Client: workloadClient}
+	} else {
+		managementCluster.WorkloadErr = errWorkloadClusterUnreachable
+	}
+
+	return &testEnvironment{
+		Client: cl,
+		Reconciler: &RKE2ControlPlaneReconciler{
+			Client:                    cl,
+			Scheme:                    scheme,
+			managementCluster:         managementCluster,
+			managementClusterUncached: managementCluster,
+			recorder:                  recorder,
+			apiReader:                 cl,
+		},
+		ManagementCluster: managementCluster,
+		Recorder:          recorder,
+		Cluster:           cluster,
+		RCP:               rcp,
+	}
+}
+
+// newControlPlaneMachine returns a control plane machine of the test environment, whose node is ready.
+func newControlPlaneMachine(env *testEnvironment, name string) *clusterv1.Machine {
+	return &clusterv1.Machine{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: env.Cluster.Namespace,
+			Name:      name,
+			Labels: map[string]string{
+				clusterv1.ClusterNameLabel:         env.Cluster.Name,
+				clusterv1.MachineControlPlaneLabel: "",
+			},
+			OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(env.RCP,
+				controlplanev1.GroupVersion.WithKind("RKE2ControlPlane"))},
+		},
+		Spec: clusterv1.MachineSpec{
+			ClusterName: env.Cluster.Name,
+			Version:     pointer.String(env.RCP.Spec.AgentConfig.Version),
+			InfrastructureRef: corev1.ObjectReference{
+				APIVersion: "infrastructure.cluster.x-k8s.io/v1beta1",
+				Kind:       "DockerMachine",
+				Name:       name,
+			},
+		},
+		Status: clusterv1.MachineStatus{
+			InfrastructureReady: true,
+			NodeRef:             &corev1.ObjectReference{Kind: "Node", Name: name},
+			Addresses:           clusterv1.MachineAddresses{{Type: clusterv1.MachineInternalIP, Address: "10.0.0.1"}},
+		},
+	}
+}
+
+// createMachines creates the machines in the test environment.
+func (env *testEnvironment) createMachines(machines ...*clusterv1.Machine) {
+	for _, machine := range machines {
+		status := machine.Status
+		Expect(env.Client.Create(context.Background(), machine)).To(Succeed())
+
+		machine.Status = status
+		Expect(env.Client.Status().Update(context.Background(), machine)).To(Succeed())
+	}
+}
+
+// controlPlane returns the control plane of the machines of the test environment.
+func (env *testEnvironment) controlPlane() *rke2.ControlPlane {
+	machines, err := env.ManagementCluster.GetMachinesForCluster(context.Background(), client.ObjectKeyFromObject(env.Cluster),
+		collections.ControlPlaneMachines(env.Cluster.Name))
+	Expect(err).ToNot(HaveOccurred())
+
+	controlPlane, err := rke2.NewControlPlane(context.Background(), env.Client, env.Cluster, env.RCP, machines, env.RCP.Namespace)
+	Expect(err).ToNot(HaveOccurred())
+
+	return controlPlane
+}
+
+// machine returns the machine of the test environment with the given name.
+func (env *testEnvironment) machine(name string) *clusterv1.Machine {
+	machine := &clusterv1.Machine{}
+	Expect(env.Client.Get(context.Background(), client.ObjectKey{Namespace: env.Cluster.Namespace, Name: name}, machine)).
+		To(Succeed())
+
+	return machine
+}
+
+// completeJob marks the job of the workload cluster succeeded, its pod terminating with the message.
+func completeJob(workloadClient client.Client, job *batchv1.Job, message string) {
+	// The selector is set by the API server.
+	job.Spec.Selector = &metav1.LabelSelector{MatchLabels: map[string]string{"controller-uid": job.Name}}
+	Expect(workloadClient.Update(context.Background(), job)).To(Succeed())
+
+	job.Status.Succeeded = 1
+	Expect(workloadClient.Status().Update(context.Background(), job)).To(Succeed())
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: job.Namespace,
+			Name:      job.Name + "-pod",
+			Labels:    map[string]string{"controller-uid": job.Name},
+		},
+	}
+	Expect(workloadClient.Create(context.Background(), pod)).To(Succeed())
+
+	pod.Status = corev1.PodStatus{
+		Phase: corev1.PodSucceeded,
+		ContainerStatuses: []corev1.ContainerStatus{{
+			State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{Message: message}},
+		}},
+	}
+	Expect(workloadClient.Status().Update(context.Background(), pod)).To(Succeed())
+}
+
+// installProvider makes the provider of the given kind installed in the management cluster of the reconciler.
+func (env *testEnvironment) installProvider(gvk schema.GroupVersionKind) {
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(gvk, meta.RESTScopeNamespace)
+
+	env.Reconciler.Client = &installedProviderClient{
+		Client: env.Reconciler.Client,
+		mapper: meta.MultiRESTMapper{env.Reconciler.Client.RESTMapper(), mapper},
+	}
+}
+
+// installedProviderClient is a client of a management cluster where more providers are installed.
+type installedProviderClient struct {
+	client.Client
+
+	mapper meta.RESTMapper
+}
+
+func (c *installedProviderClient) RESTMapper() meta.RESTMapper {
+	return c.mapper
+}
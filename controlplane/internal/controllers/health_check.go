@@ -0,0 +1,137 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util/collections"
+
+	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+	"github.com/rancher-sandbox/cluster-api-provider-rke2/pkg/rke2"
+)
+
+// reconcileHealthCheck creates or updates the MachineHealthCheck of the control plane machines when the health check
+// is enabled, and deletes it once disabled.
+func (r *RKE2ControlPlaneReconciler) reconcileHealthCheck(
+	ctx context.Context,
+	cluster *clusterv1.Cluster,
+	rcp *controlplanev1.RKE2ControlPlane,
+) error {
+	logger := log.FromContext(ctx)
+	name := rke2.ControlPlaneHealthCheckName(rcp)
+
+	desired := rke2.ControlPlaneHealthCheck(rcp, cluster.Name)
+	if desired != nil {
+		healthCheck := &clusterv1.MachineHealthCheck{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: rcp.Namespace}}
+
+		result, err := controllerutil.CreateOrPatch(ctx, r.Client, healthCheck, func() error {
+			if healthCheck.Labels == nil {
+				healthCheck.Labels = map[string]string{}
+			}
+
+			for k, v := range desired.Labels {
+				healthCheck.Labels[k] = v
+			}
+
+			healthCheck.OwnerReferences = desired.OwnerReferences
+			healthCheck.Spec = desired.Spec
+
+			return nil
+		})
+		if err != nil {
+			return errors.Wrapf(err, "failed to create or update MachineHealthCheck %s", name)
+		}
+
+		if result != controllerutil.OperationResultNone {
+			logger.Info("Reconciled the control plane MachineHealthCheck", "machineHealthCheck", name, "result", result)
+		}
+
+		return nil
+	}
+
+	healthCheck := &clusterv1.MachineHealthCheck{}
+
+	if err := r.Client.Get(ctx, types.NamespacedName{Namespace: rcp.Namespace, Name: name}, healthCheck); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+
+		return errors.Wrapf(err, "failed to get MachineHealthCheck %s", name)
+	}
+
+	// MachineHealthChecks created by users with the same name are left alone.
+	if !metav1.IsControlledBy(healthCheck, rcp) || !healthCheck.DeletionTimestamp.IsZero() {
+		return nil
+	}
+
+	logger.Info("Deleting the disabled control plane MachineHealthCheck", "machineHealthCheck", name)
+
+	if err := r.Client.Delete(ctx, healthCheck); err != nil && !apierrors.IsNotFound(err) {
+		return errors.Wrapf(err, "failed to delete MachineHealthCheck %s", name)
+	}
+
+	return nil
+}
+
+// reconcileRemediation deletes the oldest control plane machine a MachineHealthCheck found unhealthy, so that it is
+// replaced by the scale up, as long as the guards of ValidateRemediation pass. The etcd member of the machine is
+// removed first. A non zero result is returned while a machine is being remediated.
+func (r *RKE2ControlPlaneReconciler) reconcileRemediation(
+	ctx context.Context,
+	cluster *clusterv1.Cluster,
+	controlPlane *rke2.ControlPlane,
+) (ctrl.Result, error) {
+	unhealthy := controlPlane.Machines.Filter(collections.HasUnhealthyCondition)
+	if unhealthy.Len() == 0 {
+		return ctrl.Result{}, nil
+	}
+
+	machine := unhealthy.Oldest()
+	logger := controlPlane.Logger().WithValues("machine", machine.Name)
+
+	if err := rke2.ValidateRemediation(controlPlane.Machines, machine); err != nil {
+		logger.Info("Not remediating the unhealthy control plane machine", "reason", err.Error())
+
+		return ctrl.Result{}, nil
+	}
+
+	if result, err := r.removeEtcdMember(ctx, cluster, controlPlane, machine); err != nil || !result.IsZero() {
+		return result, err
+	}
+
+	logger.Info("Deleting the unhealthy control plane machine")
+
+	if err := r.Client.Delete(ctx, machine); err != nil && !apierrors.IsNotFound(err) {
+		return ctrl.Result{}, errors.Wrapf(err, "failed to delete unhealthy control plane machine %s", machine.Name)
+	}
+
+	r.recorder.Eventf(controlPlane.RCP, corev1.EventTypeNormal, "MachineRemediated",
+		"Deleted unhealthy control plane Machine %s, it is replaced", machine.Name)
+
+	return ctrl.Result{Requeue: true}, nil
+}
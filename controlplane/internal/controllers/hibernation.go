@@ -0,0 +1,184 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util"
+	"sigs.k8s.io/cluster-api/util/collections"
+	"sigs.k8s.io/cluster-api/util/conditions"
+
+	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+	"github.com/rancher-sandbox/cluster-api-provider-rke2/pkg/rke2"
+)
+
+// hibernatedRequeueAfter is how long to wait before checking again if a hibernated control plane should be resumed.
+const hibernatedRequeueAfter = time.Minute
+
+// reconcileHibernation stops the control plane, after taking an etcd snapshot, when hibernation is requested, and
+// waits for the control plane machines to be powered on when it is resumed.
+// A non zero result is returned while the control plane is hibernating or hibernated, as no other operation can be
+// performed on the workload cluster.
+func (r *RKE2ControlPlaneReconciler) reconcileHibernation(ctx context.Context, controlPlane *rke2.ControlPlane) (ctrl.Result, error) {
+	rcp := controlPlane.RCP
+
+	switch {
+	case rcp.Spec.Hibernate && rcp.Status.Hibernated:
+		controlPlane.Decision.Wait("control plane is hibernated")
+
+		return ctrl.Result{RequeueAfter: hibernatedRequeueAfter}, nil
+	case rcp.Spec.Hibernate:
+		controlPlane.Decision.Decide(rke2.ReconcileActionHibernate)
+
+		return r.hibernateControlPlane(ctx, controlPlane)
+	case rcp.Status.Hibernated:
+		controlPlane.Decision.Decide(rke2.ReconcileActionResume)
+
+		return r.resumeControlPlane(ctx, controlPlane)
+	default:
+		return ctrl.Result{}, nil
+	}
+}
+
+func (r *RKE2ControlPlaneReconciler) hibernateControlPlane(ctx context.Context, controlPlane *rke2.ControlPlane) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+	rcp := controlPlane.RCP
+
+	if !rcp.Status.Initialized {
+		logger.Info("ControlPlane not yet initialized, waiting to hibernate")
+
+		return ctrl.Result{RequeueAfter: DefaultRequeueTime}, nil
+	}
+
+	// The controller would stop with the control plane, and couldn't resume it.
+	if controlPlane.IsSelfHosted() {
+		r.recorder.Eventf(rcp, corev1.EventTypeWarning, "HibernationNotAllowed",
+			"Refusing to hibernate the control plane of the cluster the controller runs in")
+		conditions.MarkFalse(rcp, controlplanev1.HibernatedCondition, controlplanev1.HibernationFailedReason,
+			clusterv1.ConditionSeverityWarning, "The control plane of the cluster the controller runs in can't be hibernated")
+
+		return ctrl.Result{}, nil
+	}
+
+	// The nodes are going to be unhealthy, they should not be remediated while the control plane is hibernated.
+	// The nodes running etcd are listed first, the snapshot is taken on the first node.
+	nodeNames := []string{}
+	etcdMachines := controlPlane.EtcdMachines()
+
+	for _, machines := range []collections.Machines{etcdMachines, controlPlane.Machines.Difference(etcdMachines)} {
+		for _, machine := range machines {
+			if machine.Status.NodeRef != nil {
+				nodeNames = append(nodeNames, machine.Status.NodeRef.Name)
+			}
+		}
+	}
+
+	if err := controlPlane.PatchMachineAnnotations(ctx, r.Client, r.apiReader,
+		map[string]string{clusterv1.MachineSkipRemediationAnnotation: ""}); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// The cluster state is kept by the external datastore, there is no etcd snapshot to take.
+	if rcp.Status.HibernationSnapshotName == "" && controlPlane.IsEtcdManaged() {
+		rcp.Status.HibernationSnapshotName = fmt.Sprintf("%s-hibernation-%d", rcp.Name, time.Now().Unix())
+	}
+
+	workloadCluster, err := r.managementCluster.GetWorkloadCluster(ctx, util.ObjectKey(controlPlane.Cluster))
+	if err != nil {
+		return ctrl.Result{}, errors.Wrap(err, "cannot get remote client to workload cluster")
+	}
+
+	snapshotFile, stopped, err := workloadCluster.HibernateControlPlane(ctx, nodeNames, rcp.Status.HibernationSnapshotName,
+		strconv.FormatInt(rcp.Generation, 10))
+	if err != nil {
+		conditions.MarkFalse(rcp, controlplanev1.HibernatedCondition, controlplanev1.HibernationFailedReason,
+			clusterv1.ConditionSeverityWarning, err.Error())
+
+		return ctrl.Result{}, errors.Wrap(err, "failed to hibernate the control plane")
+	}
+
+	if !stopped {
+		conditions.MarkFalse(rcp, controlplanev1.HibernatedCondition, controlplanev1.HibernatingReason,
+			clusterv1.ConditionSeverityInfo, "Taking etcd snapshot %s", rcp.Status.HibernationSnapshotName)
+
+		return ctrl.Result{RequeueAfter: DefaultRequeueTime}, nil
+	}
+
+	// The name of the snapshot file is recorded, it is suffixed with the node name and the time of the snapshot.
+	if snapshotFile != "" {
+		rcp.Status.HibernationSnapshotName = snapshotFile
+	}
+
+	rcp.Status.Hibernated = true
+	conditions.MarkTrue(rcp, controlplanev1.HibernatedCondition)
+
+	if rcp.Status.HibernationSnapshotName == "" {
+		r.recorder.Eventf(rcp, corev1.EventTypeNormal, "Hibernated",
+			"Control plane stopped. To resume, set spec.hibernate to false and power on or reboot the control plane machines.")
+
+		return ctrl.Result{RequeueAfter: hibernatedRequeueAfter}, nil
+	}
+
+	r.recorder.Eventf(rcp, corev1.EventTypeNormal, "Hibernated",
+		"Control plane stopped after taking etcd snapshot %s. To resume, set spec.hibernate to false and power on or "+
+			"reboot the control plane machines. If etcd doesn't recover, restore the snapshot on one machine with "+
+			"\"rke2 server --cluster-reset --cluster-reset-restore-path=%s\".",
+		rcp.Status.HibernationSnapshotName, rcp.Status.HibernationSnapshotName)
+
+	return ctrl.Result{RequeueAfter: hibernatedRequeueAfter}, nil
+}
+
+func (r *RKE2ControlPlaneReconciler) resumeControlPlane(ctx context.Context, controlPlane *rke2.ControlPlane) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+	rcp := controlPlane.RCP
+
+	workloadCluster, err := r.managementCluster.GetWorkloadCluster(ctx, util.ObjectKey(controlPlane.Cluster))
+	if err == nil {
+		err = workloadCluster.ResumeControlPlane(ctx)
+	}
+
+	if err != nil {
+		logger.Info("Waiting for the control plane machines to be powered on", "err", err.Error())
+		conditions.MarkFalse(rcp, controlplanev1.HibernatedCondition, controlplanev1.ResumingReason,
+			clusterv1.ConditionSeverityInfo, "Waiting for the control plane machines to be powered on")
+
+		return ctrl.Result{RequeueAfter: DefaultRequeueTime}, nil
+	}
+
+	if err := controlPlane.PatchMachineAnnotations(ctx, r.Client, r.apiReader, nil,
+		clusterv1.MachineSkipRemediationAnnotation); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	rcp.Status.Hibernated = false
+	rcp.Status.HibernationSnapshotName = ""
+	conditions.Delete(rcp, controlplanev1.HibernatedCondition)
+
+	r.recorder.Eventf(rcp, corev1.EventTypeNormal, "Resumed", "Control plane resumed from hibernation")
+
+	return ctrl.Result{}, nil
+}
@@ -0,0 +1,256 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apiserver/pkg/authentication/serviceaccount"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/controllers/external"
+	"sigs.k8s.io/cluster-api/util/collections"
+	"sigs.k8s.io/cluster-api/util/conditions"
+
+	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+	"github.com/rancher-sandbox/cluster-api-provider-rke2/pkg/rke2"
+)
+
+// reconcileInfrastructureReference checks that the infrastructure template referenced by the RKE2ControlPlane exists
+// and follows the infrastructure machine template contract, so that an invalid reference is reported by the
+// InfrastructureReferenceValid condition and an event instead of failing every machine creation.
+// A template whose provider is not installed or not available, e.g. while the management cluster is upgraded, is
+// reported as waiting for its infrastructure provider.
+// It returns whether machines can be cloned from the template.
+func (r *RKE2ControlPlaneReconciler) reconcileInfrastructureReference(
+	ctx context.Context,
+	rcp *controlplanev1.RKE2ControlPlane,
+) (bool, error) {
+	ref := rcp.Spec.InfrastructureRef
+	gvk := ref.GroupVersionKind()
+
+	if !strings.HasSuffix(gvk.Kind, "MachineTemplate") {
+		r.invalidInfrastructureReference(rcp, "%s is not an infrastructure machine template kind", gvk.Kind)
+
+		return false, nil
+	}
+
+	if _, err := r.Client.RESTMapper().RESTMapping(gvk.GroupKind(), gvk.Version); err != nil {
+		if meta.IsNoMatchError(err) {
+			r.markWaitingForInfrastructureProvider(rcp, controlplanev1.InfrastructureReferenceValidCondition,
+				fmt.Sprintf("%s is not installed in the management cluster", gvk))
+
+			return false, nil
+		}
+
+		return false, errors.Wrapf(err, "failed to get the resource of %s", gvk)
+	}
+
+	namespace, err := r.infrastructureTemplateNamespace(ctx, rcp)
+	if err != nil {
+		if errors.Is(err, errInfrastructureTemplateNotAllowed) {
+			r.invalidInfrastructureReference(rcp, "%s", err)
+
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	template, err := external.Get(ctx, r.Client, &ref, namespace)
+	if err != nil {
+		if apierrors.IsNotFound(errors.Cause(err)) {
+			r.invalidInfrastructureReference(rcp, "%s %s/%s not found", gvk.Kind, namespace, ref.Name)
+
+			return false, nil
+		}
+
+		if isInfrastructureProviderUnavailableError(err) {
+			r.markWaitingForInfrastructureProvider(rcp, controlplanev1.InfrastructureReferenceValidCondition, err.Error())
+
+			return false, nil
+		}
+
+		return false, errors.Wrap(err, "failed to retrieve the infrastructure template")
+	}
+
+	if _, found, _ := unstructured.NestedMap(template.Object, "spec", "template", "spec"); !found {
+		r.invalidInfrastructureReference(rcp, "%s %s/%s doesn't have a spec.template.spec to clone machines from",
+			gvk.Kind, namespace, ref.Name)
+
+		return false, nil
+	}
+
+	r.markInfrastructureProviderAvailable(rcp, controlplanev1.InfrastructureReferenceValidCondition)
+
+	return true, nil
+}
+
+// invalidInfrastructureReference reports an invalid infrastructure template reference, the event is only recorded
+// when the reason changes.
+func (r *RKE2ControlPlaneReconciler) invalidInfrastructureReference(
+	rcp *controlplanev1.RKE2ControlPlane,
+	format string,
+	args ...interface{},
+) {
+	message := fmt.Sprintf(format, args...)
+
+	if !conditions.IsFalse(rcp, controlplanev1.InfrastructureReferenceValidCondition) ||
+		conditions.GetMessage(rcp, controlplanev1.InfrastructureReferenceValidCondition) != message {
+		r.recorder.Event(rcp, corev1.EventTypeWarning, controlplanev1.InvalidInfrastructureReferenceReason, message)
+	}
+
+	conditions.MarkFalse(rcp, controlplanev1.InfrastructureReferenceValidCondition,
+		controlplanev1.InvalidInfrastructureReferenceReason, clusterv1.ConditionSeverityError, "%s", message)
+}
+
+// errInfrastructureTemplateNotAllowed is returned when the RKE2ControlPlane is not allowed to use the infrastructure
+// template it references.
+var errInfrastructureTemplateNotAllowed = errors.New("infrastructure template not allowed")
+
+// infrastructureTemplateNamespace returns the namespace of the infrastructure template referenced by the
+// RKE2ControlPlane, which defaults to the RKE2ControlPlane namespace.
+// A template in another namespace is only used if this namespace is in the allow-list of the controller, and the
+// service accounts of the RKE2ControlPlane namespace are granted the permission to get the template. This lets
+// platform teams share templates from a catalog namespace with a RoleBinding for the
+// "system:serviceaccounts:<tenant namespace>" group.
+func (r *RKE2ControlPlaneReconciler) infrastructureTemplateNamespace(
+	ctx context.Context,
+	rcp *controlplanev1.RKE2ControlPlane,
+) (string, error) {
+	ref := rcp.Spec.InfrastructureRef
+	if ref.Namespace == "" || ref.Namespace == rcp.Namespace {
+		return rcp.Namespace, nil
+	}
+
+	if !r.isInfrastructureTemplateNamespaceAllowed(ref.Namespace) {
+		return "", fmt.Errorf("%w: infrastructure templates can't be referenced from namespace %q, it is not allowed by the controller",
+			errInfrastructureTemplateNotAllowed, ref.Namespace)
+	}
+
+	gvk := ref.GroupVersionKind()
+
+	mapping, err := r.Client.RESTMapper().RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to get the resource of %s", gvk)
+	}
+
+	sar := &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			User:   serviceaccount.MakeUsername(rcp.Namespace, "default"),
+			Groups: serviceaccount.MakeGroupNames(rcp.Namespace),
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Namespace: ref.Namespace,
+				Verb:      "get",
+				Group:     mapping.Resource.Group,
+				Version:   mapping.Resource.Version,
+				Resource:  mapping.Resource.Resource,
+				Name:      ref.Name,
+			},
+		},
+	}
+
+	if err := r.Client.Create(ctx, sar); err != nil {
+		return "", errors.Wrap(err, "failed to review the access to the infrastructure template")
+	}
+
+	if !sar.Status.Allowed {
+		return "", fmt.Errorf("%w: namespace %q is not allowed to use %s %s/%s", errInfrastructureTemplateNotAllowed, rcp.Namespace,
+			schema.GroupResource{Group: mapping.Resource.Group, Resource: mapping.Resource.Resource}, ref.Namespace, ref.Name)
+	}
+
+	return ref.Namespace, nil
+}
+
+// newControlPlane returns the control plane of the owned machines. The infrastructure template the machine images are
+// compared to is only fetched from a namespace the RKE2ControlPlane is allowed to use, as when cloning machines from it.
+func (r *RKE2ControlPlaneReconciler) newControlPlane(
+	ctx context.Context,
+	cluster *clusterv1.Cluster,
+	rcp *controlplanev1.RKE2ControlPlane,
+	ownedMachines collections.Machines,
+) (*rke2.ControlPlane, error) {
+	infraTemplateNamespace := ""
+
+	if rcp.Spec.InfrastructureImageFieldPath != "" {
+		namespace, err := r.infrastructureTemplateNamespace(ctx, rcp)
+
+		switch {
+		case errors.Is(err, errInfrastructureTemplateNotAllowed):
+			// The reference is reported as invalid by the InfrastructureReferenceValid condition.
+			log.FromContext(ctx).V(4).Info("Not comparing the machine images to the infrastructure template",
+				"reason", err.Error())
+		case err != nil:
+			return nil, err
+		default:
+			infraTemplateNamespace = namespace
+		}
+	}
+
+	return rke2.NewControlPlane(ctx, r.Client, cluster, rcp, ownedMachines, infraTemplateNamespace)
+}
+
+func (r *RKE2ControlPlaneReconciler) isInfrastructureTemplateNamespaceAllowed(namespace string) bool {
+	for _, allowed := range r.AllowedInfrastructureTemplateNamespaces {
+		if allowed == namespace {
+			return true
+		}
+	}
+
+	return false
+}
+
+// reconcileInfrastructureMachineAnnotations keeps the annotations of the infrastructure machines of the control plane in
+// sync with InfrastructureMachineAnnotations, so that changing them doesn't require a rollout.
+func (r *RKE2ControlPlaneReconciler) reconcileInfrastructureMachineAnnotations(
+	ctx context.Context,
+	controlPlane *rke2.ControlPlane,
+) error {
+	logger := log.FromContext(ctx)
+
+	for name, infraMachine := range controlPlane.InfrastructureMachines() {
+		if !infraMachine.GetDeletionTimestamp().IsZero() {
+			continue
+		}
+
+		before := infraMachine.DeepCopy()
+		if !rke2.SetInfrastructureMachineAnnotations(infraMachine, controlPlane.RCP.Spec.InfrastructureMachineAnnotations) {
+			continue
+		}
+
+		if err := r.Client.Patch(ctx, infraMachine, client.MergeFrom(before)); err != nil {
+			return errors.Wrapf(err, "failed to patch the annotations of infrastructure machine %s/%s",
+				infraMachine.GetKind(), infraMachine.GetName())
+		}
+
+		logger.V(4).Info("Updated the annotations of the infrastructure machine", "machine", name,
+			"infrastructureMachine", infraMachine.GetName())
+	}
+
+	return nil
+}
@@ -0,0 +1,319 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pkg/errors"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util/collections"
+	"sigs.k8s.io/cluster-api/util/conditions"
+
+	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+)
+
+var _ = Describe("comparing the machine images to the infrastructure template", func() {
+	var (
+		env *testEnvironment
+		cl  *templateAccessClient
+	)
+
+	ctx := context.Background()
+
+	reference := func(namespace string) {
+		env.RCP.Spec.InfrastructureImageFieldPath = "spec.template.spec.image"
+		env.RCP.Spec.InfrastructureRef = corev1.ObjectReference{
+			APIVersion: "infrastructure.cluster.x-k8s.io/v1beta1",
+			Kind:       "DockerMachineTemplate",
+			Namespace:  namespace,
+			Name:       "template",
+		}
+	}
+
+	BeforeEach(func() {
+		templates := []client.Object{}
+
+		for _, namespace := range []string{"default", "catalog"} {
+			template := &unstructured.Unstructured{}
+			template.SetAPIVersion("infrastructure.cluster.x-k8s.io/v1beta1")
+			template.SetKind("DockerMachineTemplate")
+			template.SetNamespace(namespace)
+			template.SetName("template")
+			Expect(unstructured.SetNestedField(template.Object, "image", "spec", "template", "spec", "image")).To(Succeed())
+
+			templates = append(templates, template)
+		}
+
+		env = newTestEnvironment(3, nil, templates...)
+		cl = &templateAccessClient{Client: env.Client}
+		env.Reconciler.Client = cl
+		env.installProvider(schema.GroupVersionKind{
+			Group: "infrastructure.cluster.x-k8s.io", Version: "v1beta1", Kind: "DockerMachineTemplate",
+		})
+	})
+
+	It("should fetch the template of the namespace of the control plane", func() {
+		reference("")
+
+		_, err := env.Reconciler.newControlPlane(ctx, env.Cluster, env.RCP, collections.New())
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(cl.fetched).To(ConsistOf(client.ObjectKey{Namespace: "default", Name: "template"}))
+	})
+
+	It("should not fetch the template of a namespace the controller doesn't allow", func() {
+		reference("catalog")
+
+		_, err := env.Reconciler.newControlPlane(ctx, env.Cluster, env.RCP, collections.New())
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(cl.fetched).To(BeEmpty())
+	})
+
+	It("should fetch the template of an allowed namespace the control plane namespace is granted access to", func() {
+		env.Reconciler.AllowedInfrastructureTemplateNamespaces = []string{"catalog"}
+		cl.allowed = true
+		reference("catalog")
+
+		_, err := env.Reconciler.newControlPlane(ctx, env.Cluster, env.RCP, collections.New())
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(cl.fetched).To(ConsistOf(client.ObjectKey{Namespace: "catalog", Name: "template"}))
+	})
+
+	It("should not fetch the template the namespace of the control plane is not granted access to", func() {
+		env.Reconciler.AllowedInfrastructureTemplateNamespaces = []string{"catalog"}
+		reference("catalog")
+
+		_, err := env.Reconciler.newControlPlane(ctx, env.Cluster, env.RCP, collections.New())
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(cl.fetched).To(BeEmpty())
+	})
+})
+
+var _ = Describe("validating the infrastructure template reference", func() {
+	var (
+		env *testEnvironment
+		cl  *templateAccessClient
+	)
+
+	ctx := context.Background()
+	gvk := schema.GroupVersionKind{Group: "infrastructure.cluster.x-k8s.io", Version: "v1beta1", Kind: "DockerMachineTemplate"}
+
+	reference := func(kind, namespace, name string) {
+		env.RCP.Spec.InfrastructureRef = corev1.ObjectReference{
+			APIVersion: gvk.GroupVersion().String(),
+			Kind:       kind,
+			Namespace:  namespace,
+			Name:       name,
+		}
+	}
+
+	expectInvalid := func(message string) {
+		valid, err := env.Reconciler.reconcileInfrastructureReference(ctx, env.RCP)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(valid).To(BeFalse())
+
+		condition := conditions.Get(env.RCP, controlplanev1.InfrastructureReferenceValidCondition)
+		Expect(condition).ToNot(BeNil())
+		Expect(condition.Status).To(Equal(corev1.ConditionFalse))
+		Expect(condition.Reason).To(Equal(controlplanev1.InvalidInfrastructureReferenceReason))
+		Expect(condition.Severity).To(Equal(clusterv1.ConditionSeverityError))
+		Expect(condition.Message).To(ContainSubstring(message))
+		Expect(env.Recorder.Events).To(Receive(And(HavePrefix(corev1.EventTypeWarning), ContainSubstring(message))))
+	}
+
+	BeforeEach(func() {
+		templates := []client.Object{}
+
+		for _, key := range []client.ObjectKey{
+			{Namespace: "default", Name: "template"},
+			{Namespace: "default", Name: "empty"},
+			{Namespace: "catalog", Name: "template"},
+		} {
+			template := &unstructured.Unstructured{}
+			template.SetGroupVersionKind(gvk)
+			template.SetNamespace(key.Namespace)
+			template.SetName(key.Name)
+
+			if key.Name == "template" {
+				Expect(unstructured.SetNestedMap(template.Object, map[string]interface{}{}, "spec", "template", "spec")).To(Succeed())
+			}
+
+			templates = append(templates, template)
+		}
+
+		env = newTestEnvironment(3, nil, templates...)
+		cl = &templateAccessClient{Client: env.Client}
+		env.Reconciler.Client = cl
+		reference(gvk.Kind, "", "template")
+	})
+
+	Context("with the infrastructure provider installed", func() {
+		BeforeEach(func() {
+			env.installProvider(gvk)
+		})
+
+		It("should accept a template of the namespace of the control plane", func() {
+			valid, err := env.Reconciler.reconcileInfrastructureReference(ctx, env.RCP)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(valid).To(BeTrue())
+
+			Expect(conditions.IsTrue(env.RCP, controlplanev1.InfrastructureReferenceValidCondition)).To(BeTrue())
+			Expect(cl.reviews).To(BeEmpty())
+			Expect(env.Recorder.Events).ToNot(Receive())
+		})
+
+		It("should reject a kind that is not an infrastructure machine template", func() {
+			reference("DockerMachine", "", "template")
+
+			expectInvalid("DockerMachine is not an infrastructure machine template kind")
+		})
+
+		It("should reject a template that doesn't exist", func() {
+			reference(gvk.Kind, "", "missing")
+
+			expectInvalid("DockerMachineTemplate default/missing not found")
+		})
+
+		It("should reject a template without a spec.template.spec", func() {
+			reference(gvk.Kind, "", "empty")
+
+			expectInvalid("DockerMachineTemplate default/empty doesn't have a spec.template.spec")
+		})
+
+		It("should record an event only when the reason it is invalid changes", func() {
+			reference(gvk.Kind, "", "missing")
+			expectInvalid("default/missing not found")
+
+			valid, err := env.Reconciler.reconcileInfrastructureReference(ctx, env.RCP)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(valid).To(BeFalse())
+			Expect(env.Recorder.Events).ToNot(Receive())
+
+			reference(gvk.Kind, "", "empty")
+			expectInvalid("default/empty doesn't have a spec.template.spec")
+		})
+
+		It("should reject a template of a namespace the controller doesn't allow", func() {
+			cl.allowed = true
+			reference(gvk.Kind, "catalog", "template")
+
+			expectInvalid(`infrastructure templates can't be referenced from namespace "catalog"`)
+			Expect(cl.reviews).To(BeEmpty())
+		})
+
+		It("should review the access to the template of an allowed namespace", func() {
+			env.Reconciler.AllowedInfrastructureTemplateNamespaces = []string{"catalog"}
+			cl.allowed = true
+			reference(gvk.Kind, "catalog", "template")
+
+			valid, err := env.Reconciler.reconcileInfrastructureReference(ctx, env.RCP)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(valid).To(BeTrue())
+
+			Expect(cl.reviews).To(ConsistOf(authorizationv1.SubjectAccessReviewSpec{
+				User:   "system:serviceaccount:default:default",
+				Groups: []string{"system:serviceaccounts", "system:serviceaccounts:default"},
+				ResourceAttributes: &authorizationv1.ResourceAttributes{
+					Namespace: "catalog",
+					Verb:      "get",
+					Group:     gvk.Group,
+					Version:   gvk.Version,
+					Resource:  "dockermachinetemplates",
+					Name:      "template",
+				},
+			}))
+		})
+
+		It("should reject a template the namespace of the control plane is not granted access to", func() {
+			env.Reconciler.AllowedInfrastructureTemplateNamespaces = []string{"catalog"}
+			reference(gvk.Kind, "catalog", "template")
+
+			expectInvalid(`namespace "default" is not allowed to use dockermachinetemplates.infrastructure.cluster.x-k8s.io catalog/template`)
+		})
+
+		It("should retry when the access to the template can't be reviewed", func() {
+			env.Reconciler.AllowedInfrastructureTemplateNamespaces = []string{"catalog"}
+			cl.reviewErr = errors.New("connection refused")
+			reference(gvk.Kind, "catalog", "template")
+
+			valid, err := env.Reconciler.reconcileInfrastructureReference(ctx, env.RCP)
+			Expect(err).To(MatchError(ContainSubstring("connection refused")))
+			Expect(valid).To(BeFalse())
+
+			Expect(conditions.Has(env.RCP, controlplanev1.InfrastructureReferenceValidCondition)).To(BeFalse())
+			Expect(env.Recorder.Events).ToNot(Receive())
+		})
+	})
+
+	It("should wait for the infrastructure provider of a kind that is not installed", func() {
+		valid, err := env.Reconciler.reconcileInfrastructureReference(ctx, env.RCP)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(valid).To(BeFalse())
+
+		Expect(conditions.GetReason(env.RCP, controlplanev1.InfrastructureReferenceValidCondition)).
+			To(Equal(controlplanev1.WaitingForInfrastructureProviderReason))
+		Expect(conditions.GetMessage(env.RCP, controlplanev1.InfrastructureReferenceValidCondition)).
+			To(ContainSubstring("is not installed in the management cluster"))
+	})
+})
+
+// templateAccessClient is a client recording the infrastructure templates it gets, and answering the reviews of the
+// access to them.
+type templateAccessClient struct {
+	client.Client
+
+	allowed   bool
+	reviewErr error
+	reviews   []authorizationv1.SubjectAccessReviewSpec
+	fetched   []client.ObjectKey
+}
+
+func (c *templateAccessClient) Get(ctx context.Context, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+	if u, ok := obj.(*unstructured.Unstructured); ok && u.GetKind() == "DockerMachineTemplate" {
+		c.fetched = append(c.fetched, key)
+	}
+
+	return c.Client.Get(ctx, key, obj, opts...)
+}
+
+func (c *templateAccessClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	if sar, ok := obj.(*authorizationv1.SubjectAccessReview); ok {
+		if c.reviewErr != nil {
+			return c.reviewErr
+		}
+
+		c.reviews = append(c.reviews, sar.Spec)
+		sar.Status.Allowed = c.allowed
+
+		return nil
+	}
+
+	return c.Client.Create(ctx, obj, opts...)
+}
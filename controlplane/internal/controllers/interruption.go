@@ -0,0 +1,121 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util"
+	"sigs.k8s.io/cluster-api/util/collections"
+
+	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+	"github.com/rancher-sandbox/cluster-api-provider-rke2/pkg/rke2"
+)
+
+// reconcileInterruptions replaces the control plane machines about to be interrupted, as announced by the
+// infrastructure provider with the interruption imminent annotation, one machine at a time. The etcd leadership is
+// moved off the machine first, then a replacement machine is created, and the interrupted machine is deleted once the
+// replacement is healthy. A non zero result is returned while a machine is replaced.
+func (r *RKE2ControlPlaneReconciler) reconcileInterruptions(
+	ctx context.Context,
+	cluster *clusterv1.Cluster,
+	controlPlane *rke2.ControlPlane,
+) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+	rcp := controlPlane.RCP
+
+	machine := controlPlane.InterruptedMachines().Oldest()
+	if machine == nil {
+		return ctrl.Result{}, nil
+	}
+
+	logger = logger.WithValues("machine", machine.Name)
+
+	// The machine is replaced by a machine of the same role when the roles are split.
+	roleControlPlane := controlPlane
+	desiredReplicas := *rcp.Spec.Replicas
+
+	if rcp.Spec.EtcdReplicas != nil {
+		role := controlplanev1.MachineRole(machine.Labels[controlplanev1.MachineRoleLabel])
+		roleControlPlane = controlPlane.ForRole(role)
+		desiredReplicas = rcp.Spec.RoleReplicas(role)
+	}
+
+	// The etcd cluster doesn't have to elect a new leader when the machine disappears.
+	if controlPlane.IsEtcdManaged() && !rke2.HasRole(controlplanev1.ControlPlaneMachineRole)(machine) {
+		r.forwardEtcdLeadership(ctx, cluster, controlPlane, machine)
+	}
+
+	if int32(roleControlPlane.Machines.Len()) <= desiredReplicas {
+		logger.Info("Creating a replacement for the control plane machine about to be interrupted")
+		r.recorder.Eventf(rcp, corev1.EventTypeNormal, "InterruptionImminent",
+			"Replacing control plane Machine %s before it is interrupted", machine.Name)
+
+		return r.scaleUpControlPlane(ctx, cluster, rcp, roleControlPlane)
+	}
+
+	logger.Info("Deleting the control plane machine about to be interrupted, its replacement is created")
+
+	result, err := r.scaleDownControlPlane(ctx, cluster, rcp, roleControlPlane, collections.FromMachines(machine))
+	if err != nil || !result.IsZero() {
+		return result, err
+	}
+
+	return ctrl.Result{Requeue: true}, nil
+}
+
+// forwardEtcdLeadership moves the etcd leadership off a machine about to be interrupted, to the oldest healthy machine
+// running etcd. A failure is only logged, the etcd cluster elects a new leader by itself once the machine is gone.
+func (r *RKE2ControlPlaneReconciler) forwardEtcdLeadership(
+	ctx context.Context,
+	cluster *clusterv1.Cluster,
+	controlPlane *rke2.ControlPlane,
+	machine *clusterv1.Machine,
+) {
+	logger := log.FromContext(ctx).WithValues("machine", machine.Name)
+
+	leaderCandidate := controlPlane.EtcdLeaderCandidate(machine)
+	if leaderCandidate == nil {
+		logger.Info("No healthy machine to move the etcd leadership to")
+
+		return
+	}
+
+	workloadCluster, err := r.managementCluster.GetWorkloadCluster(ctx, util.ObjectKey(cluster))
+	if err != nil {
+		logger.Info("Failed to move the etcd leadership, the workload cluster is unreachable", "error", err.Error())
+
+		return
+	}
+
+	err = workloadCluster.ForwardEtcdLeadership(ctx, machine, leaderCandidate)
+	if errors.Is(err, rke2.ErrEtcdClientUnavailable) {
+		logger.Info("Skipping the move of the etcd leadership, etcd can't be reached by the management cluster")
+
+		return
+	}
+
+	if err != nil {
+		logger.Info("Failed to move the etcd leadership", "candidate", leaderCandidate.Name, "error", err.Error())
+	}
+}
@@ -0,0 +1,188 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util/collections"
+	"sigs.k8s.io/cluster-api/util/conditions"
+
+	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+	"github.com/rancher-sandbox/cluster-api-provider-rke2/pkg/rke2"
+)
+
+// reconcileMachineRoles rolls out and scales the machines of a control plane whose roles are split: the etcd machines
+// are created, rolled out and scaled first, then the control plane machines, each role against its own replicas. The
+// control plane is initialized on an etcd machine, the control plane machines join it. A zero result is returned once
+// the machines of both roles are up to date and scaled.
+func (r *RKE2ControlPlaneReconciler) reconcileMachineRoles(
+	ctx context.Context,
+	cluster *clusterv1.Cluster,
+	rcp *controlplanev1.RKE2ControlPlane,
+	controlPlane *rke2.ControlPlane,
+) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	etcdControlPlane := controlPlane.ForRole(controlplanev1.EtcdMachineRole)
+	apiServerControlPlane := controlPlane.ForRole(controlplanev1.ControlPlaneMachineRole)
+
+	switch {
+	// We are recovering a control plane which lost all its machines
+	case controlPlane.Machines.Len() == 0 && conditions.IsTrue(cluster, clusterv1.ControlPlaneInitializedCondition):
+		logger.Info("Recovering control plane on an etcd machine")
+		controlPlane.Decision.Decide(rke2.ReconcileActionInitialize)
+
+		return r.recoverControlPlane(ctx, cluster, rcp, etcdControlPlane)
+	// We are creating the first etcd replica
+	case controlPlane.Machines.Len() == 0:
+		logger.Info("Initializing control plane on an etcd machine")
+		controlPlane.Decision.Decide(rke2.ReconcileActionInitialize)
+		conditions.MarkFalse(rcp,
+			controlplanev1.AvailableCondition,
+			controlplanev1.WaitingForRKE2ServerReason,
+			clusterv1.ConditionSeverityInfo, "")
+
+		return r.initializeControlPlane(ctx, cluster, rcp, etcdControlPlane, nil)
+	case etcdControlPlane.Machines.Len() == 0:
+		logger.Info("The control plane machines can't run without etcd machines, " +
+			"delete them to recover the control plane from the last etcd snapshot")
+		controlPlane.Decision.Wait("control plane machines can't run without etcd machines")
+
+		return ctrl.Result{RequeueAfter: DefaultRequeueTime}, nil
+	case apiServerControlPlane.Machines.Len() == 0:
+		logger.Info("Creating the first control plane machine", "Desired", rcp.Spec.RoleReplicas(controlplanev1.ControlPlaneMachineRole))
+		controlPlane.Decision.Decide(rke2.ReconcileActionScaleUp)
+
+		return r.scaleUpControlPlane(ctx, cluster, rcp, apiServerControlPlane)
+	}
+
+	if needRollout := controlPlane.MachinesNeedingRollout(); len(needRollout) > 0 {
+		if supported, err := r.startRollout(ctx, cluster, controlPlane, needRollout); err != nil || !supported {
+			return ctrl.Result{}, err
+		}
+	}
+
+	for _, roleControlPlane := range []*rke2.ControlPlane{etcdControlPlane, apiServerControlPlane} {
+		if result, err := r.reconcileRoleMachines(ctx, cluster, rcp, roleControlPlane); err != nil || !result.IsZero() {
+			return result, err
+		}
+	}
+
+	r.completeRollout(cluster, rcp)
+
+	return ctrl.Result{}, nil
+}
+
+// reconcileRoleMachines rolls out and scales the machines of one role of the control plane. A zero result is returned
+// once its machines are up to date and scaled.
+func (r *RKE2ControlPlaneReconciler) reconcileRoleMachines(
+	ctx context.Context,
+	cluster *clusterv1.Cluster,
+	rcp *controlplanev1.RKE2ControlPlane,
+	roleControlPlane *rke2.ControlPlane,
+) (ctrl.Result, error) {
+	logger := log.FromContext(ctx).WithValues("role", roleControlPlane.Role)
+
+	numMachines := roleControlPlane.Machines.Len()
+	desiredReplicas := int(rcp.Spec.RoleReplicas(roleControlPlane.Role))
+
+	if needRollout := roleControlPlane.MachinesNeedingRollout(); len(needRollout) > 0 {
+		logger.Info("Rolling out Control Plane machines", "needRollout", needRollout.Names(),
+			"inRemovedFailureDomains", roleControlPlane.MachinesInRemovedFailureDomains().Names())
+		roleControlPlane.Decision.Decide(rke2.ReconcileActionRollOut)
+
+		replicas := r.rolloutReplicas(ctx, rcp, roleControlPlane.Role)
+
+		return r.upgradeControlPlane(ctx, cluster, rcp, roleControlPlane, replicas, needRollout)
+	}
+
+	completeRolloutReplicas(ctx, rcp, roleControlPlane.Role)
+
+	switch {
+	case numMachines < desiredReplicas:
+		logger.Info("Scaling up control plane", "Desired", desiredReplicas, "Existing", numMachines)
+		roleControlPlane.Decision.Decide(rke2.ReconcileActionScaleUp)
+
+		return r.scaleUpControlPlane(ctx, cluster, rcp, roleControlPlane)
+	case numMachines > desiredReplicas:
+		logger.Info("Scaling down control plane", "Desired", desiredReplicas, "Existing", numMachines)
+		roleControlPlane.Decision.Decide(rke2.ReconcileActionScaleDown)
+
+		return r.scaleDownControlPlane(ctx, cluster, rcp, roleControlPlane, collections.Machines{})
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// rolloutReplicasStatus returns the status fields holding the replicas kept during the rollout of the machines of a
+// role, and the replicas queued until it completes.
+func rolloutReplicasStatus(rcp *controlplanev1.RKE2ControlPlane, role controlplanev1.MachineRole) (rollout, queued **int32) {
+	if role == controlplanev1.EtcdMachineRole {
+		return &rcp.Status.EtcdRolloutReplicas, &rcp.Status.QueuedEtcdReplicas
+	}
+
+	return &rcp.Status.RolloutReplicas, &rcp.Status.QueuedReplicas
+}
+
+// rolloutReplicas returns the replicas of a role to keep while its machines are rolled out: the replicas changed
+// during the rollout are applied once it is safe for the etcd quorum, a scale down is queued until it completes.
+func (r *RKE2ControlPlaneReconciler) rolloutReplicas(
+	ctx context.Context,
+	rcp *controlplanev1.RKE2ControlPlane,
+	role controlplanev1.MachineRole,
+) int32 {
+	logger := log.FromContext(ctx)
+
+	rolloutReplicas, queuedReplicas := rolloutReplicasStatus(rcp, role)
+
+	replicas, queued := rke2.RolloutReplicas(rcp.Spec.RoleReplicas(role), *rolloutReplicas)
+	if queued != nil && (*queuedReplicas == nil || **queuedReplicas != *queued) {
+		logger.Info("Queuing the scale down until the rollout completes", "Desired", *queued, "Rollout", replicas, "role", role)
+
+		if role == controlplanev1.EtcdMachineRole {
+			r.recorder.Eventf(rcp, corev1.EventTypeNormal, "ScaleDownQueued",
+				"Scaling down to %d etcd replicas once the rollout completes", *queued)
+		} else {
+			r.recorder.Eventf(rcp, corev1.EventTypeNormal, "ScaleDownQueued",
+				"Scaling down to %d replicas once the rollout completes", *queued)
+		}
+	}
+
+	*rolloutReplicas = &replicas
+	*queuedReplicas = queued
+
+	return replicas
+}
+
+// completeRolloutReplicas clears the replicas kept during the rollout of the machines of a role once it completes,
+// the scale down queued meanwhile is then applied.
+func completeRolloutReplicas(ctx context.Context, rcp *controlplanev1.RKE2ControlPlane, role controlplanev1.MachineRole) {
+	rolloutReplicas, queuedReplicas := rolloutReplicasStatus(rcp, role)
+
+	if *queuedReplicas != nil {
+		log.FromContext(ctx).Info("Applying the scale down queued during the rollout", "Desired", rcp.Spec.RoleReplicas(role), "role", role)
+	}
+
+	*rolloutReplicas = nil
+	*queuedReplicas = nil
+}
@@ -0,0 +1,171 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util/conditions"
+
+	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+	"github.com/rancher-sandbox/cluster-api-provider-rke2/pkg/rke2"
+)
+
+var _ = Describe("split machine roles", func() {
+	var (
+		env            *testEnvironment
+		workloadClient client.Client
+	)
+
+	ctx := context.Background()
+
+	roleMachine := func(name string, role controlplanev1.MachineRole) *clusterv1.Machine {
+		machine := newControlPlaneMachine(env, name)
+		machine.Labels[controlplanev1.MachineRoleLabel] = string(role)
+		conditions.MarkTrue(machine, controlplanev1.MachineAgentHealthyCondition)
+
+		return machine
+	}
+
+	machineRoles := func() map[string]string {
+		machines := &clusterv1.MachineList{}
+		Expect(env.Client.List(ctx, machines)).To(Succeed())
+
+		roles := map[string]string{}
+
+		for _, machine := range machines.Items {
+			if machine.DeletionTimestamp.IsZero() {
+				roles[machine.Name] = machine.Labels[controlplanev1.MachineRoleLabel]
+			}
+		}
+
+		return roles
+	}
+
+	BeforeEach(func() {
+		template := &unstructured.Unstructured{}
+		template.SetAPIVersion("infrastructure.cluster.x-k8s.io/v1beta1")
+		template.SetKind("DockerMachineTemplate")
+		template.SetNamespace(metav1.NamespaceDefault)
+		template.SetName("template")
+		Expect(unstructured.SetNestedMap(template.Object, map[string]interface{}{}, "spec", "template", "spec")).To(Succeed())
+
+		nodes := []client.Object{}
+		for _, name := range []string{"etcd-1", "etcd-2", "etcd-3", "control-plane-1"} {
+			nodes = append(nodes, &corev1.Node{ObjectMeta: metav1.ObjectMeta{
+				Name:   name,
+				Labels: map[string]string{"node-role.kubernetes.io/master": "true"},
+			}})
+		}
+
+		workloadClient = fake.NewClientBuilder().WithScheme(newTestScheme()).WithObjects(nodes...).Build()
+
+		env = newTestEnvironment(1, workloadClient, template)
+		env.RCP.Spec.EtcdReplicas = pointer.Int32(3)
+		env.RCP.Spec.InfrastructureRef = corev1.ObjectReference{
+			APIVersion: "infrastructure.cluster.x-k8s.io/v1beta1",
+			Kind:       "DockerMachineTemplate",
+			Name:       "template",
+		}
+	})
+
+	It("should initialize the control plane on an etcd machine", func() {
+		env.RCP.Status.Initialized = false
+
+		_, err := env.Reconciler.reconcileMachineRoles(ctx, env.Cluster, env.RCP, env.controlPlane())
+		Expect(err).ToNot(HaveOccurred())
+
+		roles := machineRoles()
+		Expect(roles).To(HaveLen(1))
+
+		for _, role := range roles {
+			Expect(role).To(Equal(string(controlplanev1.EtcdMachineRole)))
+		}
+	})
+
+	It("should create the first control plane machine once etcd machines run", func() {
+		env.createMachines(roleMachine("etcd-1", controlplanev1.EtcdMachineRole))
+
+		_, err := env.Reconciler.reconcileMachineRoles(ctx, env.Cluster, env.RCP, env.controlPlane())
+		Expect(err).ToNot(HaveOccurred())
+
+		roles := machineRoles()
+		Expect(roles).To(HaveLen(2))
+		Expect(roles).To(ContainElement(string(controlplanev1.ControlPlaneMachineRole)))
+	})
+
+	It("should queue the scale down of the etcd machines until they are rolled out", func() {
+		// The datastore is external, no etcd snapshot is taken before the rollout.
+		env.RCP.Spec.ServerConfig.ExternalDatastore = &controlplanev1.ExternalDatastore{Endpoint: "https://etcd.example.com:2379"}
+		env.RCP.Spec.EtcdReplicas = pointer.Int32(1)
+		env.RCP.Status.EtcdRolloutReplicas = pointer.Int32(3)
+		env.createMachines(
+			roleMachine("etcd-1", controlplanev1.EtcdMachineRole),
+			roleMachine("etcd-2", controlplanev1.EtcdMachineRole),
+			roleMachine("etcd-3", controlplanev1.EtcdMachineRole),
+		)
+
+		_, err := env.Reconciler.reconcileRoleMachines(ctx, env.Cluster, env.RCP,
+			env.controlPlane().ForRole(controlplanev1.EtcdMachineRole))
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(env.RCP.Status.EtcdRolloutReplicas).To(Equal(pointer.Int32(3)))
+		Expect(env.RCP.Status.QueuedEtcdReplicas).To(Equal(pointer.Int32(1)))
+		Expect(env.RCP.Status.RolloutReplicas).To(BeNil())
+		Expect(env.RCP.Status.QueuedReplicas).To(BeNil())
+		Expect(env.Recorder.Events).To(Receive(ContainSubstring("Scaling down to 1 etcd replicas once the rollout completes")))
+	})
+
+	It("should take the etcd snapshot on an etcd machine before scaling both roles to zero", func() {
+		env.RCP.Annotations = map[string]string{controlplanev1.AllowScaleToZeroAnnotation: ""}
+		env.RCP.Spec.Replicas = pointer.Int32(0)
+		env.RCP.Spec.EtcdReplicas = pointer.Int32(0)
+		env.RCP.Generation = 2
+
+		etcdMachine := roleMachine("etcd-1", controlplanev1.EtcdMachineRole)
+		controlPlaneMachine := roleMachine("control-plane-1", controlplanev1.ControlPlaneMachineRole)
+		conditions.MarkTrue(etcdMachine, clusterv1.ReadyCondition)
+		conditions.MarkTrue(controlPlaneMachine, clusterv1.ReadyCondition)
+		env.createMachines(controlPlaneMachine, etcdMachine)
+
+		_, err := env.Reconciler.scaleDownControlPlaneToZero(ctx, env.Cluster, env.RCP, env.controlPlane())
+		Expect(err).ToNot(HaveOccurred())
+
+		jobs := &batchv1.JobList{}
+		Expect(workloadClient.List(ctx, jobs, client.InNamespace(rke2.HibernationNamespace))).To(Succeed())
+		Expect(jobs.Items).To(HaveLen(1))
+		Expect(jobs.Items[0].Spec.Template.Spec.NodeName).To(Equal("etcd-1"))
+
+		completeJob(workloadClient, &jobs.Items[0], "control-plane-scale-to-zero-2-etcd-1-1700000000")
+
+		_, err = env.Reconciler.scaleDownControlPlaneToZero(ctx, env.Cluster, env.RCP, env.controlPlane())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(machineRoles()).To(BeEmpty())
+	})
+})
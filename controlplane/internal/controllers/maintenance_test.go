@@ -0,0 +1,186 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util/conditions"
+
+	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+)
+
+var _ = Describe("maintenance mode", func() {
+	var (
+		env            *testEnvironment
+		workloadClient client.Client
+	)
+
+	ctx := context.Background()
+
+	BeforeEach(func() {
+		// The node of a deleted machine is left in the workload cluster.
+		staleNode := &corev1.Node{ObjectMeta: metav1.ObjectMeta{
+			Name:        "machine-2",
+			Annotations: map[string]string{clusterv1.MachineAnnotation: "machine-2"},
+		}}
+
+		workloadClient = fake.NewClientBuilder().WithScheme(newTestScheme()).WithObjects(staleNode).Build()
+		env = newTestEnvironment(1, workloadClient)
+		env.RCP.Spec.Maintenance = true
+		env.RCP.Annotations = map[string]string{controlplanev1.RotateSecretsEncryptionKeyAnnotation: "rotation-1"}
+		env.createMachines(newControlPlaneMachine(env, "machine-1"))
+	})
+
+	It("should neither rotate the secrets encryption key nor delete the stale nodes", func() {
+		result, err := env.Reconciler.reconcileNormal(ctx, env.Cluster, env.RCP)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result).To(Equal(ctrl.Result{}))
+		Expect(conditions.IsTrue(env.RCP, controlplanev1.MaintenanceCondition)).To(BeTrue())
+
+		Expect(env.RCP.Status.SecretsEncryptionKeyRotation).To(BeNil())
+
+		jobs := &batchv1.JobList{}
+		Expect(workloadClient.List(ctx, jobs)).To(Succeed())
+		Expect(jobs.Items).To(BeEmpty())
+
+		Expect(workloadClient.Get(ctx, client.ObjectKey{Name: "machine-2"}, &corev1.Node{})).To(Succeed())
+	})
+})
+
+var _ = Describe("maintenance mode scaling and rollout", func() {
+	var env *testEnvironment
+
+	ctx := context.Background()
+
+	machines := func() []string {
+		machines := &clusterv1.MachineList{}
+		Expect(env.Client.List(ctx, machines)).To(Succeed())
+
+		names := []string{}
+		for _, machine := range machines.Items {
+			names = append(names, machine.Name)
+		}
+
+		return names
+	}
+
+	reconcile := func() *controlplanev1.RKE2ControlPlane {
+		_, err := env.Reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(env.RCP)})
+		Expect(err).ToNot(HaveOccurred())
+
+		rcp := &controlplanev1.RKE2ControlPlane{}
+		Expect(env.Client.Get(ctx, client.ObjectKeyFromObject(env.RCP), rcp)).To(Succeed())
+
+		return rcp
+	}
+
+	update := func(mutate func(rcp *controlplanev1.RKE2ControlPlane)) {
+		rcp := &controlplanev1.RKE2ControlPlane{}
+		Expect(env.Client.Get(ctx, client.ObjectKeyFromObject(env.RCP), rcp)).To(Succeed())
+		mutate(rcp)
+		Expect(env.Client.Update(ctx, rcp)).To(Succeed())
+	}
+
+	setup := func(replicas int32, names ...string) {
+		template := &unstructured.Unstructured{}
+		template.SetAPIVersion("infrastructure.cluster.x-k8s.io/v1beta1")
+		template.SetKind("DockerMachineTemplate")
+		template.SetNamespace(metav1.NamespaceDefault)
+		template.SetName("template")
+		Expect(unstructured.SetNestedMap(template.Object, map[string]interface{}{}, "spec", "template", "spec")).To(Succeed())
+
+		nodes := []client.Object{}
+		for _, name := range names {
+			nodes = append(nodes, &corev1.Node{ObjectMeta: metav1.ObjectMeta{
+				Name:   name,
+				Labels: map[string]string{"node-role.kubernetes.io/master": "true"},
+			}})
+		}
+
+		workloadClient := fake.NewClientBuilder().WithScheme(newTestScheme()).WithObjects(nodes...).Build()
+
+		env = newTestEnvironment(replicas, workloadClient, template)
+
+		env.installProvider(template.GroupVersionKind())
+
+		for _, name := range names {
+			machine := newControlPlaneMachine(env, name)
+			conditions.MarkTrue(machine, controlplanev1.MachineAgentHealthyCondition)
+			env.createMachines(machine)
+		}
+
+		update(func(rcp *controlplanev1.RKE2ControlPlane) {
+			rcp.Spec.InfrastructureRef = corev1.ObjectReference{
+				APIVersion: "infrastructure.cluster.x-k8s.io/v1beta1",
+				Kind:       "DockerMachineTemplate",
+				Name:       "template",
+			}
+			// The datastore is external, no etcd snapshot is taken before the rollout.
+			rcp.Spec.ServerConfig.ExternalDatastore = &controlplanev1.ExternalDatastore{Endpoint: "https://etcd.example.com:2379"}
+			rcp.Spec.Maintenance = true
+		})
+	}
+
+	It("should not scale the control plane up", func() {
+		setup(3, "machine-1")
+
+		rcp := reconcile()
+
+		Expect(conditions.IsTrue(rcp, controlplanev1.MaintenanceCondition)).To(BeTrue())
+		Expect(machines()).To(ConsistOf("machine-1"))
+	})
+
+	It("should not roll the outdated machines out until the maintenance ends", func() {
+		setup(3, "machine-1", "machine-2", "machine-3")
+
+		update(func(rcp *controlplanev1.RKE2ControlPlane) {
+			rcp.Spec.RolloutAfter = &metav1.Time{Time: time.Now().Add(-time.Second)}
+		})
+
+		rcp := reconcile()
+
+		Expect(conditions.IsTrue(rcp, controlplanev1.MaintenanceCondition)).To(BeTrue())
+		Expect(conditions.Has(rcp, controlplanev1.MachinesSpecUpToDateCondition)).To(BeFalse())
+		Expect(env.Recorder.Events).ToNot(Receive(ContainSubstring("RolloutTriggered")))
+		Expect(machines()).To(ConsistOf("machine-1", "machine-2", "machine-3"))
+
+		update(func(rcp *controlplanev1.RKE2ControlPlane) {
+			rcp.Spec.Maintenance = false
+		})
+
+		rcp = reconcile()
+
+		Expect(conditions.Has(rcp, controlplanev1.MaintenanceCondition)).To(BeFalse())
+		Expect(conditions.GetReason(rcp, controlplanev1.MachinesSpecUpToDateCondition)).
+			To(Equal(controlplanev1.RollingUpdateInProgressReason))
+		Expect(env.Recorder.Events).To(Receive(ContainSubstring("RolloutTriggered")))
+	})
+})
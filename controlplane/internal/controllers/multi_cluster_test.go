@@ -0,0 +1,204 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/pointer"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util/collections"
+
+	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+	"github.com/rancher-sandbox/cluster-api-provider-rke2/pkg/rke2"
+)
+
+var _ = Describe("control planes sharing a namespace", func() {
+	const namespace = "shared"
+
+	// The name of a cluster is a prefix of the other, so that prefix matches are caught.
+	clusterNames := []string{"cluster", "cluster-2"}
+
+	var (
+		ctx      = context.Background()
+		clusters map[string]*clusterv1.Cluster
+		rcps     map[string]*controlplanev1.RKE2ControlPlane
+	)
+
+	BeforeEach(func() {
+		ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: namespace}}
+		if err := k8sClient.Create(ctx, ns); err != nil && !apierrors.IsAlreadyExists(err) {
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		clusters = map[string]*clusterv1.Cluster{}
+		rcps = map[string]*controlplanev1.RKE2ControlPlane{}
+
+		for _, name := range clusterNames {
+			rcp := &controlplanev1.RKE2ControlPlane{
+				ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name + "-control-plane", UID: types.UID(name + "-uid")},
+			}
+			rcp.SetGroupVersionKind(controlplanev1.GroupVersion.WithKind("RKE2ControlPlane"))
+			rcps[name] = rcp
+
+			cluster := &clusterv1.Cluster{
+				ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+				Spec: clusterv1.ClusterSpec{
+					ControlPlaneRef: &corev1.ObjectReference{
+						APIVersion: controlplanev1.GroupVersion.String(),
+						Kind:       "RKE2ControlPlane",
+						Name:       rcp.Name,
+					},
+				},
+			}
+			Expect(k8sClient.Create(ctx, cluster)).To(Succeed())
+			clusters[name] = cluster
+
+			for i := 0; i < 2; i++ {
+				machine := newTestMachine(namespace, fmt.Sprintf("%s-control-plane-%d", name, i), name)
+				machine.Labels = rke2.ControlPlaneLabelsForCluster(name)
+				machine.OwnerReferences = []metav1.OwnerReference{
+					*metav1.NewControllerRef(rcp, controlplanev1.GroupVersion.WithKind("RKE2ControlPlane")),
+				}
+				Expect(k8sClient.Create(ctx, machine)).To(Succeed())
+			}
+
+			Expect(k8sClient.Create(ctx, newTestMachine(namespace, name+"-worker", name))).To(Succeed())
+		}
+	})
+
+	AfterEach(func() {
+		Expect(k8sClient.DeleteAllOf(ctx, &clusterv1.Machine{}, client.InNamespace(namespace))).To(Succeed())
+		Expect(k8sClient.DeleteAllOf(ctx, &clusterv1.Cluster{}, client.InNamespace(namespace))).To(Succeed())
+	})
+
+	It("should only get the machines of their own cluster", func() {
+		management := &rke2.Management{Client: k8sClient}
+
+		for _, name := range clusterNames {
+			cluster := clusters[name]
+
+			machines, err := management.GetMachinesForCluster(ctx, client.ObjectKeyFromObject(cluster),
+				collections.ControlPlaneMachines(cluster.Name), collections.OwnedMachines(rcps[name]))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(machines.Names()).To(ConsistOf(name+"-control-plane-0", name+"-control-plane-1"))
+
+			allMachines, err := management.GetMachinesForCluster(ctx, client.ObjectKeyFromObject(cluster))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(allMachines.Names()).To(ConsistOf(name+"-control-plane-0", name+"-control-plane-1", name+"-worker"))
+		}
+
+		machines, err := management.GetMachinesForCluster(ctx, client.ObjectKeyFromObject(clusters["cluster"]),
+			collections.OwnedMachines(rcps["cluster-2"]))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(machines).To(BeEmpty())
+	})
+
+	It("should only reconcile the control plane of the cluster", func() {
+		r := &RKE2ControlPlaneReconciler{}
+
+		for _, name := range clusterNames {
+			Expect(r.ClusterToRKE2ControlPlane(clusters[name])).To(Equal([]ctrl.Request{
+				{NamespacedName: client.ObjectKeyFromObject(rcps[name])},
+			}))
+		}
+
+		Expect(rke2.IsClusterControlPlane(clusters["cluster"], rcps["cluster"])).To(BeTrue())
+		Expect(rke2.IsClusterControlPlane(clusters["cluster"], rcps["cluster-2"])).To(BeFalse())
+	})
+})
+
+var _ = Describe("deleting a control plane its cluster doesn't reference", func() {
+	ctx := context.Background()
+
+	It("should delete its own machines without waiting for the machines of the cluster", func() {
+		env := newTestEnvironment(1, nil)
+
+		other := env.RCP.DeepCopy()
+		other.Name = "other-control-plane"
+		other.UID = "other-control-plane-uid"
+		other.ResourceVersion = ""
+		Expect(env.Client.Create(ctx, other)).To(Succeed())
+
+		stray := newControlPlaneMachine(env, "stray")
+		stray.OwnerReferences = []metav1.OwnerReference{
+			*metav1.NewControllerRef(other, controlplanev1.GroupVersion.WithKind("RKE2ControlPlane")),
+		}
+		env.createMachines(newControlPlaneMachine(env, "machine-1"), stray,
+			newTestMachine(env.Cluster.Namespace, "worker", env.Cluster.Name))
+
+		_, err := env.Reconciler.reconcileDelete(ctx, env.Cluster, other)
+		Expect(err).NotTo(HaveOccurred())
+
+		machines := &clusterv1.MachineList{}
+		Expect(env.Client.List(ctx, machines)).To(Succeed())
+		Expect(collections.FromMachineList(machines).Names()).To(ConsistOf("machine-1", "worker"))
+
+		_, err = env.Reconciler.reconcileDelete(ctx, env.Cluster, other)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(other.Finalizers).To(BeEmpty())
+
+		// The workload cluster is still managed by its own control plane.
+		Expect(env.ManagementCluster.Forgotten).To(BeEmpty())
+	})
+})
+
+var _ = Describe("deleting the control plane of a cluster", func() {
+	ctx := context.Background()
+
+	It("should forget the workload cluster once its machines are deleted", func() {
+		env := newTestEnvironment(1, nil)
+
+		_, err := env.Reconciler.reconcileDelete(ctx, env.Cluster, env.RCP)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(env.RCP.Finalizers).To(BeEmpty())
+
+		// The reconciler shares the fake management cluster for the cached and the uncached reads.
+		Expect(env.ManagementCluster.Forgotten).To(ConsistOf(client.ObjectKeyFromObject(env.Cluster),
+			client.ObjectKeyFromObject(env.Cluster)))
+	})
+})
+
+func newTestMachine(namespace, name, clusterName string) *clusterv1.Machine {
+	return &clusterv1.Machine{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      name,
+			Labels:    map[string]string{clusterv1.ClusterNameLabel: clusterName},
+		},
+		Spec: clusterv1.MachineSpec{
+			ClusterName: clusterName,
+			Bootstrap:   clusterv1.Bootstrap{DataSecretName: pointer.String(name)},
+			InfrastructureRef: corev1.ObjectReference{
+				APIVersion: "infrastructure.cluster.x-k8s.io/v1beta1",
+				Kind:       "DockerMachine",
+				Name:       name,
+			},
+		},
+	}
+}
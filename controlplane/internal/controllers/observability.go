@@ -0,0 +1,99 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+
+	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+	"github.com/rancher-sandbox/cluster-api-provider-rke2/pkg/rke2"
+)
+
+// reconcileObservability creates or updates the RKE2AddOns installing the enabled observability add-ons of the control
+// plane, and deletes the RKE2AddOns of the disabled ones, so that the add-ons are removed from the workload cluster.
+func (r *RKE2ControlPlaneReconciler) reconcileObservability(
+	ctx context.Context,
+	cluster *clusterv1.Cluster,
+	rcp *controlplanev1.RKE2ControlPlane,
+) error {
+	logger := log.FromContext(ctx)
+
+	enabled, disabled, err := rke2.ObservabilityAddOns(rcp, cluster.Name)
+	if err != nil {
+		return err
+	}
+
+	for _, desired := range enabled {
+		addOn := &controlplanev1.RKE2AddOn{ObjectMeta: metav1.ObjectMeta{Name: desired.Name, Namespace: desired.Namespace}}
+
+		result, err := controllerutil.CreateOrPatch(ctx, r.Client, addOn, func() error {
+			if addOn.Labels == nil {
+				addOn.Labels = map[string]string{}
+			}
+
+			for k, v := range desired.Labels {
+				addOn.Labels[k] = v
+			}
+
+			addOn.OwnerReferences = desired.OwnerReferences
+			addOn.Spec = desired.Spec
+
+			return nil
+		})
+		if err != nil {
+			return errors.Wrapf(err, "failed to create or update RKE2AddOn %s", desired.Name)
+		}
+
+		if result != controllerutil.OperationResultNone {
+			logger.Info("Reconciled the observability add-on", "addOn", desired.Name, "result", result)
+		}
+	}
+
+	for _, name := range disabled {
+		addOn := &controlplanev1.RKE2AddOn{}
+
+		if err := r.Client.Get(ctx, types.NamespacedName{Namespace: rcp.Namespace, Name: name}, addOn); err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+
+			return errors.Wrapf(err, "failed to get RKE2AddOn %s", name)
+		}
+
+		// RKE2AddOns created by users with the same name are left alone.
+		if !metav1.IsControlledBy(addOn, rcp) || !addOn.DeletionTimestamp.IsZero() {
+			continue
+		}
+
+		logger.Info("Deleting the disabled observability add-on", "addOn", name)
+
+		if err := r.Client.Delete(ctx, addOn); err != nil && !apierrors.IsNotFound(err) {
+			return errors.Wrapf(err, "failed to delete RKE2AddOn %s", name)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,146 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util/collections"
+	"sigs.k8s.io/cluster-api/util/conditions"
+
+	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+	"github.com/rancher-sandbox/cluster-api-provider-rke2/pkg/rke2"
+)
+
+const (
+	// rebootRequeueAfter is how long to wait before checking again if a control plane machine rebooted.
+	rebootRequeueAfter = 30 * time.Second
+
+	// rebootTimeout is how long to wait for a control plane machine to reboot and be healthy again, before giving up
+	// on its reboot so that the control plane is scaled and rolled out again.
+	rebootTimeout = 30 * time.Minute
+)
+
+// reconcileReboots serializes the reboots requested by the OS patching automation on the control plane machines: a
+// machine is approved to reboot when no other machine is rebooting and the agents and etcd members of all the
+// machines are healthy, so that etcd keeps its quorum.
+// A non zero result is returned while a machine is rebooting, as the control plane must not be scaled nor rolled out,
+// the approval is withdrawn when the machine didn't reboot and become healthy again before the reboot timeout.
+func (r *RKE2ControlPlaneReconciler) reconcileReboots(ctx context.Context, controlPlane *rke2.ControlPlane) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+	rcp := controlPlane.RCP
+
+	approved := controlPlane.Machines.Filter(collections.HasAnnotationKey(controlplanev1.RebootApprovedAnnotation))
+	pending := controlPlane.Machines.Filter(collections.HasAnnotationKey(controlplanev1.RebootRequestedAnnotation),
+		collections.Not(collections.HasAnnotationKey(controlplanev1.RebootApprovedAnnotation)))
+
+	for _, machine := range approved {
+		_, stillRequested := machine.Annotations[controlplanev1.RebootRequestedAnnotation]
+
+		switch {
+		case !stillRequested:
+			// The request was withdrawn, e.g. the automation gave up on the machine.
+			if err := rke2.PatchAnnotations(ctx, r.Client, r.apiReader, machine, nil,
+				controlplanev1.RebootApprovedAnnotation, controlplanev1.RebootApprovedAtAnnotation); err != nil {
+				return ctrl.Result{}, err
+			}
+		case rebooted(machine) && healthyMember(controlPlane, machine):
+			logger.Info("Control plane machine rebooted", "machine", machine.Name)
+			r.recorder.Eventf(rcp, corev1.EventTypeNormal, "RebootCompleted", "Machine %s rebooted", machine.Name)
+
+			if err := rke2.PatchAnnotations(ctx, r.Client, r.apiReader, machine, nil, controlplanev1.RebootRequestedAnnotation,
+				controlplanev1.RebootApprovedAnnotation, controlplanev1.RebootApprovedAtAnnotation); err != nil {
+				return ctrl.Result{}, err
+			}
+		case rebootTimedOut(machine):
+			logger.Info("Control plane machine didn't reboot in time, withdrawing the approval", "machine", machine.Name)
+			r.recorder.Eventf(rcp, corev1.EventTypeWarning, "RebootTimedOut",
+				"Machine %s didn't reboot and become healthy within %s, its reboot must be requested again",
+				machine.Name, rebootTimeout)
+
+			if err := rke2.PatchAnnotations(ctx, r.Client, r.apiReader, machine, nil, controlplanev1.RebootRequestedAnnotation,
+				controlplanev1.RebootApprovedAnnotation, controlplanev1.RebootApprovedAtAnnotation); err != nil {
+				return ctrl.Result{}, err
+			}
+		default:
+			logger.Info("Waiting for the control plane machine to reboot", "machine", machine.Name)
+
+			return ctrl.Result{RequeueAfter: rebootRequeueAfter}, nil
+		}
+	}
+
+	// The machines whose reboot completed or timed out in this reconciliation aren't approved again.
+	if pending.Len() == 0 {
+		return ctrl.Result{}, nil
+	}
+
+	for _, machine := range controlPlane.Machines {
+		if !machine.DeletionTimestamp.IsZero() || !healthyMember(controlPlane, machine) {
+			logger.Info("Not approving control plane machine reboots while a machine is unhealthy",
+				"requested", pending.Names(), "machine", machine.Name)
+
+			return ctrl.Result{}, nil
+		}
+	}
+
+	machine := pending.Oldest()
+	if machine.Status.NodeInfo == nil || machine.Status.NodeInfo.BootID == "" {
+		logger.Info("Not approving the reboot of a control plane machine whose boot ID is unknown", "machine", machine.Name)
+
+		return ctrl.Result{}, nil
+	}
+
+	if err := rke2.PatchAnnotations(ctx, r.Client, r.apiReader, machine, map[string]string{
+		controlplanev1.RebootApprovedAnnotation:   machine.Status.NodeInfo.BootID,
+		controlplanev1.RebootApprovedAtAnnotation: time.Now().UTC().Format(time.RFC3339),
+	}); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	logger.Info("Approved the reboot of a control plane machine", "machine", machine.Name)
+	r.recorder.Eventf(rcp, corev1.EventTypeNormal, "RebootApproved", "Machine %s can be rebooted", machine.Name)
+
+	return ctrl.Result{RequeueAfter: rebootRequeueAfter}, nil
+}
+
+// rebooted returns whether the node of the machine booted since its reboot was approved.
+func rebooted(machine *clusterv1.Machine) bool {
+	return machine.Status.NodeInfo != nil && machine.Status.NodeInfo.BootID != "" &&
+		machine.Status.NodeInfo.BootID != machine.Annotations[controlplanev1.RebootApprovedAnnotation]
+}
+
+// rebootTimedOut returns whether the reboot of the machine was approved longer than the reboot timeout ago, the
+// approvals without a time are given up on right away.
+func rebootTimedOut(machine *clusterv1.Machine) bool {
+	approvedAt, err := time.Parse(time.RFC3339, machine.Annotations[controlplanev1.RebootApprovedAtAnnotation])
+
+	return err != nil || time.Since(approvedAt) > rebootTimeout
+}
+
+// healthyMember returns whether the agent and the etcd member of the machine are healthy, the servers have no etcd
+// member with an external datastore, nor do the control plane machines of a control plane with dedicated etcd machines.
+func healthyMember(controlPlane *rke2.ControlPlane, machine *clusterv1.Machine) bool {
+	return conditions.IsTrue(machine, controlplanev1.MachineAgentHealthyCondition) &&
+		(!controlPlane.IsEtcdManaged() || rke2.HasRole(controlplanev1.ControlPlaneMachineRole)(machine) ||
+			conditions.IsTrue(machine, controlplanev1.MachineEtcdMemberHealthyCondition))
+}
@@ -0,0 +1,138 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util/conditions"
+
+	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+	"github.com/rancher-sandbox/cluster-api-provider-rke2/pkg/rke2"
+)
+
+var _ = Describe("control plane machine reboots", func() {
+	var env *testEnvironment
+
+	ctx := context.Background()
+
+	reconcileReboots := func() ctrl.Result {
+		result, err := env.Reconciler.reconcileReboots(ctx, env.controlPlane())
+		Expect(err).ToNot(HaveOccurred())
+
+		return result
+	}
+
+	requestReboot := func(name string, annotations map[string]string) {
+		annotations[controlplanev1.RebootRequestedAnnotation] = ""
+		Expect(rke2.PatchAnnotations(ctx, env.Client, env.Client, env.machine(name), annotations)).To(Succeed())
+	}
+
+	setBootID := func(name, bootID string) {
+		machine := env.machine(name)
+		machine.Status.NodeInfo = &corev1.NodeSystemInfo{BootID: bootID}
+		Expect(env.Client.Status().Update(ctx, machine)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		env = newTestEnvironment(3, nil)
+
+		for _, name := range []string{"machine-1", "machine-2", "machine-3"} {
+			machine := newControlPlaneMachine(env, name)
+			machine.Status.NodeInfo = &corev1.NodeSystemInfo{BootID: name + "-boot-1"}
+			conditions.MarkTrue(machine, controlplanev1.MachineAgentHealthyCondition)
+			conditions.MarkTrue(machine, controlplanev1.MachineEtcdMemberHealthyCondition)
+			env.createMachines(machine)
+		}
+	})
+
+	It("should approve one reboot at a time", func() {
+		requestReboot("machine-1", map[string]string{})
+		requestReboot("machine-2", map[string]string{})
+
+		Expect(reconcileReboots().RequeueAfter).To(Equal(rebootRequeueAfter))
+
+		approved := 0
+
+		for _, name := range []string{"machine-1", "machine-2"} {
+			if bootID, ok := env.machine(name).Annotations[controlplanev1.RebootApprovedAnnotation]; ok {
+				Expect(bootID).To(Equal(name + "-boot-1"))
+				Expect(env.machine(name).Annotations).To(HaveKey(controlplanev1.RebootApprovedAtAnnotation))
+
+				approved++
+			}
+		}
+
+		Expect(approved).To(Equal(1))
+
+		// The other reboot waits for the approved machine to reboot.
+		Expect(reconcileReboots().RequeueAfter).To(Equal(rebootRequeueAfter))
+	})
+
+	It("should not approve a reboot while an etcd member is unhealthy", func() {
+		machine := env.machine("machine-3")
+		conditions.MarkFalse(machine, controlplanev1.MachineEtcdMemberHealthyCondition, "Unhealthy",
+			clusterv1.ConditionSeverityError, "")
+		Expect(env.Client.Status().Update(ctx, machine)).To(Succeed())
+
+		requestReboot("machine-1", map[string]string{})
+
+		Expect(reconcileReboots()).To(Equal(ctrl.Result{}))
+		Expect(env.machine("machine-1").Annotations).ToNot(HaveKey(controlplanev1.RebootApprovedAnnotation))
+	})
+
+	It("should complete the reboot once the machine booted again and is healthy", func() {
+		requestReboot("machine-1", map[string]string{
+			controlplanev1.RebootApprovedAnnotation:   "machine-1-boot-1",
+			controlplanev1.RebootApprovedAtAnnotation: time.Now().UTC().Format(time.RFC3339),
+		})
+
+		Expect(reconcileReboots().RequeueAfter).To(Equal(rebootRequeueAfter))
+
+		setBootID("machine-1", "machine-1-boot-2")
+
+		Expect(reconcileReboots()).To(Equal(ctrl.Result{}))
+		Expect(env.machine("machine-1").Annotations).ToNot(Or(
+			HaveKey(controlplanev1.RebootRequestedAnnotation),
+			HaveKey(controlplanev1.RebootApprovedAnnotation),
+			HaveKey(controlplanev1.RebootApprovedAtAnnotation),
+		))
+	})
+
+	It("should give up on a reboot that timed out", func() {
+		requestReboot("machine-1", map[string]string{
+			controlplanev1.RebootApprovedAnnotation:   "machine-1-boot-1",
+			controlplanev1.RebootApprovedAtAnnotation: time.Now().Add(-rebootTimeout - time.Minute).UTC().Format(time.RFC3339),
+		})
+
+		Expect(reconcileReboots()).To(Equal(ctrl.Result{}))
+		Expect(env.machine("machine-1").Annotations).ToNot(Or(
+			HaveKey(controlplanev1.RebootRequestedAnnotation),
+			HaveKey(controlplanev1.RebootApprovedAnnotation),
+			HaveKey(controlplanev1.RebootApprovedAtAnnotation),
+		))
+		Expect(env.Recorder.Events).To(Receive(ContainSubstring("RebootTimedOut")))
+	})
+})
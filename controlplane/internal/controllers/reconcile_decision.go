@@ -0,0 +1,59 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	"github.com/rancher-sandbox/cluster-api-provider-rke2/pkg/rke2"
+)
+
+// reconcileDecision writes the summary of the next action of the controller on the control plane, as recorded while
+// reconciling it, to the ConfigMap of the reconcile decisions of the cluster, which is only updated when the decision
+// changes.
+func (r *RKE2ControlPlaneReconciler) reconcileDecision(ctx context.Context, controlPlane *rke2.ControlPlane) error {
+	decision := *controlPlane.Decision
+
+	desired, err := rke2.ReconcileDecisionConfigMap(controlPlane, decision)
+	if err != nil {
+		return err
+	}
+
+	configMap := &corev1.ConfigMap{ObjectMeta: *desired.ObjectMeta.DeepCopy()}
+
+	result, err := controllerutil.CreateOrPatch(ctx, r.Client, configMap, func() error {
+		configMap.Labels = desired.Labels
+		configMap.OwnerReferences = desired.OwnerReferences
+		configMap.Data = desired.Data
+
+		return nil
+	})
+	if err != nil {
+		return errors.Wrapf(err, "failed to create or update ConfigMap %s", desired.Name)
+	}
+
+	if result != controllerutil.OperationResultNone {
+		controlPlane.Logger().Info("Reconciled the reconcile decision", "configMap", desired.Name,
+			"nextAction", decision.NextAction, "reason", decision.Reason)
+	}
+
+	return nil
+}
@@ -0,0 +1,91 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util/conditions"
+
+	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+	"github.com/rancher-sandbox/cluster-api-provider-rke2/pkg/rke2"
+)
+
+var _ = Describe("reconcile decisions", func() {
+	var env *testEnvironment
+
+	ctx := context.Background()
+
+	decision := func() rke2.ReconcileDecision {
+		configMap := &corev1.ConfigMap{}
+		Expect(env.Client.Get(ctx, client.ObjectKey{
+			Namespace: env.RCP.Namespace,
+			Name:      rke2.ReconcileDecisionConfigMapName(env.Cluster.Name),
+		}, configMap)).To(Succeed())
+
+		decision := rke2.ReconcileDecision{}
+		Expect(json.Unmarshal([]byte(configMap.Data[rke2.ReconcileDecisionKey]), &decision)).To(Succeed())
+
+		return decision
+	}
+
+	BeforeEach(func() {
+		env = newTestEnvironment(3, fake.NewClientBuilder().WithScheme(newTestScheme()).Build())
+		env.Reconciler.ReconcileDecisions = true
+		env.createMachines(newControlPlaneMachine(env, "machine-1"))
+	})
+
+	It("should record the wait of a control plane in maintenance mode", func() {
+		env.RCP.Spec.Maintenance = true
+
+		_, err := env.Reconciler.reconcileNormal(ctx, env.Cluster, env.RCP)
+		Expect(err).ToNot(HaveOccurred())
+
+		// The machine has no bootstrap configuration matching the control plane, it is outdated.
+		Expect(decision()).To(Equal(rke2.ReconcileDecision{
+			DesiredReplicas:  3,
+			Replicas:         1,
+			OutdatedMachines: []string{"machine-1"},
+			NextAction:       rke2.ReconcileActionWait,
+			Reason:           "control plane is in maintenance mode",
+		}))
+	})
+
+	It("should record the check preventing the scale up", func() {
+		conditions.MarkFalse(env.RCP, controlplanev1.EtcdAlarmsClearedCondition, controlplanev1.EtcdAlarmsRaisedReason,
+			clusterv1.ConditionSeverityError, "etcd alarms NOSPACE")
+
+		controlPlane := env.controlPlane()
+		controlPlane.Decision.Decide(rke2.ReconcileActionScaleUp)
+
+		result, err := env.Reconciler.scaleUpControlPlane(ctx, env.Cluster, env.RCP, controlPlane)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.RequeueAfter).ToNot(BeZero())
+
+		Expect(controlPlane.Decision.NextAction).To(Equal(rke2.ReconcileActionWait))
+		Expect(controlPlane.Decision.Reason).To(Equal("etcd alarms NOSPACE"))
+	})
+})
@@ -0,0 +1,153 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	kubeyaml "sigs.k8s.io/yaml"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util"
+	"sigs.k8s.io/cluster-api/util/conditions"
+
+	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+	"github.com/rancher-sandbox/cluster-api-provider-rke2/pkg/rke2"
+)
+
+// registriesRefreshRequeueAfter is how long to wait before checking again whether the registries configuration is
+// written on the nodes of all the servers.
+const registriesRefreshRequeueAfter = 30 * time.Second
+
+// reconcileRegistriesRefresh writes the registries configuration on the nodes of the servers when refreshRegistries
+// is set, so that the rotation of the registry secrets doesn't require replacing the machines. The hash of the
+// configuration is recorded in the status once it is written on all the nodes.
+func (r *RKE2ControlPlaneReconciler) reconcileRegistriesRefresh(
+	ctx context.Context,
+	controlPlane *rke2.ControlPlane,
+) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+	rcp := controlPlane.RCP
+
+	if !rcp.Status.Initialized {
+		return ctrl.Result{}, nil
+	}
+
+	if !rcp.Spec.RefreshRegistries {
+		if rcp.Status.RegistriesHash == "" {
+			return ctrl.Result{}, nil
+		}
+
+		workloadCluster, err := r.managementCluster.GetWorkloadCluster(ctx, util.ObjectKey(controlPlane.Cluster))
+		if err != nil {
+			return ctrl.Result{}, errors.Wrap(err, "cannot get remote client to workload cluster")
+		}
+
+		if err := workloadCluster.DeleteRegistriesRefresh(ctx); err != nil {
+			return ctrl.Result{}, err
+		}
+
+		rcp.Status.RegistriesHash = ""
+		conditions.Delete(rcp, controlplanev1.RegistriesRefreshedCondition)
+
+		return ctrl.Result{}, nil
+	}
+
+	registries, registryFiles, err := rke2.GenerateRegistries(rke2.RegistryScope{
+		Registry: rcp.Spec.PrivateRegistriesConfig,
+		Client:   r.Client,
+		Ctx:      ctx,
+		Logger:   logger,
+	})
+	if err == nil {
+		var registriesYAML []byte
+
+		registriesYAML, err = kubeyaml.Marshal(registries)
+		registryFiles = rke2.RegistriesFiles(registriesYAML, registryFiles)
+	}
+
+	if err != nil {
+		logger.Info("Failed to generate the registries configuration", "reason", err.Error())
+		conditions.MarkFalse(rcp, controlplanev1.RegistriesRefreshedCondition, controlplanev1.RegistriesRefreshFailedReason,
+			clusterv1.ConditionSeverityWarning, "Failed to generate the registries configuration: %v", err)
+
+		return ctrl.Result{RequeueAfter: registriesRefreshRequeueAfter}, nil
+	}
+
+	hash := rke2.RegistriesHash(registryFiles)
+	if hash == rcp.Status.RegistriesHash {
+		return ctrl.Result{}, nil
+	}
+
+	workloadCluster, err := r.managementCluster.GetWorkloadCluster(ctx, util.ObjectKey(controlPlane.Cluster))
+	if err != nil {
+		return ctrl.Result{}, errors.Wrap(err, "cannot get remote client to workload cluster")
+	}
+
+	refreshed, err := workloadCluster.RefreshRegistries(ctx, registryFiles)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if !refreshed {
+		conditions.MarkFalse(rcp, controlplanev1.RegistriesRefreshedCondition, controlplanev1.RefreshingRegistriesReason,
+			clusterv1.ConditionSeverityInfo, "Writing the registries configuration on the nodes of the servers")
+
+		return ctrl.Result{RequeueAfter: registriesRefreshRequeueAfter}, nil
+	}
+
+	r.recorder.Event(rcp, corev1.EventTypeNormal, "RegistriesRefreshed",
+		"Registries configuration written on the nodes of the servers")
+
+	rcp.Status.RegistriesHash = hash
+	conditions.MarkTrue(rcp, controlplanev1.RegistriesRefreshedCondition)
+
+	return ctrl.Result{}, nil
+}
+
+// registrySecretToRKE2ControlPlanes is a handler.ToRequestsFunc to be used to enqueue requests for reconciliation
+// for the RKE2ControlPlanes refreshing their registries configuration when one of their registry secrets changes.
+func (r *RKE2ControlPlaneReconciler) registrySecretToRKE2ControlPlanes(o client.Object) []ctrl.Request {
+	rcps := &controlplanev1.RKE2ControlPlaneList{}
+	if err := r.Client.List(context.Background(), rcps); err != nil {
+		return nil
+	}
+
+	requests := []ctrl.Request{}
+
+	for i := range rcps.Items {
+		if !rcps.Items[i].Spec.RefreshRegistries {
+			continue
+		}
+
+		for _, ref := range rcps.Items[i].Spec.PrivateRegistriesConfig.SecretRefs() {
+			if ref.Namespace == o.GetNamespace() && ref.Name == o.GetName() {
+				requests = append(requests, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(&rcps.Items[i])})
+
+				break
+			}
+		}
+	}
+
+	return requests
+}
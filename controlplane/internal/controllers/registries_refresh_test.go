@@ -0,0 +1,122 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"sigs.k8s.io/cluster-api/util/conditions"
+
+	bootstrapv1 "github.com/rancher-sandbox/cluster-api-provider-rke2/bootstrap/api/v1alpha1"
+	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+	"github.com/rancher-sandbox/cluster-api-provider-rke2/pkg/rke2"
+)
+
+var _ = Describe("registries refresh", func() {
+	var (
+		env            *testEnvironment
+		workloadClient client.Client
+	)
+
+	ctx := context.Background()
+
+	reconcile := func() ctrl.Result {
+		result, err := env.Reconciler.reconcileRegistriesRefresh(ctx, env.controlPlane())
+		Expect(err).ToNot(HaveOccurred())
+
+		return result
+	}
+
+	jobs := func() []batchv1.Job {
+		jobs := &batchv1.JobList{}
+		Expect(workloadClient.List(ctx, jobs, client.InNamespace(rke2.HibernationNamespace))).To(Succeed())
+
+		return jobs.Items
+	}
+
+	BeforeEach(func() {
+		nodes := []client.Object{}
+		for _, name := range []string{"machine-1", "machine-2"} {
+			nodes = append(nodes, &corev1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{"node-role.kubernetes.io/master": "true"}},
+				Status:     corev1.NodeStatus{Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}}},
+			})
+		}
+
+		workloadClient = fake.NewClientBuilder().WithScheme(newTestScheme()).WithObjects(nodes...).Build()
+		env = newTestEnvironment(2, workloadClient)
+		env.RCP.Spec.RefreshRegistries = true
+		env.RCP.Spec.PrivateRegistriesConfig = bootstrapv1.Registry{
+			Mirrors: map[string]bootstrapv1.Mirror{"docker.io": {Endpoint: []string{"https://mirror.example.com"}}},
+		}
+	})
+
+	It("should refresh the nodes of the servers one at a time with jobs deleted once completed", func() {
+		for _, name := range []string{"machine-1", "machine-2"} {
+			Expect(reconcile().RequeueAfter).To(Equal(registriesRefreshRequeueAfter))
+			Expect(conditions.GetReason(env.RCP, controlplanev1.RegistriesRefreshedCondition)).
+				To(Equal(controlplanev1.RefreshingRegistriesReason))
+
+			Expect(jobs()).To(HaveLen(1))
+			Expect(jobs()[0].Spec.Template.Spec.NodeName).To(Equal(name))
+
+			job := &jobs()[0]
+			job.Status.Succeeded = 1
+			Expect(workloadClient.Status().Update(ctx, job)).To(Succeed())
+
+			Expect(reconcile().RequeueAfter).To(Equal(registriesRefreshRequeueAfter))
+			Expect(jobs()).To(BeEmpty())
+		}
+
+		Expect(reconcile()).To(Equal(ctrl.Result{}))
+		Expect(conditions.IsTrue(env.RCP, controlplanev1.RegistriesRefreshedCondition)).To(BeTrue())
+		Expect(env.RCP.Status.RegistriesHash).ToNot(BeEmpty())
+		Expect(env.Recorder.Events).To(Receive(ContainSubstring("RegistriesRefreshed")))
+
+		// The configuration is written, nothing is left in the workload cluster.
+		Expect(reconcile()).To(Equal(ctrl.Result{}))
+		Expect(jobs()).To(BeEmpty())
+
+		err := workloadClient.Get(ctx, client.ObjectKey{Namespace: rke2.HibernationNamespace, Name: "rke2-registries-refresh"},
+			&corev1.Secret{})
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+	})
+
+	It("should forget the refreshed configuration once the refresh is disabled", func() {
+		Expect(reconcile().RequeueAfter).To(Equal(registriesRefreshRequeueAfter))
+		Expect(jobs()).To(HaveLen(1))
+
+		env.RCP.Spec.RefreshRegistries = false
+		env.RCP.Status.RegistriesHash = "hash"
+
+		Expect(reconcile()).To(Equal(ctrl.Result{}))
+		Expect(jobs()).To(BeEmpty())
+		Expect(env.RCP.Status.RegistriesHash).To(BeEmpty())
+		Expect(conditions.Has(env.RCP, controlplanev1.RegistriesRefreshedCondition)).To(BeFalse())
+	})
+})
@@ -0,0 +1,370 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	kerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util"
+	"sigs.k8s.io/cluster-api/util/annotations"
+	"sigs.k8s.io/cluster-api/util/conditions"
+	"sigs.k8s.io/cluster-api/util/patch"
+
+	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+	"github.com/rancher-sandbox/cluster-api-provider-rke2/pkg/rke2"
+)
+
+// RKE2AddOnReconciler reconciles a RKE2AddOn object.
+type RKE2AddOnReconciler struct {
+	client.Client
+	managementCluster rke2.ManagementCluster
+	recorder          record.EventRecorder
+
+	// WorkloadClientOptions configures the clients of the workload clusters.
+	WorkloadClientOptions rke2.WorkloadClientOptions
+}
+
+//+kubebuilder:rbac:groups=controlplane.cluster.x-k8s.io,resources=rke2addons,verbs=get;list;watch;update;patch
+//+kubebuilder:rbac:groups=controlplane.cluster.x-k8s.io,resources=rke2addons/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=controlplane.cluster.x-k8s.io,resources=rke2addons/finalizers,verbs=update
+
+// Reconcile applies the manifests of a RKE2AddOn to its workload cluster once the control plane is ready, and removes
+// the resources that are no longer part of the manifests.
+func (r *RKE2AddOnReconciler) Reconcile(ctx context.Context, req ctrl.Request) (res ctrl.Result, reterr error) {
+	logger := log.FromContext(ctx)
+	addOn := &controlplanev1.RKE2AddOn{}
+
+	if err := r.Get(ctx, req.NamespacedName, addOn); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	logger = logger.WithValues("cluster", addOn.Spec.ClusterName)
+	ctx = log.IntoContext(ctx, logger)
+
+	cluster, err := util.GetClusterByName(ctx, r.Client, addOn.Namespace, addOn.Spec.ClusterName)
+	if err != nil && !apierrors.IsNotFound(errors.Cause(err)) {
+		return ctrl.Result{}, err
+	}
+
+	patchHelper, err := patch.NewHelper(addOn, r.Client)
+	if err != nil {
+		return ctrl.Result{}, errors.Wrap(err, "failed to configure the patch helper")
+	}
+
+	defer func() {
+		conditions.SetSummary(addOn, conditions.WithConditions(controlplanev1.AddOnAppliedCondition))
+
+		patchOpts := []patch.Option{
+			patch.WithOwnedConditions{Conditions: []clusterv1.ConditionType{
+				clusterv1.ReadyCondition,
+				controlplanev1.AddOnAppliedCondition,
+			}},
+		}
+
+		if reterr == nil {
+			patchOpts = append(patchOpts, patch.WithStatusObservedGeneration{})
+		}
+
+		if err := patchHelper.Patch(ctx, addOn, patchOpts...); err != nil {
+			reterr = kerrors.NewAggregate([]error{reterr, errors.Wrap(err, "failed to patch RKE2AddOn")})
+		}
+	}()
+
+	if !addOn.DeletionTimestamp.IsZero() {
+		return r.reconcileDelete(ctx, cluster, addOn)
+	}
+
+	if cluster == nil {
+		logger.Info("Cluster does not exist yet, waiting")
+
+		return ctrl.Result{RequeueAfter: DefaultRequeueTime}, nil
+	}
+
+	if annotations.IsPaused(cluster, addOn) {
+		logger.Info("Reconciliation is paused for this object")
+
+		return ctrl.Result{}, nil
+	}
+
+	controllerutil.AddFinalizer(addOn, controlplanev1.RKE2AddOnFinalizer)
+
+	return r.reconcileNormal(ctx, cluster, addOn)
+}
+
+func (r *RKE2AddOnReconciler) reconcileNormal(
+	ctx context.Context,
+	cluster *clusterv1.Cluster,
+	addOn *controlplanev1.RKE2AddOn,
+) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	if !cluster.Status.ControlPlaneReady {
+		logger.Info("Control plane is not ready yet, waiting to apply the manifests")
+		conditions.MarkFalse(addOn, controlplanev1.AddOnAppliedCondition, controlplanev1.WaitingForControlPlaneReason,
+			clusterv1.ConditionSeverityInfo, "")
+
+		return ctrl.Result{RequeueAfter: DefaultRequeueTime}, nil
+	}
+
+	objs, err := r.addOnManifests(ctx, addOn)
+	if err != nil {
+		return ctrl.Result{}, r.markApplyFailed(addOn, err)
+	}
+
+	for _, obj := range objs {
+		if err := rke2.ApplyWorkloadScheduling(obj, addOn.Spec.Scheduling); err != nil {
+			return ctrl.Result{}, r.markApplyFailed(addOn, err)
+		}
+	}
+
+	workloadCluster, err := r.managementCluster.GetWorkloadCluster(ctx, util.ObjectKey(cluster))
+	if err != nil {
+		return ctrl.Result{}, errors.Wrap(err, "cannot get remote client to workload cluster")
+	}
+
+	applied, err := workloadCluster.ApplyManifests(ctx, objs)
+	if err != nil {
+		// Keep track of the resources applied so far, so they are removed with the RKE2AddOn.
+		addOn.Status.Resources = mergeAddOnResources(addOn.Status.Resources, applied)
+
+		return ctrl.Result{}, r.markApplyFailed(addOn, err)
+	}
+
+	if err := workloadCluster.DeleteManifests(ctx, removedAddOnResources(addOn.Status.Resources, applied)); err != nil {
+		return ctrl.Result{}, r.markApplyFailed(addOn, err)
+	}
+
+	addOn.Status.Resources = applied
+	addOn.Status.Ready = true
+	conditions.MarkTrue(addOn, controlplanev1.AddOnAppliedCondition)
+
+	return ctrl.Result{}, nil
+}
+
+func (r *RKE2AddOnReconciler) reconcileDelete(
+	ctx context.Context,
+	cluster *clusterv1.Cluster,
+	addOn *controlplanev1.RKE2AddOn,
+) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	if cluster != nil && !cluster.DeletionTimestamp.IsZero() {
+		r.managementCluster.ForgetWorkloadCluster(util.ObjectKey(cluster))
+	}
+
+	// The resources are gone with the workload cluster.
+	if cluster == nil || !cluster.DeletionTimestamp.IsZero() || len(addOn.Status.Resources) == 0 {
+		controllerutil.RemoveFinalizer(addOn, controlplanev1.RKE2AddOnFinalizer)
+
+		return ctrl.Result{}, nil
+	}
+
+	workloadCluster, err := r.managementCluster.GetWorkloadCluster(ctx, util.ObjectKey(cluster))
+	if err != nil {
+		return ctrl.Result{}, errors.Wrap(err, "cannot get remote client to workload cluster")
+	}
+
+	if err := workloadCluster.DeleteManifests(ctx, addOn.Status.Resources); err != nil {
+		return ctrl.Result{}, errors.Wrap(err, "failed to delete the resources from the workload cluster")
+	}
+
+	logger.Info("Removed the resources from the workload cluster")
+
+	addOn.Status.Resources = nil
+	controllerutil.RemoveFinalizer(addOn, controlplanev1.RKE2AddOnFinalizer)
+
+	return ctrl.Result{}, nil
+}
+
+// addOnManifests returns the objects declared by the source of the RKE2AddOn.
+func (r *RKE2AddOnReconciler) addOnManifests(ctx context.Context, addOn *controlplanev1.RKE2AddOn) ([]*unstructured.Unstructured, error) {
+	switch {
+	case addOn.Spec.Chart != nil:
+		return []*unstructured.Unstructured{rke2.HelmChartManifest(addOn.Spec.Chart)}, nil
+	case addOn.Spec.ConfigMapRef != nil:
+		configMap := &corev1.ConfigMap{}
+		key := types.NamespacedName{Namespace: addOn.Namespace, Name: addOn.Spec.ConfigMapRef.Name}
+
+		if err := r.Client.Get(ctx, key, configMap); err != nil {
+			return nil, errors.Wrapf(err, "failed to get ConfigMap %s", key)
+		}
+
+		// Apply the entries in a stable order, so namespaces and CRDs can be declared in the first entries.
+		keys := make([]string, 0, len(configMap.Data))
+		for k := range configMap.Data {
+			keys = append(keys, k)
+		}
+
+		sort.Strings(keys)
+
+		objs := []*unstructured.Unstructured{}
+
+		for _, k := range keys {
+			entryObjs, err := rke2.ParseManifests(configMap.Data[k])
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to parse entry %s of ConfigMap %s", k, key)
+			}
+
+			objs = append(objs, entryObjs...)
+		}
+
+		return objs, nil
+	default:
+		return rke2.ParseManifests(addOn.Spec.Inline)
+	}
+}
+
+func (r *RKE2AddOnReconciler) markApplyFailed(addOn *controlplanev1.RKE2AddOn, err error) error {
+	addOn.Status.Ready = false
+	conditions.MarkFalse(addOn, controlplanev1.AddOnAppliedCondition, controlplanev1.AddOnApplyFailedReason,
+		clusterv1.ConditionSeverityWarning, err.Error())
+	r.recorder.Eventf(addOn, corev1.EventTypeWarning, controlplanev1.AddOnApplyFailedReason, err.Error())
+
+	return err
+}
+
+// removedAddOnResources returns the previously applied resources that are no longer applied.
+func removedAddOnResources(previous, applied []controlplanev1.RKE2AddOnResource) []controlplanev1.RKE2AddOnResource {
+	removed := []controlplanev1.RKE2AddOnResource{}
+
+	for _, resource := range previous {
+		if !containsAddOnResource(applied, resource) {
+			removed = append(removed, resource)
+		}
+	}
+
+	return removed
+}
+
+// mergeAddOnResources returns the previously applied resources, and the applied resources that are not part of them.
+func mergeAddOnResources(previous, applied []controlplanev1.RKE2AddOnResource) []controlplanev1.RKE2AddOnResource {
+	merged := append([]controlplanev1.RKE2AddOnResource{}, previous...)
+
+	for _, resource := range applied {
+		if !containsAddOnResource(merged, resource) {
+			merged = append(merged, resource)
+		}
+	}
+
+	return merged
+}
+
+func containsAddOnResource(resources []controlplanev1.RKE2AddOnResource, resource controlplanev1.RKE2AddOnResource) bool {
+	for _, r := range resources {
+		if r == resource {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *RKE2AddOnReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	c, err := ctrl.NewControllerManagedBy(mgr).
+		For(&controlplanev1.RKE2AddOn{}).
+		Build(r)
+	if err != nil {
+		return errors.Wrap(err, "failed setting up with a controller manager")
+	}
+
+	err = c.Watch(
+		&source.Kind{Type: &clusterv1.Cluster{}},
+		handler.EnqueueRequestsFromMapFunc(r.clusterToRKE2AddOns),
+	)
+	if err != nil {
+		return errors.Wrap(err, "failed adding Watch for Clusters to controller manager")
+	}
+
+	err = c.Watch(
+		&source.Kind{Type: &corev1.ConfigMap{}},
+		handler.EnqueueRequestsFromMapFunc(r.configMapToRKE2AddOns),
+	)
+	if err != nil {
+		return errors.Wrap(err, "failed adding Watch for ConfigMaps to controller manager")
+	}
+
+	r.recorder = mgr.GetEventRecorderFor("rke2-addon-controller")
+
+	if r.managementCluster == nil {
+		r.managementCluster = &rke2.Management{Client: r.Client, WorkloadClientOptions: r.WorkloadClientOptions}
+	}
+
+	return nil
+}
+
+// clusterToRKE2AddOns is a handler.ToRequestsFunc to be used to enqueue requests for the RKE2AddOns of a Cluster.
+func (r *RKE2AddOnReconciler) clusterToRKE2AddOns(o client.Object) []ctrl.Request {
+	cluster, ok := o.(*clusterv1.Cluster)
+	if !ok {
+		log.Log.Error(nil, fmt.Sprintf("Expected a Cluster but got a %T", o))
+
+		return nil
+	}
+
+	addOns := &controlplanev1.RKE2AddOnList{}
+	if err := r.Client.List(context.Background(), addOns, client.InNamespace(cluster.Namespace)); err != nil {
+		return nil
+	}
+
+	requests := []ctrl.Request{}
+
+	for i := range addOns.Items {
+		if addOns.Items[i].Spec.ClusterName == cluster.Name {
+			requests = append(requests, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(&addOns.Items[i])})
+		}
+	}
+
+	return requests
+}
+
+// configMapToRKE2AddOns is a handler.ToRequestsFunc to be used to enqueue requests for the RKE2AddOns referencing a
+// ConfigMap, so changes to the manifests are applied.
+func (r *RKE2AddOnReconciler) configMapToRKE2AddOns(o client.Object) []ctrl.Request {
+	addOns := &controlplanev1.RKE2AddOnList{}
+	if err := r.Client.List(context.Background(), addOns, client.InNamespace(o.GetNamespace())); err != nil {
+		return nil
+	}
+
+	requests := []ctrl.Request{}
+
+	for i := range addOns.Items {
+		configMapRef := addOns.Items[i].Spec.ConfigMapRef
+		if configMapRef != nil && configMapRef.Name == o.GetName() {
+			requests = append(requests, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(&addOns.Items[i])})
+		}
+	}
+
+	return requests
+}
@@ -24,6 +24,7 @@ import (
 	"github.com/go-logr/logr"
 	"github.com/pkg/errors"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -46,6 +47,7 @@ import (
 	"sigs.k8s.io/cluster-api/util/patch"
 
 	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+	"github.com/rancher-sandbox/cluster-api-provider-rke2/pkg/compatibility"
 	"github.com/rancher-sandbox/cluster-api-provider-rke2/pkg/kubeconfig"
 	"github.com/rancher-sandbox/cluster-api-provider-rke2/pkg/rke2"
 	"github.com/rancher-sandbox/cluster-api-provider-rke2/pkg/secret"
@@ -58,6 +60,9 @@ const (
 
 	// DefaultRequeueTime is the default requeue time for the controller.
 	DefaultRequeueTime = 20 * time.Second
+
+	// certificatesExpiryRefreshInterval is how often the certificates expiry of the control plane machines is read.
+	certificatesExpiryRefreshInterval = 24 * time.Hour
 )
 
 // RKE2ControlPlaneReconciler reconciles a RKE2ControlPlane object.
@@ -68,17 +73,48 @@ type RKE2ControlPlaneReconciler struct {
 	managementClusterUncached rke2.ManagementCluster
 	managementCluster         rke2.ManagementCluster
 	recorder                  record.EventRecorder
+	apiReader                 client.Reader
 	controller                controller.Controller
+
+	// AllowedInfrastructureTemplateNamespaces are the namespaces infrastructure templates can be referenced from,
+	// in addition to the namespace of the RKE2ControlPlane.
+	AllowedInfrastructureTemplateNamespaces []string
+
+	// ControllerPod is the pod the controller runs in, it is used to detect the control planes of the cluster the
+	// controller runs in.
+	ControllerPod *rke2.ControllerPod
+
+	// WorkloadClientOptions configures the clients of the workload clusters.
+	WorkloadClientOptions rke2.WorkloadClientOptions
+
+	// ClusterEvents mirrors the lifecycle events of the control planes on their Cluster, so that cluster-level watchers
+	// don't need to watch the RKE2ControlPlanes.
+	ClusterEvents bool
+
+	// ReconcileDecisions writes the next action of the controller on each control plane to a ConfigMap of the cluster
+	// after each reconciliation, so that GitOps dashboards can display it without parsing the logs.
+	ReconcileDecisions bool
+
+	// CompatibilitySource is the source of the compatibility matrix the versions are rolled out with, the embedded
+	// matrix when nil.
+	CompatibilitySource *compatibility.Source
 }
 
 //nolint:lll
 //+kubebuilder:rbac:groups=controlplane.cluster.x-k8s.io,resources=rke2controlplanes,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=controlplane.cluster.x-k8s.io,resources=rke2controlplanes/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=controlplane.cluster.x-k8s.io,resources=rke2controlplanes/finalizers,verbs=update
-// +kubebuilder:rbac:groups=cluster.x-k8s.io,resources=clusters;clusters/status;machinesets;machines;machines/status;machinepools;machinepools/status,verbs=get;list;watch;create;update;patch;delete
-// +kubebuilder:rbac:groups="",resources=secrets;events;configmaps,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=controlplane.cluster.x-k8s.io,resources=rke2addons,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=cluster.x-k8s.io,resources=clusters,verbs=get;list;watch
+// +kubebuilder:rbac:groups=cluster.x-k8s.io,resources=machines,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=cluster.x-k8s.io,resources=machines/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=cluster.x-k8s.io,resources=machinehealthchecks,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch;create;update;patch
+// +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch;create;update;patch
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
 // +kubebuilder:rbac:groups="bootstrap.cluster.x-k8s.io",resources=rke2configs,verbs=get;list;watch;create;patch;delete
 // +kubebuilder:rbac:groups="infrastructure.cluster.x-k8s.io",resources=*,verbs=get;list;watch;create;patch;delete
+// +kubebuilder:rbac:groups="authorization.k8s.io",resources=subjectaccessreviews,verbs=create
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -111,6 +147,14 @@ func (r *RKE2ControlPlaneReconciler) Reconcile(ctx context.Context, req ctrl.Req
 
 	logger = logger.WithValues("cluster", cluster.Name)
 
+	// The machines of the cluster must not be managed by another control plane of the namespace than its own. The
+	// deletion of another control plane still deletes its own machines, and releases it.
+	if !rke2.IsClusterControlPlane(cluster, rcp) && rcp.DeletionTimestamp.IsZero() {
+		logger.Info("The RKE2ControlPlane is not the control plane of its owner Cluster, not reconciling it")
+
+		return ctrl.Result{}, nil
+	}
+
 	if annotations.IsPaused(cluster, rcp) {
 		logger.Info("Reconciliation is paused for this object")
 
@@ -125,6 +169,9 @@ func (r *RKE2ControlPlaneReconciler) Reconcile(ctx context.Context, req ctrl.Req
 		return ctrl.Result{Requeue: true}, nil
 	}
 
+	// The status is patched with an optimistic lock, from the changes made during the reconciliation.
+	before := rcp.DeepCopy()
+
 	// Add finalizer first if not exist to avoid the race condition between init and delete
 	if !controllerutil.ContainsFinalizer(rcp, controlplanev1.RKE2ControlPlaneFinalizer) {
 		controllerutil.AddFinalizer(rcp, controlplanev1.RKE2ControlPlaneFinalizer)
@@ -144,7 +191,11 @@ func (r *RKE2ControlPlaneReconciler) Reconcile(ctx context.Context, req ctrl.Req
 
 	defer func() {
 		// Always attempt to update status.
-		if err := r.updateStatus(ctx, rcp, cluster); err != nil {
+		statusUpdateStart := time.Now()
+		err := r.updateStatus(ctx, rcp, cluster)
+		rke2.RecordPhaseDuration(rcp, controlplanev1.StatusUpdateReconcilePhase, statusUpdateStart)
+
+		if err != nil {
 			var connFailure *rke2.RemoteClusterConnectionError
 			if errors.As(err, &connFailure) {
 				logger.Info("Could not connect to workload cluster to fetch status", "err", err.Error())
@@ -155,7 +206,7 @@ func (r *RKE2ControlPlaneReconciler) Reconcile(ctx context.Context, req ctrl.Req
 		}
 
 		// Always attempt to Patch the RKE2ControlPlane object and status after each reconciliation.
-		if err := patchRKE2ControlPlane(ctx, patchHelper, rcp); err != nil {
+		if err := r.patchRKE2ControlPlane(ctx, patchHelper, before, rcp); err != nil {
 			logger.Error(err, "Failed to patch RKE2ControlPlane")
 			reterr = kerrors.NewAggregate([]error{reterr, err})
 		}
@@ -165,8 +216,8 @@ func (r *RKE2ControlPlaneReconciler) Reconcile(ctx context.Context, req ctrl.Req
 		// Only requeue if we are not going in exponential backoff due to error,
 		// or if we are not already re-queueing, or if the object has a deletion timestamp.
 		if reterr == nil && !res.Requeue && res.RequeueAfter <= 0 && rcp.ObjectMeta.DeletionTimestamp.IsZero() {
-			if !rcp.Status.Ready {
-				res = ctrl.Result{RequeueAfter: DefaultRequeueTime}
+			if period := reconcilePeriod(rcp); period > 0 {
+				res = ctrl.Result{RequeueAfter: period}
 			}
 		}
 	}()
@@ -184,32 +235,90 @@ func (r *RKE2ControlPlaneReconciler) Reconcile(ctx context.Context, req ctrl.Req
 	return res, err
 }
 
-func patchRKE2ControlPlane(ctx context.Context, patchHelper *patch.Helper, rcp *controlplanev1.RKE2ControlPlane) error {
+// patchRKE2ControlPlane patches the metadata, the spec and the conditions of the RKE2ControlPlane with the patch helper,
+// and the rest of the status with an optimistic lock, so that a controller acting on an outdated view of the control
+// plane, e.g. a replica of the previous version of the controller while it is upgraded, doesn't overwrite it.
+func (r *RKE2ControlPlaneReconciler) patchRKE2ControlPlane(
+	ctx context.Context,
+	patchHelper *patch.Helper,
+	before, rcp *controlplanev1.RKE2ControlPlane,
+) error {
 	// Always update the readyCondition by summarizing the state of other conditions.
 	conditions.SetSummary(rcp,
 		conditions.WithConditions(
+			controlplanev1.MachinesCreatedCondition,
 			controlplanev1.MachinesReadyCondition,
 			controlplanev1.MachinesSpecUpToDateCondition,
 			controlplanev1.ResizedCondition,
 			controlplanev1.MachinesReadyCondition,
 			controlplanev1.AvailableCondition,
+			controlplanev1.InfrastructureReferenceValidCondition,
 			// controlplanev1.CertificatesAvailableCondition,
 		),
 	)
 
+	status := rcp.Status.DeepCopy()
+	status.ObservedGeneration = rcp.Generation
+
+	rcp.Status = *before.Status.DeepCopy()
+	rcp.Status.Conditions = status.Conditions
+
 	// Patch the object, ignoring conflicts on the conditions owned by this controller.
-	return patchHelper.Patch(
+	if err := patchHelper.Patch(
 		ctx,
 		rcp,
 		patch.WithOwnedConditions{Conditions: []clusterv1.ConditionType{
 			clusterv1.ReadyCondition,
+			controlplanev1.MachinesCreatedCondition,
 			controlplanev1.MachinesSpecUpToDateCondition,
 			controlplanev1.ResizedCondition,
 			controlplanev1.MachinesReadyCondition,
 			controlplanev1.AvailableCondition,
+			controlplanev1.InfrastructureReferenceValidCondition,
+			controlplanev1.MachinesProvisionedCondition,
 		}},
-		patch.WithStatusObservedGeneration{},
-	)
+	); err != nil {
+		return err
+	}
+
+	unchanged := status.DeepCopy()
+	unchanged.Conditions = rcp.Status.Conditions
+
+	if equality.Semantic.DeepEqual(*unchanged, rcp.Status) {
+		return nil
+	}
+
+	err := rke2.PatchStatusWithRetry(ctx, r.Client, r.apiReader, rcp, func() error {
+		// The generation observed by the controller never goes backwards.
+		if rcp.Status.ObservedGeneration > status.ObservedGeneration {
+			return rke2.ErrStaleObject
+		}
+
+		latestConditions := rcp.Status.Conditions
+		rcp.Status = *status
+		rcp.Status.Conditions = latestConditions
+
+		return nil
+	})
+	if errors.Is(err, rke2.ErrStaleObject) {
+		log.FromContext(ctx).Info("Not patching the status of an RKE2ControlPlane observed at a newer generation",
+			"generation", status.ObservedGeneration)
+
+		return nil
+	}
+
+	return errors.Wrap(err, "failed to patch RKE2ControlPlane status")
+}
+
+// isLatestGeneration returns whether the RKE2ControlPlane read from the cache is the latest generation, and no
+// controller observed a newer one.
+func (r *RKE2ControlPlaneReconciler) isLatestGeneration(ctx context.Context, rcp *controlplanev1.RKE2ControlPlane) (bool, error) {
+	latest := &controlplanev1.RKE2ControlPlane{}
+	if err := r.apiReader.Get(ctx, client.ObjectKeyFromObject(rcp), latest); err != nil {
+		return false, errors.Wrap(err, "failed to get the latest RKE2ControlPlane")
+	}
+
+	return latest.Generation == rcp.Generation && latest.Status.ObservedGeneration <= rcp.Generation, nil
 }
 
 // SetupWithManager sets up the controller with the Manager.
@@ -229,15 +338,30 @@ func (r *RKE2ControlPlaneReconciler) SetupWithManager(mgr ctrl.Manager) error {
 		return errors.Wrap(err, "failed adding Watch for Clusters to controller manager")
 	}
 
+	err = c.Watch(
+		&source.Kind{Type: &corev1.Secret{}},
+		handler.EnqueueRequestsFromMapFunc(r.registrySecretToRKE2ControlPlanes),
+	)
+	if err != nil {
+		return errors.Wrap(err, "failed adding Watch for Secrets to controller manager")
+	}
+
 	r.controller = c
 	r.recorder = mgr.GetEventRecorderFor("rke2-control-plane-controller")
 
+	if r.apiReader == nil {
+		r.apiReader = mgr.GetAPIReader()
+	}
+
 	if r.managementCluster == nil {
-		r.managementCluster = &rke2.Management{Client: r.Client}
+		r.managementCluster = &rke2.Management{Client: r.Client, WorkloadClientOptions: r.WorkloadClientOptions}
 	}
 
 	if r.managementClusterUncached == nil {
-		r.managementClusterUncached = &rke2.Management{Client: mgr.GetAPIReader()}
+		r.managementClusterUncached = &rke2.Management{
+			Client:                mgr.GetAPIReader(),
+			WorkloadClientOptions: r.WorkloadClientOptions,
+		}
 	}
 
 	return nil
@@ -259,7 +383,7 @@ func (r *RKE2ControlPlaneReconciler) updateStatus(ctx context.Context, rcp *cont
 		logger.V(3).Info("Ready Machine : " + readyMachine.Name)
 	}
 
-	controlPlane, err := rke2.NewControlPlane(ctx, r.Client, cluster, rcp, ownedMachines)
+	controlPlane, err := r.newControlPlane(ctx, cluster, rcp, ownedMachines)
 	if err != nil {
 		logger.Error(err, "failed to initialize control plane")
 
@@ -268,7 +392,11 @@ func (r *RKE2ControlPlaneReconciler) updateStatus(ctx context.Context, rcp *cont
 
 	rcp.Status.UpdatedReplicas = int32(len(controlPlane.UpToDateMachines()))
 	replicas := int32(len(ownedMachines))
-	desiredReplicas := *rcp.Spec.Replicas
+	desiredReplicas := rcp.Spec.MachineReplicas()
+
+	if rcp.Spec.EtcdReplicas != nil {
+		rcp.Status.EtcdReplicas = int32(ownedMachines.Filter(rke2.HasRole(controlplanev1.EtcdMachineRole)).Len())
+	}
 
 	// set basic data that does not require interacting with the workload cluster
 	// ReadyReplicas and UnavailableReplicas are set in case the function returns before updating them
@@ -283,6 +411,17 @@ func (r *RKE2ControlPlaneReconciler) updateStatus(ctx context.Context, rcp *cont
 	}
 
 	switch {
+	// A scale down requested during a rollout is applied once it completes
+	case rcp.Status.QueuedReplicas != nil:
+		conditions.MarkFalse(
+			rcp,
+			controlplanev1.ResizedCondition,
+			controlplanev1.ScaleDownQueuedReason,
+			clusterv1.ConditionSeverityInfo,
+			"Scaling down control plane to %d replicas once the rollout completes (actual %d)",
+			*rcp.Status.QueuedReplicas,
+			replicas)
+
 	// We are scaling up
 	case replicas < desiredReplicas:
 		conditions.MarkFalse(
@@ -334,7 +473,14 @@ func (r *RKE2ControlPlaneReconciler) updateStatus(ctx context.Context, rcp *cont
 	rcp.Status.ReadyReplicas = int32(len(readyMachines))
 	rcp.Status.UnavailableReplicas = replicas - rcp.Status.ReadyReplicas
 
-	if rcp.Status.ReadyReplicas > 0 {
+	// The dedicated etcd machines run no API server, the control plane is initialized once another machine is ready.
+	apiServerMachines := readyMachines.Filter(collections.Not(rke2.HasRole(controlplanev1.EtcdMachineRole)))
+
+	if len(apiServerMachines) > 0 {
+		if !rcp.Status.Initialized {
+			r.lifecycleEventf(cluster, rcp, corev1.EventTypeNormal, "Initialized", "Control plane initialized")
+		}
+
 		rcp.Status.Initialized = true
 	}
 
@@ -350,7 +496,9 @@ func (r *RKE2ControlPlaneReconciler) updateStatus(ctx context.Context, rcp *cont
 		return nil
 	}
 
-	availableCPMachines := readyMachines
+	// The dedicated etcd machines are listed last, the control plane machines join them until an API server runs.
+	availableCPMachines := append(apiServerMachines.SortedByCreationTimestamp(),
+		readyMachines.Difference(apiServerMachines).SortedByCreationTimestamp()...)
 
 	validIPAddresses := []string{}
 
@@ -368,6 +516,15 @@ func (r *RKE2ControlPlaneReconciler) updateStatus(ctx context.Context, rcp *cont
 		return fmt.Errorf("some Control Plane machines exist and are ready but they have no IP Address available")
 	}
 
+	if len(apiServerMachines) == 0 {
+		logger.Info(fmt.Sprintf("no Control Plane Machines running an API server are ready for RKE2ControlPlane %s/%s",
+			rcp.Namespace, rcp.Name))
+
+		return nil
+	}
+
+	r.updateStatusVersion(ctx, cluster, rcp)
+
 	if len(readyMachines) == len(ownedMachines) {
 		rcp.Status.Ready = true
 	}
@@ -377,11 +534,39 @@ func (r *RKE2ControlPlaneReconciler) updateStatus(ctx context.Context, rcp *cont
 	return nil
 }
 
+// updateStatusVersion sets the version of the status to the lowest version of the control plane nodes, the last
+// known version is kept while the workload cluster is unreachable.
+func (r *RKE2ControlPlaneReconciler) updateStatusVersion(
+	ctx context.Context,
+	cluster *clusterv1.Cluster,
+	rcp *controlplanev1.RKE2ControlPlane,
+) {
+	logger := log.FromContext(ctx)
+
+	workloadCluster, err := r.managementCluster.GetWorkloadCluster(ctx, util.ObjectKey(cluster))
+	if err != nil {
+		logger.Info("Unable to get the version of the control plane nodes", "reason", err.Error())
+
+		return
+	}
+
+	status, err := workloadCluster.ClusterStatus(ctx)
+	if err != nil {
+		logger.Info("Unable to get the version of the control plane nodes", "reason", err.Error())
+
+		return
+	}
+
+	if status.Version != "" {
+		rcp.Status.Version = &status.Version
+	}
+}
+
 func (r *RKE2ControlPlaneReconciler) reconcileNormal(
 	ctx context.Context,
 	cluster *clusterv1.Cluster,
 	rcp *controlplanev1.RKE2ControlPlane,
-) (ctrl.Result, error) {
+) (res ctrl.Result, reterr error) {
 	logger := log.FromContext(ctx)
 	logger.Info("Reconcile RKE2 Control Plane")
 
@@ -393,6 +578,11 @@ func (r *RKE2ControlPlaneReconciler) reconcileNormal(
 	}
 
 	certificates := secret.NewCertificatesForInitialControlPlane()
+	// The etcd metrics are scraped with a client certificate signed by the etcd CA, unless the datastore is external.
+	if rcp.Spec.ServerConfig.Metrics != nil && rcp.Spec.ServerConfig.ExternalDatastore == nil {
+		certificates = append(certificates, secret.NewEtcdCACertificate())
+	}
+
 	controllerRef := metav1.NewControllerRef(rcp, controlplanev1.GroupVersion.WithKind("RKE2ControlPlane"))
 
 	if err := certificates.LookupOrGenerate(ctx, r.Client, util.ObjectKey(cluster), *controllerRef); err != nil {
@@ -414,6 +604,20 @@ func (r *RKE2ControlPlaneReconciler) reconcileNormal(
 		return ctrl.Result{}, nil
 	}
 
+	r.reconcileTLSSan(ctx, cluster, rcp)
+
+	if err := r.reconcileObservability(ctx, cluster, rcp); err != nil {
+		logger.Error(err, "failed to reconcile the observability add-ons")
+
+		return ctrl.Result{}, err
+	}
+
+	if err := r.reconcileHealthCheck(ctx, cluster, rcp); err != nil {
+		logger.Error(err, "failed to reconcile the control plane MachineHealthCheck")
+
+		return ctrl.Result{}, err
+	}
+
 	// Generate Cluster Kubeconfig if needed
 	if result, err := r.reconcileKubeconfig(
 		ctx,
@@ -442,13 +646,26 @@ func (r *RKE2ControlPlaneReconciler) reconcileNormal(
 		return ctrl.Result{}, nil
 	}
 
-	controlPlane, err := rke2.NewControlPlane(ctx, r.Client, cluster, rcp, ownedMachines)
+	controlPlane, err := r.newControlPlane(ctx, cluster, rcp, ownedMachines)
 	if err != nil {
 		logger.Error(err, "failed to initialize control plane")
 
 		return ctrl.Result{}, err
 	}
 
+	// The decision is recorded as the control plane is reconciled, it is not written when the reconciliation fails.
+	if r.ReconcileDecisions {
+		defer func() {
+			if reterr != nil {
+				return
+			}
+
+			if err := r.reconcileDecision(ctx, controlPlane); err != nil {
+				logger.Error(err, "failed to write the reconcile decision")
+			}
+		}()
+	}
+
 	// Aggregate the operational state of all the machines; while aggregating we are adding the
 	// source ref (reason@machine/name) so the problem can be easily tracked down to its source machine.
 	conditions.SetAggregate(controlPlane.RCP, controlplanev1.MachinesReadyCondition,
@@ -456,6 +673,49 @@ func (r *RKE2ControlPlaneReconciler) reconcileNormal(
 		conditions.AddSourceRef(),
 		conditions.WithStepCounterIf(false))
 
+	// The provisioning conditions are reported before the control plane is initialized, while the workload cluster
+	// is not reachable yet.
+	controlPlane.UpdateProvisioningConditions()
+
+	if err := controlPlane.PatchMachines(ctx); err != nil {
+		logger.Error(err, "failed to patch the provisioning conditions of the machines")
+
+		return ctrl.Result{}, err
+	}
+
+	if err := r.reconcileInfrastructureMachineAnnotations(ctx, controlPlane); err != nil {
+		logger.Error(err, "failed to reconcile the annotations of the infrastructure machines")
+
+		return ctrl.Result{}, err
+	}
+
+	// The workload cluster is not reachable until the etcd quorum is recovered, the recovery runs before any step
+	// needing the workload cluster API.
+	if result, err := r.reconcileEtcdRecovery(ctx, controlPlane); err != nil || !result.IsZero() {
+		if err != nil {
+			logger.Error(err, "failed to reconcile the etcd quorum recovery")
+		}
+
+		controlPlane.Decision.Wait("etcd quorum is being recovered")
+
+		return result, err
+	}
+
+	if err := r.reconcileSelfHosting(ctx, controlPlane); err != nil {
+		logger.Error(err, "failed to detect if the cluster manages itself")
+
+		return ctrl.Result{}, err
+	}
+
+	// The workload cluster is not reachable while the control plane is hibernated, no other operation can be performed.
+	if result, err := r.reconcileHibernation(ctx, controlPlane); err != nil || !result.IsZero() {
+		if err != nil {
+			logger.Error(err, "failed to reconcile Control Plane hibernation")
+		}
+
+		return result, err
+	}
+
 	// Updates conditions reporting the status of static pods and the status of the etcd cluster.
 	// NOTE: Conditions reporting RCP operation progress like e.g. Resized or SpecUpToDate are inlined with the rest of the execution.
 	if result, err := r.reconcileControlPlaneConditions(ctx, controlPlane); err != nil || !result.IsZero() {
@@ -464,28 +724,175 @@ func (r *RKE2ControlPlaneReconciler) reconcileNormal(
 		return result, err
 	}
 
+	r.reconcileLastEtcdS3Snapshot(ctx, controlPlane)
+
+	if err := r.reconcileControlPlaneMetrics(ctx, controlPlane, certificates); err != nil {
+		logger.Error(err, "failed to reconcile Control Plane metrics")
+
+		return ctrl.Result{}, err
+	}
+
+	if err := r.reconcileKubeletServingCertificates(ctx, controlPlane); err != nil {
+		logger.Error(err, "failed to approve kubelet serving certificates")
+
+		return ctrl.Result{}, err
+	}
+
+	// The machines are rolled out to the desired version once it is approved.
+	if err := r.reconcileDesiredVersion(ctx, rcp); err != nil {
+		logger.Error(err, "failed to reconcile the desired version")
+
+		return ctrl.Result{}, err
+	}
+
+	// In maintenance mode the control plane is only monitored, its machines are not scaled nor rolled out.
+	if rcp.Spec.Maintenance {
+		logger.Info("Control plane is in maintenance mode, skipping scaling and rollout")
+		conditions.MarkTrue(rcp, controlplanev1.MaintenanceCondition)
+		controlPlane.Decision.Wait("control plane is in maintenance mode")
+
+		return ctrl.Result{}, nil
+	}
+
+	conditions.Delete(rcp, controlplanev1.MaintenanceCondition)
+
+	// The nodes of the deleted machines are deleted along with their etcd members.
+	if err := r.reconcileStaleNodes(ctx, controlPlane); err != nil {
+		logger.Error(err, "failed to delete the nodes of deleted machines")
+
+		return ctrl.Result{}, err
+	}
+
+	// The servers are restarted one at a time to rotate the secrets encryption key, no machine is rebooted meanwhile.
+	if result, err := r.reconcileSecretsEncryptionKeyRotation(ctx, controlPlane); err != nil || !result.IsZero() {
+		if err != nil {
+			logger.Error(err, "failed to rotate the secrets encryption key")
+		}
+
+		controlPlane.Decision.Wait("secrets encryption key is being rotated")
+
+		return result, err
+	}
+
+	if err := r.reconcileCertificatesExpiry(ctx, controlPlane); err != nil {
+		logger.Error(err, "failed to record the certificates expiry of the machines")
+
+		return ctrl.Result{}, err
+	}
+
+	// Machines are only created and deleted for the latest generation of the control plane, another replica of the
+	// controller may be acting on it, e.g. while the controller is upgraded.
+	if latest, err := r.isLatestGeneration(ctx, rcp); err != nil || !latest {
+		if err != nil {
+			logger.Error(err, "failed to check the RKE2ControlPlane generation")
+
+			return ctrl.Result{}, err
+		}
+
+		logger.Info("RKE2ControlPlane was modified, waiting for the latest generation before scaling or rolling out")
+		controlPlane.Decision.Wait("waiting for the latest generation of the control plane")
+
+		return ctrl.Result{Requeue: true}, nil
+	}
+
+	// Scaling to zero replicas tears the control plane down, the machines don't need to be rolled out. Both roles are
+	// scaled to zero when they are split.
+	if rcp.Spec.MachineReplicas() == 0 {
+		rcp.Status.RolloutReplicas = nil
+		rcp.Status.QueuedReplicas = nil
+		rcp.Status.EtcdRolloutReplicas = nil
+		rcp.Status.QueuedEtcdReplicas = nil
+
+		if controlPlane.Machines.Len() > 0 {
+			controlPlane.Decision.Decide(rke2.ReconcileActionScaleDown)
+		}
+
+		return r.scaleDownControlPlaneToZero(ctx, cluster, rcp, controlPlane)
+	}
+
+	// The machines are cloned from the infrastructure template when scaling up or rolling out.
+	if valid, err := r.reconcileInfrastructureReference(ctx, rcp); err != nil || !valid {
+		if err != nil {
+			logger.Error(err, "failed to validate the infrastructure template")
+		}
+
+		controlPlane.Decision.Wait(conditions.GetMessage(rcp, controlplanev1.InfrastructureReferenceValidCondition))
+
+		// The control plane resumes by itself once the infrastructure provider is available again.
+		if err == nil && conditions.GetReason(rcp, controlplanev1.InfrastructureReferenceValidCondition) ==
+			controlplanev1.WaitingForInfrastructureProviderReason {
+			return r.waitForInfrastructureProvider(ctx, rcp, controlplanev1.InfrastructureReferenceValidCondition,
+				conditions.GetMessage(rcp, controlplanev1.InfrastructureReferenceValidCondition))
+		}
+
+		return ctrl.Result{}, err
+	}
+
+	// The unhealthy machines are replaced before the machines are rolled out or scaled.
+	if result, err := r.reconcileRemediation(ctx, cluster, controlPlane); err != nil || !result.IsZero() {
+		if err != nil {
+			logger.Error(err, "failed to remediate the unhealthy control plane machines")
+		}
+
+		controlPlane.Decision.Decide(rke2.ReconcileActionRemediate)
+
+		return result, err
+	}
+
+	// The machines about to be interrupted are replaced before they disappear.
+	if result, err := r.reconcileInterruptions(ctx, cluster, controlPlane); err != nil || !result.IsZero() {
+		if err != nil {
+			logger.Error(err, "failed to replace the control plane machines about to be interrupted")
+		}
+
+		controlPlane.Decision.Decide(rke2.ReconcileActionRemediate)
+
+		return result, err
+	}
+
+	// The OS patching automation reboots the machines one at a time, the control plane isn't scaled nor rolled out
+	// meanwhile. The unhealthy and interrupted machines are still replaced while a machine reboots.
+	if result, err := r.reconcileReboots(ctx, controlPlane); err != nil || !result.IsZero() {
+		if err != nil {
+			logger.Error(err, "failed to reconcile Control Plane machine reboots")
+		}
+
+		controlPlane.Decision.Wait("control plane machine is rebooting")
+
+		return result, err
+	}
+
+	// The machines of each role are rolled out and scaled separately when the roles are split.
+	if rcp.Spec.EtcdReplicas != nil {
+		if result, err := r.reconcileMachineRoles(ctx, cluster, rcp, controlPlane); err != nil || !result.IsZero() {
+			return result, err
+		}
+
+		return r.reconcileSteadyState(ctx, controlPlane)
+	}
+
 	// Control plane machines rollout due to configuration changes (e.g. upgrades) takes precedence over other operations.
 	needRollout := controlPlane.MachinesNeedingRollout()
 
 	switch {
 	case len(needRollout) > 0:
-		logger.Info("Rolling out Control Plane machines", "needRollout", needRollout.Names())
-		conditions.MarkFalse(controlPlane.RCP,
-			controlplanev1.MachinesSpecUpToDateCondition,
-			controlplanev1.RollingUpdateInProgressReason,
-			clusterv1.ConditionSeverityWarning,
-			"Rolling %d replicas with outdated spec (%d replicas up to date)",
-			len(needRollout),
-			len(controlPlane.Machines)-len(needRollout))
+		controlPlane.Decision.Decide(rke2.ReconcileActionRollOut)
 
-		return r.upgradeControlPlane(ctx, cluster, rcp, controlPlane, needRollout)
+		if supported, err := r.startRollout(ctx, cluster, controlPlane, needRollout); err != nil || !supported {
+			return ctrl.Result{}, err
+		}
+
+		logger.Info("Rolling out Control Plane machines", "needRollout", needRollout.Names(),
+			"inRemovedFailureDomains", controlPlane.MachinesInRemovedFailureDomains().Names())
+
+		replicas := r.rolloutReplicas(ctx, rcp, controlPlane.Role)
+
+		return r.upgradeControlPlane(ctx, cluster, rcp, controlPlane, replicas, needRollout)
 	default:
+		completeRolloutReplicas(ctx, rcp, controlPlane.Role)
+
 		// make sure last upgrade operation is marked as completed.
-		// NOTE: we are checking the condition already exists in order to avoid to set this condition at the first
-		// reconciliation/before a rolling upgrade actually starts.
-		if conditions.Has(controlPlane.RCP, controlplanev1.MachinesSpecUpToDateCondition) {
-			conditions.MarkTrue(controlPlane.RCP, controlplanev1.MachinesSpecUpToDateCondition)
-		}
+		r.completeRollout(cluster, rcp)
 	}
 
 	// If we've made it this far, we can assume that all ownedMachines are up to date
@@ -493,31 +900,188 @@ func (r *RKE2ControlPlaneReconciler) reconcileNormal(
 	desiredReplicas := int(*rcp.Spec.Replicas)
 
 	switch {
+	// We are recovering a control plane which lost all its machines
+	case numMachines < desiredReplicas && numMachines == 0 &&
+		conditions.IsTrue(cluster, clusterv1.ControlPlaneInitializedCondition):
+		logger.Info("Recovering control plane", "Desired", desiredReplicas, "Existing", numMachines)
+		controlPlane.Decision.Decide(rke2.ReconcileActionInitialize)
+
+		return r.recoverControlPlane(ctx, cluster, rcp, controlPlane)
 	// We are creating the first replica
 	case numMachines < desiredReplicas && numMachines == 0:
 		// Create new Machine w/ init
 		logger.Info("Initializing control plane", "Desired", desiredReplicas, "Existing", numMachines)
+		controlPlane.Decision.Decide(rke2.ReconcileActionInitialize)
 		conditions.MarkFalse(controlPlane.RCP,
 			controlplanev1.AvailableCondition,
 			controlplanev1.WaitingForRKE2ServerReason,
 			clusterv1.ConditionSeverityInfo, "")
 
-		return r.initializeControlPlane(ctx, cluster, rcp, controlPlane)
+		return r.initializeControlPlane(ctx, cluster, rcp, controlPlane, nil)
 	// We are scaling up
 	case numMachines < desiredReplicas && numMachines > 0:
 		// Create a new Machine w/ join
 		logger.Info("Scaling up control plane", "Desired", desiredReplicas, "Existing", numMachines)
+		controlPlane.Decision.Decide(rke2.ReconcileActionScaleUp)
 
 		return r.scaleUpControlPlane(ctx, cluster, rcp, controlPlane)
 
 	// We are scaling down
 	case numMachines > desiredReplicas:
 		logger.Info("Scaling down control plane", "Desired", desiredReplicas, "Existing", numMachines)
+		controlPlane.Decision.Decide(rke2.ReconcileActionScaleDown)
 		// The last parameter (i.e. machines needing to be rolled out) should always be empty here.
 		return r.scaleDownControlPlane(ctx, cluster, rcp, controlPlane, collections.Machines{})
 	}
 
-	return ctrl.Result{}, nil
+	return r.reconcileSteadyState(ctx, controlPlane)
+}
+
+// startRollout checks that the machines needing a rollout can be rolled out to the version of the control plane, and
+// reports the rollout in the MachinesSpecUpToDate condition. False is returned when the version is not supported.
+func (r *RKE2ControlPlaneReconciler) startRollout(
+	ctx context.Context,
+	cluster *clusterv1.Cluster,
+	controlPlane *rke2.ControlPlane,
+	needRollout collections.Machines,
+) (bool, error) {
+	logger := log.FromContext(ctx)
+	rcp := controlPlane.RCP
+
+	// The compatibility matrix may have changed since the version was admitted.
+	matrix, err := r.CompatibilitySource.Matrix(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	if err := matrix.Validate(rcp.TargetVersion()); err != nil {
+		logger.Info("Not rolling out Control Plane machines with an unsupported version", "reason", err.Error())
+		conditions.MarkFalse(rcp,
+			controlplanev1.MachinesSpecUpToDateCondition,
+			controlplanev1.UnsupportedVersionReason,
+			clusterv1.ConditionSeverityError,
+			"Not rolling out %d replicas: %v", len(needRollout), err)
+		controlPlane.Decision.Wait(conditions.GetMessage(rcp, controlplanev1.MachinesSpecUpToDateCondition))
+
+		return false, nil
+	}
+
+	trigger, err := rke2.NewRolloutTrigger(rcp, controlPlane.RolloutReason(needRollout), metav1.Now())
+	if err != nil {
+		return false, err
+	}
+
+	// The trigger of the rollout is recorded once, or again when the spec or the reason changes during the rollout.
+	if last := rcp.Status.LastRolloutTrigger; last == nil || last.SpecHash != trigger.SpecHash || last.Reason != trigger.Reason {
+		rcp.Status.LastRolloutTrigger = trigger
+		logger.Info("Rollout triggered", "reason", trigger.Reason, "specHash", trigger.SpecHash,
+			"fieldManager", trigger.FieldManager, "operation", trigger.Operation)
+		r.lifecycleEventf(cluster, rcp, corev1.EventTypeNormal, "RolloutTriggered",
+			"Rolling out %d control plane machines to spec %s (%s), last changed by %s", len(needRollout), trigger.SpecHash,
+			trigger.Reason, rolloutTriggerManager(trigger))
+	}
+
+	if !isRolloutInProgress(rcp) {
+		r.lifecycleEventf(cluster, rcp, corev1.EventTypeNormal, "UpgradeStarted",
+			"Rolling out %d control plane machines to version %s", len(needRollout), rcp.TargetVersion())
+	}
+
+	if rcp.Spec.RolloutStrategyType() == controlplanev1.OnDeleteStrategyType {
+		conditions.MarkFalse(rcp,
+			controlplanev1.MachinesSpecUpToDateCondition,
+			controlplanev1.WaitingForOutdatedMachinesDeletionReason,
+			clusterv1.ConditionSeverityWarning,
+			"Waiting for %d replicas with outdated spec to be deleted (%d replicas up to date)",
+			len(needRollout),
+			len(controlPlane.Machines)-len(needRollout))
+
+		return true, nil
+	}
+
+	conditions.MarkFalse(rcp,
+		controlplanev1.MachinesSpecUpToDateCondition,
+		controlplanev1.RollingUpdateInProgressReason,
+		clusterv1.ConditionSeverityWarning,
+		"Rolling %d replicas with outdated spec (%d replicas up to date)",
+		len(needRollout),
+		len(controlPlane.Machines)-len(needRollout))
+
+	return true, nil
+}
+
+// isRolloutInProgress returns whether the machines of the control plane are being rolled out, by either strategy.
+func isRolloutInProgress(rcp *controlplanev1.RKE2ControlPlane) bool {
+	switch conditions.GetReason(rcp, controlplanev1.MachinesSpecUpToDateCondition) {
+	case controlplanev1.RollingUpdateInProgressReason, controlplanev1.WaitingForOutdatedMachinesDeletionReason:
+		return true
+	default:
+		return false
+	}
+}
+
+// rolloutTriggerManager describes the field manager of a rollout trigger for its event.
+func rolloutTriggerManager(trigger *controlplanev1.RolloutTrigger) string {
+	if trigger.FieldManager == "" {
+		return "an unknown manager"
+	}
+
+	return fmt.Sprintf("%s (%s at %s)", trigger.FieldManager, trigger.Operation, trigger.ChangeTime.UTC().Format(time.RFC3339))
+}
+
+// completeRollout marks the last rollout as completed.
+func (r *RKE2ControlPlaneReconciler) completeRollout(cluster *clusterv1.Cluster, rcp *controlplanev1.RKE2ControlPlane) {
+	// NOTE: we are checking the condition already exists in order to avoid to set this condition at the first
+	// reconciliation/before a rolling upgrade actually starts.
+	if !conditions.Has(rcp, controlplanev1.MachinesSpecUpToDateCondition) {
+		return
+	}
+
+	if isRolloutInProgress(rcp) {
+		r.lifecycleEventf(cluster, rcp, corev1.EventTypeNormal, "UpgradeCompleted",
+			"Control plane machines rolled out to version %s", rcp.TargetVersion())
+	}
+
+	conditions.MarkTrue(rcp, controlplanev1.MachinesSpecUpToDateCondition)
+}
+
+// reconcileSteadyState performs the operations on a control plane which is neither rolled out nor scaled.
+func (r *RKE2ControlPlaneReconciler) reconcileSteadyState(ctx context.Context, controlPlane *rke2.ControlPlane) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+	rcp := controlPlane.RCP
+
+	// The etcd members of the machines being created or deleted are not orphaned, they are only garbage collected and
+	// defragmented while the control plane is neither rolled out nor scaled.
+	if err := r.reconcileEtcdMemberGarbageCollection(ctx, controlPlane); err != nil {
+		logger.Error(err, "failed to remove the orphaned etcd members")
+
+		return ctrl.Result{}, err
+	}
+
+	result, err := r.reconcileEtcdDefragmentation(ctx, controlPlane)
+	if err != nil {
+		logger.Error(err, "failed to defragment the etcd members")
+
+		return result, err
+	}
+
+	// The registries configuration is written on the nodes of the servers once the control plane is stable.
+	registriesResult, err := r.reconcileRegistriesRefresh(ctx, controlPlane)
+	if err != nil {
+		logger.Error(err, "failed to refresh the registries configuration")
+
+		return registriesResult, err
+	}
+
+	result = util.LowestNonZeroResult(result, registriesResult)
+
+	// Make sure the rollout is triggered as soon as RolloutAfter, or the time of the restart annotation, is reached.
+	for _, rolloutAfter := range []*metav1.Time{rcp.Spec.RolloutAfter, rcp.RestartTime()} {
+		if rolloutAfter != nil && rolloutAfter.After(time.Now()) {
+			result = util.LowestNonZeroResult(result, ctrl.Result{RequeueAfter: time.Until(rolloutAfter.Time)})
+		}
+	}
+
+	return result, nil
 }
 
 func (r *RKE2ControlPlaneReconciler) reconcileDelete(ctx context.Context,
@@ -538,10 +1102,16 @@ func (r *RKE2ControlPlaneReconciler) reconcileDelete(ctx context.Context,
 	if len(ownedMachines) == 0 {
 		controllerutil.RemoveFinalizer(rcp, controlplanev1.RKE2ControlPlaneFinalizer)
 
+		// The workload cluster is gone with its control plane.
+		if rke2.IsClusterControlPlane(cluster, rcp) {
+			r.managementCluster.ForgetWorkloadCluster(util.ObjectKey(cluster))
+			r.managementClusterUncached.ForgetWorkloadCluster(util.ObjectKey(cluster))
+		}
+
 		return ctrl.Result{}, nil
 	}
 
-	controlPlane, err := rke2.NewControlPlane(ctx, r.Client, cluster, rcp, ownedMachines)
+	controlPlane, err := r.newControlPlane(ctx, cluster, rcp, ownedMachines)
 	if err != nil {
 		logger.Error(err, "failed to initialize control plane")
 
@@ -564,8 +1134,9 @@ func (r *RKE2ControlPlaneReconciler) reconcileDelete(ctx context.Context,
 		conditions.AddSourceRef(),
 		conditions.WithStepCounterIf(false))
 
-	// Verify that only control plane machines remain
-	if len(allMachines) != len(ownedMachines) {
+	// Verify that only control plane machines remain, the machines of the cluster are not waited for by a control
+	// plane the cluster doesn't reference.
+	if rke2.IsClusterControlPlane(cluster, rcp) && len(allMachines) != len(ownedMachines) {
 		logger.Info("Waiting for worker nodes to be deleted first")
 		conditions.MarkFalse(rcp,
 			controlplanev1.ResizedCondition,
@@ -622,11 +1193,13 @@ func (r *RKE2ControlPlaneReconciler) reconcileKubeconfig(
 
 	switch {
 	case apierrors.IsNotFound(errors.Cause(err)):
+		// The host of an IPv6 endpoint may already be enclosed in brackets, the readiness of the workload cluster is
+		// probed on the server of the kubeconfig.
 		createErr := kubeconfig.CreateSecretWithOwner(
 			ctx,
 			r.Client,
 			clusterName,
-			endpoint.String(),
+			rke2.HostPort(endpoint.Host, int(endpoint.Port)),
 			controllerOwnerRef,
 		)
 		if errors.Is(createErr, kubeconfig.ErrDependentCertificateNotFound) {
@@ -647,6 +1220,38 @@ func (r *RKE2ControlPlaneReconciler) reconcileKubeconfig(
 	return ctrl.Result{}, nil
 }
 
+// reconcileWorkerProvisioning records the provisioning times of the worker machines of the cluster in their annotations,
+// as the control plane controller is the one connected to the workload cluster.
+func (r *RKE2ControlPlaneReconciler) reconcileWorkerProvisioning(
+	ctx context.Context,
+	cluster *clusterv1.Cluster,
+	workloadCluster rke2.WorkloadCluster,
+) error {
+	workers, err := r.managementCluster.GetMachinesForCluster(ctx, util.ObjectKey(cluster),
+		collections.Not(collections.ControlPlaneMachines(cluster.Name)))
+	if err != nil {
+		return errors.Wrap(err, "failed to get the worker machines")
+	}
+
+	before := map[string]*clusterv1.Machine{}
+	for name, machine := range workers {
+		before[name] = machine.DeepCopy()
+	}
+
+	changed, err := workloadCluster.RecordWorkerProvisioning(ctx, workers)
+	if err != nil {
+		return err
+	}
+
+	for _, machine := range changed {
+		if err := r.Client.Patch(ctx, machine, client.MergeFrom(before[machine.Name])); err != nil {
+			return errors.Wrapf(err, "failed to record the provisioning times of machine %s", machine.Name)
+		}
+	}
+
+	return nil
+}
+
 // reconcileControlPlaneConditions is responsible of reconciling conditions reporting the status of static pods and
 // the status of the etcd cluster.
 func (r *RKE2ControlPlaneReconciler) reconcileControlPlaneConditions(ctx context.Context, controlPlane *rke2.ControlPlane) (ctrl.Result, error) {
@@ -659,6 +1264,7 @@ func (r *RKE2ControlPlaneReconciler) reconcileControlPlaneConditions(ctx context
 		controlPlane.RCP.Status.Ready = false
 		controlPlane.RCP.Status.ReadyReplicas = 0
 		controlPlane.RCP.Status.AvailableServerIPs = nil
+		controlPlane.RCP.Status.MachineNodes = nil
 		conditions.MarkFalse(
 			controlPlane.RCP,
 			controlplanev1.AvailableCondition,
@@ -686,7 +1292,25 @@ func (r *RKE2ControlPlaneReconciler) reconcileControlPlaneConditions(ctx context
 
 	// Update conditions status
 	workloadCluster.UpdateAgentConditions(ctx, controlPlane)
+	alarms := conditions.Get(controlPlane.RCP, controlplanev1.EtcdAlarmsClearedCondition)
+	etcdCheckStart := time.Now()
 	workloadCluster.UpdateEtcdConditions(ctx, controlPlane)
+	rke2.RecordPhaseDuration(controlPlane.RCP, controlplanev1.EtcdCheckReconcilePhase, etcdCheckStart)
+	r.recordEtcdAlarms(controlPlane.RCP, alarms)
+
+	if err := workloadCluster.UpdateMachineNodes(ctx, controlPlane); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if err := r.reconcileWorkerProvisioning(ctx, controlPlane.Cluster, workloadCluster); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	drifted := conditions.Has(controlPlane.RCP, controlplanev1.VersionDriftCondition)
+	if controlPlane.UpdateVersionDriftCondition(time.Now()) && !drifted {
+		r.recorder.Eventf(controlPlane.RCP, corev1.EventTypeWarning, controlplanev1.VersionDriftDetectedReason, "%s",
+			conditions.GetMessage(controlPlane.RCP, controlplanev1.VersionDriftCondition))
+	}
 
 	// Patch machines with the updated conditions.
 	if err := controlPlane.PatchMachines(ctx); err != nil {
@@ -697,11 +1321,188 @@ func (r *RKE2ControlPlaneReconciler) reconcileControlPlaneConditions(ctx context
 	return ctrl.Result{}, nil
 }
 
+// recordEtcdAlarms records an event when etcd alarms are raised or cleared, given the EtcdAlarmsClearedCondition before
+// the etcd cluster was inspected.
+func (r *RKE2ControlPlaneReconciler) recordEtcdAlarms(rcp *controlplanev1.RKE2ControlPlane, previous *clusterv1.Condition) {
+	raised := previous != nil && previous.Status == corev1.ConditionFalse
+
+	switch {
+	case conditions.IsFalse(rcp, controlplanev1.EtcdAlarmsClearedCondition):
+		message := conditions.GetMessage(rcp, controlplanev1.EtcdAlarmsClearedCondition)
+		if !raised || previous.Message != message {
+			r.recorder.Eventf(rcp, corev1.EventTypeWarning, controlplanev1.EtcdAlarmsRaisedReason,
+				"Etcd alarms raised: %s. The control plane is not scaled nor rolled out until they are resolved, "+
+					"unless it has the %s annotation", message, controlplanev1.IgnoreEtcdAlarmsAnnotation)
+		}
+	case conditions.IsTrue(rcp, controlplanev1.EtcdAlarmsClearedCondition) && raised:
+		r.recorder.Event(rcp, corev1.EventTypeNormal, "EtcdAlarmsCleared", "Etcd alarms cleared")
+	}
+}
+
+// reconcileControlPlaneMetrics makes sure the secret and the RBAC resources needed to scrape the control plane
+// components exist in the workload cluster, when the control plane metrics are enabled.
+func (r *RKE2ControlPlaneReconciler) reconcileControlPlaneMetrics(
+	ctx context.Context,
+	controlPlane *rke2.ControlPlane,
+	certificates secret.Certificates,
+) error {
+	metrics := controlPlane.RCP.Spec.ServerConfig.Metrics
+	if metrics == nil || !controlPlane.RCP.Status.Initialized {
+		return nil
+	}
+
+	workloadCluster, err := r.managementCluster.GetWorkloadCluster(ctx, util.ObjectKey(controlPlane.Cluster))
+	if err != nil {
+		return errors.Wrap(err, "cannot get remote client to workload cluster")
+	}
+
+	return workloadCluster.UpdateControlPlaneMetrics(ctx, *metrics, certificates)
+}
+
+// reconcileKubeletServingCertificates approves the kubelet serving certificates of the nodes of all the machines of the
+// cluster, when enabled.
+func (r *RKE2ControlPlaneReconciler) reconcileKubeletServingCertificates(ctx context.Context, controlPlane *rke2.ControlPlane) error {
+	if !controlPlane.RCP.Spec.ServerConfig.ApproveKubeletServingCertificates || !controlPlane.RCP.Status.Initialized {
+		return nil
+	}
+
+	machines, err := r.managementCluster.GetMachinesForCluster(ctx, util.ObjectKey(controlPlane.Cluster))
+	if err != nil {
+		return err
+	}
+
+	workloadCluster, err := r.managementCluster.GetWorkloadCluster(ctx, util.ObjectKey(controlPlane.Cluster))
+	if err != nil {
+		return errors.Wrap(err, "cannot get remote client to workload cluster")
+	}
+
+	return workloadCluster.ApproveKubeletServingCertificates(ctx, machines)
+}
+
+// reconcileSelfHosting detects if the controller runs in the workload cluster of the control plane, e.g. after
+// "clusterctl move", and the node it runs on, so the operations that would take the controller down can be avoided.
+func (r *RKE2ControlPlaneReconciler) reconcileSelfHosting(ctx context.Context, controlPlane *rke2.ControlPlane) error {
+	rcp := controlPlane.RCP
+
+	if r.ControllerPod == nil || !rcp.Status.Initialized || rcp.Status.Hibernated {
+		return nil
+	}
+
+	workloadCluster, err := r.managementCluster.GetWorkloadCluster(ctx, util.ObjectKey(controlPlane.Cluster))
+	if err != nil {
+		return errors.Wrap(err, "cannot get remote client to workload cluster")
+	}
+
+	nodeName, err := workloadCluster.GetControllerNodeName(ctx, *r.ControllerPod)
+	if err != nil {
+		return err
+	}
+
+	if nodeName != "" && !rcp.Status.SelfHosted {
+		log.FromContext(ctx).Info("The controller runs in the workload cluster, the cluster manages itself", "node", nodeName)
+	}
+
+	controlPlane.ControllerNodeName = nodeName
+	rcp.Status.SelfHosted = nodeName != ""
+
+	return nil
+}
+
+// reconcileStaleNodes deletes the workload cluster nodes left behind by the deleted machines of the cluster, so they
+// don't linger as NotReady nodes.
+func (r *RKE2ControlPlaneReconciler) reconcileStaleNodes(ctx context.Context, controlPlane *rke2.ControlPlane) error {
+	if !controlPlane.RCP.Status.Initialized {
+		return nil
+	}
+
+	machines, err := r.managementCluster.GetMachinesForCluster(ctx, util.ObjectKey(controlPlane.Cluster))
+	if err != nil {
+		return err
+	}
+
+	workloadCluster, err := r.managementCluster.GetWorkloadCluster(ctx, util.ObjectKey(controlPlane.Cluster))
+	if err != nil {
+		return errors.Wrap(err, "cannot get remote client to workload cluster")
+	}
+
+	etcdMembers, err := workloadCluster.DeleteStaleNodes(ctx, machines)
+
+	for _, member := range etcdMembers {
+		r.lifecycleEventf(controlPlane.Cluster, controlPlane.RCP, corev1.EventTypeNormal, "EtcdMemberRemoved",
+			"Etcd member %s removed with the node of its deleted machine", member)
+	}
+
+	return err
+}
+
+// reconcileCertificatesExpiry records the expiry of the certificates of the control plane machines in their
+// certificates expiry annotation, which Cluster API reports in the status of the machines, so that they are replaced
+// before their certificates expire when rolloutBefore is set. The expiry is read from the serving certificate of the
+// API server of the machines, on all the machines at once, and read again every certificatesExpiryRefreshInterval as
+// RKE2 renews the certificates close to their expiry when it restarts. The dedicated etcd machines are skipped as they
+// run no API server.
+func (r *RKE2ControlPlaneReconciler) reconcileCertificatesExpiry(ctx context.Context, controlPlane *rke2.ControlPlane) error {
+	if !controlPlane.RCP.Status.Initialized {
+		return nil
+	}
+
+	now := time.Now()
+	machines := controlPlane.Machines.Filter(
+		collections.Not(collections.HasDeletionTimestamp),
+		collections.Not(rke2.HasRole(controlplanev1.EtcdMachineRole)),
+		func(machine *clusterv1.Machine) bool {
+			checkedAt, err := time.Parse(time.RFC3339, machine.Annotations[controlplanev1.CertificatesExpiryCheckedAtAnnotation])
+
+			return machine.Status.NodeRef != nil && (err != nil || now.Sub(checkedAt) > certificatesExpiryRefreshInterval)
+		},
+	)
+	if machines.Len() == 0 {
+		return nil
+	}
+
+	caSecret, err := secret.GetFromNamespacedName(ctx, r.Client, util.ObjectKey(controlPlane.Cluster), secret.ClusterCA)
+	if err != nil {
+		return errors.Wrap(err, "failed to get the cluster certificate authority")
+	}
+
+	workloadCluster, err := r.managementCluster.GetWorkloadCluster(ctx, util.ObjectKey(controlPlane.Cluster))
+	if err != nil {
+		return errors.Wrap(err, "cannot get remote client to workload cluster")
+	}
+
+	errs := []error{}
+
+	for _, machine := range machines {
+		expiry, err := workloadCluster.CertificatesExpiry(ctx, machine.Status.NodeRef.Name, caSecret.Data[secret.TLSCrtDataName])
+		if err != nil {
+			// The failed job is kept for a while, the certificates of the machine are not read again meanwhile.
+			log.FromContext(ctx).Info("Failed to read the certificates expiry of the machine", "machine", machine.Name,
+				"error", err.Error())
+
+			continue
+		}
+
+		if expiry.IsZero() {
+			continue
+		}
+
+		if err := rke2.PatchAnnotations(ctx, r.Client, r.apiReader, machine, map[string]string{
+			clusterv1.MachineCertificatesExpiryDateAnnotation:    expiry.UTC().Format(time.RFC3339),
+			controlplanev1.CertificatesExpiryCheckedAtAnnotation: now.UTC().Format(time.RFC3339),
+		}); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return kerrors.NewAggregate(errs)
+}
+
 func (r *RKE2ControlPlaneReconciler) upgradeControlPlane(
 	ctx context.Context,
 	cluster *clusterv1.Cluster,
 	rcp *controlplanev1.RKE2ControlPlane,
 	controlPlane *rke2.ControlPlane,
+	replicas int32,
 	machinesRequireUpgrade collections.Machines,
 ) (ctrl.Result, error) {
 	logger := controlPlane.Logger()
@@ -721,17 +1522,82 @@ func (r *RKE2ControlPlaneReconciler) upgradeControlPlane(
 		return ctrl.Result{}, err
 	}
 
+	// An etcd snapshot is taken before the first machine is replaced, as a rollback point of the upgrade.
+	if snapshotName := rke2.UpgradeSnapshotName(rcp, machinesRequireUpgrade); snapshotName != "" &&
+		!rke2.IsEtcdSnapshotFile(rcp.Status.UpgradeSnapshotName, snapshotName) && controlPlane.IsEtcdManaged() {
+		snapshotMachine := controlPlane.EtcdMachines().Filter(collections.IsReady(), func(machine *clusterv1.Machine) bool {
+			return machine.Status.NodeRef != nil
+		}).Oldest()
+
+		if snapshotMachine == nil {
+			logger.Info("Waiting for a ready control plane machine to take the etcd snapshot before upgrading")
+
+			return ctrl.Result{RequeueAfter: requeueTime(rcp)}, nil
+		}
+
+		snapshotFile, err := workloadCluster.SnapshotEtcd(ctx, snapshotMachine.Status.NodeRef.Name, snapshotName)
+		if err != nil {
+			return ctrl.Result{}, errors.Wrap(err, "failed to take an etcd snapshot before upgrading")
+		}
+
+		if snapshotFile == "" {
+			logger.Info("Waiting for the etcd snapshot to be taken before upgrading", "snapshot", snapshotName)
+
+			return ctrl.Result{RequeueAfter: requeueTime(rcp)}, nil
+		}
+
+		rcp.Status.UpgradeSnapshotName = snapshotFile
+		r.recorder.Eventf(rcp, corev1.EventTypeNormal, "UpgradeSnapshotTaken",
+			"Took etcd snapshot %s before rolling out version %s", snapshotFile, rcp.TargetVersion())
+	}
+
 	status, err := workloadCluster.ClusterStatus(ctx)
 	if err != nil {
 		return ctrl.Result{}, err
 	}
 
-	if status.Nodes <= *rcp.Spec.Replicas {
-		// scaleUp ensures that we don't continue scaling up while waiting for Machines to have NodeRefs
-		return r.scaleUpControlPlane(ctx, cluster, rcp, controlPlane)
+	// Only the nodes of the role being rolled out are counted when the roles are split.
+	nodes := status.Nodes
+	if controlPlane.Role != "" {
+		nodes = int32(controlPlane.Machines.Filter(func(machine *clusterv1.Machine) bool {
+			return machine.Status.NodeRef != nil
+		}).Len())
 	}
 
-	return r.scaleDownControlPlane(ctx, cluster, rcp, controlPlane, machinesRequireUpgrade)
+	// The node of a deleted machine may linger, it must not be replaced twice.
+	machines := int32(controlPlane.Machines.Len())
+	if machines < nodes {
+		nodes = machines
+	}
+
+	step := rke2.NextRolloutStep(machines, nodes, replicas, rcp.Spec.MaxSurge())
+	if rcp.Spec.RolloutStrategyType() == controlplanev1.OnDeleteStrategyType {
+		step = rke2.NextOnDeleteRolloutStep(machines, nodes, replicas)
+	}
+
+	switch step {
+	case rke2.RolloutStepScaleUp:
+		// The etcd members of the machines deleted by the user are removed before they are replaced, as the
+		// control plane isn't in a steady state, where they are garbage collected, until the rollout completes.
+		if rcp.Spec.RolloutStrategyType() == controlplanev1.OnDeleteStrategyType && !controlPlane.HasDeletingMachine() {
+			if err := r.reconcileEtcdMemberGarbageCollection(ctx, controlPlane); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+
+		return r.scaleUpControlPlane(ctx, cluster, rcp, controlPlane)
+	case rke2.RolloutStepWaitForNode:
+		logger.Info("Waiting for the node of the new control plane machine to join before replacing another machine")
+
+		return ctrl.Result{RequeueAfter: requeueTime(rcp)}, nil
+	case rke2.RolloutStepWaitForDeletion:
+		logger.Info("Waiting for an outdated control plane machine to be deleted to replace it",
+			"needRollout", machinesRequireUpgrade.Names())
+
+		return ctrl.Result{}, nil
+	default:
+		return r.scaleDownControlPlane(ctx, cluster, rcp, controlPlane, machinesRequireUpgrade)
+	}
 }
 
 // ClusterToRKE2ControlPlane is a handler.ToRequestsFunc to be used to enqueue requests for reconciliation
@@ -744,9 +1610,10 @@ func (r *RKE2ControlPlaneReconciler) ClusterToRKE2ControlPlane(o client.Object)
 		return nil
 	}
 
+	// The control plane is in the namespace of the cluster, another namespace may have a control plane of the same name.
 	controlPlaneRef := c.Spec.ControlPlaneRef
 	if controlPlaneRef != nil && controlPlaneRef.Kind == "RKE2ControlPlane" {
-		return []ctrl.Request{{NamespacedName: client.ObjectKey{Namespace: controlPlaneRef.Namespace, Name: controlPlaneRef.Name}}}
+		return []ctrl.Request{{NamespacedName: client.ObjectKey{Namespace: c.Namespace, Name: controlPlaneRef.Name}}}
 	}
 
 	return nil
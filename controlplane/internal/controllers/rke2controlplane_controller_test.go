@@ -0,0 +1,117 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/pointer"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+
+	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+)
+
+var _ = Describe("recording the provisioning of the worker machines", func() {
+	ctx := context.Background()
+
+	It("should record the time the node of the worker machines joined the cluster", func() {
+		joined := metav1.NewTime(time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC))
+
+		workloadClient := fake.NewClientBuilder().WithScheme(newTestScheme()).WithObjects(
+			&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "worker", CreationTimestamp: joined}},
+			&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "machine-1", CreationTimestamp: joined}},
+		).Build()
+
+		env := newTestEnvironment(1, workloadClient)
+
+		worker := newTestMachine(env.Cluster.Namespace, "worker", env.Cluster.Name)
+		worker.Status.NodeRef = &corev1.ObjectReference{Kind: "Node", Name: "worker"}
+		env.createMachines(worker, newControlPlaneMachine(env, "machine-1"))
+
+		Expect(env.Reconciler.reconcileWorkerProvisioning(ctx, env.Cluster, env.ManagementCluster.Workload)).To(Succeed())
+
+		Expect(env.Client.Get(ctx, client.ObjectKeyFromObject(worker), worker)).To(Succeed())
+		Expect(worker.Annotations).To(HaveKeyWithValue(controlplanev1.JoinedAtAnnotation, "2023-06-01T00:00:00Z"))
+
+		// The provisioning of the control plane machines is recorded with the status of the control plane.
+		controlPlaneMachine := &clusterv1.Machine{}
+		Expect(env.Client.Get(ctx, client.ObjectKey{Namespace: env.Cluster.Namespace, Name: "machine-1"},
+			controlPlaneMachine)).To(Succeed())
+		Expect(controlPlaneMachine.Annotations).ToNot(HaveKey(controlplanev1.JoinedAtAnnotation))
+	})
+})
+
+var _ = Describe("reconcile periods", func() {
+	var rcp *controlplanev1.RKE2ControlPlane
+
+	BeforeEach(func() {
+		rcp = &controlplanev1.RKE2ControlPlane{
+			Spec:   controlplanev1.RKE2ControlPlaneSpec{Replicas: pointer.Int32(3)},
+			Status: controlplanev1.RKE2ControlPlaneStatus{Ready: true},
+		}
+	})
+
+	It("should wait for the sync period of the controller to reconcile a ready control plane by default", func() {
+		Expect(reconcilePeriod(rcp)).To(BeZero())
+
+		rcp.Status.Ready = false
+		Expect(reconcilePeriod(rcp)).To(Equal(DefaultRequeueTime))
+	})
+
+	It("should reconcile the control plane at its reconcile periods", func() {
+		rcp.Spec.ReconcilePeriods = &controlplanev1.ReconcilePeriods{
+			NotReady: &metav1.Duration{Duration: 5 * time.Second},
+			Ready:    &metav1.Duration{Duration: 5 * time.Minute},
+		}
+		Expect(reconcilePeriod(rcp)).To(Equal(5 * time.Minute))
+
+		rcp.Status.Ready = false
+		Expect(reconcilePeriod(rcp)).To(Equal(5 * time.Second))
+	})
+
+	It("should keep checking the kubelet serving certificates to approve", func() {
+		rcp.Spec.ServerConfig.ApproveKubeletServingCertificates = true
+		Expect(reconcilePeriod(rcp)).To(Equal(kubeletServingCertificatesRequeueAfter))
+
+		rcp.Spec.ReconcilePeriods = &controlplanev1.ReconcilePeriods{Ready: &metav1.Duration{Duration: time.Hour}}
+		Expect(reconcilePeriod(rcp)).To(Equal(kubeletServingCertificatesRequeueAfter))
+
+		rcp.Spec.ReconcilePeriods.Ready.Duration = 10 * time.Second
+		Expect(reconcilePeriod(rcp)).To(Equal(10 * time.Second))
+	})
+
+	It("should requeue a control plane reconciled without errors at its reconcile period", func() {
+		// The workload cluster is unreachable, so the control plane is not ready.
+		env := newTestEnvironment(1, nil)
+		env.RCP.Spec.ReconcilePeriods = &controlplanev1.ReconcilePeriods{NotReady: &metav1.Duration{Duration: 7 * time.Second}}
+		Expect(env.Client.Update(context.Background(), env.RCP)).To(Succeed())
+
+		res, err := env.Reconciler.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKeyFromObject(env.RCP)})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(res.RequeueAfter).To(Equal(7 * time.Second))
+	})
+})
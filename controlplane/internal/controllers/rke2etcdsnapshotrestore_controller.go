@@ -0,0 +1,418 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	kerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util"
+	"sigs.k8s.io/cluster-api/util/annotations"
+	"sigs.k8s.io/cluster-api/util/collections"
+	"sigs.k8s.io/cluster-api/util/conditions"
+	"sigs.k8s.io/cluster-api/util/patch"
+
+	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+	"github.com/rancher-sandbox/cluster-api-provider-rke2/pkg/rke2"
+)
+
+// RKE2EtcdSnapshotRestoreReconciler reconciles a RKE2EtcdSnapshotRestore object.
+type RKE2EtcdSnapshotRestoreReconciler struct {
+	client.Client
+	apiReader         client.Reader
+	managementCluster rke2.ManagementCluster
+	recorder          record.EventRecorder
+
+	// WorkloadClientOptions configures the clients of the workload clusters.
+	WorkloadClientOptions rke2.WorkloadClientOptions
+}
+
+//+kubebuilder:rbac:groups=controlplane.cluster.x-k8s.io,resources=rke2etcdsnapshotrestores,verbs=get;list;watch;update;patch
+//+kubebuilder:rbac:groups=controlplane.cluster.x-k8s.io,resources=rke2etcdsnapshotrestores/status,verbs=get;update;patch
+
+// Reconcile restores the control plane of a cluster from the etcd snapshot of a RKE2EtcdSnapshotRestore. The restore
+// is carried on as a reset of etcd on the chosen machine, see reconcileEtcdRecovery: the ClusterResetRequestedAnnotation
+// stops the scaling of the control plane, and once the snapshot is restored, the ClusterResetCompletedAnnotation gets
+// the other machines recreated to join the restored etcd.
+func (r *RKE2EtcdSnapshotRestoreReconciler) Reconcile(ctx context.Context, req ctrl.Request) (res ctrl.Result, reterr error) {
+	logger := log.FromContext(ctx)
+	restore := &controlplanev1.RKE2EtcdSnapshotRestore{}
+
+	if err := r.Get(ctx, req.NamespacedName, restore); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	if !restore.DeletionTimestamp.IsZero() {
+		return ctrl.Result{}, nil
+	}
+
+	logger = logger.WithValues("cluster", restore.Spec.ClusterName)
+	ctx = log.IntoContext(ctx, logger)
+
+	if annotations.HasPaused(restore) {
+		logger.Info("Reconciliation is paused for this object")
+
+		return ctrl.Result{}, nil
+	}
+
+	patchHelper, err := patch.NewHelper(restore, r.Client)
+	if err != nil {
+		return ctrl.Result{}, errors.Wrap(err, "failed to configure the patch helper")
+	}
+
+	defer func() {
+		conditions.SetSummary(restore, conditions.WithConditions(controlplanev1.EtcdSnapshotRestoredCondition))
+
+		patchOpts := []patch.Option{
+			patch.WithOwnedConditions{Conditions: []clusterv1.ConditionType{
+				clusterv1.ReadyCondition,
+				controlplanev1.EtcdSnapshotRestoredCondition,
+			}},
+		}
+
+		if reterr == nil {
+			patchOpts = append(patchOpts, patch.WithStatusObservedGeneration{})
+		}
+
+		if err := patchHelper.Patch(ctx, restore, patchOpts...); err != nil {
+			reterr = kerrors.NewAggregate([]error{reterr, errors.Wrap(err, "failed to patch RKE2EtcdSnapshotRestore")})
+		}
+	}()
+
+	switch restore.Status.Phase {
+	case controlplanev1.RKE2EtcdSnapshotRestoreCompleted, controlplanev1.RKE2EtcdSnapshotRestoreFailed:
+		return ctrl.Result{}, nil
+	case "":
+		restore.Status.Phase = controlplanev1.RKE2EtcdSnapshotRestorePending
+	}
+
+	cluster, err := util.GetClusterByName(ctx, r.Client, restore.Namespace, restore.Spec.ClusterName)
+	if err != nil {
+		if apierrors.IsNotFound(errors.Cause(err)) {
+			r.markRestoreFailed(restore, "Cluster %s not found", restore.Spec.ClusterName)
+
+			return ctrl.Result{}, nil
+		}
+
+		return ctrl.Result{}, err
+	}
+
+	machines, err := r.managementCluster.GetMachinesForCluster(ctx, util.ObjectKey(cluster),
+		collections.ControlPlaneMachines(cluster.Name))
+	if err != nil {
+		return ctrl.Result{}, errors.Wrap(err, "failed to get the control plane machines")
+	}
+
+	switch restore.Status.Phase {
+	case controlplanev1.RKE2EtcdSnapshotRestoreRestoring:
+		return r.reconcileRestoring(ctx, cluster, machines, restore)
+	case controlplanev1.RKE2EtcdSnapshotRestoreRejoiningMachines:
+		return r.reconcileRejoiningMachines(ctx, machines, restore)
+	default:
+		return r.reconcilePending(ctx, cluster, machines, restore)
+	}
+}
+
+// reconcilePending starts the restore: once the snapshot is found by the machine, the machine is annotated to stop the
+// scaling of the control plane, rke2-server is stopped on the other machines, and the snapshot is restored on the
+// machine.
+func (r *RKE2EtcdSnapshotRestoreReconciler) reconcilePending(
+	ctx context.Context,
+	cluster *clusterv1.Cluster,
+	machines collections.Machines,
+	restore *controlplanev1.RKE2EtcdSnapshotRestore,
+) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	rcp, err := r.getControlPlane(ctx, cluster)
+	if err != nil {
+		r.markRestoreFailed(restore, "%v", err)
+
+		return ctrl.Result{}, nil
+	}
+
+	// The snapshot is restored on a machine running etcd.
+	machine, err := rke2.EtcdRestoreMachine(
+		machines.Filter(collections.Not(rke2.HasRole(controlplanev1.ControlPlaneMachineRole))), restore.Spec.MachineName)
+	if err != nil {
+		r.markRestoreFailed(restore, "%v", err)
+
+		return ctrl.Result{}, nil
+	}
+
+	restorePath, err := rke2.EtcdSnapshotRestorePath(rcp, restore.Spec.Source, restore.Spec.SnapshotName)
+	if err != nil {
+		r.markRestoreFailed(restore, "%v", err)
+
+		return ctrl.Result{}, nil
+	}
+
+	// Etcd is already being reset or recovered on another machine.
+	resetting := machines.Filter(collections.HasAnnotationKey(controlplanev1.ClusterResetRequestedAnnotation))
+	if _, ok := resetting[machine.Name]; resetting.Len() > 0 && !ok {
+		logger.Info("Waiting for the reset of etcd in progress to complete", "machines", resetting.Names())
+		conditions.MarkFalse(restore, controlplanev1.EtcdSnapshotRestoredCondition,
+			controlplanev1.RestoringEtcdSnapshotReason, clusterv1.ConditionSeverityInfo,
+			"Waiting for the reset of etcd in progress on machines %v", resetting.Names())
+
+		return ctrl.Result{RequeueAfter: etcdRecoveryRequeueAfter}, nil
+	}
+
+	workloadCluster, err := r.managementCluster.GetWorkloadCluster(ctx, util.ObjectKey(cluster))
+	if err != nil {
+		return ctrl.Result{}, errors.Wrap(err, "cannot get remote client to workload cluster")
+	}
+
+	nodeName := machine.Status.NodeRef.Name
+
+	// The jobs are not part of the restored etcd, they must not be created again once the snapshot is restored.
+	restored, err := workloadCluster.EtcdSnapshotRestored(ctx, nodeName, string(restore.UID))
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if !restored {
+		// No server is stopped before the snapshot is known to be found.
+		verified, err := workloadCluster.VerifyEtcdSnapshot(ctx, nodeName, restorePath,
+			restore.Spec.Source == controlplanev1.EtcdSnapshotSourceS3, string(restore.UID))
+		if errors.Is(err, rke2.ErrEtcdSnapshotNotFound) {
+			r.markRestoreFailed(restore, "Etcd snapshot %s not found on machine %s, no control plane machine was stopped",
+				restore.Spec.SnapshotName, machine.Name)
+
+			return ctrl.Result{}, nil
+		}
+
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+
+		if !verified {
+			logger.Info("Verifying the etcd snapshot", "machine", machine.Name, "path", restorePath)
+			conditions.MarkFalse(restore, controlplanev1.EtcdSnapshotRestoredCondition,
+				controlplanev1.VerifyingEtcdSnapshotReason, clusterv1.ConditionSeverityInfo,
+				"Verifying etcd snapshot %s on machine %s", restore.Spec.SnapshotName, machine.Name)
+
+			return ctrl.Result{RequeueAfter: etcdRecoveryRequeueAfter}, nil
+		}
+
+		if err := rke2.PatchAnnotations(ctx, r.Client, r.apiReader, machine, map[string]string{
+			controlplanev1.ClusterResetRequestedAnnotation: time.Now().UTC().Format(time.RFC3339),
+			controlplanev1.EtcdSnapshotRestoreAnnotation:   restore.Name,
+		}); err != nil {
+			return ctrl.Result{}, err
+		}
+
+		otherNodeNames := []string{}
+
+		for _, other := range machines {
+			if other.Name != machine.Name && other.Status.NodeRef != nil {
+				otherNodeNames = append(otherNodeNames, other.Status.NodeRef.Name)
+			}
+		}
+
+		if err := workloadCluster.RestoreEtcdSnapshot(ctx, nodeName, otherNodeNames, restorePath,
+			string(restore.UID)); err != nil {
+			return ctrl.Result{}, err
+		}
+
+		logger.Info("Restoring the etcd snapshot", "machine", machine.Name, "path", restorePath)
+		r.recorder.Eventf(restore, corev1.EventTypeWarning, "EtcdSnapshotRestoreStarted",
+			"Restoring etcd snapshot %s on machine %s, the other control plane machines are stopped",
+			restore.Spec.SnapshotName, machine.Name)
+	}
+
+	restore.Status.MachineName = machine.Name
+	restore.Status.Phase = controlplanev1.RKE2EtcdSnapshotRestoreRestoring
+	conditions.MarkFalse(restore, controlplanev1.EtcdSnapshotRestoredCondition,
+		controlplanev1.RestoringEtcdSnapshotReason, clusterv1.ConditionSeverityInfo,
+		"Restoring etcd snapshot %s on machine %s", restore.Spec.SnapshotName, machine.Name)
+
+	return ctrl.Result{RequeueAfter: etcdRecoveryRequeueAfter}, nil
+}
+
+// reconcileRestoring waits for the workload cluster API to be served from the restored etcd, then requests the other
+// machines to be recreated. The restore is failed once rke2.EtcdRestoreTimeout is elapsed, as rke2-server is started
+// again on the other machines.
+func (r *RKE2EtcdSnapshotRestoreReconciler) reconcileRestoring(
+	ctx context.Context,
+	cluster *clusterv1.Cluster,
+	machines collections.Machines,
+	restore *controlplanev1.RKE2EtcdSnapshotRestore,
+) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	machine, ok := machines[restore.Status.MachineName]
+	if !ok || !machine.DeletionTimestamp.IsZero() || machine.Status.NodeRef == nil {
+		r.markRestoreFailed(restore, "Machine %s restoring the etcd snapshot is being deleted", restore.Status.MachineName)
+
+		return ctrl.Result{}, nil
+	}
+
+	// The workload cluster API is not served while etcd is being restored.
+	workloadCluster, err := r.managementCluster.GetWorkloadCluster(ctx, util.ObjectKey(cluster))
+	if err != nil {
+		if restoreTimedOut(machine) {
+			return ctrl.Result{}, r.rollbackRestore(ctx, machine, restore)
+		}
+
+		logger.Info("Waiting for the workload cluster to be reachable", "reason", err.Error())
+
+		return ctrl.Result{RequeueAfter: etcdRecoveryRequeueAfter}, nil
+	}
+
+	restored, err := workloadCluster.EtcdSnapshotRestored(ctx, machine.Status.NodeRef.Name, string(restore.UID))
+	if err != nil || !restored {
+		if restoreTimedOut(machine) {
+			return ctrl.Result{}, r.rollbackRestore(ctx, machine, restore)
+		}
+
+		logger.Info("Waiting for the etcd snapshot to be restored", "machine", machine.Name)
+
+		return ctrl.Result{RequeueAfter: etcdRecoveryRequeueAfter}, nil
+	}
+
+	if err := rke2.PatchAnnotations(ctx, r.Client, r.apiReader, machine, map[string]string{
+		controlplanev1.ClusterResetCompletedAnnotation: time.Now().UTC().Format(time.RFC3339),
+	}); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	logger.Info("Restored the etcd snapshot", "machine", machine.Name)
+	r.recorder.Eventf(restore, corev1.EventTypeNormal, "EtcdSnapshotRestored",
+		"Restored etcd snapshot %s on machine %s, the other control plane machines are recreated",
+		restore.Spec.SnapshotName, machine.Name)
+
+	restore.Status.Phase = controlplanev1.RKE2EtcdSnapshotRestoreRejoiningMachines
+	conditions.MarkFalse(restore, controlplanev1.EtcdSnapshotRestoredCondition,
+		controlplanev1.RejoiningControlPlaneMachinesReason, clusterv1.ConditionSeverityInfo,
+		"Waiting for the other control plane machines to be recreated")
+
+	return ctrl.Result{RequeueAfter: etcdRecoveryRequeueAfter}, nil
+}
+
+// reconcileRejoiningMachines completes the restore once the RKE2ControlPlane controller deleted the other machines
+// and removed the reset annotations from the machine.
+func (r *RKE2EtcdSnapshotRestoreReconciler) reconcileRejoiningMachines(
+	ctx context.Context,
+	machines collections.Machines,
+	restore *controlplanev1.RKE2EtcdSnapshotRestore,
+) (ctrl.Result, error) {
+	machine, ok := machines[restore.Status.MachineName]
+	if !ok {
+		r.markRestoreFailed(restore, "Machine %s restoring the etcd snapshot is deleted", restore.Status.MachineName)
+
+		return ctrl.Result{}, nil
+	}
+
+	if _, resetting := machine.Annotations[controlplanev1.ClusterResetRequestedAnnotation]; resetting {
+		return ctrl.Result{RequeueAfter: etcdRecoveryRequeueAfter}, nil
+	}
+
+	log.FromContext(ctx).Info("Restored the control plane from the etcd snapshot")
+	restore.Status.Phase = controlplanev1.RKE2EtcdSnapshotRestoreCompleted
+	conditions.MarkTrue(restore, controlplanev1.EtcdSnapshotRestoredCondition)
+
+	return ctrl.Result{}, nil
+}
+
+// restoreTimedOut returns true once rke2.EtcdRestoreTimeout is elapsed since the restore was started on the machine.
+func restoreTimedOut(machine *clusterv1.Machine) bool {
+	startedAt, err := time.Parse(time.RFC3339, machine.Annotations[controlplanev1.ClusterResetRequestedAnnotation])
+	if err != nil {
+		return false
+	}
+
+	return time.Since(startedAt) > rke2.EtcdRestoreTimeout
+}
+
+// rollbackRestore fails a restore which timed out: rke2-server is started again on the other machines by the timer
+// set when they were stopped, the machine is released so that the control plane is scaled again.
+func (r *RKE2EtcdSnapshotRestoreReconciler) rollbackRestore(
+	ctx context.Context,
+	machine *clusterv1.Machine,
+	restore *controlplanev1.RKE2EtcdSnapshotRestore,
+) error {
+	if err := rke2.PatchAnnotations(ctx, r.Client, r.apiReader, machine, nil,
+		controlplanev1.ClusterResetRequestedAnnotation, controlplanev1.EtcdSnapshotRestoreAnnotation); err != nil {
+		return err
+	}
+
+	log.FromContext(ctx).Info("The etcd snapshot restore timed out", "machine", machine.Name)
+	r.markRestoreFailed(restore, "Etcd snapshot %s wasn't restored on machine %s within %s, "+
+		"rke2-server is started again on the other control plane machines", restore.Spec.SnapshotName, machine.Name,
+		rke2.EtcdRestoreTimeout)
+
+	return nil
+}
+
+func (r *RKE2EtcdSnapshotRestoreReconciler) getControlPlane(
+	ctx context.Context,
+	cluster *clusterv1.Cluster,
+) (*controlplanev1.RKE2ControlPlane, error) {
+	ref := cluster.Spec.ControlPlaneRef
+	if ref == nil || ref.Kind != "RKE2ControlPlane" {
+		return nil, errors.Errorf("cluster %s has no RKE2ControlPlane", cluster.Name)
+	}
+
+	rcp := &controlplanev1.RKE2ControlPlane{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: cluster.Namespace, Name: ref.Name}, rcp); err != nil {
+		return nil, errors.Wrapf(err, "failed to get RKE2ControlPlane %s", ref.Name)
+	}
+
+	return rcp, nil
+}
+
+func (r *RKE2EtcdSnapshotRestoreReconciler) markRestoreFailed(
+	restore *controlplanev1.RKE2EtcdSnapshotRestore,
+	messageFormat string,
+	messageArgs ...interface{},
+) {
+	restore.Status.Phase = controlplanev1.RKE2EtcdSnapshotRestoreFailed
+	restore.Status.Message = fmt.Sprintf(messageFormat, messageArgs...)
+	conditions.MarkFalse(restore, controlplanev1.EtcdSnapshotRestoredCondition,
+		controlplanev1.EtcdSnapshotRestoreFailedReason, clusterv1.ConditionSeverityError, "%s", restore.Status.Message)
+	r.recorder.Event(restore, corev1.EventTypeWarning, "EtcdSnapshotRestoreFailed", restore.Status.Message)
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *RKE2EtcdSnapshotRestoreReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	if err := ctrl.NewControllerManagedBy(mgr).
+		For(&controlplanev1.RKE2EtcdSnapshotRestore{}).
+		Complete(r); err != nil {
+		return errors.Wrap(err, "failed setting up with a controller manager")
+	}
+
+	r.recorder = mgr.GetEventRecorderFor("rke2-etcd-snapshot-restore-controller")
+	r.apiReader = mgr.GetAPIReader()
+
+	if r.managementCluster == nil {
+		r.managementCluster = &rke2.Management{Client: r.Client, WorkloadClientOptions: r.WorkloadClientOptions}
+	}
+
+	return nil
+}
@@ -0,0 +1,184 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"sigs.k8s.io/cluster-api/util/conditions"
+
+	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+	"github.com/rancher-sandbox/cluster-api-provider-rke2/pkg/rke2"
+)
+
+var _ = Describe("RKE2EtcdSnapshotRestore controller", func() {
+	var (
+		env            *testEnvironment
+		workloadClient client.Client
+		reconciler     *RKE2EtcdSnapshotRestoreReconciler
+		restore        *controlplanev1.RKE2EtcdSnapshotRestore
+	)
+
+	ctx := context.Background()
+
+	reconcile := func() ctrl.Result {
+		result, err := reconciler.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(restore)})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(env.Client.Get(ctx, client.ObjectKeyFromObject(restore), restore)).To(Succeed())
+
+		return result
+	}
+
+	getJob := func(name string) (*batchv1.Job, error) {
+		job := &batchv1.Job{}
+
+		return job, workloadClient.Get(ctx, client.ObjectKey{Namespace: rke2.HibernationNamespace, Name: name}, job)
+	}
+
+	completeJob := func(name string, succeeded bool) {
+		job, err := getJob(name)
+		Expect(err).ToNot(HaveOccurred())
+
+		if succeeded {
+			job.Status.Succeeded = 1
+		} else {
+			job.Status.Failed = 1
+		}
+
+		Expect(workloadClient.Status().Update(ctx, job)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		nodes := []client.Object{}
+		for _, name := range []string{"machine-1", "machine-2", "machine-3"} {
+			nodes = append(nodes, &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name}})
+		}
+
+		workloadClient = fake.NewClientBuilder().WithScheme(newTestScheme()).WithObjects(nodes...).Build()
+
+		restore = &controlplanev1.RKE2EtcdSnapshotRestore{
+			ObjectMeta: metav1.ObjectMeta{Namespace: metav1.NamespaceDefault, Name: "restore", UID: "restore-uid"},
+			Spec: controlplanev1.RKE2EtcdSnapshotRestoreSpec{
+				ClusterName:  "cluster",
+				SnapshotName: "snapshot-1",
+				MachineName:  "machine-1",
+			},
+		}
+
+		env = newTestEnvironment(3, workloadClient, restore)
+		env.createMachines(
+			newControlPlaneMachine(env, "machine-1"),
+			newControlPlaneMachine(env, "machine-2"),
+			newControlPlaneMachine(env, "machine-3"),
+		)
+
+		reconciler = &RKE2EtcdSnapshotRestoreReconciler{
+			Client:            env.Client,
+			apiReader:         env.Client,
+			managementCluster: env.ManagementCluster,
+			recorder:          env.Recorder,
+		}
+	})
+
+	It("should not stop any machine when the snapshot isn't found", func() {
+		Expect(reconcile().RequeueAfter).To(Equal(etcdRecoveryRequeueAfter))
+		Expect(conditions.GetReason(restore, controlplanev1.EtcdSnapshotRestoredCondition)).
+			To(Equal(controlplanev1.VerifyingEtcdSnapshotReason))
+
+		job, err := getJob("rke2-etcd-restore-verify-machine-1")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(job.Spec.Template.Spec.Containers[0].Env).To(ConsistOf(corev1.EnvVar{
+			Name: "ETCD_RESTORE_PATH", Value: "/var/lib/rancher/rke2/server/db/snapshots/snapshot-1",
+		}))
+
+		completeJob("rke2-etcd-restore-verify-machine-1", false)
+
+		Expect(reconcile()).To(Equal(ctrl.Result{}))
+		Expect(restore.Status.Phase).To(Equal(controlplanev1.RKE2EtcdSnapshotRestoreFailed))
+		Expect(restore.Status.Message).To(ContainSubstring("not found on machine machine-1"))
+
+		for _, name := range []string{"rke2-etcd-restore-stop-machine-2", "rke2-etcd-restore-stop-machine-3", "rke2-etcd-restore-machine-1"} {
+			_, err := getJob(name)
+			Expect(err).To(HaveOccurred(), name)
+		}
+
+		Expect(env.machine("machine-1").Annotations).ToNot(HaveKey(controlplanev1.ClusterResetRequestedAnnotation))
+	})
+
+	It("should restore the verified snapshot, without the control plane running its own reset", func() {
+		reconcile()
+		completeJob("rke2-etcd-restore-verify-machine-1", true)
+
+		Expect(reconcile().RequeueAfter).To(Equal(etcdRecoveryRequeueAfter))
+		Expect(restore.Status.Phase).To(Equal(controlplanev1.RKE2EtcdSnapshotRestoreRestoring))
+
+		for _, name := range []string{"rke2-etcd-restore-stop-machine-2", "rke2-etcd-restore-stop-machine-3", "rke2-etcd-restore-machine-1"} {
+			_, err := getJob(name)
+			Expect(err).ToNot(HaveOccurred(), name)
+		}
+
+		Expect(env.machine("machine-1").Annotations).To(And(
+			HaveKey(controlplanev1.ClusterResetRequestedAnnotation),
+			HaveKeyWithValue(controlplanev1.EtcdSnapshotRestoreAnnotation, "restore"),
+		))
+
+		_, err := env.Reconciler.reconcileEtcdRecovery(ctx, env.controlPlane())
+		Expect(err).ToNot(HaveOccurred())
+
+		jobs := &batchv1.JobList{}
+		Expect(workloadClient.List(ctx, jobs)).To(Succeed())
+
+		for _, job := range jobs.Items {
+			Expect(job.Name).ToNot(HavePrefix("rke2-cluster-reset-"))
+		}
+	})
+
+	It("should fail the restore once it timed out, releasing the machine", func() {
+		restore.Status.Phase = controlplanev1.RKE2EtcdSnapshotRestoreRestoring
+		restore.Status.MachineName = "machine-1"
+		Expect(env.Client.Status().Update(ctx, restore)).To(Succeed())
+
+		startedAt := time.Now().Add(-rke2.EtcdRestoreTimeout - time.Minute).UTC().Format(time.RFC3339)
+		Expect(rke2.PatchAnnotations(ctx, env.Client, env.Client, env.machine("machine-1"), map[string]string{
+			controlplanev1.ClusterResetRequestedAnnotation: startedAt,
+			controlplanev1.EtcdSnapshotRestoreAnnotation:   "restore",
+		})).To(Succeed())
+
+		// The restore is waited for until it times out.
+		env.ManagementCluster.Workload = nil
+		env.ManagementCluster.WorkloadErr = errWorkloadClusterUnreachable
+
+		Expect(reconcile()).To(Equal(ctrl.Result{}))
+		Expect(restore.Status.Phase).To(Equal(controlplanev1.RKE2EtcdSnapshotRestoreFailed))
+		Expect(restore.Status.Message).To(ContainSubstring("rke2-server is started again"))
+		Expect(env.machine("machine-1").Annotations).ToNot(Or(
+			HaveKey(controlplanev1.ClusterResetRequestedAnnotation),
+			HaveKey(controlplanev1.EtcdSnapshotRestoreAnnotation),
+		))
+	})
+})
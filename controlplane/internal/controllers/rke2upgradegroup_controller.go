@@ -0,0 +1,243 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	kerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util/annotations"
+	"sigs.k8s.io/cluster-api/util/conditions"
+	"sigs.k8s.io/cluster-api/util/patch"
+
+	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+	"github.com/rancher-sandbox/cluster-api-provider-rke2/pkg/rke2"
+)
+
+// RKE2UpgradeGroupReconciler reconciles a RKE2UpgradeGroup object.
+type RKE2UpgradeGroupReconciler struct {
+	client.Client
+	recorder record.EventRecorder
+}
+
+//+kubebuilder:rbac:groups=controlplane.cluster.x-k8s.io,resources=rke2upgradegroups,verbs=get;list;watch;update;patch
+//+kubebuilder:rbac:groups=controlplane.cluster.x-k8s.io,resources=rke2upgradegroups/status,verbs=get;update;patch
+
+// Reconcile rolls the version of a RKE2UpgradeGroup out across the RKE2ControlPlanes it selects, wave by wave, and
+// halts when more control plane upgrades fail than tolerated.
+func (r *RKE2UpgradeGroupReconciler) Reconcile(ctx context.Context, req ctrl.Request) (res ctrl.Result, reterr error) {
+	logger := log.FromContext(ctx)
+	group := &controlplanev1.RKE2UpgradeGroup{}
+
+	if err := r.Get(ctx, req.NamespacedName, group); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	if !group.DeletionTimestamp.IsZero() {
+		return ctrl.Result{}, nil
+	}
+
+	if annotations.HasPaused(group) {
+		logger.Info("Reconciliation is paused for this object")
+
+		return ctrl.Result{}, nil
+	}
+
+	patchHelper, err := patch.NewHelper(group, r.Client)
+	if err != nil {
+		return ctrl.Result{}, errors.Wrap(err, "failed to configure the patch helper")
+	}
+
+	defer func() {
+		conditions.SetSummary(group, conditions.WithConditions(controlplanev1.UpgradeCompletedCondition))
+
+		patchOpts := []patch.Option{
+			patch.WithOwnedConditions{Conditions: []clusterv1.ConditionType{
+				clusterv1.ReadyCondition,
+				controlplanev1.UpgradeCompletedCondition,
+			}},
+		}
+
+		if reterr == nil {
+			patchOpts = append(patchOpts, patch.WithStatusObservedGeneration{})
+		}
+
+		if err := patchHelper.Patch(ctx, group, patchOpts...); err != nil {
+			reterr = kerrors.NewAggregate([]error{reterr, errors.Wrap(err, "failed to patch RKE2UpgradeGroup")})
+		}
+	}()
+
+	return r.reconcileNormal(ctx, group)
+}
+
+func (r *RKE2UpgradeGroupReconciler) reconcileNormal(
+	ctx context.Context,
+	group *controlplanev1.RKE2UpgradeGroup,
+) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	selector, err := metav1.LabelSelectorAsSelector(&group.Spec.Selector)
+	if err != nil {
+		return ctrl.Result{}, errors.Wrap(err, "invalid selector")
+	}
+
+	rcps := &controlplanev1.RKE2ControlPlaneList{}
+	if err := r.List(ctx, rcps, client.InNamespace(group.Namespace),
+		client.MatchingLabelsSelector{Selector: selector}); err != nil {
+		return ctrl.Result{}, errors.Wrap(err, "failed to list the selected control planes")
+	}
+
+	wasHalted := group.Status.Halted
+
+	plan, err := rke2.PlanUpgradeGroup(group, rcps.Items, time.Now())
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	for _, rcp := range plan.Upgrade {
+		logger.Info("Upgrading control plane", "controlPlane", rcp.Name, "version", group.Spec.Version)
+
+		before := rcp.DeepCopy()
+		rcp.Spec.AgentConfig.Version = group.Spec.Version
+
+		if err := r.Patch(ctx, rcp, client.MergeFrom(before)); err != nil {
+			// The control plane webhook rejects the upgrades the compatibility matrix doesn't support.
+			if !apierrors.IsInvalid(err) && !apierrors.IsForbidden(err) {
+				group.Status = plan.Status
+
+				return ctrl.Result{}, errors.Wrapf(err, "failed to upgrade control plane %s", rcp.Name)
+			}
+
+			plan.Fail(rcp.Name, err.Error())
+
+			continue
+		}
+
+		r.recorder.Eventf(group, corev1.EventTypeNormal, "UpgradeStarted", "Upgrading control plane %s to %s",
+			rcp.Name, group.Spec.Version)
+	}
+
+	previouslyInProgress := map[string]bool{}
+	for _, member := range group.Status.InProgress {
+		previouslyInProgress[member.Name] = true
+	}
+
+	for _, member := range plan.Status.Upgraded {
+		if previouslyInProgress[member.Name] {
+			r.recorder.Eventf(group, corev1.EventTypeNormal, "UpgradeCompleted", "Control plane %s upgraded to %s",
+				member.Name, group.Spec.Version)
+		}
+	}
+
+	group.Status = plan.Status
+	total := len(rcps.Items)
+
+	switch {
+	case plan.Completed():
+		conditions.MarkTrue(group, controlplanev1.UpgradeCompletedCondition)
+
+		return ctrl.Result{}, nil
+	case plan.Status.Halted:
+		if !wasHalted {
+			logger.Info("Upgrade halted", "failed", len(plan.Status.Failed))
+			r.recorder.Eventf(group, corev1.EventTypeWarning, controlplanev1.UpgradeHaltedReason,
+				"Upgrade halted after %d control plane upgrade failures", len(plan.Status.Failed))
+		}
+
+		conditions.MarkFalse(group, controlplanev1.UpgradeCompletedCondition, controlplanev1.UpgradeHaltedReason,
+			clusterv1.ConditionSeverityError, "%d of %d control plane upgrades failed", len(plan.Status.Failed), total)
+	case group.Spec.Paused:
+		conditions.MarkFalse(group, controlplanev1.UpgradeCompletedCondition, controlplanev1.UpgradePausedReason,
+			clusterv1.ConditionSeverityInfo, "%d of %d control planes upgraded", len(plan.Status.Upgraded), total)
+	default:
+		conditions.MarkFalse(group, controlplanev1.UpgradeCompletedCondition, controlplanev1.UpgradingReason,
+			clusterv1.ConditionSeverityInfo, "%d of %d control planes upgraded", len(plan.Status.Upgraded), total)
+	}
+
+	// The upgrades in progress are checked again for their timeout.
+	if len(plan.Status.InProgress) > 0 {
+		return ctrl.Result{RequeueAfter: DefaultRequeueTime}, nil
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *RKE2UpgradeGroupReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	c, err := ctrl.NewControllerManagedBy(mgr).
+		For(&controlplanev1.RKE2UpgradeGroup{}).
+		Build(r)
+	if err != nil {
+		return errors.Wrap(err, "failed setting up with a controller manager")
+	}
+
+	err = c.Watch(
+		&source.Kind{Type: &controlplanev1.RKE2ControlPlane{}},
+		handler.EnqueueRequestsFromMapFunc(r.rke2ControlPlaneToRKE2UpgradeGroups),
+	)
+	if err != nil {
+		return errors.Wrap(err, "failed adding Watch for RKE2ControlPlanes to controller manager")
+	}
+
+	r.recorder = mgr.GetEventRecorderFor("rke2-upgrade-group-controller")
+
+	return nil
+}
+
+// rke2ControlPlaneToRKE2UpgradeGroups is a handler.ToRequestsFunc to be used to enqueue requests for the
+// RKE2UpgradeGroups selecting a RKE2ControlPlane.
+func (r *RKE2UpgradeGroupReconciler) rke2ControlPlaneToRKE2UpgradeGroups(o client.Object) []ctrl.Request {
+	rcp, ok := o.(*controlplanev1.RKE2ControlPlane)
+	if !ok {
+		log.Log.Error(nil, fmt.Sprintf("Expected a RKE2ControlPlane but got a %T", o))
+
+		return nil
+	}
+
+	groups := &controlplanev1.RKE2UpgradeGroupList{}
+	if err := r.Client.List(context.Background(), groups, client.InNamespace(rcp.Namespace)); err != nil {
+		return nil
+	}
+
+	requests := []ctrl.Request{}
+
+	for i := range groups.Items {
+		selector, err := metav1.LabelSelectorAsSelector(&groups.Items[i].Spec.Selector)
+		if err != nil || !selector.Matches(labels.Set(rcp.Labels)) {
+			continue
+		}
+
+		requests = append(requests, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(&groups.Items[i])})
+	}
+
+	return requests
+}
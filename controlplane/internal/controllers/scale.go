@@ -26,6 +26,7 @@ import (
 	"github.com/pkg/errors"
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	kerrors "k8s.io/apimachinery/pkg/util/errors"
@@ -36,6 +37,7 @@ import (
 	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
 	"sigs.k8s.io/cluster-api/controllers/external"
 	"sigs.k8s.io/cluster-api/util"
+	"sigs.k8s.io/cluster-api/util/annotations"
 	"sigs.k8s.io/cluster-api/util/collections"
 	"sigs.k8s.io/cluster-api/util/conditions"
 
@@ -50,6 +52,7 @@ func (r *RKE2ControlPlaneReconciler) initializeControlPlane(
 	cluster *clusterv1.Cluster,
 	rcp *controlplanev1.RKE2ControlPlane,
 	controlPlane *rke2.ControlPlane,
+	bootstrapAnnotations map[string]string,
 ) (ctrl.Result, error) {
 	logger := controlPlane.Logger()
 
@@ -72,7 +75,15 @@ func (r *RKE2ControlPlaneReconciler) initializeControlPlane(
 	bootstrapSpec := controlPlane.InitialControlPlaneConfig()
 	fd := controlPlane.NextFailureDomainForScaleUp()
 
-	if err := r.cloneConfigsAndGenerateMachine(ctx, cluster, rcp, bootstrapSpec, fd); err != nil {
+	if err := r.cloneConfigsAndGenerateMachine(ctx, cluster, rcp, controlPlane.Role, bootstrapSpec, bootstrapAnnotations, fd); err != nil {
+		if isInfrastructureCapacityError(err) {
+			return r.waitForInfrastructureCapacity(ctx, cluster, rcp, err)
+		}
+
+		if isInfrastructureProviderUnavailableError(err) {
+			return r.waitForInfrastructureProvider(ctx, rcp, controlplanev1.MachinesCreatedCondition, err.Error())
+		}
+
 		logger.Error(err, "Failed to create initial control plane Machine")
 		r.recorder.Eventf(
 			rcp,
@@ -107,7 +118,15 @@ func (r *RKE2ControlPlaneReconciler) scaleUpControlPlane(
 	bootstrapSpec := controlPlane.JoinControlPlaneConfig()
 	fd := controlPlane.NextFailureDomainForScaleUp()
 
-	if err := r.cloneConfigsAndGenerateMachine(ctx, cluster, rcp, bootstrapSpec, fd); err != nil {
+	if err := r.cloneConfigsAndGenerateMachine(ctx, cluster, rcp, controlPlane.Role, bootstrapSpec, nil, fd); err != nil {
+		if isInfrastructureCapacityError(err) {
+			return r.waitForInfrastructureCapacity(ctx, cluster, rcp, err)
+		}
+
+		if isInfrastructureProviderUnavailableError(err) {
+			return r.waitForInfrastructureProvider(ctx, rcp, controlplanev1.MachinesCreatedCondition, err.Error())
+		}
+
 		logger.Error(err, "Failed to create additional control plane Machine")
 		r.recorder.Eventf(
 			rcp,
@@ -154,6 +173,11 @@ func (r *RKE2ControlPlaneReconciler) scaleDownControlPlane(
 	}
 
 	logger = logger.WithValues("machine", machineToDelete)
+
+	if result, err := r.removeEtcdMember(ctx, cluster, controlPlane, machineToDelete); err != nil || !result.IsZero() {
+		return result, err
+	}
+
 	if err := r.Client.Delete(ctx, machineToDelete); err != nil && !apierrors.IsNotFound(err) {
 		logger.Error(err, "Failed to delete control plane machine")
 		r.recorder.Eventf(rcp, corev1.EventTypeWarning, "FailedScaleDown",
@@ -162,15 +186,150 @@ func (r *RKE2ControlPlaneReconciler) scaleDownControlPlane(
 		return ctrl.Result{}, err
 	}
 
+	if _, outdated := outdatedMachines[machineToDelete.Name]; outdated {
+		r.lifecycleEventf(cluster, rcp, corev1.EventTypeNormal, "MachineReplaced",
+			"Control plane Machine %s with an outdated spec replaced", machineToDelete.Name)
+	}
+
 	// Requeue the control plane, in case there are additional operations to perform
 	return ctrl.Result{Requeue: true}, nil
 }
 
+// removeEtcdMember removes the etcd member of a control plane machine before the machine is deleted, so that no stale
+// member is left behind to degrade the etcd quorum. The machine is marked for deletion meanwhile, so that the same
+// machine is selected again until its member is removed. A non zero result is returned while the member is removed.
+func (r *RKE2ControlPlaneReconciler) removeEtcdMember(
+	ctx context.Context,
+	cluster *clusterv1.Cluster,
+	controlPlane *rke2.ControlPlane,
+	machine *clusterv1.Machine,
+) (ctrl.Result, error) {
+	logger := controlPlane.Logger().WithValues("machine", machine.Name)
+
+	// The machines of the control plane role run no etcd member.
+	if machine.Status.NodeRef == nil || !controlPlane.IsEtcdManaged() ||
+		rke2.HasRole(controlplanev1.ControlPlaneMachineRole)(machine) {
+		return ctrl.Result{}, nil
+	}
+
+	if _, marked := machine.Annotations[clusterv1.DeleteMachineAnnotation]; !marked {
+		if err := rke2.PatchAnnotations(ctx, r.Client, r.apiReader, machine, map[string]string{
+			clusterv1.DeleteMachineAnnotation: "",
+		}); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	workloadCluster, err := r.managementCluster.GetWorkloadCluster(ctx, util.ObjectKey(cluster))
+	if err != nil {
+		return ctrl.Result{}, errors.Wrap(err, "cannot get remote client to workload cluster")
+	}
+
+	removed, err := workloadCluster.RemoveEtcdMemberForMachine(ctx, machine)
+	if err != nil {
+		return ctrl.Result{}, errors.Wrapf(err, "failed to remove the etcd member of machine %s", machine.Name)
+	}
+
+	if !removed {
+		logger.Info("Waiting for the etcd member to be removed before deleting the control plane machine")
+		r.recorder.Eventf(controlPlane.RCP, corev1.EventTypeNormal, "RemovingEtcdMember",
+			"Removing the etcd member of control plane Machine %s before deleting it", machine.Name)
+
+		return ctrl.Result{RequeueAfter: DefaultRequeueTime}, nil
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// scaleDownControlPlaneToZero tears the control plane down when its replicas are set to 0, along with its etcd replicas
+// when the roles are split: an etcd snapshot is taken on a ready etcd machine, then all the control plane machines are
+// deleted. The secrets holding the certificate authorities and the join token are kept, so the control plane can be
+// scaled up again.
+func (r *RKE2ControlPlaneReconciler) scaleDownControlPlaneToZero(
+	ctx context.Context,
+	cluster *clusterv1.Cluster,
+	rcp *controlplanev1.RKE2ControlPlane,
+	controlPlane *rke2.ControlPlane,
+) (ctrl.Result, error) {
+	logger := controlPlane.Logger()
+
+	if controlPlane.Machines.Len() == 0 {
+		return ctrl.Result{}, nil
+	}
+
+	// The replicas are validated by the webhook, but the annotation may have been removed since.
+	if _, ok := rcp.Annotations[controlplanev1.AllowScaleToZeroAnnotation]; !ok {
+		logger.Info("Refusing to scale the control plane to zero replicas", "annotation", controlplanev1.AllowScaleToZeroAnnotation)
+		r.recorder.Eventf(rcp, corev1.EventTypeWarning, "ScaleToZeroNotAllowed",
+			"Refusing to scale the control plane to zero replicas without the %s annotation", controlplanev1.AllowScaleToZeroAnnotation)
+
+		return ctrl.Result{}, nil
+	}
+
+	if controlPlane.IsSelfHosted() {
+		logger.Info("Refusing to scale the control plane of the cluster the controller runs in to zero replicas")
+		r.recorder.Eventf(rcp, corev1.EventTypeWarning, "ScaleToZeroNotAllowed",
+			"Refusing to scale the control plane of the cluster the controller runs in to zero replicas")
+
+		return ctrl.Result{}, nil
+	}
+
+	snapshotName := fmt.Sprintf("%s-scale-to-zero-%d", rcp.Name, rcp.Generation)
+	snapshotMachine := controlPlane.EtcdMachines().Filter(collections.IsReady(), func(machine *clusterv1.Machine) bool {
+		return machine.Status.NodeRef != nil
+	}).Oldest()
+
+	// The machines are only deleted once the snapshot is saved.
+	if !rke2.IsEtcdSnapshotFile(rcp.Status.ScaleToZeroSnapshotName, snapshotName) && controlPlane.IsEtcdManaged() {
+		if snapshotMachine == nil {
+			logger.Info("Waiting for a ready control plane machine to take the etcd snapshot before scaling to zero replicas")
+			r.recorder.Eventf(rcp, corev1.EventTypeWarning, "ScaleToZeroBlocked",
+				"Not scaling the control plane to zero replicas, no control plane machine is ready to take an etcd snapshot")
+
+			return ctrl.Result{RequeueAfter: DefaultRequeueTime}, nil
+		}
+
+		workloadCluster, err := r.managementCluster.GetWorkloadCluster(ctx, util.ObjectKey(cluster))
+		if err != nil {
+			return ctrl.Result{}, errors.Wrap(err, "cannot get remote client to workload cluster")
+		}
+
+		snapshotFile, err := workloadCluster.SnapshotEtcd(ctx, snapshotMachine.Status.NodeRef.Name, snapshotName)
+		if err != nil {
+			return ctrl.Result{}, errors.Wrap(err, "failed to take an etcd snapshot before scaling to zero replicas")
+		}
+
+		if snapshotFile == "" {
+			logger.Info("Waiting for the etcd snapshot to be taken before scaling to zero replicas", "snapshot", snapshotName)
+
+			return ctrl.Result{RequeueAfter: DefaultRequeueTime}, nil
+		}
+
+		rcp.Status.ScaleToZeroSnapshotName = snapshotFile
+	}
+
+	for _, machine := range controlPlane.Machines.Filter(collections.Not(collections.HasDeletionTimestamp)) {
+		if err := r.Client.Delete(ctx, machine); err != nil && !apierrors.IsNotFound(err) {
+			r.recorder.Eventf(rcp, corev1.EventTypeWarning, "FailedScaleDown",
+				"Failed to delete control plane Machine %s for cluster %s/%s control plane: %v", machine.Name, cluster.Namespace, cluster.Name, err)
+
+			return ctrl.Result{}, errors.Wrapf(err, "failed to delete control plane machine %s", machine.Name)
+		}
+	}
+
+	r.recorder.Eventf(rcp, corev1.EventTypeNormal, "ScaledToZero",
+		"Deleting all the control plane machines, the last etcd snapshot is %q", rcp.Status.ScaleToZeroSnapshotName)
+
+	return ctrl.Result{RequeueAfter: deleteRequeueAfter}, nil
+}
+
 // preflightChecks checks if the control plane is stable before proceeding with a scale up/scale down operation,
 // where stable means that:
 // - There are no machine deletion in progress
 // - All the health conditions on RCP are true.
 // - All the health conditions on the control plane machines are true.
+// - The etcd cluster keeps its quorum and has no raised alarm.
+// Only the deleting machines and the etcd alarms are checked for a single node control plane.
 // If the control plane is not passing preflight checks, it requeue.
 //
 // NOTE: this func uses RCP conditions, it is required to call reconcileControlPlaneConditions before this.
@@ -181,6 +340,8 @@ func (r *RKE2ControlPlaneReconciler) preflightChecks(
 ) ctrl.Result {
 	logger := log.FromContext(ctx)
 
+	defer rke2.RecordPhaseDuration(controlPlane.RCP, controlplanev1.PreflightReconcilePhase, time.Now())
+
 	// If there is no RCP-owned control-plane machines, then control-plane has not been initialized yet,
 	// so it is considered ok to proceed.
 	if controlPlane.Machines.Len() == 0 {
@@ -193,12 +354,45 @@ func (r *RKE2ControlPlaneReconciler) preflightChecks(
 			strings.Join(controlPlane.Machines.Filter(collections.HasDeletionTimestamp).Names(),
 				", ",
 			))
+		controlPlane.Decision.Wait("waiting for machines to be deleted")
 
 		return ctrl.Result{RequeueAfter: deleteRequeueAfter}
 	}
 
+	// A single node control plane has no quorum to protect, and its only machine is replaced even when unhealthy.
+	singleNode := controlPlane.RCP.Spec.IsSingleNode()
+
+	// The etcd cluster is only checked when it could be inspected, the health of its members is checked on the machines.
+	if !singleNode && conditions.GetReason(controlPlane.RCP, controlplanev1.EtcdClusterHealthyCondition) == controlplanev1.EtcdQuorumLostReason {
+		message := conditions.GetMessage(controlPlane.RCP, controlplanev1.EtcdClusterHealthyCondition)
+		r.recorder.Eventf(controlPlane.RCP, corev1.EventTypeWarning, "ControlPlaneUnhealthy",
+			"Waiting for the etcd cluster to be healthy to continue reconciliation: %s", message)
+		logger.Info("Waiting for the etcd cluster to be healthy", "etcd", message)
+		controlPlane.Decision.Wait(message)
+
+		return ctrl.Result{RequeueAfter: preflightRequeueTime(controlPlane.RCP)}
+	}
+
+	// The raised alarms are resolved first, unless they are explicitly ignored.
+	if _, ignored := controlPlane.RCP.Annotations[controlplanev1.IgnoreEtcdAlarmsAnnotation]; !ignored &&
+		conditions.IsFalse(controlPlane.RCP, controlplanev1.EtcdAlarmsClearedCondition) {
+		message := conditions.GetMessage(controlPlane.RCP, controlplanev1.EtcdAlarmsClearedCondition)
+		logger.Info("Waiting for the etcd alarms to be resolved", "alarms", message,
+			"override", controlplanev1.IgnoreEtcdAlarmsAnnotation)
+		controlPlane.Decision.Wait(message)
+
+		return ctrl.Result{RequeueAfter: preflightRequeueTime(controlPlane.RCP)}
+	}
+
 	// Check machine health conditions; if there are conditions with False or Unknown, then wait.
 	allMachineHealthConditions := []clusterv1.ConditionType{controlplanev1.MachineAgentHealthyCondition}
+	if controlPlane.IsEtcdManaged() {
+		allMachineHealthConditions = append(allMachineHealthConditions, controlplanev1.MachineEtcdMemberHealthyCondition)
+	}
+
+	if singleNode {
+		allMachineHealthConditions = nil
+	}
 	machineErrors := []error{}
 
 loopmachines:
@@ -212,6 +406,12 @@ loopmachines:
 		}
 
 		for _, condition := range allMachineHealthConditions {
+			// The machines of the control plane role run no etcd member.
+			if condition == controlplanev1.MachineEtcdMemberHealthyCondition &&
+				rke2.HasRole(controlplanev1.ControlPlaneMachineRole)(machine) {
+				continue
+			}
+
 			if err := preflightCheckCondition("machine", machine, condition); err != nil {
 				machineErrors = append(machineErrors, err)
 			}
@@ -223,8 +423,9 @@ loopmachines:
 		r.recorder.Eventf(controlPlane.RCP, corev1.EventTypeWarning, "ControlPlaneUnhealthy",
 			"Waiting for control plane to pass preflight checks to continue reconciliation: %v", aggregatedError)
 		logger.Info("Waiting for control plane to pass preflight checks", "failures", aggregatedError.Error())
+		controlPlane.Decision.Wait(aggregatedError.Error())
 
-		return ctrl.Result{RequeueAfter: preflightFailedRequeueAfter}
+		return ctrl.Result{RequeueAfter: preflightRequeueTime(controlPlane.RCP)}
 	}
 
 	return ctrl.Result{}
@@ -272,9 +473,13 @@ func (r *RKE2ControlPlaneReconciler) cloneConfigsAndGenerateMachine(
 	ctx context.Context,
 	cluster *clusterv1.Cluster,
 	rcp *controlplanev1.RKE2ControlPlane,
+	role controlplanev1.MachineRole,
 	bootstrapSpec *bootstrapv1.RKE2ConfigSpec,
+	bootstrapAnnotations map[string]string,
 	failureDomain *string,
 ) error {
+	defer rke2.RecordPhaseDuration(rcp, controlplanev1.MachineGenerationReconcilePhase, time.Now())
+
 	var errs []error
 
 	// Since the cloned resource should eventually have a controller ref for the Machine, we create an
@@ -286,29 +491,52 @@ func (r *RKE2ControlPlaneReconciler) cloneConfigsAndGenerateMachine(
 		UID:        rcp.UID,
 	}
 
+	templateNamespace, err := r.infrastructureTemplateNamespace(ctx, rcp)
+	if err != nil {
+		return errors.Wrap(err, "failed to resolve infrastructure template")
+	}
+
+	templateRef := rcp.Spec.InfrastructureRef.DeepCopy()
+	templateRef.Namespace = templateNamespace
+
+	// The name of the machine is generated first, as the hostname of its infrastructure machine may be derived from it.
+	machineName := names.SimpleNameGenerator.GenerateName(rcp.Name + "-")
+
 	// Clone the infrastructure template
-	infraRef, err := external.CreateFromTemplate(ctx, &external.CreateFromTemplateInput{
+	infraRef, err := r.cloneInfrastructureMachine(ctx, &external.CreateFromTemplateInput{
 		Client:      r.Client,
-		TemplateRef: &rcp.Spec.InfrastructureRef,
+		TemplateRef: templateRef,
 		Namespace:   rcp.Namespace,
 		OwnerRef:    infraCloneOwner,
 		ClusterName: cluster.Name,
-		Labels:      rke2.ControlPlaneLabelsForCluster(cluster.Name),
-	})
+		Labels:      rke2.ControlPlaneLabelsForRole(cluster.Name, role),
+		Annotations: rke2.InfrastructureMachineAnnotations(rcp.Spec.InfrastructureMachineAnnotations),
+	}, rcp.Spec.InfrastructureMachineHostname, machineName)
 	if err != nil {
+		switch {
+		case isInfrastructureCapacityError(err):
+			conditions.MarkFalse(rcp, controlplanev1.MachinesCreatedCondition, controlplanev1.WaitingForInfrastructureCapacityReason,
+				clusterv1.ConditionSeverityInfo, err.Error())
+		case isInfrastructureProviderUnavailableError(err):
+			r.markWaitingForInfrastructureProvider(rcp, controlplanev1.MachinesCreatedCondition, err.Error())
+		default:
+			conditions.MarkFalse(rcp, controlplanev1.MachinesCreatedCondition, controlplanev1.InfrastructureTemplateCloningFailedReason,
+				clusterv1.ConditionSeverityError, err.Error())
+		}
+
 		// Safe to return early here since no resources have been created yet.
 		return errors.Wrap(err, "failed to clone infrastructure template")
 	}
 
 	// Clone the bootstrap configuration
-	bootstrapRef, err := r.generateRKE2Config(ctx, rcp, cluster, bootstrapSpec)
+	bootstrapRef, err := r.generateRKE2Config(ctx, rcp, cluster, role, bootstrapSpec, bootstrapAnnotations)
 	if err != nil {
 		errs = append(errs, errors.Wrap(err, "failed to generate bootstrap config"))
 	}
 
 	// Only proceed to generating the Machine if we haven't encountered an error
 	if len(errs) == 0 {
-		if err := r.generateMachine(ctx, rcp, cluster, infraRef, bootstrapRef, failureDomain); err != nil {
+		if err := r.generateMachine(ctx, rcp, cluster, role, machineName, infraRef, bootstrapRef, failureDomain); err != nil {
 			errs = append(errs, errors.Wrap(err, "failed to create Machine"))
 		}
 	}
@@ -319,12 +547,198 @@ func (r *RKE2ControlPlaneReconciler) cloneConfigsAndGenerateMachine(
 			errs = append(errs, errors.Wrap(err, "failed to cleanup generated resources"))
 		}
 
-		return kerrors.NewAggregate(errs)
+		err := kerrors.NewAggregate(errs)
+		conditions.MarkFalse(rcp, controlplanev1.MachinesCreatedCondition, controlplanev1.MachineGenerationFailedReason,
+			clusterv1.ConditionSeverityError, err.Error())
+
+		return err
 	}
 
+	r.markInfrastructureProviderAvailable(rcp, controlplanev1.MachinesCreatedCondition)
+
 	return nil
 }
 
+// cloneInfrastructureMachine clones the infrastructure machine of a new control plane machine from the infrastructure
+// template, with the hostname formatted from the name of the machine when configured. Nothing is cloned from a paused
+// template.
+func (r *RKE2ControlPlaneReconciler) cloneInfrastructureMachine(
+	ctx context.Context,
+	in *external.CreateFromTemplateInput,
+	hostname *controlplanev1.InfrastructureMachineHostname,
+	machineName string,
+) (*corev1.ObjectReference, error) {
+	template, err := external.Get(ctx, in.Client, in.TemplateRef, in.Namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	if annotations.HasPaused(template) {
+		return nil, errors.Wrapf(errInfrastructureTemplatePaused, "%s %s/%s has the %s annotation",
+			template.GetKind(), template.GetNamespace(), template.GetName(), clusterv1.PausedAnnotation)
+	}
+
+	infraMachine, err := external.GenerateTemplate(&external.GenerateTemplateInput{
+		Template:    template,
+		TemplateRef: in.TemplateRef,
+		Namespace:   in.Namespace,
+		ClusterName: in.ClusterName,
+		OwnerRef:    in.OwnerRef,
+		Labels:      in.Labels,
+		Annotations: in.Annotations,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if hostname != nil {
+		if err := rke2.SetInfrastructureMachineHostname(infraMachine, hostname, machineName); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := in.Client.Create(ctx, infraMachine); err != nil {
+		return nil, err
+	}
+
+	return external.GetObjectReference(infraMachine), nil
+}
+
+// isInfrastructureCapacityError returns true if the error is caused by an exhausted quota or capacity, which is
+// expected to be resolved without any change to the RKE2ControlPlane.
+func isInfrastructureCapacityError(err error) bool {
+	if apierrors.IsTooManyRequests(err) {
+		return true
+	}
+
+	if !apierrors.IsForbidden(err) {
+		return false
+	}
+
+	message := strings.ToLower(err.Error())
+
+	for _, pattern := range []string{"exceeded quota", "quota exceeded", "insufficient capacity"} {
+		if strings.Contains(message, pattern) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// waitForInfrastructureCapacity reports a control plane machine that can't be created because of an exhausted quota or
+// capacity, and requeues with a delay growing with the time spent waiting.
+func (r *RKE2ControlPlaneReconciler) waitForInfrastructureCapacity(
+	ctx context.Context,
+	cluster *clusterv1.Cluster,
+	rcp *controlplanev1.RKE2ControlPlane,
+	err error,
+) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	requeueAfter := infrastructureRequeueAfter(rcp, controlplanev1.MachinesCreatedCondition,
+		controlplanev1.WaitingForInfrastructureCapacityReason)
+
+	logger.Info("Waiting for infrastructure capacity to create a control plane Machine", "err", err.Error(), "requeueAfter", requeueAfter)
+	r.recorder.Eventf(
+		rcp,
+		corev1.EventTypeNormal,
+		controlplanev1.WaitingForInfrastructureCapacityReason,
+		"Waiting for infrastructure capacity to create a control plane Machine for cluster %s/%s control plane: %v",
+		cluster.Namespace,
+		cluster.Name,
+		err,
+	)
+
+	return ctrl.Result{RequeueAfter: requeueAfter}, nil
+}
+
+// errInfrastructureTemplatePaused is returned when cloning an infrastructure machine from a paused template.
+var errInfrastructureTemplatePaused = errors.New("infrastructure template is paused")
+
+// isInfrastructureProviderUnavailableError returns true if the error is caused by an infrastructure provider which is
+// not available, e.g. while the management cluster is upgraded, and which is expected to be available again without
+// any change to the RKE2ControlPlane.
+func isInfrastructureProviderUnavailableError(err error) bool {
+	// The template is paused, or the CRDs of the provider are not installed.
+	if errors.Is(err, errInfrastructureTemplatePaused) || meta.IsNoMatchError(err) {
+		return true
+	}
+
+	// The webhooks of a provider which is scaled down can't be called, the API server reports it as an internal error,
+	// the other statuses report a server which is overloaded or not ready yet.
+	return apierrors.IsInternalError(err) || apierrors.IsServiceUnavailable(err) || apierrors.IsServerTimeout(err) ||
+		apierrors.IsTimeout(err) || apierrors.IsTooManyRequests(err)
+}
+
+// waitForInfrastructureProvider reports a control plane waiting for its infrastructure provider to be available, and
+// requeues with a delay growing with the time spent waiting, so that it resumes once the provider is back.
+func (r *RKE2ControlPlaneReconciler) waitForInfrastructureProvider(
+	ctx context.Context,
+	rcp *controlplanev1.RKE2ControlPlane,
+	conditionType clusterv1.ConditionType,
+	message string,
+) (ctrl.Result, error) {
+	requeueAfter := infrastructureRequeueAfter(rcp, conditionType, controlplanev1.WaitingForInfrastructureProviderReason)
+
+	log.FromContext(ctx).Info("Waiting for the infrastructure provider to be available",
+		"reason", message, "requeueAfter", requeueAfter)
+
+	return ctrl.Result{RequeueAfter: requeueAfter}, nil
+}
+
+// markWaitingForInfrastructureProvider reports a control plane waiting for its infrastructure provider on the
+// condition, the event is only recorded when it starts waiting so that an upgrade of the provider doesn't flood them.
+func (r *RKE2ControlPlaneReconciler) markWaitingForInfrastructureProvider(
+	rcp *controlplanev1.RKE2ControlPlane,
+	conditionType clusterv1.ConditionType,
+	message string,
+) {
+	if conditions.GetReason(rcp, conditionType) != controlplanev1.WaitingForInfrastructureProviderReason {
+		r.recorder.Eventf(rcp, corev1.EventTypeNormal, controlplanev1.WaitingForInfrastructureProviderReason,
+			"Waiting for the infrastructure provider to be available: %s", message)
+	}
+
+	conditions.MarkFalse(rcp, conditionType, controlplanev1.WaitingForInfrastructureProviderReason,
+		clusterv1.ConditionSeverityWarning, "%s", message)
+}
+
+// markInfrastructureProviderAvailable marks the condition true, recording an event when the control plane was waiting
+// for its infrastructure provider.
+func (r *RKE2ControlPlaneReconciler) markInfrastructureProviderAvailable(
+	rcp *controlplanev1.RKE2ControlPlane,
+	conditionType clusterv1.ConditionType,
+) {
+	if conditions.GetReason(rcp, conditionType) == controlplanev1.WaitingForInfrastructureProviderReason {
+		r.recorder.Event(rcp, corev1.EventTypeNormal, "InfrastructureProviderAvailable",
+			"The infrastructure provider is available again")
+	}
+
+	conditions.MarkTrue(rcp, conditionType)
+}
+
+// infrastructureRequeueAfter returns how long to wait before trying again to create a control plane machine, growing
+// with the time the condition has had the given reason.
+func infrastructureRequeueAfter(
+	rcp *controlplanev1.RKE2ControlPlane,
+	conditionType clusterv1.ConditionType,
+	reason string,
+) time.Duration {
+	requeueAfter := infrastructureMinRequeueAfter
+
+	if condition := conditions.Get(rcp, conditionType); condition != nil && condition.Reason == reason {
+		if waiting := time.Since(condition.LastTransitionTime.Time); waiting > requeueAfter {
+			requeueAfter = waiting
+		}
+	}
+
+	if requeueAfter > infrastructureMaxRequeueAfter {
+		requeueAfter = infrastructureMaxRequeueAfter
+	}
+
+	return requeueAfter
+}
+
 func (r *RKE2ControlPlaneReconciler) cleanupFromGeneration(ctx context.Context, remoteRefs ...*corev1.ObjectReference) error {
 	var errs []error
 
@@ -349,7 +763,9 @@ func (r *RKE2ControlPlaneReconciler) generateRKE2Config(
 	ctx context.Context,
 	rcp *controlplanev1.RKE2ControlPlane,
 	cluster *clusterv1.Cluster,
+	role controlplanev1.MachineRole,
 	spec *bootstrapv1.RKE2ConfigSpec,
+	annotations map[string]string,
 ) (*corev1.ObjectReference, error) {
 	// Create an owner reference without a controller reference because the owning controller is the machine controller
 	owner := metav1.OwnerReference{
@@ -363,7 +779,8 @@ func (r *RKE2ControlPlaneReconciler) generateRKE2Config(
 		ObjectMeta: metav1.ObjectMeta{
 			Name:            names.SimpleNameGenerator.GenerateName(rcp.Name + "-"),
 			Namespace:       rcp.Namespace,
-			Labels:          rke2.ControlPlaneLabelsForCluster(cluster.Name),
+			Labels:          rke2.ControlPlaneLabelsForRole(cluster.Name, role),
+			Annotations:     annotations,
 			OwnerReferences: []metav1.OwnerReference{owner},
 		},
 		Spec: *spec,
@@ -388,24 +805,26 @@ func (r *RKE2ControlPlaneReconciler) generateMachine(
 	ctx context.Context,
 	rcp *controlplanev1.RKE2ControlPlane,
 	cluster *clusterv1.Cluster,
+	role controlplanev1.MachineRole,
+	name string,
 	infraRef,
 	bootstrapRef *corev1.ObjectReference,
 	failureDomain *string,
 ) error {
-	newVersion, err := bsutil.Rke2ToKubeVersion(rcp.Spec.AgentConfig.Version)
+	newVersion, err := bsutil.Rke2ToKubeVersion(rcp.TargetVersion())
 	if err != nil {
 		return fmt.Errorf("failed to convert rke2 version to kubernetes version: %w", err)
 	}
 
 	logger := log.FromContext(ctx)
 
-	logger.Info("Version checking...", "rke2-version", rcp.Spec.AgentConfig.Version, "machine-version: ", newVersion)
+	logger.Info("Version checking...", "rke2-version", rcp.TargetVersion(), "machine-version: ", newVersion)
 
 	machine := &clusterv1.Machine{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      names.SimpleNameGenerator.GenerateName(rcp.Name + "-"),
+			Name:      name,
 			Namespace: rcp.Namespace,
-			Labels:    rke2.ControlPlaneLabelsForCluster(cluster.Name),
+			Labels:    rke2.ControlPlaneLabelsForRole(cluster.Name, role),
 			OwnerReferences: []metav1.OwnerReference{
 				*metav1.NewControllerRef(rcp, controlplanev1.GroupVersion.WithKind("RKE2ControlPlane")),
 			},
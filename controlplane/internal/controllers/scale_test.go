@@ -0,0 +1,424 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pkg/errors"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util/conditions"
+
+	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+	"github.com/rancher-sandbox/cluster-api-provider-rke2/pkg/rke2"
+)
+
+var _ = Describe("scaling the control plane to zero replicas", func() {
+	var (
+		env            *testEnvironment
+		workloadClient client.Client
+	)
+
+	ctx := context.Background()
+
+	scaleToZero := func() {
+		result, err := env.Reconciler.scaleDownControlPlaneToZero(ctx, env.Cluster, env.RCP, env.controlPlane())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.RequeueAfter).ToNot(BeZero())
+	}
+
+	undeletedMachines := func() []string {
+		machines := &clusterv1.MachineList{}
+		Expect(env.Client.List(ctx, machines)).To(Succeed())
+
+		names := []string{}
+
+		for _, machine := range machines.Items {
+			if machine.DeletionTimestamp.IsZero() {
+				names = append(names, machine.Name)
+			}
+		}
+
+		return names
+	}
+
+	BeforeEach(func() {
+		workloadClient = fake.NewClientBuilder().WithScheme(newTestScheme()).Build()
+		env = newTestEnvironment(0, workloadClient)
+		env.RCP.Annotations = map[string]string{controlplanev1.AllowScaleToZeroAnnotation: ""}
+		env.RCP.Generation = 2
+	})
+
+	It("should not delete the machines while no machine is ready to take the etcd snapshot", func() {
+		env.createMachines(newControlPlaneMachine(env, "machine-1"), newControlPlaneMachine(env, "machine-2"))
+
+		scaleToZero()
+
+		Expect(undeletedMachines()).To(ConsistOf("machine-1", "machine-2"))
+		Expect(env.RCP.Status.ScaleToZeroSnapshotName).To(BeEmpty())
+
+		jobs := &batchv1.JobList{}
+		Expect(workloadClient.List(ctx, jobs)).To(Succeed())
+		Expect(jobs.Items).To(BeEmpty())
+	})
+
+	It("should delete the machines once the etcd snapshot is saved", func() {
+		ready := newControlPlaneMachine(env, "machine-1")
+		conditions.MarkTrue(ready, clusterv1.ReadyCondition)
+		env.createMachines(ready, newControlPlaneMachine(env, "machine-2"))
+
+		scaleToZero()
+		Expect(undeletedMachines()).To(ConsistOf("machine-1", "machine-2"))
+
+		jobs := &batchv1.JobList{}
+		Expect(workloadClient.List(ctx, jobs, client.InNamespace(rke2.HibernationNamespace))).To(Succeed())
+		Expect(jobs.Items).To(HaveLen(1))
+		Expect(jobs.Items[0].Spec.Template.Spec.NodeName).To(Equal("machine-1"))
+
+		completeJob(workloadClient, &jobs.Items[0], "control-plane-scale-to-zero-2-machine-1-1700000000")
+
+		scaleToZero()
+		Expect(undeletedMachines()).To(BeEmpty())
+		Expect(env.RCP.Status.ScaleToZeroSnapshotName).To(Equal("control-plane-scale-to-zero-2-machine-1-1700000000"))
+	})
+})
+
+var _ = Describe("rolling out the control plane", func() {
+	var env *testEnvironment
+
+	ctx := context.Background()
+
+	machines := func() []clusterv1.Machine {
+		machines := &clusterv1.MachineList{}
+		Expect(env.Client.List(ctx, machines)).To(Succeed())
+
+		return machines.Items
+	}
+
+	rollout := func(maxSurge int) {
+		env.RCP.Spec.RolloutStrategy = &controlplanev1.RolloutStrategy{
+			RollingUpdate: &controlplanev1.RollingUpdate{MaxSurge: &intstr.IntOrString{Type: intstr.Int, IntVal: int32(maxSurge)}},
+		}
+
+		controlPlane := env.controlPlane()
+		_, err := env.Reconciler.upgradeControlPlane(ctx, env.Cluster, env.RCP, controlPlane, 3, controlPlane.Machines)
+		Expect(err).ToNot(HaveOccurred())
+	}
+
+	BeforeEach(func() {
+		template := &unstructured.Unstructured{}
+		template.SetAPIVersion("infrastructure.cluster.x-k8s.io/v1beta1")
+		template.SetKind("DockerMachineTemplate")
+		template.SetNamespace(metav1.NamespaceDefault)
+		template.SetName("template")
+		Expect(unstructured.SetNestedMap(template.Object, map[string]interface{}{}, "spec", "template", "spec")).To(Succeed())
+
+		nodes := []client.Object{}
+		for _, name := range []string{"machine-1", "machine-2", "machine-3"} {
+			nodes = append(nodes, &corev1.Node{ObjectMeta: metav1.ObjectMeta{
+				Name:   name,
+				Labels: map[string]string{"node-role.kubernetes.io/master": "true"},
+			}})
+		}
+
+		workloadClient := fake.NewClientBuilder().WithScheme(newTestScheme()).WithObjects(nodes...).Build()
+
+		env = newTestEnvironment(3, workloadClient, template)
+		env.RCP.Spec.InfrastructureRef = corev1.ObjectReference{
+			APIVersion: "infrastructure.cluster.x-k8s.io/v1beta1",
+			Kind:       "DockerMachineTemplate",
+			Name:       "template",
+		}
+		// The datastore is external, no etcd snapshot is taken before the rollout.
+		env.RCP.Spec.ServerConfig.ExternalDatastore = &controlplanev1.ExternalDatastore{Endpoint: "https://etcd.example.com:2379"}
+
+		for _, name := range []string{"machine-1", "machine-2", "machine-3"} {
+			machine := newControlPlaneMachine(env, name)
+			conditions.MarkTrue(machine, controlplanev1.MachineAgentHealthyCondition)
+			env.createMachines(machine)
+		}
+	})
+
+	It("should create a new machine before deleting an outdated one with a surge of 1", func() {
+		rollout(1)
+
+		Expect(machines()).To(HaveLen(4))
+	})
+
+	It("should delete an outdated machine before creating its replacement with a surge of 0", func() {
+		rollout(0)
+
+		Expect(machines()).To(HaveLen(2))
+	})
+
+	Context("with the OnDelete strategy", func() {
+		names := func() []string {
+			names := []string{}
+			for _, machine := range machines() {
+				names = append(names, machine.Name)
+			}
+
+			return names
+		}
+
+		onDelete := func() {
+			env.RCP.Spec.RolloutStrategy = &controlplanev1.RolloutStrategy{Type: controlplanev1.OnDeleteStrategyType}
+
+			controlPlane := env.controlPlane()
+			_, err := env.Reconciler.upgradeControlPlane(ctx, env.Cluster, env.RCP, controlPlane, 3, controlPlane.Machines)
+			Expect(err).ToNot(HaveOccurred())
+		}
+
+		It("should wait for the outdated machines to be deleted", func() {
+			env.RCP.Spec.RolloutStrategy = &controlplanev1.RolloutStrategy{Type: controlplanev1.OnDeleteStrategyType}
+
+			controlPlane := env.controlPlane()
+			started, err := env.Reconciler.startRollout(ctx, env.Cluster, controlPlane, controlPlane.Machines)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(started).To(BeTrue())
+			Expect(conditions.GetReason(env.RCP, controlplanev1.MachinesSpecUpToDateCondition)).
+				To(Equal(controlplanev1.WaitingForOutdatedMachinesDeletionReason))
+
+			onDelete()
+
+			Expect(names()).To(ConsistOf("machine-1", "machine-2", "machine-3"))
+		})
+
+		It("should replace an outdated machine once deleted", func() {
+			deleted := &clusterv1.Machine{}
+			Expect(env.Client.Get(ctx, client.ObjectKey{Namespace: env.Cluster.Namespace, Name: "machine-1"}, deleted)).
+				To(Succeed())
+			Expect(env.Client.Delete(ctx, deleted)).To(Succeed())
+
+			onDelete()
+
+			Expect(names()).To(HaveLen(3))
+			Expect(names()).To(ContainElements("machine-2", "machine-3"))
+			Expect(names()).ToNot(ContainElement("machine-1"))
+		})
+	})
+})
+
+var _ = Describe("waiting for the infrastructure provider", func() {
+	var env *testEnvironment
+
+	ctx := context.Background()
+
+	BeforeEach(func() {
+		env = newTestEnvironment(3, nil)
+	})
+
+	It("should tell the errors of an unavailable infrastructure provider", func() {
+		resource := schema.GroupResource{Group: "infrastructure.cluster.x-k8s.io", Resource: "dockermachines"}
+
+		for _, err := range []error{
+			errors.Wrap(errInfrastructureTemplatePaused, "failed to clone"),
+			&meta.NoKindMatchError{GroupKind: schema.GroupKind{Group: resource.Group, Kind: "DockerMachine"}},
+			apierrors.NewInternalError(errors.New(`failed calling webhook "validation.dockermachine"`)),
+			apierrors.NewServiceUnavailable("the server is currently unable to handle the request"),
+			apierrors.NewServerTimeout(resource, "create", 1),
+			apierrors.NewTooManyRequests("too many requests", 1),
+		} {
+			Expect(isInfrastructureProviderUnavailableError(err)).To(BeTrue(), err.Error())
+		}
+
+		for _, err := range []error{
+			apierrors.NewForbidden(resource, "machine", errors.New("forbidden")),
+			apierrors.NewBadRequest("invalid spec"),
+			apierrors.NewNotFound(resource, "machine"),
+			errors.New("failed to clone"),
+		} {
+			Expect(isInfrastructureProviderUnavailableError(err)).To(BeFalse(), err.Error())
+		}
+	})
+
+	It("should record an event only when it starts waiting", func() {
+		env.Reconciler.markWaitingForInfrastructureProvider(env.RCP, controlplanev1.MachinesCreatedCondition, "provider down")
+		Expect(conditions.GetReason(env.RCP, controlplanev1.MachinesCreatedCondition)).
+			To(Equal(controlplanev1.WaitingForInfrastructureProviderReason))
+		Expect(conditions.GetMessage(env.RCP, controlplanev1.MachinesCreatedCondition)).To(Equal("provider down"))
+		Expect(env.Recorder.Events).To(Receive(ContainSubstring("provider down")))
+
+		env.Reconciler.markWaitingForInfrastructureProvider(env.RCP, controlplanev1.MachinesCreatedCondition, "still down")
+		Expect(conditions.GetMessage(env.RCP, controlplanev1.MachinesCreatedCondition)).To(Equal("still down"))
+		Expect(env.Recorder.Events).ToNot(Receive())
+
+		env.Reconciler.markInfrastructureProviderAvailable(env.RCP, controlplanev1.MachinesCreatedCondition)
+		Expect(conditions.IsTrue(env.RCP, controlplanev1.MachinesCreatedCondition)).To(BeTrue())
+		Expect(env.Recorder.Events).To(Receive(ContainSubstring("available again")))
+	})
+
+	It("should requeue later the longer it waits", func() {
+		result, err := env.Reconciler.waitForInfrastructureProvider(ctx, env.RCP, controlplanev1.MachinesCreatedCondition, "down")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.RequeueAfter).To(Equal(infrastructureMinRequeueAfter))
+
+		waitingSince := func(waiting time.Duration) {
+			for i := range env.RCP.Status.Conditions {
+				if env.RCP.Status.Conditions[i].Type == controlplanev1.MachinesCreatedCondition {
+					env.RCP.Status.Conditions[i].LastTransitionTime = metav1.NewTime(time.Now().Add(-waiting))
+				}
+			}
+		}
+
+		env.Reconciler.markWaitingForInfrastructureProvider(env.RCP, controlplanev1.MachinesCreatedCondition, "down")
+		waitingSince(2 * time.Minute)
+
+		result, err = env.Reconciler.waitForInfrastructureProvider(ctx, env.RCP, controlplanev1.MachinesCreatedCondition, "down")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.RequeueAfter).To(BeNumerically("~", 2*time.Minute, time.Second))
+
+		waitingSince(time.Hour)
+
+		result, err = env.Reconciler.waitForInfrastructureProvider(ctx, env.RCP, controlplanev1.MachinesCreatedCondition, "down")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.RequeueAfter).To(Equal(infrastructureMaxRequeueAfter))
+	})
+})
+
+var _ = Describe("waiting for the infrastructure capacity", func() {
+	var env *testEnvironment
+
+	ctx := context.Background()
+	resource := schema.GroupResource{Group: "infrastructure.cluster.x-k8s.io", Resource: "dockermachines"}
+
+	BeforeEach(func() {
+		env = newTestEnvironment(3, nil)
+	})
+
+	It("should tell the errors of an exhausted quota or capacity", func() {
+		for _, tc := range []struct {
+			name     string
+			err      error
+			capacity bool
+		}{
+			{"too many requests", apierrors.NewTooManyRequests("rate limited", 1), true},
+			{"wrapped too many requests", errors.Wrap(apierrors.NewTooManyRequests("rate limited", 1), "failed to clone"), true},
+			{"exceeded quota", apierrors.NewForbidden(resource, "machine", errors.New("exceeded quota: compute-resources")), true},
+			{"quota exceeded", apierrors.NewForbidden(resource, "machine", errors.New("Quota Exceeded for instances")), true},
+			{"insufficient capacity", apierrors.NewForbidden(resource, "machine", errors.New("Insufficient capacity in zone-a")), true},
+			{"forbidden", apierrors.NewForbidden(resource, "machine", errors.New("user can't create machines")), false},
+			{"quota in a bad request", apierrors.NewBadRequest("exceeded quota: compute-resources"), false},
+			{"quota in a plain error", errors.New("insufficient capacity"), false},
+			{"unavailable service", apierrors.NewServiceUnavailable("exceeded quota"), false},
+		} {
+			Expect(isInfrastructureCapacityError(tc.err)).To(Equal(tc.capacity), tc.name)
+		}
+	})
+
+	It("should wait for capacity when the infrastructure machine can't be created", func() {
+		template := &unstructured.Unstructured{}
+		template.SetAPIVersion("infrastructure.cluster.x-k8s.io/v1beta1")
+		template.SetKind("DockerMachineTemplate")
+		template.SetNamespace(metav1.NamespaceDefault)
+		template.SetName("template")
+		Expect(unstructured.SetNestedMap(template.Object, map[string]interface{}{}, "spec", "template", "spec")).To(Succeed())
+		Expect(env.Client.Create(ctx, template)).To(Succeed())
+
+		env.installProvider(template.GroupVersionKind())
+		env.Reconciler.Client = &failingCreateClient{
+			Client: env.Reconciler.Client,
+			err:    apierrors.NewForbidden(resource, "machine", errors.New("exceeded quota: compute-resources")),
+		}
+		env.RCP.Spec.InfrastructureRef = corev1.ObjectReference{
+			APIVersion: template.GetAPIVersion(),
+			Kind:       template.GetKind(),
+			Name:       template.GetName(),
+		}
+
+		err := env.Reconciler.cloneConfigsAndGenerateMachine(ctx, env.Cluster, env.RCP, env.controlPlane().Role,
+			&env.RCP.Spec.RKE2ConfigSpec, nil, nil)
+		Expect(isInfrastructureCapacityError(err)).To(BeTrue())
+
+		condition := conditions.Get(env.RCP, controlplanev1.MachinesCreatedCondition)
+		Expect(condition).ToNot(BeNil())
+		Expect(condition.Reason).To(Equal(controlplanev1.WaitingForInfrastructureCapacityReason))
+		Expect(condition.Severity).To(Equal(clusterv1.ConditionSeverityInfo))
+
+		result, err := env.Reconciler.waitForInfrastructureCapacity(ctx, env.Cluster, env.RCP, err)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.RequeueAfter).To(Equal(infrastructureMinRequeueAfter))
+		Expect(env.Recorder.Events).To(Receive(And(
+			ContainSubstring(controlplanev1.WaitingForInfrastructureCapacityReason),
+			ContainSubstring("exceeded quota"),
+		)))
+	})
+
+	It("should requeue later the longer it waits", func() {
+		waitingSince := func(reason string, waiting time.Duration) {
+			conditions.MarkFalse(env.RCP, controlplanev1.MachinesCreatedCondition, reason, clusterv1.ConditionSeverityInfo, "")
+
+			for i := range env.RCP.Status.Conditions {
+				if env.RCP.Status.Conditions[i].Type == controlplanev1.MachinesCreatedCondition {
+					env.RCP.Status.Conditions[i].LastTransitionTime = metav1.NewTime(time.Now().Add(-waiting))
+				}
+			}
+		}
+
+		requeueAfter := func() time.Duration {
+			result, err := env.Reconciler.waitForInfrastructureCapacity(ctx, env.Cluster, env.RCP, errors.New("exceeded quota"))
+			Expect(err).ToNot(HaveOccurred())
+
+			return result.RequeueAfter
+		}
+
+		Expect(requeueAfter()).To(Equal(infrastructureMinRequeueAfter))
+
+		waitingSince(controlplanev1.WaitingForInfrastructureCapacityReason, 10*time.Second)
+		Expect(requeueAfter()).To(Equal(infrastructureMinRequeueAfter))
+
+		waitingSince(controlplanev1.WaitingForInfrastructureCapacityReason, 2*time.Minute)
+		Expect(requeueAfter()).To(BeNumerically("~", 2*time.Minute, time.Second))
+
+		waitingSince(controlplanev1.WaitingForInfrastructureCapacityReason, time.Hour)
+		Expect(requeueAfter()).To(Equal(infrastructureMaxRequeueAfter))
+
+		// The time spent waiting for another reason doesn't count.
+		waitingSince(controlplanev1.WaitingForInfrastructureProviderReason, time.Hour)
+		Expect(requeueAfter()).To(Equal(infrastructureMinRequeueAfter))
+	})
+})
+
+// failingCreateClient is a client failing to create the infrastructure machines.
+type failingCreateClient struct {
+	client.Client
+
+	err error
+}
+
+func (c *failingCreateClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	if _, ok := obj.(*unstructured.Unstructured); ok {
+		return c.err
+	}
+
+	return c.Client.Create(ctx, obj, opts...)
+}
@@ -0,0 +1,236 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util"
+	"sigs.k8s.io/cluster-api/util/collections"
+	"sigs.k8s.io/cluster-api/util/conditions"
+
+	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+	"github.com/rancher-sandbox/cluster-api-provider-rke2/pkg/rke2"
+)
+
+// secretsEncryptionRequeueAfter is how long to wait before checking again the progress of the rotation of the secrets
+// encryption key.
+const secretsEncryptionRequeueAfter = 15 * time.Second
+
+// reconcileSecretsEncryptionKeyRotation rotates the secrets encryption key when requested with the
+// rotate-secrets-encryption-key annotation: the "rke2 secrets-encrypt" prepare, rotate and reencrypt commands are run
+// on a server, each followed by a restart of rke2-server on all the servers, one at a time and once the previous one
+// is healthy again. The progress is recorded in the status, so the rotation carries on where it stopped.
+// A non zero result is returned while the key is rotated, as the control plane must not change meanwhile.
+func (r *RKE2ControlPlaneReconciler) reconcileSecretsEncryptionKeyRotation(
+	ctx context.Context,
+	controlPlane *rke2.ControlPlane,
+) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+	rcp := controlPlane.RCP
+
+	rotationID := rcp.Annotations[controlplanev1.RotateSecretsEncryptionKeyAnnotation]
+	rotation := rcp.Status.SecretsEncryptionKeyRotation
+
+	if rotationID == "" {
+		return ctrl.Result{}, nil
+	}
+
+	if rotation == nil || rotation.ID != rotationID {
+		return r.startSecretsEncryptionKeyRotation(ctx, controlPlane, rotationID)
+	}
+
+	if rotation.Stage == controlplanev1.CompletedSecretsEncryptionKeyRotationStage ||
+		conditions.GetReason(rcp, controlplanev1.SecretsEncryptionKeyRotatedCondition) ==
+			controlplanev1.SecretsEncryptionKeyRotationFailedReason {
+		return ctrl.Result{}, nil
+	}
+
+	logger = logger.WithValues("rotation", rotation.ID, "stage", rotation.Stage)
+
+	workloadCluster, err := r.managementCluster.GetWorkloadCluster(ctx, util.ObjectKey(controlPlane.Cluster))
+	if err != nil {
+		return ctrl.Result{}, errors.Wrap(err, "cannot get remote client to workload cluster")
+	}
+
+	step := fmt.Sprintf("%s/%s", rotation.ID, rotation.Stage)
+
+	if command := rke2.SecretsEncryptionCommand(rotation.Stage); command != "" {
+		machine, ok := controlPlane.Machines[rotation.MachineName]
+		if !ok || machine.Status.NodeRef == nil {
+			r.failSecretsEncryptionKeyRotation(rcp, fmt.Errorf("machine %s has no node", rotation.MachineName))
+
+			return ctrl.Result{}, nil
+		}
+
+		done, err := workloadCluster.SecretsEncrypt(ctx, machine.Status.NodeRef.Name, command, step)
+		if err != nil {
+			r.failSecretsEncryptionKeyRotation(rcp, err)
+
+			return ctrl.Result{}, nil
+		}
+
+		if !done {
+			logger.Info("Waiting for the secrets encryption command to complete", "machine", machine.Name)
+
+			return ctrl.Result{RequeueAfter: secretsEncryptionRequeueAfter}, nil
+		}
+
+		r.advanceSecretsEncryptionKeyRotation(rcp)
+
+		return ctrl.Result{Requeue: true}, nil
+	}
+
+	restarted := sets.NewString(rotation.RestartedMachines...)
+
+	for _, machine := range rke2.SecretsEncryptionRestartOrder(controlPlane.Machines, rotation.MachineName) {
+		if restarted.Has(machine.Name) {
+			continue
+		}
+
+		if machine.Status.NodeRef == nil {
+			logger.Info("Waiting for the control plane machine to have a node", "machine", machine.Name)
+
+			return ctrl.Result{RequeueAfter: secretsEncryptionRequeueAfter}, nil
+		}
+
+		done, err := workloadCluster.RestartServer(ctx, machine.Status.NodeRef.Name, step+"/"+machine.Name)
+		if err != nil {
+			r.failSecretsEncryptionKeyRotation(rcp, err)
+
+			return ctrl.Result{}, nil
+		}
+
+		if !done || !healthyMember(controlPlane, machine) {
+			logger.Info("Waiting for rke2-server to restart", "machine", machine.Name)
+
+			return ctrl.Result{RequeueAfter: secretsEncryptionRequeueAfter}, nil
+		}
+
+		logger.Info("Restarted rke2-server", "machine", machine.Name)
+		rotation.RestartedMachines = append(rotation.RestartedMachines, machine.Name)
+
+		return ctrl.Result{Requeue: true}, nil
+	}
+
+	if rke2.NextSecretsEncryptionKeyRotationStage(rotation.Stage) == controlplanev1.CompletedSecretsEncryptionKeyRotationStage {
+		if err := workloadCluster.DeleteSecretsEncryptionJobs(ctx); err != nil {
+			return ctrl.Result{}, err
+		}
+
+		now := metav1.Now()
+		rotation.CompletionTime = &now
+
+		logger.Info("Rotated the secrets encryption key")
+		r.recorder.Eventf(rcp, corev1.EventTypeNormal, "SecretsEncryptionKeyRotated", "Rotated the secrets encryption key")
+		conditions.MarkTrue(rcp, controlplanev1.SecretsEncryptionKeyRotatedCondition)
+	}
+
+	r.advanceSecretsEncryptionKeyRotation(rcp)
+
+	return ctrl.Result{Requeue: true}, nil
+}
+
+// startSecretsEncryptionKeyRotation starts the rotation of the secrets encryption key once all the machines are
+// healthy and none is deleted or rebooted, the commands are run on the oldest machine running an API server.
+func (r *RKE2ControlPlaneReconciler) startSecretsEncryptionKeyRotation(
+	ctx context.Context,
+	controlPlane *rke2.ControlPlane,
+	rotationID string,
+) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+	rcp := controlPlane.RCP
+
+	if !controlPlane.RCP.Status.Initialized {
+		logger.Info("Not rotating the secrets encryption key until the control plane is initialized")
+
+		return ctrl.Result{}, nil
+	}
+
+	for _, machine := range controlPlane.Machines {
+		_, rebooting := machine.Annotations[controlplanev1.RebootApprovedAnnotation]
+
+		if !machine.DeletionTimestamp.IsZero() || rebooting || !healthyMember(controlPlane, machine) {
+			logger.Info("Not rotating the secrets encryption key while a control plane machine is unhealthy",
+				"machine", machine.Name)
+
+			return ctrl.Result{}, nil
+		}
+	}
+
+	machine := controlPlane.Machines.Filter(
+		collections.Not(rke2.HasRole(controlplanev1.EtcdMachineRole)),
+		func(machine *clusterv1.Machine) bool { return machine.Status.NodeRef != nil },
+	).Oldest()
+	if machine == nil {
+		logger.Info("Not rotating the secrets encryption key until a control plane machine has a node")
+
+		return ctrl.Result{}, nil
+	}
+
+	now := metav1.Now()
+	rcp.Status.SecretsEncryptionKeyRotation = &controlplanev1.SecretsEncryptionKeyRotation{
+		ID:          rotationID,
+		Stage:       controlplanev1.PrepareSecretsEncryptionKeyRotationStage,
+		MachineName: machine.Name,
+		StartTime:   &now,
+	}
+
+	logger.Info("Rotating the secrets encryption key", "rotation", rotationID, "machine", machine.Name)
+	r.recorder.Eventf(rcp, corev1.EventTypeNormal, "SecretsEncryptionKeyRotationStarted",
+		"Rotating the secrets encryption key from machine %s", machine.Name)
+	conditions.MarkFalse(rcp, controlplanev1.SecretsEncryptionKeyRotatedCondition,
+		controlplanev1.RotatingSecretsEncryptionKeyReason, clusterv1.ConditionSeverityInfo,
+		"Rotating the secrets encryption key, stage %s", controlplanev1.PrepareSecretsEncryptionKeyRotationStage)
+
+	return ctrl.Result{Requeue: true}, nil
+}
+
+// advanceSecretsEncryptionKeyRotation moves the rotation of the secrets encryption key to its next stage.
+func (r *RKE2ControlPlaneReconciler) advanceSecretsEncryptionKeyRotation(rcp *controlplanev1.RKE2ControlPlane) {
+	rotation := rcp.Status.SecretsEncryptionKeyRotation
+	rotation.Stage = rke2.NextSecretsEncryptionKeyRotationStage(rotation.Stage)
+	rotation.RestartedMachines = nil
+
+	if rotation.Stage != controlplanev1.CompletedSecretsEncryptionKeyRotationStage {
+		conditions.MarkFalse(rcp, controlplanev1.SecretsEncryptionKeyRotatedCondition,
+			controlplanev1.RotatingSecretsEncryptionKeyReason, clusterv1.ConditionSeverityInfo,
+			"Rotating the secrets encryption key, stage %s", rotation.Stage)
+	}
+}
+
+// failSecretsEncryptionKeyRotation stops the rotation of the secrets encryption key at its current stage, the servers
+// may need to be fixed by hand before another rotation is requested.
+func (r *RKE2ControlPlaneReconciler) failSecretsEncryptionKeyRotation(rcp *controlplanev1.RKE2ControlPlane, err error) {
+	stage := rcp.Status.SecretsEncryptionKeyRotation.Stage
+
+	r.recorder.Eventf(rcp, corev1.EventTypeWarning, "SecretsEncryptionKeyRotationFailed",
+		"Failed to rotate the secrets encryption key at stage %s: %v", stage, err)
+	conditions.MarkFalse(rcp, controlplanev1.SecretsEncryptionKeyRotatedCondition,
+		controlplanev1.SecretsEncryptionKeyRotationFailedReason, clusterv1.ConditionSeverityError,
+		"Failed to rotate the secrets encryption key at stage %s: %v", stage, err)
+}
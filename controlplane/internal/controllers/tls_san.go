@@ -0,0 +1,56 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util/conditions"
+
+	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+	"github.com/rancher-sandbox/cluster-api-provider-rke2/pkg/rke2"
+)
+
+// reconcileTLSSan warns in the TLSSanCovered condition about the registration addresses that aren't subject
+// alternative names of the server certificate, as the machines registering with them would fail to join.
+func (r *RKE2ControlPlaneReconciler) reconcileTLSSan(
+	ctx context.Context,
+	cluster *clusterv1.Cluster,
+	rcp *controlplanev1.RKE2ControlPlane,
+) {
+	uncovered := rke2.UncoveredRegistrationAddresses(rcp, cluster.Spec.ControlPlaneEndpoint.Host)
+	if len(uncovered) == 0 {
+		conditions.MarkTrue(rcp, controlplanev1.TLSSanCoveredCondition)
+
+		return
+	}
+
+	if conditions.GetReason(rcp, controlplanev1.TLSSanCoveredCondition) != controlplanev1.RegistrationAddressNotCoveredReason {
+		log.FromContext(ctx).Info("Registration addresses are not covered by the server certificate", "addresses", uncovered)
+		r.recorder.Eventf(rcp, corev1.EventTypeWarning, controlplanev1.RegistrationAddressNotCoveredReason,
+			"Registration addresses %s are not covered by the server certificate", strings.Join(uncovered, ", "))
+	}
+
+	conditions.MarkFalse(rcp, controlplanev1.TLSSanCoveredCondition, controlplanev1.RegistrationAddressNotCoveredReason,
+		clusterv1.ConditionSeverityWarning,
+		"Registration addresses %s must be added to serverConfig.tlsSan for the machines to join", strings.Join(uncovered, ", "))
+}
@@ -17,10 +17,12 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
@@ -29,6 +31,7 @@ import (
 
 	"github.com/spf13/pflag"
 	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
@@ -36,13 +39,19 @@ import (
 	"k8s.io/klog/v2/klogr"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/cluster"
 
 	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
 
 	bootstrapv1 "github.com/rancher-sandbox/cluster-api-provider-rke2/bootstrap/api/v1alpha1"
 	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
 	"github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/internal/controllers"
+	"github.com/rancher-sandbox/cluster-api-provider-rke2/pkg/certrotation"
+	"github.com/rancher-sandbox/cluster-api-provider-rke2/pkg/channel"
+	"github.com/rancher-sandbox/cluster-api-provider-rke2/pkg/compatibility"
 	"github.com/rancher-sandbox/cluster-api-provider-rke2/pkg/consts"
+	"github.com/rancher-sandbox/cluster-api-provider-rke2/pkg/observer"
+	"github.com/rancher-sandbox/cluster-api-provider-rke2/pkg/rke2"
 )
 
 var (
@@ -52,6 +61,7 @@ var (
 	// flags.
 	metricsBindAddr             string
 	enableLeaderElection        bool
+	observerMode                bool
 	leaderElectionLeaseDuration time.Duration
 	leaderElectionRenewDeadline time.Duration
 	leaderElectionRetryPeriod   time.Duration
@@ -61,11 +71,28 @@ var (
 	syncPeriod                  time.Duration
 	webhookPort                 int
 	webhookCertDir              string
+	webhookCertRotation         bool
+	webhookServiceName          string
+	webhookCertSecretName       string
 	healthAddr                  string
+
+	allowedInfrastructureTemplateNamespaces []string
+
+	workloadClientOptions rke2.WorkloadClientOptions
+
+	compatibilityMatrixConfigMap string
+	compatibilitySource          = &compatibility.Source{}
+
+	versionDefaults controlplanev1.VersionDefaults
+	channelResolver channel.Resolver
+
+	clusterEvents      bool
+	reconcileDecisions bool
 )
 
 func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(apiextensionsv1.AddToScheme(scheme))
 
 	utilruntime.Must(clusterv1.AddToScheme(scheme))
 	utilruntime.Must(controlplanev1.AddToScheme(scheme))
@@ -107,8 +134,60 @@ func InitFlags(fs *pflag.FlagSet) {
 	fs.StringVar(&webhookCertDir, "webhook-cert-dir", "/tmp/k8s-webhook-server/serving-certs/",
 		"Webhook cert dir, only used when webhook-port is specified.")
 
+	fs.BoolVar(&webhookCertRotation, "webhook-cert-rotation", false,
+		"Generate and renew the webhook serving certificate and its CA, injecting the CA in the webhook configurations and the conversion webhooks of the CRDs, instead of using the certificate issued by cert-manager. The webhook cert dir must be writable and the POD_NAMESPACE environment variable set.") //nolint:lll
+
+	fs.StringVar(&webhookServiceName, "webhook-service-name", "rke2-control-plane-webhook-service",
+		"The service of the webhook server, in the namespace of the pod, only used with --webhook-cert-rotation.")
+
+	fs.StringVar(&webhookCertSecretName, "webhook-cert-secret-name", "rke2-control-plane-webhook-rotated-cert",
+		"The secret storing the webhook serving certificate and its CA, in the namespace of the pod, only used with --webhook-cert-rotation.")
+
 	fs.StringVar(&healthAddr, "health-addr", ":9440",
 		"The address the health endpoint binds to.")
+
+	fs.StringSliceVar(&allowedInfrastructureTemplateNamespaces, "allowed-infrastructure-template-namespaces", []string{},
+		"Namespaces RKE2ControlPlane objects can reference infrastructure templates from, in addition to their own namespace. The service accounts of the RKE2ControlPlane namespace must also be allowed to get the template.") //nolint:lll
+
+	fs.DurationVar(&workloadClientOptions.Timeout, "workload-cluster-timeout", rke2.DefaultWorkloadTimeout,
+		"The timeout of the requests to the workload clusters (duration string)")
+
+	fs.DurationVar(&workloadClientOptions.DialTimeout, "workload-cluster-dial-timeout", rke2.DefaultWorkloadDialTimeout,
+		"The timeout for establishing a connection to a workload cluster (duration string)")
+
+	fs.Float32Var(&workloadClientOptions.QPS, "workload-cluster-qps", 0,
+		"The maximum queries per second to a workload cluster. If unspecified, the client-go default is used.")
+
+	fs.IntVar(&workloadClientOptions.Burst, "workload-cluster-burst", 0,
+		"The maximum burst of queries to a workload cluster. If unspecified, the client-go default is used.")
+
+	fs.DurationVar(&workloadClientOptions.HealthCheckInterval, "workload-cluster-health-check-interval", rke2.DefaultWorkloadHealthCheckInterval,
+		"The interval between two health checks of a workload cluster. An unhealthy workload cluster isn't contacted again until the next health check (duration string)") //nolint:lll
+
+	fs.DurationVar(&workloadClientOptions.HealthCheckTimeout, "workload-cluster-health-check-timeout", rke2.DefaultWorkloadHealthCheckTimeout,
+		"The timeout for the health check of a workload cluster (duration string)")
+
+	fs.StringVar(&workloadClientOptions.HostJobImage, "host-job-image", rke2.DefaultHostJobImage,
+		"The image of the jobs running commands on the hosts of the workload cluster nodes, such as etcd snapshots, it needs nsenter.")
+
+	fs.StringVar(&compatibilityMatrixConfigMap, "compatibility-matrix-configmap", "",
+		"The ConfigMap (namespace/name) overriding the compatibility matrix of the supported RKE2 versions, from its matrix.yaml key. If unspecified or missing, the embedded matrix is used.") //nolint:lll
+
+	fs.StringVar(&versionDefaults.Version, "default-rke2-version", "",
+		"The RKE2 version set on the RKE2ControlPlane objects created without one (e.g. v1.26.4+rke2r1). If unspecified, the latest release of --default-rke2-channel is set.") //nolint:lll
+
+	fs.StringVar(&versionDefaults.Channel, "default-rke2-channel", "",
+		"The RKE2 release channel (e.g. stable, latest or v1.26) whose latest release is set on the RKE2ControlPlane objects created without a version, when --default-rke2-version is unspecified. The channel is resolved with --rke2-channel-server, which the controller must reach. If unspecified, the version isn't defaulted.") //nolint:lll
+
+	fs.StringVar(&channelResolver.Server, "rke2-channel-server", channel.DefaultServer,
+		"The server resolving the RKE2 release channels to their latest release.")
+
+	fs.BoolVar(&clusterEvents, "cluster-events", false,
+		"Record the lifecycle events of the control planes (e.g. Initialized, UpgradeStarted, MachineReplaced) on their Cluster as well.") //nolint:lll
+	fs.BoolVar(&reconcileDecisions, "reconcile-decisions", false,
+		"Write the next action of the controller on each control plane (desired replicas, outdated machines, next action) to the <cluster>-control-plane-decision ConfigMap after each reconciliation.") //nolint:lll
+	fs.BoolVar(&observerMode, "observer", false,
+		"Run in read-only observer mode, for audit environments: the changes the controllers would make are logged instead of being made. Leader election is disabled.") //nolint:lll
 }
 
 func main() {
@@ -127,10 +206,18 @@ func main() {
 		}()
 	}
 
+	newClient := cluster.DefaultNewClient
+	if observerMode {
+		workloadClientOptions.Observer = true
+		setupLog.Info("Running in observer mode, the changes are logged instead of being made")
+
+		newClient = observer.NewClientFunc()
+	}
+
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
 		Scheme:             scheme,
 		MetricsBindAddress: metricsBindAddr,
-		LeaderElection:     enableLeaderElection,
+		LeaderElection:     enableLeaderElection && !observerMode,
 		LeaderElectionID:   "rke2-controlplane-manager-leader-election-capi",
 		LeaseDuration:      &leaderElectionLeaseDuration,
 		RenewDeadline:      &leaderElectionRenewDeadline,
@@ -143,13 +230,27 @@ func main() {
 		Port:                   webhookPort,
 		CertDir:                webhookCertDir,
 		HealthProbeBindAddress: healthAddr,
+		NewClient:              newClient,
 	})
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
 		os.Exit(1)
 	}
 
+	// The ConfigMap is read without a cache, so that the ConfigMaps aren't watched for a single one.
+	if compatibilityMatrixConfigMap != "" {
+		namespace, name, found := strings.Cut(compatibilityMatrixConfigMap, "/")
+		if !found {
+			setupLog.Error(nil, "--compatibility-matrix-configmap must be in the namespace/name format")
+			os.Exit(1)
+		}
+
+		compatibilitySource.Reader = mgr.GetAPIReader()
+		compatibilitySource.ConfigMap = client.ObjectKey{Namespace: namespace, Name: name}
+	}
+
 	setupChecks(mgr)
+	setupCertRotation(mgr)
 	setupReconcilers(mgr)
 	setupWebhooks(mgr)
 	//+kubebuilder:scaffold:builder
@@ -182,18 +283,76 @@ func setupChecks(mgr ctrl.Manager) {
 	}
 }
 
+func setupCertRotation(mgr ctrl.Manager) {
+	if !webhookCertRotation {
+		return
+	}
+
+	namespace := os.Getenv("POD_NAMESPACE")
+	if namespace == "" {
+		setupLog.Error(nil, "--webhook-cert-rotation requires the POD_NAMESPACE environment variable")
+		os.Exit(1)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	service := client.ObjectKey{Namespace: namespace, Name: webhookServiceName}
+	if err := certrotation.Setup(ctx, mgr, service, webhookCertSecretName, webhookCertDir); err != nil {
+		setupLog.Error(err, "unable to set up the webhook certificate rotation")
+		os.Exit(1)
+	}
+}
+
 func setupReconcilers(mgr ctrl.Manager) {
 	if err := (&controllers.RKE2ControlPlaneReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:                                  mgr.GetClient(),
+		Scheme:                                  mgr.GetScheme(),
+		AllowedInfrastructureTemplateNamespaces: allowedInfrastructureTemplateNamespaces,
+		ControllerPod:                           rke2.ControllerPodFromEnv(),
+		WorkloadClientOptions:                   workloadClientOptions,
+		ClusterEvents:                           clusterEvents,
+		ReconcileDecisions:                      reconcileDecisions,
+		CompatibilitySource:                     compatibilitySource,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "RKE2ControlPlane")
 		os.Exit(1)
 	}
+
+	if err := (&controllers.RKE2AddOnReconciler{
+		Client:                mgr.GetClient(),
+		WorkloadClientOptions: workloadClientOptions,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "RKE2AddOn")
+		os.Exit(1)
+	}
+
+	if err := (&controllers.RKE2UpgradeGroupReconciler{
+		Client: mgr.GetClient(),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "RKE2UpgradeGroup")
+		os.Exit(1)
+	}
+
+	if err := (&controllers.RKE2EtcdSnapshotRestoreReconciler{
+		Client:                mgr.GetClient(),
+		WorkloadClientOptions: workloadClientOptions,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "RKE2EtcdSnapshotRestore")
+		os.Exit(1)
+	}
 }
 
 func setupWebhooks(mgr ctrl.Manager) {
-	if err := (&controlplanev1.RKE2ControlPlane{}).SetupWebhookWithManager(mgr); err != nil {
+	versionDefaults.ResolveChannel = channelResolver.Resolve
+
+	// The Cluster of a created control plane may not be in the cache yet.
+	resolveClusterDomain := func(ctx context.Context, rcp *controlplanev1.RKE2ControlPlane) (string, error) {
+		return rke2.ClusterDNSDomain(ctx, mgr.GetAPIReader(), rcp)
+	}
+
+	if err := (&controlplanev1.RKE2ControlPlane{}).SetupWebhookWithManager(
+		mgr, versionDefaults, resolveClusterDomain, compatibilitySource); err != nil {
 		setupLog.Error(err, "unable to create webhook", "webhook", "RKE2ControlPlane")
 		os.Exit(1)
 	}
@@ -202,4 +361,19 @@ func setupWebhooks(mgr ctrl.Manager) {
 		setupLog.Error(err, "unable to create webhook", "webhook", "RKE2ControlPlaneTemplate")
 		os.Exit(1)
 	}
+
+	if err := (&controlplanev1.RKE2AddOn{}).SetupWebhookWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create webhook", "webhook", "RKE2AddOn")
+		os.Exit(1)
+	}
+
+	if err := (&controlplanev1.RKE2UpgradeGroup{}).SetupWebhookWithManager(mgr, compatibilitySource); err != nil {
+		setupLog.Error(err, "unable to create webhook", "webhook", "RKE2UpgradeGroup")
+		os.Exit(1)
+	}
+
+	if err := (&controlplanev1.RKE2EtcdSnapshotRestore{}).SetupWebhookWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create webhook", "webhook", "RKE2EtcdSnapshotRestore")
+		os.Exit(1)
+	}
 }
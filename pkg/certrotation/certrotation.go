@@ -0,0 +1,528 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package certrotation keeps the serving certificate of the webhook server of a provider valid, without cert-manager:
+// it generates a self-signed certificate authority and the serving certificate, stores them in a secret, writes them
+// to the certificate directory of the webhook server and injects the certificate authority in the webhook
+// configurations and the conversion webhooks of the CRDs, renewing them before they expire.
+package certrotation
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"sigs.k8s.io/cluster-api/util/certs"
+)
+
+const (
+	// CAKeyKey is the key of the secret holding the private key of the certificate authority, the certificate
+	// authority is held under the ca.crt key and the serving certificate under the tls.crt and tls.key keys.
+	CAKeyKey = "ca.key"
+
+	// PreviousCACertKey is the key of the secret holding the previous certificate authority after it was renewed, it is
+	// injected along with the current one until it expires, so that the serving certificate signed by either is trusted.
+	PreviousCACertKey = "previous-ca.crt"
+
+	// DefaultCAValidity is the validity of the certificate authority.
+	DefaultCAValidity = 10 * 365 * 24 * time.Hour
+
+	// DefaultCertValidity is the validity of the serving certificate.
+	DefaultCertValidity = 365 * 24 * time.Hour
+
+	// DefaultRenewBefore is the remaining validity under which the certificate authority and the serving certificate
+	// are renewed.
+	DefaultRenewBefore = 30 * 24 * time.Hour
+
+	// DefaultCheckInterval is the interval between two checks of the certificates.
+	DefaultCheckInterval = time.Hour
+)
+
+var certificateExpiration = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "rke2_provider_webhook_certificate_expiration_timestamp_seconds",
+	Help: "Expiration time of the serving certificate of the webhook server, as a Unix timestamp, when it is rotated by the provider.",
+})
+
+func init() {
+	metrics.Registry.MustRegister(certificateExpiration)
+}
+
+// Rotator keeps the serving certificate of the webhook server valid. It runs in every replica of the manager, as each
+// replica serves the webhooks with the certificate of its own certificate directory, and it must be reconciled once
+// before the manager starts, as the webhook server doesn't start without a certificate.
+type Rotator struct {
+	// Client writes the secret, the webhook configurations and the CRDs.
+	Client client.Client
+
+	// Reader reads the secret, the webhook configurations and the CRDs, it must not be cached as the manager doesn't
+	// watch them.
+	Reader client.Reader
+
+	// SecretKey is the secret holding the certificate authority and the serving certificate.
+	SecretKey client.ObjectKey
+
+	// Service is the service of the webhook server, the serving certificate is issued for its DNS names and the
+	// certificate authority is injected in the webhooks calling it.
+	Service client.ObjectKey
+
+	// CertDir is the certificate directory of the webhook server, it must be writable.
+	CertDir string
+
+	// CheckInterval is the interval between two checks of the certificates (default: DefaultCheckInterval).
+	CheckInterval time.Duration
+
+	mu       sync.RWMutex
+	notAfter time.Time
+}
+
+// Setup reconciles the certificates of the webhook server of the manager, then adds the rotator to the manager along
+// with a health check of the serving certificate. The certificate directory of the webhook server must be writable.
+func Setup(ctx context.Context, mgr ctrl.Manager, service client.ObjectKey, secretName, certDir string) error {
+	rotator := &Rotator{
+		Client:    mgr.GetClient(),
+		Reader:    mgr.GetAPIReader(),
+		SecretKey: client.ObjectKey{Namespace: service.Namespace, Name: secretName},
+		Service:   service,
+		CertDir:   certDir,
+	}
+
+	if err := rotator.Reconcile(ctx); err != nil {
+		return err
+	}
+
+	if err := mgr.Add(rotator); err != nil {
+		return errors.Wrap(err, "failed to add the webhook certificate rotator")
+	}
+
+	return errors.Wrap(mgr.AddHealthzCheck("webhook-certificate", rotator.Checker),
+		"failed to add the webhook certificate health check")
+}
+
+// DNSNames returns the DNS names of the service of the webhook server, which the serving certificate is issued for.
+func DNSNames(service client.ObjectKey) []string {
+	return []string{
+		fmt.Sprintf("%s.%s.svc", service.Name, service.Namespace),
+		fmt.Sprintf("%s.%s.svc.cluster.local", service.Name, service.Namespace),
+	}
+}
+
+// Start checks the certificates periodically until the context is done. A failure is only logged, the certificates
+// are checked again on the next interval, long before they expire.
+func (r *Rotator) Start(ctx context.Context) error {
+	logger := log.FromContext(ctx).WithName("certrotation")
+
+	interval := r.CheckInterval
+	if interval == 0 {
+		interval = DefaultCheckInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := r.Reconcile(ctx); err != nil {
+				logger.Error(err, "Failed to rotate the webhook certificates")
+			}
+		}
+	}
+}
+
+// NeedLeaderElection returns false, the certificates are written to the certificate directory of every replica.
+func (r *Rotator) NeedLeaderElection() bool {
+	return false
+}
+
+// Checker is a health check failing when the serving certificate is missing or expired.
+func (r *Rotator) Checker(_ *http.Request) error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.notAfter.IsZero() {
+		return errors.New("the webhook serving certificate is not generated yet")
+	}
+
+	if time.Now().After(r.notAfter) {
+		return errors.Errorf("the webhook serving certificate expired at %s", r.notAfter.Format(time.RFC3339))
+	}
+
+	return nil
+}
+
+// Reconcile renews the certificates stored in the secret when needed, injects the certificate authority in the
+// webhooks calling the service, then writes the serving certificate to the certificate directory. The certificate
+// authority is injected first, so that a serving certificate signed by a renewed certificate authority is trusted.
+func (r *Rotator) Reconcile(ctx context.Context) error {
+	logger := log.FromContext(ctx).WithName("certrotation")
+
+	secret := &corev1.Secret{}
+
+	err := r.Reader.Get(ctx, r.SecretKey, secret)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return errors.Wrapf(err, "failed to get secret %s", r.SecretKey)
+	}
+
+	exists := err == nil
+
+	data, renewed, err := Renew(secret.Data, DNSNames(r.Service), time.Now())
+	if err != nil {
+		return err
+	}
+
+	if renewed {
+		logger.Info("Renewing the webhook certificates", "secret", r.SecretKey.String())
+
+		secret.Data = data
+		if exists {
+			err = r.Client.Update(ctx, secret)
+		} else {
+			secret.ObjectMeta = metav1.ObjectMeta{Namespace: r.SecretKey.Namespace, Name: r.SecretKey.Name}
+			secret.Type = corev1.SecretTypeTLS
+			err = r.Client.Create(ctx, secret)
+		}
+
+		// Another replica renewed the certificates meanwhile, they are read again on the next check.
+		if err != nil {
+			return errors.Wrapf(err, "failed to save secret %s", r.SecretKey)
+		}
+	}
+
+	if err := r.injectCABundle(ctx, CABundle(data, time.Now())); err != nil {
+		return err
+	}
+
+	if err := writeFileIfChanged(filepath.Join(r.CertDir, corev1.TLSCertKey), data[corev1.TLSCertKey], 0o644); err != nil {
+		return err
+	}
+
+	if err := writeFileIfChanged(filepath.Join(r.CertDir, corev1.TLSPrivateKeyKey), data[corev1.TLSPrivateKeyKey], 0o600); err != nil {
+		return err
+	}
+
+	cert, err := certs.DecodeCertPEM(data[corev1.TLSCertKey])
+	if err != nil {
+		return errors.Wrap(err, "failed to decode the serving certificate")
+	}
+
+	r.mu.Lock()
+	r.notAfter = cert.NotAfter
+	r.mu.Unlock()
+
+	certificateExpiration.Set(float64(cert.NotAfter.Unix()))
+
+	return nil
+}
+
+// Renew returns the data of the secret holding the certificate authority and the serving certificate, and whether
+// they were renewed. The certificate authority is renewed when missing or about to expire, the previous one being
+// kept until it expires. The serving certificate is renewed when missing, about to expire, not issued for the DNS
+// names or not signed by the certificate authority.
+func Renew(data map[string][]byte, dnsNames []string, now time.Time) (map[string][]byte, bool, error) {
+	renewed := map[string][]byte{}
+	for key, value := range data {
+		renewed[key] = value
+	}
+
+	caCert, caKey, err := decodeKeyPair(data[corev1.ServiceAccountRootCAKey], data[CAKeyKey])
+	caRenewed := err != nil || caCert.NotAfter.Sub(now) < DefaultRenewBefore
+
+	if caRenewed {
+		if caCert != nil && caCert.NotAfter.After(now) {
+			renewed[PreviousCACertKey] = data[corev1.ServiceAccountRootCAKey]
+		} else {
+			delete(renewed, PreviousCACertKey)
+		}
+
+		caCert, caKey, err = newCertificateAuthority(now)
+		if err != nil {
+			return nil, false, err
+		}
+
+		renewed[corev1.ServiceAccountRootCAKey] = certs.EncodeCertPEM(caCert)
+		renewed[CAKeyKey] = certs.EncodePrivateKeyPEM(caKey)
+	}
+
+	cert, _, err := decodeKeyPair(data[corev1.TLSCertKey], data[corev1.TLSPrivateKeyKey])
+	if !caRenewed && err == nil && cert.NotAfter.Sub(now) >= DefaultRenewBefore &&
+		cert.CheckSignatureFrom(caCert) == nil && sameNames(cert.DNSNames, dnsNames) {
+		return renewed, false, nil
+	}
+
+	cert, key, err := newServingCertificate(dnsNames, caCert, caKey, now)
+	if err != nil {
+		return nil, false, err
+	}
+
+	renewed[corev1.TLSCertKey] = certs.EncodeCertPEM(cert)
+	renewed[corev1.TLSPrivateKeyKey] = certs.EncodePrivateKeyPEM(key)
+
+	return renewed, true, nil
+}
+
+// CABundle returns the certificate authorities to inject: the current one, and the previous one until it expires.
+func CABundle(data map[string][]byte, now time.Time) []byte {
+	bundle := append([]byte{}, data[corev1.ServiceAccountRootCAKey]...)
+
+	if previous, err := certs.DecodeCertPEM(data[PreviousCACertKey]); err == nil && previous != nil && previous.NotAfter.After(now) {
+		bundle = append(bundle, data[PreviousCACertKey]...)
+	}
+
+	return bundle
+}
+
+// injectCABundle sets the certificate authorities of the webhooks, and of the conversion webhooks of the CRDs, calling
+// the service of the webhook server.
+func (r *Rotator) injectCABundle(ctx context.Context, bundle []byte) error {
+	validatingConfigs := &admissionregistrationv1.ValidatingWebhookConfigurationList{}
+	if err := r.Reader.List(ctx, validatingConfigs); err != nil {
+		return errors.Wrap(err, "failed to list the validating webhook configurations")
+	}
+
+	for i := range validatingConfigs.Items {
+		config := &validatingConfigs.Items[i]
+
+		injected := false
+		for j := range config.Webhooks {
+			injected = r.inject(&config.Webhooks[j].ClientConfig, bundle) || injected
+		}
+
+		if injected {
+			if err := r.Client.Update(ctx, config); err != nil {
+				return errors.Wrapf(err, "failed to inject the CA bundle in validating webhook configuration %s", config.Name)
+			}
+		}
+	}
+
+	mutatingConfigs := &admissionregistrationv1.MutatingWebhookConfigurationList{}
+	if err := r.Reader.List(ctx, mutatingConfigs); err != nil {
+		return errors.Wrap(err, "failed to list the mutating webhook configurations")
+	}
+
+	for i := range mutatingConfigs.Items {
+		config := &mutatingConfigs.Items[i]
+
+		injected := false
+		for j := range config.Webhooks {
+			injected = r.inject(&config.Webhooks[j].ClientConfig, bundle) || injected
+		}
+
+		if injected {
+			if err := r.Client.Update(ctx, config); err != nil {
+				return errors.Wrapf(err, "failed to inject the CA bundle in mutating webhook configuration %s", config.Name)
+			}
+		}
+	}
+
+	crds := &apiextensionsv1.CustomResourceDefinitionList{}
+	if err := r.Reader.List(ctx, crds); err != nil {
+		return errors.Wrap(err, "failed to list the CRDs")
+	}
+
+	for i := range crds.Items {
+		crd := &crds.Items[i]
+
+		conversion := crd.Spec.Conversion
+		if conversion == nil || conversion.Strategy != apiextensionsv1.WebhookConverter || conversion.Webhook == nil ||
+			conversion.Webhook.ClientConfig == nil {
+			continue
+		}
+
+		clientConfig := conversion.Webhook.ClientConfig
+		if clientConfig.Service == nil || !r.targets(clientConfig.Service.Namespace, clientConfig.Service.Name) ||
+			bytes.Equal(clientConfig.CABundle, bundle) {
+			continue
+		}
+
+		clientConfig.CABundle = bundle
+
+		if err := r.Client.Update(ctx, crd); err != nil {
+			return errors.Wrapf(err, "failed to inject the CA bundle in CRD %s", crd.Name)
+		}
+	}
+
+	return nil
+}
+
+// inject sets the certificate authorities of a webhook calling the service of the webhook server, and returns whether
+// they changed.
+func (r *Rotator) inject(clientConfig *admissionregistrationv1.WebhookClientConfig, bundle []byte) bool {
+	if clientConfig.Service == nil || !r.targets(clientConfig.Service.Namespace, clientConfig.Service.Name) ||
+		bytes.Equal(clientConfig.CABundle, bundle) {
+		return false
+	}
+
+	clientConfig.CABundle = bundle
+
+	return true
+}
+
+// targets returns whether a webhook calls the service of the webhook server.
+func (r *Rotator) targets(namespace, name string) bool {
+	return namespace == r.Service.Namespace && name == r.Service.Name
+}
+
+// writeFileIfChanged writes a file of the certificate directory unless it already has the content, as the webhook
+// server reloads the certificate whenever the file is written. The file is replaced atomically.
+func writeFileIfChanged(path string, content []byte, mode os.FileMode) error {
+	if current, err := os.ReadFile(path); err == nil && bytes.Equal(current, content) {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return errors.Wrapf(err, "failed to create directory %s", filepath.Dir(path))
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, content, mode); err != nil {
+		return errors.Wrapf(err, "failed to write %s", tmp)
+	}
+
+	return errors.Wrapf(os.Rename(tmp, path), "failed to write %s", path)
+}
+
+// decodeKeyPair decodes a certificate and its private key.
+func decodeKeyPair(certPEM, keyPEM []byte) (*x509.Certificate, *rsa.PrivateKey, error) {
+	cert, err := certs.DecodeCertPEM(certPEM)
+	if err != nil || cert == nil {
+		return nil, nil, errors.New("invalid certificate")
+	}
+
+	signer, err := certs.DecodePrivateKeyPEM(keyPEM)
+	if err != nil {
+		return nil, nil, errors.New("invalid private key")
+	}
+
+	key, ok := signer.(*rsa.PrivateKey)
+	if !ok {
+		return nil, nil, errors.New("not a RSA private key")
+	}
+
+	return cert, key, nil
+}
+
+// newCertificateAuthority creates a self-signed certificate authority valid from the given time.
+func newCertificateAuthority(now time.Time) (*x509.Certificate, *rsa.PrivateKey, error) {
+	key, err := certs.NewPrivateKey()
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to create the private key of the certificate authority")
+	}
+
+	tmpl := &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "rke2-provider-webhook-ca"},
+		NotBefore:             now.Add(-5 * time.Minute).UTC(),
+		NotAfter:              now.Add(DefaultCAValidity).UTC(),
+		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+
+	cert, err := signCertificate(tmpl, tmpl, key, key)
+
+	return cert, key, err
+}
+
+// newServingCertificate creates a serving certificate for the DNS names, signed by the certificate authority. It
+// expires with the certificate authority at the latest.
+func newServingCertificate(
+	dnsNames []string,
+	caCert *x509.Certificate,
+	caKey *rsa.PrivateKey,
+	now time.Time,
+) (*x509.Certificate, *rsa.PrivateKey, error) {
+	key, err := certs.NewPrivateKey()
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to create the private key of the serving certificate")
+	}
+
+	notAfter := now.Add(DefaultCertValidity)
+	if caCert.NotAfter.Before(notAfter) {
+		notAfter = caCert.NotAfter
+	}
+
+	tmpl := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: dnsNames[0]},
+		DNSNames:    dnsNames,
+		NotBefore:   now.Add(-5 * time.Minute).UTC(),
+		NotAfter:    notAfter.UTC(),
+		KeyUsage:    x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	cert, err := signCertificate(tmpl, caCert, key, caKey)
+
+	return cert, key, err
+}
+
+// signCertificate signs the certificate of the key with the key of its parent, with a random serial number.
+func signCertificate(tmpl, parent *x509.Certificate, key, parentKey *rsa.PrivateKey) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, big.NewInt(math.MaxInt64))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to generate a serial number")
+	}
+
+	tmpl.SerialNumber = serial
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, key.Public(), parentKey)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to sign certificate %s", tmpl.Subject.CommonName)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+
+	return cert, errors.WithStack(err)
+}
+
+// sameNames returns whether the certificate is issued for the DNS names.
+func sameNames(names, expected []string) bool {
+	if len(names) != len(expected) {
+		return false
+	}
+
+	for i := range names {
+		if names[i] != expected[i] {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,243 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package certrotation
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"sigs.k8s.io/cluster-api/util/certs"
+)
+
+var _ = Describe("Renew", func() {
+	dnsNames := DNSNames(client.ObjectKey{Namespace: "rke2-system", Name: "webhook-service"})
+	now := time.Now()
+
+	It("should generate the certificate authority and the serving certificate", func() {
+		data, renewed, err := Renew(nil, dnsNames, now)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(renewed).To(BeTrue())
+
+		caCert, err := certs.DecodeCertPEM(data[corev1.ServiceAccountRootCAKey])
+		Expect(err).ToNot(HaveOccurred())
+
+		cert, err := certs.DecodeCertPEM(data[corev1.TLSCertKey])
+		Expect(err).ToNot(HaveOccurred())
+		Expect(cert.DNSNames).To(Equal([]string{
+			"webhook-service.rke2-system.svc",
+			"webhook-service.rke2-system.svc.cluster.local",
+		}))
+		Expect(cert.CheckSignatureFrom(caCert)).To(Succeed())
+		Expect(data).ToNot(HaveKey(PreviousCACertKey))
+	})
+
+	It("should keep valid certificates", func() {
+		data, _, err := Renew(nil, dnsNames, now)
+		Expect(err).ToNot(HaveOccurred())
+
+		kept, renewed, err := Renew(data, dnsNames, now.Add(24*time.Hour))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(renewed).To(BeFalse())
+		Expect(kept).To(Equal(data))
+	})
+
+	It("should renew the serving certificate before it expires", func() {
+		data, _, err := Renew(nil, dnsNames, now)
+		Expect(err).ToNot(HaveOccurred())
+
+		later := now.Add(DefaultCertValidity - DefaultRenewBefore + time.Hour)
+		renewedData, renewed, err := Renew(data, dnsNames, later)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(renewed).To(BeTrue())
+		Expect(renewedData[corev1.ServiceAccountRootCAKey]).To(Equal(data[corev1.ServiceAccountRootCAKey]))
+		Expect(renewedData[corev1.TLSCertKey]).ToNot(Equal(data[corev1.TLSCertKey]))
+
+		cert, err := certs.DecodeCertPEM(renewedData[corev1.TLSCertKey])
+		Expect(err).ToNot(HaveOccurred())
+		Expect(cert.NotAfter).To(BeTemporally("~", later.Add(DefaultCertValidity), time.Second))
+	})
+
+	It("should renew the serving certificate of another service", func() {
+		data, _, err := Renew(nil, dnsNames, now)
+		Expect(err).ToNot(HaveOccurred())
+
+		_, renewed, err := Renew(data, DNSNames(client.ObjectKey{Namespace: "other", Name: "webhook-service"}), now)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(renewed).To(BeTrue())
+	})
+
+	It("should keep the previous certificate authority in the bundle until it expires", func() {
+		data, _, err := Renew(nil, dnsNames, now)
+		Expect(err).ToNot(HaveOccurred())
+
+		later := now.Add(DefaultCAValidity - DefaultRenewBefore + time.Hour)
+		renewedData, renewed, err := Renew(data, dnsNames, later)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(renewed).To(BeTrue())
+		Expect(renewedData[PreviousCACertKey]).To(Equal(data[corev1.ServiceAccountRootCAKey]))
+
+		caCert, err := certs.DecodeCertPEM(renewedData[corev1.ServiceAccountRootCAKey])
+		Expect(err).ToNot(HaveOccurred())
+
+		cert, err := certs.DecodeCertPEM(renewedData[corev1.TLSCertKey])
+		Expect(err).ToNot(HaveOccurred())
+		Expect(cert.CheckSignatureFrom(caCert)).To(Succeed())
+
+		Expect(CABundle(renewedData, later)).To(Equal(append(append([]byte{}, renewedData[corev1.ServiceAccountRootCAKey]...),
+			data[corev1.ServiceAccountRootCAKey]...)))
+		Expect(CABundle(renewedData, now.Add(DefaultCAValidity+time.Hour))).To(
+			Equal(renewedData[corev1.ServiceAccountRootCAKey]))
+	})
+})
+
+var _ = Describe("Rotator", func() {
+	var (
+		ctx     context.Context
+		rotator *Rotator
+		service client.ObjectKey
+		certDir string
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+
+		var err error
+		certDir, err = os.MkdirTemp("", "certrotation")
+		Expect(err).ToNot(HaveOccurred())
+
+		service = client.ObjectKey{Namespace: "rke2-system", Name: "webhook-service"}
+
+		scheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(apiextensionsv1.AddToScheme(scheme)).To(Succeed())
+
+		fakeClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+			&admissionregistrationv1.ValidatingWebhookConfiguration{
+				ObjectMeta: metav1.ObjectMeta{Name: "validating-webhook-configuration"},
+				Webhooks: []admissionregistrationv1.ValidatingWebhook{
+					{
+						Name: "vrke2controlplane.kb.io",
+						ClientConfig: admissionregistrationv1.WebhookClientConfig{
+							Service: &admissionregistrationv1.ServiceReference{Namespace: service.Namespace, Name: service.Name},
+						},
+					},
+					{
+						Name: "other.kb.io",
+						ClientConfig: admissionregistrationv1.WebhookClientConfig{
+							Service: &admissionregistrationv1.ServiceReference{Namespace: "other", Name: service.Name},
+						},
+					},
+				},
+			},
+			&admissionregistrationv1.MutatingWebhookConfiguration{
+				ObjectMeta: metav1.ObjectMeta{Name: "mutating-webhook-configuration"},
+				Webhooks: []admissionregistrationv1.MutatingWebhook{{
+					Name: "mrke2controlplane.kb.io",
+					ClientConfig: admissionregistrationv1.WebhookClientConfig{
+						Service: &admissionregistrationv1.ServiceReference{Namespace: service.Namespace, Name: service.Name},
+					},
+				}},
+			},
+			&apiextensionsv1.CustomResourceDefinition{
+				ObjectMeta: metav1.ObjectMeta{Name: "rke2controlplanes.controlplane.cluster.x-k8s.io"},
+				Spec: apiextensionsv1.CustomResourceDefinitionSpec{
+					Conversion: &apiextensionsv1.CustomResourceConversion{
+						Strategy: apiextensionsv1.WebhookConverter,
+						Webhook: &apiextensionsv1.WebhookConversion{
+							ClientConfig: &apiextensionsv1.WebhookClientConfig{
+								Service: &apiextensionsv1.ServiceReference{Namespace: service.Namespace, Name: service.Name},
+							},
+						},
+					},
+				},
+			},
+		).Build()
+
+		rotator = &Rotator{
+			Client:    fakeClient,
+			Reader:    fakeClient,
+			SecretKey: client.ObjectKey{Namespace: service.Namespace, Name: "webhook-service-cert"},
+			Service:   service,
+			CertDir:   certDir,
+		}
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(certDir)).To(Succeed())
+	})
+
+	It("should store, write and inject the certificates", func() {
+		Expect(rotator.Checker(nil)).ToNot(Succeed())
+		Expect(rotator.Reconcile(ctx)).To(Succeed())
+
+		secret := &corev1.Secret{}
+		Expect(rotator.Reader.Get(ctx, rotator.SecretKey, secret)).To(Succeed())
+		Expect(secret.Type).To(Equal(corev1.SecretTypeTLS))
+
+		cert, err := os.ReadFile(filepath.Join(rotator.CertDir, corev1.TLSCertKey))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(cert).To(Equal(secret.Data[corev1.TLSCertKey]))
+
+		key, err := os.ReadFile(filepath.Join(rotator.CertDir, corev1.TLSPrivateKeyKey))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(key).To(Equal(secret.Data[corev1.TLSPrivateKeyKey]))
+
+		bundle := secret.Data[corev1.ServiceAccountRootCAKey]
+
+		validating := &admissionregistrationv1.ValidatingWebhookConfiguration{}
+		Expect(rotator.Reader.Get(ctx, client.ObjectKey{Name: "validating-webhook-configuration"}, validating)).To(Succeed())
+		Expect(validating.Webhooks[0].ClientConfig.CABundle).To(Equal(bundle))
+		Expect(validating.Webhooks[1].ClientConfig.CABundle).To(BeEmpty())
+
+		mutating := &admissionregistrationv1.MutatingWebhookConfiguration{}
+		Expect(rotator.Reader.Get(ctx, client.ObjectKey{Name: "mutating-webhook-configuration"}, mutating)).To(Succeed())
+		Expect(mutating.Webhooks[0].ClientConfig.CABundle).To(Equal(bundle))
+
+		crd := &apiextensionsv1.CustomResourceDefinition{}
+		Expect(rotator.Reader.Get(ctx, client.ObjectKey{Name: "rke2controlplanes.controlplane.cluster.x-k8s.io"}, crd)).To(Succeed())
+		Expect(crd.Spec.Conversion.Webhook.ClientConfig.CABundle).To(Equal(bundle))
+
+		Expect(rotator.Checker(nil)).To(Succeed())
+	})
+
+	It("should keep the stored certificates", func() {
+		Expect(rotator.Reconcile(ctx)).To(Succeed())
+
+		secret := &corev1.Secret{}
+		Expect(rotator.Reader.Get(ctx, rotator.SecretKey, secret)).To(Succeed())
+
+		Expect(rotator.Reconcile(ctx)).To(Succeed())
+
+		kept := &corev1.Secret{}
+		Expect(rotator.Reader.Get(ctx, rotator.SecretKey, kept)).To(Succeed())
+		Expect(kept.ResourceVersion).To(Equal(secret.ResourceVersion))
+	})
+})
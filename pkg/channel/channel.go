@@ -0,0 +1,140 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package channel resolves the RKE2 release channels, e.g. stable or v1.26, to their latest release.
+package channel
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/util/version"
+)
+
+const (
+	// DefaultServer is the server of the RKE2 release channels, which redirects a channel to its latest release.
+	DefaultServer = "https://update.rke2.io/v1-release/channels"
+
+	// DefaultTimeout is the timeout of the resolution of a channel, well under the timeout of the defaulting webhook
+	// resolving the channels so that a slow channel server fails the admission with an error.
+	DefaultTimeout = 3 * time.Second
+
+	// DefaultCacheTTL is how long the latest release of a channel is reused before resolving the channel again.
+	DefaultCacheTTL = time.Hour
+)
+
+// Resolver resolves the RKE2 release channels with the channel server.
+type Resolver struct {
+	// Server is the URL of the channel server, DefaultServer when empty.
+	Server string
+
+	// Timeout is the timeout of the resolution of a channel, DefaultTimeout when zero.
+	Timeout time.Duration
+
+	// CacheTTL is how long the latest release of a channel is reused, DefaultCacheTTL when zero.
+	CacheTTL time.Duration
+
+	lock     sync.Mutex
+	releases map[string]resolvedRelease
+}
+
+// resolvedRelease is the latest release of a channel, until it expires.
+type resolvedRelease struct {
+	release string
+	expires time.Time
+}
+
+// Resolve returns the latest RKE2 release of the channel, e.g. v1.26.4+rke2r1 for the v1.26 channel. The channel
+// server redirects to the release page of the latest release, the release is read from the redirection. The release
+// is cached, so that the channel server isn't queried for each created control plane.
+func (r *Resolver) Resolve(ctx context.Context, channel string) (string, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if resolved, ok := r.releases[channel]; ok && time.Now().Before(resolved.expires) {
+		return resolved.release, nil
+	}
+
+	release, err := r.resolve(ctx, channel)
+	if err != nil {
+		return "", err
+	}
+
+	ttl := r.CacheTTL
+	if ttl == 0 {
+		ttl = DefaultCacheTTL
+	}
+
+	if r.releases == nil {
+		r.releases = map[string]resolvedRelease{}
+	}
+
+	r.releases[channel] = resolvedRelease{release: release, expires: time.Now().Add(ttl)}
+
+	return release, nil
+}
+
+// resolve queries the channel server for the latest release of the channel.
+func (r *Resolver) resolve(ctx context.Context, channel string) (string, error) {
+	server, timeout := r.Server, r.Timeout
+	if server == "" {
+		server = DefaultServer
+	}
+
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(server, "/")+"/"+url.PathEscape(channel), nil)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to resolve the rke2 channel %s", channel)
+	}
+
+	client := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to resolve the rke2 channel %s", channel)
+	}
+	defer resp.Body.Close()
+
+	location, err := resp.Location()
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve the rke2 channel %s: the channel server answered %s without a release",
+			channel, resp.Status)
+	}
+
+	release := path.Base(location.Path)
+	if _, err := version.ParseSemantic(release); err != nil || !strings.HasPrefix(release, "v") {
+		return "", fmt.Errorf("failed to resolve the rke2 channel %s: %q is not a release", channel, release)
+	}
+
+	return release, nil
+}
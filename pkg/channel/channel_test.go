@@ -0,0 +1,91 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package channel
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Resolver", func() {
+	var (
+		server   *httptest.Server
+		resolver *Resolver
+		requests int
+	)
+
+	BeforeEach(func() {
+		requests = 0
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+
+			switch r.URL.Path {
+			case "/v1-release/channels/stable":
+				http.Redirect(w, r, "https://github.com/rancher/rke2/releases/tag/v1.26.4%2Brke2r1", http.StatusFound)
+			case "/v1-release/channels/broken":
+				http.Redirect(w, r, "https://github.com/rancher/rke2/releases", http.StatusFound)
+			default:
+				http.NotFound(w, r)
+			}
+		}))
+		resolver = &Resolver{Server: server.URL + "/v1-release/channels/"}
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("should resolve a channel to the release it redirects to", func() {
+		Expect(resolver.Resolve(context.Background(), "stable")).To(Equal("v1.26.4+rke2r1"))
+	})
+
+	It("should fail when the channel doesn't redirect to a release", func() {
+		_, err := resolver.Resolve(context.Background(), "unknown")
+		Expect(err).To(MatchError(ContainSubstring("404 Not Found")))
+
+		_, err = resolver.Resolve(context.Background(), "broken")
+		Expect(err).To(MatchError(ContainSubstring(`"releases" is not a release`)))
+	})
+
+	It("should reuse the resolved release of a channel", func() {
+		Expect(resolver.Resolve(context.Background(), "stable")).To(Equal("v1.26.4+rke2r1"))
+		Expect(resolver.Resolve(context.Background(), "stable")).To(Equal("v1.26.4+rke2r1"))
+		Expect(requests).To(Equal(1))
+	})
+
+	It("should resolve a channel again once its release expired", func() {
+		resolver.CacheTTL = time.Nanosecond
+
+		Expect(resolver.Resolve(context.Background(), "stable")).To(Equal("v1.26.4+rke2r1"))
+		time.Sleep(time.Millisecond)
+		Expect(resolver.Resolve(context.Background(), "stable")).To(Equal("v1.26.4+rke2r1"))
+		Expect(requests).To(Equal(2))
+	})
+
+	It("should not cache the failed resolutions", func() {
+		_, err := resolver.Resolve(context.Background(), "unknown")
+		Expect(err).To(HaveOccurred())
+		_, err = resolver.Resolve(context.Background(), "unknown")
+		Expect(err).To(HaveOccurred())
+		Expect(requests).To(Equal(2))
+	})
+})
@@ -0,0 +1,136 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package client provides helpers for the programs embedding the provider, e.g. platform orchestrators, to build the
+// RKE2 control plane and bootstrap objects, to wait for the control planes to be ready, and to fetch their kubeconfig.
+package client
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/pointer"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+
+	bootstrapv1 "github.com/rancher-sandbox/cluster-api-provider-rke2/bootstrap/api/v1alpha1"
+	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+)
+
+// RKE2ControlPlaneOption customizes a RKE2ControlPlane built by NewRKE2ControlPlane.
+type RKE2ControlPlaneOption func(*controlplanev1.RKE2ControlPlane)
+
+// WithLabels adds labels to the RKE2ControlPlane.
+func WithLabels(labels map[string]string) RKE2ControlPlaneOption {
+	return func(rcp *controlplanev1.RKE2ControlPlane) {
+		if rcp.Labels == nil {
+			rcp.Labels = map[string]string{}
+		}
+
+		for key, value := range labels {
+			rcp.Labels[key] = value
+		}
+	}
+}
+
+// WithCNI sets the CNI of the RKE2ControlPlane.
+func WithCNI(cni controlplanev1.CNI) RKE2ControlPlaneOption {
+	return func(rcp *controlplanev1.RKE2ControlPlane) {
+		rcp.Spec.ServerConfig.CNI = cni
+	}
+}
+
+// WithServerConfig sets the server configuration of the RKE2ControlPlane.
+func WithServerConfig(serverConfig controlplanev1.RKE2ServerConfig) RKE2ControlPlaneOption {
+	return func(rcp *controlplanev1.RKE2ControlPlane) {
+		rcp.Spec.ServerConfig = serverConfig
+	}
+}
+
+// WithAgentConfig sets the agent configuration of the RKE2ControlPlane, keeping its version.
+func WithAgentConfig(agentConfig bootstrapv1.RKE2AgentConfig) RKE2ControlPlaneOption {
+	return func(rcp *controlplanev1.RKE2ControlPlane) {
+		version := rcp.Spec.AgentConfig.Version
+		rcp.Spec.AgentConfig = agentConfig
+		rcp.Spec.AgentConfig.Version = version
+	}
+}
+
+// NewRKE2ControlPlane returns a RKE2ControlPlane, to be created in the namespace of the cluster and referenced by its
+// control plane reference, running the RKE2 version on the replicas of the infrastructure template.
+func NewRKE2ControlPlane(
+	cluster *clusterv1.Cluster,
+	name, version string,
+	replicas int32,
+	infrastructureTemplate corev1.ObjectReference,
+	opts ...RKE2ControlPlaneOption,
+) *controlplanev1.RKE2ControlPlane {
+	rcp := &controlplanev1.RKE2ControlPlane{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: controlplanev1.GroupVersion.String(),
+			Kind:       "RKE2ControlPlane",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: cluster.Namespace,
+			Labels: map[string]string{
+				clusterv1.ClusterNameLabel: cluster.Name,
+			},
+		},
+		Spec: controlplanev1.RKE2ControlPlaneSpec{
+			Replicas:          pointer.Int32(replicas),
+			InfrastructureRef: infrastructureTemplate,
+		},
+	}
+	rcp.Spec.AgentConfig.Version = version
+
+	for _, opt := range opts {
+		opt(rcp)
+	}
+
+	return rcp
+}
+
+// ControlPlaneRef returns the reference to the RKE2ControlPlane, to be set as the control plane reference of its
+// cluster.
+func ControlPlaneRef(rcp *controlplanev1.RKE2ControlPlane) *corev1.ObjectReference {
+	return &corev1.ObjectReference{
+		APIVersion: controlplanev1.GroupVersion.String(),
+		Kind:       "RKE2ControlPlane",
+		Namespace:  rcp.Namespace,
+		Name:       rcp.Name,
+	}
+}
+
+// NewRKE2ConfigTemplate returns a RKE2ConfigTemplate, to be created in the namespace of the cluster and referenced by
+// the bootstrap configuration of its MachineDeployments, running the RKE2 version on the worker machines.
+func NewRKE2ConfigTemplate(cluster *clusterv1.Cluster, name, version string) *bootstrapv1.RKE2ConfigTemplate {
+	template := &bootstrapv1.RKE2ConfigTemplate{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: bootstrapv1.GroupVersion.String(),
+			Kind:       "RKE2ConfigTemplate",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: cluster.Namespace,
+			Labels: map[string]string{
+				clusterv1.ClusterNameLabel: cluster.Name,
+			},
+		},
+	}
+	template.Spec.Template.Spec.AgentConfig.Version = version
+
+	return template
+}
@@ -0,0 +1,123 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+
+	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+)
+
+var _ = Describe("NewRKE2ControlPlane", func() {
+	It("should build a control plane of the cluster", func() {
+		cluster := &clusterv1.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "ns"}}
+		infraRef := corev1.ObjectReference{Kind: "DockerMachineTemplate", Name: "control-plane"}
+
+		rcp := NewRKE2ControlPlane(cluster, "test-control-plane", "v1.26.4+rke2r1", 3, infraRef,
+			WithCNI(controlplanev1.Cilium), WithLabels(map[string]string{"team": "platform"}))
+
+		Expect(rcp.Namespace).To(Equal("ns"))
+		Expect(rcp.Labels).To(HaveKeyWithValue(clusterv1.ClusterNameLabel, "test"))
+		Expect(rcp.Labels).To(HaveKeyWithValue("team", "platform"))
+		Expect(*rcp.Spec.Replicas).To(BeEquivalentTo(3))
+		Expect(rcp.Spec.AgentConfig.Version).To(Equal("v1.26.4+rke2r1"))
+		Expect(rcp.Spec.ServerConfig.CNI).To(Equal(controlplanev1.Cilium))
+		Expect(rcp.Spec.InfrastructureRef).To(Equal(infraRef))
+		Expect(ControlPlaneRef(rcp).Kind).To(Equal("RKE2ControlPlane"))
+	})
+})
+
+var _ = Describe("WaitForControlPlaneReady", func() {
+	var (
+		scheme *runtime.Scheme
+		rcp    *controlplanev1.RKE2ControlPlane
+	)
+
+	BeforeEach(func() {
+		scheme = runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+		Expect(controlplanev1.AddToScheme(scheme)).To(Succeed())
+
+		cluster := &clusterv1.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "ns"}}
+		rcp = NewRKE2ControlPlane(cluster, "test-control-plane", "v1.26.4+rke2r1", 3, corev1.ObjectReference{})
+		rcp.Generation = 1
+		rcp.Status = controlplanev1.RKE2ControlPlaneStatus{
+			ObservedGeneration: 1,
+			Ready:              true,
+			Replicas:           3,
+			ReadyReplicas:      3,
+		}
+	})
+
+	It("should return the ready control plane", func() {
+		c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(rcp).Build()
+
+		ready, err := WaitForControlPlaneReady(context.Background(), c, ctrlclient.ObjectKeyFromObject(rcp), time.Millisecond)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ready.Name).To(Equal(rcp.Name))
+	})
+
+	It("should not consider a control plane with missing replicas ready", func() {
+		rcp.Status.ReadyReplicas = 2
+		Expect(ControlPlaneReady(rcp)).To(BeFalse())
+
+		c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(rcp).Build()
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		_, err := WaitForControlPlaneReady(ctx, c, ctrlclient.ObjectKeyFromObject(rcp), time.Millisecond)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should fail on a terminal failure", func() {
+		rcp.Status.Ready = false
+		rcp.Status.FailureReason = "InvalidConfiguration"
+
+		c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(rcp).Build()
+
+		_, err := WaitForControlPlaneReady(context.Background(), c, ctrlclient.ObjectKeyFromObject(rcp), time.Millisecond)
+		Expect(errors.Is(err, ErrControlPlaneFailed)).To(BeTrue())
+	})
+})
+
+var _ = Describe("GetKubeconfig", func() {
+	It("should return the kubeconfig of the cluster", func() {
+		c := fake.NewClientBuilder().WithObjects(&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-kubeconfig", Namespace: "ns"},
+			Data:       map[string][]byte{"value": []byte("kubeconfig")},
+		}).Build()
+
+		data, err := GetKubeconfig(context.Background(), c, types.NamespacedName{Namespace: "ns", Name: "test"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(data)).To(Equal("kubeconfig"))
+	})
+})
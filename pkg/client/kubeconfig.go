@@ -0,0 +1,54 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+
+	"sigs.k8s.io/cluster-api/util/kubeconfig"
+)
+
+// GetKubeconfig returns the admin kubeconfig of the workload cluster, generated by the control plane controller once
+// the control plane endpoint of the cluster is known.
+func GetKubeconfig(ctx context.Context, c ctrlclient.Reader, cluster ctrlclient.ObjectKey) ([]byte, error) {
+	data, err := kubeconfig.FromSecret(ctx, c, cluster)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get the kubeconfig of cluster %s", cluster)
+	}
+
+	return data, nil
+}
+
+// GetRESTConfig returns the REST configuration of the admin kubeconfig of the workload cluster.
+func GetRESTConfig(ctx context.Context, c ctrlclient.Reader, cluster ctrlclient.ObjectKey) (*rest.Config, error) {
+	data, err := GetKubeconfig(ctx, c, cluster)
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := clientcmd.RESTConfigFromKubeConfig(data)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid kubeconfig of cluster %s", cluster)
+	}
+
+	return config, nil
+}
@@ -0,0 +1,89 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+
+	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+)
+
+// DefaultPollInterval is the interval at which WaitForControlPlaneReady checks the RKE2ControlPlane when no interval
+// is given.
+const DefaultPollInterval = 10 * time.Second
+
+// ErrControlPlaneFailed is returned by WaitForControlPlaneReady when the controller reports a terminal failure of the
+// RKE2ControlPlane.
+var ErrControlPlaneFailed = errors.New("control plane failed")
+
+// ControlPlaneReady returns whether the controller observed the latest spec of the RKE2ControlPlane, and all its
+// replicas are ready.
+func ControlPlaneReady(rcp *controlplanev1.RKE2ControlPlane) bool {
+	if rcp.Status.ObservedGeneration < rcp.Generation || !rcp.Status.Ready {
+		return false
+	}
+
+	if replicas := rcp.Spec.MachineReplicas(); rcp.Spec.Replicas != nil &&
+		(rcp.Status.Replicas != replicas || rcp.Status.ReadyReplicas != replicas) {
+		return false
+	}
+
+	return true
+}
+
+// WaitForControlPlaneReady polls the RKE2ControlPlane at the interval, DefaultPollInterval when zero, until it is
+// ready, and returns it. It fails when the context is done or when the controller reports a terminal failure.
+func WaitForControlPlaneReady(
+	ctx context.Context,
+	c ctrlclient.Reader,
+	key ctrlclient.ObjectKey,
+	interval time.Duration,
+) (*controlplanev1.RKE2ControlPlane, error) {
+	if interval == 0 {
+		interval = DefaultPollInterval
+	}
+
+	rcp := &controlplanev1.RKE2ControlPlane{}
+
+	err := wait.PollImmediateUntilWithContext(ctx, interval, func(ctx context.Context) (bool, error) {
+		if err := c.Get(ctx, key, rcp); err != nil {
+			// The control plane may not be in the cache of the reader yet.
+			if apierrors.IsNotFound(err) {
+				return false, nil
+			}
+
+			return false, errors.Wrapf(err, "failed to get RKE2ControlPlane %s", key)
+		}
+
+		if rcp.Status.FailureReason != "" {
+			return false, errors.Wrapf(ErrControlPlaneFailed, "%s: %s", rcp.Status.FailureReason, rcp.Status.FailureMessage)
+		}
+
+		return ControlPlaneReady(rcp), nil
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "RKE2ControlPlane %s is not ready", key)
+	}
+
+	return rcp, nil
+}
@@ -0,0 +1,316 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package compatibility implements the matrix of the RKE2 versions the provider supports, used to reject invalid
+// versions and upgrades early.
+package compatibility
+
+import (
+	"context"
+	_ "embed" // The default matrix is embedded.
+	"fmt"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/version"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// Contract is the Cluster API contract implemented by the provider.
+	Contract = "v1beta1"
+
+	// ConfigMapKey is the key of the compatibility matrix in the override ConfigMap.
+	ConfigMapKey = "matrix.yaml"
+)
+
+//go:embed matrix.yaml
+var embeddedMatrix []byte
+
+var defaultMatrix = mustParse(embeddedMatrix)
+
+// Matrix lists the Kubernetes minor versions RKE2 releases can be deployed with.
+type Matrix struct {
+	// MinKubernetes is the oldest supported Kubernetes minor version, e.g. v1.21.
+	MinKubernetes string `json:"minKubernetes,omitempty"`
+
+	// MaxKubernetes is the newest supported Kubernetes minor version, the newer versions are supported when empty.
+	MaxKubernetes string `json:"maxKubernetes,omitempty"`
+
+	// Releases restrict the supported RKE2 versions and Cluster API contracts of Kubernetes minor versions, the minor
+	// versions which aren't listed support all their RKE2 versions with the Cluster API contract of the provider.
+	Releases []Release `json:"releases,omitempty"`
+}
+
+// Release defines the supported RKE2 versions of a Kubernetes minor version.
+type Release struct {
+	// Kubernetes is the Kubernetes minor version, e.g. v1.26.
+	Kubernetes string `json:"kubernetes"`
+
+	// MinRKE2Version is the oldest supported RKE2 version of the minor version, e.g. v1.26.4+rke2r1.
+	MinRKE2Version string `json:"minRKE2Version,omitempty"`
+
+	// MaxRKE2Version is the newest supported RKE2 version of the minor version.
+	MaxRKE2Version string `json:"maxRKE2Version,omitempty"`
+
+	// Contracts are the Cluster API contracts the minor version is compatible with.
+	Contracts []string `json:"contracts"`
+}
+
+// Embedded returns the compatibility matrix embedded in the provider.
+func Embedded() *Matrix {
+	return defaultMatrix
+}
+
+// Source reads the compatibility matrix from the override ConfigMap, from its matrix.yaml key, and falls back to the
+// embedded matrix when it is not configured or doesn't exist. A nil source returns the embedded matrix.
+type Source struct {
+	// Reader reads the ConfigMap, it is better not cached, so that the ConfigMaps aren't watched for a single one.
+	Reader client.Reader
+
+	// ConfigMap is the override ConfigMap.
+	ConfigMap client.ObjectKey
+}
+
+// Matrix returns the compatibility matrix of the override ConfigMap when it exists, the embedded one otherwise.
+func (s *Source) Matrix(ctx context.Context) (*Matrix, error) {
+	if s == nil || s.Reader == nil {
+		return defaultMatrix, nil
+	}
+
+	configMap := &corev1.ConfigMap{}
+	if err := s.Reader.Get(ctx, s.ConfigMap, configMap); err != nil {
+		if apierrors.IsNotFound(err) {
+			return defaultMatrix, nil
+		}
+
+		return nil, errors.Wrapf(err, "failed to get the compatibility matrix ConfigMap %s", s.ConfigMap)
+	}
+
+	data, ok := configMap.Data[ConfigMapKey]
+	if !ok {
+		return nil, fmt.Errorf("compatibility matrix ConfigMap %s is missing the %s key", s.ConfigMap, ConfigMapKey)
+	}
+
+	matrix, err := Parse([]byte(data))
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid compatibility matrix in ConfigMap %s", s.ConfigMap)
+	}
+
+	return matrix, nil
+}
+
+// Parse parses and validates a compatibility matrix.
+func Parse(data []byte) (*Matrix, error) {
+	matrix := &Matrix{}
+	if err := yaml.UnmarshalStrict(data, matrix); err != nil {
+		return nil, err
+	}
+
+	for _, minor := range []string{matrix.MinKubernetes, matrix.MaxKubernetes} {
+		if _, err := parseKubernetesMinor(minor); minor != "" && err != nil {
+			return nil, err
+		}
+	}
+
+	for _, release := range matrix.Releases {
+		if _, err := parseKubernetesMinor(release.Kubernetes); err != nil {
+			return nil, err
+		}
+
+		for _, bound := range []string{release.MinRKE2Version, release.MaxRKE2Version} {
+			if bound == "" {
+				continue
+			}
+
+			v, err := parseRKE2Version(bound)
+			if err != nil {
+				return nil, err
+			}
+
+			if kubernetesMinor(v) != release.Kubernetes {
+				return nil, fmt.Errorf("rke2 version %s is not a kubernetes %s version", bound, release.Kubernetes)
+			}
+		}
+	}
+
+	return matrix, nil
+}
+
+func mustParse(data []byte) *Matrix {
+	matrix, err := Parse(data)
+	if err != nil {
+		panic(err)
+	}
+
+	return matrix
+}
+
+// Validate returns an error when the RKE2 version isn't supported by the provider.
+func (m *Matrix) Validate(rke2Version string) error {
+	v, err := parseRKE2Version(rke2Version)
+	if err != nil {
+		return err
+	}
+
+	minor := kubernetesMinor(v)
+	if m.MinKubernetes != "" && compareKubernetesMinors(minor, m.MinKubernetes) < 0 {
+		return fmt.Errorf("kubernetes %s is not supported, the oldest supported version is %s", minor, m.MinKubernetes)
+	}
+
+	if m.MaxKubernetes != "" && compareKubernetesMinors(minor, m.MaxKubernetes) > 0 {
+		return fmt.Errorf("kubernetes %s is not supported, the newest supported version is %s", minor, m.MaxKubernetes)
+	}
+
+	release := m.release(minor)
+	if release == nil {
+		return nil
+	}
+
+	if release.MinRKE2Version != "" && compareRKE2Versions(v, mustParseRKE2Version(release.MinRKE2Version)) < 0 {
+		return fmt.Errorf("rke2 %s is older than the oldest supported version %s", rke2Version, release.MinRKE2Version)
+	}
+
+	if release.MaxRKE2Version != "" && compareRKE2Versions(v, mustParseRKE2Version(release.MaxRKE2Version)) > 0 {
+		return fmt.Errorf("rke2 %s is newer than the newest supported version %s", rke2Version, release.MaxRKE2Version)
+	}
+
+	for _, contract := range release.Contracts {
+		if contract == Contract {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("kubernetes %s is not compatible with the Cluster API contract %s", release.Kubernetes, Contract)
+}
+
+// ValidateUpgrade returns an error when the control plane can't be upgraded from an RKE2 version to another:
+// downgrades and upgrades skipping a Kubernetes minor version are not supported.
+func (m *Matrix) ValidateUpgrade(from, to string) error {
+	fromVersion, err := parseRKE2Version(from)
+	if err != nil {
+		return err
+	}
+
+	toVersion, err := parseRKE2Version(to)
+	if err != nil {
+		return err
+	}
+
+	if compareRKE2Versions(toVersion, fromVersion) < 0 {
+		return fmt.Errorf("downgrading rke2 from %s to %s is not supported", from, to)
+	}
+
+	if toVersion.Major() != fromVersion.Major() || toVersion.Minor() > fromVersion.Minor()+1 {
+		return fmt.Errorf("upgrading rke2 from %s to %s skips a kubernetes minor version, upgrade one minor version at a time",
+			from, to)
+	}
+
+	return nil
+}
+
+func (m *Matrix) release(kubernetes string) *Release {
+	for i := range m.Releases {
+		if m.Releases[i].Kubernetes == kubernetes {
+			return &m.Releases[i]
+		}
+	}
+
+	return nil
+}
+
+// parseKubernetesMinor parses a Kubernetes minor version, e.g. v1.26.
+func parseKubernetesMinor(minor string) (*version.Version, error) {
+	v, err := version.ParseGeneric(minor)
+	if err != nil || len(v.Components()) != 2 {
+		return nil, fmt.Errorf("invalid kubernetes minor version %q", minor)
+	}
+
+	return v, nil
+}
+
+// compareKubernetesMinors compares two valid Kubernetes minor versions.
+func compareKubernetesMinors(a, b string) int {
+	aVersion, _ := parseKubernetesMinor(a)
+	bVersion, _ := parseKubernetesMinor(b)
+
+	switch {
+	case aVersion.LessThan(bVersion):
+		return -1
+	case bVersion.LessThan(aVersion):
+		return 1
+	default:
+		return 0
+	}
+}
+
+// parseRKE2Version parses an RKE2 version, e.g. v1.26.4+rke2r1.
+func parseRKE2Version(rke2Version string) (*version.Version, error) {
+	v, err := version.ParseSemantic(rke2Version)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid rke2 version %q", rke2Version)
+	}
+
+	if _, err := rke2Revision(v); err != nil {
+		return nil, err
+	}
+
+	return v, nil
+}
+
+func mustParseRKE2Version(rke2Version string) *version.Version {
+	v, err := parseRKE2Version(rke2Version)
+	if err != nil {
+		panic(err)
+	}
+
+	return v
+}
+
+// rke2Revision returns the RKE2 revision of a version, e.g. 2 for v1.26.4+rke2r2.
+func rke2Revision(v *version.Version) (int, error) {
+	if v.BuildMetadata() == "" {
+		return 0, nil
+	}
+
+	var revision int
+	if _, err := fmt.Sscanf(v.BuildMetadata(), "rke2r%d", &revision); err != nil {
+		return 0, fmt.Errorf("invalid rke2 revision %q in version %s", v.BuildMetadata(), v)
+	}
+
+	return revision, nil
+}
+
+// compareRKE2Versions compares the Kubernetes versions, then the RKE2 revisions of two RKE2 versions.
+func compareRKE2Versions(a, b *version.Version) int {
+	switch {
+	case a.LessThan(b):
+		return -1
+	case b.LessThan(a):
+		return 1
+	}
+
+	aRevision, _ := rke2Revision(a)
+	bRevision, _ := rke2Revision(b)
+
+	return aRevision - bRevision
+}
+
+func kubernetesMinor(v *version.Version) string {
+	return fmt.Sprintf("v%d.%d", v.Major(), v.Minor())
+}
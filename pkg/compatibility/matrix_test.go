@@ -0,0 +1,110 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package compatibility
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("Matrix", func() {
+	var matrix *Matrix
+
+	BeforeEach(func() {
+		var err error
+
+		matrix, err = Parse([]byte(`minKubernetes: v1.25
+maxKubernetes: v1.28
+releases:
+- kubernetes: v1.25
+  minRKE2Version: v1.25.6+rke2r2
+  contracts: [v1beta1]
+- kubernetes: v1.26
+  maxRKE2Version: v1.26.4+rke2r1
+  contracts: [v1beta1]
+- kubernetes: v1.27
+  contracts: [v1alpha4]
+`))
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("should validate the versions", func() {
+		Expect(matrix.Validate("v1.25.6+rke2r2")).To(Succeed())
+		Expect(matrix.Validate("v1.26.4+rke2r1")).To(Succeed())
+		Expect(matrix.Validate("v1.25.6+rke2r1")).ToNot(Succeed())
+		Expect(matrix.Validate("v1.26.4+rke2r2")).ToNot(Succeed())
+		Expect(matrix.Validate("v1.27.1+rke2r1")).ToNot(Succeed())
+		Expect(matrix.Validate("v1.28.1+rke2r1")).To(Succeed())
+		Expect(matrix.Validate("v1.29.1+rke2r1")).ToNot(Succeed())
+		Expect(matrix.Validate("v1.24.13+rke2r1")).ToNot(Succeed())
+		Expect(matrix.Validate("1.26")).ToNot(Succeed())
+		Expect(matrix.Validate("v1.26.1+k3s1")).ToNot(Succeed())
+	})
+
+	It("should validate the upgrades", func() {
+		Expect(matrix.ValidateUpgrade("v1.25.6+rke2r1", "v1.25.6+rke2r2")).To(Succeed())
+		Expect(matrix.ValidateUpgrade("v1.25.6+rke2r1", "v1.26.4+rke2r1")).To(Succeed())
+		Expect(matrix.ValidateUpgrade("v1.26.4+rke2r1", "v1.25.6+rke2r1")).ToNot(Succeed())
+		Expect(matrix.ValidateUpgrade("v1.25.6+rke2r2", "v1.25.6+rke2r1")).ToNot(Succeed())
+		Expect(matrix.ValidateUpgrade("v1.25.6+rke2r1", "v1.27.1+rke2r1")).ToNot(Succeed())
+	})
+
+	It("should support the releases newer than the embedded matrix", func() {
+		Expect(Embedded().Validate("v1.21.3+rke2r1")).To(Succeed())
+		Expect(Embedded().Validate("v1.21.2+rke2r1")).ToNot(Succeed())
+		Expect(Embedded().Validate("v1.20.15+rke2r2")).ToNot(Succeed())
+		Expect(Embedded().Validate("v1.30.1+rke2r1")).To(Succeed())
+	})
+
+	It("should reject invalid matrices", func() {
+		_, err := Parse([]byte(`releases: [{kubernetes: v1.26.4}]`))
+		Expect(err).To(HaveOccurred())
+
+		_, err = Parse([]byte(`releases: [{kubernetes: v1.26, minRKE2Version: v1.25.6+rke2r1}]`))
+		Expect(err).To(HaveOccurred())
+
+		_, err = Parse([]byte(`maxKubernetes: v1.28.1`))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should read the matrix from the override ConfigMap", func() {
+		key := client.ObjectKey{Namespace: "rke2-control-plane-system", Name: "compatibility-matrix"}
+
+		Expect((*Source)(nil).Matrix(context.Background())).To(Equal(Embedded()))
+
+		source := &Source{Reader: fake.NewClientBuilder().Build(), ConfigMap: key}
+		Expect(source.Matrix(context.Background())).To(Equal(Embedded()))
+
+		source.Reader = fake.NewClientBuilder().WithObjects(&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Namespace: key.Namespace, Name: key.Name},
+			Data:       map[string]string{ConfigMapKey: `{minKubernetes: v1.28, releases: [{kubernetes: v1.28, contracts: [v1beta1]}]}`},
+		}).Build()
+
+		current, err := source.Matrix(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(current.Validate("v1.28.1+rke2r1")).To(Succeed())
+		Expect(current.Validate("v1.27.1+rke2r1")).ToNot(Succeed())
+	})
+
+})
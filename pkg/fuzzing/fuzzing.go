@@ -0,0 +1,75 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package fuzzing implements the fuzz tests shared by the API packages.
+package fuzzing
+
+import (
+	"math/rand"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/api/apitesting/fuzzer"
+	"k8s.io/apimachinery/pkg/api/apitesting/roundtrip"
+	metafuzzer "k8s.io/apimachinery/pkg/apis/meta/fuzzer"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+)
+
+// SeedEnvVar is the environment variable setting the seed of the fuzz tests, to reproduce a failure.
+const SeedEnvVar = "FUZZ_SEED"
+
+// Seed returns the seed of the fuzz tests, from SeedEnvVar when it is set, else a random one. The tests log it so
+// that a failure can be reproduced.
+func Seed() (int64, error) {
+	value, ok := os.LookupEnv(SeedEnvVar)
+	if !ok {
+		return time.Now().UnixNano(), nil
+	}
+
+	seed, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, errors.Wrapf(err, "invalid %s", SeedEnvVar)
+	}
+
+	return seed, nil
+}
+
+// RoundTrip makes sure the API types added to the scheme survive a round trip through their serialized form, so the
+// types and the conversion functions of future API versions don't lose data.
+func RoundTrip(t *testing.T, addToScheme func(*runtime.Scheme) error) {
+	t.Helper()
+
+	seed, err := Seed()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Logf("Fuzzing with %s=%d", SeedEnvVar, seed)
+
+	scheme := runtime.NewScheme()
+	if err := addToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	codecs := serializer.NewCodecFactory(scheme)
+
+	roundtrip.RoundTripExternalTypesWithoutProtobuf(t, scheme, codecs,
+		fuzzer.FuzzerFor(metafuzzer.Funcs, rand.NewSource(seed), codecs), nil)
+}
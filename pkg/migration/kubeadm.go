@@ -0,0 +1,427 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package migration converts the control planes and bootstrap configurations of the clusters created with the
+// kubeadm providers to their RKE2 equivalent, for the clusters switching to the RKE2 providers.
+package migration
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/utils/pointer"
+
+	kubeadmv1 "sigs.k8s.io/cluster-api/bootstrap/kubeadm/api/v1beta1"
+	kcpv1 "sigs.k8s.io/cluster-api/controlplane/kubeadm/api/v1beta1"
+
+	bootstrapv1 "github.com/rancher-sandbox/cluster-api-provider-rke2/bootstrap/api/v1alpha1"
+	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+)
+
+// defaultImageRepositories are the registries kubeadm pulls the control plane images from by default, they aren't
+// converted to a system default registry.
+var defaultImageRepositories = map[string]bool{
+	"":                true,
+	"registry.k8s.io": true,
+	"k8s.gcr.io":      true,
+}
+
+var (
+	// ErrExternalEtcd is returned when converting a control plane using an external etcd cluster, RKE2 servers run
+	// their own etcd members.
+	ErrExternalEtcd = errors.New("an external etcd cluster can't be converted, RKE2 servers run an embedded etcd")
+
+	// ErrVersionMismatch is returned when the RKE2 version a control plane is converted to doesn't release its
+	// Kubernetes version, a migration can't be an upgrade as well.
+	ErrVersionMismatch = errors.New("the RKE2 version must release the Kubernetes version of the control plane")
+)
+
+// ConvertKubeadmControlPlane converts a KubeadmControlPlane to a RKE2ControlPlane of the same name, running the given
+// RKE2 version, e.g. v1.26.4+rke2r1 for a control plane running Kubernetes v1.26.4. The settings that can't be
+// converted are returned as warnings, to be reviewed before applying the RKE2ControlPlane.
+func ConvertKubeadmControlPlane(
+	kcp *kcpv1.KubeadmControlPlane,
+	rke2Version string,
+) (*controlplanev1.RKE2ControlPlane, []string, error) {
+	kubernetesVersion, _, _ := strings.Cut(rke2Version, "+")
+	if kubernetesVersion != kcp.Spec.Version {
+		return nil, nil, errors.Wrapf(ErrVersionMismatch, "%s doesn't release %s", rke2Version, kcp.Spec.Version)
+	}
+
+	kubeadmSpec := &kcp.Spec.KubeadmConfigSpec
+	if clusterConfig := kubeadmSpec.ClusterConfiguration; clusterConfig != nil && clusterConfig.Etcd.External != nil {
+		return nil, nil, ErrExternalEtcd
+	}
+
+	configSpec, warnings := convertKubeadmConfigSpec(kubeadmSpec, initNodeRegistration(kubeadmSpec))
+	configSpec.AgentConfig.Version = rke2Version
+
+	if kubeadmSpec.InitConfiguration != nil && kubeadmSpec.JoinConfiguration != nil &&
+		!reflect.DeepEqual(kubeadmSpec.InitConfiguration.NodeRegistration, kubeadmSpec.JoinConfiguration.NodeRegistration) {
+		warnings = append(warnings, "the node registration of initConfiguration is used for all the servers, "+
+			"it differs from the one of joinConfiguration")
+	}
+
+	serverConfig, serverWarnings := convertClusterConfiguration(kubeadmSpec.ClusterConfiguration, configSpec)
+	warnings = append(warnings, serverWarnings...)
+
+	rolloutStrategy, rolloutWarnings := convertRolloutStrategy(kcp)
+	warnings = append(warnings, rolloutWarnings...)
+	warnings = append(warnings, machineTemplateWarnings(kcp)...)
+
+	var rolloutBefore *controlplanev1.RolloutBefore
+	if kcp.Spec.RolloutBefore != nil && kcp.Spec.RolloutBefore.CertificatesExpiryDays != nil {
+		rolloutBefore = &controlplanev1.RolloutBefore{CertificatesExpiryDays: kcp.Spec.RolloutBefore.CertificatesExpiryDays}
+	}
+
+	rcp := &controlplanev1.RKE2ControlPlane{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: controlplanev1.GroupVersion.String(),
+			Kind:       "RKE2ControlPlane",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        kcp.Name,
+			Namespace:   kcp.Namespace,
+			Labels:      kcp.Labels,
+			Annotations: kcp.Annotations,
+		},
+		Spec: controlplanev1.RKE2ControlPlaneSpec{
+			RKE2ConfigSpec:    *configSpec,
+			Replicas:          kcp.Spec.Replicas,
+			ServerConfig:      serverConfig,
+			InfrastructureRef: kcp.Spec.MachineTemplate.InfrastructureRef,
+			NodeDrainTimeout:  kcp.Spec.MachineTemplate.NodeDrainTimeout,
+			RolloutAfter:      kcp.Spec.RolloutAfter,
+			RolloutBefore:     rolloutBefore,
+			RolloutStrategy:   rolloutStrategy,
+		},
+	}
+
+	return rcp, warnings, nil
+}
+
+// machineTemplateWarnings returns the warnings about the settings of the machines of the control plane that aren't
+// converted, the RKE2ControlPlane has no equivalent to them.
+func machineTemplateWarnings(kcp *kcpv1.KubeadmControlPlane) []string {
+	var warnings []string
+
+	machineTemplate := kcp.Spec.MachineTemplate
+
+	if len(machineTemplate.ObjectMeta.Labels) > 0 || len(machineTemplate.ObjectMeta.Annotations) > 0 {
+		warnings = append(warnings, "machineTemplate.metadata is not converted, the labels and annotations aren't set on the machines")
+	}
+
+	if machineTemplate.NodeDeletionTimeout != nil {
+		warnings = append(warnings, "machineTemplate.nodeDeletionTimeout is not converted, the default of the machines is used")
+	}
+
+	if machineTemplate.NodeVolumeDetachTimeout != nil {
+		warnings = append(warnings, "machineTemplate.nodeVolumeDetachTimeout is not converted, the default of the machines is used")
+	}
+
+	if kcp.Spec.RemediationStrategy != nil {
+		warnings = append(warnings, "remediationStrategy is not converted, the remediation of the servers isn't retried")
+	}
+
+	return warnings
+}
+
+// convertRolloutStrategy converts the surge of the rolling update of the control plane, the servers are replaced one at
+// a time so only a surge of 0 or 1 is supported, and a surge of 0 needs 3 servers to keep the etcd quorum. The
+// unsupported surges are returned as warnings, the default surge of 1 is used instead.
+func convertRolloutStrategy(kcp *kcpv1.KubeadmControlPlane) (*controlplanev1.RolloutStrategy, []string) {
+	if kcp.Spec.RolloutStrategy == nil || kcp.Spec.RolloutStrategy.RollingUpdate == nil ||
+		kcp.Spec.RolloutStrategy.RollingUpdate.MaxSurge == nil {
+		return nil, nil
+	}
+
+	maxSurge := kcp.Spec.RolloutStrategy.RollingUpdate.MaxSurge
+
+	switch {
+	case maxSurge.Type != intstr.Int || maxSurge.IntVal < 0 || maxSurge.IntVal > 1:
+		return nil, []string{fmt.Sprintf("rolloutStrategy.rollingUpdate.maxSurge %s is not converted, "+
+			"the servers are rolled out one at a time with a surge of 1", maxSurge.String())}
+	case maxSurge.IntVal == 0 && pointer.Int32Deref(kcp.Spec.Replicas, 1) < 3:
+		return nil, []string{"rolloutStrategy.rollingUpdate.maxSurge 0 is not converted as it requires at least 3 replicas, " +
+			"the servers are rolled out with a surge of 1"}
+	}
+
+	return &controlplanev1.RolloutStrategy{
+		Type:          controlplanev1.RollingUpdateStrategyType,
+		RollingUpdate: &controlplanev1.RollingUpdate{MaxSurge: maxSurge},
+	}, nil
+}
+
+// ConvertKubeadmConfigTemplate converts a KubeadmConfigTemplate of the worker machines to a RKE2ConfigTemplate of the
+// same name, the settings that can't be converted are returned as warnings.
+func ConvertKubeadmConfigTemplate(template *kubeadmv1.KubeadmConfigTemplate) (*bootstrapv1.RKE2ConfigTemplate, []string) {
+	kubeadmSpec := &template.Spec.Template.Spec
+
+	var nodeRegistration *kubeadmv1.NodeRegistrationOptions
+	if kubeadmSpec.JoinConfiguration != nil {
+		nodeRegistration = &kubeadmSpec.JoinConfiguration.NodeRegistration
+	}
+
+	configSpec, warnings := convertKubeadmConfigSpec(kubeadmSpec, nodeRegistration)
+
+	return &bootstrapv1.RKE2ConfigTemplate{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: bootstrapv1.GroupVersion.String(),
+			Kind:       "RKE2ConfigTemplate",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        template.Name,
+			Namespace:   template.Namespace,
+			Labels:      template.Labels,
+			Annotations: template.Annotations,
+		},
+		Spec: bootstrapv1.RKE2ConfigTemplateSpec{
+			Template: bootstrapv1.RKE2ConfigTemplateResource{Spec: *configSpec},
+		},
+	}, warnings
+}
+
+// initNodeRegistration returns the node registration of the first server.
+func initNodeRegistration(spec *kubeadmv1.KubeadmConfigSpec) *kubeadmv1.NodeRegistrationOptions {
+	switch {
+	case spec.InitConfiguration != nil:
+		return &spec.InitConfiguration.NodeRegistration
+	case spec.JoinConfiguration != nil:
+		return &spec.JoinConfiguration.NodeRegistration
+	default:
+		return nil
+	}
+}
+
+// convertKubeadmConfigSpec converts the settings common to the servers and the agents.
+func convertKubeadmConfigSpec(
+	spec *kubeadmv1.KubeadmConfigSpec,
+	nodeRegistration *kubeadmv1.NodeRegistrationOptions,
+) (*bootstrapv1.RKE2ConfigSpec, []string) {
+	var warnings []string
+
+	configSpec := &bootstrapv1.RKE2ConfigSpec{
+		PreRKE2Commands:  spec.PreKubeadmCommands,
+		PostRKE2Commands: spec.PostKubeadmCommands,
+	}
+
+	for _, commands := range [][]string{spec.PreKubeadmCommands, spec.PostKubeadmCommands} {
+		for _, command := range commands {
+			if strings.Contains(command, "kubeadm") {
+				warnings = append(warnings, fmt.Sprintf("command %q refers to kubeadm", command))
+			}
+		}
+	}
+
+	for _, file := range spec.Files {
+		if file.Append {
+			warnings = append(warnings, fmt.Sprintf("file %s is overwritten, appending to a file is not supported", file.Path))
+		}
+
+		converted := bootstrapv1.File{
+			Path:        file.Path,
+			Owner:       file.Owner,
+			Permissions: file.Permissions,
+			Encoding:    bootstrapv1.Encoding(file.Encoding),
+			Content:     file.Content,
+		}
+
+		if file.ContentFrom != nil {
+			converted.ContentFrom = &bootstrapv1.FileSource{
+				Secret: bootstrapv1.SecretFileSource{Name: file.ContentFrom.Secret.Name, Key: file.ContentFrom.Secret.Key},
+			}
+		}
+
+		configSpec.Files = append(configSpec.Files, converted)
+	}
+
+	if spec.NTP != nil {
+		configSpec.AgentConfig.NTP = &bootstrapv1.NTP{Servers: spec.NTP.Servers, Enabled: spec.NTP.Enabled}
+	}
+
+	if spec.Format == kubeadmv1.Ignition {
+		configSpec.AgentConfig.Format = bootstrapv1.Ignition
+	}
+
+	if len(spec.Users) > 0 {
+		warnings = append(warnings, "users are not converted, they can be created with additionalUserData")
+	}
+
+	if spec.DiskSetup != nil || len(spec.Mounts) > 0 {
+		warnings = append(warnings, "diskSetup and mounts are not converted, they can be set up with additionalUserData")
+	}
+
+	if spec.Ignition != nil {
+		warnings = append(warnings, "the ignition settings are not converted")
+	}
+
+	if nodeRegistration != nil {
+		warnings = append(warnings, convertNodeRegistration(nodeRegistration, &configSpec.AgentConfig)...)
+	}
+
+	return configSpec, warnings
+}
+
+// convertNodeRegistration converts the kubelet settings of the node registration to the agent configuration.
+func convertNodeRegistration(nodeRegistration *kubeadmv1.NodeRegistrationOptions, agentConfig *bootstrapv1.RKE2AgentConfig) []string {
+	var warnings []string
+
+	kubeletArgs := map[string]string{}
+
+	for name, value := range nodeRegistration.KubeletExtraArgs {
+		if name == "node-labels" {
+			agentConfig.NodeLabels = strings.Split(value, ",")
+
+			continue
+		}
+
+		kubeletArgs[name] = value
+	}
+
+	if len(kubeletArgs) > 0 {
+		agentConfig.Kubelet = &bootstrapv1.ComponentConfig{ExtraArgs: extraArgs(kubeletArgs)}
+	}
+
+	for _, taint := range nodeRegistration.Taints {
+		agentConfig.NodeTaints = append(agentConfig.NodeTaints, taint.ToString())
+	}
+
+	if nodeRegistration.Name != "" {
+		warnings = append(warnings, "nodeRegistration.name is not converted, the nodes are named after their machine")
+	}
+
+	if nodeRegistration.CRISocket != "" {
+		warnings = append(warnings, "nodeRegistration.criSocket is not converted, RKE2 runs its own containerd")
+	}
+
+	return warnings
+}
+
+// convertClusterConfiguration converts the settings of the control plane components, the registry of the images is
+// set on the agent configuration.
+func convertClusterConfiguration(
+	clusterConfig *kubeadmv1.ClusterConfiguration,
+	configSpec *bootstrapv1.RKE2ConfigSpec,
+) (controlplanev1.RKE2ServerConfig, []string) {
+	var (
+		serverConfig controlplanev1.RKE2ServerConfig
+		warnings     []string
+	)
+
+	if clusterConfig == nil {
+		return serverConfig, nil
+	}
+
+	serverConfig.TLSSan = clusterConfig.APIServer.CertSANs
+	serverConfig.ClusterDomain = clusterConfig.Networking.DNSDomain
+
+	for _, component := range []struct {
+		name      string
+		kubeadm   kubeadmv1.ControlPlaneComponent
+		converted **bootstrapv1.ComponentConfig
+	}{
+		{"apiServer", clusterConfig.APIServer.ControlPlaneComponent, &serverConfig.KubeAPIServer},
+		{"controllerManager", clusterConfig.ControllerManager, &serverConfig.KubeControllerManager},
+		{"scheduler", clusterConfig.Scheduler, &serverConfig.KubeScheduler},
+	} {
+		converted, componentWarnings := convertControlPlaneComponent(component.name, component.kubeadm)
+		*component.converted = converted
+		warnings = append(warnings, componentWarnings...)
+	}
+
+	if local := clusterConfig.Etcd.Local; local != nil {
+		if len(local.ExtraArgs) > 0 {
+			serverConfig.Etcd.CustomConfig = &bootstrapv1.ComponentConfig{ExtraArgs: extraArgs(local.ExtraArgs)}
+		}
+
+		if local.DataDir != "" || local.ImageRepository != "" || local.ImageTag != "" {
+			warnings = append(warnings, "the etcd data directory and image are not converted, RKE2 manages them")
+		}
+	}
+
+	if !defaultImageRepositories[clusterConfig.ImageRepository] {
+		configSpec.AgentConfig.SystemDefaultRegistry = clusterConfig.ImageRepository
+		warnings = append(warnings, fmt.Sprintf("imageRepository %s is the system default registry, it must serve the RKE2 images",
+			clusterConfig.ImageRepository))
+	}
+
+	if len(clusterConfig.FeatureGates) > 0 {
+		warnings = append(warnings, "the kubeadm feature gates are not converted")
+	}
+
+	return serverConfig, warnings
+}
+
+// convertControlPlaneComponent converts the arguments and volumes of a control plane component. The extra mounts are
+// keyed by host path, only the first volume of a host path is converted, and their read-only flag and path type are
+// dropped, they are returned as warnings.
+func convertControlPlaneComponent(name string, component kubeadmv1.ControlPlaneComponent) (*bootstrapv1.ComponentConfig, []string) {
+	if len(component.ExtraArgs) == 0 && len(component.ExtraVolumes) == 0 {
+		return nil, nil
+	}
+
+	var warnings []string
+
+	config := &bootstrapv1.ComponentConfig{ExtraArgs: extraArgs(component.ExtraArgs)}
+
+	for _, volume := range component.ExtraVolumes {
+		if config.ExtraMounts == nil {
+			config.ExtraMounts = map[string]string{}
+		}
+
+		if mountPath, ok := config.ExtraMounts[volume.HostPath]; ok {
+			warnings = append(warnings, fmt.Sprintf("%s.extraVolumes %s is not converted, host path %s is already mounted at %s",
+				name, volume.Name, volume.HostPath, mountPath))
+
+			continue
+		}
+
+		config.ExtraMounts[volume.HostPath] = volume.MountPath
+
+		if volume.ReadOnly {
+			warnings = append(warnings, fmt.Sprintf("%s.extraVolumes %s is mounted read-write, readOnly is not converted",
+				name, volume.Name))
+		}
+
+		if volume.PathType != "" {
+			warnings = append(warnings, fmt.Sprintf("%s.extraVolumes %s pathType is not converted, the host path isn't checked",
+				name, volume.Name))
+		}
+	}
+
+	return config, warnings
+}
+
+// extraArgs returns the arguments in the name=value form, sorted by name.
+func extraArgs(args map[string]string) []string {
+	if len(args) == 0 {
+		return nil
+	}
+
+	converted := make([]string, 0, len(args))
+	for name, value := range args {
+		converted = append(converted, name+"="+value)
+	}
+
+	sort.Strings(converted)
+
+	return converted
+}
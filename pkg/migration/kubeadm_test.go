@@ -0,0 +1,213 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package migration
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/utils/pointer"
+
+	kubeadmv1 "sigs.k8s.io/cluster-api/bootstrap/kubeadm/api/v1beta1"
+	kcpv1 "sigs.k8s.io/cluster-api/controlplane/kubeadm/api/v1beta1"
+
+	bootstrapv1 "github.com/rancher-sandbox/cluster-api-provider-rke2/bootstrap/api/v1alpha1"
+)
+
+var _ = Describe("ConvertKubeadmControlPlane", func() {
+	var kcp *kcpv1.KubeadmControlPlane
+
+	BeforeEach(func() {
+		kcp = &kcpv1.KubeadmControlPlane{
+			ObjectMeta: metav1.ObjectMeta{Name: "control-plane", Namespace: "default"},
+			Spec: kcpv1.KubeadmControlPlaneSpec{
+				Replicas: pointer.Int32(3),
+				Version:  "v1.26.4",
+				MachineTemplate: kcpv1.KubeadmControlPlaneMachineTemplate{
+					InfrastructureRef: corev1.ObjectReference{Kind: "DockerMachineTemplate", Name: "control-plane"},
+				},
+				KubeadmConfigSpec: kubeadmv1.KubeadmConfigSpec{
+					ClusterConfiguration: &kubeadmv1.ClusterConfiguration{
+						APIServer: kubeadmv1.APIServer{
+							ControlPlaneComponent: kubeadmv1.ControlPlaneComponent{
+								ExtraArgs: map[string]string{"v": "2", "audit-log-maxage": "30"},
+								ExtraVolumes: []kubeadmv1.HostPathMount{
+									{Name: "audit", HostPath: "/var/log/audit", MountPath: "/var/log/kubernetes"},
+								},
+							},
+							CertSANs: []string{"api.example.com"},
+						},
+						Networking:      kubeadmv1.Networking{DNSDomain: "cluster.example"},
+						ImageRepository: "registry.example.com",
+					},
+					InitConfiguration: &kubeadmv1.InitConfiguration{
+						NodeRegistration: kubeadmv1.NodeRegistrationOptions{
+							KubeletExtraArgs: map[string]string{"node-labels": "tier=control-plane,zone=a", "max-pods": "200"},
+							Taints:           []corev1.Taint{{Key: "dedicated", Value: "control-plane", Effect: corev1.TaintEffectNoSchedule}},
+						},
+					},
+					Files:              []kubeadmv1.File{{Path: "/etc/motd", Content: "hello", Encoding: kubeadmv1.Base64}},
+					PreKubeadmCommands: []string{"echo pre"},
+					NTP:                &kubeadmv1.NTP{Servers: []string{"ntp.example.com"}},
+				},
+			},
+		}
+	})
+
+	It("should convert the control plane", func() {
+		rcp, warnings, err := ConvertKubeadmControlPlane(kcp, "v1.26.4+rke2r1")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(rcp.Name).To(Equal("control-plane"))
+		Expect(rcp.Spec.Replicas).To(Equal(pointer.Int32(3)))
+		Expect(rcp.Spec.InfrastructureRef.Kind).To(Equal("DockerMachineTemplate"))
+		Expect(rcp.Spec.AgentConfig.Version).To(Equal("v1.26.4+rke2r1"))
+		Expect(rcp.Spec.ServerConfig.TLSSan).To(Equal([]string{"api.example.com"}))
+		Expect(rcp.Spec.ServerConfig.ClusterDomain).To(Equal("cluster.example"))
+		Expect(rcp.Spec.ServerConfig.KubeAPIServer).To(Equal(&bootstrapv1.ComponentConfig{
+			ExtraArgs:   []string{"audit-log-maxage=30", "v=2"},
+			ExtraMounts: map[string]string{"/var/log/audit": "/var/log/kubernetes"},
+		}))
+		Expect(rcp.Spec.AgentConfig.SystemDefaultRegistry).To(Equal("registry.example.com"))
+		Expect(rcp.Spec.AgentConfig.NodeLabels).To(Equal([]string{"tier=control-plane", "zone=a"}))
+		Expect(rcp.Spec.AgentConfig.NodeTaints).To(Equal([]string{"dedicated=control-plane:NoSchedule"}))
+		Expect(rcp.Spec.AgentConfig.Kubelet.ExtraArgs).To(Equal([]string{"max-pods=200"}))
+		Expect(rcp.Spec.AgentConfig.NTP.Servers).To(Equal([]string{"ntp.example.com"}))
+		Expect(rcp.Spec.Files).To(Equal([]bootstrapv1.File{{Path: "/etc/motd", Content: "hello", Encoding: bootstrapv1.Base64}}))
+		Expect(rcp.Spec.PreRKE2Commands).To(Equal([]string{"echo pre"}))
+		Expect(rcp.Spec.RolloutStrategy).To(BeNil())
+		Expect(warnings).To(HaveLen(1))
+	})
+
+	It("should convert the rollout strategy", func() {
+		maxSurge := intstr.FromInt(0)
+		kcp.Spec.RolloutStrategy = &kcpv1.RolloutStrategy{
+			Type:          kcpv1.RollingUpdateStrategyType,
+			RollingUpdate: &kcpv1.RollingUpdate{MaxSurge: &maxSurge},
+		}
+
+		rcp, warnings, err := ConvertKubeadmControlPlane(kcp, "v1.26.4+rke2r1")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(rcp.Spec.MaxSurge()).To(Equal(int32(0)))
+		Expect(warnings).ToNot(ContainElement(ContainSubstring("maxSurge")))
+	})
+
+	It("should warn about the surges that aren't supported", func() {
+		for _, maxSurge := range []intstr.IntOrString{intstr.FromString("50%"), intstr.FromInt(2), intstr.FromInt(0)} {
+			maxSurge := maxSurge
+			kcp.Spec.Replicas = pointer.Int32(1)
+			kcp.Spec.RolloutStrategy = &kcpv1.RolloutStrategy{
+				Type:          kcpv1.RollingUpdateStrategyType,
+				RollingUpdate: &kcpv1.RollingUpdate{MaxSurge: &maxSurge},
+			}
+
+			rcp, warnings, err := ConvertKubeadmControlPlane(kcp, "v1.26.4+rke2r1")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(rcp.Spec.RolloutStrategy).To(BeNil(), maxSurge.String())
+			Expect(warnings).To(ContainElement(ContainSubstring("maxSurge " + maxSurge.String())))
+		}
+	})
+
+	It("should convert the rollout before certificates expiry", func() {
+		kcp.Spec.RolloutBefore = &kcpv1.RolloutBefore{CertificatesExpiryDays: pointer.Int32(21)}
+
+		rcp, _, err := ConvertKubeadmControlPlane(kcp, "v1.26.4+rke2r1")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(rcp.Spec.RolloutBefore.CertificatesExpiryDays).To(Equal(pointer.Int32(21)))
+	})
+
+	It("should warn about the settings that aren't converted", func() {
+		kcp.Spec.KubeadmConfigSpec.Users = []kubeadmv1.User{{Name: "admin"}}
+		kcp.Spec.KubeadmConfigSpec.PostKubeadmCommands = []string{"kubeadm token list"}
+
+		_, warnings, err := ConvertKubeadmControlPlane(kcp, "v1.26.4+rke2r1")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(warnings).To(HaveLen(3))
+	})
+
+	It("should warn about the machine template and remediation settings that aren't converted", func() {
+		kcp.Spec.MachineTemplate.ObjectMeta.Labels = map[string]string{"tier": "control-plane"}
+		kcp.Spec.MachineTemplate.NodeDeletionTimeout = &metav1.Duration{Duration: time.Minute}
+		kcp.Spec.MachineTemplate.NodeVolumeDetachTimeout = &metav1.Duration{Duration: time.Minute}
+		kcp.Spec.RemediationStrategy = &kcpv1.RemediationStrategy{MaxRetry: pointer.Int32(3)}
+
+		_, warnings, err := ConvertKubeadmControlPlane(kcp, "v1.26.4+rke2r1")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(warnings).To(ContainElements(
+			ContainSubstring("machineTemplate.metadata"),
+			ContainSubstring("machineTemplate.nodeDeletionTimeout"),
+			ContainSubstring("machineTemplate.nodeVolumeDetachTimeout"),
+			ContainSubstring("remediationStrategy"),
+		))
+	})
+
+	It("should warn about the extra volumes that can't be converted", func() {
+		apiServer := &kcp.Spec.KubeadmConfigSpec.ClusterConfiguration.APIServer
+		apiServer.ExtraVolumes = []kubeadmv1.HostPathMount{
+			{Name: "audit", HostPath: "/var/log/audit", MountPath: "/var/log/kubernetes", ReadOnly: true, PathType: corev1.HostPathDirectory},
+			{Name: "audit-copy", HostPath: "/var/log/audit", MountPath: "/var/log/audit"},
+		}
+
+		rcp, warnings, err := ConvertKubeadmControlPlane(kcp, "v1.26.4+rke2r1")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(rcp.Spec.ServerConfig.KubeAPIServer.ExtraMounts).To(Equal(map[string]string{"/var/log/audit": "/var/log/kubernetes"}))
+		Expect(warnings).To(ContainElements(
+			ContainSubstring("apiServer.extraVolumes audit is mounted read-write"),
+			ContainSubstring("apiServer.extraVolumes audit pathType"),
+			ContainSubstring("apiServer.extraVolumes audit-copy is not converted"),
+		))
+	})
+
+	It("should refuse to upgrade or to convert an external etcd", func() {
+		_, _, err := ConvertKubeadmControlPlane(kcp, "v1.27.1+rke2r1")
+		Expect(errors.Is(err, ErrVersionMismatch)).To(BeTrue())
+
+		kcp.Spec.KubeadmConfigSpec.ClusterConfiguration.Etcd.External = &kubeadmv1.ExternalEtcd{}
+		_, _, err = ConvertKubeadmControlPlane(kcp, "v1.26.4+rke2r1")
+		Expect(errors.Is(err, ErrExternalEtcd)).To(BeTrue())
+	})
+})
+
+var _ = Describe("ConvertKubeadmConfigTemplate", func() {
+	It("should convert the worker configuration from the join configuration", func() {
+		template := &kubeadmv1.KubeadmConfigTemplate{
+			ObjectMeta: metav1.ObjectMeta{Name: "workers", Namespace: "default"},
+			Spec: kubeadmv1.KubeadmConfigTemplateSpec{
+				Template: kubeadmv1.KubeadmConfigTemplateResource{
+					Spec: kubeadmv1.KubeadmConfigSpec{
+						JoinConfiguration: &kubeadmv1.JoinConfiguration{
+							NodeRegistration: kubeadmv1.NodeRegistrationOptions{
+								KubeletExtraArgs: map[string]string{"node-labels": "tier=workers"},
+							},
+						},
+					},
+				},
+			},
+		}
+
+		converted, warnings := ConvertKubeadmConfigTemplate(template)
+		Expect(warnings).To(BeEmpty())
+		Expect(converted.Name).To(Equal("workers"))
+		Expect(converted.Spec.Template.Spec.AgentConfig.NodeLabels).To(Equal([]string{"tier=workers"}))
+		Expect(converted.Spec.Template.Spec.AgentConfig.Kubelet).To(BeNil())
+	})
+})
@@ -0,0 +1,146 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package observer implements the read-only observer mode of the controllers, meant for audit environments: the
+// changes the controllers would make are logged instead of being made.
+package observer
+
+import (
+	"context"
+	"fmt"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/cluster"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// NewClient returns a client reading through the given client, which logs the writes instead of making them.
+// The subject access reviews are still created, as they are not persisted.
+func NewClient(c client.Client) client.Client {
+	return &observerClient{Client: c}
+}
+
+// NewClientFunc returns the function creating the observer client of a manager.
+func NewClientFunc() cluster.NewClientFunc {
+	return func(cache cache.Cache, config *rest.Config, options client.Options, uncachedObjects ...client.Object) (client.Client, error) {
+		c, err := cluster.DefaultNewClient(cache, config, options, uncachedObjects...)
+		if err != nil {
+			return nil, err
+		}
+
+		return NewClient(c), nil
+	}
+}
+
+type observerClient struct {
+	client.Client
+}
+
+func (c *observerClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	if _, ok := obj.(*authorizationv1.SubjectAccessReview); ok {
+		return c.Client.Create(ctx, obj, opts...)
+	}
+
+	c.logWrite(ctx, "create", obj, "")
+
+	return nil
+}
+
+func (c *observerClient) Delete(ctx context.Context, obj client.Object, _ ...client.DeleteOption) error {
+	c.logWrite(ctx, "delete", obj, "")
+
+	return nil
+}
+
+func (c *observerClient) Update(ctx context.Context, obj client.Object, _ ...client.UpdateOption) error {
+	c.logWrite(ctx, "update", obj, "")
+
+	return nil
+}
+
+func (c *observerClient) Patch(ctx context.Context, obj client.Object, _ client.Patch, _ ...client.PatchOption) error {
+	c.logWrite(ctx, "patch", obj, "")
+
+	return nil
+}
+
+func (c *observerClient) DeleteAllOf(ctx context.Context, obj client.Object, _ ...client.DeleteAllOfOption) error {
+	c.logWrite(ctx, "deletecollection", obj, "")
+
+	return nil
+}
+
+func (c *observerClient) Status() client.SubResourceWriter {
+	return &observerSubResourceClient{SubResourceClient: c.Client.SubResource("status"), client: c, subResource: "status"}
+}
+
+func (c *observerClient) SubResource(subResource string) client.SubResourceClient {
+	return &observerSubResourceClient{SubResourceClient: c.Client.SubResource(subResource), client: c, subResource: subResource}
+}
+
+// logWrite logs a write that is not made.
+func (c *observerClient) logWrite(ctx context.Context, verb string, obj client.Object, subResource string) {
+	kind := fmt.Sprintf("%T", obj)
+	if gvk, err := apiutil.GVKForObject(obj, c.Scheme()); err == nil {
+		kind = gvk.Kind
+	}
+
+	keysAndValues := []interface{}{"verb", verb, "kind", kind, "object", klog.KObj(obj)}
+	if subResource != "" {
+		keysAndValues = append(keysAndValues, "subResource", subResource)
+	}
+
+	log.FromContext(ctx).Info("Not writing in observer mode", keysAndValues...)
+}
+
+type observerSubResourceClient struct {
+	client.SubResourceClient
+	client      *observerClient
+	subResource string
+}
+
+func (c *observerSubResourceClient) Create(
+	ctx context.Context,
+	obj client.Object,
+	_ client.Object,
+	_ ...client.SubResourceCreateOption,
+) error {
+	c.client.logWrite(ctx, "create", obj, c.subResource)
+
+	return nil
+}
+
+func (c *observerSubResourceClient) Update(ctx context.Context, obj client.Object, _ ...client.SubResourceUpdateOption) error {
+	c.client.logWrite(ctx, "update", obj, c.subResource)
+
+	return nil
+}
+
+func (c *observerSubResourceClient) Patch(
+	ctx context.Context,
+	obj client.Object,
+	_ client.Patch,
+	_ ...client.SubResourcePatchOption,
+) error {
+	c.client.logWrite(ctx, "patch", obj, c.subResource)
+
+	return nil
+}
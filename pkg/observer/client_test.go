@@ -0,0 +1,79 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package observer
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("NewClient", func() {
+	var (
+		ctx        context.Context
+		existing   *corev1.ConfigMap
+		fakeClient client.Client
+		observer   client.Client
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		existing = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "existing", Namespace: "default"},
+			Data:       map[string]string{"key": "value"},
+		}
+		fakeClient = fake.NewClientBuilder().WithObjects(existing).Build()
+		observer = NewClient(fakeClient)
+	})
+
+	It("should read through the client", func() {
+		configMap := &corev1.ConfigMap{}
+		Expect(observer.Get(ctx, client.ObjectKeyFromObject(existing), configMap)).To(Succeed())
+		Expect(configMap.Data).To(Equal(existing.Data))
+	})
+
+	It("should not write", func() {
+		Expect(observer.Create(ctx, &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "created", Namespace: "default"},
+		})).To(Succeed())
+		Expect(fakeClient.Get(ctx, client.ObjectKey{Name: "created", Namespace: "default"}, &corev1.ConfigMap{})).ToNot(Succeed())
+
+		updated := existing.DeepCopy()
+		updated.Data["key"] = "updated"
+		Expect(observer.Update(ctx, updated)).To(Succeed())
+		Expect(observer.Patch(ctx, updated, client.MergeFrom(existing))).To(Succeed())
+		Expect(observer.Status().Update(ctx, updated)).To(Succeed())
+		Expect(observer.Delete(ctx, existing)).To(Succeed())
+
+		configMap := &corev1.ConfigMap{}
+		Expect(fakeClient.Get(ctx, client.ObjectKeyFromObject(existing), configMap)).To(Succeed())
+		Expect(configMap.Data).To(Equal(map[string]string{"key": "value"}))
+	})
+
+	It("should create the subject access reviews", func() {
+		sar := &authorizationv1.SubjectAccessReview{ObjectMeta: metav1.ObjectMeta{Name: "review"}}
+		Expect(observer.Create(ctx, sar)).To(Succeed())
+		Expect(fakeClient.Get(ctx, client.ObjectKeyFromObject(sar), &authorizationv1.SubjectAccessReview{})).To(Succeed())
+	})
+})
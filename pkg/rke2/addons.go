@@ -0,0 +1,277 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rke2
+
+import (
+	"context"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	kerrors "k8s.io/apimachinery/pkg/util/errors"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+
+	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+)
+
+const (
+	// HelmChartNamespace is the namespace of the HelmChart resources watched by the RKE2 Helm controller.
+	HelmChartNamespace = "kube-system"
+
+	// manifestDecoderBufferSize is the size of the buffer used to find the start of each YAML document.
+	manifestDecoderBufferSize = 4096
+
+	// NodeProblemDetectorAddOn is the suffix of the name of the RKE2AddOn installing node-problem-detector.
+	NodeProblemDetectorAddOn = "node-problem-detector"
+
+	// LogShippingAddOn is the suffix of the name of the RKE2AddOn installing the log shipping agent.
+	LogShippingAddOn = "log-shipping"
+)
+
+// observabilityChart is the default chart of an observability add-on.
+type observabilityChart struct {
+	repo  string
+	chart string
+}
+
+var observabilityCharts = map[string]observabilityChart{
+	NodeProblemDetectorAddOn: {repo: "https://charts.deliveryhero.io", chart: "node-problem-detector"},
+	LogShippingAddOn:         {repo: "https://fluent.github.io/helm-charts", chart: "fluent-bit"},
+}
+
+// ParseManifests parses YAML or JSON manifests, separated by "---", into unstructured objects.
+// Empty documents are skipped.
+func ParseManifests(manifests string) ([]*unstructured.Unstructured, error) {
+	objs := []*unstructured.Unstructured{}
+	decoder := utilyaml.NewYAMLOrJSONDecoder(strings.NewReader(manifests), manifestDecoderBufferSize)
+
+	for {
+		obj := &unstructured.Unstructured{}
+
+		if err := decoder.Decode(&obj.Object); err != nil {
+			if errors.Is(err, io.EOF) {
+				return objs, nil
+			}
+
+			return nil, errors.Wrap(err, "failed to decode manifest")
+		}
+
+		if len(obj.Object) == 0 {
+			continue
+		}
+
+		if obj.GetAPIVersion() == "" || obj.GetKind() == "" || obj.GetName() == "" {
+			return nil, errors.New("manifest must have an apiVersion, a kind and a name")
+		}
+
+		objs = append(objs, obj)
+	}
+}
+
+// HelmChartManifest returns the HelmChart resource installing the chart with the RKE2 Helm controller.
+func HelmChartManifest(chart *controlplanev1.RKE2AddOnChart) *unstructured.Unstructured {
+	spec := map[string]interface{}{
+		"chart":           chart.Chart,
+		"createNamespace": true,
+	}
+
+	if chart.Version != "" {
+		spec["version"] = chart.Version
+	}
+
+	if chart.TargetNamespace != "" {
+		spec["targetNamespace"] = chart.TargetNamespace
+	}
+
+	if chart.ValuesContent != "" {
+		spec["valuesContent"] = chart.ValuesContent
+	}
+
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{"spec": spec}}
+	obj.SetAPIVersion("helm.cattle.io/v1")
+	obj.SetKind("HelmChart")
+	obj.SetNamespace(HelmChartNamespace)
+	obj.SetName(chart.Name)
+
+	return obj
+}
+
+// ObservabilityAddOns returns the RKE2AddOns installing the enabled observability add-ons of the control plane on
+// the cluster, and the names of the RKE2AddOns of the disabled ones. The RKE2AddOns are controlled by the control plane.
+func ObservabilityAddOns(
+	rcp *controlplanev1.RKE2ControlPlane,
+	clusterName string,
+) (enabled []*controlplanev1.RKE2AddOn, disabled []string, err error) {
+	addOns := map[string]*controlplanev1.ObservabilityAddOn{}
+	if rcp.Spec.Observability != nil {
+		addOns[NodeProblemDetectorAddOn] = rcp.Spec.Observability.NodeProblemDetector
+		addOns[LogShippingAddOn] = rcp.Spec.Observability.LogShipping
+	}
+
+	for _, suffix := range []string{NodeProblemDetectorAddOn, LogShippingAddOn} {
+		name := rcp.Name + "-" + suffix
+
+		addOn := addOns[suffix]
+		if addOn == nil || !addOn.Enabled {
+			disabled = append(disabled, name)
+
+			continue
+		}
+
+		manifest, err := yaml.Marshal(observabilityHelmChart(suffix, addOn).Object)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "failed to marshal the HelmChart of %s", suffix)
+		}
+
+		enabled = append(enabled, &controlplanev1.RKE2AddOn{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: rcp.Namespace,
+				Labels:    map[string]string{clusterv1.ClusterNameLabel: clusterName},
+				OwnerReferences: []metav1.OwnerReference{
+					*metav1.NewControllerRef(rcp, controlplanev1.GroupVersion.WithKind("RKE2ControlPlane")),
+				},
+			},
+			Spec: controlplanev1.RKE2AddOnSpec{
+				ClusterName: clusterName,
+				Inline:      string(manifest),
+				Scheduling:  addOn.Scheduling,
+			},
+		})
+	}
+
+	return enabled, disabled, nil
+}
+
+// observabilityHelmChart returns the HelmChart resource installing an observability add-on with the RKE2 Helm
+// controller, the default chart of the add-on is used unless a chart is set.
+func observabilityHelmChart(suffix string, addOn *controlplanev1.ObservabilityAddOn) *unstructured.Unstructured {
+	defaults := observabilityCharts[suffix]
+	spec := map[string]interface{}{
+		"chart":           defaults.chart,
+		"repo":            defaults.repo,
+		"targetNamespace": HelmChartNamespace,
+		"createNamespace": true,
+	}
+
+	if addOn.Chart != "" {
+		spec["chart"] = addOn.Chart
+		delete(spec, "repo")
+	}
+
+	if addOn.Repo != "" {
+		spec["repo"] = addOn.Repo
+	}
+
+	if addOn.Version != "" {
+		spec["version"] = addOn.Version
+	}
+
+	if addOn.TargetNamespace != "" {
+		spec["targetNamespace"] = addOn.TargetNamespace
+	}
+
+	if addOn.ValuesContent != "" {
+		spec["valuesContent"] = addOn.ValuesContent
+	}
+
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{"spec": spec}}
+	obj.SetAPIVersion("helm.cattle.io/v1")
+	obj.SetKind("HelmChart")
+	obj.SetNamespace(HelmChartNamespace)
+	obj.SetName(suffix)
+
+	return obj
+}
+
+// ApplyManifests creates or updates the objects on the workload cluster, namespaced objects without a namespace are
+// created in the "default" namespace. It returns the references of the applied objects.
+func (w *Workload) ApplyManifests(ctx context.Context, objs []*unstructured.Unstructured) ([]controlplanev1.RKE2AddOnResource, error) {
+	resources := []controlplanev1.RKE2AddOnResource{}
+
+	for _, obj := range objs {
+		gvk := obj.GroupVersionKind()
+
+		mapping, err := w.Client.RESTMapper().RESTMapping(gvk.GroupKind(), gvk.Version)
+		if err != nil {
+			return resources, errors.Wrapf(err, "failed to get the resource of %s", gvk)
+		}
+
+		if mapping.Scope.Name() == meta.RESTScopeNameNamespace && obj.GetNamespace() == "" {
+			obj.SetNamespace(metav1.NamespaceDefault)
+		}
+
+		if err := w.applyManifest(ctx, obj); err != nil {
+			return resources, errors.Wrapf(err, "failed to apply %s %s", gvk.Kind, ctrlclient.ObjectKeyFromObject(obj))
+		}
+
+		resources = append(resources, controlplanev1.RKE2AddOnResource{
+			APIVersion: obj.GetAPIVersion(),
+			Kind:       obj.GetKind(),
+			Namespace:  obj.GetNamespace(),
+			Name:       obj.GetName(),
+		})
+	}
+
+	return resources, nil
+}
+
+func (w *Workload) applyManifest(ctx context.Context, obj *unstructured.Unstructured) error {
+	existing := &unstructured.Unstructured{}
+	existing.SetGroupVersionKind(obj.GroupVersionKind())
+
+	err := w.Client.Get(ctx, ctrlclient.ObjectKeyFromObject(obj), existing)
+
+	switch {
+	case apierrors.IsNotFound(err):
+		return w.Client.Create(ctx, obj)
+	case err != nil:
+		return err
+	}
+
+	obj.SetResourceVersion(existing.GetResourceVersion())
+
+	return w.Client.Update(ctx, obj)
+}
+
+// DeleteManifests deletes the referenced objects from the workload cluster, objects that no longer exist are ignored.
+func (w *Workload) DeleteManifests(ctx context.Context, resources []controlplanev1.RKE2AddOnResource) error {
+	errs := []error{}
+
+	for _, resource := range resources {
+		obj := &unstructured.Unstructured{}
+		obj.SetAPIVersion(resource.APIVersion)
+		obj.SetKind(resource.Kind)
+		obj.SetNamespace(resource.Namespace)
+		obj.SetName(resource.Name)
+
+		err := w.Client.Delete(ctx, obj, ctrlclient.PropagationPolicy(metav1.DeletePropagationBackground))
+		if err != nil && !apierrors.IsNotFound(err) && !meta.IsNoMatchError(err) {
+			errs = append(errs, errors.Wrapf(err, "failed to delete %s %s/%s", resource.Kind, resource.Namespace, resource.Name))
+		}
+	}
+
+	return kerrors.NewAggregate(errs)
+}
@@ -0,0 +1,190 @@
+/*
+Copyright 2023 SUSE.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rke2
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+)
+
+const addOnManifests = `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: app-config
+data:
+  key: value
+---
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: app-role
+`
+
+var _ = Describe("AddOns", func() {
+	var workload *Workload
+
+	BeforeEach(func() {
+		mapper := meta.NewDefaultRESTMapper(nil)
+		mapper.Add(corev1.SchemeGroupVersion.WithKind("ConfigMap"), meta.RESTScopeNamespace)
+		mapper.Add(rbacv1.SchemeGroupVersion.WithKind("ClusterRole"), meta.RESTScopeRoot)
+
+		workload = &Workload{
+			Client: fake.NewClientBuilder().WithRESTMapper(mapper).Build(),
+		}
+	})
+
+	It("should skip empty documents when parsing manifests", func() {
+		objs, err := ParseManifests(addOnManifests)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(objs).To(HaveLen(2))
+		Expect(objs[0].GetKind()).To(Equal("ConfigMap"))
+		Expect(objs[1].GetKind()).To(Equal("ClusterRole"))
+	})
+
+	It("should fail to parse manifests without a name", func() {
+		_, err := ParseManifests("apiVersion: v1\nkind: ConfigMap\n")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should apply, update and delete the manifests", func() {
+		objs, err := ParseManifests(addOnManifests)
+		Expect(err).ToNot(HaveOccurred())
+
+		resources, err := workload.ApplyManifests(context.Background(), objs)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(resources).To(Equal([]controlplanev1.RKE2AddOnResource{
+			{APIVersion: "v1", Kind: "ConfigMap", Namespace: "default", Name: "app-config"},
+			{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "ClusterRole", Name: "app-role"},
+		}))
+
+		objs, err = ParseManifests(addOnManifests)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(unstructured.SetNestedField(objs[0].Object, "updated", "data", "key")).To(Succeed())
+
+		_, err = workload.ApplyManifests(context.Background(), objs)
+		Expect(err).ToNot(HaveOccurred())
+
+		configMap := &corev1.ConfigMap{}
+		configMapKey := types.NamespacedName{Namespace: "default", Name: "app-config"}
+		Expect(workload.Client.Get(context.Background(), configMapKey, configMap)).To(Succeed())
+		Expect(configMap.Data).To(HaveKeyWithValue("key", "updated"))
+
+		Expect(workload.DeleteManifests(context.Background(), resources)).To(Succeed())
+		Expect(workload.DeleteManifests(context.Background(), resources)).To(Succeed())
+
+		configMaps := &corev1.ConfigMapList{}
+		Expect(workload.Client.List(context.Background(), configMaps)).To(Succeed())
+		Expect(configMaps.Items).To(BeEmpty())
+	})
+
+	It("should render a HelmChart for a chart", func() {
+		obj := HelmChartManifest(&controlplanev1.RKE2AddOnChart{
+			Name:            "app",
+			Chart:           "oci://registry.example.com/charts/app",
+			Version:         "1.0.0",
+			TargetNamespace: "app",
+		})
+		Expect(obj.GetAPIVersion()).To(Equal("helm.cattle.io/v1"))
+		Expect(obj.GetKind()).To(Equal("HelmChart"))
+		Expect(obj.GetNamespace()).To(Equal(HelmChartNamespace))
+		Expect(obj.Object["spec"]).To(HaveKeyWithValue("chart", "oci://registry.example.com/charts/app"))
+		Expect(obj.Object["spec"]).To(HaveKeyWithValue("version", "1.0.0"))
+		Expect(obj.Object["spec"]).To(HaveKeyWithValue("targetNamespace", "app"))
+		Expect(obj.Object["spec"]).ToNot(HaveKey("valuesContent"))
+	})
+})
+
+var _ = Describe("ObservabilityAddOns", func() {
+	var rcp *controlplanev1.RKE2ControlPlane
+
+	BeforeEach(func() {
+		rcp = &controlplanev1.RKE2ControlPlane{
+			ObjectMeta: metav1.ObjectMeta{Name: "rcp", Namespace: "ns", UID: "uid"},
+		}
+	})
+
+	It("should return the names of all the add-ons when observability is not set", func() {
+		enabled, disabled, err := ObservabilityAddOns(rcp, "cluster")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(enabled).To(BeEmpty())
+		Expect(disabled).To(ConsistOf("rcp-node-problem-detector", "rcp-log-shipping"))
+	})
+
+	It("should install the default chart of an enabled add-on", func() {
+		rcp.Spec.Observability = &controlplanev1.Observability{
+			NodeProblemDetector: &controlplanev1.ObservabilityAddOn{Enabled: true},
+			LogShipping:         &controlplanev1.ObservabilityAddOn{Enabled: false},
+		}
+
+		enabled, disabled, err := ObservabilityAddOns(rcp, "cluster")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(disabled).To(ConsistOf("rcp-log-shipping"))
+		Expect(enabled).To(HaveLen(1))
+		Expect(enabled[0].Name).To(Equal("rcp-node-problem-detector"))
+		Expect(enabled[0].Namespace).To(Equal("ns"))
+		Expect(enabled[0].Spec.ClusterName).To(Equal("cluster"))
+		Expect(metav1.IsControlledBy(enabled[0], rcp)).To(BeTrue())
+
+		objs, err := ParseManifests(enabled[0].Spec.Inline)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(objs).To(HaveLen(1))
+		Expect(objs[0].GetKind()).To(Equal("HelmChart"))
+		Expect(objs[0].GetNamespace()).To(Equal(HelmChartNamespace))
+		Expect(objs[0].Object["spec"]).To(HaveKeyWithValue("chart", "node-problem-detector"))
+		Expect(objs[0].Object["spec"]).To(HaveKeyWithValue("repo", "https://charts.deliveryhero.io"))
+		Expect(objs[0].Object["spec"]).To(HaveKeyWithValue("targetNamespace", "kube-system"))
+	})
+
+	It("should install the configured chart with its values", func() {
+		rcp.Spec.Observability = &controlplanev1.Observability{
+			LogShipping: &controlplanev1.ObservabilityAddOn{
+				Enabled:         true,
+				Chart:           "oci://registry.example.com/charts/fluent-bit",
+				Version:         "0.37.0",
+				TargetNamespace: "logging",
+				ValuesContent:   "config:\n  outputs: ''\n",
+			},
+		}
+
+		enabled, _, err := ObservabilityAddOns(rcp, "cluster")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(enabled).To(HaveLen(1))
+
+		objs, err := ParseManifests(enabled[0].Spec.Inline)
+		Expect(err).ToNot(HaveOccurred())
+
+		spec := objs[0].Object["spec"]
+		Expect(spec).ToNot(HaveKey("repo"))
+		Expect(spec).To(HaveKeyWithValue("chart", "oci://registry.example.com/charts/fluent-bit"))
+		Expect(spec).To(HaveKeyWithValue("version", "0.37.0"))
+		Expect(spec).To(HaveKeyWithValue("targetNamespace", "logging"))
+		Expect(spec).To(HaveKeyWithValue("valuesContent", "config:\n  outputs: ''\n"))
+	})
+})
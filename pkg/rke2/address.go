@@ -0,0 +1,57 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rke2
+
+import (
+	"net"
+	"strconv"
+	"strings"
+)
+
+// HostPort joins a host name or an IP address and a port, IPv6 addresses are enclosed in brackets. The host may
+// already be enclosed in brackets.
+func HostPort(host string, port int) string {
+	return net.JoinHostPort(strings.TrimSuffix(strings.TrimPrefix(host, "["), "]"), strconv.Itoa(port))
+}
+
+// ServerURL returns the HTTPS URL of a server listening on the given host and port.
+func ServerURL(host string, port int) string {
+	return "https://" + HostPort(host, port)
+}
+
+// WildcardAddress returns the wildcard address servers listen on for the comma separated CIDRs of a cluster: the IPv6
+// wildcard address as soon as one of the CIDRs is an IPv6 one, as it accepts the IPv4 connections of the dual-stack
+// clusters as well, the IPv4 one otherwise.
+func WildcardAddress(cidrs string) string {
+	for _, cidr := range strings.Split(cidrs, ",") {
+		if ip, _, err := net.ParseCIDR(strings.TrimSpace(cidr)); err == nil && ip.To4() == nil {
+			return "::"
+		}
+	}
+
+	return "0.0.0.0"
+}
+
+// NormalizeAddress returns the canonical form of an IP address, so that the different forms of an IPv6 address
+// compare equal. Host names are returned unchanged.
+func NormalizeAddress(address string) string {
+	if ip := net.ParseIP(strings.TrimSuffix(strings.TrimPrefix(address, "["), "]")); ip != nil {
+		return ip.String()
+	}
+
+	return address
+}
@@ -0,0 +1,46 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rke2
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("addresses", func() {
+	It("should join hosts and ports", func() {
+		Expect(HostPort("10.0.0.1", 9345)).To(Equal("10.0.0.1:9345"))
+		Expect(HostPort("cp.example.com", 9345)).To(Equal("cp.example.com:9345"))
+		Expect(HostPort("fd00::1", 9345)).To(Equal("[fd00::1]:9345"))
+		Expect(HostPort("[fd00::1]", 9345)).To(Equal("[fd00::1]:9345"))
+		Expect(ServerURL("fd00::1", 6443)).To(Equal("https://[fd00::1]:6443"))
+	})
+
+	It("should listen on the IPv6 wildcard address when a CIDR is an IPv6 one", func() {
+		Expect(WildcardAddress("10.42.0.0/16")).To(Equal("0.0.0.0"))
+		Expect(WildcardAddress("fd42::/56")).To(Equal("::"))
+		Expect(WildcardAddress("10.42.0.0/16,fd42::/56")).To(Equal("::"))
+		Expect(WildcardAddress("")).To(Equal("0.0.0.0"))
+	})
+
+	It("should normalize IP addresses", func() {
+		Expect(NormalizeAddress("fd00:0:0::1")).To(Equal("fd00::1"))
+		Expect(NormalizeAddress("[FD00::1]")).To(Equal("fd00::1"))
+		Expect(NormalizeAddress("10.0.0.1")).To(Equal("10.0.0.1"))
+		Expect(NormalizeAddress("cp.example.com")).To(Equal("cp.example.com"))
+	})
+})
@@ -0,0 +1,129 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rke2
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/pkg/errors"
+	batchv1 "k8s.io/api/batch/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/rancher-sandbox/cluster-api-provider-rke2/pkg/secret"
+)
+
+const (
+	// certificatesExpiryJobLabel is the label set on the workload cluster jobs reading the certificates of the nodes.
+	certificatesExpiryJobLabel = "controlplane.cluster.x-k8s.io/certificates-expiry"
+
+	certificatesExpiryJobPrefix = "rke2-certificates-expiry-"
+)
+
+// apiServerServingCertificateFile is the serving certificate of the API server of a RKE2 server.
+var apiServerServingCertificateFile = path.Join(secret.DefaultCertificatesDir, "serving-kube-apiserver.crt")
+
+// CertificatesExpiry returns the expiry of the serving certificate of the API server of the node, read from the host
+// of the node by a job, so the nodes don't need to be reachable from the management cluster. RKE2 issues the
+// certificates of a server together, with the same validity, so it is the expiry of the certificates of the node. The
+// certificate must be signed by the certificate authority.
+// A zero time is returned while the job runs. The job is removed once the certificate is read, a failed job is kept
+// until it expires, so the certificate of the node isn't read again meanwhile.
+func (w *Workload) CertificatesExpiry(ctx context.Context, nodeName string, caCert []byte) (time.Time, error) {
+	jobName := hostJobName(certificatesExpiryJobPrefix, nodeName)
+	job := &batchv1.Job{}
+
+	err := w.Client.Get(ctx, ctrlclient.ObjectKey{Namespace: HibernationNamespace, Name: jobName}, job)
+
+	switch {
+	case apierrors.IsNotFound(err):
+		job = w.newHostJob(jobName, nodeName,
+			fmt.Sprintf(hostCommand, "cat "+apiServerServingCertificateFile)+" > /dev/termination-log",
+			certificatesExpiryJobLabel, "certificates-expiry")
+
+		if err := w.Client.Create(ctx, job); err != nil && !apierrors.IsAlreadyExists(err) {
+			return time.Time{}, errors.Wrapf(err, "failed to create the job reading the certificates of node %s", nodeName)
+		}
+
+		return time.Time{}, nil
+	case err != nil:
+		return time.Time{}, errors.Wrapf(err, "failed to get the job reading the certificates of node %s", nodeName)
+	case job.Status.Failed > 0:
+		return time.Time{}, errors.Errorf("job %s/%s reading the certificates of node %s failed", HibernationNamespace,
+			jobName, nodeName)
+	case job.Status.Succeeded == 0:
+		return time.Time{}, nil
+	}
+
+	servingCert, err := w.jobTerminationMessage(ctx, job)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if err := w.Client.Delete(ctx, job, ctrlclient.PropagationPolicy(metav1.DeletePropagationBackground)); err != nil &&
+		!apierrors.IsNotFound(err) {
+		return time.Time{}, errors.Wrapf(err, "failed to delete the job reading the certificates of node %s", nodeName)
+	}
+
+	return servingCertificateExpiry([]byte(servingCert), caCert)
+}
+
+// servingCertificateExpiry returns the expiry of the first certificate of the PEM data, followed by its
+// intermediates, once verified against the certificate authority. Its host names aren't checked as it is only read.
+func servingCertificateExpiry(servingCert, caCert []byte) (time.Time, error) {
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caCert) {
+		return time.Time{}, errors.New("failed to load the cluster certificate authority")
+	}
+
+	certs := []*x509.Certificate{}
+
+	for block, rest := pem.Decode(servingCert); block != nil; block, rest = pem.Decode(rest) {
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return time.Time{}, errors.Wrap(err, "failed to parse the serving certificate")
+		}
+
+		certs = append(certs, cert)
+	}
+
+	if len(certs) == 0 {
+		return time.Time{}, errors.New("no serving certificate")
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	// The chain is verified as of the issuance of the certificate, the expiry of an expired certificate is returned.
+	if _, err := certs[0].Verify(x509.VerifyOptions{
+		Roots:         pool,
+		Intermediates: intermediates,
+		CurrentTime:   certs[0].NotBefore,
+	}); err != nil {
+		return time.Time{}, errors.Wrap(err, "the serving certificate is not signed by the cluster certificate authority")
+	}
+
+	return certs[0].NotAfter, nil
+}
@@ -0,0 +1,172 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rke2
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// newTestCertificate returns a PEM certificate signed by the parent, or self-signed when the parent is nil.
+func newTestCertificate(parent *x509.Certificate, parentKey *ecdsa.PrivateKey, notAfter time.Time) (*x509.Certificate, *ecdsa.PrivateKey, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).ToNot(HaveOccurred())
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: "kube-apiserver"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              notAfter,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+
+	if parent == nil {
+		template.Subject.CommonName = "rke2-server-ca"
+		template.IsCA = true
+		template.KeyUsage = x509.KeyUsageCertSign
+		parent, parentKey = template, key
+	}
+
+	raw, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	Expect(err).ToNot(HaveOccurred())
+
+	cert, err := x509.ParseCertificate(raw)
+	Expect(err).ToNot(HaveOccurred())
+
+	return cert, key, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: raw})
+}
+
+var _ = Describe("CertificatesExpiry", func() {
+	var (
+		workload         *Workload
+		ca               *x509.Certificate
+		caKey            *ecdsa.PrivateKey
+		caCert           []byte
+		servingCert      []byte
+		servingNotAfter  time.Time
+		certificatesJobs types.NamespacedName
+	)
+
+	BeforeEach(func() {
+		workload = &Workload{Client: fake.NewClientBuilder().Build()}
+		ca, caKey, caCert = newTestCertificate(nil, nil, time.Now().Add(10*365*24*time.Hour))
+		servingNotAfter = time.Now().Add(365 * 24 * time.Hour).Truncate(time.Second)
+		_, _, servingCert = newTestCertificate(ca, caKey, servingNotAfter)
+		certificatesJobs = types.NamespacedName{Namespace: HibernationNamespace, Name: "rke2-certificates-expiry-node-1"}
+	})
+
+	completeCertificatesJob := func(servingCert []byte) {
+		job := &batchv1.Job{}
+		Expect(workload.Client.Get(context.Background(), certificatesJobs, job)).To(Succeed())
+
+		// The selector is set by the API server.
+		job.Spec.Selector = &metav1.LabelSelector{MatchLabels: map[string]string{"controller-uid": "certificates"}}
+		Expect(workload.Client.Update(context.Background(), job)).To(Succeed())
+
+		job.Status.Succeeded = 1
+		Expect(workload.Client.Status().Update(context.Background(), job)).To(Succeed())
+
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Namespace: HibernationNamespace,
+			Name:      job.Name + "-pod",
+			Labels:    map[string]string{"controller-uid": "certificates"},
+		}}
+		Expect(workload.Client.Create(context.Background(), pod)).To(Succeed())
+
+		pod.Status = corev1.PodStatus{
+			Phase: corev1.PodSucceeded,
+			ContainerStatuses: []corev1.ContainerStatus{{
+				State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{Message: string(servingCert)}},
+			}},
+		}
+		Expect(workload.Client.Status().Update(context.Background(), pod)).To(Succeed())
+	}
+
+	It("should return the expiry of the serving certificate read on the node", func() {
+		expiry, err := workload.CertificatesExpiry(context.Background(), "node-1", caCert)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(expiry.IsZero()).To(BeTrue())
+
+		job := &batchv1.Job{}
+		Expect(workload.Client.Get(context.Background(), certificatesJobs, job)).To(Succeed())
+		Expect(job.Spec.Template.Spec.NodeName).To(Equal("node-1"))
+		Expect(job.Spec.Template.Spec.Containers[0].Command).To(ContainElement(
+			ContainSubstring("cat /var/lib/rancher/rke2/server/tls/serving-kube-apiserver.crt")))
+
+		completeCertificatesJob(servingCert)
+
+		expiry, err = workload.CertificatesExpiry(context.Background(), "node-1", caCert)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(expiry).To(BeTemporally("==", servingNotAfter))
+
+		// The job is removed, the certificate is read again on the next refresh.
+		Expect(workload.Client.Get(context.Background(), certificatesJobs, job)).ToNot(Succeed())
+	})
+
+	It("should keep the failed job, so the certificate isn't read again until it expires", func() {
+		_, err := workload.CertificatesExpiry(context.Background(), "node-1", caCert)
+		Expect(err).ToNot(HaveOccurred())
+
+		job := &batchv1.Job{}
+		Expect(workload.Client.Get(context.Background(), certificatesJobs, job)).To(Succeed())
+		Expect(job.Spec.TTLSecondsAfterFinished).ToNot(BeNil())
+
+		job.Status.Failed = 1
+		Expect(workload.Client.Status().Update(context.Background(), job)).To(Succeed())
+
+		for i := 0; i < 2; i++ {
+			_, err = workload.CertificatesExpiry(context.Background(), "node-1", caCert)
+			Expect(err).To(HaveOccurred())
+		}
+
+		Expect(workload.Client.Get(context.Background(), certificatesJobs, job)).To(Succeed())
+	})
+
+	It("should refuse a serving certificate signed by another certificate authority", func() {
+		otherCA, otherKey, _ := newTestCertificate(nil, nil, time.Now().Add(time.Hour))
+		_, _, otherServingCert := newTestCertificate(otherCA, otherKey, time.Now().Add(time.Hour))
+
+		_, err := servingCertificateExpiry(otherServingCert, caCert)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should return the expiry of an expired serving certificate", func() {
+		notAfter := time.Now().Add(-time.Minute).Truncate(time.Second)
+		_, _, expiredCert := newTestCertificate(ca, caKey, notAfter)
+
+		expiry, err := servingCertificateExpiry(expiredCert, caCert)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(expiry).To(BeTemporally("==", notAfter))
+	})
+})
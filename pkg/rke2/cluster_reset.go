@@ -0,0 +1,106 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rke2
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+	batchv1 "k8s.io/api/batch/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kerrors "k8s.io/apimachinery/pkg/util/errors"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// clusterResetJobLabel is the label set on the workload cluster jobs resetting etcd.
+	clusterResetJobLabel = "controlplane.cluster.x-k8s.io/cluster-reset"
+
+	// clusterResetIDAnnotation is the annotation set on the cluster reset jobs with the reset they run.
+	clusterResetIDAnnotation = "controlplane.cluster.x-k8s.io/cluster-reset-id"
+
+	clusterResetJobPrefix = "rke2-cluster-reset-"
+
+	// ClusterResetCommand resets etcd to a single member cluster on the host, then starts rke2-server again. It is
+	// the command run by the infrastructure hooks or node automation when the workload cluster API is unreachable.
+	ClusterResetCommand = "systemctl stop rke2-server.service && rke2 server --cluster-reset && systemctl start rke2-server.service"
+
+	// clusterResetUnitCommand runs ClusterResetCommand from a transient unit, waiting for it: the job pod keeps
+	// running while rke2-server is stopped, and reports the result once the kubelet is started again.
+	clusterResetUnitCommand = "systemd-run --unit=rke2-cluster-reset --collect --wait sh -c " +
+		"\"PATH=$PATH:/usr/local/bin:/opt/rke2/bin; " + ClusterResetCommand + "\""
+)
+
+// ErrClusterResetFailed is returned by ClusterReset when the reset failed on the node.
+var ErrClusterResetFailed = errors.New("etcd cluster reset failed")
+
+// ClusterReset runs "rke2 server --cluster-reset" on the node, the ID identifies the reset and the job left by
+// another reset is replaced. It returns true once etcd is reset and rke2-server started again, and an error wrapping
+// ErrClusterResetFailed when the reset failed.
+func (w *Workload) ClusterReset(ctx context.Context, nodeName string, id string) (bool, error) {
+	name := clusterResetJobPrefix + nodeName
+	job := &batchv1.Job{}
+
+	err := w.Client.Get(ctx, ctrlclient.ObjectKey{Namespace: HibernationNamespace, Name: name}, job)
+
+	switch {
+	case err == nil && job.Annotations[clusterResetIDAnnotation] != id:
+		if err := w.Client.Delete(ctx, job, ctrlclient.PropagationPolicy(metav1.DeletePropagationBackground)); err != nil &&
+			!apierrors.IsNotFound(err) {
+			return false, errors.Wrapf(err, "failed to delete the previous job %s", name)
+		}
+
+		return false, nil
+	case apierrors.IsNotFound(err):
+		job = w.newHostJob(name, nodeName, fmt.Sprintf(hostCommand, clusterResetUnitCommand), clusterResetJobLabel, "cluster-reset")
+		job.Annotations = map[string]string{clusterResetIDAnnotation: id}
+
+		if err := w.Client.Create(ctx, job); err != nil {
+			return false, errors.Wrapf(err, "failed to create job %s", name)
+		}
+
+		return false, nil
+	case err != nil:
+		return false, errors.Wrapf(err, "failed to get job %s", name)
+	case job.Status.Failed > 0:
+		return false, errors.Wrapf(ErrClusterResetFailed, "job %s/%s failed", HibernationNamespace, name)
+	default:
+		return job.Status.Succeeded > 0, nil
+	}
+}
+
+// DeleteClusterResetJobs removes the jobs left by the resets of etcd.
+func (w *Workload) DeleteClusterResetJobs(ctx context.Context) error {
+	jobs := &batchv1.JobList{}
+	if err := w.Client.List(ctx, jobs, ctrlclient.InNamespace(HibernationNamespace),
+		ctrlclient.HasLabels{clusterResetJobLabel}); err != nil {
+		return errors.Wrap(err, "failed to list the cluster reset jobs")
+	}
+
+	errs := []error{}
+
+	for i := range jobs.Items {
+		if err := w.Client.Delete(ctx, &jobs.Items[i], ctrlclient.PropagationPolicy(metav1.DeletePropagationBackground)); err != nil &&
+			!apierrors.IsNotFound(err) {
+			errs = append(errs, errors.Wrapf(err, "failed to delete job %s", jobs.Items[i].Name))
+		}
+	}
+
+	return kerrors.NewAggregate(errs)
+}
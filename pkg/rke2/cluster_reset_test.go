@@ -0,0 +1,90 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rke2
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	batchv1 "k8s.io/api/batch/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("ClusterReset", func() {
+	var workload *Workload
+
+	jobKey := types.NamespacedName{Namespace: HibernationNamespace, Name: clusterResetJobPrefix + "node-1"}
+
+	setJobStatus := func(status batchv1.JobStatus) {
+		job := &batchv1.Job{}
+		Expect(workload.Client.Get(context.Background(), jobKey, job)).To(Succeed())
+
+		job.Status = status
+		Expect(workload.Client.Update(context.Background(), job)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		workload = &Workload{Client: fake.NewClientBuilder().Build()}
+	})
+
+	It("should reset etcd on the node and wait for the job", func() {
+		reset, err := workload.ClusterReset(context.Background(), "node-1", "reset-1")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(reset).To(BeFalse())
+
+		job := &batchv1.Job{}
+		Expect(workload.Client.Get(context.Background(), jobKey, job)).To(Succeed())
+		Expect(job.Spec.Template.Spec.NodeName).To(Equal("node-1"))
+		Expect(job.Spec.Template.Spec.Containers[0].Command[2]).To(ContainSubstring("rke2 server --cluster-reset"))
+
+		setJobStatus(batchv1.JobStatus{Succeeded: 1})
+
+		reset, err = workload.ClusterReset(context.Background(), "node-1", "reset-1")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(reset).To(BeTrue())
+
+		Expect(workload.DeleteClusterResetJobs(context.Background())).To(Succeed())
+		Expect(workload.Client.Get(context.Background(), jobKey, job)).ToNot(Succeed())
+	})
+
+	It("should report a failed reset", func() {
+		_, err := workload.ClusterReset(context.Background(), "node-1", "reset-1")
+		Expect(err).ToNot(HaveOccurred())
+
+		setJobStatus(batchv1.JobStatus{Failed: 1})
+
+		_, err = workload.ClusterReset(context.Background(), "node-1", "reset-1")
+		Expect(err).To(MatchError(ErrClusterResetFailed))
+	})
+
+	It("should replace the job left by another reset", func() {
+		_, err := workload.ClusterReset(context.Background(), "node-1", "reset-1")
+		Expect(err).ToNot(HaveOccurred())
+
+		setJobStatus(batchv1.JobStatus{Failed: 1})
+
+		reset, err := workload.ClusterReset(context.Background(), "node-1", "reset-2")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(reset).To(BeFalse())
+
+		job := &batchv1.Job{}
+		Expect(workload.Client.Get(context.Background(), jobKey, job)).ToNot(Succeed())
+	})
+})
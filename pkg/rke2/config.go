@@ -19,9 +19,15 @@ package rke2
 import (
 	"context"
 	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
 
+	"github.com/pkg/errors"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/pointer"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
@@ -39,9 +45,52 @@ const (
 	// DefaultRKE2CloudProviderConfigLocation is the default location for the RKE2 cloud provider config file.
 	DefaultRKE2CloudProviderConfigLocation = "/etc/rancher/rke2/cloud-provider-config"
 
+	// DefaultRKE2ManifestsDirectory is the directory of the manifests RKE2 deploys on the servers.
+	DefaultRKE2ManifestsDirectory = "/var/lib/rancher/rke2/server/manifests"
+
+	// DefaultRKE2DatastoreCAFile is the location of the CA of the external datastore on the server nodes.
+	DefaultRKE2DatastoreCAFile = "/etc/rancher/rke2/datastore-ca.crt"
+
+	// DefaultRKE2DatastoreCertFile is the location of the client certificate of the external datastore on the servers.
+	DefaultRKE2DatastoreCertFile = "/etc/rancher/rke2/datastore-client.crt"
+
+	// DefaultRKE2DatastoreKeyFile is the location of the client key of the external datastore on the servers.
+	DefaultRKE2DatastoreKeyFile = "/etc/rancher/rke2/datastore-client.key"
+
 	// DefaultRKE2JoinPort is the default port used for joining nodes to the cluster. It is open on the control plane nodes.
 	DefaultRKE2JoinPort = 9345
 
+	// KubeAPIServerSecurePort is the default port of the Kube API Server.
+	KubeAPIServerSecurePort = 6443
+
+	// EtcdMetricsPort is the port where ETCD exposes its metrics.
+	EtcdMetricsPort = 2381
+
+	// KubeControllerManagerSecurePort is the port where the Kube Controller Manager exposes its metrics.
+	KubeControllerManagerSecurePort = 10257
+
+	// KubeSchedulerSecurePort is the port where the Kube Scheduler exposes its metrics.
+	KubeSchedulerSecurePort = 10259
+
+	// OIDCCAFile is the location of the CA of the OpenID Connect provider on the server nodes.
+	OIDCCAFile = "/etc/rancher/rke2/oidc-ca.crt"
+
+	// KubeSchedulerConfigFile is the location of the Kube Scheduler configuration file on the server nodes.
+	KubeSchedulerConfigFile = "/etc/rancher/rke2/kube-scheduler-config.yaml"
+
+	// DefaultRKE2DataDir is the default folder where RKE2 holds its state.
+	DefaultRKE2DataDir = "/var/lib/rancher/rke2"
+
+	// EtcdDiskSetupScriptLocation is the location of the script that prepares the dedicated ETCD disk.
+	EtcdDiskSetupScriptLocation = "/opt/rke2-etcd-disk-setup.sh"
+
+	// EtcdIONiceUnitLocation is the location of the systemd unit that sets the IO priority of the ETCD process.
+	EtcdIONiceUnitLocation = "/etc/systemd/system/rke2-etcd-ionice.service"
+
+	// ClusterResetRestoreUnitLocation is the location of the drop-in of the rke2-server unit restoring etcd from a
+	// snapshot before rke2-server is first started.
+	ClusterResetRestoreUnitLocation = "/etc/systemd/system/rke2-server.service.d/10-cluster-reset-restore.conf"
+
 	// CISNodePreparationScript is the script that is used to prepare a node for CIS compliance.
 	CISNodePreparationScript = `#!/bin/bash
 set -e
@@ -74,9 +123,210 @@ fi
 
 # Applying kernel parameters
 sysctl -p /etc/sysctl.d/90-rke2-cis.conf
+`
+
+	// etcdDiskSetupScript is the script that formats and mounts the dedicated ETCD disk, it is rendered with
+	// the device, the filesystem, the mount point and the IO scheduler, quoted for the shell.
+	etcdDiskSetupScript = `#!/bin/bash
+set -e
+
+DEVICE=%[1]s
+FILESYSTEM=%[2]s
+MOUNT_POINT=%[3]s
+IO_SCHEDULER=%[4]s
+
+# Waiting for the device to be attached
+for i in $(seq 1 60); do
+    [ -b "$DEVICE" ] && break
+    sleep 5
+done
+
+if [ ! -b "$DEVICE" ]; then
+    echo "ETCD device $DEVICE not found"
+    exit 1
+fi
+
+# Only format the device if it does not hold a filesystem yet
+if ! blkid "$DEVICE" &>/dev/null; then
+    mkfs -t "$FILESYSTEM" "$DEVICE"
+fi
+
+mkdir -p "$MOUNT_POINT"
+if ! grep -qs " $MOUNT_POINT " /etc/fstab; then
+    echo "$DEVICE $MOUNT_POINT $FILESYSTEM defaults,noatime 0 2" >> /etc/fstab
+fi
+mountpoint -q "$MOUNT_POINT" || mount "$MOUNT_POINT"
+chmod 700 "$MOUNT_POINT"
+
+if [ -n "$IO_SCHEDULER" ]; then
+    DEVICE_NAME=$(basename "$(readlink -f "$DEVICE")")
+    echo "$IO_SCHEDULER" > "/sys/block/$DEVICE_NAME/queue/scheduler"
+    echo "ACTION==\"add|change\", KERNEL==\"$DEVICE_NAME\", ATTR{queue/scheduler}=\"$IO_SCHEDULER\"" > /etc/udev/rules.d/60-rke2-etcd-scheduler.rules
+fi
+
+if [ -f /etc/systemd/system/rke2-etcd-ionice.service ]; then
+    systemctl daemon-reload
+    systemctl enable --now --no-block rke2-etcd-ionice.service
+fi
+`
+
+	// etcdIONiceUnit is the systemd unit waiting for the ETCD process to start and setting its IO priority.
+	etcdIONiceUnit = `[Unit]
+Description=Set the IO priority of the ETCD process
+After=rke2-server.service
+
+[Service]
+Type=oneshot
+RemainAfterExit=true
+ExecStart=/bin/sh -c 'until pid=$$(pgrep -x etcd); do sleep 5; done; ionice -c %[1]d -n %[2]d -p $$pid'
+
+[Install]
+WantedBy=multi-user.target
+`
+
+	// clusterResetRestoreUnit resets etcd from the snapshot the first time rke2-server is started, the marker file
+	// keeps it from being reset again when rke2-server is restarted. It runs with the configuration file of the server,
+	// so the snapshot is downloaded with its S3 configuration.
+	clusterResetRestoreUnit = `[Service]
+ExecStartPre=/bin/sh -c 'if [ ! -f %[2]s ]; then export PATH=$$PATH:/usr/local/bin:/opt/rke2/bin; \
+    rke2 server --cluster-reset --cluster-reset-restore-path=%[1]s && touch %[2]s; fi'
+`
+
+	// HostAliasesLocation is the location of the host aliases added to /etc/hosts.
+	HostAliasesLocation = "/etc/rke2-hosts"
+
+	// HostAliasesScriptLocation is the location of the script adding the host aliases to /etc/hosts.
+	HostAliasesScriptLocation = "/opt/rke2-host-aliases.sh"
+
+	// HostAliasesUnitLocation is the location of the systemd unit adding the host aliases to /etc/hosts at every boot.
+	HostAliasesUnitLocation = "/etc/systemd/system/rke2-host-aliases.service"
+
+	// hostAliasesScript replaces the block of the host aliases in /etc/hosts, so it can be run again, and enables the
+	// unit running it at every boot, as /etc/hosts may be regenerated, e.g. by cloud-init.
+	hostAliasesScript = `#!/bin/bash
+set -e
+
+sed -i '/^# BEGIN RKE2 host aliases$/,/^# END RKE2 host aliases$/d' /etc/hosts
+{
+    echo '# BEGIN RKE2 host aliases'
+    cat /etc/rke2-hosts
+    echo '# END RKE2 host aliases'
+} >> /etc/hosts
+
+if ! systemctl is-enabled --quiet rke2-host-aliases.service; then
+    systemctl enable rke2-host-aliases.service
+fi
+`
+
+	// hostAliasesUnit runs the host aliases script at every boot, before RKE2 is started.
+	hostAliasesUnit = `[Unit]
+Description=Add the RKE2 host aliases to /etc/hosts
+After=cloud-init.service
+Before=rke2-server.service rke2-agent.service
+
+[Service]
+Type=oneshot
+RemainAfterExit=true
+ExecStart=/opt/rke2-host-aliases.sh
+
+[Install]
+WantedBy=multi-user.target
+`
+
+	// NodePreparationScriptLocation is the location of the script that prepares the kernel of the node.
+	NodePreparationScriptLocation = "/opt/rke2-node-preparation.sh"
+
+	// NodePreparationSysctlLocation is the location of the kernel parameters set by the node preparation, the CIS
+	// kernel parameters of /etc/sysctl.d/90-rke2-cis.conf take precedence.
+	NodePreparationSysctlLocation = "/etc/sysctl.d/80-rke2.conf"
+
+	// NodePreparationModulesLocation is the location of the kernel modules loaded by the node preparation.
+	NodePreparationModulesLocation = "/etc/modules-load.d/rke2.conf"
+
+	// nodePreparationScript loads the kernel modules and sets the kernel parameters of the node preparation, they are
+	// loaded and set again at every boot by systemd.
+	nodePreparationScript = `#!/bin/bash
+set -e
+
+# Loading the kernel modules, the bridge parameters require br_netfilter
+grep -v '^#' /etc/modules-load.d/rke2.conf | xargs -r -n 1 modprobe
+
+# Applying the kernel parameters
+sysctl -p /etc/sysctl.d/80-rke2.conf
+`
+
+	// disableSwapScript turns swap off and keeps it off across reboots, whatever the way the OS sets it up.
+	disableSwapScript = `
+# Disabling swap, the kubelet doesn't start with swap on
+swapoff -a
+
+# Commenting out the swap entries of /etc/fstab, e.g. /swap.img on Ubuntu, it is read-only on some immutable OSes
+if [ -w /etc/fstab ]; then
+    sed -i -E 's@^([^#[:space:]][^[:space:]]*[[:space:]]+[^[:space:]]+[[:space:]]+swap[[:space:]].*)$@#\1@' /etc/fstab
+fi
+
+# Masking the swap units, e.g. generated from the swap partitions by systemd-gpt-auto-generator
+for unit in $(systemctl list-units --type swap --all --plain --no-legend | awk '{print $1}'); do
+    systemctl mask "$unit"
+done
+
+# Disabling zram swap, e.g. set up by zram-generator on Fedora, with an empty configuration
+if [ -e /usr/lib/systemd/zram-generator.conf ] || [ -e /etc/systemd/zram-generator.conf ]; then
+    : > /etc/systemd/zram-generator.conf
+fi
+`
+
+	// CiliumConfigManifest is the location of the configuration of the rke2-cilium chart on the server nodes.
+	CiliumConfigManifest = DefaultRKE2ManifestsDirectory + "/rke2-cilium-config.yaml"
+
+	// ciliumKubeProxyReplacementConfig configures Cilium to replace kube-proxy.
+	ciliumKubeProxyReplacementConfig = `apiVersion: helm.cattle.io/v1
+kind: HelmChartConfig
+metadata:
+  name: rke2-cilium
+  namespace: kube-system
+spec:
+  valuesContent: |-
+    kubeProxyReplacement: true
+    k8sServiceHost: localhost
+    k8sServicePort: 6443
+`
+
+	// CalicoConfigManifest is the location of the configuration of the rke2-calico chart on the server nodes.
+	CalicoConfigManifest = DefaultRKE2ManifestsDirectory + "/rke2-calico-config.yaml"
+
+	// calicoEBPFConfig configures Calico to replace kube-proxy with its eBPF dataplane, the Tigera operator reaching
+	// the Kube API Server directly.
+	calicoEBPFConfig = `apiVersion: helm.cattle.io/v1
+kind: HelmChartConfig
+metadata:
+  name: rke2-calico
+  namespace: kube-system
+spec:
+  valuesContent: |-
+    installation:
+      calicoNetwork:
+        linuxDataplane: BPF
+        hostPorts: Disabled
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: kubernetes-services-endpoint
+  namespace: tigera-operator
+data:
+  KUBERNETES_SERVICE_HOST: localhost
+  KUBERNETES_SERVICE_PORT: "6443"
 `
 )
 
+// ioNiceClasses maps the API ionice classes to the values expected by ionice(1).
+var ioNiceClasses = map[controlplanev1.IONiceClass]int{ //nolint:gochecknoglobals
+	controlplanev1.IONiceRealtime:   1,
+	controlplanev1.IONiceBestEffort: 2,
+	controlplanev1.IONiceIdle:       3,
+}
+
 type rke2ServerConfig struct {
 	AdvertiseAddress                  string            `json:"advertise-address,omitempty"`
 	AuditPolicyFile                   string            `json:"audit-policy-file,omitempty"`
@@ -84,10 +334,15 @@ type rke2ServerConfig struct {
 	CNI                               []string          `json:"cni,omitempty"`
 	CloudControllerManagerExtraEnv    map[string]string `json:"cloud-controller-manager-extra-env,omitempty"`
 	CloudControllerManagerExtraMounts map[string]string `json:"cloud-controller-manager-extra-mount,omitempty"`
+	CloudControllerManagerImage       string            `json:"cloud-controller-manager-image,omitempty"`
 	CloudProviderConfig               string            `json:"cloud-provider-config,omitempty"`
 	CloudProviderName                 string            `json:"cloud-provider-name,omitempty"`
 	ClusterDNS                        string            `json:"cluster-dns,omitempty"`
 	ClusterDomain                     string            `json:"cluster-domain,omitempty"`
+	DatastoreCAFile                   string            `json:"datastore-cafile,omitempty"`
+	DatastoreCertFile                 string            `json:"datastore-certfile,omitempty"`
+	DatastoreEndpoint                 string            `json:"datastore-endpoint,omitempty"`
+	DatastoreKeyFile                  string            `json:"datastore-keyfile,omitempty"`
 	DisableCloudController            bool              `json:"disable-cloud-controller,omitempty"`
 	DisableComponents                 []string          `json:"disable,omitempty"`
 	DisableKubeProxy                  bool              `json:"disable-kube-proxy,omitempty"`
@@ -97,12 +352,16 @@ type rke2ServerConfig struct {
 	EtcdS3                            bool              `json:"etcd-s3,omitempty"`
 	EtcdS3AccessKey                   string            `json:"etcd-s3-access-key,omitempty"`
 	EtcdS3Bucket                      string            `json:"etcd-s3-bucket,omitempty"`
+	EtcdS3BucketLookupType            string            `json:"etcd-s3-bucket-lookup-type,omitempty"`
 	EtcdS3Endpoint                    string            `json:"etcd-s3-endpoint,omitempty"`
 	EtcdS3EndpointCA                  string            `json:"etcd-s3-endpoint-ca,omitempty"`
 	EtcdS3Folder                      string            `json:"etcd-s3-folder,omitempty"`
-	EtcdS3Region                      string            `json:"etcd-s3-region,omitempty"`
+	EtcdS3Insecure                    bool              `json:"etcd-s3-insecure,omitempty"`
+	EtcdS3Region                      *string           `json:"etcd-s3-region,omitempty"`
 	EtcdS3SecretKey                   string            `json:"etcd-s3-secret-key,omitempty"`
 	EtcdS3SkipSslVerify               bool              `json:"etcd-s3-skip-ssl-verify,omitempty"`
+	EtcdS3Timeout                     string            `json:"etcd-s3-timeout,omitempty"`
+	EtcdSnapshotCompress              bool              `json:"etcd-snapshot-compress,omitempty"`
 	EtcdSnapshotDir                   string            `json:"etcd-snapshot-dir,omitempty"`
 	EtcdSnapshotName                  string            `json:"etcd-snapshot-name,omitempty"`
 	EtcdSnapshotRetention             string            `json:"etcd-snapshot-retention,omitempty"`
@@ -130,12 +389,10 @@ type rke2ServerConfig struct {
 	AirgapExtraRegistry       string `json:"airgap-extra-registry,omitempty"`
 	DisableAPIserver          bool   `json:"disable-apiserver,omitempty"`
 	DisableControllerManager  bool   `json:"disable-controller-manager,omitempty"`
+	DisableEtcd               bool   `json:"disable-etcd,omitempty"`
 	EgressSelectorMode        string `json:"egress-selector-mode,omitempty"`
 	EnablePprof               bool   `json:"enable-pprof,omitempty"`
 	EnableServiceLoadBalancer bool   `json:"enable-servicelb,omitempty"`
-	EtcdS3Insecure            bool   `json:"etcd-s3-insecure,omitempty"`
-	EtcdS3Timeout             string `json:"etcd-s3-timeout,omitempty"`
-	EtcdSnapshotCompress      string `json:"etcd-snapshot-compress,omitempty"`
 	ServicelbNamespace        string `json:"servicelb-namespace,omitempty"`
 
 	rke2AgentConfig `json:",inline"`
@@ -151,6 +408,13 @@ type ServerConfigOpts struct {
 	AgentConfig          bootstrapv1.RKE2AgentConfig
 	Ctx                  context.Context
 	Client               client.Client
+
+	// Role is the role of the server when the roles of the control plane are split, it runs all the roles when empty.
+	Role controlplanev1.MachineRole
+
+	// ClusterResetRestorePath is the etcd snapshot restored by the init control plane node before it starts, when a
+	// control plane which lost all its machines is recovered.
+	ClusterResetRestorePath string
 }
 
 func newRKE2ServerConfig(opts ServerConfigOpts) (*rke2ServerConfig, []bootstrapv1.File, error) { // nolint:gocyclo
@@ -182,16 +446,16 @@ func newRKE2ServerConfig(opts ServerConfigOpts) (*rke2ServerConfig, []bootstrapv
 		})
 	}
 
-	if opts.Cluster.Spec.ClusterNetwork != nil &&
-		opts.Cluster.Spec.ClusterNetwork.Pods != nil &&
-		len(opts.Cluster.Spec.ClusterNetwork.Pods.CIDRBlocks) > 0 {
-		rke2ServerConfig.ClusterCIDR = opts.Cluster.Spec.ClusterNetwork.Pods.CIDRBlocks[0]
+	clusterSettings, err := GetClusterSettings(&opts.Cluster)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	if opts.Cluster.Spec.ClusterNetwork != nil &&
-		opts.Cluster.Spec.ClusterNetwork.Services != nil &&
-		len(opts.Cluster.Spec.ClusterNetwork.Services.CIDRBlocks) > 0 {
-		rke2ServerConfig.ServiceCIDR = opts.Cluster.Spec.ClusterNetwork.Services.CIDRBlocks[0]
+	rke2ServerConfig.ClusterCIDR = clusterSettings.PodCIDR
+	rke2ServerConfig.ServiceCIDR = clusterSettings.ServiceCIDR
+
+	if proxyFile := clusterSettings.ProxyEnvironmentFile(ServerEnvironmentFile); proxyFile != nil {
+		files = append(files, *proxyFile)
 	}
 
 	rke2ServerConfig.BindAddress = opts.ServerConfig.BindAddress
@@ -228,7 +492,47 @@ func newRKE2ServerConfig(opts ServerConfigOpts) (*rke2ServerConfig, []bootstrapv
 		})
 	}
 
-	rke2ServerConfig.CloudProviderName = opts.ServerConfig.CloudProviderName
+	rke2ServerConfig.CloudProviderName = opts.ServerConfig.NodeCloudProviderName()
+
+	if opts.ServerConfig.CloudController == controlplanev1.ExternalCloudController &&
+		opts.ServerConfig.ExternalCloudControllerManifests != nil {
+		manifestsConfigMap := &corev1.ConfigMap{}
+		if err := opts.Client.Get(opts.Ctx, types.NamespacedName{
+			Name:      opts.ServerConfig.ExternalCloudControllerManifests.Name,
+			Namespace: opts.ServerConfig.ExternalCloudControllerManifests.Namespace,
+		}, manifestsConfigMap); err != nil {
+			return nil, nil, fmt.Errorf("failed to get external cloud controller manifests config map: %w", err)
+		}
+
+		// The manifests are sorted to render the same bootstrap data for every server.
+		names := make([]string, 0, len(manifestsConfigMap.Data))
+		for name := range manifestsConfigMap.Data {
+			names = append(names, name)
+		}
+
+		sort.Strings(names)
+
+		for _, name := range names {
+			content := manifestsConfigMap.Data[name]
+
+			if scheduling := opts.ServerConfig.ExternalCloudControllerScheduling; scheduling != nil {
+				scheduled, err := scheduleManifests(content, scheduling)
+				if err != nil {
+					return nil, nil, fmt.Errorf("failed to schedule external cloud controller manifest %s: %w", name, err)
+				}
+
+				content = scheduled
+			}
+
+			files = append(files, bootstrapv1.File{
+				Path:        DefaultRKE2ManifestsDirectory + "/" + name,
+				Content:     content,
+				Owner:       consts.DefaultFileOwner,
+				Permissions: consts.DefaultFileMode,
+			})
+		}
+	}
+
 	rke2ServerConfig.DisableComponents = func() []string {
 		disabled := []string{}
 		for _, plugin := range opts.ServerConfig.DisableComponents.PluginComponents {
@@ -239,53 +543,73 @@ func newRKE2ServerConfig(opts ServerConfigOpts) (*rke2ServerConfig, []bootstrapv
 	}()
 
 	for _, component := range opts.ServerConfig.DisableComponents.KubernetesComponents {
-		switch component {
-		case controlplanev1.KubeProxy:
-			rke2ServerConfig.DisableKubeProxy = true
-		case controlplanev1.Scheduler:
+		if component == controlplanev1.Scheduler {
 			rke2ServerConfig.DisableScheduler = true
 		}
 	}
 
-	rke2ServerConfig.DisableCloudController = true
+	rke2ServerConfig.DisableKubeProxy = opts.ServerConfig.KubeProxyDisabled(opts.AgentConfig.KubeProxyMode)
+
+	// The RKE2 cloud controller manager is only enabled on request, external cloud controller managers are
+	// deployed separately.
+	rke2ServerConfig.DisableCloudController = opts.ServerConfig.CloudController != controlplanev1.EmbeddedCloudController
+
+	// The machines of a control plane with split roles run either etcd or the Kubernetes control plane components.
+	switch opts.Role {
+	case controlplanev1.EtcdMachineRole:
+		rke2ServerConfig.DisableAPIserver = true
+		rke2ServerConfig.DisableControllerManager = true
+		rke2ServerConfig.DisableScheduler = true
+		rke2ServerConfig.DisableCloudController = true
+	case controlplanev1.ControlPlaneMachineRole:
+		rke2ServerConfig.DisableEtcd = true
+	}
+
 	rke2ServerConfig.EtcdDisableSnapshots = opts.ServerConfig.Etcd.BackupConfig.DisableAutomaticSnapshots
 	rke2ServerConfig.EtcdExposeMetrics = opts.ServerConfig.Etcd.ExposeMetrics
+	rke2ServerConfig.EtcdSnapshotCompress = opts.ServerConfig.Etcd.BackupConfig.Compress
 
 	if opts.ServerConfig.Etcd.BackupConfig.S3 != nil {
 		rke2ServerConfig.EtcdS3 = true
-		awsCredentialsSecret := &corev1.Secret{}
 
-		if err := opts.Client.Get(opts.Ctx, types.NamespacedName{
-			Name:      opts.ServerConfig.Etcd.BackupConfig.S3.S3CredentialSecret.Name,
-			Namespace: opts.ServerConfig.Etcd.BackupConfig.S3.S3CredentialSecret.Namespace,
-		}, awsCredentialsSecret); err != nil {
-			return nil, nil, fmt.Errorf("failed to get aws credentials secret: %w", err)
-		}
+		// Without access keys, the servers authenticate with the IAM credentials of their machine.
+		if !opts.ServerConfig.Etcd.BackupConfig.S3.IAMAuthentication {
+			awsCredentialsSecret := &corev1.Secret{}
 
-		accessKeyID, ok := awsCredentialsSecret.Data["aws_access_key_id"]
+			if err := opts.Client.Get(opts.Ctx, types.NamespacedName{
+				Name:      opts.ServerConfig.Etcd.BackupConfig.S3.S3CredentialSecret.Name,
+				Namespace: etcdS3SecretNamespace(opts.ServerConfig.Etcd.BackupConfig.S3.S3CredentialSecret, opts.Cluster),
+			}, awsCredentialsSecret); err != nil {
+				return nil, nil, fmt.Errorf("failed to get aws credentials secret: %w", err)
+			}
 
-		if !ok {
-			return nil, nil, fmt.Errorf("aws credentials secret is missing aws_access_key_id")
-		}
+			accessKeyID, ok := awsCredentialsSecret.Data["aws_access_key_id"]
 
-		secretAccessKey, ok := awsCredentialsSecret.Data["aws_secret_access_key"]
+			if !ok {
+				return nil, nil, fmt.Errorf("aws credentials secret is missing aws_access_key_id")
+			}
 
-		if !ok {
-			return nil, nil, fmt.Errorf("aws credentials secret is missing aws_secret_access_key")
+			secretAccessKey, ok := awsCredentialsSecret.Data["aws_secret_access_key"]
+
+			if !ok {
+				return nil, nil, fmt.Errorf("aws credentials secret is missing aws_secret_access_key")
+			}
+
+			rke2ServerConfig.EtcdS3AccessKey = string(accessKeyID)
+			rke2ServerConfig.EtcdS3SecretKey = string(secretAccessKey)
 		}
 
-		rke2ServerConfig.EtcdS3AccessKey = string(accessKeyID)
-		rke2ServerConfig.EtcdS3SecretKey = string(secretAccessKey)
 		rke2ServerConfig.EtcdS3Bucket = opts.ServerConfig.Etcd.BackupConfig.S3.Bucket
-		rke2ServerConfig.EtcdS3Region = opts.ServerConfig.Etcd.BackupConfig.S3.Region
+		rke2ServerConfig.EtcdS3Region = etcdS3Region(opts.ServerConfig.Etcd.BackupConfig.S3)
 		rke2ServerConfig.EtcdS3Folder = opts.ServerConfig.Etcd.BackupConfig.S3.Folder
 		rke2ServerConfig.EtcdS3Endpoint = opts.ServerConfig.Etcd.BackupConfig.S3.Endpoint
+		rke2ServerConfig.EtcdS3BucketLookupType = strings.ToLower(string(opts.ServerConfig.Etcd.BackupConfig.S3.BucketLookup))
 
 		if opts.ServerConfig.Etcd.BackupConfig.S3.EndpointCASecret != nil {
 			endpointCAsecret := &corev1.Secret{}
 			if err := opts.Client.Get(opts.Ctx, types.NamespacedName{
 				Name:      opts.ServerConfig.Etcd.BackupConfig.S3.EndpointCASecret.Name,
-				Namespace: opts.ServerConfig.Etcd.BackupConfig.S3.EndpointCASecret.Namespace,
+				Namespace: etcdS3SecretNamespace(*opts.ServerConfig.Etcd.BackupConfig.S3.EndpointCASecret, opts.Cluster),
 			}, endpointCAsecret); err != nil {
 				return nil, nil, fmt.Errorf("failed to get aws credentials secret: %w", err)
 			}
@@ -310,6 +634,24 @@ func newRKE2ServerConfig(opts ServerConfigOpts) (*rke2ServerConfig, []bootstrapv
 		rke2ServerConfig.EtcdSnapshotRetention = opts.ServerConfig.Etcd.BackupConfig.Retention
 		rke2ServerConfig.EtcdSnapshotScheduleCron = opts.ServerConfig.Etcd.BackupConfig.ScheduleCron
 		rke2ServerConfig.EtcdS3SkipSslVerify = !opts.ServerConfig.Etcd.BackupConfig.S3.EnforceSSLVerify
+		rke2ServerConfig.EtcdS3Insecure = opts.ServerConfig.Etcd.BackupConfig.S3.Insecure
+
+		if timeout := opts.ServerConfig.Etcd.BackupConfig.S3.Timeout; timeout != nil {
+			rke2ServerConfig.EtcdS3Timeout = timeout.Duration.String()
+		}
+	}
+
+	if datastore := opts.ServerConfig.ExternalDatastore; datastore != nil {
+		datastoreFiles, err := newExternalDatastoreConfig(opts, rke2ServerConfig)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		files = append(files, datastoreFiles...)
+	}
+
+	if opts.ServerConfig.Etcd.DiskSetup != nil {
+		files = append(files, newEtcdDiskSetupFiles(opts.ServerConfig.Etcd.DiskSetup, opts.AgentConfig.DataDir)...)
 	}
 
 	if opts.ServerConfig.Etcd.CustomConfig != nil {
@@ -329,6 +671,15 @@ func newRKE2ServerConfig(opts ServerConfigOpts) (*rke2ServerConfig, []bootstrapv
 		rke2ServerConfig.KubeAPIserverExtraEnv = opts.ServerConfig.KubeAPIServer.ExtraEnv
 	}
 
+	if opts.ServerConfig.OIDC != nil {
+		oidcFiles, err := addOIDCArgs(opts, rke2ServerConfig)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		files = append(files, oidcFiles...)
+	}
+
 	if opts.ServerConfig.KubeScheduler != nil {
 		rke2ServerConfig.KubeSchedulerArgs = opts.ServerConfig.KubeScheduler.ExtraArgs
 		rke2ServerConfig.KubeSchedulerImage = opts.ServerConfig.KubeScheduler.OverrideImage
@@ -336,6 +687,38 @@ func newRKE2ServerConfig(opts ServerConfigOpts) (*rke2ServerConfig, []bootstrapv
 		rke2ServerConfig.KubeSchedulerExtraEnv = opts.ServerConfig.KubeScheduler.ExtraEnv
 	}
 
+	if opts.ServerConfig.KubeSchedulerConfigMap != nil {
+		kubeSchedulerConfigMap := &corev1.ConfigMap{}
+		if err := opts.Client.Get(opts.Ctx, types.NamespacedName{
+			Name:      opts.ServerConfig.KubeSchedulerConfigMap.Name,
+			Namespace: opts.ServerConfig.KubeSchedulerConfigMap.Namespace,
+		}, kubeSchedulerConfigMap); err != nil {
+			return nil, nil, fmt.Errorf("failed to get kube scheduler config map: %w", err)
+		}
+
+		kubeSchedulerConfig, ok := kubeSchedulerConfigMap.Data["kube-scheduler-config.yaml"]
+		if !ok {
+			return nil, nil, fmt.Errorf("kube scheduler config map is missing kube-scheduler-config.yaml key")
+		}
+
+		files = append(files, bootstrapv1.File{
+			Path:        KubeSchedulerConfigFile,
+			Content:     kubeSchedulerConfig,
+			Owner:       consts.DefaultFileOwner,
+			Permissions: consts.DefaultFileMode,
+		})
+
+		// The file is mounted at the same path in the Kube Scheduler static pod.
+		extraMounts := map[string]string{KubeSchedulerConfigFile: KubeSchedulerConfigFile}
+		for hostPath, podPath := range rke2ServerConfig.KubeSchedulerExtraMounts {
+			extraMounts[hostPath] = podPath
+		}
+
+		rke2ServerConfig.KubeSchedulerExtraMounts = extraMounts
+		rke2ServerConfig.KubeSchedulerArgs = append(append([]string{}, rke2ServerConfig.KubeSchedulerArgs...),
+			"config="+KubeSchedulerConfigFile)
+	}
+
 	if opts.ServerConfig.KubeControllerManager != nil {
 		rke2ServerConfig.KubeControllerManagerArgs = opts.ServerConfig.KubeControllerManager.ExtraArgs
 		rke2ServerConfig.KubeControllerManagerImage = opts.ServerConfig.KubeControllerManager.OverrideImage
@@ -346,11 +729,451 @@ func newRKE2ServerConfig(opts ServerConfigOpts) (*rke2ServerConfig, []bootstrapv
 	if opts.ServerConfig.CloudControllerManager != nil {
 		rke2ServerConfig.CloudControllerManagerExtraMounts = opts.ServerConfig.CloudControllerManager.ExtraMounts
 		rke2ServerConfig.CloudControllerManagerExtraEnv = opts.ServerConfig.CloudControllerManager.ExtraEnv
+		rke2ServerConfig.CloudControllerManagerImage = opts.ServerConfig.CloudControllerManager.OverrideImage
+	}
+
+	if opts.ServerConfig.Metrics != nil {
+		// Expose the metrics on all interfaces, ETCD serves them over TLS and requires a client certificate
+		// signed by its CA, the other components authenticate and authorize requests through the Kube API Server.
+		bindAddress := WildcardAddress(rke2ServerConfig.ClusterCIDR)
+
+		// The servers don't run etcd with an external datastore.
+		if opts.ServerConfig.ExternalDatastore == nil {
+			rke2ServerConfig.EtcdArgs = append(append([]string{}, rke2ServerConfig.EtcdArgs...),
+				"listen-metrics-urls="+ServerURL(bindAddress, EtcdMetricsPort))
+		}
+
+		rke2ServerConfig.KubeSchedulerArgs = append(append([]string{}, rke2ServerConfig.KubeSchedulerArgs...),
+			"bind-address="+bindAddress)
+		rke2ServerConfig.KubeControllerManagerArgs = append(append([]string{}, rke2ServerConfig.KubeControllerManagerArgs...),
+			"bind-address="+bindAddress)
+	}
+
+	// Without kube-proxy, the CNI must proxy the services traffic and reach the Kube API Server directly, through the
+	// local load balancer of the agents.
+	if rke2ServerConfig.DisableKubeProxy {
+		switch opts.ServerConfig.CNI {
+		case controlplanev1.Cilium:
+			files = append(files, bootstrapv1.File{
+				Path:        CiliumConfigManifest,
+				Content:     ciliumKubeProxyReplacementConfig,
+				Owner:       consts.DefaultFileOwner,
+				Permissions: consts.DefaultFileMode,
+			})
+		case controlplanev1.Calico:
+			files = append(files, bootstrapv1.File{
+				Path:        CalicoConfigManifest,
+				Content:     calicoEBPFConfig,
+				Owner:       consts.DefaultFileOwner,
+				Permissions: consts.DefaultFileMode,
+			})
+		}
 	}
 
 	return rke2ServerConfig, files, nil
 }
 
+// addOIDCArgs adds the Kube API Server arguments authenticating users with an OpenID Connect provider, it returns the
+// CA file of the provider if it is set.
+func addOIDCArgs(opts ServerConfigOpts, rke2ServerConfig *rke2ServerConfig) ([]bootstrapv1.File, error) {
+	oidc := opts.ServerConfig.OIDC
+	files := []bootstrapv1.File{}
+
+	args := []string{
+		"oidc-issuer-url=" + oidc.IssuerURL,
+		"oidc-client-id=" + oidc.ClientID,
+	}
+
+	optionalArgs := []struct{ name, value string }{
+		{"oidc-username-claim", oidc.UsernameClaim},
+		{"oidc-username-prefix", oidc.UsernamePrefix},
+		{"oidc-groups-claim", oidc.GroupsClaim},
+		{"oidc-groups-prefix", oidc.GroupsPrefix},
+		{"oidc-signing-algs", strings.Join(oidc.SigningAlgs, ",")},
+	}
+
+	for _, arg := range optionalArgs {
+		if arg.value != "" {
+			args = append(args, arg.name+"="+arg.value)
+		}
+	}
+
+	requiredClaims := make([]string, 0, len(oidc.RequiredClaims))
+	for claim, value := range oidc.RequiredClaims {
+		requiredClaims = append(requiredClaims, "oidc-required-claim="+claim+"="+value)
+	}
+
+	sort.Strings(requiredClaims)
+	args = append(args, requiredClaims...)
+
+	if oidc.CASecret != nil {
+		caSecret := &corev1.Secret{}
+		if err := opts.Client.Get(opts.Ctx, types.NamespacedName{
+			Name:      oidc.CASecret.Name,
+			Namespace: oidc.CASecret.Namespace,
+		}, caSecret); err != nil {
+			return nil, fmt.Errorf("failed to get oidc CA secret: %w", err)
+		}
+
+		caCert, ok := caSecret.Data["ca.pem"]
+		if !ok {
+			return nil, fmt.Errorf("oidc CA secret is missing ca.pem")
+		}
+
+		files = append(files, bootstrapv1.File{
+			Path:        OIDCCAFile,
+			Content:     string(caCert),
+			Owner:       consts.DefaultFileOwner,
+			Permissions: consts.DefaultFileMode,
+		})
+
+		// The file is mounted at the same path in the Kube API Server static pod.
+		extraMounts := map[string]string{OIDCCAFile: OIDCCAFile}
+		for hostPath, podPath := range rke2ServerConfig.KubeAPIserverExtraMounts {
+			extraMounts[hostPath] = podPath
+		}
+
+		rke2ServerConfig.KubeAPIserverExtraMounts = extraMounts
+		args = append(args, "oidc-ca-file="+OIDCCAFile)
+	}
+
+	rke2ServerConfig.KubeAPIServerArgs = append(append([]string{}, rke2ServerConfig.KubeAPIServerArgs...), args...)
+
+	return files, nil
+}
+
+// etcdS3SecretNamespace returns the namespace of a secret of the etcd S3 backups, which defaults to the namespace of the
+// cluster. The secrets referenced from other namespaces are checked by the bootstrap controller.
+func etcdS3SecretNamespace(ref corev1.ObjectReference, cluster clusterv1.Cluster) string {
+	if ref.Namespace == "" {
+		return cluster.Namespace
+	}
+
+	return ref.Namespace
+}
+
+// awsS3EndpointRegion matches the regional AWS S3 endpoints, e.g. s3.eu-west-1.amazonaws.com, capturing the region.
+var awsS3EndpointRegion = regexp.MustCompile(`^s3[.-](?:dualstack\.)?([a-z]{2}(?:-gov)?-[a-z]+-[0-9])\.amazonaws\.com(?:\.cn)?$`)
+
+// etcdS3Region returns the region of the etcd S3 backups bucket, nil to let RKE2 default it. A detected region is taken
+// from the endpoint when it is a regional AWS endpoint, or is empty for the S3 client to look up the bucket location.
+func etcdS3Region(s3 *controlplanev1.EtcdS3) *string {
+	if !s3.AutoDetectRegion {
+		if s3.Region == "" {
+			return nil
+		}
+
+		return pointer.String(s3.Region)
+	}
+
+	if match := awsS3EndpointRegion.FindStringSubmatch(s3.Endpoint); match != nil {
+		return pointer.String(match[1])
+	}
+
+	return pointer.String("")
+}
+
+var (
+	// nodePreparationSysctls are the default kernel parameters of the node preparation.
+	nodePreparationSysctls = map[string]string{
+		"net.bridge.bridge-nf-call-iptables":  "1",
+		"net.bridge.bridge-nf-call-ip6tables": "1",
+		"net.ipv4.ip_forward":                 "1",
+		"vm.overcommit_memory":                "1",
+		"fs.inotify.max_user_watches":         "524288",
+		"fs.inotify.max_user_instances":       "8192",
+	}
+
+	// nodePreparationKernelModules are the kernel modules always loaded by the node preparation.
+	nodePreparationKernelModules = []string{"br_netfilter", "overlay"}
+)
+
+// newNodePreparationFiles returns the files needed to prepare the kernel of a node.
+func newNodePreparationFiles(nodePreparation *bootstrapv1.NodePreparation) []bootstrapv1.File {
+	sysctls := map[string]string{}
+	for key, value := range nodePreparationSysctls {
+		sysctls[key] = value
+	}
+
+	for key, value := range nodePreparation.Sysctls {
+		sysctls[key] = value
+	}
+
+	keys := make([]string, 0, len(sysctls))
+	for key := range sysctls {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	sysctlLines := make([]string, 0, len(keys))
+	for _, key := range keys {
+		sysctlLines = append(sysctlLines, key+" = "+sysctls[key])
+	}
+
+	modules := []string{}
+	loaded := map[string]bool{}
+
+	for _, module := range append(append([]string{}, nodePreparationKernelModules...), nodePreparation.KernelModules...) {
+		if !loaded[module] {
+			loaded[module] = true
+			modules = append(modules, module)
+		}
+	}
+
+	script := nodePreparationScript
+	if nodePreparation.DisableSwap {
+		script += disableSwapScript
+	}
+
+	return []bootstrapv1.File{
+		{
+			Path:        NodePreparationModulesLocation,
+			Content:     strings.Join(modules, "\n") + "\n",
+			Owner:       consts.DefaultFileOwner,
+			Permissions: consts.DefaultFileMode,
+		},
+		{
+			Path:        NodePreparationSysctlLocation,
+			Content:     strings.Join(sysctlLines, "\n") + "\n",
+			Owner:       consts.DefaultFileOwner,
+			Permissions: consts.DefaultFileMode,
+		},
+		{
+			Path:        NodePreparationScriptLocation,
+			Content:     script,
+			Owner:       consts.DefaultFileOwner,
+			Permissions: consts.FileModeRootExecutable,
+		},
+	}
+}
+
+// newHostAliasesFiles returns the files needed to add the host aliases to /etc/hosts.
+func newHostAliasesFiles(hostAliases []corev1.HostAlias) []bootstrapv1.File {
+	lines := make([]string, 0, len(hostAliases))
+	for _, hostAlias := range hostAliases {
+		lines = append(lines, hostAlias.IP+" "+strings.Join(hostAlias.Hostnames, " "))
+	}
+
+	return []bootstrapv1.File{
+		{
+			Path:        HostAliasesLocation,
+			Content:     strings.Join(lines, "\n") + "\n",
+			Owner:       consts.DefaultFileOwner,
+			Permissions: consts.DefaultFileMode,
+		},
+		{
+			Path:        HostAliasesScriptLocation,
+			Content:     hostAliasesScript,
+			Owner:       consts.DefaultFileOwner,
+			Permissions: consts.FileModeRootExecutable,
+		},
+		{
+			Path:        HostAliasesUnitLocation,
+			Content:     hostAliasesUnit,
+			Owner:       consts.DefaultFileOwner,
+			Permissions: consts.DefaultFileMode,
+		},
+	}
+}
+
+// newExternalDatastoreConfig sets the external datastore options of the server configuration, and returns the files
+// holding the certificate authority and the client certificate of the datastore.
+func newExternalDatastoreConfig(opts ServerConfigOpts, rke2ServerConfig *rke2ServerConfig) ([]bootstrapv1.File, error) {
+	datastore := opts.ServerConfig.ExternalDatastore
+	files := []bootstrapv1.File{}
+
+	endpoint, err := externalDatastoreEndpoint(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	rke2ServerConfig.DatastoreEndpoint = endpoint
+
+	if datastore.CASecretName != "" {
+		caSecret := &corev1.Secret{}
+		if err := opts.Client.Get(opts.Ctx, types.NamespacedName{
+			Name:      datastore.CASecretName,
+			Namespace: opts.Cluster.Namespace,
+		}, caSecret); err != nil {
+			return nil, errors.Wrap(err, "failed to get datastore CA secret")
+		}
+
+		caCert, ok := caSecret.Data[corev1.ServiceAccountRootCAKey]
+		if !ok {
+			return nil, errors.Errorf("datastore CA secret is missing %s", corev1.ServiceAccountRootCAKey)
+		}
+
+		rke2ServerConfig.DatastoreCAFile = DefaultRKE2DatastoreCAFile
+
+		files = append(files, bootstrapv1.File{
+			Path:        rke2ServerConfig.DatastoreCAFile,
+			Content:     string(caCert),
+			Owner:       consts.DefaultFileOwner,
+			Permissions: "0640",
+		})
+	}
+
+	if datastore.ClientCertificateSecretName != "" {
+		certificateSecret := &corev1.Secret{}
+		if err := opts.Client.Get(opts.Ctx, types.NamespacedName{
+			Name:      datastore.ClientCertificateSecretName,
+			Namespace: opts.Cluster.Namespace,
+		}, certificateSecret); err != nil {
+			return nil, errors.Wrap(err, "failed to get datastore client certificate secret")
+		}
+
+		cert, key := certificateSecret.Data[corev1.TLSCertKey], certificateSecret.Data[corev1.TLSPrivateKeyKey]
+		if len(cert) == 0 || len(key) == 0 {
+			return nil, errors.Errorf("datastore client certificate secret is missing %s or %s",
+				corev1.TLSCertKey, corev1.TLSPrivateKeyKey)
+		}
+
+		rke2ServerConfig.DatastoreCertFile = DefaultRKE2DatastoreCertFile
+		rke2ServerConfig.DatastoreKeyFile = DefaultRKE2DatastoreKeyFile
+
+		files = append(files,
+			bootstrapv1.File{
+				Path:        rke2ServerConfig.DatastoreCertFile,
+				Content:     string(cert),
+				Owner:       consts.DefaultFileOwner,
+				Permissions: "0640",
+			},
+			bootstrapv1.File{
+				Path:        rke2ServerConfig.DatastoreKeyFile,
+				Content:     string(key),
+				Owner:       consts.DefaultFileOwner,
+				Permissions: "0600",
+			},
+		)
+	}
+
+	return files, nil
+}
+
+// externalDatastoreEndpoint returns the endpoint of the external datastore: the configured endpoint, or the endpoint
+// of its PostgreSQL or MySQL database, in the formats expected by kine, with the credentials of its secret.
+func externalDatastoreEndpoint(opts ServerConfigOpts) (string, error) {
+	datastore := opts.ServerConfig.ExternalDatastore
+
+	sqlDatastore, defaultPort := datastore.Postgres, controlplanev1.DefaultPostgresPort
+	if datastore.MySQL != nil {
+		sqlDatastore, defaultPort = datastore.MySQL, controlplanev1.DefaultMySQLPort
+	}
+
+	if sqlDatastore == nil {
+		return datastore.Endpoint, nil
+	}
+
+	var (
+		user     *url.Userinfo
+		username string
+		password []byte
+	)
+
+	if sqlDatastore.CredentialsSecretName != "" {
+		credentialsSecret := &corev1.Secret{}
+		if err := opts.Client.Get(opts.Ctx, types.NamespacedName{
+			Name:      sqlDatastore.CredentialsSecretName,
+			Namespace: opts.Cluster.Namespace,
+		}, credentialsSecret); err != nil {
+			return "", errors.Wrap(err, "failed to get datastore credentials secret")
+		}
+
+		username = string(credentialsSecret.Data[corev1.BasicAuthUsernameKey])
+		if username == "" {
+			return "", errors.Errorf("datastore credentials secret is missing %s", corev1.BasicAuthUsernameKey)
+		}
+
+		user = url.User(username)
+		password = credentialsSecret.Data[corev1.BasicAuthPasswordKey]
+
+		if password != nil {
+			user = url.UserPassword(username, string(password))
+		}
+	}
+
+	parameters := url.Values{}
+	for name, value := range sqlDatastore.Parameters {
+		parameters.Set(name, value)
+	}
+
+	address := sqlDatastore.Address(defaultPort)
+
+	if datastore.MySQL != nil {
+		// kine passes the endpoint without its scheme to the MySQL driver, whose DSN isn't a URL: the credentials are
+		// not unescaped, and the server address is in the "tcp(host:port)" form.
+		credentials := ""
+		if username != "" {
+			credentials = username + "@"
+		}
+
+		if password != nil {
+			credentials = username + ":" + string(password) + "@"
+		}
+
+		endpoint := "mysql://" + credentials + "tcp(" + address + ")/" + sqlDatastore.Database
+
+		return strings.TrimSuffix(endpoint+"?"+parameters.Encode(), "?"), nil
+	}
+
+	endpoint := url.URL{
+		Scheme:   "postgres",
+		User:     user,
+		Host:     address,
+		Path:     "/" + sqlDatastore.Database,
+		RawQuery: parameters.Encode(),
+	}
+
+	return endpoint.String(), nil
+}
+
+// newEtcdDiskSetupFiles returns the files needed to prepare the dedicated ETCD disk on a server node.
+func newEtcdDiskSetupFiles(diskSetup *controlplanev1.EtcdDiskSetup, dataDir string) []bootstrapv1.File {
+	filesystem := diskSetup.Filesystem
+	if filesystem == "" {
+		filesystem = "ext4"
+	}
+
+	mountPoint := diskSetup.MountPoint
+	if mountPoint == "" {
+		if dataDir == "" {
+			dataDir = DefaultRKE2DataDir
+		}
+
+		mountPoint = dataDir + "/server/db"
+	}
+
+	script := fmt.Sprintf(etcdDiskSetupScript,
+		shellQuote(diskSetup.Device), shellQuote(filesystem), shellQuote(mountPoint), shellQuote(diskSetup.IOScheduler))
+
+	files := []bootstrapv1.File{
+		{
+			Path:        EtcdDiskSetupScriptLocation,
+			Content:     script,
+			Owner:       consts.DefaultFileOwner,
+			Permissions: consts.FileModeRootExecutable,
+		},
+	}
+
+	if diskSetup.IONice != nil {
+		files = append(files, bootstrapv1.File{
+			Path:        EtcdIONiceUnitLocation,
+			Content:     fmt.Sprintf(etcdIONiceUnit, ioNiceClasses[diskSetup.IONice.Class], diskSetup.IONice.Priority),
+			Owner:       consts.DefaultFileOwner,
+			Permissions: consts.DefaultFileMode,
+		})
+	}
+
+	return files
+}
+
+// shellQuote quotes a value for the shell, within single quotes nothing is expanded and a single quote is written
+// by closing the quoted string, escaping the quote and opening a new quoted string.
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
 type rke2AgentConfig struct {
 	ContainerRuntimeEndpoint      string            `json:"container-runtime-endpoint,omitempty"`
 	CloudProviderConfig           string            `json:"cloud-provider-config,omitempty"`
@@ -378,6 +1201,7 @@ type rke2AgentConfig struct {
 	Selinux                       bool              `json:"selinux,omitempty"`
 	Server                        string            `json:"server,omitempty"`
 	Snapshotter                   string            `json:"snapshotter,omitempty"`
+	SystemDefaultRegistry         string            `json:"system-default-registry,omitempty"`
 	Token                         string            `json:"token,omitempty"`
 
 	// We don't expose these in the API
@@ -392,6 +1216,7 @@ type rke2AgentConfig struct {
 
 // AgentConfigOpts is a struct that holds the information needed to generate the rke2 server config.
 type AgentConfigOpts struct {
+	Cluster                clusterv1.Cluster
 	ServerURL              string
 	Token                  string
 	AgentConfig            bootstrapv1.RKE2AgentConfig
@@ -420,6 +1245,14 @@ func newRKE2AgentConfig(opts AgentConfigOpts) (*rke2AgentConfig, []bootstrapv1.F
 		rke2AgentConfig.Profile = string(opts.AgentConfig.CISProfile)
 	}
 
+	if opts.AgentConfig.NodePreparation != nil {
+		files = append(files, newNodePreparationFiles(opts.AgentConfig.NodePreparation)...)
+	}
+
+	if len(opts.AgentConfig.HostAliases) > 0 {
+		files = append(files, newHostAliasesFiles(opts.AgentConfig.HostAliases)...)
+	}
+
 	if opts.CloudProviderConfigMap != nil {
 		cloudProviderConfigMap := &corev1.ConfigMap{}
 		if err := opts.Client.Get(opts.Ctx, types.NamespacedName{
@@ -484,6 +1317,14 @@ func newRKE2AgentConfig(opts AgentConfigOpts) (*rke2AgentConfig, []bootstrapv1.F
 		rke2AgentConfig.KubeletArgs = opts.AgentConfig.Kubelet.ExtraArgs
 	}
 
+	if opts.AgentConfig.CgroupDriver != "" {
+		// RKE2 aligns the cgroup driver of its embedded containerd with the one passed to the kubelet.
+		rke2AgentConfig.KubeletArgs = append(
+			append([]string{}, rke2AgentConfig.KubeletArgs...),
+			"cgroup-driver="+string(opts.AgentConfig.CgroupDriver),
+		)
+	}
+
 	rke2AgentConfig.LbServerPort = opts.AgentConfig.LoadBalancerPort
 	rke2AgentConfig.NodeLabels = opts.AgentConfig.NodeLabels
 	rke2AgentConfig.NodeTaints = opts.AgentConfig.NodeTaints
@@ -517,6 +1358,7 @@ func newRKE2AgentConfig(opts AgentConfigOpts) (*rke2AgentConfig, []bootstrapv1.F
 	rke2AgentConfig.Selinux = opts.AgentConfig.EnableContainerdSElinux
 	rke2AgentConfig.Server = opts.ServerURL
 	rke2AgentConfig.Snapshotter = opts.AgentConfig.Snapshotter
+	rke2AgentConfig.SystemDefaultRegistry = opts.AgentConfig.SystemDefaultRegistry
 
 	if opts.AgentConfig.KubeProxy != nil {
 		rke2AgentConfig.KubeProxyArgs = opts.AgentConfig.KubeProxy.ExtraArgs
@@ -525,6 +1367,12 @@ func newRKE2AgentConfig(opts AgentConfigOpts) (*rke2AgentConfig, []bootstrapv1.F
 		rke2AgentConfig.KubeProxyExtraEnv = opts.AgentConfig.KubeProxy.ExtraEnv
 	}
 
+	switch mode := opts.AgentConfig.KubeProxyMode; mode {
+	case bootstrapv1.IPTablesKubeProxyMode, bootstrapv1.IPVSKubeProxyMode:
+		rke2AgentConfig.KubeProxyArgs = append(append([]string{}, rke2AgentConfig.KubeProxyArgs...),
+			"proxy-mode="+string(mode))
+	}
+
 	rke2AgentConfig.Token = opts.Token
 
 	return rke2AgentConfig, files, nil
@@ -552,10 +1400,30 @@ func GenerateInitControlPlaneConfig(opts ServerConfigOpts) (*rke2ServerConfig, [
 	}
 
 	rke2ServerConfig.rke2AgentConfig = *rke2AgentConfig
+	rke2ServerConfig.PauseImage = opts.ServerConfig.PauseImage
+
+	if opts.ClusterResetRestorePath != "" {
+		serverFiles = append(serverFiles, newClusterResetRestoreFile(opts.ClusterResetRestorePath, opts.AgentConfig.DataDir))
+	}
 
 	return rke2ServerConfig, append(serverFiles, agentFiles...), nil
 }
 
+// newClusterResetRestoreFile returns the drop-in of the rke2-server unit restoring etcd from the snapshot before
+// rke2-server is first started.
+func newClusterResetRestoreFile(restorePath, dataDir string) bootstrapv1.File {
+	if dataDir == "" {
+		dataDir = DefaultRKE2DataDir
+	}
+
+	return bootstrapv1.File{
+		Path:        ClusterResetRestoreUnitLocation,
+		Content:     fmt.Sprintf(clusterResetRestoreUnit, restorePath, dataDir+"/server/cluster-reset-restored"),
+		Owner:       consts.DefaultFileOwner,
+		Permissions: consts.DefaultFileMode,
+	}
+}
+
 // GenerateJoinControlPlaneConfig generates the rke2 agent config for joining a control plane node.
 func GenerateJoinControlPlaneConfig(opts ServerConfigOpts) (*rke2ServerConfig, []bootstrapv1.File, error) {
 	if opts.ServerURL == "" {
@@ -583,6 +1451,7 @@ func GenerateJoinControlPlaneConfig(opts ServerConfigOpts) (*rke2ServerConfig, [
 	}
 
 	rke2ServerConfig.rke2AgentConfig = *rke2AgentConfig
+	rke2ServerConfig.PauseImage = opts.ServerConfig.PauseImage
 
 	return rke2ServerConfig, append(serverFiles, agentFiles...), nil
 }
@@ -602,5 +1471,14 @@ func GenerateWorkerConfig(opts AgentConfigOpts) (*rke2AgentConfig, []bootstrapv1
 		return nil, nil, fmt.Errorf("failed to generate rke2 agent config: %w", err)
 	}
 
+	clusterSettings, err := GetClusterSettings(&opts.Cluster)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate rke2 agent config: %w", err)
+	}
+
+	if proxyFile := clusterSettings.ProxyEnvironmentFile(AgentEnvironmentFile); proxyFile != nil {
+		agentFiles = append(agentFiles, *proxyFile)
+	}
+
 	return rke2AgentConfig, agentFiles, nil
 }
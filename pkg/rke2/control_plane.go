@@ -18,6 +18,8 @@ package rke2
 
 import (
 	"context"
+	"math/rand"
+	"sort"
 
 	"github.com/go-logr/logr"
 	"github.com/pkg/errors"
@@ -32,6 +34,7 @@ import (
 
 	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
 	"sigs.k8s.io/cluster-api/controllers/external"
+	"sigs.k8s.io/cluster-api/util/annotations"
 	"sigs.k8s.io/cluster-api/util/collections"
 	capifd "sigs.k8s.io/cluster-api/util/failuredomains"
 	"sigs.k8s.io/cluster-api/util/patch"
@@ -49,20 +52,36 @@ type ControlPlane struct {
 	Machines             collections.Machines
 	machinesPatchHelpers map[string]*patch.Helper
 
+	// ControllerNodeName is the node the controller runs on, when it runs in the workload cluster of the control plane.
+	ControllerNodeName string
+
+	// Role is the role of the machines of a control plane restricted to the machines of a role, see ForRole.
+	Role controlplanev1.MachineRole
+
+	// allMachines are the machines of all the roles, when the control plane is restricted to the machines of a role.
+	allMachines collections.Machines
+
+	// Decision is the decision of the controller on the control plane, recorded as the control plane is reconciled. It
+	// is shared by the control planes restricted to the machines of a role.
+	Decision *ReconcileDecision
+
 	// reconciliationTime is the time of the current reconciliation, and should be used for all "now" calculations
 	reconciliationTime metav1.Time
 
 	rke2Configs    map[string]*bootstrapv1.RKE2Config
 	infraResources map[string]*unstructured.Unstructured
+	infraTemplate  *unstructured.Unstructured
 }
 
-// NewControlPlane returns an instantiated ControlPlane.
+// NewControlPlane returns an instantiated ControlPlane. The infrastructure template is fetched from
+// infraTemplateNamespace, once the access of the RKE2ControlPlane to it is checked, or not at all when it is empty.
 func NewControlPlane(
 	ctx context.Context,
 	client client.Client,
 	cluster *clusterv1.Cluster,
 	rcp *controlplanev1.RKE2ControlPlane,
 	ownedMachines collections.Machines,
+	infraTemplateNamespace string,
 ) (*ControlPlane, error) {
 	infraObjects, err := getInfraResources(ctx, client, ownedMachines)
 	if err != nil {
@@ -74,6 +93,11 @@ func NewControlPlane(
 		return nil, err
 	}
 
+	infraTemplate, err := getInfraTemplate(ctx, client, rcp, infraTemplateNamespace)
+	if err != nil {
+		return nil, err
+	}
+
 	patchHelpers := map[string]*patch.Helper{}
 
 	for _, machine := range ownedMachines {
@@ -85,15 +109,58 @@ func NewControlPlane(
 		patchHelpers[machine.Name] = patchHelper
 	}
 
-	return &ControlPlane{
+	controlPlane := &ControlPlane{
 		RCP:                  rcp,
 		Cluster:              cluster,
 		Machines:             ownedMachines,
 		machinesPatchHelpers: patchHelpers,
 		rke2Configs:          rke2Configs,
 		infraResources:       infraObjects,
+		infraTemplate:        infraTemplate,
 		reconciliationTime:   metav1.Now(),
-	}, nil
+	}
+
+	decision := NewReconcileDecision(controlPlane)
+	controlPlane.Decision = &decision
+
+	return controlPlane, nil
+}
+
+// ForRole returns the control plane restricted to the machines of the given role, when the roles are split. The
+// machines it creates have the role, and it is scaled to the desired replicas of the role.
+func (c *ControlPlane) ForRole(role controlplanev1.MachineRole) *ControlPlane {
+	roleControlPlane := *c
+	roleControlPlane.Machines = c.Machines.Filter(HasRole(role))
+	roleControlPlane.Role = role
+
+	if c.allMachines == nil {
+		roleControlPlane.allMachines = c.Machines
+	}
+
+	return &roleControlPlane
+}
+
+// HasRole returns a filter to find the control plane machines of the given role.
+func HasRole(role controlplanev1.MachineRole) collections.Func {
+	return func(machine *clusterv1.Machine) bool {
+		return machine != nil && controlplanev1.MachineRole(machine.Labels[controlplanev1.MachineRoleLabel]) == role
+	}
+}
+
+// EtcdMachines returns the control plane machines running etcd, which are all the machines unless the roles are split,
+// whatever the role the control plane is restricted to.
+func (c *ControlPlane) EtcdMachines() collections.Machines {
+	machines := c.Machines
+	if c.allMachines != nil {
+		machines = c.allMachines
+	}
+
+	return machines.Filter(collections.Not(HasRole(controlplanev1.ControlPlaneMachineRole)))
+}
+
+// InfrastructureMachines returns the infrastructure machines of the control plane machines, by machine name.
+func (c *ControlPlane) InfrastructureMachines() map[string]*unstructured.Unstructured {
+	return c.infraResources
 }
 
 // Logger returns a logger with useful context.
@@ -110,9 +177,11 @@ func (c *ControlPlane) FailureDomains() clusterv1.FailureDomains {
 	return c.Cluster.Status.FailureDomains
 }
 
-// Version returns the RKE2ControlPlane's version.
+// Version returns the RKE2 version the machines of the RKE2ControlPlane are rolled out to.
 func (c *ControlPlane) Version() *string {
-	return &c.RCP.Spec.AgentConfig.Version
+	version := c.RCP.TargetVersion()
+
+	return &version
 }
 
 // InfrastructureRef returns the RKE2ControlPlane's infrastructure template.
@@ -132,9 +201,12 @@ func (c *ControlPlane) AsOwnerReference() *metav1.OwnerReference {
 
 // MachineInFailureDomainWithMostMachines returns the first matching failure domain with machines that has the most control-plane machines on it.
 func (c *ControlPlane) MachineInFailureDomainWithMostMachines(machines collections.Machines) (*clusterv1.Machine, error) {
+	// The machine the controller runs on is deleted last, so it is moved to an up to date machine first.
+	machines = c.withoutControllerMachine(machines)
+
 	fd := c.FailureDomainWithMostMachines(machines)
 	machinesInFailureDomain := machines.Filter(collections.InFailureDomains(fd))
-	machineToMark := machinesInFailureDomain.Oldest()
+	machineToMark := selectMachine(machinesInFailureDomain, c.RCP.Spec.SelectionPolicy)
 
 	if machineToMark == nil {
 		return nil, errors.New("failed to pick control plane Machine to mark for deletion")
@@ -143,6 +215,24 @@ func (c *ControlPlane) MachineInFailureDomainWithMostMachines(machines collectio
 	return machineToMark, nil
 }
 
+// selectMachine returns the machine selected by the policy, the oldest one by default.
+func selectMachine(machines collections.Machines, policy controlplanev1.MachineSelectionPolicy) *clusterv1.Machine {
+	switch policy {
+	case controlplanev1.NewestMachineSelectionPolicy:
+		return machines.Newest()
+	case controlplanev1.RandomMachineSelectionPolicy:
+		if machines.Len() == 0 {
+			return nil
+		}
+
+		sortedMachines := machines.SortedByCreationTimestamp()
+
+		return sortedMachines[rand.Intn(len(sortedMachines))] //nolint:gosec
+	default:
+		return machines.Oldest()
+	}
+}
+
 // MachineWithDeleteAnnotation returns a machine that has been annotated with DeleteMachineAnnotation key.
 func (c *ControlPlane) MachineWithDeleteAnnotation(machines collections.Machines) collections.Machines {
 	// See if there are any machines with DeleteMachineAnnotation key.
@@ -180,6 +270,7 @@ func (c *ControlPlane) NextFailureDomainForScaleUp() *string {
 // InitialControlPlaneConfig returns a new RKE2ConfigSpec that is to be used for an initializing control plane.
 func (c *ControlPlane) InitialControlPlaneConfig() *bootstrapv1.RKE2ConfigSpec {
 	bootstrapSpec := c.RCP.Spec.RKE2ConfigSpec.DeepCopy()
+	bootstrapSpec.AgentConfig = c.RCP.TargetAgentConfig()
 
 	return bootstrapSpec
 }
@@ -187,6 +278,7 @@ func (c *ControlPlane) InitialControlPlaneConfig() *bootstrapv1.RKE2ConfigSpec {
 // JoinControlPlaneConfig returns a new RKE2ConfigSpec that is to be used for joining control planes.
 func (c *ControlPlane) JoinControlPlaneConfig() *bootstrapv1.RKE2ConfigSpec {
 	bootstrapSpec := c.RCP.Spec.RKE2ConfigSpec.DeepCopy()
+	bootstrapSpec.AgentConfig = c.RCP.TargetAgentConfig()
 
 	return bootstrapSpec
 }
@@ -205,7 +297,7 @@ func (c *ControlPlane) GenerateRKE2Config(spec *bootstrapv1.RKE2ConfigSpec) *boo
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      names.SimpleNameGenerator.GenerateName(c.RCP.Name + "-"),
 			Namespace: c.RCP.Namespace,
-			Labels:    ControlPlaneLabelsForCluster(c.Cluster.Name),
+			Labels:    ControlPlaneLabelsForRole(c.Cluster.Name, c.Role),
 			OwnerReferences: []metav1.OwnerReference{
 				owner,
 			},
@@ -216,6 +308,16 @@ func (c *ControlPlane) GenerateRKE2Config(spec *bootstrapv1.RKE2ConfigSpec) *boo
 	return bootstrapConfig
 }
 
+// IsClusterControlPlane returns whether the RKE2ControlPlane is the control plane referenced by the Cluster. Several
+// clusters may share a namespace, a control plane only manages the machines of the cluster referencing it, in the
+// namespace of the cluster.
+func IsClusterControlPlane(cluster *clusterv1.Cluster, rcp *controlplanev1.RKE2ControlPlane) bool {
+	ref := cluster.Spec.ControlPlaneRef
+
+	return ref != nil && ref.Kind == "RKE2ControlPlane" && ref.Name == rcp.Name &&
+		(ref.Namespace == "" || ref.Namespace == cluster.Namespace) && cluster.Namespace == rcp.Namespace
+}
+
 // ControlPlaneLabelsForCluster returns a set of labels to add to a control plane machine for this specific cluster.
 func ControlPlaneLabelsForCluster(clusterName string) map[string]string {
 	return map[string]string{
@@ -224,13 +326,24 @@ func ControlPlaneLabelsForCluster(clusterName string) map[string]string {
 	}
 }
 
+// ControlPlaneLabelsForRole returns the labels to add to a control plane machine of the given role, and to its
+// RKE2Config, the role is omitted when the roles are not split.
+func ControlPlaneLabelsForRole(clusterName string, role controlplanev1.MachineRole) map[string]string {
+	labels := ControlPlaneLabelsForCluster(clusterName)
+	if role != "" {
+		labels[controlplanev1.MachineRoleLabel] = string(role)
+	}
+
+	return labels
+}
+
 // NewMachine returns a machine configured to be a part of the control plane.
 func (c *ControlPlane) NewMachine(infraRef, bootstrapRef *corev1.ObjectReference, failureDomain *string) *clusterv1.Machine {
 	return &clusterv1.Machine{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      names.SimpleNameGenerator.GenerateName(c.RCP.Name + "-"),
 			Namespace: c.RCP.Namespace,
-			Labels:    ControlPlaneLabelsForCluster(c.Cluster.Name),
+			Labels:    ControlPlaneLabelsForRole(c.Cluster.Name, c.Role),
 			OwnerReferences: []metav1.OwnerReference{
 				*metav1.NewControllerRef(c.RCP, controlplanev1.GroupVersion.WithKind("RKE2ControlPlane")),
 			},
@@ -253,8 +366,14 @@ func (c *ControlPlane) NeedsReplacementNode() bool {
 	if c.RCP.Spec.Replicas == nil {
 		return false
 	}
+
+	replicas := *c.RCP.Spec.Replicas
+	if c.Role != "" {
+		replicas = c.RCP.Spec.RoleReplicas(c.Role)
+	}
+
 	// if the number of existing machines is exactly 1 > than the number of replicas.
-	return len(c.Machines)+1 == int(*c.RCP.Spec.Replicas)
+	return len(c.Machines)+1 == int(replicas)
 }
 
 // HasDeletingMachine returns true if any machine in the control plane is in the process of being deleted.
@@ -269,11 +388,125 @@ func (c *ControlPlane) MachinesNeedingRollout() collections.Machines {
 
 	// Return machines if they are scheduled for rollout or if with an outdated configuration.
 	return machines.AnyFilter(
+		// Machines whose rollout has been requested with RolloutAfter, e.g. by "clusterctl alpha rollout restart".
+		collections.ShouldRolloutAfter(&c.reconciliationTime, c.RCP.Spec.RolloutAfter),
+		// Machines whose rollout has been requested with the restart annotation.
+		collections.ShouldRolloutAfter(&c.reconciliationTime, c.RCP.RestartTime()),
+		// Machines whose certificates expire within rolloutBefore.certificatesExpiryDays.
+		shouldRolloutBefore(&c.reconciliationTime, c.RCP.Spec.RolloutBefore),
 		// Machines that do not match with RCP config.
-		collections.Not(matchesRCPConfiguration(c.infraResources, c.rke2Configs, c.RCP)),
+		collections.Not(matchesRCPConfiguration(c.infraResources, c.rke2Configs, c.infraTemplate, c.RCP)),
+		// Machines placed in a failure domain which was removed, or moved to rebalance the failure domains.
+		c.needsFailureDomainChange(machines),
 	)
 }
 
+// RolloutReason returns the reason the machines are rolled out: the change of the spec first, as it replaces all the
+// machines, then the rollouts requested by the restart annotation or rolloutAfter, then the expiry of the certificates,
+// and the change of failure domain otherwise.
+func (c *ControlPlane) RolloutReason(needRollout collections.Machines) controlplanev1.RolloutReason {
+	reasons := []struct {
+		reason controlplanev1.RolloutReason
+		filter collections.Func
+	}{
+		{controlplanev1.SpecChangedRolloutReason,
+			collections.Not(matchesRCPConfiguration(c.infraResources, c.rke2Configs, c.infraTemplate, c.RCP))},
+		{controlplanev1.RestartRolloutReason, collections.ShouldRolloutAfter(&c.reconciliationTime, c.RCP.RestartTime())},
+		{controlplanev1.RolloutAfterRolloutReason, collections.ShouldRolloutAfter(&c.reconciliationTime, c.RCP.Spec.RolloutAfter)},
+		{controlplanev1.CertificatesExpiryRolloutReason, shouldRolloutBefore(&c.reconciliationTime, c.RCP.Spec.RolloutBefore)},
+	}
+
+	for _, reason := range reasons {
+		if len(needRollout.Filter(reason.filter)) > 0 {
+			return reason.reason
+		}
+	}
+
+	return controlplanev1.FailureDomainRolloutReason
+}
+
+// MachinesInRemovedFailureDomains returns the machines placed in a failure domain which is not a control plane failure
+// domain of the cluster anymore, e.g. after the infrastructure provider removed it.
+func (c *ControlPlane) MachinesInRemovedFailureDomains() collections.Machines {
+	failureDomains := c.FailureDomains().FilterControlPlane()
+	if len(failureDomains) == 0 {
+		return collections.New()
+	}
+
+	return c.Machines.Filter(func(machine *clusterv1.Machine) bool {
+		if machine.Spec.FailureDomain == nil || *machine.Spec.FailureDomain == "" {
+			return false
+		}
+
+		_, ok := failureDomains[*machine.Spec.FailureDomain]
+
+		return !ok
+	})
+}
+
+// MachineToRebalance returns the oldest machine of the failure domain with the most machines, when it has at least two
+// machines more than the failure domain with the fewest machines, e.g. after the infrastructure provider added a
+// failure domain. Replacing it places a machine in the failure domain with the fewest machines.
+func (c *ControlPlane) MachineToRebalance(machines collections.Machines) *clusterv1.Machine {
+	failureDomains := c.FailureDomains().FilterControlPlane()
+	if len(failureDomains) < 2 {
+		return nil
+	}
+
+	ids := make([]string, 0, len(failureDomains))
+	counts := make(map[string]int, len(failureDomains))
+
+	for id := range failureDomains {
+		ids = append(ids, id)
+		counts[id] = 0
+	}
+
+	// The failure domains are sorted so that the same machine is selected by every reconciliation.
+	sort.Strings(ids)
+
+	for _, machine := range machines {
+		if machine.Spec.FailureDomain == nil {
+			continue
+		}
+
+		if _, ok := counts[*machine.Spec.FailureDomain]; ok {
+			counts[*machine.Spec.FailureDomain]++
+		}
+	}
+
+	var most, fewest string
+
+	for _, id := range ids {
+		if most == "" || counts[id] > counts[most] {
+			most = id
+		}
+
+		if fewest == "" || counts[id] < counts[fewest] {
+			fewest = id
+		}
+	}
+
+	if counts[most]-counts[fewest] < 2 {
+		return nil
+	}
+
+	return machines.Filter(collections.InFailureDomains(&most)).Oldest()
+}
+
+// needsFailureDomainChange returns a filter to find the machines to move to another failure domain.
+func (c *ControlPlane) needsFailureDomainChange(machines collections.Machines) collections.Func {
+	removed := c.MachinesInRemovedFailureDomains()
+	rebalanced := c.MachineToRebalance(machines)
+
+	return func(machine *clusterv1.Machine) bool {
+		if _, ok := removed[machine.Name]; ok {
+			return true
+		}
+
+		return rebalanced != nil && rebalanced.Name == machine.Name
+	}
+}
+
 // UpToDateMachines returns the machines that are up to date with the control
 // plane's configuration and therefore do not require rollout.
 func (c *ControlPlane) UpToDateMachines() collections.Machines {
@@ -301,6 +534,31 @@ func getInfraResources(ctx context.Context, cl client.Client, machines collectio
 	return result, nil
 }
 
+// getInfraTemplate fetches the infrastructure template of the RKE2ControlPlane from the given namespace, when it is
+// needed to compare the machines images. The template isn't fetched without a namespace, when the RKE2ControlPlane
+// isn't allowed to use it.
+func getInfraTemplate(
+	ctx context.Context,
+	cl client.Client,
+	rcp *controlplanev1.RKE2ControlPlane,
+	namespace string,
+) (*unstructured.Unstructured, error) {
+	if rcp.Spec.InfrastructureImageFieldPath == "" || namespace == "" {
+		return nil, nil //nolint:nilnil
+	}
+
+	infraTemplate, err := external.Get(ctx, cl, &rcp.Spec.InfrastructureRef, namespace)
+	if err != nil {
+		if apierrors.IsNotFound(errors.Cause(err)) {
+			return nil, nil //nolint:nilnil
+		}
+
+		return nil, errors.Wrap(err, "failed to retrieve infrastructure template")
+	}
+
+	return infraTemplate, nil
+}
+
 // getRKE2Configs fetches the RKE2 config for each machine in the collection and returns a map of machine.Name -> RKE2Config.
 func getRKE2Configs(ctx context.Context, cl client.Client, machines collections.Machines) (map[string]*bootstrapv1.RKE2Config, error) {
 	result := map[string]*bootstrapv1.RKE2Config{}
@@ -342,6 +600,61 @@ func (c *ControlPlane) HasUnhealthyMachine() bool {
 	return len(c.UnhealthyMachines()) > 0
 }
 
+// PatchMachineAnnotations sets and removes annotations of the machines in the control plane with an optimistic lock,
+// retrying on conflicts, as other controllers annotate the machines too.
+func (c *ControlPlane) PatchMachineAnnotations(
+	ctx context.Context,
+	cl client.Client,
+	reader client.Reader,
+	set map[string]string,
+	remove ...string,
+) error {
+	errList := []error{}
+
+	for _, machine := range c.Machines {
+		if err := PatchAnnotations(ctx, cl, reader, machine, set, remove...); err != nil {
+			errList = append(errList, err)
+		}
+	}
+
+	return kerrors.NewAggregate(errList)
+}
+
+// PatchAnnotations sets and removes annotations of a machine with an optimistic lock, retrying on conflicts. The rest
+// of the machine is left untouched, its patch helper being based on the version read initially.
+func PatchAnnotations(
+	ctx context.Context,
+	cl client.Client,
+	reader client.Reader,
+	machine *clusterv1.Machine,
+	set map[string]string,
+	remove ...string,
+) error {
+	latest := machine.DeepCopy()
+
+	if err := PatchWithRetry(ctx, cl, reader, latest, func() error {
+		annotations.AddAnnotations(latest, set)
+
+		for _, key := range remove {
+			delete(latest.Annotations, key)
+		}
+
+		return nil
+	}); err != nil {
+		return errors.Wrapf(err, "failed to patch annotations of machine %s", machine.Name)
+	}
+
+	machine.Annotations = latest.Annotations
+
+	return nil
+}
+
+// IsEtcdManaged returns true if the servers run the embedded etcd, whose members are managed by the provider, and
+// false when they use an external datastore.
+func (c *ControlPlane) IsEtcdManaged() bool {
+	return c.RCP.Spec.ServerConfig.ExternalDatastore == nil
+}
+
 // PatchMachines patches the machines in the control plane.
 func (c *ControlPlane) PatchMachines(ctx context.Context) error {
 	errList := []error{}
@@ -352,6 +665,7 @@ func (c *ControlPlane) PatchMachines(ctx context.Context) error {
 			if err := helper.Patch(ctx, machine, patch.WithOwnedConditions{Conditions: []clusterv1.ConditionType{
 				controlplanev1.MachineAgentHealthyCondition,
 				controlplanev1.MachineEtcdMemberHealthyCondition,
+				controlplanev1.MachineProvisionedCondition,
 			}}); err != nil {
 				errList = append(errList, errors.Wrapf(err, "failed to patch machine %s", machine.Name))
 			}
@@ -0,0 +1,93 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rke2
+
+import (
+	"context"
+	"encoding/json"
+	"regexp"
+	"time"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// etcdSnapshotsConfigMapName is the name of the ConfigMap of the kube-system namespace RKE2 lists the etcd snapshots
+// of the cluster in.
+const etcdSnapshotsConfigMapName = "rke2-etcd-snapshots"
+
+// etcdSnapshotNameRegexp matches the names of the etcd snapshots saved by RKE2, the snapshot name is passed to a
+// command run on the nodes.
+var etcdSnapshotNameRegexp = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// etcdSnapshotFile is an etcd snapshot listed by RKE2, the snapshots stored in S3 are listed with the "s3" node name.
+type etcdSnapshotFile struct {
+	Name      string    `json:"name"`
+	NodeName  string    `json:"nodeName"`
+	CreatedAt time.Time `json:"createdAt"`
+	Status    string    `json:"status,omitempty"`
+}
+
+// LatestEtcdS3Snapshot returns the name of the most recent etcd snapshot stored in S3 successfully, as listed by
+// RKE2 in the workload cluster, or an empty name when there is none.
+func (w *Workload) LatestEtcdS3Snapshot(ctx context.Context) (string, error) {
+	configMap := &corev1.ConfigMap{}
+
+	err := w.Client.Get(ctx, ctrlclient.ObjectKey{Namespace: metav1.NamespaceSystem, Name: etcdSnapshotsConfigMapName},
+		configMap)
+	if apierrors.IsNotFound(err) {
+		return "", nil
+	}
+
+	if err != nil {
+		return "", errors.Wrap(err, "failed to get the etcd snapshots")
+	}
+
+	return latestEtcdS3Snapshot(configMap.Data), nil
+}
+
+// latestEtcdS3Snapshot returns the name of the most recent etcd snapshot stored in S3 successfully among the snapshots
+// listed by RKE2, the entries which can't be decoded are ignored.
+func latestEtcdS3Snapshot(snapshots map[string]string) string {
+	var latest *etcdSnapshotFile
+
+	for _, data := range snapshots {
+		snapshot := &etcdSnapshotFile{}
+		if err := json.Unmarshal([]byte(data), snapshot); err != nil {
+			continue
+		}
+
+		if snapshot.NodeName != "s3" || (snapshot.Status != "" && snapshot.Status != "successful") ||
+			!etcdSnapshotNameRegexp.MatchString(snapshot.Name) {
+			continue
+		}
+
+		if latest == nil || snapshot.CreatedAt.After(latest.CreatedAt) ||
+			(snapshot.CreatedAt.Equal(latest.CreatedAt) && snapshot.Name > latest.Name) {
+			latest = snapshot
+		}
+	}
+
+	if latest == nil {
+		return ""
+	}
+
+	return latest.Name
+}
@@ -0,0 +1,57 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rke2
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("LatestEtcdS3Snapshot", func() {
+	It("should return no snapshot when RKE2 lists none", func() {
+		workload := &Workload{Client: fake.NewClientBuilder().Build()}
+
+		name, err := workload.LatestEtcdS3Snapshot(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(name).To(BeEmpty())
+	})
+
+	It("should return the most recent snapshot stored in S3 successfully", func() {
+		configMap := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Namespace: metav1.NamespaceSystem, Name: etcdSnapshotsConfigMapName},
+			Data: map[string]string{
+				"s3-old": `{"name":"etcd-snapshot-old","nodeName":"s3","createdAt":"2023-06-01T10:00:00Z","status":"successful"}`,
+				"s3-new": `{"name":"etcd-snapshot-new","nodeName":"s3","createdAt":"2023-06-01T11:00:00Z"}`,
+				"local":  `{"name":"etcd-snapshot-local","nodeName":"node-1","createdAt":"2023-06-01T12:00:00Z"}`,
+				"failed": `{"name":"etcd-snapshot-failed","nodeName":"s3","createdAt":"2023-06-01T12:00:00Z","status":"failed"}`,
+				"unsafe": `{"name":"etcd-snapshot; reboot","nodeName":"s3","createdAt":"2023-06-01T12:00:00Z"}`,
+				"broken": `{"name":`,
+			},
+		}
+		workload := &Workload{Client: fake.NewClientBuilder().WithObjects(configMap).Build()}
+
+		name, err := workload.LatestEtcdS3Snapshot(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(name).To(Equal("etcd-snapshot-new"))
+	})
+})
@@ -0,0 +1,290 @@
+/*
+Copyright 2023 SUSE.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rke2
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/pointer"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util/collections"
+
+	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+)
+
+var _ = Describe("selectMachine", func() {
+	var machines collections.Machines
+
+	BeforeEach(func() {
+		now := time.Now()
+		machines = collections.FromMachines(
+			&clusterv1.Machine{ObjectMeta: metav1.ObjectMeta{Name: "old", CreationTimestamp: metav1.NewTime(now.Add(-time.Hour))}},
+			&clusterv1.Machine{ObjectMeta: metav1.ObjectMeta{Name: "new", CreationTimestamp: metav1.NewTime(now)}},
+		)
+	})
+
+	It("should select the oldest machine by default", func() {
+		Expect(selectMachine(machines, "").Name).To(Equal("old"))
+		Expect(selectMachine(machines, controlplanev1.OldestMachineSelectionPolicy).Name).To(Equal("old"))
+	})
+
+	It("should select the newest machine", func() {
+		Expect(selectMachine(machines, controlplanev1.NewestMachineSelectionPolicy).Name).To(Equal("new"))
+	})
+
+	It("should select one of the machines at random", func() {
+		Expect(selectMachine(machines, controlplanev1.RandomMachineSelectionPolicy).Name).To(BeElementOf("old", "new"))
+		Expect(selectMachine(collections.New(), controlplanev1.RandomMachineSelectionPolicy)).To(BeNil())
+	})
+})
+
+var _ = Describe("failure domain changes", func() {
+	var (
+		created      time.Time
+		controlPlane *ControlPlane
+	)
+
+	newMachine := func(name, failureDomain string) *clusterv1.Machine {
+		created = created.Add(time.Minute)
+
+		return &clusterv1.Machine{
+			ObjectMeta: metav1.ObjectMeta{Name: name, CreationTimestamp: metav1.NewTime(created)},
+			Spec:       clusterv1.MachineSpec{FailureDomain: &failureDomain},
+		}
+	}
+
+	setFailureDomains := func(ids ...string) {
+		controlPlane.Cluster.Status.FailureDomains = clusterv1.FailureDomains{}
+		for _, id := range ids {
+			controlPlane.Cluster.Status.FailureDomains[id] = clusterv1.FailureDomainSpec{ControlPlane: true}
+		}
+	}
+
+	BeforeEach(func() {
+		created = time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+		controlPlane = &ControlPlane{
+			Cluster: &clusterv1.Cluster{},
+			Machines: collections.FromMachines(
+				newMachine("m1", "a"),
+				newMachine("m2", "a"),
+				newMachine("m3", "b"),
+			),
+		}
+	})
+
+	It("should find the machines in removed failure domains", func() {
+		setFailureDomains("a", "c")
+		Expect(controlPlane.MachinesInRemovedFailureDomains().Names()).To(ConsistOf("m3"))
+
+		setFailureDomains()
+		Expect(controlPlane.MachinesInRemovedFailureDomains()).To(BeEmpty())
+	})
+
+	It("should rebalance the machines on added failure domains", func() {
+		setFailureDomains("a", "b")
+		Expect(controlPlane.MachineToRebalance(controlPlane.Machines)).To(BeNil())
+
+		controlPlane.Machines.Insert(newMachine("m4", "b"))
+		setFailureDomains("a", "b", "c")
+		Expect(controlPlane.MachineToRebalance(controlPlane.Machines).Name).To(Equal("m1"))
+	})
+})
+
+var _ = Describe("machine roles", func() {
+	var controlPlane *ControlPlane
+
+	newMachine := func(name string, role controlplanev1.MachineRole) *clusterv1.Machine {
+		return &clusterv1.Machine{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Labels: ControlPlaneLabelsForRole("cluster", role)},
+		}
+	}
+
+	BeforeEach(func() {
+		controlPlane = &ControlPlane{
+			RCP: &controlplanev1.RKE2ControlPlane{
+				Spec: controlplanev1.RKE2ControlPlaneSpec{Replicas: pointer.Int32(2), EtcdReplicas: pointer.Int32(3)},
+			},
+			Cluster: &clusterv1.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "cluster"}},
+			Machines: collections.FromMachines(
+				newMachine("etcd-1", controlplanev1.EtcdMachineRole),
+				newMachine("etcd-2", controlplanev1.EtcdMachineRole),
+				newMachine("control-plane-1", controlplanev1.ControlPlaneMachineRole),
+			),
+		}
+	})
+
+	It("should label the machines with their role when the roles are split", func() {
+		Expect(ControlPlaneLabelsForRole("cluster", "")).ToNot(HaveKey(controlplanev1.MachineRoleLabel))
+		Expect(ControlPlaneLabelsForRole("cluster", controlplanev1.EtcdMachineRole)).To(
+			HaveKeyWithValue(controlplanev1.MachineRoleLabel, "etcd"))
+	})
+
+	It("should restrict the control plane to the machines of a role", func() {
+		etcdControlPlane := controlPlane.ForRole(controlplanev1.EtcdMachineRole)
+		Expect(etcdControlPlane.Machines.Names()).To(ConsistOf("etcd-1", "etcd-2"))
+		Expect(etcdControlPlane.NewMachine(&corev1.ObjectReference{}, &corev1.ObjectReference{}, nil).Labels).To(
+			HaveKeyWithValue(controlplanev1.MachineRoleLabel, "etcd"))
+
+		apiServerControlPlane := controlPlane.ForRole(controlplanev1.ControlPlaneMachineRole)
+		Expect(apiServerControlPlane.Machines.Names()).To(ConsistOf("control-plane-1"))
+		Expect(apiServerControlPlane.NeedsReplacementNode()).To(BeTrue())
+		Expect(controlPlane.Machines.Len()).To(Equal(3))
+	})
+
+	It("should find the machines running etcd whatever the role of the control plane", func() {
+		Expect(controlPlane.EtcdMachines().Names()).To(ConsistOf("etcd-1", "etcd-2"))
+		Expect(controlPlane.ForRole(controlplanev1.ControlPlaneMachineRole).EtcdMachines().Names()).To(
+			ConsistOf("etcd-1", "etcd-2"))
+
+		controlPlane.Machines = collections.FromMachines(newMachine("m1", ""), newMachine("m2", ""))
+		Expect(controlPlane.EtcdMachines().Names()).To(ConsistOf("m1", "m2"))
+	})
+
+	It("should count the desired machines of each role", func() {
+		Expect(controlPlane.RCP.Spec.MachineReplicas()).To(Equal(int32(5)))
+		Expect(controlPlane.RCP.Spec.RoleReplicas(controlplanev1.EtcdMachineRole)).To(Equal(int32(3)))
+		Expect(controlPlane.RCP.Spec.RoleReplicas(controlplanev1.ControlPlaneMachineRole)).To(Equal(int32(2)))
+
+		controlPlane.RCP.Spec.EtcdReplicas = nil
+		Expect(controlPlane.RCP.Spec.MachineReplicas()).To(Equal(int32(2)))
+		Expect(controlPlane.RCP.Spec.RoleReplicas(controlplanev1.EtcdMachineRole)).To(BeZero())
+	})
+})
+
+var _ = Describe("IsClusterControlPlane", func() {
+	It("should only match the control plane referenced by the cluster in its namespace", func() {
+		cluster := &clusterv1.Cluster{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "shared", Name: "cluster"},
+			Spec: clusterv1.ClusterSpec{
+				ControlPlaneRef: &corev1.ObjectReference{Kind: "RKE2ControlPlane", Name: "cluster-control-plane"},
+			},
+		}
+		rcp := &controlplanev1.RKE2ControlPlane{ObjectMeta: metav1.ObjectMeta{Namespace: "shared", Name: "cluster-control-plane"}}
+
+		Expect(IsClusterControlPlane(cluster, rcp)).To(BeTrue())
+
+		cluster.Spec.ControlPlaneRef.Namespace = "shared"
+		Expect(IsClusterControlPlane(cluster, rcp)).To(BeTrue())
+
+		other := &controlplanev1.RKE2ControlPlane{ObjectMeta: metav1.ObjectMeta{Namespace: "shared", Name: "cluster-2-control-plane"}}
+		Expect(IsClusterControlPlane(cluster, other)).To(BeFalse())
+
+		elsewhere := &controlplanev1.RKE2ControlPlane{ObjectMeta: metav1.ObjectMeta{Namespace: "other", Name: "cluster-control-plane"}}
+		Expect(IsClusterControlPlane(cluster, elsewhere)).To(BeFalse())
+
+		cluster.Spec.ControlPlaneRef = nil
+		Expect(IsClusterControlPlane(cluster, rcp)).To(BeFalse())
+	})
+})
+
+var _ = Describe("rolloutAfter", func() {
+	var (
+		now          time.Time
+		controlPlane *ControlPlane
+	)
+
+	newMachine := func(name string, created time.Time) *clusterv1.Machine {
+		return &clusterv1.Machine{
+			ObjectMeta: metav1.ObjectMeta{Name: name, CreationTimestamp: metav1.NewTime(created)},
+			Spec:       clusterv1.MachineSpec{Version: pointer.String("v1.26.4")},
+		}
+	}
+
+	BeforeEach(func() {
+		now = time.Now()
+		controlPlane = &ControlPlane{
+			RCP:     &controlplanev1.RKE2ControlPlane{},
+			Cluster: &clusterv1.Cluster{},
+			Machines: collections.FromMachines(
+				newMachine("old", now.Add(-2*time.Hour)),
+				newMachine("new", now.Add(-time.Minute)),
+			),
+			reconciliationTime: metav1.NewTime(now),
+		}
+		controlPlane.RCP.Spec.AgentConfig.Version = "v1.26.4+rke2r1"
+	})
+
+	It("should not roll the machines out without rolloutAfter", func() {
+		Expect(controlPlane.MachinesNeedingRollout()).To(BeEmpty())
+	})
+
+	It("should roll out the machines created before rolloutAfter once it is reached", func() {
+		rolloutAfter := metav1.NewTime(now.Add(-time.Hour))
+		controlPlane.RCP.Spec.RolloutAfter = &rolloutAfter
+
+		Expect(controlPlane.MachinesNeedingRollout().Names()).To(ConsistOf("old"))
+		Expect(controlPlane.UpToDateMachines().Names()).To(ConsistOf("new"))
+		Expect(controlPlane.RolloutReason(controlPlane.MachinesNeedingRollout())).
+			To(Equal(controlplanev1.RolloutAfterRolloutReason))
+	})
+
+	It("should not roll the machines out before rolloutAfter is reached", func() {
+		rolloutAfter := metav1.NewTime(now.Add(time.Hour))
+		controlPlane.RCP.Spec.RolloutAfter = &rolloutAfter
+
+		Expect(controlPlane.MachinesNeedingRollout()).To(BeEmpty())
+	})
+
+	It("should roll out the machines created before the time of the restart annotation once it is reached", func() {
+		controlPlane.RCP.Annotations = map[string]string{
+			controlplanev1.RestartAnnotation: now.Add(-time.Hour).UTC().Format(time.RFC3339),
+		}
+
+		Expect(controlPlane.MachinesNeedingRollout().Names()).To(ConsistOf("old"))
+		Expect(controlPlane.RolloutReason(controlPlane.MachinesNeedingRollout())).
+			To(Equal(controlplanev1.RestartRolloutReason))
+
+		controlPlane.RCP.Annotations[controlplanev1.RestartAnnotation] = now.Add(time.Hour).UTC().Format(time.RFC3339)
+
+		Expect(controlPlane.MachinesNeedingRollout()).To(BeEmpty())
+	})
+
+	It("should roll out the machines whose certificates expire within rolloutBefore", func() {
+		controlPlane.RCP.Spec.RolloutBefore = &controlplanev1.RolloutBefore{CertificatesExpiryDays: pointer.Int32(21)}
+		controlPlane.Machines["old"].Status.CertificatesExpiryDate = &metav1.Time{Time: now.Add(20 * 24 * time.Hour)}
+		controlPlane.Machines["new"].Status.CertificatesExpiryDate = &metav1.Time{Time: now.Add(300 * 24 * time.Hour)}
+
+		Expect(controlPlane.MachinesNeedingRollout().Names()).To(ConsistOf("old"))
+		Expect(controlPlane.RolloutReason(controlPlane.MachinesNeedingRollout())).
+			To(Equal(controlplanev1.CertificatesExpiryRolloutReason))
+
+		controlPlane.RCP.Spec.RolloutBefore = nil
+		Expect(controlPlane.MachinesNeedingRollout()).To(BeEmpty())
+	})
+
+	It("should report a change of version rather than a restart", func() {
+		controlPlane.RCP.Annotations = map[string]string{
+			controlplanev1.RestartAnnotation: now.Add(-time.Hour).UTC().Format(time.RFC3339),
+		}
+		controlPlane.RCP.Spec.AgentConfig.Version = "v1.27.1+rke2r1"
+
+		Expect(controlPlane.MachinesNeedingRollout().Names()).To(ConsistOf("old", "new"))
+		Expect(controlPlane.RolloutReason(controlPlane.MachinesNeedingRollout())).
+			To(Equal(controlplanev1.SpecChangedRolloutReason))
+	})
+
+	It("should ignore a restart annotation which is not a time", func() {
+		controlPlane.RCP.Annotations = map[string]string{controlplanev1.RestartAnnotation: "now"}
+
+		Expect(controlPlane.MachinesNeedingRollout()).To(BeEmpty())
+	})
+})
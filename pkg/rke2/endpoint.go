@@ -0,0 +1,119 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rke2
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+)
+
+var (
+	// ErrControlPlaneEndpointUnresolved is returned by ValidateControlPlaneEndpoint when the host of the control plane
+	// endpoint doesn't resolve.
+	ErrControlPlaneEndpointUnresolved = errors.New("control plane endpoint doesn't resolve")
+
+	// ErrControlPlaneEndpointMismatch is returned by ValidateControlPlaneEndpoint when the host of the control plane
+	// endpoint resolves to none of the addresses of the control plane.
+	ErrControlPlaneEndpointMismatch = errors.New("control plane endpoint points at no control plane address")
+)
+
+// endpointLookupTimeout bounds each lookup of the control plane endpoint and of the registration addresses, so that
+// an unresponsive DNS server doesn't block the generation of the bootstrap data.
+const endpointLookupTimeout = 5 * time.Second
+
+// Resolver resolves host names to addresses, it is implemented by net.Resolver.
+type Resolver interface {
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+// ValidateControlPlaneEndpoint resolves the host of the control plane endpoint from the management cluster, and checks
+// that it points at one of the addresses of the control plane: the addresses of its available servers or its
+// registration addresses, e.g. the load balancers in front of the servers, whose host names are resolved as well.
+// The host is only checked to resolve while the control plane has no address.
+func ValidateControlPlaneEndpoint(
+	ctx context.Context,
+	resolver Resolver,
+	host string,
+	rcp *controlplanev1.RKE2ControlPlane,
+) error {
+	resolved, err := lookupHost(ctx, resolver, host)
+	if err != nil {
+		return errors.Wrapf(ErrControlPlaneEndpointUnresolved, "%s: %v", host, err)
+	}
+
+	// The addresses are normalized, as IPv6 addresses have several forms.
+	controlPlaneAddresses := sets.NewString()
+	for _, address := range rcp.Status.AvailableServerIPs {
+		controlPlaneAddresses.Insert(NormalizeAddress(address))
+	}
+
+	for _, registrationAddress := range rcp.Spec.RegistrationAddresses {
+		controlPlaneAddresses.Insert(NormalizeAddress(registrationAddress.Address))
+	}
+
+	if controlPlaneAddresses.Len() == 0 || controlPlaneAddresses.Has(NormalizeAddress(host)) {
+		return nil
+	}
+
+	// The registration addresses may be the host names of load balancers, the endpoint may point at their addresses.
+	for _, registrationAddress := range rcp.Spec.RegistrationAddresses {
+		if net.ParseIP(registrationAddress.Address) != nil {
+			continue
+		}
+
+		addresses, err := lookupHost(ctx, resolver, registrationAddress.Address)
+		if err != nil {
+			continue
+		}
+
+		for _, address := range addresses {
+			controlPlaneAddresses.Insert(NormalizeAddress(address))
+		}
+	}
+
+	for _, address := range resolved {
+		if controlPlaneAddresses.Has(NormalizeAddress(address)) {
+			return nil
+		}
+	}
+
+	return errors.Wrapf(ErrControlPlaneEndpointMismatch, "%s points at %v, the control plane addresses are %v",
+		host, resolved, controlPlaneAddresses.List())
+}
+
+// lookupHost returns the addresses of a host, which is its own address when it is an IP address.
+func lookupHost(ctx context.Context, resolver Resolver, host string) ([]string, error) {
+	if net.ParseIP(host) != nil {
+		return []string{host}, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, endpointLookupTimeout)
+	defer cancel()
+
+	addresses, err := resolver.LookupHost(ctx, host)
+	if err == nil && len(addresses) == 0 {
+		err = errors.New("no address")
+	}
+
+	return addresses, err
+}
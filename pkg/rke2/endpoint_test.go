@@ -0,0 +1,111 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rke2
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pkg/errors"
+
+	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+)
+
+// fakeResolver resolves the hosts it maps to addresses, the lookups must be bounded by a timeout.
+type fakeResolver map[string][]string
+
+func (r fakeResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		return nil, errors.Errorf("lookup %s: no timeout", host)
+	}
+
+	addresses, ok := r[host]
+	if !ok {
+		return nil, errors.Errorf("lookup %s: no such host", host)
+	}
+
+	return addresses, nil
+}
+
+var _ = Describe("ValidateControlPlaneEndpoint", func() {
+	var (
+		resolver fakeResolver
+		rcp      *controlplanev1.RKE2ControlPlane
+	)
+
+	BeforeEach(func() {
+		resolver = fakeResolver{
+			"api.example.com":   {"10.0.0.1", "10.0.0.2"},
+			"stale.example.com": {"10.0.0.9"},
+		}
+		rcp = &controlplanev1.RKE2ControlPlane{
+			Status: controlplanev1.RKE2ControlPlaneStatus{AvailableServerIPs: []string{"10.0.0.2", "10.0.0.3"}},
+		}
+	})
+
+	It("should accept an endpoint pointing at a control plane machine", func() {
+		Expect(ValidateControlPlaneEndpoint(context.Background(), resolver, "api.example.com", rcp)).To(Succeed())
+		Expect(ValidateControlPlaneEndpoint(context.Background(), resolver, "10.0.0.3", rcp)).To(Succeed())
+	})
+
+	It("should accept an endpoint pointing at a registration address", func() {
+		rcp.Spec.RegistrationAddresses = []controlplanev1.RegistrationAddress{{Address: "10.0.0.9"}}
+
+		Expect(ValidateControlPlaneEndpoint(context.Background(), resolver, "stale.example.com", rcp)).To(Succeed())
+	})
+
+	It("should accept an endpoint pointing at the addresses of a load balancer registration address", func() {
+		resolver["lb.example.com"] = []string{"10.0.0.10"}
+		resolver["api-lb.example.com"] = []string{"10.0.0.10"}
+		rcp.Spec.RegistrationAddresses = []controlplanev1.RegistrationAddress{{Address: "lb.example.com"}}
+
+		Expect(ValidateControlPlaneEndpoint(context.Background(), resolver, "api-lb.example.com", rcp)).To(Succeed())
+		Expect(ValidateControlPlaneEndpoint(context.Background(), resolver, "10.0.0.10", rcp)).To(Succeed())
+
+		err := ValidateControlPlaneEndpoint(context.Background(), resolver, "stale.example.com", rcp)
+		Expect(errors.Is(err, ErrControlPlaneEndpointMismatch)).To(BeTrue())
+	})
+
+	It("should compare the IPv6 addresses in their canonical form", func() {
+		resolver["api6.example.com"] = []string{"fd00::2"}
+		rcp.Status.AvailableServerIPs = []string{"fd00:0:0::2"}
+
+		Expect(ValidateControlPlaneEndpoint(context.Background(), resolver, "api6.example.com", rcp)).To(Succeed())
+		Expect(ValidateControlPlaneEndpoint(context.Background(), resolver, "fd00::2", rcp)).To(Succeed())
+	})
+
+	It("should reject an endpoint that doesn't resolve", func() {
+		err := ValidateControlPlaneEndpoint(context.Background(), resolver, "typo.example.com", rcp)
+		Expect(errors.Is(err, ErrControlPlaneEndpointUnresolved)).To(BeTrue())
+	})
+
+	It("should reject an endpoint pointing at no control plane address", func() {
+		err := ValidateControlPlaneEndpoint(context.Background(), resolver, "stale.example.com", rcp)
+		Expect(errors.Is(err, ErrControlPlaneEndpointMismatch)).To(BeTrue())
+
+		err = ValidateControlPlaneEndpoint(context.Background(), resolver, "10.0.0.9", rcp)
+		Expect(errors.Is(err, ErrControlPlaneEndpointMismatch)).To(BeTrue())
+	})
+
+	It("should only check that the endpoint resolves while the control plane has no address", func() {
+		rcp.Status.AvailableServerIPs = nil
+
+		Expect(ValidateControlPlaneEndpoint(context.Background(), resolver, "stale.example.com", rcp)).To(Succeed())
+	})
+})
@@ -0,0 +1,476 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rke2
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	kerrors "k8s.io/apimachinery/pkg/util/errors"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"sigs.k8s.io/cluster-api/util/certs"
+
+	"github.com/rancher-sandbox/cluster-api-provider-rke2/pkg/secret"
+)
+
+const (
+	// EtcdClientPort is the port the etcd members of RKE2 serve their clients on.
+	EtcdClientPort = "2379"
+
+	// etcdClientName is the common name of the client certificate the etcd cluster of a workload cluster is inspected
+	// with.
+	etcdClientName = "rke2-control-plane-etcd-client"
+
+	// etcdDefragmentTimeout is the timeout of the defragmentation of an etcd member, which takes longer than the other
+	// requests as it rewrites the whole database.
+	etcdDefragmentTimeout = 5 * time.Minute
+)
+
+// EtcdMember is a member of the etcd cluster of a workload cluster.
+type EtcdMember struct {
+	ID         uint64   `json:"ID,string"`
+	Name       string   `json:"name"`
+	ClientURLs []string `json:"clientURLs"`
+	IsLearner  bool     `json:"isLearner"`
+}
+
+// EtcdAlarm is an alarm raised by a member of the etcd cluster of a workload cluster, e.g. NOSPACE.
+type EtcdAlarm struct {
+	MemberID uint64 `json:"memberID,string"`
+	Alarm    string `json:"alarm"`
+}
+
+// EtcdMemberStatus is the status of a member of the etcd cluster of a workload cluster.
+type EtcdMemberStatus struct {
+	Leader      uint64 `json:"leader,string"`
+	DBSize      int64  `json:"dbSize,string"`
+	DBSizeInUse int64  `json:"dbSizeInUse,string"`
+}
+
+// EtcdClient inspects the etcd cluster of a workload cluster.
+type EtcdClient interface {
+	// MemberList returns the members of the etcd cluster, as reported by the first of the endpoints answering.
+	MemberList(ctx context.Context, endpoints []string) ([]EtcdMember, error)
+	// Alarms returns the alarms raised in the etcd cluster, as reported by the first of the endpoints answering.
+	Alarms(ctx context.Context, endpoints []string) ([]EtcdAlarm, error)
+	// Health returns an error when the member serving the endpoint is not healthy.
+	Health(ctx context.Context, endpoint string) error
+	// Status returns the status of the member serving the endpoint.
+	Status(ctx context.Context, endpoint string) (EtcdMemberStatus, error)
+	// Defragment defragments the database of the member serving the endpoint, the member doesn't serve any request
+	// meanwhile.
+	Defragment(ctx context.Context, endpoint string) error
+	// MemberRemove removes a member from the etcd cluster, through the first of the endpoints answering.
+	MemberRemove(ctx context.Context, endpoints []string, id uint64) error
+	// MoveLeader transfers the leadership of the etcd cluster to another member, through the leader serving the
+	// endpoint.
+	MoveLeader(ctx context.Context, endpoint string, targetID uint64) error
+}
+
+// etcdGatewayClient is an EtcdClient talking to the JSON gateway of the etcd v3 API served by the etcd members.
+type etcdGatewayClient struct {
+	httpClient       *http.Client
+	defragmentClient *http.Client
+}
+
+// NewEtcdClient returns an EtcdClient connecting to the etcd members with the given TLS configuration, through the
+// dial function, or directly when it is nil.
+func NewEtcdClient(tlsConfig *tls.Config, timeout time.Duration, dial DialFunc) EtcdClient {
+	transport := &http.Transport{TLSClientConfig: tlsConfig, Proxy: http.ProxyFromEnvironment}
+	if dial != nil {
+		transport.Proxy = nil
+		transport.DialContext = dial
+	}
+
+	return &etcdGatewayClient{
+		httpClient:       &http.Client{Timeout: timeout, Transport: transport},
+		defragmentClient: &http.Client{Timeout: etcdDefragmentTimeout, Transport: transport},
+	}
+}
+
+// observerEtcdClient is an EtcdClient logging the changes to the etcd cluster instead of making them, in the observer
+// mode of the controllers.
+type observerEtcdClient struct {
+	EtcdClient
+}
+
+func (c *observerEtcdClient) Defragment(ctx context.Context, endpoint string) error {
+	log.FromContext(ctx).Info("Not writing in observer mode", "verb", "defragment", "endpoint", endpoint)
+
+	return nil
+}
+
+func (c *observerEtcdClient) MemberRemove(ctx context.Context, _ []string, id uint64) error {
+	log.FromContext(ctx).Info("Not writing in observer mode", "verb", "memberremove", "member", fmt.Sprintf("%x", id))
+
+	return nil
+}
+
+func (c *observerEtcdClient) MoveLeader(ctx context.Context, endpoint string, targetID uint64) error {
+	log.FromContext(ctx).Info("Not writing in observer mode", "verb", "moveleader", "endpoint", endpoint,
+		"member", fmt.Sprintf("%x", targetID))
+
+	return nil
+}
+
+// NewEtcdTLSConfig returns the TLS configuration of an EtcdClient, trusting the etcd certificate authority and
+// authenticating with a client certificate it signs. The expiry of the client certificate is returned as well.
+func NewEtcdTLSConfig(etcdCA *secret.Certificate) (*tls.Config, time.Time, error) {
+	keyPair, err := etcdCA.NewClientCertificate(etcdClientName, nil)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	clientCert, err := tls.X509KeyPair(keyPair.Cert, keyPair.Key)
+	if err != nil {
+		return nil, time.Time{}, errors.Wrap(err, "failed to load the etcd client certificate")
+	}
+
+	cert, err := certs.DecodeCertPEM(keyPair.Cert)
+	if err != nil {
+		return nil, time.Time{}, errors.Wrap(err, "failed to decode the etcd client certificate")
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(etcdCA.KeyPair.Cert) {
+		return nil, time.Time{}, errors.New("failed to load the etcd certificate authority")
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{clientCert},
+		RootCAs:      pool,
+		MinVersion:   tls.VersionTLS12,
+	}, cert.NotAfter, nil
+}
+
+// EtcdEndpoint returns the client endpoint of the etcd member running on a control plane node address.
+func EtcdEndpoint(address string) string {
+	return "https://" + net.JoinHostPort(strings.TrimSuffix(strings.TrimPrefix(address, "["), "]"), EtcdClientPort)
+}
+
+// MemberList implements EtcdClient.
+func (c *etcdGatewayClient) MemberList(ctx context.Context, endpoints []string) ([]EtcdMember, error) {
+	response := struct {
+		Members []EtcdMember `json:"members"`
+	}{}
+
+	if err := c.postAny(ctx, endpoints, "/v3/cluster/member/list", map[string]interface{}{}, &response); err != nil {
+		return nil, errors.Wrap(err, "failed to list the etcd members")
+	}
+
+	return response.Members, nil
+}
+
+// Alarms implements EtcdClient.
+func (c *etcdGatewayClient) Alarms(ctx context.Context, endpoints []string) ([]EtcdAlarm, error) {
+	response := struct {
+		Alarms []EtcdAlarm `json:"alarms"`
+	}{}
+
+	if err := c.postAny(ctx, endpoints, "/v3/maintenance/alarm", map[string]interface{}{"action": "GET"}, &response); err != nil {
+		return nil, errors.Wrap(err, "failed to list the etcd alarms")
+	}
+
+	alarms := []EtcdAlarm{}
+
+	for _, alarm := range response.Alarms {
+		if alarm.Alarm != "" && alarm.Alarm != "NONE" {
+			alarms = append(alarms, alarm)
+		}
+	}
+
+	return alarms, nil
+}
+
+// Health implements EtcdClient, the health endpoint of a member reports it unhealthy when it has no leader. The alarms
+// are excluded from the health of the members, as they are reported for the whole cluster by Alarms.
+func (c *etcdGatewayClient) Health(ctx context.Context, endpoint string) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		strings.TrimSuffix(endpoint, "/")+"/health?exclude=NOSPACE&exclude=CORRUPT", nil)
+	if err != nil {
+		return errors.Wrap(err, "failed to create the etcd health request")
+	}
+
+	response := struct {
+		Health string `json:"health"`
+		Reason string `json:"reason"`
+	}{}
+
+	if err := c.do(c.httpClient, request, &response); err != nil && response.Health == "" {
+		return err
+	}
+
+	if response.Health != "true" {
+		if response.Reason != "" {
+			return errors.Errorf("etcd member %s is unhealthy: %s", endpoint, response.Reason)
+		}
+
+		return errors.Errorf("etcd member %s is unhealthy", endpoint)
+	}
+
+	return nil
+}
+
+// Status implements EtcdClient.
+func (c *etcdGatewayClient) Status(ctx context.Context, endpoint string) (EtcdMemberStatus, error) {
+	status := EtcdMemberStatus{}
+
+	if err := c.post(ctx, c.httpClient, endpoint, "/v3/maintenance/status", map[string]interface{}{}, &status); err != nil {
+		return status, errors.Wrap(err, "failed to get the status of the etcd member")
+	}
+
+	return status, nil
+}
+
+// Defragment implements EtcdClient.
+func (c *etcdGatewayClient) Defragment(ctx context.Context, endpoint string) error {
+	response := map[string]interface{}{}
+
+	if err := c.post(ctx, c.defragmentClient, endpoint, "/v3/maintenance/defragment", map[string]interface{}{},
+		&response); err != nil {
+		return errors.Wrap(err, "failed to defragment the etcd member")
+	}
+
+	return nil
+}
+
+// MemberRemove implements EtcdClient.
+func (c *etcdGatewayClient) MemberRemove(ctx context.Context, endpoints []string, id uint64) error {
+	response := map[string]interface{}{}
+
+	if err := c.postAny(ctx, endpoints, "/v3/cluster/member/remove",
+		map[string]interface{}{"ID": strconv.FormatUint(id, 10)}, &response); err != nil {
+		return errors.Wrapf(err, "failed to remove etcd member %x", id)
+	}
+
+	return nil
+}
+
+// MoveLeader implements EtcdClient.
+func (c *etcdGatewayClient) MoveLeader(ctx context.Context, endpoint string, targetID uint64) error {
+	response := map[string]interface{}{}
+
+	if err := c.post(ctx, c.httpClient, endpoint, "/v3/maintenance/transfer-leadership",
+		map[string]interface{}{"targetID": strconv.FormatUint(targetID, 10)}, &response); err != nil {
+		return errors.Wrapf(err, "failed to move the etcd leadership to member %x", targetID)
+	}
+
+	return nil
+}
+
+// postAny posts the request to the endpoints in turn, until one of them answers.
+func (c *etcdGatewayClient) postAny(ctx context.Context, endpoints []string, path string, body, into interface{}) error {
+	if len(endpoints) == 0 {
+		return errors.New("no etcd endpoint")
+	}
+
+	errs := []error{}
+
+	for _, endpoint := range endpoints {
+		if err := c.post(ctx, c.httpClient, endpoint, path, body, into); err != nil {
+			errs = append(errs, err)
+
+			continue
+		}
+
+		return nil
+	}
+
+	return kerrors.NewAggregate(errs)
+}
+
+// post posts the request to an endpoint with the given HTTP client.
+func (c *etcdGatewayClient) post(
+	ctx context.Context,
+	httpClient *http.Client,
+	endpoint, path string,
+	body, into interface{},
+) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal the etcd request")
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(endpoint, "/")+path,
+		bytes.NewReader(data))
+	if err != nil {
+		return errors.Wrap(err, "failed to create the etcd request")
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+
+	return c.do(httpClient, request, into)
+}
+
+// do sends a request to an etcd member and decodes its answer, an error is returned unless the answer is a success.
+func (c *etcdGatewayClient) do(httpClient *http.Client, request *http.Request, into interface{}) error {
+	response, err := httpClient.Do(request)
+	if err != nil {
+		return errors.Wrapf(err, "failed to contact etcd member %s", request.URL.Host)
+	}
+	defer response.Body.Close()
+
+	decodeErr := json.NewDecoder(response.Body).Decode(into)
+
+	if response.StatusCode != http.StatusOK {
+		return errors.Errorf("etcd member %s answered %s", request.URL.Host, response.Status)
+	}
+
+	if decodeErr != nil {
+		return errors.Wrapf(decodeErr, "failed to decode the answer of etcd member %s", request.URL.Host)
+	}
+
+	return nil
+}
+
+// EtcdClusterHealth is the health of the etcd cluster of a workload cluster.
+type EtcdClusterHealth struct {
+	// Members are the members of the etcd cluster.
+	Members []EtcdMember
+	// Unhealthy are the names of the unhealthy members, with the reason they are unhealthy.
+	Unhealthy map[string]string
+	// Alarms are the alarms raised in the etcd cluster.
+	Alarms []EtcdAlarm
+}
+
+// InspectEtcdCluster lists the members and the alarms of the etcd cluster through the endpoints, and checks the health
+// of each member.
+func InspectEtcdCluster(ctx context.Context, client EtcdClient, endpoints []string) (EtcdClusterHealth, error) {
+	health := EtcdClusterHealth{Unhealthy: map[string]string{}}
+
+	members, err := client.MemberList(ctx, endpoints)
+	if err != nil {
+		return health, err
+	}
+
+	health.Members = members
+
+	alarms, err := client.Alarms(ctx, endpoints)
+	if err != nil {
+		return health, err
+	}
+
+	health.Alarms = alarms
+
+	for _, member := range members {
+		if len(member.ClientURLs) == 0 {
+			health.Unhealthy[member.Name] = "not started"
+
+			continue
+		}
+
+		// The members of dual-stack clusters may advertise a client URL per address family, the member is healthy as
+		// soon as one of them answers.
+		for _, clientURL := range member.ClientURLs {
+			err := client.Health(ctx, clientURL)
+			if err == nil {
+				delete(health.Unhealthy, member.Name)
+
+				break
+			}
+
+			health.Unhealthy[member.Name] = err.Error()
+		}
+	}
+
+	return health, nil
+}
+
+// VotingMembers returns the number of members of the etcd cluster which are not learners.
+func (h EtcdClusterHealth) VotingMembers() int {
+	voting := 0
+
+	for _, member := range h.Members {
+		if !member.IsLearner {
+			voting++
+		}
+	}
+
+	return voting
+}
+
+// HasQuorum returns whether a majority of the voting members of the etcd cluster is healthy.
+func (h EtcdClusterHealth) HasQuorum() bool {
+	healthy := 0
+
+	for _, member := range h.Members {
+		if _, unhealthy := h.Unhealthy[member.Name]; !member.IsLearner && !unhealthy {
+			healthy++
+		}
+	}
+
+	return healthy > h.VotingMembers()/2
+}
+
+// Summary describes the members, the quorum and the alarms of the etcd cluster.
+func (h EtcdClusterHealth) Summary() string {
+	quorum := "quorum kept"
+	if !h.HasQuorum() {
+		quorum = "quorum lost"
+	}
+
+	summary := fmt.Sprintf("%d members, %d unhealthy, %s", len(h.Members), len(h.Unhealthy), quorum)
+
+	if len(h.Unhealthy) > 0 {
+		names := make([]string, 0, len(h.Unhealthy))
+		for name := range h.Unhealthy {
+			names = append(names, name)
+		}
+
+		sort.Strings(names)
+		summary += "; unhealthy members: " + strings.Join(names, ", ")
+	}
+
+	if len(h.Alarms) == 0 {
+		return summary + "; no alarm"
+	}
+
+	return summary + "; alarms: " + h.AlarmsSummary()
+}
+
+// AlarmsSummary describes the alarms raised in the etcd cluster, along with the members raising them.
+func (h EtcdClusterHealth) AlarmsSummary() string {
+	names := map[uint64]string{}
+	for _, member := range h.Members {
+		names[member.ID] = member.Name
+	}
+
+	alarms := make([]string, 0, len(h.Alarms))
+
+	for _, alarm := range h.Alarms {
+		member := names[alarm.MemberID]
+		if member == "" {
+			member = fmt.Sprintf("%x", alarm.MemberID)
+		}
+
+		alarms = append(alarms, fmt.Sprintf("%s on %s", alarm.Alarm, member))
+	}
+
+	return strings.Join(alarms, ", ")
+}
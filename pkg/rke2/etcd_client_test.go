@@ -0,0 +1,225 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rke2
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"sigs.k8s.io/cluster-api/util/certs"
+
+	"github.com/rancher-sandbox/cluster-api-provider-rke2/pkg/secret"
+)
+
+var _ = Describe("EtcdClient", func() {
+	var (
+		server *httptest.Server
+		client EtcdClient
+		health map[string]interface{}
+	)
+
+	BeforeEach(func() {
+		health = map[string]interface{}{"health": "true"}
+
+		server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var response interface{}
+
+			switch r.URL.Path {
+			case "/v3/cluster/member/list":
+				response = map[string]interface{}{"members": []map[string]interface{}{
+					{"ID": "1311768467294899695", "name": "node-1-5c6e2f1a", "clientURLs": []string{"https://10.0.0.1:2379"}},
+					{"ID": "42", "name": "node-2-0b1f2e3d", "isLearner": true},
+				}}
+			case "/v3/maintenance/alarm":
+				response = map[string]interface{}{"alarms": []map[string]interface{}{
+					{"memberID": "1311768467294899695", "alarm": "NOSPACE"},
+					{"memberID": "42", "alarm": "NONE"},
+				}}
+			case "/v3/maintenance/status":
+				response = map[string]interface{}{"leader": "1311768467294899695", "dbSize": "8192", "dbSizeInUse": "4096"}
+			case "/v3/maintenance/defragment":
+				response = map[string]interface{}{"header": map[string]interface{}{"member_id": "1311768467294899695"}}
+			case "/v3/maintenance/transfer-leadership":
+				body := map[string]interface{}{}
+				Expect(json.NewDecoder(r.Body).Decode(&body)).To(Succeed())
+
+				if body["targetID"] != "42" {
+					w.WriteHeader(http.StatusBadRequest)
+
+					return
+				}
+
+				response = map[string]interface{}{"header": map[string]interface{}{"member_id": "1311768467294899695"}}
+			case "/v3/cluster/member/remove":
+				body := map[string]interface{}{}
+				Expect(json.NewDecoder(r.Body).Decode(&body)).To(Succeed())
+
+				if body["ID"] != "42" {
+					w.WriteHeader(http.StatusNotFound)
+
+					return
+				}
+
+				response = map[string]interface{}{"members": []map[string]interface{}{}}
+			case "/health":
+				Expect(r.URL.Query()["exclude"]).To(ConsistOf("NOSPACE", "CORRUPT"))
+
+				if health["health"] != "true" {
+					w.WriteHeader(http.StatusServiceUnavailable)
+				}
+
+				response = health
+			default:
+				w.WriteHeader(http.StatusNotFound)
+
+				return
+			}
+
+			Expect(json.NewEncoder(w).Encode(response)).To(Succeed())
+		}))
+
+		tlsConfig := server.Client().Transport.(*http.Transport).TLSClientConfig
+		client = NewEtcdClient(tlsConfig, 5*time.Second, nil)
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("should list the members through the first endpoint answering", func() {
+		members, err := client.MemberList(context.Background(), []string{"https://127.0.0.1:1", server.URL})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(members).To(Equal([]EtcdMember{
+			{ID: 1311768467294899695, Name: "node-1-5c6e2f1a", ClientURLs: []string{"https://10.0.0.1:2379"}},
+			{ID: 42, Name: "node-2-0b1f2e3d", IsLearner: true},
+		}))
+
+		_, err = client.MemberList(context.Background(), []string{"https://127.0.0.1:1"})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should list the raised alarms", func() {
+		alarms, err := client.Alarms(context.Background(), []string{server.URL})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(alarms).To(Equal([]EtcdAlarm{{MemberID: 1311768467294899695, Alarm: "NOSPACE"}}))
+	})
+
+	It("should report the health of a member", func() {
+		Expect(client.Health(context.Background(), server.URL)).To(Succeed())
+
+		health = map[string]interface{}{"health": "false", "reason": "RAFT NO LEADER"}
+		Expect(client.Health(context.Background(), server.URL)).To(MatchError(ContainSubstring("RAFT NO LEADER")))
+	})
+
+	It("should get the status of a member", func() {
+		status, err := client.Status(context.Background(), server.URL)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(status).To(Equal(EtcdMemberStatus{Leader: 1311768467294899695, DBSize: 8192, DBSizeInUse: 4096}))
+	})
+
+	It("should defragment a member", func() {
+		Expect(client.Defragment(context.Background(), server.URL)).To(Succeed())
+		Expect(client.Defragment(context.Background(), "https://127.0.0.1:1")).ToNot(Succeed())
+	})
+
+	It("should remove a member", func() {
+		Expect(client.MemberRemove(context.Background(), []string{"https://127.0.0.1:1", server.URL}, 42)).To(Succeed())
+		Expect(client.MemberRemove(context.Background(), []string{server.URL}, 43)).ToNot(Succeed())
+	})
+
+	It("should move the leadership to another member", func() {
+		Expect(client.MoveLeader(context.Background(), server.URL, 42)).To(Succeed())
+		Expect(client.MoveLeader(context.Background(), server.URL, 43)).ToNot(Succeed())
+	})
+})
+
+var _ = Describe("NewEtcdTLSConfig", func() {
+	It("should authenticate with a client certificate signed by the etcd certificate authority", func() {
+		etcdCA := secret.NewEtcdCACertificate()
+		Expect(etcdCA.Generate()).To(Succeed())
+
+		tlsConfig, notAfter, err := NewEtcdTLSConfig(etcdCA)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(notAfter).To(BeTemporally(">", time.Now().Add(certificateRenewalThreshold)))
+		Expect(tlsConfig.MinVersion).To(BeEquivalentTo(tls.VersionTLS12))
+		Expect(tlsConfig.Certificates).To(HaveLen(1))
+
+		caCert, err := certs.DecodeCertPEM(etcdCA.KeyPair.Cert)
+		Expect(err).ToNot(HaveOccurred())
+
+		clientCert, err := x509.ParseCertificate(tlsConfig.Certificates[0].Certificate[0])
+		Expect(err).ToNot(HaveOccurred())
+		Expect(clientCert.CheckSignatureFrom(caCert)).To(Succeed())
+		Expect(clientCert.Subject.CommonName).To(Equal(etcdClientName))
+	})
+})
+
+var _ = Describe("InspectEtcdCluster", func() {
+	It("should probe every client URL of the members of a dual-stack cluster", func() {
+		etcdClient := &fakeEtcdClient{
+			members: []EtcdMember{
+				{ID: 1, Name: "m1", ClientURLs: []string{EtcdEndpoint("10.0.0.1"), EtcdEndpoint("fd00::1")}},
+				{ID: 2, Name: "m2", ClientURLs: []string{EtcdEndpoint("10.0.0.2"), EtcdEndpoint("fd00::2")}},
+			},
+			unhealthy: map[string]bool{
+				EtcdEndpoint("10.0.0.1"): true,
+				EtcdEndpoint("10.0.0.2"): true,
+				EtcdEndpoint("fd00::2"):  true,
+			},
+		}
+
+		health, err := InspectEtcdCluster(context.Background(), etcdClient, []string{EtcdEndpoint("10.0.0.1")})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(health.Unhealthy).To(HaveLen(1))
+		Expect(health.Unhealthy).To(HaveKey("m2"))
+	})
+})
+
+var _ = Describe("EtcdClusterHealth", func() {
+	members := []EtcdMember{
+		{ID: 1, Name: "m1"},
+		{ID: 2, Name: "m2"},
+		{ID: 3, Name: "m3"},
+		{ID: 4, Name: "m4", IsLearner: true},
+	}
+
+	It("should keep the quorum while a majority of the voting members is healthy", func() {
+		health := EtcdClusterHealth{Members: members, Unhealthy: map[string]string{"m2": "unreachable", "m4": "not started"}}
+		Expect(health.VotingMembers()).To(Equal(3))
+		Expect(health.HasQuorum()).To(BeTrue())
+		Expect(health.Summary()).To(Equal("4 members, 2 unhealthy, quorum kept; unhealthy members: m2, m4; no alarm"))
+
+		health.Unhealthy["m3"] = "unreachable"
+		Expect(health.HasQuorum()).To(BeFalse())
+	})
+
+	It("should name the members raising alarms", func() {
+		health := EtcdClusterHealth{Members: members, Unhealthy: map[string]string{}, Alarms: []EtcdAlarm{
+			{MemberID: 1, Alarm: "NOSPACE"},
+			{MemberID: 255, Alarm: "CORRUPT"},
+		}}
+		Expect(health.Summary()).To(Equal("4 members, 0 unhealthy, quorum kept; alarms: NOSPACE on m1, CORRUPT on ff"))
+	})
+})
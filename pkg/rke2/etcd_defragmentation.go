@@ -0,0 +1,151 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rke2
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+
+	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+)
+
+// DefaultEtcdDefragmentationInterval is the time between two defragmentations of the etcd members, when the control
+// plane doesn't configure it.
+const DefaultEtcdDefragmentationInterval = 24 * time.Hour
+
+var (
+	// ErrEtcdClientUnavailable is returned when the etcd cluster of a workload cluster can't be reached, as the etcd
+	// certificate authority is not provided by the management cluster.
+	ErrEtcdClientUnavailable = errors.New("etcd certificate authority not provided by the management cluster")
+
+	// ErrEtcdMembersUnhealthy is returned by DefragmentEtcd when some etcd members are unhealthy, no member is
+	// defragmented then as it would make the etcd cluster even less available.
+	ErrEtcdMembersUnhealthy = errors.New("etcd members are unhealthy")
+)
+
+// EtcdDefragmentationDue returns whether the etcd members of the control plane are to be defragmented, because the
+// defragmentation is in progress or its interval elapsed since the last one, and how long to wait for the next one
+// otherwise.
+func EtcdDefragmentationDue(rcp *controlplanev1.RKE2ControlPlane, now time.Time) (bool, time.Duration) {
+	defragmentation := rcp.Spec.ServerConfig.Etcd.Defragmentation
+	if defragmentation == nil {
+		return false, 0
+	}
+
+	last := rcp.Status.LastEtcdDefragmentationTime
+	if len(rcp.Status.EtcdDefragmentedMembers) > 0 || last == nil {
+		return true, 0
+	}
+
+	interval := DefaultEtcdDefragmentationInterval
+	if defragmentation.Interval != nil {
+		interval = defragmentation.Interval.Duration
+	}
+
+	if next := last.Add(interval); now.Before(next) {
+		return false, next.Sub(now)
+	}
+
+	return true, 0
+}
+
+// NextEtcdMemberToDefragment returns the next etcd member to defragment among the members not defragmented yet, the
+// followers by name and the leader last, as defragmenting the leader makes the cluster unavailable until it's done.
+// False is returned once all the members are defragmented.
+func NextEtcdMemberToDefragment(members []EtcdMember, leaderID uint64, defragmented []string) (EtcdMember, bool) {
+	done := map[string]bool{}
+	for _, name := range defragmented {
+		done[name] = true
+	}
+
+	candidates := []EtcdMember{}
+
+	for _, member := range members {
+		if !done[member.Name] && len(member.ClientURLs) > 0 {
+			candidates = append(candidates, member)
+		}
+	}
+
+	if len(candidates) == 0 {
+		return EtcdMember{}, false
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		if (candidates[i].ID == leaderID) != (candidates[j].ID == leaderID) {
+			return candidates[j].ID == leaderID
+		}
+
+		return candidates[i].Name < candidates[j].Name
+	})
+
+	return candidates[0], true
+}
+
+// DefragmentEtcd defragments the next etcd member not among the defragmented ones, the leader last, once all the
+// members are healthy. It returns the name of the defragmented member, or an empty name when all the members are
+// defragmented.
+func (w *Workload) DefragmentEtcd(ctx context.Context, defragmented []string) (string, error) {
+	if w.EtcdClient == nil {
+		return "", ErrEtcdClientUnavailable
+	}
+
+	nodes, err := w.getEtcdNodes(ctx)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to list the etcd nodes")
+	}
+
+	health, err := InspectEtcdCluster(ctx, w.EtcdClient, etcdEndpoints(nodes))
+	if err != nil {
+		return "", err
+	}
+
+	// The member defragmented last is healthy again before the next one is defragmented.
+	if len(health.Unhealthy) > 0 {
+		return "", errors.Wrap(ErrEtcdMembersUnhealthy, health.Summary())
+	}
+
+	var leaderID uint64
+
+	for _, member := range health.Members {
+		if len(member.ClientURLs) == 0 {
+			continue
+		}
+
+		status, err := w.EtcdClient.Status(ctx, member.ClientURLs[0])
+		if err != nil {
+			return "", err
+		}
+
+		leaderID = status.Leader
+
+		break
+	}
+
+	member, found := NextEtcdMemberToDefragment(health.Members, leaderID, defragmented)
+	if !found {
+		return "", nil
+	}
+
+	if err := w.EtcdClient.Defragment(ctx, member.ClientURLs[0]); err != nil {
+		return "", errors.Wrapf(err, "failed to defragment etcd member %s", member.Name)
+	}
+
+	return member.Name, nil
+}
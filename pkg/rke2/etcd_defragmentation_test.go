@@ -0,0 +1,168 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rke2
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+)
+
+var _ = Describe("EtcdDefragmentationDue", func() {
+	var (
+		rcp *controlplanev1.RKE2ControlPlane
+		now time.Time
+	)
+
+	BeforeEach(func() {
+		rcp = &controlplanev1.RKE2ControlPlane{}
+		now = time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+	})
+
+	It("should not defragment unless enabled", func() {
+		due, _ := EtcdDefragmentationDue(rcp, now)
+		Expect(due).To(BeFalse())
+	})
+
+	It("should defragment once the interval elapsed since the last defragmentation", func() {
+		rcp.Spec.ServerConfig.Etcd.Defragmentation = &controlplanev1.EtcdDefragmentation{}
+
+		due, _ := EtcdDefragmentationDue(rcp, now)
+		Expect(due).To(BeTrue())
+
+		rcp.Status.LastEtcdDefragmentationTime = &metav1.Time{Time: now.Add(-time.Hour)}
+
+		due, wait := EtcdDefragmentationDue(rcp, now)
+		Expect(due).To(BeFalse())
+		Expect(wait).To(Equal(23 * time.Hour))
+
+		rcp.Spec.ServerConfig.Etcd.Defragmentation.Interval = &metav1.Duration{Duration: time.Hour}
+
+		due, _ = EtcdDefragmentationDue(rcp, now)
+		Expect(due).To(BeTrue())
+	})
+
+	It("should carry on a defragmentation in progress", func() {
+		rcp.Spec.ServerConfig.Etcd.Defragmentation = &controlplanev1.EtcdDefragmentation{}
+		rcp.Status.LastEtcdDefragmentationTime = &metav1.Time{Time: now}
+		rcp.Status.EtcdDefragmentedMembers = []string{"node-1"}
+
+		due, _ := EtcdDefragmentationDue(rcp, now)
+		Expect(due).To(BeTrue())
+	})
+})
+
+var _ = Describe("NextEtcdMemberToDefragment", func() {
+	members := []EtcdMember{
+		{ID: 3, Name: "node-3", ClientURLs: []string{EtcdEndpoint("10.0.0.3")}},
+		{ID: 1, Name: "node-1", ClientURLs: []string{EtcdEndpoint("10.0.0.1")}},
+		{ID: 2, Name: "node-2", ClientURLs: []string{EtcdEndpoint("10.0.0.2")}},
+	}
+
+	It("should defragment the followers by name and the leader last", func() {
+		defragmented := []string{}
+
+		for {
+			member, found := NextEtcdMemberToDefragment(members, 1, defragmented)
+			if !found {
+				break
+			}
+
+			defragmented = append(defragmented, member.Name)
+		}
+
+		Expect(defragmented).To(Equal([]string{"node-2", "node-3", "node-1"}))
+	})
+
+	It("should skip the members not started", func() {
+		_, found := NextEtcdMemberToDefragment([]EtcdMember{{ID: 4, Name: "node-4"}}, 1, nil)
+		Expect(found).To(BeFalse())
+	})
+})
+
+var _ = Describe("DefragmentEtcd", func() {
+	var (
+		workload   *Workload
+		etcdClient *fakeEtcdClient
+	)
+
+	BeforeEach(func() {
+		objs := []ctrlclient.Object{}
+		etcdClient = &fakeEtcdClient{unhealthy: map[string]bool{}, leader: 1}
+
+		for i := 1; i <= 3; i++ {
+			address := fmt.Sprintf("10.0.0.%d", i)
+
+			objs = append(objs, &corev1.Node{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:   fmt.Sprintf("node-%d", i),
+					Labels: map[string]string{labelNodeRoleControlPlane: "true", labelNodeRoleEtcd: "true"},
+				},
+				Status: corev1.NodeStatus{Addresses: []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: address}}},
+			})
+			etcdClient.members = append(etcdClient.members, EtcdMember{
+				ID:         uint64(i),
+				Name:       fmt.Sprintf("node-%d", i),
+				ClientURLs: []string{EtcdEndpoint(address)},
+			})
+		}
+
+		workload = &Workload{Client: fake.NewClientBuilder().WithObjects(objs...).Build(), EtcdClient: etcdClient}
+	})
+
+	It("should defragment one member at a time, the leader last", func() {
+		member, err := workload.DefragmentEtcd(context.Background(), nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(member).To(Equal("node-2"))
+
+		member, err = workload.DefragmentEtcd(context.Background(), []string{"node-2", "node-3"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(member).To(Equal("node-1"))
+
+		member, err = workload.DefragmentEtcd(context.Background(), []string{"node-1", "node-2", "node-3"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(member).To(BeEmpty())
+
+		Expect(etcdClient.defragmented).To(Equal([]string{EtcdEndpoint("10.0.0.2"), EtcdEndpoint("10.0.0.1")}))
+	})
+
+	It("should not defragment while a member is unhealthy", func() {
+		etcdClient.unhealthy[EtcdEndpoint("10.0.0.3")] = true
+
+		_, err := workload.DefragmentEtcd(context.Background(), nil)
+		Expect(errors.Is(err, ErrEtcdMembersUnhealthy)).To(BeTrue())
+		Expect(etcdClient.defragmented).To(BeEmpty())
+	})
+
+	It("should not defragment without an etcd client", func() {
+		workload.EtcdClient = nil
+
+		_, err := workload.DefragmentEtcd(context.Background(), nil)
+		Expect(errors.Is(err, ErrEtcdClientUnavailable)).To(BeTrue())
+	})
+})
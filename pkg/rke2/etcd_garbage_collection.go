@@ -0,0 +1,178 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rke2
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"sigs.k8s.io/cluster-api/util/collections"
+)
+
+// ErrEtcdQuorumLost is returned by RemoveOrphanedEtcdMembers when the etcd cluster has lost its quorum, members can't
+// be removed until it is recovered.
+var ErrEtcdQuorumLost = errors.New("etcd quorum is lost")
+
+// EtcdPendingMemberGracePeriod is how long an etcd member can stay pending, without a name as its etcd server didn't
+// start yet or as a learner catching up with the leader, before it is removed when backed by no machine.
+const EtcdPendingMemberGracePeriod = 15 * time.Minute
+
+// pendingEtcdMembers records since when the etcd members of a workload cluster are pending.
+type pendingEtcdMembers struct {
+	lock  sync.Mutex
+	since map[uint64]time.Time
+}
+
+// expired records the members pending for the first time, forgets the members no longer pending, and returns the IDs
+// of the members pending for longer than EtcdPendingMemberGracePeriod. No member expires when p is nil.
+func (p *pendingEtcdMembers) expired(members []EtcdMember, now time.Time) map[uint64]bool {
+	if p == nil {
+		return nil
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	since := map[uint64]time.Time{}
+	expired := map[uint64]bool{}
+
+	for _, member := range members {
+		if !isPendingEtcdMember(member) {
+			continue
+		}
+
+		since[member.ID] = now
+		if pendingSince, ok := p.since[member.ID]; ok {
+			since[member.ID] = pendingSince
+		}
+
+		expired[member.ID] = now.Sub(since[member.ID]) > EtcdPendingMemberGracePeriod
+	}
+
+	p.since = since
+
+	return expired
+}
+
+// isPendingEtcdMember returns true when the member is joining the etcd cluster.
+func isPendingEtcdMember(member EtcdMember) bool {
+	return member.Name == "" || member.IsLearner
+}
+
+// OrphanedEtcdMembers returns the etcd members backed by no control plane machine: the members whose name is not the
+// etcd member name of the node of a machine, e.g. after a failed scale down or the crash of an infrastructure machine.
+// Members which didn't start yet, and have no name, are orphaned as well.
+func OrphanedEtcdMembers(members []EtcdMember, nodes []corev1.Node, machines collections.Machines) []EtcdMember {
+	machineNodes := sets.NewString()
+
+	for _, machine := range machines {
+		if machine.Status.NodeRef != nil {
+			machineNodes.Insert(machine.Status.NodeRef.Name)
+		}
+	}
+
+	backed := sets.NewString()
+
+	for _, node := range nodes {
+		if name := node.Annotations[etcdNodeNameAnnotation]; name != "" && machineNodes.Has(node.Name) {
+			backed.Insert(name)
+		}
+	}
+
+	orphaned := []EtcdMember{}
+
+	for _, member := range members {
+		if member.Name == "" || !backed.Has(member.Name) {
+			orphaned = append(orphaned, member)
+		}
+	}
+
+	return orphaned
+}
+
+// RemoveOrphanedEtcdMembers removes the etcd members backed by no control plane machine, and returns their names.
+// Nothing is removed while a machine is provisioning, or while the node of a machine doesn't report its etcd member
+// yet, as its member can't be told apart from an orphaned one, nor when no member at all is backed by a machine.
+// The pending members, unnamed or learners, are only removed once pending for EtcdPendingMemberGracePeriod, as they
+// may belong to a server joining the cluster.
+func (w *Workload) RemoveOrphanedEtcdMembers(ctx context.Context, controlPlane *ControlPlane) ([]string, error) {
+	if w.EtcdClient == nil {
+		return nil, ErrEtcdClientUnavailable
+	}
+
+	if hasProvisioningMachine(controlPlane.Machines) {
+		return nil, nil
+	}
+
+	nodes, err := w.getEtcdNodes(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list the etcd nodes")
+	}
+
+	for _, node := range nodes.Items {
+		if node.Annotations[etcdNodeNameAnnotation] == "" {
+			return nil, nil
+		}
+	}
+
+	endpoints := etcdEndpoints(nodes)
+
+	health, err := InspectEtcdCluster(ctx, w.EtcdClient, endpoints)
+	if err != nil {
+		return nil, err
+	}
+
+	if !health.HasQuorum() {
+		return nil, errors.Wrap(ErrEtcdQuorumLost, health.Summary())
+	}
+
+	orphaned := OrphanedEtcdMembers(health.Members, nodes.Items, controlPlane.Machines)
+	if len(orphaned) == len(health.Members) {
+		return nil, nil
+	}
+
+	expired := w.pendingEtcdMembers.expired(health.Members, time.Now())
+	removed := []string{}
+
+	for _, member := range orphaned {
+		if isPendingEtcdMember(member) && !expired[member.ID] {
+			continue
+		}
+
+		name := member.Name
+		if name == "" {
+			name = fmt.Sprintf("%x", member.ID)
+		}
+
+		if err := w.EtcdClient.MemberRemove(ctx, endpoints, member.ID); err != nil {
+			return removed, errors.Wrapf(err, "failed to remove orphaned etcd member %s", name)
+		}
+
+		log.FromContext(ctx).Info("Removed orphaned etcd member", "member", name)
+
+		removed = append(removed, name)
+	}
+
+	return removed, nil
+}
@@ -0,0 +1,163 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rke2
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util/collections"
+
+	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+)
+
+var _ = Describe("RemoveOrphanedEtcdMembers", func() {
+	var (
+		objs         []ctrlclient.Object
+		workload     *Workload
+		controlPlane *ControlPlane
+		etcdClient   *fakeEtcdClient
+	)
+
+	BeforeEach(func() {
+		objs = []ctrlclient.Object{}
+		machines := collections.New()
+		etcdClient = &fakeEtcdClient{unhealthy: map[string]bool{}}
+
+		for i := 1; i <= 3; i++ {
+			name := fmt.Sprintf("node-%d", i)
+			address := fmt.Sprintf("10.0.0.%d", i)
+
+			objs = append(objs, &corev1.Node{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        name,
+					Labels:      map[string]string{labelNodeRoleControlPlane: "true", labelNodeRoleEtcd: "true"},
+					Annotations: map[string]string{etcdNodeNameAnnotation: name + "-5c6e2f1a"},
+				},
+				Status: corev1.NodeStatus{Addresses: []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: address}}},
+			})
+			machines.Insert(&clusterv1.Machine{
+				ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("machine-%d", i)},
+				Status:     clusterv1.MachineStatus{NodeRef: &corev1.ObjectReference{Name: name}},
+			})
+			etcdClient.members = append(etcdClient.members, EtcdMember{
+				ID:         uint64(i),
+				Name:       name + "-5c6e2f1a",
+				ClientURLs: []string{EtcdEndpoint(address)},
+			})
+		}
+
+		// The member of a machine whose deletion failed half way, and a member added by a machine which crashed before
+		// starting it.
+		etcdClient.members = append(etcdClient.members,
+			EtcdMember{ID: 4, Name: "node-4-0b1f2e3d", ClientURLs: []string{EtcdEndpoint("10.0.0.4")}},
+			EtcdMember{ID: 0x2a},
+		)
+		etcdClient.unhealthy[EtcdEndpoint("10.0.0.4")] = true
+
+		workload = &Workload{Client: fake.NewClientBuilder().WithObjects(objs...).Build(), EtcdClient: etcdClient}
+		controlPlane = &ControlPlane{RCP: &controlplanev1.RKE2ControlPlane{}, Machines: machines}
+	})
+
+	It("should remove the members backed by no machine", func() {
+		workload.pendingEtcdMembers = &pendingEtcdMembers{
+			since: map[uint64]time.Time{0x2a: time.Now().Add(-EtcdPendingMemberGracePeriod - time.Minute)},
+		}
+
+		removed, err := workload.RemoveOrphanedEtcdMembers(context.Background(), controlPlane)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(removed).To(Equal([]string{"node-4-0b1f2e3d", "2a"}))
+		Expect(etcdClient.removed).To(Equal([]uint64{4, 0x2a}))
+	})
+
+	It("should not remove the pending members within their grace period", func() {
+		workload.pendingEtcdMembers = &pendingEtcdMembers{}
+		etcdClient.members = append(etcdClient.members,
+			EtcdMember{ID: 5, Name: "node-5-7d8e9f0a", ClientURLs: []string{EtcdEndpoint("10.0.0.5")}, IsLearner: true})
+
+		removed, err := workload.RemoveOrphanedEtcdMembers(context.Background(), controlPlane)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(removed).To(Equal([]string{"node-4-0b1f2e3d"}))
+		Expect(workload.pendingEtcdMembers.since).To(HaveLen(2))
+
+		// The members which are no longer pending are forgotten.
+		etcdClient.members = append(etcdClient.members[:4], etcdClient.members[5])
+
+		_, err = workload.RemoveOrphanedEtcdMembers(context.Background(), controlPlane)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(workload.pendingEtcdMembers.since).To(HaveKey(uint64(5)))
+		Expect(workload.pendingEtcdMembers.since).ToNot(HaveKey(uint64(0x2a)))
+	})
+
+	It("should remove the member of a node without machine", func() {
+		controlPlane.Machines = controlPlane.Machines.Filter(func(machine *clusterv1.Machine) bool {
+			return machine.Name != "machine-3"
+		})
+		etcdClient.members = etcdClient.members[:3]
+
+		removed, err := workload.RemoveOrphanedEtcdMembers(context.Background(), controlPlane)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(removed).To(Equal([]string{"node-3-5c6e2f1a"}))
+	})
+
+	It("should not remove members while a machine is provisioning", func() {
+		controlPlane.Machines.Insert(&clusterv1.Machine{ObjectMeta: metav1.ObjectMeta{Name: "machine-4"}})
+
+		removed, err := workload.RemoveOrphanedEtcdMembers(context.Background(), controlPlane)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(removed).To(BeEmpty())
+		Expect(etcdClient.removed).To(BeEmpty())
+	})
+
+	It("should not remove members while a node doesn't report its member", func() {
+		objs = append(objs, &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "node-4", Labels: map[string]string{labelNodeRoleControlPlane: "true", labelNodeRoleEtcd: "true"}},
+		})
+		workload.Client = fake.NewClientBuilder().WithObjects(objs...).Build()
+
+		removed, err := workload.RemoveOrphanedEtcdMembers(context.Background(), controlPlane)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(removed).To(BeEmpty())
+		Expect(etcdClient.removed).To(BeEmpty())
+	})
+
+	It("should not remove members without quorum", func() {
+		etcdClient.unhealthy[EtcdEndpoint("10.0.0.1")] = true
+		etcdClient.unhealthy[EtcdEndpoint("10.0.0.2")] = true
+
+		_, err := workload.RemoveOrphanedEtcdMembers(context.Background(), controlPlane)
+		Expect(err).To(MatchError(ErrEtcdQuorumLost))
+		Expect(etcdClient.removed).To(BeEmpty())
+	})
+
+	It("should not remove members without etcd client", func() {
+		workload.EtcdClient = nil
+
+		_, err := workload.RemoveOrphanedEtcdMembers(context.Background(), controlPlane)
+		Expect(err).To(MatchError(ErrEtcdClientUnavailable))
+	})
+})
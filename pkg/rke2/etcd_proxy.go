@@ -0,0 +1,250 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rke2
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/websocket"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// portForwardProtocol is the channel protocol of the port forwarding over websockets, the first byte of each
+	// message is the channel: the data channel of the forwarded port is 0, its error channel is 1.
+	portForwardProtocol = "v4.channel.k8s.io"
+
+	portForwardDataChannel  = 0
+	portForwardErrorChannel = 1
+
+	// portForwardHeaderLength is the length of the forwarded port, a little-endian uint16, starting each channel.
+	portForwardHeaderLength = 2
+
+	// etcdPodComponentLabel is the label of the etcd static pods of RKE2.
+	etcdPodComponentLabel = "component"
+)
+
+// DialFunc connects to an address, e.g. the DialContext function of a net.Dialer.
+type DialFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+// NewEtcdPortForwardDialer returns a DialFunc connecting to the etcd members of a workload cluster through its API
+// server, as the etcd client port of the nodes is normally not reachable from the management cluster. The address of a
+// node is mapped to the etcd pod running on it, and the port of the pod is forwarded over a websocket.
+func NewEtcdPortForwardDialer(restConfig *rest.Config, c ctrlclient.Reader, dialTimeout time.Duration) DialFunc {
+	return func(ctx context.Context, _, address string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid etcd address %s", address)
+		}
+
+		pod, err := etcdPodOnAddress(ctx, c, host)
+		if err != nil {
+			return nil, err
+		}
+
+		return dialPortForward(ctx, restConfig, pod, port, dialTimeout)
+	}
+}
+
+// etcdPodOnAddress returns the etcd pod running on the node with the given address.
+func etcdPodOnAddress(ctx context.Context, c ctrlclient.Reader, address string) (*corev1.Pod, error) {
+	pods := &corev1.PodList{}
+	if err := c.List(ctx, pods, ctrlclient.InNamespace(metav1.NamespaceSystem),
+		ctrlclient.MatchingLabels{etcdPodComponentLabel: "etcd"}); err != nil {
+		return nil, errors.Wrap(err, "failed to list the etcd pods")
+	}
+
+	address = NormalizeAddress(address)
+
+	// The etcd pods run on the host network, their addresses are the addresses of their node.
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if NormalizeAddress(pod.Status.HostIP) == address {
+			return pod, nil
+		}
+
+		for _, podIP := range pod.Status.PodIPs {
+			if NormalizeAddress(podIP.IP) == address {
+				return pod, nil
+			}
+		}
+	}
+
+	return nil, errors.Errorf("no etcd pod runs on node address %s", address)
+}
+
+// dialPortForward forwards a port of a pod through the API server, over a websocket.
+func dialPortForward(
+	ctx context.Context,
+	restConfig *rest.Config,
+	pod *corev1.Pod,
+	port string,
+	dialTimeout time.Duration,
+) (net.Conn, error) {
+	server, err := url.Parse(restConfig.Host)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid API server address")
+	}
+
+	tlsConfig, err := rest.TLSConfigFor(restConfig)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get the TLS configuration of the API server")
+	}
+
+	location := &url.URL{
+		Scheme:   "wss",
+		Host:     server.Host,
+		Path:     strings.TrimSuffix(server.Path, "/") + "/api/v1/namespaces/" + pod.Namespace + "/pods/" + pod.Name + "/portforward",
+		RawQuery: url.Values{"ports": []string{port}}.Encode(),
+	}
+
+	if server.Scheme == "http" {
+		location.Scheme = "ws"
+		tlsConfig = nil
+	}
+
+	config, err := websocket.NewConfig(location.String(), "http://localhost")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to configure the port forwarding")
+	}
+
+	config.Protocol = []string{portForwardProtocol}
+	config.Header = http.Header{}
+
+	if restConfig.BearerToken != "" {
+		config.Header.Set("Authorization", "Bearer "+restConfig.BearerToken)
+	}
+
+	hostPort := location.Host
+	if location.Port() == "" {
+		hostPort = net.JoinHostPort(location.Hostname(), "443")
+	}
+
+	dialer := &net.Dialer{Timeout: dialTimeout}
+
+	conn, err := dialer.DialContext(ctx, "tcp", hostPort)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to connect to the API server")
+	}
+
+	if tlsConfig != nil {
+		tlsConfig = tlsConfig.Clone()
+		if tlsConfig.ServerName == "" {
+			tlsConfig.ServerName = location.Hostname()
+		}
+
+		tlsConn := tls.Client(conn, tlsConfig)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+
+			return nil, errors.Wrap(err, "failed to connect to the API server")
+		}
+
+		conn = tlsConn
+	}
+
+	ws, err := websocket.NewClient(config, conn)
+	if err != nil {
+		conn.Close()
+
+		return nil, errors.Wrapf(err, "failed to forward port %s of pod %s", port, pod.Name)
+	}
+
+	ws.PayloadType = websocket.BinaryFrame
+
+	return &portForwardConn{Conn: ws, pod: pod.Name}, nil
+}
+
+// portForwardConn is the connection to a port forwarded over a websocket, the data is read and written on the data
+// channel. Each channel starts with the forwarded port, which is skipped.
+type portForwardConn struct {
+	*websocket.Conn
+
+	pod string
+
+	readLock sync.Mutex
+	pending  []byte
+	started  [2]bool
+}
+
+// Read implements net.Conn.
+func (c *portForwardConn) Read(b []byte) (int, error) {
+	c.readLock.Lock()
+	defer c.readLock.Unlock()
+
+	for len(c.pending) == 0 {
+		var message []byte
+		if err := websocket.Message.Receive(c.Conn, &message); err != nil {
+			return 0, err
+		}
+
+		if len(message) == 0 {
+			continue
+		}
+
+		channel, data := message[0], message[1:]
+		if channel > portForwardErrorChannel {
+			continue
+		}
+
+		if !c.started[channel] {
+			if len(data) < portForwardHeaderLength {
+				return 0, errors.Errorf("invalid port forwarding header from pod %s", c.pod)
+			}
+
+			c.started[channel] = true
+			data = data[portForwardHeaderLength:]
+		}
+
+		if channel == portForwardErrorChannel {
+			if len(data) > 0 {
+				return 0, errors.Errorf("failed to forward the port of pod %s: %s", c.pod, data)
+			}
+
+			continue
+		}
+
+		c.pending = data
+	}
+
+	n := copy(b, c.pending)
+	c.pending = c.pending[n:]
+
+	return n, nil
+}
+
+// Write implements net.Conn.
+func (c *portForwardConn) Write(b []byte) (int, error) {
+	if err := websocket.Message.Send(c.Conn, append([]byte{portForwardDataChannel}, b...)); err != nil {
+		return 0, err
+	}
+
+	return len(b), nil
+}
+
+var _ net.Conn = &portForwardConn{}
@@ -0,0 +1,139 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rke2
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"golang.org/x/net/websocket"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("NewEtcdPortForwardDialer", func() {
+	var (
+		server    *httptest.Server
+		dial      DialFunc
+		forwarded chan string
+		failure   string
+	)
+
+	ctx := context.Background()
+
+	BeforeEach(func() {
+		forwarded = make(chan string, 1)
+		failure = ""
+
+		// The API server forwards the port of the pod, and the pod echoes the data it receives.
+		handler := websocket.Server{
+			Handshake: func(config *websocket.Config, _ *http.Request) error {
+				config.Protocol = []string{portForwardProtocol}
+
+				return nil
+			},
+			Handler: func(ws *websocket.Conn) {
+				defer GinkgoRecover()
+
+				forwarded <- ws.Request().URL.Path + "?" + ws.Request().URL.RawQuery
+				ws.PayloadType = websocket.BinaryFrame
+
+				Expect(websocket.Message.Send(ws, []byte{portForwardDataChannel, 0x4b, 0x09})).To(Succeed())
+				Expect(websocket.Message.Send(ws, []byte{portForwardErrorChannel, 0x4b, 0x09})).To(Succeed())
+
+				if failure != "" {
+					Expect(websocket.Message.Send(ws, append([]byte{portForwardErrorChannel}, failure...))).To(Succeed())
+
+					return
+				}
+
+				for {
+					var message []byte
+					if err := websocket.Message.Receive(ws, &message); err != nil {
+						return
+					}
+
+					Expect(message[0]).To(BeEquivalentTo(portForwardDataChannel))
+					Expect(websocket.Message.Send(ws, message)).To(Succeed())
+				}
+			},
+		}
+
+		server = httptest.NewTLSServer(handler)
+
+		c := fake.NewClientBuilder().WithObjects(&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "etcd-node-1",
+				Namespace: metav1.NamespaceSystem,
+				Labels:    map[string]string{etcdPodComponentLabel: "etcd"},
+			},
+			Status: corev1.PodStatus{
+				HostIP: "10.0.0.1",
+				PodIPs: []corev1.PodIP{{IP: "10.0.0.1"}, {IP: "fd00::1"}},
+			},
+		}).Build()
+
+		restConfig := &rest.Config{Host: server.URL, TLSClientConfig: rest.TLSClientConfig{Insecure: true}}
+		dial = NewEtcdPortForwardDialer(restConfig, c, 5*time.Second)
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("should forward the etcd port of the pod running on the node", func() {
+		conn, err := dial(ctx, "tcp", "[fd00::1]:2379")
+		Expect(err).ToNot(HaveOccurred())
+
+		defer conn.Close()
+
+		Expect(forwarded).To(Receive(Equal("/api/v1/namespaces/kube-system/pods/etcd-node-1/portforward?ports=2379")))
+
+		_, err = conn.Write([]byte("ping"))
+		Expect(err).ToNot(HaveOccurred())
+
+		answer := make([]byte, 4)
+		_, err = io.ReadFull(conn, answer)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(answer)).To(Equal("ping"))
+	})
+
+	It("should report the errors of the port forwarding", func() {
+		failure = "connection refused"
+
+		conn, err := dial(ctx, "tcp", "10.0.0.1:2379")
+		Expect(err).ToNot(HaveOccurred())
+
+		defer conn.Close()
+
+		_, err = conn.Read(make([]byte, 4))
+		Expect(err).To(MatchError(ContainSubstring("connection refused")))
+	})
+
+	It("should fail without an etcd pod on the node", func() {
+		_, err := dial(ctx, "tcp", "10.0.0.2:2379")
+		Expect(err).To(MatchError(ContainSubstring("no etcd pod runs on node address 10.0.0.2")))
+	})
+})
@@ -0,0 +1,129 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rke2
+
+import (
+	"sort"
+
+	"github.com/pkg/errors"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util/collections"
+	"sigs.k8s.io/cluster-api/util/conditions"
+
+	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+)
+
+var (
+	// ErrEtcdQuorumNotLost is returned by ElectEtcdRecoveryMember when enough etcd members survive to keep the quorum.
+	ErrEtcdQuorumNotLost = errors.New("etcd quorum is not lost")
+
+	// ErrNoSurvivingEtcdMember is returned by ElectEtcdRecoveryMember when no etcd member survives.
+	ErrNoSurvivingEtcdMember = errors.New("no etcd member survives")
+
+	// ErrClusterResetMachineRequired is returned by ValidateClusterResetMember when no machine is named.
+	ErrClusterResetMachineRequired = errors.New("the machine to reset etcd on must be named")
+)
+
+// EtcdQuorum returns the number of members an etcd cluster of the given size needs to keep its quorum.
+func EtcdQuorum(members int) int {
+	return members/2 + 1
+}
+
+// SurvivingEtcdMembers returns the control plane machines whose etcd member may still be running: the machines that
+// are not deleting, not failed, whose infrastructure is ready, and whose node was not reported unhealthy.
+func SurvivingEtcdMembers(machines collections.Machines) collections.Machines {
+	return machines.Filter(func(machine *clusterv1.Machine) bool {
+		return machine.DeletionTimestamp.IsZero() &&
+			machine.Status.FailureReason == nil &&
+			machine.Status.FailureMessage == nil &&
+			machine.Status.InfrastructureReady &&
+			!conditions.IsFalse(machine, clusterv1.MachineNodeHealthyCondition)
+	})
+}
+
+// ElectEtcdRecoveryMember returns the surviving member the etcd cluster is reset on, once its quorum is lost. The
+// machine with the given name is elected if the name is not empty, otherwise the healthiest surviving member: the
+// members last reported healthy are preferred, then the members whose agent was last reported healthy, then the
+// oldest members.
+func ElectEtcdRecoveryMember(machines collections.Machines, name string) (*clusterv1.Machine, error) {
+	surviving := SurvivingEtcdMembers(machines)
+
+	if surviving.Len() >= EtcdQuorum(machines.Len()) {
+		return nil, errors.Wrapf(ErrEtcdQuorumNotLost, "%d of %d members survive", surviving.Len(), machines.Len())
+	}
+
+	if surviving.Len() == 0 {
+		return nil, ErrNoSurvivingEtcdMember
+	}
+
+	if name != "" {
+		for _, machine := range surviving {
+			if machine.Name == name {
+				return machine, nil
+			}
+		}
+
+		return nil, errors.Errorf("machine %s is not a surviving etcd member", name)
+	}
+
+	candidates := surviving.UnsortedList()
+
+	sort.Slice(candidates, func(i, j int) bool {
+		for _, condition := range []clusterv1.ConditionType{
+			controlplanev1.MachineEtcdMemberHealthyCondition,
+			controlplanev1.MachineAgentHealthyCondition,
+		} {
+			if healthyI, healthyJ := conditions.IsTrue(candidates[i], condition),
+				conditions.IsTrue(candidates[j], condition); healthyI != healthyJ {
+				return healthyI
+			}
+		}
+
+		if !candidates[i].CreationTimestamp.Equal(&candidates[j].CreationTimestamp) {
+			return candidates[i].CreationTimestamp.Before(&candidates[j].CreationTimestamp)
+		}
+
+		return candidates[i].Name < candidates[j].Name
+	})
+
+	return candidates[0], nil
+}
+
+// ValidateClusterResetMember returns the machine with the given name, which etcd is reset on at the request of the
+// ClusterResetAnnotation. The reset is refused unless the machine is a surviving etcd member, and while control plane
+// machines are being deleted.
+func ValidateClusterResetMember(machines collections.Machines, name string) (*clusterv1.Machine, error) {
+	if name == "" {
+		return nil, ErrClusterResetMachineRequired
+	}
+
+	machine, ok := machines[name]
+	if !ok {
+		return nil, errors.Errorf("machine %s is not a control plane machine", name)
+	}
+
+	if deleting := machines.Filter(collections.HasDeletionTimestamp); deleting.Len() > 0 {
+		return nil, errors.Errorf("machines %v are being deleted", deleting.Names())
+	}
+
+	if SurvivingEtcdMembers(collections.FromMachines(machine)).Len() == 0 {
+		return nil, errors.Errorf("machine %s is not a surviving etcd member", name)
+	}
+
+	return machine, nil
+}
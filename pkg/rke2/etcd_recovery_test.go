@@ -0,0 +1,183 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rke2
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	capierrors "sigs.k8s.io/cluster-api/errors"
+	"sigs.k8s.io/cluster-api/util/collections"
+	"sigs.k8s.io/cluster-api/util/conditions"
+
+	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+)
+
+var _ = Describe("ElectEtcdRecoveryMember", func() {
+	var created time.Time
+
+	newMachine := func(name string, surviving bool, healthy ...clusterv1.ConditionType) *clusterv1.Machine {
+		created = created.Add(time.Minute)
+		machine := &clusterv1.Machine{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              name,
+				CreationTimestamp: metav1.NewTime(created),
+			},
+			Status: clusterv1.MachineStatus{InfrastructureReady: surviving},
+		}
+
+		for _, condition := range healthy {
+			conditions.MarkTrue(machine, condition)
+		}
+
+		return machine
+	}
+
+	BeforeEach(func() {
+		created = time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+	})
+
+	It("should refuse to recover while the quorum is kept", func() {
+		machines := collections.FromMachines(
+			newMachine("m1", true),
+			newMachine("m2", true),
+			newMachine("m3", false),
+		)
+
+		_, err := ElectEtcdRecoveryMember(machines, "")
+		Expect(errors.Is(err, ErrEtcdQuorumNotLost)).To(BeTrue())
+	})
+
+	It("should refuse to recover without a surviving member", func() {
+		machines := collections.FromMachines(
+			newMachine("m1", false),
+			newMachine("m2", false),
+			newMachine("m3", false),
+		)
+
+		_, err := ElectEtcdRecoveryMember(machines, "")
+		Expect(errors.Is(err, ErrNoSurvivingEtcdMember)).To(BeTrue())
+	})
+
+	It("should not count failed or unhealthy machines as surviving", func() {
+		failed := newMachine("m2", true)
+		reason := capierrors.CreateMachineError
+		failed.Status.FailureReason = &reason
+		unhealthy := newMachine("m3", true)
+		conditions.MarkFalse(unhealthy, clusterv1.MachineNodeHealthyCondition, clusterv1.NodeConditionsFailedReason,
+			clusterv1.ConditionSeverityWarning, "")
+
+		machines := collections.FromMachines(newMachine("m1", true), failed, unhealthy)
+
+		Expect(SurvivingEtcdMembers(machines).Names()).To(ConsistOf("m1"))
+
+		member, err := ElectEtcdRecoveryMember(machines, "")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(member.Name).To(Equal("m1"))
+	})
+
+	It("should elect the member last reported healthy", func() {
+		machines := collections.FromMachines(
+			newMachine("m1", true, controlplanev1.MachineAgentHealthyCondition),
+			newMachine("m2", true, controlplanev1.MachineAgentHealthyCondition,
+				controlplanev1.MachineEtcdMemberHealthyCondition),
+			newMachine("m3", false),
+			newMachine("m4", false),
+			newMachine("m5", false),
+		)
+
+		member, err := ElectEtcdRecoveryMember(machines, "")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(member.Name).To(Equal("m2"))
+	})
+
+	It("should elect the oldest member among equally healthy members", func() {
+		machines := collections.FromMachines(
+			newMachine("m1", true),
+			newMachine("m2", true),
+			newMachine("m3", false),
+			newMachine("m4", false),
+			newMachine("m5", false),
+		)
+
+		member, err := ElectEtcdRecoveryMember(machines, "")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(member.Name).To(Equal("m1"))
+	})
+
+	It("should elect the surviving member chosen by the operator", func() {
+		machines := collections.FromMachines(
+			newMachine("m1", true, controlplanev1.MachineEtcdMemberHealthyCondition),
+			newMachine("m2", true),
+			newMachine("m3", false),
+			newMachine("m4", false),
+			newMachine("m5", false),
+		)
+
+		member, err := ElectEtcdRecoveryMember(machines, "m2")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(member.Name).To(Equal("m2"))
+
+		_, err = ElectEtcdRecoveryMember(machines, "m3")
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("ValidateClusterResetMember", func() {
+	newMachine := func(name string, surviving bool) *clusterv1.Machine {
+		return &clusterv1.Machine{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Status:     clusterv1.MachineStatus{InfrastructureReady: surviving},
+		}
+	}
+
+	It("should reset etcd on the named surviving member, even though the quorum is kept", func() {
+		machines := collections.FromMachines(newMachine("m1", true), newMachine("m2", true), newMachine("m3", false))
+
+		member, err := ValidateClusterResetMember(machines, "m2")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(member.Name).To(Equal("m2"))
+	})
+
+	It("should refuse to reset etcd on an unnamed, unknown or failed machine", func() {
+		machines := collections.FromMachines(newMachine("m1", true), newMachine("m2", false))
+
+		_, err := ValidateClusterResetMember(machines, "")
+		Expect(errors.Is(err, ErrClusterResetMachineRequired)).To(BeTrue())
+
+		_, err = ValidateClusterResetMember(machines, "m3")
+		Expect(err).To(HaveOccurred())
+
+		_, err = ValidateClusterResetMember(machines, "m2")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should refuse to reset etcd while machines are being deleted", func() {
+		deleting := newMachine("m2", true)
+		now := metav1.Now()
+		deleting.DeletionTimestamp = &now
+
+		_, err := ValidateClusterResetMember(collections.FromMachines(newMachine("m1", true), deleting), "m1")
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,242 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rke2
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/pkg/errors"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util/collections"
+
+	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+)
+
+const (
+	// DefaultEtcdSnapshotDir is the directory RKE2 saves the local etcd snapshots to, unless configured otherwise.
+	DefaultEtcdSnapshotDir = "/var/lib/rancher/rke2/server/db/snapshots"
+
+	// EtcdSnapshotRestoredAnnotation is a node annotation set by the restore job once rke2-server is started again with
+	// the restored etcd, it stores the UID of the RKE2EtcdSnapshotRestore. The annotation is set after the restore, so
+	// it isn't part of the restored snapshot.
+	EtcdSnapshotRestoredAnnotation = "controlplane.cluster.x-k8s.io/etcd-snapshot-restored"
+
+	// EtcdRestoreTimeout bounds the restore of an etcd snapshot: rke2-server is started again on the stopped control
+	// plane nodes once it is elapsed, and the restore is failed.
+	EtcdRestoreTimeout = 30 * time.Minute
+
+	// etcdRestoreVerifyAnnotation is the annotation set on the job verifying an etcd snapshot with the restore it
+	// verifies the snapshot for.
+	etcdRestoreVerifyAnnotation = "controlplane.cluster.x-k8s.io/etcd-restore-verify"
+
+	etcdRestoreJobPrefix       = "rke2-etcd-restore-"
+	etcdRestoreStopJobPrefix   = "rke2-etcd-restore-stop-"
+	etcdRestoreVerifyJobPrefix = "rke2-etcd-restore-verify-"
+
+	// etcdRestorePathEnv is the environment variable the restore path is passed to the jobs with, the path is never
+	// interpolated into their commands.
+	etcdRestorePathEnv = "ETCD_RESTORE_PATH"
+
+	// verifyLocalSnapshotCommand succeeds when the local snapshot file exists.
+	verifyLocalSnapshotCommand = "test -f \"$" + etcdRestorePathEnv + "\""
+
+	// verifyS3SnapshotCommand succeeds when the snapshot is listed by "rke2 etcd-snapshot list", which lists the
+	// snapshots stored in S3 with the S3 configuration of the server.
+	verifyS3SnapshotCommand = "rke2 etcd-snapshot list 2>/dev/null | " +
+		"{ while read -r name _; do [ \"$name\" = \"$" + etcdRestorePathEnv + "\" ] && exit 0; done; exit 1; }"
+
+	// restoreStopCommand schedules rke2-server to be started again once the restore timed out, then stops it. The
+	// node is recreated once the restore completes, before the timer elapses.
+	restoreStopCommand = "systemd-run --unit=rke2-etcd-restore-rollback --on-active=%d --collect " +
+		"systemctl start rke2-server.service; " + stopCommand
+
+	// restoreCommand restores etcd from the snapshot from a transient unit, as the job pod is killed when rke2-server
+	// is stopped, then starts rke2-server again and marks the node once the workload cluster API is served. The
+	// restore path is read from the environment by the unit.
+	restoreCommand = "systemd-run --unit=rke2-etcd-restore --setenv=" + etcdRestorePathEnv + " --collect sh -c " +
+		"\"systemctl stop rke2-server.service; export PATH=$PATH:/usr/local/bin:/opt/rke2/bin; rke2-killall.sh; " +
+		"rke2 server --cluster-reset --cluster-reset-restore-path=\\\"\\$" + etcdRestorePathEnv + "\\\" && " +
+		"systemctl start rke2-server.service && " +
+		"until /var/lib/rancher/rke2/bin/kubectl --kubeconfig /etc/rancher/rke2/rke2.yaml " +
+		"annotate --overwrite node %s %s=%s; do sleep 10; done\""
+)
+
+// ErrEtcdSnapshotNotFound is returned by VerifyEtcdSnapshot when the snapshot to restore can't be found.
+var ErrEtcdSnapshotNotFound = errors.New("etcd snapshot not found")
+
+// EtcdSnapshotRestorePath returns the path passed to "rke2 server --cluster-reset-restore-path" to restore the named
+// snapshot: the path of the local snapshot file, or the name of the S3 snapshot, which is downloaded with the S3
+// configuration of the control plane. There is no snapshot to restore when the servers use an external datastore.
+func EtcdSnapshotRestorePath(
+	rcp *controlplanev1.RKE2ControlPlane,
+	source controlplanev1.EtcdSnapshotSource,
+	snapshotName string,
+) (string, error) {
+	backupConfig := rcp.Spec.ServerConfig.Etcd.BackupConfig
+
+	if rcp.Spec.ServerConfig.ExternalDatastore != nil {
+		return "", errors.Errorf("control plane %s uses an external datastore, it has no etcd snapshot", rcp.Name)
+	}
+
+	if source == controlplanev1.EtcdSnapshotSourceS3 {
+		if backupConfig.S3 == nil {
+			return "", errors.Errorf("control plane %s has no S3 etcd backup configuration", rcp.Name)
+		}
+
+		return snapshotName, nil
+	}
+
+	// The snapshot directory is only configured along with S3, see GenerateInitControlPlaneConfig.
+	dir := DefaultEtcdSnapshotDir
+	if backupConfig.S3 != nil && backupConfig.Directory != "" {
+		dir = backupConfig.Directory
+	}
+
+	return path.Join(dir, snapshotName), nil
+}
+
+// EtcdRestoreMachine returns the control plane machine etcd is restored on: the machine with the given name, or the
+// oldest machine with a node when no name is given. The machine must pass the guards of ValidateClusterResetMember
+// and have a node, which the restore job is run on.
+func EtcdRestoreMachine(machines collections.Machines, name string) (*clusterv1.Machine, error) {
+	if name == "" {
+		withNode := machines.Filter(func(machine *clusterv1.Machine) bool {
+			return machine.Status.NodeRef != nil
+		})
+		if withNode.Len() == 0 {
+			return nil, errors.New("no control plane machine has a node")
+		}
+
+		name = withNode.Oldest().Name
+	}
+
+	machine, err := ValidateClusterResetMember(machines, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if machine.Status.NodeRef == nil {
+		return nil, errors.Errorf("machine %s has no node", name)
+	}
+
+	return machine, nil
+}
+
+// VerifyEtcdSnapshot checks that the snapshot to restore is found by the node, before any server is stopped: the
+// local snapshot file must exist, the S3 snapshot must be listed. It returns true once the snapshot is found, and an
+// error wrapping ErrEtcdSnapshotNotFound when it isn't.
+func (w *Workload) VerifyEtcdSnapshot(
+	ctx context.Context,
+	nodeName string,
+	restorePath string,
+	s3 bool,
+	restoreID string,
+) (bool, error) {
+	name := etcdRestoreVerifyJobPrefix + nodeName
+	job := &batchv1.Job{}
+
+	err := w.Client.Get(ctx, ctrlclient.ObjectKey{Namespace: HibernationNamespace, Name: name}, job)
+
+	switch {
+	case err == nil && job.Annotations[etcdRestoreVerifyAnnotation] != restoreID:
+		if err := w.Client.Delete(ctx, job, ctrlclient.PropagationPolicy(metav1.DeletePropagationBackground)); err != nil &&
+			!apierrors.IsNotFound(err) {
+			return false, errors.Wrapf(err, "failed to delete the previous job %s", name)
+		}
+
+		return false, nil
+	case apierrors.IsNotFound(err):
+		command := verifyLocalSnapshotCommand
+		if s3 {
+			command = verifyS3SnapshotCommand
+		}
+
+		job = w.newRestoreJob(name, nodeName, fmt.Sprintf(hostCommand, command), restorePath)
+		job.Annotations = map[string]string{etcdRestoreVerifyAnnotation: restoreID}
+
+		if err := w.Client.Create(ctx, job); err != nil {
+			return false, errors.Wrapf(err, "failed to create job %s", name)
+		}
+
+		return false, nil
+	case err != nil:
+		return false, errors.Wrapf(err, "failed to get job %s", name)
+	case job.Status.Failed > 0:
+		return false, errors.Wrapf(ErrEtcdSnapshotNotFound, "snapshot %s not found by node %s", restorePath, nodeName)
+	default:
+		return job.Status.Succeeded > 0, nil
+	}
+}
+
+// RestoreEtcdSnapshot stops rke2-server on the other control plane nodes, and restores etcd from the snapshot on the
+// node, with "rke2 server --cluster-reset". The other nodes are removed from etcd by the restore, rke2-server is
+// started again on them once EtcdRestoreTimeout is elapsed, in case the restore failed.
+func (w *Workload) RestoreEtcdSnapshot(
+	ctx context.Context,
+	nodeName string,
+	otherNodeNames []string,
+	restorePath string,
+	restoreID string,
+) error {
+	// The jobs are the hibernation ones, they are removed along with the hibernation jobs.
+	for _, otherNodeName := range otherNodeNames {
+		stopJob := w.newHibernationJob(etcdRestoreStopJobPrefix+otherNodeName, otherNodeName,
+			fmt.Sprintf(hostCommand, fmt.Sprintf(restoreStopCommand, int(EtcdRestoreTimeout.Seconds()))))
+
+		if err := w.Client.Create(ctx, stopJob); err != nil && !apierrors.IsAlreadyExists(err) {
+			return errors.Wrapf(err, "failed to create the job stopping node %s", otherNodeName)
+		}
+	}
+
+	restoreJob := w.newRestoreJob(etcdRestoreJobPrefix+nodeName, nodeName, fmt.Sprintf(hostCommand,
+		fmt.Sprintf(restoreCommand, nodeName, EtcdSnapshotRestoredAnnotation, restoreID)), restorePath)
+
+	if err := w.Client.Create(ctx, restoreJob); err != nil && !apierrors.IsAlreadyExists(err) {
+		return errors.Wrapf(err, "failed to create the job restoring etcd on node %s", nodeName)
+	}
+
+	return nil
+}
+
+// EtcdSnapshotRestored returns true once the node was marked by the restore job, i.e. once the workload cluster API
+// is served from the restored etcd.
+func (w *Workload) EtcdSnapshotRestored(ctx context.Context, nodeName string, restoreID string) (bool, error) {
+	node := &corev1.Node{}
+	if err := w.Client.Get(ctx, ctrlclient.ObjectKey{Name: nodeName}, node); err != nil {
+		return false, errors.Wrapf(err, "failed to get node %s", nodeName)
+	}
+
+	return node.Annotations[EtcdSnapshotRestoredAnnotation] == restoreID, nil
+}
+
+// newRestoreJob returns a hibernation job running the command on the host of the node, with the restore path in its
+// environment.
+func (w *Workload) newRestoreJob(name, nodeName, command, restorePath string) *batchv1.Job {
+	job := w.newHibernationJob(name, nodeName, command)
+	job.Spec.Template.Spec.Containers[0].Env = []corev1.EnvVar{{Name: etcdRestorePathEnv, Value: restorePath}}
+
+	return job
+}
@@ -0,0 +1,229 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rke2
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pkg/errors"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util/collections"
+
+	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+)
+
+var _ = Describe("EtcdSnapshotRestorePath", func() {
+	It("should restore local snapshots from the snapshot directory", func() {
+		rcp := &controlplanev1.RKE2ControlPlane{}
+
+		restorePath, err := EtcdSnapshotRestorePath(rcp, controlplanev1.EtcdSnapshotSourceLocal, "snapshot-1")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(restorePath).To(Equal("/var/lib/rancher/rke2/server/db/snapshots/snapshot-1"))
+
+		rcp.Spec.ServerConfig.Etcd.BackupConfig = controlplanev1.EtcdBackupConfig{
+			Directory: "/data/snapshots",
+			S3:        &controlplanev1.EtcdS3{Bucket: "bucket"},
+		}
+
+		restorePath, err = EtcdSnapshotRestorePath(rcp, "", "snapshot-1")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(restorePath).To(Equal("/data/snapshots/snapshot-1"))
+	})
+
+	It("should restore S3 snapshots by name, with the S3 configuration of the control plane", func() {
+		rcp := &controlplanev1.RKE2ControlPlane{}
+
+		_, err := EtcdSnapshotRestorePath(rcp, controlplanev1.EtcdSnapshotSourceS3, "snapshot-1")
+		Expect(err).To(HaveOccurred())
+
+		rcp.Spec.ServerConfig.Etcd.BackupConfig.S3 = &controlplanev1.EtcdS3{Bucket: "bucket"}
+
+		restorePath, err := EtcdSnapshotRestorePath(rcp, controlplanev1.EtcdSnapshotSourceS3, "snapshot-1")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(restorePath).To(Equal("snapshot-1"))
+	})
+
+	It("should refuse to restore snapshots of a control plane using an external datastore", func() {
+		rcp := &controlplanev1.RKE2ControlPlane{}
+		rcp.Spec.ServerConfig.ExternalDatastore = &controlplanev1.ExternalDatastore{Endpoint: "postgres://db:5432/rke2"}
+
+		_, err := EtcdSnapshotRestorePath(rcp, controlplanev1.EtcdSnapshotSourceLocal, "snapshot-1")
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("EtcdRestoreMachine", func() {
+	newMachine := func(name string, created int64, nodeName string) *clusterv1.Machine {
+		machine := &clusterv1.Machine{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              name,
+				CreationTimestamp: metav1.Unix(created, 0),
+			},
+			Status: clusterv1.MachineStatus{InfrastructureReady: true},
+		}
+
+		if nodeName != "" {
+			machine.Status.NodeRef = &corev1.ObjectReference{Name: nodeName}
+		}
+
+		return machine
+	}
+
+	It("should default to the oldest machine with a node", func() {
+		machines := collections.FromMachines(newMachine("m1", 1, ""), newMachine("m2", 2, "node-2"), newMachine("m3", 3, "node-3"))
+
+		machine, err := EtcdRestoreMachine(machines, "")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(machine.Name).To(Equal("m2"))
+
+		machine, err = EtcdRestoreMachine(machines, "m3")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(machine.Name).To(Equal("m3"))
+	})
+
+	It("should refuse machines without a node", func() {
+		machines := collections.FromMachines(newMachine("m1", 1, ""))
+
+		_, err := EtcdRestoreMachine(machines, "")
+		Expect(err).To(HaveOccurred())
+
+		_, err = EtcdRestoreMachine(machines, "m1")
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("RestoreEtcdSnapshot", func() {
+	It("should stop the other nodes and restore etcd on the node, until the node is marked", func() {
+		node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1"}}
+		workload := &Workload{
+			Client: fake.NewClientBuilder().WithObjects(node).Build(),
+		}
+
+		Expect(workload.RestoreEtcdSnapshot(context.Background(), "node-1", []string{"node-2", "node-3"},
+			"/var/lib/rancher/rke2/server/db/snapshots/snapshot-1", "uid")).To(Succeed())
+
+		restoreJob := &batchv1.Job{}
+		restoreKey := types.NamespacedName{Namespace: HibernationNamespace, Name: etcdRestoreJobPrefix + "node-1"}
+		Expect(workload.Client.Get(context.Background(), restoreKey, restoreJob)).To(Succeed())
+		Expect(restoreJob.Spec.Template.Spec.NodeName).To(Equal("node-1"))
+		Expect(restoreJob.Spec.Template.Spec.Containers[0].Command).To(ContainElement(And(
+			ContainSubstring(`rke2 server --cluster-reset --cluster-reset-restore-path=\"\$ETCD_RESTORE_PATH\"`),
+			ContainSubstring("annotate --overwrite node node-1 "+EtcdSnapshotRestoredAnnotation+"=uid"),
+		)))
+		Expect(restoreJob.Spec.Template.Spec.Containers[0].Env).To(ConsistOf(corev1.EnvVar{
+			Name: "ETCD_RESTORE_PATH", Value: "/var/lib/rancher/rke2/server/db/snapshots/snapshot-1",
+		}))
+
+		for _, nodeName := range []string{"node-2", "node-3"} {
+			stopJob := &batchv1.Job{}
+			stopKey := types.NamespacedName{Namespace: HibernationNamespace, Name: etcdRestoreStopJobPrefix + nodeName}
+			Expect(workload.Client.Get(context.Background(), stopKey, stopJob)).To(Succeed())
+			Expect(stopJob.Spec.Template.Spec.NodeName).To(Equal(nodeName))
+			Expect(stopJob.Spec.Template.Spec.Containers[0].Command).To(ContainElement(
+				ContainSubstring("--on-active=1800 --collect systemctl start rke2-server.service")))
+		}
+
+		// Creating the jobs again is a no-op.
+		Expect(workload.RestoreEtcdSnapshot(context.Background(), "node-1", []string{"node-2", "node-3"},
+			"snapshot-1", "uid")).To(Succeed())
+
+		restored, err := workload.EtcdSnapshotRestored(context.Background(), "node-1", "uid")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(restored).To(BeFalse())
+
+		node.Annotations = map[string]string{EtcdSnapshotRestoredAnnotation: "uid"}
+		Expect(workload.Client.Update(context.Background(), node)).To(Succeed())
+
+		restored, err = workload.EtcdSnapshotRestored(context.Background(), "node-1", "uid")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(restored).To(BeTrue())
+
+		restored, err = workload.EtcdSnapshotRestored(context.Background(), "node-1", "other-uid")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(restored).To(BeFalse())
+	})
+})
+
+var _ = Describe("VerifyEtcdSnapshot", func() {
+	var workload *Workload
+
+	BeforeEach(func() {
+		workload = &Workload{Client: fake.NewClientBuilder().Build()}
+	})
+
+	getJob := func() *batchv1.Job {
+		job := &batchv1.Job{}
+		Expect(workload.Client.Get(context.Background(), types.NamespacedName{
+			Namespace: HibernationNamespace, Name: etcdRestoreVerifyJobPrefix + "node-1",
+		}, job)).To(Succeed())
+
+		return job
+	}
+
+	It("should find the local snapshot file with a job", func() {
+		verified, err := workload.VerifyEtcdSnapshot(context.Background(), "node-1", "/snapshots/snapshot-1", false, "uid")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(verified).To(BeFalse())
+
+		job := getJob()
+		Expect(job.Spec.Template.Spec.NodeName).To(Equal("node-1"))
+		Expect(job.Spec.Template.Spec.Containers[0].Command).To(ContainElement(ContainSubstring(`test -f "$ETCD_RESTORE_PATH"`)))
+		Expect(job.Spec.Template.Spec.Containers[0].Env).To(ConsistOf(corev1.EnvVar{
+			Name: "ETCD_RESTORE_PATH", Value: "/snapshots/snapshot-1",
+		}))
+
+		job.Status.Succeeded = 1
+		Expect(workload.Client.Status().Update(context.Background(), job)).To(Succeed())
+
+		verified, err = workload.VerifyEtcdSnapshot(context.Background(), "node-1", "/snapshots/snapshot-1", false, "uid")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(verified).To(BeTrue())
+	})
+
+	It("should list the S3 snapshots, and report a snapshot which isn't found", func() {
+		_, err := workload.VerifyEtcdSnapshot(context.Background(), "node-1", "snapshot-1", true, "uid")
+		Expect(err).ToNot(HaveOccurred())
+
+		job := getJob()
+		Expect(job.Spec.Template.Spec.Containers[0].Command).To(ContainElement(ContainSubstring("rke2 etcd-snapshot list")))
+
+		job.Status.Failed = 1
+		Expect(workload.Client.Status().Update(context.Background(), job)).To(Succeed())
+
+		_, err = workload.VerifyEtcdSnapshot(context.Background(), "node-1", "snapshot-1", true, "uid")
+		Expect(errors.Is(err, ErrEtcdSnapshotNotFound)).To(BeTrue())
+
+		// The job of another restore is replaced.
+		verified, err := workload.VerifyEtcdSnapshot(context.Background(), "node-1", "snapshot-1", true, "other-uid")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(verified).To(BeFalse())
+
+		verified, err = workload.VerifyEtcdSnapshot(context.Background(), "node-1", "snapshot-1", true, "other-uid")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(verified).To(BeFalse())
+		Expect(getJob().Annotations).To(HaveKeyWithValue(etcdRestoreVerifyAnnotation, "other-uid"))
+	})
+})
@@ -0,0 +1,155 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rke2
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+
+	bootstrapv1 "github.com/rancher-sandbox/cluster-api-provider-rke2/bootstrap/api/v1alpha1"
+	"github.com/rancher-sandbox/cluster-api-provider-rke2/pkg/consts"
+)
+
+const (
+	// ServerUnit is the systemd unit of the rke2 servers.
+	ServerUnit = "rke2-server"
+
+	// AgentUnit is the systemd unit of the rke2 agents.
+	AgentUnit = "rke2-agent"
+
+	// flagsDropInLocationFormat is the location of the systemd drop-in passing the configuration as flags to a unit.
+	flagsDropInLocationFormat = "/etc/systemd/system/%s.service.d/50-capi-flags.conf"
+)
+
+// GenerateConfigFile renders the configuration of rke2 according to the configuration mode: the config.yaml file,
+// or the systemd drop-in of the unit passing the same configuration as command line flags.
+func GenerateConfigFile(mode bootstrapv1.ConfigurationMode, unit string, config interface{}, permissions string) (bootstrapv1.File, error) {
+	if mode == bootstrapv1.FlagsConfigurationMode {
+		flags, err := ConfigFlags(config)
+		if err != nil {
+			return bootstrapv1.File{}, err
+		}
+
+		return bootstrapv1.File{
+			Path:        fmt.Sprintf(flagsDropInLocationFormat, unit),
+			Content:     flagsDropIn(unit, flags),
+			Owner:       consts.DefaultFileOwner,
+			Permissions: permissions,
+		}, nil
+	}
+
+	b, err := yaml.Marshal(config)
+	if err != nil {
+		return bootstrapv1.File{}, err
+	}
+
+	return bootstrapv1.File{
+		Path:        DefaultRKE2ConfigLocation,
+		Content:     string(b),
+		Owner:       consts.DefaultFileOwner,
+		Permissions: permissions,
+	}, nil
+}
+
+// ConfigFlags returns the rke2 command line flags equivalent to a configuration file, sorted by name.
+// List values are repeated flags, and map values are repeated flags of key=value pairs, or host-path:container-path
+// pairs for the extra mounts.
+func ConfigFlags(config interface{}) ([]string, error) {
+	b, err := json.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+
+	values := map[string]interface{}{}
+	if err := json.Unmarshal(b, &values); err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	flags := []string{}
+
+	for _, name := range names {
+		switch value := values[name].(type) {
+		case []interface{}:
+			for _, item := range value {
+				flags = append(flags, fmt.Sprintf("--%s=%v", name, item))
+			}
+		case map[string]interface{}:
+			separator := "="
+			if strings.HasSuffix(name, "-extra-mount") {
+				separator = ":"
+			}
+
+			keys := make([]string, 0, len(value))
+			for key := range value {
+				keys = append(keys, key)
+			}
+
+			sort.Strings(keys)
+
+			for _, key := range keys {
+				flags = append(flags, fmt.Sprintf("--%s=%s%s%v", name, key, separator, value[key]))
+			}
+		case float64:
+			flags = append(flags, fmt.Sprintf("--%s=%s", name, json.Number(fmt.Sprint(value))))
+		default:
+			flags = append(flags, fmt.Sprintf("--%s=%v", name, value))
+		}
+	}
+
+	return flags, nil
+}
+
+// flagsDropIn renders the systemd drop-in replacing the command of a rke2 unit with one passing the flags.
+// The rke2 binary is looked up in the systemd executable search path, which requires systemd 239 or later, as it
+// is installed in /usr/local/bin or /usr/bin depending on the install method.
+func flagsDropIn(unit string, flags []string) string {
+	command := "rke2 server"
+	if unit == AgentUnit {
+		command = "rke2 agent"
+	}
+
+	lines := []string{"[Service]", "ExecStart=", "ExecStart=" + command}
+
+	for _, flag := range flags {
+		lines[len(lines)-1] += " \\"
+		lines = append(lines, "  "+quoteSystemdArg(flag))
+	}
+
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// quoteSystemdArg quotes a command line argument of a systemd unit, escaping the specifiers and the variables.
+func quoteSystemdArg(arg string) string {
+	arg = strings.NewReplacer("%", "%%", "$", "$$").Replace(arg)
+
+	if !strings.ContainsAny(arg, " \t\n\"'\\;") {
+		return arg
+	}
+
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\t", `\t`).Replace(arg) + `"`
+}
@@ -0,0 +1,194 @@
+/*
+Copyright 2023 SUSE.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rke2
+
+import (
+	"fmt"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"sigs.k8s.io/yaml"
+
+	bootstrapv1 "github.com/rancher-sandbox/cluster-api-provider-rke2/bootstrap/api/v1alpha1"
+)
+
+// parseFlagsDropIn parses the flags of the ExecStart command of a drop-in rendered by flagsDropIn, undoing the
+// systemd quoting and escaping.
+func parseFlagsDropIn(content string) (string, map[string][]string) {
+	Expect(content).To(HavePrefix("[Service]\nExecStart=\nExecStart="))
+
+	command := strings.TrimPrefix(content, "[Service]\nExecStart=\nExecStart=")
+	command = strings.TrimSuffix(strings.ReplaceAll(command, " \\\n  ", " "), "\n")
+
+	args := []string{}
+	arg := strings.Builder{}
+	quoted := false
+
+	for i := 0; i < len(command); i++ {
+		switch c := command[i]; {
+		case c == '\\' && quoted:
+			i++
+
+			switch command[i] {
+			case 'n':
+				arg.WriteByte('\n')
+			case 't':
+				arg.WriteByte('\t')
+			default:
+				arg.WriteByte(command[i])
+			}
+		case c == '"':
+			quoted = !quoted
+		case (c == '%' || c == '$') && i+1 < len(command) && command[i+1] == c:
+			i++
+
+			arg.WriteByte(c)
+		case c == ' ' && !quoted:
+			args = append(args, arg.String())
+			arg.Reset()
+		default:
+			arg.WriteByte(c)
+		}
+	}
+
+	args = append(args, arg.String())
+
+	flags := map[string][]string{}
+
+	for _, arg := range args[2:] {
+		Expect(arg).To(HavePrefix("--"))
+
+		name, value, _ := strings.Cut(strings.TrimPrefix(arg, "--"), "=")
+		flags[name] = append(flags[name], value)
+	}
+
+	return strings.Join(args[:2], " "), flags
+}
+
+// configFileFlags returns the flags equivalent to a config.yaml file, the way rke2 reads them.
+func configFileFlags(content string) map[string][]string {
+	values := map[string]interface{}{}
+	Expect(yaml.Unmarshal([]byte(content), &values)).To(Succeed())
+
+	flags := map[string][]string{}
+
+	for name, value := range values {
+		switch value := value.(type) {
+		case []interface{}:
+			for _, item := range value {
+				flags[name] = append(flags[name], fmt.Sprint(item))
+			}
+		case map[string]interface{}:
+			separator := "="
+			if strings.HasSuffix(name, "-extra-mount") {
+				separator = ":"
+			}
+
+			for key, item := range value {
+				flags[name] = append(flags[name], fmt.Sprintf("%s%s%v", key, separator, item))
+			}
+		default:
+			flags[name] = []string{fmt.Sprint(value)}
+		}
+	}
+
+	return flags
+}
+
+var _ = Describe("GenerateConfigFile", func() {
+	var config *rke2ServerConfig
+
+	BeforeEach(func() {
+		disableSnapshots := false
+
+		config = &rke2ServerConfig{
+			AdvertiseAddress:                 "10.0.0.1",
+			CNI:                              []string{"calico"},
+			DisableComponents:                []string{"rke2-ingress-nginx", "rke2-metrics-server"},
+			DisableKubeProxy:                 true,
+			EtcdDisableSnapshots:             &disableSnapshots,
+			EtcdSnapshotScheduleCron:         "0 */5 * * *",
+			KubeAPIServerArgs:                []string{"audit-log-path=/var/log/audit.log", "oidc-username-prefix=\"oidc:\""},
+			KubeAPIserverExtraEnv:            map[string]string{"HTTP_PROXY": "http://proxy:3128", "NO_PROXY": "$HOST,10.0.0.0/8"},
+			KubeControllerManagerExtraMounts: map[string]string{"/etc/ssl": "/etc/ssl/host"},
+			TLSSan:                           []string{"api.example.com", "10.0.0.1"},
+			rke2AgentConfig: rke2AgentConfig{
+				KubeletArgs:  []string{"eviction-hard=memory.available<5%", "provider-id=aws:///zone/i-0123\\4"},
+				LbServerPort: 9345,
+				NodeLabels:   []string{"role=server"},
+				Token:        "token",
+			},
+		}
+	})
+
+	It("should render the config.yaml file by default", func() {
+		file, err := GenerateConfigFile("", ServerUnit, config, "0640")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(file.Path).To(Equal(DefaultRKE2ConfigLocation))
+		Expect(file.Permissions).To(Equal("0640"))
+		Expect(file.Content).To(ContainSubstring("advertise-address: 10.0.0.1\n"))
+	})
+
+	It("should render flags equivalent to the config.yaml file", func() {
+		configFile, err := GenerateConfigFile(bootstrapv1.FileConfigurationMode, ServerUnit, config, "0640")
+		Expect(err).ToNot(HaveOccurred())
+
+		dropIn, err := GenerateConfigFile(bootstrapv1.FlagsConfigurationMode, ServerUnit, config, "0640")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(dropIn.Path).To(Equal("/etc/systemd/system/rke2-server.service.d/50-capi-flags.conf"))
+		Expect(dropIn.Permissions).To(Equal("0640"))
+
+		command, flags := parseFlagsDropIn(dropIn.Content)
+		Expect(command).To(Equal("rke2 server"))
+
+		expected := configFileFlags(configFile.Content)
+		Expect(flags).To(HaveLen(len(expected)))
+
+		for name, values := range expected {
+			Expect(flags).To(HaveKeyWithValue(name, ConsistOf(values)), "flag %s", name)
+		}
+	})
+
+	It("should render the flags of the agents", func() {
+		dropIn, err := GenerateConfigFile(bootstrapv1.FlagsConfigurationMode, AgentUnit, &config.rke2AgentConfig, "0640")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(dropIn.Path).To(Equal("/etc/systemd/system/rke2-agent.service.d/50-capi-flags.conf"))
+
+		command, flags := parseFlagsDropIn(dropIn.Content)
+		Expect(command).To(Equal("rke2 agent"))
+		Expect(flags).To(Equal(map[string][]string{
+			"kubelet-arg":    {"eviction-hard=memory.available<5%", "provider-id=aws:///zone/i-0123\\4"},
+			"lb-server-port": {"9345"},
+			"node-label":     {"role=server"},
+			"token":          {"token"},
+		}))
+	})
+})
+
+var _ = Describe("quoteSystemdArg", func() {
+	It("should only quote the arguments when needed", func() {
+		Expect(quoteSystemdArg("--token=token")).To(Equal("--token=token"))
+		Expect(quoteSystemdArg("--etcd-snapshot-schedule-cron=0 */5 * * *")).To(Equal(`"--etcd-snapshot-schedule-cron=0 */5 * * *"`))
+		Expect(quoteSystemdArg(`--kube-apiserver-arg=oidc-username-prefix="oidc:"`)).To(Equal(`"--kube-apiserver-arg=oidc-username-prefix=\"oidc:\""`))
+	})
+
+	It("should escape the specifiers and the variables", func() {
+		Expect(quoteSystemdArg("--kubelet-arg=eviction-hard=memory.available<5%")).To(Equal("--kubelet-arg=eviction-hard=memory.available<5%%"))
+		Expect(quoteSystemdArg("--kube-apiserver-extra-env=NO_PROXY=$HOST")).To(Equal("--kube-apiserver-extra-env=NO_PROXY=$$HOST"))
+	})
+})
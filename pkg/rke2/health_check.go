@@ -0,0 +1,105 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rke2
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util/collections"
+	"sigs.k8s.io/cluster-api/util/conditions"
+
+	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+)
+
+// DefaultUnhealthyTimeout is how long the Ready condition of a node must be False or Unknown for its machine to be
+// unhealthy, unless other unhealthy conditions are configured.
+const DefaultUnhealthyTimeout = 5 * time.Minute
+
+// ControlPlaneHealthCheckName returns the name of the MachineHealthCheck of the control plane machines.
+func ControlPlaneHealthCheckName(rcp *controlplanev1.RKE2ControlPlane) string {
+	return rcp.Name + "-control-plane"
+}
+
+// ControlPlaneHealthCheck returns the MachineHealthCheck of the control plane machines of the cluster, controlled by
+// the control plane, or nil when the health check is disabled.
+func ControlPlaneHealthCheck(rcp *controlplanev1.RKE2ControlPlane, clusterName string) *clusterv1.MachineHealthCheck {
+	healthCheck := rcp.Spec.HealthCheck
+	if healthCheck == nil || !healthCheck.Enabled {
+		return nil
+	}
+
+	unhealthyConditions := healthCheck.UnhealthyConditions
+	if len(unhealthyConditions) == 0 {
+		unhealthyConditions = []clusterv1.UnhealthyCondition{
+			{Type: corev1.NodeReady, Status: corev1.ConditionFalse, Timeout: metav1.Duration{Duration: DefaultUnhealthyTimeout}},
+			{Type: corev1.NodeReady, Status: corev1.ConditionUnknown, Timeout: metav1.Duration{Duration: DefaultUnhealthyTimeout}},
+		}
+	}
+
+	return &clusterv1.MachineHealthCheck{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      ControlPlaneHealthCheckName(rcp),
+			Namespace: rcp.Namespace,
+			Labels:    map[string]string{clusterv1.ClusterNameLabel: clusterName},
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(rcp, controlplanev1.GroupVersion.WithKind("RKE2ControlPlane")),
+			},
+		},
+		Spec: clusterv1.MachineHealthCheckSpec{
+			ClusterName: clusterName,
+			Selector: metav1.LabelSelector{
+				MatchLabels: map[string]string{
+					clusterv1.ClusterNameLabel:         clusterName,
+					clusterv1.MachineControlPlaneLabel: "",
+				},
+			},
+			UnhealthyConditions: unhealthyConditions,
+			MaxUnhealthy:        healthCheck.MaxUnhealthy,
+			NodeStartupTimeout:  healthCheck.NodeStartupTimeout,
+		},
+	}
+}
+
+// ValidateRemediation checks that the unhealthy machine can be deleted to be replaced: no other control plane machine
+// is being deleted, and the healthy etcd members left keep the quorum.
+func ValidateRemediation(machines collections.Machines, machine *clusterv1.Machine) error {
+	if deleting := machines.Filter(collections.HasDeletionTimestamp); deleting.Len() > 0 {
+		return errors.Errorf("machines %v are being deleted", deleting.Names())
+	}
+
+	remaining := machines.Filter(func(m *clusterv1.Machine) bool {
+		return m.Name != machine.Name
+	})
+	if remaining.Len() == 0 {
+		return errors.Errorf("machine %s is the only control plane machine", machine.Name)
+	}
+
+	healthy := remaining.Filter(collections.Not(collections.HasUnhealthyCondition), func(m *clusterv1.Machine) bool {
+		return !conditions.IsFalse(m, controlplanev1.MachineEtcdMemberHealthyCondition)
+	})
+	if healthy.Len() < remaining.Len()/2+1 {
+		return errors.Errorf("the %d healthy etcd members left would lose the quorum of %d members",
+			healthy.Len(), remaining.Len())
+	}
+
+	return nil
+}
@@ -0,0 +1,120 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rke2
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util/collections"
+	"sigs.k8s.io/cluster-api/util/conditions"
+
+	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+)
+
+var _ = Describe("ControlPlaneHealthCheck", func() {
+	var rcp *controlplanev1.RKE2ControlPlane
+
+	BeforeEach(func() {
+		rcp = &controlplanev1.RKE2ControlPlane{ObjectMeta: metav1.ObjectMeta{Name: "rcp", Namespace: "ns"}}
+	})
+
+	It("should not create a health check unless enabled", func() {
+		Expect(ControlPlaneHealthCheck(rcp, "cluster")).To(BeNil())
+
+		rcp.Spec.HealthCheck = &controlplanev1.ControlPlaneHealthCheck{}
+		Expect(ControlPlaneHealthCheck(rcp, "cluster")).To(BeNil())
+	})
+
+	It("should check the control plane machines of the cluster, with the default unhealthy conditions", func() {
+		maxUnhealthy := intstr.FromInt(1)
+		rcp.Spec.HealthCheck = &controlplanev1.ControlPlaneHealthCheck{Enabled: true, MaxUnhealthy: &maxUnhealthy}
+
+		healthCheck := ControlPlaneHealthCheck(rcp, "cluster")
+		Expect(healthCheck.Name).To(Equal("rcp-control-plane"))
+		Expect(healthCheck.Namespace).To(Equal("ns"))
+		Expect(metav1.IsControlledBy(healthCheck, rcp)).To(BeTrue())
+		Expect(healthCheck.Spec.ClusterName).To(Equal("cluster"))
+		Expect(healthCheck.Spec.Selector.MatchLabels).To(HaveKeyWithValue(clusterv1.MachineControlPlaneLabel, ""))
+		Expect(healthCheck.Spec.Selector.MatchLabels).To(HaveKeyWithValue(clusterv1.ClusterNameLabel, "cluster"))
+		Expect(healthCheck.Spec.UnhealthyConditions).To(HaveLen(2))
+		Expect(healthCheck.Spec.MaxUnhealthy).To(Equal(&maxUnhealthy))
+	})
+
+	It("should use the configured unhealthy conditions", func() {
+		rcp.Spec.HealthCheck = &controlplanev1.ControlPlaneHealthCheck{
+			Enabled: true,
+			UnhealthyConditions: []clusterv1.UnhealthyCondition{
+				{Type: "DiskPressure", Status: corev1.ConditionTrue, Timeout: metav1.Duration{}},
+			},
+		}
+
+		Expect(ControlPlaneHealthCheck(rcp, "cluster").Spec.UnhealthyConditions).To(Equal(rcp.Spec.HealthCheck.UnhealthyConditions))
+	})
+})
+
+var _ = Describe("ValidateRemediation", func() {
+	newMachine := func(name string, unhealthy bool) *clusterv1.Machine {
+		machine := &clusterv1.Machine{ObjectMeta: metav1.ObjectMeta{Name: name}}
+
+		if unhealthy {
+			conditions.MarkFalse(machine, clusterv1.MachineHealthCheckSucceededCondition, clusterv1.UnhealthyNodeConditionReason,
+				clusterv1.ConditionSeverityWarning, "")
+			conditions.MarkFalse(machine, clusterv1.MachineOwnerRemediatedCondition, clusterv1.WaitingForRemediationReason,
+				clusterv1.ConditionSeverityWarning, "")
+		}
+
+		return machine
+	}
+
+	It("should remediate a machine when the quorum is kept", func() {
+		unhealthy := newMachine("m1", true)
+		machines := collections.FromMachines(unhealthy, newMachine("m2", false), newMachine("m3", false))
+
+		Expect(ValidateRemediation(machines, unhealthy)).To(Succeed())
+	})
+
+	It("should not remediate a machine when the quorum would be lost", func() {
+		unhealthy := newMachine("m1", true)
+		machines := collections.FromMachines(unhealthy, newMachine("m2", true), newMachine("m3", false))
+
+		Expect(ValidateRemediation(machines, unhealthy)).ToNot(Succeed())
+
+		etcdUnhealthy := newMachine("m2", false)
+		conditions.MarkFalse(etcdUnhealthy, controlplanev1.MachineEtcdMemberHealthyCondition, "", clusterv1.ConditionSeverityError, "")
+		machines = collections.FromMachines(unhealthy, etcdUnhealthy, newMachine("m3", false))
+
+		Expect(ValidateRemediation(machines, unhealthy)).ToNot(Succeed())
+	})
+
+	It("should not remediate the only machine, nor while a machine is being deleted", func() {
+		unhealthy := newMachine("m1", true)
+		Expect(ValidateRemediation(collections.FromMachines(unhealthy), unhealthy)).ToNot(Succeed())
+
+		deleting := newMachine("m2", false)
+		now := metav1.Now()
+		deleting.DeletionTimestamp = &now
+		machines := collections.FromMachines(unhealthy, deleting, newMachine("m3", false))
+
+		Expect(ValidateRemediation(machines, unhealthy)).ToNot(Succeed())
+	})
+})
@@ -0,0 +1,268 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rke2
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/utils/pointer"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// HibernationNamespace is the namespace of the workload cluster jobs hibernating the control plane.
+	HibernationNamespace = "kube-system"
+
+	// DefaultHostJobImage is the image of the workload cluster jobs running commands on the hosts of the nodes, unless
+	// another image is set in the workload client options. It needs nsenter.
+	DefaultHostJobImage = "registry.suse.com/bci/bci-busybox:15.5"
+
+	// hostJobTTL is how long the jobs running commands on the hosts of the nodes are kept once finished, in seconds.
+	hostJobTTL = 3600
+
+	// hibernationJobLabel is the label set on the workload cluster jobs hibernating the control plane.
+	hibernationJobLabel = "controlplane.cluster.x-k8s.io/hibernation"
+
+	etcdSnapshotJobPrefix    = "rke2-etcd-snapshot-"
+	hibernationStopJobPrefix = "rke2-hibernation-stop-"
+
+	// hostCommand runs the command on the host, with the RKE2 binaries in the path for both RPM and tarball installs.
+	hostCommand = "nsenter --target 1 --mount --uts --ipc --net --pid -- " +
+		"sh -c 'PATH=$PATH:/usr/local/bin:/opt/rke2/bin; %s'"
+
+	// snapshotCommand saves an etcd snapshot with the name, then writes the name of its file, as listed by
+	// "rke2 etcd-snapshot list", to the termination message of the job container. The file name is suffixed with the
+	// node name and the time of the snapshot.
+	snapshotCommand = "rke2 etcd-snapshot save --name %[1]s >&2 && rke2 etcd-snapshot list 2>/dev/null | " +
+		"{ while read -r name _; do case \"$name\" in %[1]s-*) echo \"$name\";; esac; done; } | sort | tail -n 1 | grep ."
+
+	// stopCommand stops rke2-server and its containers from a transient unit, so it isn't interrupted when the job pod is
+	// killed. The rke2-server service stays enabled, so it is started again when the machine boots.
+	stopCommand = "systemd-run --unit=rke2-hibernation --collect sh -c " +
+		"\"systemctl stop rke2-server.service; PATH=$PATH:/usr/local/bin:/opt/rke2/bin rke2-killall.sh\""
+)
+
+// HibernateControlPlane takes an etcd snapshot on the first node, unless the snapshot name is empty, then stops
+// rke2-server on all the control plane nodes. It returns true once the snapshot is complete and the nodes are being
+// stopped, along with the name of the snapshot file. The jobs stopping the nodes are named after the hibernation run,
+// so the jobs left by a previous hibernation aren't mistaken for the ones of this run.
+func (w *Workload) HibernateControlPlane(ctx context.Context, nodeNames []string, snapshotName, runID string) (string, bool, error) {
+	if len(nodeNames) == 0 {
+		return "", false, errors.New("no control plane node to hibernate")
+	}
+
+	snapshotFile := ""
+
+	if snapshotName != "" {
+		var err error
+
+		if snapshotFile, err = w.SnapshotEtcd(ctx, nodeNames[0], snapshotName); err != nil || snapshotFile == "" {
+			return "", false, err
+		}
+	}
+
+	for _, nodeName := range nodeNames {
+		stopJob := w.newHibernationJob(hostJobName(hibernationStopJobPrefix, nodeName, runID), nodeName,
+			fmt.Sprintf(hostCommand, stopCommand))
+
+		if err := w.Client.Create(ctx, stopJob); err != nil && !apierrors.IsAlreadyExists(err) {
+			return "", false, errors.Wrapf(err, "failed to create the job stopping node %s", nodeName)
+		}
+	}
+
+	return snapshotFile, true, nil
+}
+
+// SnapshotEtcd takes an etcd snapshot on the node, with the etcd backup configuration of the node, so the snapshot is
+// also uploaded to S3 when it is enabled. It returns the name of the snapshot file once the snapshot is complete, see
+// IsEtcdSnapshotFile, and an empty name meanwhile. The job is named after the snapshot, a failed job is removed so
+// that the snapshot is taken again.
+func (w *Workload) SnapshotEtcd(ctx context.Context, nodeName string, snapshotName string) (string, error) {
+	jobName := hostJobName(etcdSnapshotJobPrefix, snapshotName)
+	snapshotJob := &batchv1.Job{}
+
+	err := w.Client.Get(ctx, ctrlclient.ObjectKey{Namespace: HibernationNamespace, Name: jobName}, snapshotJob)
+
+	switch {
+	case err == nil && snapshotJob.Status.Failed > 0:
+		if err := w.Client.Delete(ctx, snapshotJob, ctrlclient.PropagationPolicy(metav1.DeletePropagationBackground)); err != nil &&
+			!apierrors.IsNotFound(err) {
+			return "", errors.Wrap(err, "failed to delete the failed etcd snapshot job")
+		}
+
+		return "", errors.Errorf("etcd snapshot job %s/%s failed, it is created again", HibernationNamespace, jobName)
+	case apierrors.IsNotFound(err):
+		snapshotJob = w.newHibernationJob(jobName, nodeName,
+			fmt.Sprintf(hostCommand, fmt.Sprintf(snapshotCommand, snapshotName))+" > /dev/termination-log")
+
+		if err := w.Client.Create(ctx, snapshotJob); err != nil {
+			return "", errors.Wrap(err, "failed to create the etcd snapshot job")
+		}
+
+		return "", nil
+	case err != nil:
+		return "", errors.Wrap(err, "failed to get the etcd snapshot job")
+	case snapshotJob.Status.Succeeded == 0:
+		return "", nil
+	}
+
+	snapshotFile, err := w.jobTerminationMessage(ctx, snapshotJob)
+	if err != nil {
+		return "", err
+	}
+
+	if !IsEtcdSnapshotFile(snapshotFile, snapshotName) {
+		return "", errors.Errorf("etcd snapshot job %s/%s reported an unexpected snapshot file %q", HibernationNamespace,
+			jobName, snapshotFile)
+	}
+
+	return snapshotFile, nil
+}
+
+// IsEtcdSnapshotFile returns true when the file is the one of a snapshot saved with the name by SnapshotEtcd.
+func IsEtcdSnapshotFile(fileName, snapshotName string) bool {
+	return snapshotName != "" && strings.HasPrefix(fileName, snapshotName+"-")
+}
+
+// jobTerminationMessage returns the termination message of the container of the succeeded pod of the job.
+func (w *Workload) jobTerminationMessage(ctx context.Context, job *batchv1.Job) (string, error) {
+	if job.Spec.Selector == nil {
+		return "", errors.Errorf("job %s/%s has no pod selector", job.Namespace, job.Name)
+	}
+
+	selector, err := metav1.LabelSelectorAsSelector(job.Spec.Selector)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to parse the pod selector of job %s/%s", job.Namespace, job.Name)
+	}
+
+	pods := &corev1.PodList{}
+	if err := w.Client.List(ctx, pods, ctrlclient.InNamespace(job.Namespace),
+		ctrlclient.MatchingLabelsSelector{Selector: selector}); err != nil {
+		return "", errors.Wrapf(err, "failed to list the pods of job %s/%s", job.Namespace, job.Name)
+	}
+
+	for _, pod := range pods.Items {
+		if pod.Status.Phase != corev1.PodSucceeded {
+			continue
+		}
+
+		for _, status := range pod.Status.ContainerStatuses {
+			if status.State.Terminated != nil && status.State.Terminated.Message != "" {
+				return strings.TrimSpace(status.State.Terminated.Message), nil
+			}
+		}
+	}
+
+	return "", errors.Errorf("no termination message found for job %s/%s", job.Namespace, job.Name)
+}
+
+// ResumeControlPlane removes the jobs left by the hibernation of the control plane, it fails as long as the workload
+// cluster API server is not reachable.
+func (w *Workload) ResumeControlPlane(ctx context.Context) error {
+	jobs := &batchv1.JobList{}
+	if err := w.Client.List(ctx, jobs, ctrlclient.InNamespace(HibernationNamespace), ctrlclient.HasLabels{hibernationJobLabel}); err != nil {
+		return errors.Wrap(err, "failed to list the hibernation jobs")
+	}
+
+	errs := []error{}
+
+	for i := range jobs.Items {
+		if err := w.Client.Delete(ctx, &jobs.Items[i], ctrlclient.PropagationPolicy(metav1.DeletePropagationBackground)); err != nil &&
+			!apierrors.IsNotFound(err) {
+			errs = append(errs, errors.Wrapf(err, "failed to delete job %s", jobs.Items[i].Name))
+		}
+	}
+
+	return kerrors.NewAggregate(errs)
+}
+
+func (w *Workload) newHibernationJob(name, nodeName, command string) *batchv1.Job {
+	return w.newHostJob(name, nodeName, command, hibernationJobLabel, "hibernation")
+}
+
+// hostJobImage returns the image of the jobs running commands on the hosts of the nodes.
+func (w *Workload) hostJobImage() string {
+	if w.HostJobImage != "" {
+		return w.HostJobImage
+	}
+
+	return DefaultHostJobImage
+}
+
+// hostJobName returns the name of a job running a command on a host, made of the prefix and the parts. The parts are
+// replaced by their hash when the name would be too long for the job-name label of the job pods.
+func hostJobName(prefix string, parts ...string) string {
+	name := prefix + strings.Join(parts, "-")
+	if len(name) <= validation.DNS1123LabelMaxLength {
+		return name
+	}
+
+	hash := sha256.Sum256([]byte(strings.Join(parts, "/")))
+
+	return prefix + hex.EncodeToString(hash[:])[:16]
+}
+
+// newHostJob returns a job running the command on the host of the node, its pod tolerates all the taints. The job is
+// removed once it has been finished for an hour.
+func (w *Workload) newHostJob(name, nodeName, command, jobLabel, containerName string) *batchv1.Job {
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: HibernationNamespace,
+			Labels:    map[string]string{jobLabel: ""},
+		},
+		Spec: batchv1.JobSpec{
+			// Never retry, the job pod may be killed when the node is stopped or rke2-server restarted.
+			BackoffLimit:            pointer.Int32(0),
+			TTLSecondsAfterFinished: pointer.Int32(hostJobTTL),
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: map[string]string{jobLabel: ""},
+				},
+				Spec: corev1.PodSpec{
+					NodeName:      nodeName,
+					HostPID:       true,
+					RestartPolicy: corev1.RestartPolicyNever,
+					Tolerations: []corev1.Toleration{
+						{Operator: corev1.TolerationOpExists},
+					},
+					Containers: []corev1.Container{
+						{
+							Name:    containerName,
+							Image:   w.hostJobImage(),
+							Command: []string{"sh", "-c", command},
+							SecurityContext: &corev1.SecurityContext{
+								Privileged: pointer.Bool(true),
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
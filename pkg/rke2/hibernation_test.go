@@ -0,0 +1,193 @@
+/*
+Copyright 2023 SUSE.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rke2
+
+import (
+	"context"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// completeSnapshotJob marks the etcd snapshot job succeeded, its pod reporting the snapshot file.
+func completeSnapshotJob(workload *Workload, snapshotName, snapshotFile string) {
+	snapshotJob := &batchv1.Job{}
+	snapshotKey := types.NamespacedName{Namespace: HibernationNamespace, Name: etcdSnapshotJobPrefix + snapshotName}
+	Expect(workload.Client.Get(context.Background(), snapshotKey, snapshotJob)).To(Succeed())
+
+	// The selector is set by the API server.
+	snapshotJob.Spec.Selector = &metav1.LabelSelector{MatchLabels: map[string]string{"controller-uid": "snapshot"}}
+	Expect(workload.Client.Update(context.Background(), snapshotJob)).To(Succeed())
+
+	snapshotJob.Status.Succeeded = 1
+	Expect(workload.Client.Status().Update(context.Background(), snapshotJob)).To(Succeed())
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: HibernationNamespace,
+			Name:      snapshotJob.Name + "-pod",
+			Labels:    map[string]string{"controller-uid": "snapshot"},
+		},
+	}
+	Expect(workload.Client.Create(context.Background(), pod)).To(Succeed())
+
+	pod.Status = corev1.PodStatus{
+		Phase: corev1.PodSucceeded,
+		ContainerStatuses: []corev1.ContainerStatus{{
+			State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{Message: snapshotFile + "\n"}},
+		}},
+	}
+	Expect(workload.Client.Status().Update(context.Background(), pod)).To(Succeed())
+}
+
+var _ = Describe("HibernateControlPlane", func() {
+	var workload *Workload
+
+	BeforeEach(func() {
+		workload = &Workload{
+			Client: fake.NewClientBuilder().Build(),
+		}
+	})
+
+	It("should stop the nodes once the etcd snapshot is complete", func() {
+		nodeNames := []string{"node-1", "node-2"}
+
+		_, stopped, err := workload.HibernateControlPlane(context.Background(), nodeNames, "snapshot", "2")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(stopped).To(BeFalse())
+
+		snapshotJob := &batchv1.Job{}
+		snapshotKey := types.NamespacedName{Namespace: HibernationNamespace, Name: "rke2-etcd-snapshot-snapshot"}
+		Expect(workload.Client.Get(context.Background(), snapshotKey, snapshotJob)).To(Succeed())
+		Expect(snapshotJob.Spec.Template.Spec.NodeName).To(Equal("node-1"))
+		Expect(snapshotJob.Spec.TTLSecondsAfterFinished).ToNot(BeNil())
+		Expect(snapshotJob.Spec.Template.Spec.Containers[0].Image).To(Equal(DefaultHostJobImage))
+		Expect(snapshotJob.Spec.Template.Spec.Containers[0].Command).To(ContainElement(And(
+			ContainSubstring("rke2 etcd-snapshot save --name snapshot"),
+			HaveSuffix("> /dev/termination-log"),
+		)))
+
+		completeSnapshotJob(workload, "snapshot", "snapshot-node-1-1700000000")
+
+		snapshotFile, stopped, err := workload.HibernateControlPlane(context.Background(), nodeNames, "snapshot", "2")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(stopped).To(BeTrue())
+		Expect(snapshotFile).To(Equal("snapshot-node-1-1700000000"))
+
+		for _, nodeName := range nodeNames {
+			stopJob := &batchv1.Job{}
+			stopKey := types.NamespacedName{Namespace: HibernationNamespace, Name: "rke2-hibernation-stop-" + nodeName + "-2"}
+			Expect(workload.Client.Get(context.Background(), stopKey, stopJob)).To(Succeed())
+			Expect(stopJob.Spec.Template.Spec.NodeName).To(Equal(nodeName))
+		}
+
+		Expect(workload.ResumeControlPlane(context.Background())).To(Succeed())
+
+		jobs := &batchv1.JobList{}
+		Expect(workload.Client.List(context.Background(), jobs)).To(Succeed())
+		Expect(jobs.Items).To(BeEmpty())
+	})
+})
+
+var _ = Describe("SnapshotEtcd", func() {
+	var workload *Workload
+
+	snapshotKey := types.NamespacedName{Namespace: HibernationNamespace, Name: "rke2-etcd-snapshot-snapshot-1"}
+
+	BeforeEach(func() {
+		workload = &Workload{
+			Client:       fake.NewClientBuilder().Build(),
+			HostJobImage: "registry.example.com/busybox:1.36",
+		}
+	})
+
+	It("should take each snapshot in its own job", func() {
+		snapshotFile, err := workload.SnapshotEtcd(context.Background(), "node-1", "snapshot-1")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(snapshotFile).To(BeEmpty())
+
+		completeSnapshotJob(workload, "snapshot-1", "snapshot-1-node-1-1700000000")
+
+		snapshotFile, err = workload.SnapshotEtcd(context.Background(), "node-1", "snapshot-1")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(snapshotFile).To(Equal("snapshot-1-node-1-1700000000"))
+
+		snapshotFile, err = workload.SnapshotEtcd(context.Background(), "node-1", "snapshot-2")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(snapshotFile).To(BeEmpty())
+
+		snapshotFile, err = workload.SnapshotEtcd(context.Background(), "node-1", "snapshot-2")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(snapshotFile).To(BeEmpty())
+
+		snapshotJob := &batchv1.Job{}
+		snapshotKey := types.NamespacedName{Namespace: HibernationNamespace, Name: "rke2-etcd-snapshot-snapshot-2"}
+		Expect(workload.Client.Get(context.Background(), snapshotKey, snapshotJob)).To(Succeed())
+		Expect(snapshotJob.Spec.Template.Spec.Containers[0].Command).To(ContainElement(ContainSubstring("--name snapshot-2")))
+		Expect(snapshotJob.Spec.Template.Spec.Containers[0].Image).To(Equal("registry.example.com/busybox:1.36"))
+
+		snapshotFile, err = workload.SnapshotEtcd(context.Background(), "node-1", "snapshot-1")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(snapshotFile).To(Equal("snapshot-1-node-1-1700000000"))
+	})
+
+	It("should take the snapshot again once its job failed", func() {
+		_, err := workload.SnapshotEtcd(context.Background(), "node-1", "snapshot-1")
+		Expect(err).ToNot(HaveOccurred())
+
+		snapshotJob := &batchv1.Job{}
+		Expect(workload.Client.Get(context.Background(), snapshotKey, snapshotJob)).To(Succeed())
+		snapshotJob.Status.Failed = 1
+		Expect(workload.Client.Status().Update(context.Background(), snapshotJob)).To(Succeed())
+
+		_, err = workload.SnapshotEtcd(context.Background(), "node-1", "snapshot-1")
+		Expect(err).To(HaveOccurred())
+
+		snapshotFile, err := workload.SnapshotEtcd(context.Background(), "node-1", "snapshot-1")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(snapshotFile).To(BeEmpty())
+
+		Expect(workload.Client.Get(context.Background(), snapshotKey, snapshotJob)).To(Succeed())
+		Expect(snapshotJob.Status.Failed).To(BeZero())
+	})
+})
+
+var _ = Describe("hostJobName", func() {
+	It("should hash the parts of the names too long for a label", func() {
+		Expect(hostJobName(hibernationStopJobPrefix, "node-1", "2")).To(Equal("rke2-hibernation-stop-node-1-2"))
+
+		name := hostJobName(hibernationStopJobPrefix, strings.Repeat("node", 16), "2")
+		Expect(len(name)).To(BeNumerically("<=", 63))
+		Expect(name).To(HavePrefix(hibernationStopJobPrefix))
+		Expect(name).ToNot(Equal(hostJobName(hibernationStopJobPrefix, strings.Repeat("node", 16), "3")))
+	})
+})
+
+var _ = Describe("IsEtcdSnapshotFile", func() {
+	It("should match the files of the snapshots saved with the name", func() {
+		Expect(IsEtcdSnapshotFile("snapshot-node-1-1700000000", "snapshot")).To(BeTrue())
+		Expect(IsEtcdSnapshotFile("snapshot", "snapshot")).To(BeFalse())
+		Expect(IsEtcdSnapshotFile("snapshots-node-1-1700000000", "snapshot")).To(BeFalse())
+		Expect(IsEtcdSnapshotFile("", "")).To(BeFalse())
+	})
+})
@@ -0,0 +1,87 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rke2
+
+import (
+	"sort"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+)
+
+// InfrastructureMachineAnnotations returns the annotations to set on a new infrastructure machine: the desired
+// annotations, along with the keys of the desired annotations.
+func InfrastructureMachineAnnotations(desired map[string]string) map[string]string {
+	obj := &metav1.ObjectMeta{}
+	SetInfrastructureMachineAnnotations(obj, desired)
+
+	return obj.GetAnnotations()
+}
+
+// SetInfrastructureMachineAnnotations sets the desired annotations on an infrastructure machine, and removes the
+// annotations set previously that are not desired anymore. It returns whether the annotations changed.
+func SetInfrastructureMachineAnnotations(obj metav1.Object, desired map[string]string) bool {
+	annotations := obj.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+
+	changed := false
+
+	if previous := annotations[controlplanev1.InfrastructureMachineAnnotationsAnnotation]; previous != "" {
+		for _, key := range strings.Split(previous, ",") {
+			if _, ok := desired[key]; !ok {
+				delete(annotations, key)
+
+				changed = true
+			}
+		}
+	}
+
+	keys := make([]string, 0, len(desired))
+
+	for key, value := range desired {
+		keys = append(keys, key)
+
+		if current, ok := annotations[key]; !ok || current != value {
+			annotations[key] = value
+			changed = true
+		}
+	}
+
+	sort.Strings(keys)
+
+	if joined := strings.Join(keys, ","); joined != annotations[controlplanev1.InfrastructureMachineAnnotationsAnnotation] {
+		changed = true
+
+		if joined == "" {
+			delete(annotations, controlplanev1.InfrastructureMachineAnnotationsAnnotation)
+		} else {
+			annotations[controlplanev1.InfrastructureMachineAnnotationsAnnotation] = joined
+		}
+	}
+
+	if len(annotations) == 0 {
+		annotations = nil
+	}
+
+	obj.SetAnnotations(annotations)
+
+	return changed
+}
@@ -0,0 +1,59 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rke2
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+)
+
+var _ = Describe("SetInfrastructureMachineAnnotations", func() {
+	It("should set the desired annotations on new infrastructure machines", func() {
+		Expect(InfrastructureMachineAnnotations(map[string]string{"b": "2", "a": "1"})).To(Equal(map[string]string{
+			"a": "1",
+			"b": "2",
+			controlplanev1.InfrastructureMachineAnnotationsAnnotation: "a,b",
+		}))
+		Expect(InfrastructureMachineAnnotations(nil)).To(BeEmpty())
+	})
+
+	It("should update the annotations and remove the ones not desired anymore", func() {
+		obj := &metav1.ObjectMeta{Annotations: map[string]string{
+			"a":     "1",
+			"b":     "2",
+			"other": "kept",
+			controlplanev1.InfrastructureMachineAnnotationsAnnotation: "a,b",
+		}}
+
+		Expect(SetInfrastructureMachineAnnotations(obj, map[string]string{"a": "10", "c": "3"})).To(BeTrue())
+		Expect(obj.Annotations).To(Equal(map[string]string{
+			"a":     "10",
+			"c":     "3",
+			"other": "kept",
+			controlplanev1.InfrastructureMachineAnnotationsAnnotation: "a,c",
+		}))
+
+		Expect(SetInfrastructureMachineAnnotations(obj, map[string]string{"a": "10", "c": "3"})).To(BeFalse())
+
+		Expect(SetInfrastructureMachineAnnotations(obj, nil)).To(BeTrue())
+		Expect(obj.Annotations).To(Equal(map[string]string{"other": "kept"}))
+	})
+})
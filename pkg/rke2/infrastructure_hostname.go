@@ -0,0 +1,78 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rke2
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+)
+
+// MachineHostname returns the hostname of a control plane machine, formatted from its name.
+func MachineHostname(hostname *controlplanev1.InfrastructureMachineHostname, machineName string) (string, error) {
+	format := hostname.Format
+	if format == "" {
+		format = "%s"
+	}
+
+	name := strings.ReplaceAll(format, "%s", machineName)
+
+	if errs := validation.IsDNS1123Subdomain(name); len(errs) > 0 {
+		return "", errors.Errorf("hostname %q of machine %s is invalid: %s", name, machineName, strings.Join(errs, ", "))
+	}
+
+	return name, nil
+}
+
+// SetInfrastructureMachineHostname sets the hostname of a control plane machine on its infrastructure machine, in the
+// field and in the annotation of the hostname configuration.
+func SetInfrastructureMachineHostname(
+	obj *unstructured.Unstructured,
+	hostname *controlplanev1.InfrastructureMachineHostname,
+	machineName string,
+) error {
+	if hostname == nil {
+		return nil
+	}
+
+	name, err := MachineHostname(hostname, machineName)
+	if err != nil {
+		return err
+	}
+
+	if hostname.FieldPath != "" {
+		if err := unstructured.SetNestedField(obj.Object, name, strings.Split(hostname.FieldPath, ".")...); err != nil {
+			return errors.Wrapf(err, "failed to set the hostname in field %s", hostname.FieldPath)
+		}
+	}
+
+	if hostname.Annotation != "" {
+		annotations := obj.GetAnnotations()
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+
+		annotations[hostname.Annotation] = name
+		obj.SetAnnotations(annotations)
+	}
+
+	return nil
+}
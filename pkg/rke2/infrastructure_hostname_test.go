@@ -0,0 +1,73 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rke2
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+)
+
+var _ = Describe("SetInfrastructureMachineHostname", func() {
+	var infraMachine *unstructured.Unstructured
+
+	BeforeEach(func() {
+		infraMachine = &unstructured.Unstructured{Object: map[string]interface{}{
+			"spec": map[string]interface{}{"template": "ubuntu"},
+		}}
+		infraMachine.SetAnnotations(map[string]string{"example.com/zone": "a"})
+	})
+
+	It("should set the hostname in the field and the annotation", func() {
+		Expect(SetInfrastructureMachineHostname(infraMachine, &controlplanev1.InfrastructureMachineHostname{
+			Format:     "%s.example.com",
+			FieldPath:  "spec.network.hostname",
+			Annotation: "example.com/hostname",
+		}, "rcp-x7k2p")).To(Succeed())
+
+		hostname, _, _ := unstructured.NestedString(infraMachine.Object, "spec", "network", "hostname")
+		Expect(hostname).To(Equal("rcp-x7k2p.example.com"))
+		Expect(infraMachine.GetAnnotations()).To(Equal(map[string]string{
+			"example.com/zone":     "a",
+			"example.com/hostname": "rcp-x7k2p.example.com",
+		}))
+	})
+
+	It("should use the machine name by default", func() {
+		Expect(SetInfrastructureMachineHostname(infraMachine, &controlplanev1.InfrastructureMachineHostname{
+			FieldPath: "spec.hostname",
+		}, "rcp-x7k2p")).To(Succeed())
+
+		hostname, _, _ := unstructured.NestedString(infraMachine.Object, "spec", "hostname")
+		Expect(hostname).To(Equal("rcp-x7k2p"))
+	})
+
+	It("should leave the infrastructure machine untouched without hostname configuration", func() {
+		before := infraMachine.DeepCopy()
+
+		Expect(SetInfrastructureMachineHostname(infraMachine, nil, "rcp-x7k2p")).To(Succeed())
+		Expect(infraMachine).To(Equal(before))
+	})
+
+	It("should refuse invalid hostnames", func() {
+		_, err := MachineHostname(&controlplanev1.InfrastructureMachineHostname{Format: "%s_node"}, "rcp-x7k2p")
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,122 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rke2
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util/collections"
+
+	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+)
+
+// InterruptedMachines returns the control plane machines about to be interrupted, which are not deleted yet.
+func (c *ControlPlane) InterruptedMachines() collections.Machines {
+	return c.Machines.Filter(
+		collections.HasAnnotationKey(controlplanev1.InterruptionImminentAnnotation),
+		collections.Not(collections.HasDeletionTimestamp),
+	)
+}
+
+// EtcdLeaderCandidate returns the machine the etcd leadership is forwarded to when the given machine is about to be
+// interrupted: the oldest ready machine running etcd, which is neither interrupted nor deleted. Nil is returned when
+// there is no such machine.
+func (c *ControlPlane) EtcdLeaderCandidate(machine *clusterv1.Machine) *clusterv1.Machine {
+	return c.EtcdMachines().Filter(
+		collections.IsReady(),
+		collections.Not(collections.HasAnnotationKey(controlplanev1.InterruptionImminentAnnotation)),
+		collections.Not(collections.HasDeletionTimestamp),
+		func(candidate *clusterv1.Machine) bool {
+			return candidate.Name != machine.Name && candidate.Status.NodeRef != nil
+		},
+	).Oldest()
+}
+
+// ForwardEtcdLeadership moves the etcd leadership from the member of the node of a machine to the member of the node
+// of the leader candidate, when the member of the machine is the leader. Nothing is done when the machine has no node.
+func (w *Workload) ForwardEtcdLeadership(ctx context.Context, machine, leaderCandidate *clusterv1.Machine) error {
+	if machine == nil || machine.Status.NodeRef == nil {
+		return nil
+	}
+
+	if leaderCandidate == nil || leaderCandidate.Status.NodeRef == nil {
+		return errors.New("the etcd leader candidate has no node")
+	}
+
+	if w.EtcdClient == nil {
+		return ErrEtcdClientUnavailable
+	}
+
+	nodes, err := w.getEtcdNodes(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to list the etcd nodes")
+	}
+
+	members, err := w.EtcdClient.MemberList(ctx, etcdEndpoints(nodes))
+	if err != nil {
+		return err
+	}
+
+	member, found := etcdMemberOfNode(members, nodes, machine.Status.NodeRef.Name)
+	if !found || len(member.ClientURLs) == 0 {
+		return errors.Errorf("no etcd member found for node %s", machine.Status.NodeRef.Name)
+	}
+
+	candidate, found := etcdMemberOfNode(members, nodes, leaderCandidate.Status.NodeRef.Name)
+	if !found || candidate.IsLearner {
+		return errors.Errorf("no voting etcd member found for node %s", leaderCandidate.Status.NodeRef.Name)
+	}
+
+	status, err := w.EtcdClient.Status(ctx, member.ClientURLs[0])
+	if err != nil {
+		return err
+	}
+
+	if status.Leader != member.ID {
+		return nil
+	}
+
+	if err := w.EtcdClient.MoveLeader(ctx, member.ClientURLs[0], candidate.ID); err != nil {
+		return err
+	}
+
+	log.FromContext(ctx).Info("Moved the etcd leadership", "from", member.Name, "to", candidate.Name)
+
+	return nil
+}
+
+// etcdMemberOfNode returns the etcd member of a node, named by the etcd node name annotation of the node.
+func etcdMemberOfNode(members []EtcdMember, nodes *corev1.NodeList, nodeName string) (EtcdMember, bool) {
+	for _, node := range nodes.Items {
+		if node.Name != nodeName || node.Annotations[etcdNodeNameAnnotation] == "" {
+			continue
+		}
+
+		for _, member := range members {
+			if member.Name == node.Annotations[etcdNodeNameAnnotation] {
+				return member, true
+			}
+		}
+	}
+
+	return EtcdMember{}, false
+}
@@ -0,0 +1,127 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rke2
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util/collections"
+	"sigs.k8s.io/cluster-api/util/conditions"
+
+	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+)
+
+var _ = Describe("machine interruptions", func() {
+	var (
+		workload     *Workload
+		controlPlane *ControlPlane
+		etcdClient   *fakeEtcdClient
+	)
+
+	BeforeEach(func() {
+		objs := []ctrlclient.Object{}
+		machines := collections.New()
+		etcdClient = &fakeEtcdClient{leader: 1}
+		created := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+
+		for i := 1; i <= 3; i++ {
+			name := fmt.Sprintf("node-%d", i)
+			address := fmt.Sprintf("10.0.0.%d", i)
+
+			objs = append(objs, &corev1.Node{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        name,
+					Labels:      map[string]string{labelNodeRoleControlPlane: "true", labelNodeRoleEtcd: "true"},
+					Annotations: map[string]string{etcdNodeNameAnnotation: name + "-5c6e2f1a"},
+				},
+				Status: corev1.NodeStatus{Addresses: []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: address}}},
+			})
+
+			machine := &clusterv1.Machine{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:              fmt.Sprintf("machine-%d", i),
+					CreationTimestamp: metav1.NewTime(created.Add(time.Duration(i) * time.Minute)),
+				},
+				Status: clusterv1.MachineStatus{NodeRef: &corev1.ObjectReference{Name: name}},
+			}
+			conditions.MarkTrue(machine, clusterv1.ReadyCondition)
+			machines.Insert(machine)
+
+			etcdClient.members = append(etcdClient.members, EtcdMember{
+				ID:         uint64(i),
+				Name:       name + "-5c6e2f1a",
+				ClientURLs: []string{EtcdEndpoint(address)},
+			})
+		}
+
+		machines["machine-1"].Annotations = map[string]string{controlplanev1.InterruptionImminentAnnotation: ""}
+
+		workload = &Workload{Client: fake.NewClientBuilder().WithObjects(objs...).Build(), EtcdClient: etcdClient}
+		controlPlane = &ControlPlane{RCP: &controlplanev1.RKE2ControlPlane{}, Machines: machines}
+	})
+
+	It("should find the machines about to be interrupted", func() {
+		Expect(controlPlane.InterruptedMachines().Names()).To(ConsistOf("machine-1"))
+
+		now := metav1.Now()
+		controlPlane.Machines["machine-1"].DeletionTimestamp = &now
+		Expect(controlPlane.InterruptedMachines()).To(BeEmpty())
+	})
+
+	It("should elect the oldest healthy machine as the etcd leader candidate", func() {
+		interrupted := controlPlane.Machines["machine-1"]
+		Expect(controlPlane.EtcdLeaderCandidate(interrupted).Name).To(Equal("machine-2"))
+
+		conditions.MarkFalse(controlPlane.Machines["machine-2"], clusterv1.ReadyCondition, "NotReady",
+			clusterv1.ConditionSeverityWarning, "")
+		Expect(controlPlane.EtcdLeaderCandidate(interrupted).Name).To(Equal("machine-3"))
+
+		controlPlane.Machines["machine-3"].Annotations = map[string]string{controlplanev1.InterruptionImminentAnnotation: ""}
+		Expect(controlPlane.EtcdLeaderCandidate(interrupted)).To(BeNil())
+	})
+
+	It("should move the etcd leadership off the machine about to be interrupted", func() {
+		Expect(workload.ForwardEtcdLeadership(context.Background(), controlPlane.Machines["machine-1"],
+			controlPlane.Machines["machine-2"])).To(Succeed())
+		Expect(etcdClient.leader).To(Equal(uint64(2)))
+	})
+
+	It("should keep the etcd leadership of another member", func() {
+		Expect(workload.ForwardEtcdLeadership(context.Background(), controlPlane.Machines["machine-3"],
+			controlPlane.Machines["machine-2"])).To(Succeed())
+		Expect(etcdClient.leader).To(Equal(uint64(1)))
+	})
+
+	It("should fail when the leader candidate has no etcd member", func() {
+		controlPlane.Machines["machine-2"].Status.NodeRef.Name = "node-4"
+
+		Expect(workload.ForwardEtcdLeadership(context.Background(), controlPlane.Machines["machine-1"],
+			controlPlane.Machines["machine-2"])).ToNot(Succeed())
+		Expect(etcdClient.leader).To(Equal(uint64(1)))
+	})
+})
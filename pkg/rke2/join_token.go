@@ -0,0 +1,83 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rke2
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	bootstrapapi "k8s.io/cluster-bootstrap/token/api"
+	bootstraputil "k8s.io/cluster-bootstrap/token/util"
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// joinTokenGroup is the group "rke2 token create" assigns to the bootstrap tokens agents join the cluster with.
+const joinTokenGroup = "system:bootstrappers:k3s:default-node-token"
+
+// NewJoinToken generates a bootstrap token for the machine to join the cluster with, expiring at the given time. It
+// returns the token, its ID and the secret storing it in the kube-system namespace of the workload cluster.
+func NewJoinToken(machine *clusterv1.Machine, expiration time.Time) (string, string, *corev1.Secret, error) {
+	token, err := bootstraputil.GenerateBootstrapToken()
+	if err != nil {
+		return "", "", nil, errors.Wrap(err, "failed to generate a bootstrap token")
+	}
+
+	tokenID, tokenSecret, _ := strings.Cut(token, ".")
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      bootstraputil.BootstrapTokenSecretName(tokenID),
+			Namespace: metav1.NamespaceSystem,
+		},
+		Type: bootstrapapi.SecretTypeBootstrapToken,
+		StringData: map[string]string{
+			bootstrapapi.BootstrapTokenIDKey:               tokenID,
+			bootstrapapi.BootstrapTokenSecretKey:           tokenSecret,
+			bootstrapapi.BootstrapTokenExpirationKey:       expiration.UTC().Format(time.RFC3339),
+			bootstrapapi.BootstrapTokenDescriptionKey:      fmt.Sprintf("Join token of the machine %s/%s", machine.Namespace, machine.Name),
+			bootstrapapi.BootstrapTokenUsageAuthentication: "true",
+			bootstrapapi.BootstrapTokenUsageSigningKey:     "true",
+			bootstrapapi.BootstrapTokenExtraGroupsKey:      joinTokenGroup,
+		},
+	}
+
+	return token, tokenID, secret, nil
+}
+
+// DeleteJoinToken deletes the bootstrap token a machine joined the cluster with from the workload cluster, so that
+// it can't be used to join other nodes.
+func DeleteJoinToken(ctx context.Context, workloadClient ctrlclient.Client, tokenID string) error {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      bootstraputil.BootstrapTokenSecretName(tokenID),
+			Namespace: metav1.NamespaceSystem,
+		},
+	}
+
+	if err := workloadClient.Delete(ctx, secret); err != nil && !apierrors.IsNotFound(err) {
+		return errors.Wrapf(err, "failed to delete the bootstrap token %s", tokenID)
+	}
+
+	return nil
+}
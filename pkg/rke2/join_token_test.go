@@ -0,0 +1,80 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rke2
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	bootstrapapi "k8s.io/cluster-bootstrap/token/api"
+	bootstraputil "k8s.io/cluster-bootstrap/token/util"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+)
+
+var _ = Describe("JoinToken", func() {
+	var machine *clusterv1.Machine
+
+	BeforeEach(func() {
+		machine = &clusterv1.Machine{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "worker-1"}}
+	})
+
+	It("should generate a bootstrap token of the machine expiring at the given time", func() {
+		expiration := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+
+		token, tokenID, secret, err := NewJoinToken(machine, expiration)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(bootstraputil.IsValidBootstrapToken(token)).To(BeTrue())
+		Expect(token).To(HavePrefix(tokenID + "."))
+
+		Expect(secret.Namespace).To(Equal(metav1.NamespaceSystem))
+		Expect(secret.Name).To(Equal("bootstrap-token-" + tokenID))
+		Expect(secret.Type).To(Equal(bootstrapapi.SecretTypeBootstrapToken))
+		Expect(secret.StringData).To(HaveKeyWithValue(bootstrapapi.BootstrapTokenIDKey, tokenID))
+		Expect(secret.StringData).To(HaveKeyWithValue(bootstrapapi.BootstrapTokenSecretKey, token[len(tokenID)+1:]))
+		Expect(secret.StringData).To(HaveKeyWithValue(bootstrapapi.BootstrapTokenExpirationKey, "2023-06-01T12:00:00Z"))
+		Expect(secret.StringData).To(HaveKeyWithValue(bootstrapapi.BootstrapTokenUsageAuthentication, "true"))
+		Expect(secret.StringData).To(HaveKeyWithValue(bootstrapapi.BootstrapTokenDescriptionKey,
+			"Join token of the machine default/worker-1"))
+
+		otherToken, _, _, err := NewJoinToken(machine, expiration)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(otherToken).ToNot(Equal(token))
+	})
+
+	It("should delete the bootstrap token of the machine from the workload cluster", func() {
+		_, tokenID, secret, err := NewJoinToken(machine, time.Now().Add(time.Hour))
+		Expect(err).ToNot(HaveOccurred())
+
+		workloadClient := fake.NewClientBuilder().WithObjects(secret).Build()
+
+		Expect(DeleteJoinToken(context.Background(), workloadClient, tokenID)).To(Succeed())
+
+		err = workloadClient.Get(context.Background(), ctrlclient.ObjectKeyFromObject(secret), &corev1.Secret{})
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+
+		Expect(DeleteJoinToken(context.Background(), workloadClient, tokenID)).To(Succeed())
+	})
+})
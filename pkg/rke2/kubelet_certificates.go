@@ -0,0 +1,172 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rke2
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	certificatesv1 "k8s.io/api/certificates/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util/collections"
+)
+
+const (
+	nodeUserPrefix = "system:node:"
+	nodesGroup     = "system:nodes"
+)
+
+// ApproveKubeletServingCertificates approves the pending certificate signing requests of the kubelet serving
+// certificates of the nodes of the machines, RKE2 doesn't approve them when the kubelet rotates its serving
+// certificate. A request is only approved if it is made by the node, and only for the addresses of its machine.
+func (w *Workload) ApproveKubeletServingCertificates(ctx context.Context, machines collections.Machines) error {
+	logger := log.FromContext(ctx)
+
+	csrs := &certificatesv1.CertificateSigningRequestList{}
+	if err := w.Client.List(ctx, csrs); err != nil {
+		return errors.Wrap(err, "failed to list the certificate signing requests")
+	}
+
+	nodeMachines := map[string]*clusterv1.Machine{}
+
+	for _, machine := range machines {
+		if machine.Status.NodeRef != nil {
+			nodeMachines[machine.Status.NodeRef.Name] = machine
+		}
+	}
+
+	for i := range csrs.Items {
+		csr := &csrs.Items[i]
+
+		if csr.Spec.SignerName != certificatesv1.KubeletServingSignerName || isCertificateSigningRequestHandled(csr) ||
+			!strings.HasPrefix(csr.Spec.Username, nodeUserPrefix) {
+			continue
+		}
+
+		machine, ok := nodeMachines[strings.TrimPrefix(csr.Spec.Username, nodeUserPrefix)]
+		if !ok {
+			continue
+		}
+
+		if err := validateKubeletServingCertificateRequest(csr, machine); err != nil {
+			logger.Info("Not approving kubelet serving certificate signing request", "csr", csr.Name, "reason", err.Error())
+
+			continue
+		}
+
+		csr.Status.Conditions = append(csr.Status.Conditions, certificatesv1.CertificateSigningRequestCondition{
+			Type:           certificatesv1.CertificateApproved,
+			Status:         corev1.ConditionTrue,
+			Reason:         "ApprovedByRKE2ControlPlane",
+			Message:        fmt.Sprintf("Kubelet serving certificate of machine %s", machine.Name),
+			LastUpdateTime: metav1.Now(),
+		})
+
+		if err := w.Client.SubResource("approval").Update(ctx, csr); err != nil {
+			return errors.Wrapf(err, "failed to approve certificate signing request %s", csr.Name)
+		}
+
+		logger.Info("Approved kubelet serving certificate signing request", "csr", csr.Name, "machine", machine.Name)
+	}
+
+	return nil
+}
+
+func isCertificateSigningRequestHandled(csr *certificatesv1.CertificateSigningRequest) bool {
+	for _, condition := range csr.Status.Conditions {
+		switch condition.Type {
+		case certificatesv1.CertificateApproved, certificatesv1.CertificateDenied, certificatesv1.CertificateFailed:
+			return true
+		}
+	}
+
+	return false
+}
+
+// validateKubeletServingCertificateRequest checks the request is the one of a kubelet serving certificate, for the
+// addresses of the machine.
+func validateKubeletServingCertificateRequest(csr *certificatesv1.CertificateSigningRequest, machine *clusterv1.Machine) error {
+	if !sets.NewString(csr.Spec.Groups...).Has(nodesGroup) {
+		return fmt.Errorf("requester is not in the %s group", nodesGroup)
+	}
+
+	allowedUsages := sets.NewString(string(certificatesv1.UsageDigitalSignature), string(certificatesv1.UsageKeyEncipherment),
+		string(certificatesv1.UsageServerAuth))
+
+	usages := sets.NewString()
+	for _, usage := range csr.Spec.Usages {
+		usages.Insert(string(usage))
+	}
+
+	if !usages.Has(string(certificatesv1.UsageServerAuth)) || !allowedUsages.IsSuperset(usages) {
+		return fmt.Errorf("unexpected usages %v", usages.List())
+	}
+
+	block, _ := pem.Decode(csr.Spec.Request)
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return errors.New("request is not a PEM encoded certificate request")
+	}
+
+	request, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return errors.Wrap(err, "failed to parse the certificate request")
+	}
+
+	if request.Subject.CommonName != csr.Spec.Username {
+		return fmt.Errorf("common name %q is not the requester", request.Subject.CommonName)
+	}
+
+	if len(request.Subject.Organization) != 1 || request.Subject.Organization[0] != nodesGroup {
+		return fmt.Errorf("organization %v is not %s", request.Subject.Organization, nodesGroup)
+	}
+
+	if len(request.EmailAddresses) > 0 || len(request.URIs) > 0 {
+		return errors.New("email addresses and URIs are not allowed")
+	}
+
+	if len(request.DNSNames)+len(request.IPAddresses) == 0 {
+		return errors.New("no subject alternative name")
+	}
+
+	allowedAddresses := sets.NewString(machine.Status.NodeRef.Name)
+	for _, address := range machine.Status.Addresses {
+		allowedAddresses.Insert(address.Address)
+	}
+
+	for _, dnsName := range request.DNSNames {
+		if !allowedAddresses.Has(dnsName) {
+			return fmt.Errorf("DNS name %s is not an address of the machine", dnsName)
+		}
+	}
+
+	for _, ip := range request.IPAddresses {
+		if !allowedAddresses.Has(ip.String()) {
+			return fmt.Errorf("IP address %s is not an address of the machine", ip)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,110 @@
+/*
+Copyright 2023 SUSE.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rke2
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"net"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	certificatesv1 "k8s.io/api/certificates/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util/collections"
+)
+
+var _ = Describe("ApproveKubeletServingCertificates", func() {
+	var (
+		workload *Workload
+		machines collections.Machines
+	)
+
+	newCSR := func(name, nodeName string, ips ...string) *certificatesv1.CertificateSigningRequest {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		Expect(err).ToNot(HaveOccurred())
+
+		template := &x509.CertificateRequest{
+			Subject:  pkix.Name{CommonName: nodeUserPrefix + nodeName, Organization: []string{nodesGroup}},
+			DNSNames: []string{nodeName},
+		}
+		for _, ip := range ips {
+			template.IPAddresses = append(template.IPAddresses, net.ParseIP(ip))
+		}
+
+		request, err := x509.CreateCertificateRequest(rand.Reader, template, key)
+		Expect(err).ToNot(HaveOccurred())
+
+		return &certificatesv1.CertificateSigningRequest{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec: certificatesv1.CertificateSigningRequestSpec{
+				Request:    pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: request}),
+				SignerName: certificatesv1.KubeletServingSignerName,
+				Usages: []certificatesv1.KeyUsage{
+					certificatesv1.UsageDigitalSignature, certificatesv1.UsageKeyEncipherment, certificatesv1.UsageServerAuth,
+				},
+				Username: nodeUserPrefix + nodeName,
+				Groups:   []string{nodesGroup, "system:authenticated"},
+			},
+		}
+	}
+
+	isApproved := func(name string) bool {
+		csr := &certificatesv1.CertificateSigningRequest{}
+		Expect(workload.Client.Get(context.Background(), types.NamespacedName{Name: name}, csr)).To(Succeed())
+
+		return isCertificateSigningRequestHandled(csr)
+	}
+
+	BeforeEach(func() {
+		machines = collections.FromMachines(&clusterv1.Machine{
+			ObjectMeta: metav1.ObjectMeta{Name: "machine-1"},
+			Status: clusterv1.MachineStatus{
+				NodeRef: &corev1.ObjectReference{Name: "node-1"},
+				Addresses: clusterv1.MachineAddresses{
+					{Type: clusterv1.MachineInternalIP, Address: "10.0.0.1"},
+				},
+			},
+		})
+
+		workload = &Workload{
+			Client: fake.NewClientBuilder().WithObjects(
+				newCSR("valid", "node-1", "10.0.0.1"),
+				newCSR("foreign-ip", "node-1", "10.0.0.2"),
+				newCSR("unknown-node", "node-2", "10.0.0.1"),
+			).Build(),
+		}
+	})
+
+	It("should only approve the requests of the machine nodes for their addresses", func() {
+		Expect(workload.ApproveKubeletServingCertificates(context.Background(), machines)).To(Succeed())
+
+		Expect(isApproved("valid")).To(BeTrue())
+		Expect(isApproved("foreign-ip")).To(BeFalse())
+		Expect(isApproved("unknown-node")).To(BeFalse())
+	})
+})
@@ -3,7 +3,10 @@ package rke2
 import (
 	"encoding/json"
 	"reflect"
+	"strings"
+	"time"
 
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 
 	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
@@ -19,12 +22,14 @@ import (
 func matchesRCPConfiguration(
 	infraConfigs map[string]*unstructured.Unstructured,
 	machineConfigs map[string]*bootstrapv1.RKE2Config,
+	infraTemplate *unstructured.Unstructured,
 	rcp *controlplanev1.RKE2ControlPlane,
 ) func(machine *clusterv1.Machine) bool {
 	return collections.And(
-		matchesKubernetesVersion(rcp.Spec.AgentConfig.Version),
+		matchesKubernetesVersion(rcp.TargetVersion()),
 		matchesRKE2BootstrapConfig(machineConfigs, rcp),
 		matchesTemplateClonedFrom(infraConfigs, rcp),
+		matchesInfrastructureImage(infraConfigs, infraTemplate, rcp.Spec.InfrastructureImageFieldPath),
 	)
 }
 
@@ -55,7 +60,7 @@ func matchesRKE2BootstrapConfig(machineConfigs map[string]*bootstrapv1.RKE2Confi
 		}
 
 		// Check if RCP AgentConfig and machineBootstrapConfig matches
-		return reflect.DeepEqual(machineConfig.Spec.AgentConfig, rcp.Spec.AgentConfig)
+		return reflect.DeepEqual(machineConfig.Spec.AgentConfig, rcp.TargetAgentConfig())
 	}
 }
 
@@ -77,9 +82,16 @@ func matchServerConfig(rcp *controlplanev1.RKE2ControlPlane, machine *clusterv1.
 		machineServerConfig = &controlplanev1.RKE2ServerConfig{}
 	}
 
-	rcpServerConfig := &rcp.Spec.ServerConfig
-	if rcpServerConfig == nil {
-		rcpServerConfig = &controlplanev1.RKE2ServerConfig{}
+	// The RCP configuration goes through the same serialization as the annotation, so differences that don't survive
+	// it, like empty and nil lists, don't trigger a roll out.
+	rcpServerConfigStr, err := json.Marshal(rcp.Spec.ServerConfig)
+	if err != nil {
+		return false
+	}
+
+	rcpServerConfig := &controlplanev1.RKE2ServerConfig{}
+	if err := json.Unmarshal(rcpServerConfigStr, rcpServerConfig); err != nil {
+		return false
 	}
 
 	// Compare and return
@@ -119,6 +131,46 @@ func matchesTemplateClonedFrom(infraConfigs map[string]*unstructured.Unstructure
 	}
 }
 
+// matchesInfrastructureImage returns a filter to find all machines whose infrastructure machine image matches the image
+// of the infrastructure template. The image field of the infrastructure machine is found at the template field path,
+// without the "spec.template" prefix.
+func matchesInfrastructureImage(
+	infraConfigs map[string]*unstructured.Unstructured,
+	infraTemplate *unstructured.Unstructured,
+	templateFieldPath string,
+) collections.Func {
+	return func(machine *clusterv1.Machine) bool {
+		if machine == nil {
+			return false
+		}
+
+		machineFieldPath := strings.TrimPrefix(templateFieldPath, "spec.template.")
+		if infraTemplate == nil || templateFieldPath == "" || machineFieldPath == templateFieldPath {
+			return true
+		}
+
+		infraObj, found := infraConfigs[machine.Name]
+		if !found {
+			// Return true here because failing to get infrastructure machine should not be considered as unmatching.
+			return true
+		}
+
+		templateImage, found, err := unstructured.NestedFieldNoCopy(infraTemplate.Object, strings.Split(templateFieldPath, ".")...)
+		if err != nil || !found {
+			// The template doesn't define an image, there is nothing to compare with.
+			return true
+		}
+
+		machineImage, found, err := unstructured.NestedFieldNoCopy(infraObj.Object, strings.Split(machineFieldPath, ".")...)
+		if err != nil || !found {
+			// The image may have been defaulted elsewhere by the infrastructure provider, should not be considered as mismatch.
+			return true
+		}
+
+		return reflect.DeepEqual(templateImage, machineImage)
+	}
+}
+
 // matchesKubernetesVersion returns a filter to find all machines that match a given Kubernetes version.
 func matchesKubernetesVersion(kubernetesVersion string) func(*clusterv1.Machine) bool {
 	return func(machine *clusterv1.Machine) bool {
@@ -138,3 +190,20 @@ func matchesKubernetesVersion(kubernetesVersion string) func(*clusterv1.Machine)
 		return bsutil.CompareVersions(*machine.Spec.Version, rcpKubeVersion)
 	}
 }
+
+// shouldRolloutBefore returns a filter to find all machines whose certificates expire within the days of rolloutBefore.
+func shouldRolloutBefore(reconciliationTime *metav1.Time, rolloutBefore *controlplanev1.RolloutBefore) collections.Func {
+	return func(machine *clusterv1.Machine) bool {
+		if rolloutBefore == nil || rolloutBefore.CertificatesExpiryDays == nil {
+			return false
+		}
+
+		if machine == nil || machine.Status.CertificatesExpiryDate == nil {
+			return false
+		}
+
+		window := time.Duration(*rolloutBefore.CertificatesExpiryDays) * 24 * time.Hour
+
+		return reconciliationTime.Add(window).After(machine.Status.CertificatesExpiryDate.Time)
+	}
+}
@@ -1,17 +1,24 @@
 package rke2
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	fuzz "github.com/google/gofuzz"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 
 	corev1 "k8s.io/api/core/v1"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 
 	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
 	"sigs.k8s.io/cluster-api/util/collections"
 
 	bootstrapv1 "github.com/rancher-sandbox/cluster-api-provider-rke2/bootstrap/api/v1alpha1"
 	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+	"github.com/rancher-sandbox/cluster-api-provider-rke2/pkg/fuzzing"
 )
 
 var rcp = controlplanev1.RKE2ControlPlane{
@@ -69,6 +76,39 @@ var _ = Describe("ServerConfigMatching", func() {
 	})
 })
 
+var _ = Describe("ServerConfigMatching fuzzing", func() {
+	It("should match the annotation of machines created from any server configuration", func() {
+		seed, err := fuzzing.Seed()
+		Expect(err).ToNot(HaveOccurred())
+
+		fmt.Fprintf(GinkgoWriter, "Fuzzing with %s=%d\n", fuzzing.SeedEnvVar, seed)
+
+		fuzzer := fuzz.NewWithSeed(seed).NilChance(0.3).NumElements(0, 3)
+
+		for i := 0; i < 500; i++ {
+			fuzzedRCP := &controlplanev1.RKE2ControlPlane{}
+			fuzzer.Fuzz(&fuzzedRCP.Spec.ServerConfig)
+
+			serverConfig, err := json.Marshal(fuzzedRCP.Spec.ServerConfig)
+			Expect(err).ToNot(HaveOccurred())
+
+			fuzzedMachine := &clusterv1.Machine{
+				ObjectMeta: v1.ObjectMeta{
+					Annotations: map[string]string{controlplanev1.RKE2ServerConfigurationAnnotation: string(serverConfig)},
+				},
+			}
+			Expect(matchServerConfig(fuzzedRCP, fuzzedMachine)).To(BeTrue(), "seed %d: %s", seed, serverConfig)
+
+			// Formatting the annotation differently is not a configuration change.
+			indented := &bytes.Buffer{}
+			Expect(json.Indent(indented, serverConfig, "", "  ")).To(Succeed())
+
+			fuzzedMachine.Annotations[controlplanev1.RKE2ServerConfigurationAnnotation] = indented.String()
+			Expect(matchServerConfig(fuzzedRCP, fuzzedMachine)).To(BeTrue(), "seed %d: %s", seed, indented.String())
+		}
+	})
+})
+
 var _ = Describe("matchAgentConfig", func() {
 	It("should match Agent Config", func() {
 		machineConfigs := map[string]*bootstrapv1.RKE2Config{
@@ -103,3 +143,43 @@ var _ = Describe("matching Kubernetes Version", func() {
 		Expect(len(matches)).To(Equal(1))
 	})
 })
+
+var _ = Describe("matching infrastructure image", func() {
+	infraTemplate := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{
+			"template": map[string]interface{}{
+				"spec": map[string]interface{}{
+					"ami": map[string]interface{}{"id": "ami-new"},
+				},
+			},
+		},
+	}}
+
+	infraMachine := func(ami string) map[string]*unstructured.Unstructured {
+		return map[string]*unstructured.Unstructured{
+			machine.Name: {Object: map[string]interface{}{
+				"spec": map[string]interface{}{
+					"ami": map[string]interface{}{"id": ami},
+				},
+			}},
+		}
+	}
+
+	It("should match the template image", func() {
+		machineCollection := collections.FromMachines(&machine)
+		matches := machineCollection.AnyFilter(matchesInfrastructureImage(infraMachine("ami-new"), infraTemplate, "spec.template.spec.ami.id"))
+		Expect(len(matches)).To(Equal(1))
+	})
+
+	It("should not match an outdated image", func() {
+		machineCollection := collections.FromMachines(&machine)
+		matches := machineCollection.AnyFilter(matchesInfrastructureImage(infraMachine("ami-old"), infraTemplate, "spec.template.spec.ami.id"))
+		Expect(matches).To(BeEmpty())
+	})
+
+	It("should match any image when no field path is set", func() {
+		machineCollection := collections.FromMachines(&machine)
+		matches := machineCollection.AnyFilter(matchesInfrastructureImage(infraMachine("ami-old"), infraTemplate, ""))
+		Expect(len(matches)).To(Equal(1))
+	})
+})
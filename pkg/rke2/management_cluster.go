@@ -17,22 +17,42 @@ limitations under the License.
 package rke2
 
 import (
+	"bytes"
 	"context"
+	"net"
+	"sync"
 	"time"
 
 	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/discovery"
 	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
 	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 
 	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
 	"sigs.k8s.io/cluster-api/controllers/remote"
+	"sigs.k8s.io/cluster-api/util/certs"
 	"sigs.k8s.io/cluster-api/util/collections"
+
+	"github.com/rancher-sandbox/cluster-api-provider-rke2/pkg/observer"
+	"github.com/rancher-sandbox/cluster-api-provider-rke2/pkg/secret"
 )
 
 const (
 	// DefaultWorkloadTimeout is the default timeout for the management cluster.
 	DefaultWorkloadTimeout = 30 * time.Second
+
+	// DefaultWorkloadDialTimeout is the default timeout for establishing a connection to a workload cluster.
+	DefaultWorkloadDialTimeout = 10 * time.Second
+
+	// DefaultWorkloadHealthCheckInterval is the default interval between two health checks of a workload cluster.
+	DefaultWorkloadHealthCheckInterval = 30 * time.Second
+
+	// DefaultWorkloadHealthCheckTimeout is the default timeout for the health check of a workload cluster.
+	DefaultWorkloadHealthCheckTimeout = 5 * time.Second
 )
 
 // ManagementCluster defines all behaviors necessary for something to function as a management cluster.
@@ -41,11 +61,66 @@ type ManagementCluster interface {
 
 	GetMachinesForCluster(ctx context.Context, cluster ctrlclient.ObjectKey, filters ...collections.Func) (collections.Machines, error)
 	GetWorkloadCluster(ctx context.Context, clusterKey ctrlclient.ObjectKey) (WorkloadCluster, error)
+	ForgetWorkloadCluster(clusterKey ctrlclient.ObjectKey)
+}
+
+// WorkloadClientOptions configures the clients of the workload clusters, the zero values are replaced by the
+// defaults.
+type WorkloadClientOptions struct {
+	// Timeout is the timeout of the requests to a workload cluster.
+	Timeout time.Duration
+
+	// DialTimeout is the timeout for establishing a connection to a workload cluster.
+	DialTimeout time.Duration
+
+	// QPS is the maximum queries per second to a workload cluster, the client-go default is used when zero.
+	QPS float32
+
+	// Burst is the maximum burst of queries to a workload cluster, the client-go default is used when zero.
+	Burst int
+
+	// HealthCheckInterval is the interval between two health checks of a workload cluster. The client of a healthy
+	// cluster is reused in between, an unhealthy cluster isn't contacted again until the next health check.
+	HealthCheckInterval time.Duration
+
+	// HealthCheckTimeout is the timeout for the health check of a workload cluster.
+	HealthCheckTimeout time.Duration
+
+	// Observer makes the clients of the workload clusters log the writes instead of making them.
+	Observer bool
+
+	// HostJobImage is the image of the jobs running commands on the hosts of the workload cluster nodes, it needs
+	// nsenter. DefaultHostJobImage is used when empty.
+	HostJobImage string
 }
 
 // Management holds operations on the management cluster.
 type Management struct {
 	Client ctrlclient.Reader
+
+	// WorkloadClientOptions configures the clients of the workload clusters.
+	WorkloadClientOptions WorkloadClientOptions
+
+	lock               sync.Mutex
+	workloads          map[ctrlclient.ObjectKey]*workloadClient
+	etcdClients        map[ctrlclient.ObjectKey]*workloadEtcdClient
+	pendingEtcdMembers map[ctrlclient.ObjectKey]*pendingEtcdMembers
+}
+
+// workloadClient is the client of a workload cluster and the result of its last health check.
+type workloadClient struct {
+	restConfig *rest.Config
+	client     ctrlclient.Client
+	checkedAt  time.Time
+	err        error
+}
+
+// workloadEtcdClient is the etcd client of a workload cluster, along with the certificate authority that signed its
+// client certificate and the expiry of the certificate.
+type workloadEtcdClient struct {
+	client   EtcdClient
+	caCert   []byte
+	notAfter time.Time
 }
 
 // RemoteClusterConnectionError represents a failure to connect to a remote cluster.
@@ -100,20 +175,246 @@ const (
 
 // GetWorkloadCluster builds a cluster object.
 // The cluster comes with an etcd client generator to connect to any etcd pod living on a managed machine.
+// The client of a cluster is reused until its next health check or until its kubeconfig changes, and a cluster that
+// failed its health check returns an error without being contacted until the next health check, so that unreachable
+// workload clusters don't slow down the reconciliation of the others.
 func (m *Management) GetWorkloadCluster(ctx context.Context, clusterKey ctrlclient.ObjectKey) (WorkloadCluster, error) {
 	restConfig, err := remote.RESTConfig(ctx, RKE2ControlPlaneControllerName, m.Client, clusterKey)
 	if err != nil {
+		// The kubeconfig is deleted with the cluster.
+		if apierrors.IsNotFound(errors.Cause(err)) {
+			m.ForgetWorkloadCluster(clusterKey)
+		}
+
 		return nil, err
 	}
 
-	restConfig.Timeout = DefaultWorkloadTimeout
+	opts := m.WorkloadClientOptions.withDefaults()
 
-	c, err := ctrlclient.New(restConfig, ctrlclient.Options{Scheme: scheme.Scheme})
-	if err != nil {
-		return nil, &RemoteClusterConnectionError{Name: clusterKey.String(), Err: err}
+	m.lock.Lock()
+	cached := m.workloads[clusterKey]
+	m.lock.Unlock()
+
+	if cached == nil || !sameRESTConfig(cached.restConfig, restConfig) {
+		cached = &workloadClient{restConfig: rest.CopyConfig(restConfig)}
+	} else if time.Since(cached.checkedAt) < opts.HealthCheckInterval {
+		if cached.err != nil {
+			return nil, &RemoteClusterConnectionError{Name: clusterKey.String(), Err: cached.err}
+		}
+
+		return &Workload{
+			Client:             cached.client,
+			EtcdClient:         m.getEtcdClient(ctx, clusterKey),
+			HostJobImage:       opts.HostJobImage,
+			pendingEtcdMembers: m.getPendingEtcdMembers(clusterKey),
+		}, nil
+	}
+
+	opts.apply(restConfig)
+
+	checked := &workloadClient{
+		restConfig: cached.restConfig,
+		client:     cached.client,
+		checkedAt:  time.Now(),
+		err:        checkHealth(ctx, restConfig, opts.HealthCheckTimeout),
+	}
+
+	if checked.err == nil && checked.client == nil {
+		checked.client, checked.err = newWorkloadClient(restConfig)
+		if checked.err == nil && opts.Observer {
+			checked.client = observer.NewClient(checked.client)
+		}
+	}
+
+	if checked.err != nil {
+		checked.client = nil
+	}
+
+	m.lock.Lock()
+	if m.workloads == nil {
+		m.workloads = map[ctrlclient.ObjectKey]*workloadClient{}
+	}
+
+	m.workloads[clusterKey] = checked
+	m.lock.Unlock()
+
+	if checked.err != nil {
+		return nil, &RemoteClusterConnectionError{Name: clusterKey.String(), Err: checked.err}
 	}
 
 	return &Workload{
-		Client: c,
+		Client:             checked.client,
+		EtcdClient:         m.getEtcdClient(ctx, clusterKey),
+		HostJobImage:       opts.HostJobImage,
+		pendingEtcdMembers: m.getPendingEtcdMembers(clusterKey),
 	}, nil
 }
+
+// ForgetWorkloadCluster drops the clients and the pending etcd members of a workload cluster, once it is deleted.
+func (m *Management) ForgetWorkloadCluster(clusterKey ctrlclient.ObjectKey) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	delete(m.workloads, clusterKey)
+	delete(m.etcdClients, clusterKey)
+	delete(m.pendingEtcdMembers, clusterKey)
+}
+
+// getPendingEtcdMembers returns the pending etcd members of a workload cluster, they are kept until the workload
+// cluster is forgotten.
+func (m *Management) getPendingEtcdMembers(clusterKey ctrlclient.ObjectKey) *pendingEtcdMembers {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.pendingEtcdMembers == nil {
+		m.pendingEtcdMembers = map[ctrlclient.ObjectKey]*pendingEtcdMembers{}
+	}
+
+	if m.pendingEtcdMembers[clusterKey] == nil {
+		m.pendingEtcdMembers[clusterKey] = &pendingEtcdMembers{}
+	}
+
+	return m.pendingEtcdMembers[clusterKey]
+}
+
+// getEtcdClient returns the etcd client of a workload cluster, or nil when the etcd certificate authority is not
+// provided by the management cluster. The client is reused until the certificate authority changes or its client
+// certificate is about to expire.
+func (m *Management) getEtcdClient(ctx context.Context, clusterKey ctrlclient.ObjectKey) EtcdClient {
+	logger := log.FromContext(ctx)
+
+	caSecret, err := secret.GetFromNamespacedName(ctx, m.Client, clusterKey, secret.EtcdCA)
+	if err != nil {
+		if !apierrors.IsNotFound(err) {
+			logger.Info("Failed to look up the etcd certificate authority", "error", err.Error())
+		}
+
+		return nil
+	}
+
+	etcdCA := secret.NewEtcdCACertificate()
+	etcdCA.KeyPair = &certs.KeyPair{
+		Cert: caSecret.Data[secret.TLSCrtDataName],
+		Key:  caSecret.Data[secret.TLSKeyDataName],
+	}
+
+	m.lock.Lock()
+	cached := m.etcdClients[clusterKey]
+	m.lock.Unlock()
+
+	if cached != nil && bytes.Equal(cached.caCert, etcdCA.KeyPair.Cert) &&
+		time.Until(cached.notAfter) > certificateRenewalThreshold {
+		return cached.client
+	}
+
+	tlsConfig, notAfter, err := NewEtcdTLSConfig(etcdCA)
+	if err != nil {
+		logger.Info("Failed to create the etcd client certificate", "error", err.Error())
+
+		return nil
+	}
+
+	opts := m.WorkloadClientOptions.withDefaults()
+	etcdClient := NewEtcdClient(tlsConfig, opts.HealthCheckTimeout, m.etcdDialer(clusterKey, opts.DialTimeout))
+	if opts.Observer {
+		etcdClient = &observerEtcdClient{EtcdClient: etcdClient}
+	}
+
+	cached = &workloadEtcdClient{
+		client:   etcdClient,
+		caCert:   etcdCA.KeyPair.Cert,
+		notAfter: notAfter,
+	}
+
+	m.lock.Lock()
+	if m.etcdClients == nil {
+		m.etcdClients = map[ctrlclient.ObjectKey]*workloadEtcdClient{}
+	}
+
+	m.etcdClients[clusterKey] = cached
+	m.lock.Unlock()
+
+	return cached.client
+}
+
+// etcdDialer returns the DialFunc of the etcd client of a workload cluster, connecting through the API server with the
+// current client of the workload cluster.
+func (m *Management) etcdDialer(clusterKey ctrlclient.ObjectKey, dialTimeout time.Duration) DialFunc {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		m.lock.Lock()
+		workload := m.workloads[clusterKey]
+		m.lock.Unlock()
+
+		if workload == nil || workload.client == nil {
+			return nil, errors.Errorf("workload cluster %s is not connected", clusterKey)
+		}
+
+		return NewEtcdPortForwardDialer(workload.restConfig, workload.client, dialTimeout)(ctx, network, address)
+	}
+}
+
+// withDefaults returns the options with the defaults in place of the zero values.
+func (o WorkloadClientOptions) withDefaults() WorkloadClientOptions {
+	if o.Timeout <= 0 {
+		o.Timeout = DefaultWorkloadTimeout
+	}
+
+	if o.DialTimeout <= 0 {
+		o.DialTimeout = DefaultWorkloadDialTimeout
+	}
+
+	if o.HealthCheckInterval <= 0 {
+		o.HealthCheckInterval = DefaultWorkloadHealthCheckInterval
+	}
+
+	if o.HealthCheckTimeout <= 0 {
+		o.HealthCheckTimeout = DefaultWorkloadHealthCheckTimeout
+	}
+
+	return o
+}
+
+// apply sets the timeouts and rate limits of the options on a rest config.
+func (o WorkloadClientOptions) apply(restConfig *rest.Config) {
+	restConfig.Timeout = o.Timeout
+	restConfig.QPS = o.QPS
+	restConfig.Burst = o.Burst
+	restConfig.Dial = (&net.Dialer{Timeout: o.DialTimeout, KeepAlive: 30 * time.Second}).DialContext
+}
+
+// sameRESTConfig returns whether two rest configs of a workload cluster connect to the same endpoint with the same
+// credentials.
+func sameRESTConfig(a, b *rest.Config) bool {
+	return a.Host == b.Host &&
+		a.BearerToken == b.BearerToken &&
+		bytes.Equal(a.CAData, b.CAData) &&
+		bytes.Equal(a.CertData, b.CertData) &&
+		bytes.Equal(a.KeyData, b.KeyData)
+}
+
+// checkHealth checks the readiness of the API server of a workload cluster.
+func checkHealth(ctx context.Context, restConfig *rest.Config, timeout time.Duration) error {
+	healthConfig := rest.CopyConfig(restConfig)
+	healthConfig.Timeout = timeout
+
+	client, err := discovery.NewDiscoveryClientForConfig(healthConfig)
+	if err != nil {
+		return errors.Wrap(err, "failed to create the health check client")
+	}
+
+	if err := client.RESTClient().Get().AbsPath("/readyz").Do(ctx).Error(); err != nil {
+		return errors.Wrap(err, "workload cluster API server is not ready")
+	}
+
+	return nil
+}
+
+// newWorkloadClient creates the client of a workload cluster, the REST mappings are discovered when first needed.
+func newWorkloadClient(restConfig *rest.Config) (ctrlclient.Client, error) {
+	mapper, err := apiutil.NewDynamicRESTMapper(restConfig, apiutil.WithLazyDiscovery)
+	if err != nil {
+		return nil, err
+	}
+
+	return ctrlclient.New(restConfig, ctrlclient.Options{Scheme: scheme.Scheme, Mapper: mapper})
+}
@@ -0,0 +1,140 @@
+/*
+Copyright 2023 SUSE.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rke2
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("GetWorkloadCluster", func() {
+	var (
+		server     *httptest.Server
+		healthy    atomic.Bool
+		checks     atomic.Int32
+		management *Management
+		clusterKey = ctrlclient.ObjectKey{Namespace: "default", Name: "test"}
+	)
+
+	BeforeEach(func() {
+		healthy.Store(true)
+		checks.Store(0)
+
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/readyz" {
+				checks.Add(1)
+
+				if !healthy.Load() {
+					w.WriteHeader(http.StatusInternalServerError)
+
+					return
+				}
+			}
+
+			_, _ = w.Write([]byte("ok"))
+		}))
+
+		kubeconfig := fmt.Sprintf(`apiVersion: v1
+kind: Config
+clusters:
+- name: test
+  cluster:
+    server: %s
+contexts:
+- name: test
+  context:
+    cluster: test
+    user: test
+current-context: test
+users:
+- name: test
+  user:
+    token: token
+`, server.URL)
+
+		management = &Management{
+			Client: fake.NewClientBuilder().WithObjects(&corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Namespace: clusterKey.Namespace, Name: clusterKey.Name + "-kubeconfig"},
+				Data:       map[string][]byte{"value": []byte(kubeconfig)},
+			}).Build(),
+			WorkloadClientOptions: WorkloadClientOptions{HealthCheckInterval: time.Hour},
+		}
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("should reuse the client until the next health check", func() {
+		first, err := management.GetWorkloadCluster(context.Background(), clusterKey)
+		Expect(err).ToNot(HaveOccurred())
+
+		second, err := management.GetWorkloadCluster(context.Background(), clusterKey)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(second.(*Workload).Client).To(BeIdenticalTo(first.(*Workload).Client))
+		Expect(checks.Load()).To(BeEquivalentTo(1))
+	})
+
+	It("should not contact an unhealthy cluster until the next health check", func() {
+		healthy.Store(false)
+
+		_, err := management.GetWorkloadCluster(context.Background(), clusterKey)
+		Expect(err).To(BeAssignableToTypeOf(&RemoteClusterConnectionError{}))
+
+		_, err = management.GetWorkloadCluster(context.Background(), clusterKey)
+		Expect(err).To(BeAssignableToTypeOf(&RemoteClusterConnectionError{}))
+		Expect(checks.Load()).To(BeEquivalentTo(1))
+
+		healthy.Store(true)
+		management.workloads[clusterKey].checkedAt = time.Time{}
+
+		_, err = management.GetWorkloadCluster(context.Background(), clusterKey)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(checks.Load()).To(BeEquivalentTo(2))
+	})
+
+	It("should forget a workload cluster once its kubeconfig is deleted", func() {
+		_, err := management.GetWorkloadCluster(context.Background(), clusterKey)
+		Expect(err).ToNot(HaveOccurred())
+
+		management.etcdClients = map[ctrlclient.ObjectKey]*workloadEtcdClient{clusterKey: {}}
+		Expect(management.workloads).To(HaveKey(clusterKey))
+		Expect(management.pendingEtcdMembers).To(HaveKey(clusterKey))
+
+		Expect(management.Client.(ctrlclient.Client).Delete(context.Background(), &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: clusterKey.Namespace, Name: clusterKey.Name + "-kubeconfig"},
+		})).To(Succeed())
+
+		_, err = management.GetWorkloadCluster(context.Background(), clusterKey)
+		Expect(err).To(HaveOccurred())
+
+		Expect(management.workloads).ToNot(HaveKey(clusterKey))
+		Expect(management.etcdClients).ToNot(HaveKey(clusterKey))
+		Expect(management.pendingEtcdMembers).ToNot(HaveKey(clusterKey))
+	})
+})
@@ -0,0 +1,420 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rke2
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	"sigs.k8s.io/cluster-api/util/certs"
+
+	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+	"github.com/rancher-sandbox/cluster-api-provider-rke2/pkg/secret"
+)
+
+const (
+	// ControlPlaneMetricsName is the name of the secret, and of the RBAC resources, created in the workload cluster
+	// to scrape the control plane components. It is also the common name of the client certificates.
+	ControlPlaneMetricsName = "rke2-control-plane-metrics"
+
+	// DefaultControlPlaneMetricsNamespace is the default namespace of the control plane metrics secret.
+	DefaultControlPlaneMetricsNamespace = "kube-system"
+
+	// ScrapeConfigKey is the key of the control plane metrics secret holding the prometheus scrape configuration.
+	ScrapeConfigKey = "scrape-config.yaml"
+
+	// EtcdCACertKey is the key of the control plane metrics secret holding the ETCD CA certificate.
+	EtcdCACertKey = "etcd-ca.crt"
+
+	// EtcdClientCertKey is the key of the control plane metrics secret holding the ETCD client certificate.
+	EtcdClientCertKey = "etcd-client.crt"
+
+	// EtcdClientKeyKey is the key of the control plane metrics secret holding the ETCD client key.
+	EtcdClientKeyKey = "etcd-client.key"
+
+	// etcdClientSecretLabel is the label of the secrets of the workload cluster the etcd client certificate is exported
+	// to, the secrets without it are not updated nor deleted.
+	etcdClientSecretLabel = "controlplane.cluster.x-k8s.io/etcd-client-secret"
+
+	// certificateRenewalThreshold is the remaining validity under which the client certificates are renewed.
+	certificateRenewalThreshold = 30 * 24 * time.Hour
+
+	// scrapeConfig is the prometheus scrape configuration of the control plane components, it expects the secret to be
+	// mounted under /etc/prometheus/secrets/<secret name>, as done by the prometheus operator for the secrets listed in
+	// the Prometheus resource. The Kube Controller Manager and Kube Scheduler serving certificates do not include the
+	// node addresses, so their verification is skipped. The IPv6 node addresses are enclosed in brackets.
+	scrapeConfig = `{{ define "address" }}  - source_labels: [__meta_kubernetes_node_address_{{ .AddressType }}]
+    regex: "([^:]+)"
+    target_label: __address__
+    replacement: $1:{{ .Port }}
+  - source_labels: [__meta_kubernetes_node_address_{{ .AddressType }}]
+    regex: "(.+:.+)"
+    target_label: __address__
+    replacement: "[$1]:{{ .Port }}"
+{{ end -}}
+- job_name: rke2-kube-apiserver
+  scheme: https
+  tls_config:
+    ca_file: /etc/prometheus/secrets/{{ .Name }}/ca.crt
+    cert_file: /etc/prometheus/secrets/{{ .Name }}/tls.crt
+    key_file: /etc/prometheus/secrets/{{ .Name }}/tls.key
+  kubernetes_sd_configs:
+  - role: node
+  relabel_configs:
+  - source_labels: [__meta_kubernetes_node_label_node_role_kubernetes_io_master]
+    regex: "true"
+    action: keep
+{{ template "address" .KubeAPIServer -}}
+- job_name: rke2-kube-controller-manager
+  scheme: https
+  tls_config:
+    cert_file: /etc/prometheus/secrets/{{ .Name }}/tls.crt
+    key_file: /etc/prometheus/secrets/{{ .Name }}/tls.key
+    insecure_skip_verify: true
+  kubernetes_sd_configs:
+  - role: node
+  relabel_configs:
+  - source_labels: [__meta_kubernetes_node_label_node_role_kubernetes_io_master]
+    regex: "true"
+    action: keep
+{{ template "address" .KubeControllerManager -}}
+- job_name: rke2-kube-scheduler
+  scheme: https
+  tls_config:
+    cert_file: /etc/prometheus/secrets/{{ .Name }}/tls.crt
+    key_file: /etc/prometheus/secrets/{{ .Name }}/tls.key
+    insecure_skip_verify: true
+  kubernetes_sd_configs:
+  - role: node
+  relabel_configs:
+  - source_labels: [__meta_kubernetes_node_label_node_role_kubernetes_io_master]
+    regex: "true"
+    action: keep
+{{ template "address" .KubeScheduler -}}
+{{ if .Etcd -}}
+- job_name: rke2-etcd
+  scheme: https
+  tls_config:
+    ca_file: /etc/prometheus/secrets/{{ .Name }}/etcd-ca.crt
+    cert_file: /etc/prometheus/secrets/{{ .Name }}/etcd-client.crt
+    key_file: /etc/prometheus/secrets/{{ .Name }}/etcd-client.key
+    server_name: localhost
+  kubernetes_sd_configs:
+  - role: node
+  relabel_configs:
+  - source_labels: [__meta_kubernetes_node_label_node_role_kubernetes_io_etcd]
+    regex: "true"
+    action: keep
+{{ template "address" .Etcd -}}
+{{ end -}}
+`
+)
+
+// UpdateControlPlaneMetrics creates or updates the RBAC resources granting access to the metrics of the control plane
+// components, then the secret holding the scrape configuration and the client certificates needed to scrape them.
+// The secret is created in the SecretNamespace of the metrics, and the components are scraped on the node addresses of
+// their AddressType.
+// The client certificates are only renewed when they are about to expire or when a certificate authority changed.
+// The etcd client certificate is exported to the EtcdClientSecrets of the metrics as well, the secrets it was exported
+// to before and that were removed from the EtcdClientSecrets are deleted. Etcd is only scraped when the certificates
+// include the etcd CA, the servers don't run etcd with an external datastore.
+func (w *Workload) UpdateControlPlaneMetrics(
+	ctx context.Context,
+	metrics controlplanev1.ControlPlaneMetrics,
+	certificates secret.Certificates,
+) error {
+	clusterCA := certificates.GetByPurpose(secret.ClusterCA)
+	clientCA := certificates.GetByPurpose(secret.ClientClusterCA)
+	etcdCA := certificates.GetByPurpose(secret.EtcdCA)
+
+	if clusterCA == nil || clientCA == nil {
+		return errors.New("the cluster and client certificate authorities are required to scrape the control plane")
+	}
+
+	clusterRole := &rbacv1.ClusterRole{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: ControlPlaneMetricsName,
+		},
+	}
+
+	if _, err := controllerutil.CreateOrUpdate(ctx, w.Client, clusterRole, func() error {
+		clusterRole.Rules = []rbacv1.PolicyRule{
+			{
+				NonResourceURLs: []string{"/metrics"},
+				Verbs:           []string{"get"},
+			},
+		}
+
+		return nil
+	}); err != nil {
+		return errors.Wrapf(err, "failed to update cluster role %s", ControlPlaneMetricsName)
+	}
+
+	clusterRoleBinding := &rbacv1.ClusterRoleBinding{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: ControlPlaneMetricsName,
+		},
+	}
+
+	if _, err := controllerutil.CreateOrUpdate(ctx, w.Client, clusterRoleBinding, func() error {
+		clusterRoleBinding.RoleRef = rbacv1.RoleRef{
+			APIGroup: rbacv1.GroupName,
+			Kind:     "ClusterRole",
+			Name:     ControlPlaneMetricsName,
+		}
+		clusterRoleBinding.Subjects = []rbacv1.Subject{
+			{
+				APIGroup: rbacv1.GroupName,
+				Kind:     rbacv1.UserKind,
+				Name:     ControlPlaneMetricsName,
+			},
+		}
+
+		return nil
+	}); err != nil {
+		return errors.Wrapf(err, "failed to update cluster role binding %s", ControlPlaneMetricsName)
+	}
+
+	namespace := metrics.SecretNamespace
+	if namespace == "" {
+		namespace = DefaultControlPlaneMetricsNamespace
+	}
+
+	metricsSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      ControlPlaneMetricsName,
+			Namespace: namespace,
+		},
+	}
+
+	if _, err := controllerutil.CreateOrUpdate(ctx, w.Client, metricsSecret, func() error {
+		if metricsSecret.Data == nil {
+			metricsSecret.Data = map[string][]byte{}
+		}
+
+		scrapeConfig, err := renderScrapeConfig(metrics, etcdCA != nil)
+		if err != nil {
+			return err
+		}
+
+		metricsSecret.Type = corev1.SecretTypeOpaque
+		metricsSecret.Data[ScrapeConfigKey] = scrapeConfig
+		metricsSecret.Data[corev1.ServiceAccountRootCAKey] = clusterCA.KeyPair.Cert
+
+		if needsClientCertificate(metricsSecret.Data[corev1.TLSCertKey], clientCA) {
+			keyPair, err := clientCA.NewClientCertificate(ControlPlaneMetricsName, nil)
+			if err != nil {
+				return errors.Wrap(err, "failed to generate the control plane metrics client certificate")
+			}
+
+			metricsSecret.Data[corev1.TLSCertKey] = keyPair.Cert
+			metricsSecret.Data[corev1.TLSPrivateKeyKey] = keyPair.Key
+		}
+
+		if etcdCA == nil {
+			return nil
+		}
+
+		metricsSecret.Data[EtcdCACertKey] = etcdCA.KeyPair.Cert
+
+		if needsClientCertificate(metricsSecret.Data[EtcdClientCertKey], etcdCA) {
+			keyPair, err := etcdCA.NewClientCertificate(ControlPlaneMetricsName, nil)
+			if err != nil {
+				return errors.Wrap(err, "failed to generate the etcd metrics client certificate")
+			}
+
+			metricsSecret.Data[EtcdClientCertKey] = keyPair.Cert
+			metricsSecret.Data[EtcdClientKeyKey] = keyPair.Key
+		}
+
+		return nil
+	}); err != nil {
+		return errors.Wrapf(err, "failed to update secret %s/%s", namespace, ControlPlaneMetricsName)
+	}
+
+	if etcdCA == nil {
+		return nil
+	}
+
+	for _, etcdClientSecret := range metrics.EtcdClientSecrets {
+		if err := w.updateEtcdClientSecret(ctx, etcdClientSecret, etcdCA); err != nil {
+			return err
+		}
+	}
+
+	return w.pruneEtcdClientSecrets(ctx, metrics.EtcdClientSecrets)
+}
+
+// updateEtcdClientSecret creates or updates a TLS secret holding the etcd CA certificate and an etcd client
+// certificate, renewed when it is about to expire or when the etcd CA changed. The secrets that were not created by
+// the controller are left untouched, an error is returned instead.
+func (w *Workload) updateEtcdClientSecret(
+	ctx context.Context,
+	etcdClientSecret controlplanev1.EtcdClientSecret,
+	etcdCA *secret.Certificate,
+) error {
+	clientSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      etcdClientSecret.Name,
+			Namespace: etcdClientSecret.Namespace,
+		},
+	}
+
+	if _, err := controllerutil.CreateOrUpdate(ctx, w.Client, clientSecret, func() error {
+		if clientSecret.ResourceVersion == "" {
+			clientSecret.Type = corev1.SecretTypeTLS
+		} else if _, ok := clientSecret.Labels[etcdClientSecretLabel]; !ok {
+			return errors.New("the secret exists and was not created by the control plane, another name must be used")
+		}
+
+		if clientSecret.Labels == nil {
+			clientSecret.Labels = map[string]string{}
+		}
+
+		if clientSecret.Data == nil {
+			clientSecret.Data = map[string][]byte{}
+		}
+
+		clientSecret.Labels[etcdClientSecretLabel] = ""
+		clientSecret.Data[corev1.ServiceAccountRootCAKey] = etcdCA.KeyPair.Cert
+
+		if needsClientCertificate(clientSecret.Data[corev1.TLSCertKey], etcdCA) {
+			keyPair, err := etcdCA.NewClientCertificate(ControlPlaneMetricsName, nil)
+			if err != nil {
+				return errors.Wrap(err, "failed to generate the etcd client certificate")
+			}
+
+			clientSecret.Data[corev1.TLSCertKey] = keyPair.Cert
+			clientSecret.Data[corev1.TLSPrivateKeyKey] = keyPair.Key
+		}
+
+		return nil
+	}); err != nil {
+		return errors.Wrapf(err, "failed to update secret %s/%s", etcdClientSecret.Namespace, etcdClientSecret.Name)
+	}
+
+	return nil
+}
+
+// pruneEtcdClientSecrets deletes the secrets the etcd client certificate was exported to, that are not listed anymore.
+func (w *Workload) pruneEtcdClientSecrets(ctx context.Context, etcdClientSecrets []controlplanev1.EtcdClientSecret) error {
+	secrets := &corev1.SecretList{}
+	if err := w.Client.List(ctx, secrets, client.HasLabels{etcdClientSecretLabel}); err != nil {
+		return errors.Wrap(err, "failed to list the etcd client secrets")
+	}
+
+	for i := range secrets.Items {
+		clientSecret := &secrets.Items[i]
+
+		listed := false
+
+		for _, etcdClientSecret := range etcdClientSecrets {
+			if etcdClientSecret.Namespace == clientSecret.Namespace && etcdClientSecret.Name == clientSecret.Name {
+				listed = true
+
+				break
+			}
+		}
+
+		if listed {
+			continue
+		}
+
+		if err := w.Client.Delete(ctx, clientSecret); err != nil && !apierrors.IsNotFound(err) {
+			return errors.Wrapf(err, "failed to delete secret %s/%s", clientSecret.Namespace, clientSecret.Name)
+		}
+	}
+
+	return nil
+}
+
+// needsClientCertificate returns true if the client certificate is missing, about to expire or not signed by the
+// certificate authority.
+func needsClientCertificate(encodedCert []byte, ca *secret.Certificate) bool {
+	if len(encodedCert) == 0 {
+		return true
+	}
+
+	cert, err := certs.DecodeCertPEM(encodedCert)
+	if err != nil || cert == nil {
+		return true
+	}
+
+	caCert, err := certs.DecodeCertPEM(ca.KeyPair.Cert)
+	if err != nil || caCert == nil {
+		return true
+	}
+
+	if err := cert.CheckSignatureFrom(caCert); err != nil {
+		return true
+	}
+
+	return time.Until(cert.NotAfter) < certificateRenewalThreshold
+}
+
+// scrapeTarget is the node address type and the port a control plane component is scraped on.
+type scrapeTarget struct {
+	AddressType corev1.NodeAddressType
+	Port        int32
+}
+
+func renderScrapeConfig(metrics controlplanev1.ControlPlaneMetrics, scrapeEtcd bool) ([]byte, error) {
+	tmpl, err := template.New("scrape-config").Parse(scrapeConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse scrape config template: %w", err)
+	}
+
+	addressType := metrics.AddressType
+	if addressType == "" {
+		addressType = corev1.NodeInternalIP
+	}
+
+	apiServerPort := metrics.APIServerPort
+	if apiServerPort == 0 {
+		apiServerPort = KubeAPIServerSecurePort
+	}
+
+	var etcd *scrapeTarget
+	if scrapeEtcd {
+		etcd = &scrapeTarget{AddressType: addressType, Port: EtcdMetricsPort}
+	}
+
+	var out bytes.Buffer
+
+	if err := tmpl.Execute(&out, map[string]interface{}{
+		"Name":                  ControlPlaneMetricsName,
+		"KubeAPIServer":         scrapeTarget{AddressType: addressType, Port: apiServerPort},
+		"KubeControllerManager": scrapeTarget{AddressType: addressType, Port: KubeControllerManagerSecurePort},
+		"KubeScheduler":         scrapeTarget{AddressType: addressType, Port: KubeSchedulerSecurePort},
+		"Etcd":                  etcd,
+	}); err != nil {
+		return nil, fmt.Errorf("failed to render scrape config: %w", err)
+	}
+
+	return out.Bytes(), nil
+}
@@ -0,0 +1,190 @@
+/*
+Copyright 2023 SUSE.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rke2
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/yaml"
+
+	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+	"github.com/rancher-sandbox/cluster-api-provider-rke2/pkg/secret"
+)
+
+var _ = Describe("UpdateControlPlaneMetrics", func() {
+	var (
+		certificates secret.Certificates
+		workload     *Workload
+	)
+
+	BeforeEach(func() {
+		certificates = append(secret.NewCertificatesForInitialControlPlane(), secret.NewEtcdCACertificate())
+		Expect(certificates.Generate()).To(Succeed())
+
+		workload = &Workload{
+			Client: fake.NewClientBuilder().Build(),
+		}
+	})
+
+	It("should create the metrics secret and RBAC resources", func() {
+		Expect(workload.UpdateControlPlaneMetrics(context.Background(), controlplanev1.ControlPlaneMetrics{}, certificates)).To(Succeed())
+
+		metricsSecret := &corev1.Secret{}
+		Expect(workload.Client.Get(context.Background(), types.NamespacedName{
+			Name:      ControlPlaneMetricsName,
+			Namespace: DefaultControlPlaneMetricsNamespace,
+		}, metricsSecret)).To(Succeed())
+
+		Expect(metricsSecret.Data).To(HaveKey(ScrapeConfigKey))
+		Expect(metricsSecret.Data[corev1.ServiceAccountRootCAKey]).To(Equal(certificates.GetByPurpose(secret.ClusterCA).KeyPair.Cert))
+		Expect(metricsSecret.Data[EtcdCACertKey]).To(Equal(certificates.GetByPurpose(secret.EtcdCA).KeyPair.Cert))
+		Expect(needsClientCertificate(metricsSecret.Data[corev1.TLSCertKey], certificates.GetByPurpose(secret.ClientClusterCA))).To(BeFalse())
+		Expect(needsClientCertificate(metricsSecret.Data[EtcdClientCertKey], certificates.GetByPurpose(secret.EtcdCA))).To(BeFalse())
+
+		Expect(workload.Client.Get(context.Background(), types.NamespacedName{Name: ControlPlaneMetricsName}, &rbacv1.ClusterRole{})).To(Succeed())
+		Expect(workload.Client.Get(context.Background(), types.NamespacedName{Name: ControlPlaneMetricsName}, &rbacv1.ClusterRoleBinding{})).To(Succeed())
+	})
+
+	It("should keep the client certificates while they are valid", func() {
+		metrics := controlplanev1.ControlPlaneMetrics{SecretNamespace: "monitoring"}
+		Expect(workload.UpdateControlPlaneMetrics(context.Background(), metrics, certificates)).To(Succeed())
+
+		key := types.NamespacedName{Name: ControlPlaneMetricsName, Namespace: "monitoring"}
+		firstSecret := &corev1.Secret{}
+		Expect(workload.Client.Get(context.Background(), key, firstSecret)).To(Succeed())
+
+		Expect(workload.UpdateControlPlaneMetrics(context.Background(), metrics, certificates)).To(Succeed())
+
+		secondSecret := &corev1.Secret{}
+		Expect(workload.Client.Get(context.Background(), key, secondSecret)).To(Succeed())
+		Expect(secondSecret.Data[corev1.TLSCertKey]).To(Equal(firstSecret.Data[corev1.TLSCertKey]))
+		Expect(secondSecret.Data[EtcdClientCertKey]).To(Equal(firstSecret.Data[EtcdClientCertKey]))
+	})
+
+	It("should export the etcd client certificate and renew it with the etcd CA", func() {
+		metrics := controlplanev1.ControlPlaneMetrics{
+			EtcdClientSecrets: []controlplanev1.EtcdClientSecret{{Name: "etcd-client", Namespace: "monitoring"}},
+		}
+		Expect(workload.UpdateControlPlaneMetrics(context.Background(), metrics, certificates)).To(Succeed())
+
+		key := types.NamespacedName{Name: "etcd-client", Namespace: "monitoring"}
+		clientSecret := &corev1.Secret{}
+		Expect(workload.Client.Get(context.Background(), key, clientSecret)).To(Succeed())
+		Expect(clientSecret.Type).To(Equal(corev1.SecretTypeTLS))
+		Expect(clientSecret.Data[corev1.ServiceAccountRootCAKey]).To(Equal(certificates.GetByPurpose(secret.EtcdCA).KeyPair.Cert))
+		Expect(needsClientCertificate(clientSecret.Data[corev1.TLSCertKey], certificates.GetByPurpose(secret.EtcdCA))).To(BeFalse())
+
+		rotated := append(secret.NewCertificatesForInitialControlPlane(), secret.NewEtcdCACertificate())
+		Expect(rotated.Generate()).To(Succeed())
+		Expect(workload.UpdateControlPlaneMetrics(context.Background(), metrics, rotated)).To(Succeed())
+
+		Expect(workload.Client.Get(context.Background(), key, clientSecret)).To(Succeed())
+		Expect(clientSecret.Data[corev1.ServiceAccountRootCAKey]).To(Equal(rotated.GetByPurpose(secret.EtcdCA).KeyPair.Cert))
+		Expect(needsClientCertificate(clientSecret.Data[corev1.TLSCertKey], rotated.GetByPurpose(secret.EtcdCA))).To(BeFalse())
+	})
+
+	It("should not update an etcd client secret it didn't create", func() {
+		workload.Client = fake.NewClientBuilder().WithObjects(&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "etcd-client", Namespace: "monitoring"},
+			Data:       map[string][]byte{"password": []byte("secret")},
+		}).Build()
+
+		metrics := controlplanev1.ControlPlaneMetrics{
+			EtcdClientSecrets: []controlplanev1.EtcdClientSecret{{Name: "etcd-client", Namespace: "monitoring"}},
+		}
+		Expect(workload.UpdateControlPlaneMetrics(context.Background(), metrics, certificates)).
+			To(MatchError(ContainSubstring("not created by the control plane")))
+
+		clientSecret := &corev1.Secret{}
+		Expect(workload.Client.Get(context.Background(), types.NamespacedName{Name: "etcd-client", Namespace: "monitoring"},
+			clientSecret)).To(Succeed())
+		Expect(clientSecret.Data).To(Equal(map[string][]byte{"password": []byte("secret")}))
+
+		// The RBAC resources are created before the secrets.
+		Expect(workload.Client.Get(context.Background(), types.NamespacedName{Name: ControlPlaneMetricsName}, &rbacv1.ClusterRole{})).To(Succeed())
+	})
+
+	It("should delete the etcd client secrets removed from the metrics", func() {
+		metrics := controlplanev1.ControlPlaneMetrics{
+			EtcdClientSecrets: []controlplanev1.EtcdClientSecret{
+				{Name: "etcd-client", Namespace: "monitoring"},
+				{Name: "etcd-client", Namespace: "logging"},
+			},
+		}
+		Expect(workload.UpdateControlPlaneMetrics(context.Background(), metrics, certificates)).To(Succeed())
+
+		metrics.EtcdClientSecrets = metrics.EtcdClientSecrets[:1]
+		Expect(workload.UpdateControlPlaneMetrics(context.Background(), metrics, certificates)).To(Succeed())
+
+		Expect(workload.Client.Get(context.Background(), types.NamespacedName{Name: "etcd-client", Namespace: "monitoring"},
+			&corev1.Secret{})).To(Succeed())
+		Expect(apierrors.IsNotFound(workload.Client.Get(context.Background(),
+			types.NamespacedName{Name: "etcd-client", Namespace: "logging"}, &corev1.Secret{}))).To(BeTrue())
+
+		// The metrics secret isn't an etcd client secret.
+		Expect(workload.Client.Get(context.Background(), types.NamespacedName{
+			Name:      ControlPlaneMetricsName,
+			Namespace: DefaultControlPlaneMetricsNamespace,
+		}, &corev1.Secret{})).To(Succeed())
+	})
+
+	It("should scrape the components on the configured addresses and ports", func() {
+		scrapeConfig, err := renderScrapeConfig(controlplanev1.ControlPlaneMetrics{
+			AddressType:   corev1.NodeExternalIP,
+			APIServerPort: 8443,
+		}, true)
+		Expect(err).ToNot(HaveOccurred())
+
+		var jobs []map[string]interface{}
+		Expect(yaml.Unmarshal(scrapeConfig, &jobs)).To(Succeed())
+		Expect(jobs).To(HaveLen(4))
+
+		Expect(string(scrapeConfig)).To(ContainSubstring("source_labels: [__meta_kubernetes_node_address_ExternalIP]"))
+		Expect(string(scrapeConfig)).ToNot(ContainSubstring("InternalIP"))
+		Expect(string(scrapeConfig)).To(ContainSubstring("replacement: $1:8443"))
+		Expect(string(scrapeConfig)).To(ContainSubstring(`replacement: "[$1]:8443"`))
+		Expect(string(scrapeConfig)).To(ContainSubstring(`replacement: "[$1]:2381"`))
+	})
+
+	It("should not scrape etcd without the etcd CA", func() {
+		certificates = secret.NewCertificatesForInitialControlPlane()
+		Expect(certificates.Generate()).To(Succeed())
+
+		Expect(workload.UpdateControlPlaneMetrics(context.Background(), controlplanev1.ControlPlaneMetrics{}, certificates)).To(Succeed())
+
+		metricsSecret := &corev1.Secret{}
+		Expect(workload.Client.Get(context.Background(), types.NamespacedName{
+			Name:      ControlPlaneMetricsName,
+			Namespace: DefaultControlPlaneMetricsNamespace,
+		}, metricsSecret)).To(Succeed())
+		Expect(metricsSecret.Data).ToNot(HaveKey(EtcdCACertKey))
+		Expect(metricsSecret.Data).ToNot(HaveKey(EtcdClientCertKey))
+
+		var jobs []map[string]interface{}
+		Expect(yaml.Unmarshal(metricsSecret.Data[ScrapeConfigKey], &jobs)).To(Succeed())
+		Expect(jobs).To(HaveLen(3))
+		Expect(string(metricsSecret.Data[ScrapeConfigKey])).ToNot(ContainSubstring("rke2-etcd"))
+	})
+})
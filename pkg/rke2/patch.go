@@ -0,0 +1,71 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rke2
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ErrStaleObject is returned by mutations passed to PatchWithRetry and PatchStatusWithRetry to abort the patch, when
+// the latest version of the object shows that the change is based on an outdated view.
+var ErrStaleObject = errors.New("object was modified by another controller")
+
+// PatchWithRetry applies the mutation to the object and patches it with an optimistic lock, so that the patch is
+// rejected if the object was modified since it was read. On conflicts, the latest version of the object is read
+// with the reader, which should not be cached, and the mutation is applied again.
+func PatchWithRetry(ctx context.Context, c client.Client, reader client.Reader, obj client.Object, mutate func() error) error {
+	return patchWithRetry(ctx, reader, obj, mutate, func(patch client.Patch) error {
+		return c.Patch(ctx, obj, patch)
+	})
+}
+
+// PatchStatusWithRetry is PatchWithRetry for the status subresource of the object.
+func PatchStatusWithRetry(ctx context.Context, c client.Client, reader client.Reader, obj client.Object, mutate func() error) error {
+	return patchWithRetry(ctx, reader, obj, mutate, func(patch client.Patch) error {
+		return c.Status().Patch(ctx, obj, patch)
+	})
+}
+
+func patchWithRetry(ctx context.Context, reader client.Reader, obj client.Object, mutate func() error, patch func(client.Patch) error) error {
+	first := true
+
+	return retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		// The first attempt uses the version of the object the caller acted on.
+		if !first {
+			if err := reader.Get(ctx, client.ObjectKeyFromObject(obj), obj); err != nil {
+				return err
+			}
+		}
+
+		first = false
+
+		before, ok := obj.DeepCopyObject().(client.Object)
+		if !ok {
+			return errors.Errorf("failed to copy %s", obj.GetName())
+		}
+
+		if err := mutate(); err != nil {
+			return err
+		}
+
+		return patch(client.MergeFromWithOptions(before, client.MergeFromWithOptimisticLock{}))
+	})
+}
@@ -0,0 +1,106 @@
+/*
+Copyright 2023 SUSE.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rke2
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util/collections"
+)
+
+var _ = Describe("PatchWithRetry", func() {
+	var (
+		ctx   context.Context
+		cl    ctrlclient.Client
+		stale *clusterv1.Machine
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+
+		scheme := runtime.NewScheme()
+		Expect(clusterv1.AddToScheme(scheme)).To(Succeed())
+
+		cl = fake.NewClientBuilder().WithScheme(scheme).WithObjects(&clusterv1.Machine{
+			ObjectMeta: metav1.ObjectMeta{Name: "machine", Namespace: "default"},
+		}).Build()
+
+		stale = &clusterv1.Machine{}
+		Expect(cl.Get(ctx, ctrlclient.ObjectKey{Name: "machine", Namespace: "default"}, stale)).To(Succeed())
+
+		// Another controller modifies the machine after it was read.
+		latest := stale.DeepCopy()
+		latest.Annotations = map[string]string{"other": "value"}
+		Expect(cl.Update(ctx, latest)).To(Succeed())
+	})
+
+	It("should apply the mutation again to the latest version on conflicts", func() {
+		attempts := 0
+
+		Expect(PatchWithRetry(ctx, cl, cl, stale, func() error {
+			attempts++
+
+			if stale.Annotations == nil {
+				stale.Annotations = map[string]string{}
+			}
+
+			stale.Annotations["mine"] = "value"
+
+			return nil
+		})).To(Succeed())
+		Expect(attempts).To(Equal(2))
+
+		machine := &clusterv1.Machine{}
+		Expect(cl.Get(ctx, ctrlclient.ObjectKeyFromObject(stale), machine)).To(Succeed())
+		Expect(machine.Annotations).To(Equal(map[string]string{"other": "value", "mine": "value"}))
+	})
+
+	It("should not patch when the mutation aborts", func() {
+		Expect(PatchWithRetry(ctx, cl, cl, stale, func() error {
+			if stale.Annotations["other"] != "" {
+				return ErrStaleObject
+			}
+
+			stale.Annotations = map[string]string{"mine": "value"}
+
+			return nil
+		})).To(MatchError(ErrStaleObject))
+
+		machine := &clusterv1.Machine{}
+		Expect(cl.Get(ctx, ctrlclient.ObjectKeyFromObject(stale), machine)).To(Succeed())
+		Expect(machine.Annotations).To(Equal(map[string]string{"other": "value"}))
+	})
+
+	It("should patch the annotations of the control plane machines", func() {
+		controlPlane := &ControlPlane{Machines: collections.FromMachines(stale)}
+
+		Expect(controlPlane.PatchMachineAnnotations(ctx, cl, cl, map[string]string{"mine": "value"}, "other")).To(Succeed())
+		Expect(stale.Annotations).To(Equal(map[string]string{"mine": "value"}))
+
+		machine := &clusterv1.Machine{}
+		Expect(cl.Get(ctx, ctrlclient.ObjectKeyFromObject(stale), machine)).To(Succeed())
+		Expect(machine.Annotations).To(Equal(map[string]string{"mine": "value"}))
+	})
+})
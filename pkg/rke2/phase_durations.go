@@ -0,0 +1,59 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rke2
+
+import (
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+)
+
+const (
+	// phaseDurationMinChange is the smallest change of the duration of a reconcile phase recorded in the status.
+	phaseDurationMinChange = time.Second
+
+	// phaseDurationRelativeChange is the fraction of the recorded duration of a reconcile phase its duration has to
+	// change by to be recorded again.
+	phaseDurationRelativeChange = 5
+)
+
+// RecordPhaseDuration records the duration of a reconcile phase started at the given time in the status of the
+// control plane, to the millisecond. The recorded duration is kept unless the duration changed by more than a second
+// and a fifth, so that the status doesn't change, triggering another reconciliation, on every reconciliation. It is
+// meant to be deferred at the start of the phase.
+func RecordPhaseDuration(rcp *controlplanev1.RKE2ControlPlane, phase controlplanev1.ReconcilePhase, start time.Time) {
+	duration := time.Since(start).Truncate(time.Millisecond)
+
+	if recorded, ok := rcp.Status.LastPhaseDurations[phase]; ok {
+		change := duration - recorded.Duration
+		if change < 0 {
+			change = -change
+		}
+
+		if change <= phaseDurationMinChange || change <= recorded.Duration/phaseDurationRelativeChange {
+			return
+		}
+	}
+
+	if rcp.Status.LastPhaseDurations == nil {
+		rcp.Status.LastPhaseDurations = map[controlplanev1.ReconcilePhase]metav1.Duration{}
+	}
+
+	rcp.Status.LastPhaseDurations[phase] = metav1.Duration{Duration: duration}
+}
@@ -0,0 +1,65 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rke2
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+)
+
+var _ = Describe("RecordPhaseDuration", func() {
+	var rcp *controlplanev1.RKE2ControlPlane
+
+	BeforeEach(func() {
+		rcp = &controlplanev1.RKE2ControlPlane{}
+	})
+
+	recorded := func(phase controlplanev1.ReconcilePhase) time.Duration {
+		return rcp.Status.LastPhaseDurations[phase].Duration
+	}
+
+	It("should record the duration of a phase the first time it runs", func() {
+		RecordPhaseDuration(rcp, controlplanev1.PreflightReconcilePhase, time.Now())
+		Expect(rcp.Status.LastPhaseDurations).To(HaveKey(controlplanev1.PreflightReconcilePhase))
+		Expect(recorded(controlplanev1.PreflightReconcilePhase)).To(BeNumerically("<", time.Second))
+		Expect(rcp.Status.LastPhaseDurations).ToNot(HaveKey(controlplanev1.StatusUpdateReconcilePhase))
+	})
+
+	It("should only record a duration again when it changed significantly", func() {
+		rcp.Status.LastPhaseDurations = map[controlplanev1.ReconcilePhase]metav1.Duration{
+			controlplanev1.EtcdCheckReconcilePhase: {Duration: 10 * time.Second},
+		}
+
+		RecordPhaseDuration(rcp, controlplanev1.EtcdCheckReconcilePhase, time.Now().Add(-11*time.Second))
+		Expect(recorded(controlplanev1.EtcdCheckReconcilePhase)).To(Equal(10 * time.Second))
+
+		RecordPhaseDuration(rcp, controlplanev1.EtcdCheckReconcilePhase, time.Now().Add(-13*time.Second))
+		Expect(recorded(controlplanev1.EtcdCheckReconcilePhase)).To(BeNumerically("~", 13*time.Second, time.Second))
+
+		RecordPhaseDuration(rcp, controlplanev1.EtcdCheckReconcilePhase, time.Now())
+		Expect(recorded(controlplanev1.EtcdCheckReconcilePhase)).To(BeNumerically("<", time.Second))
+
+		RecordPhaseDuration(rcp, controlplanev1.EtcdCheckReconcilePhase, time.Now().Add(-900*time.Millisecond))
+		Expect(recorded(controlplanev1.EtcdCheckReconcilePhase)).To(BeNumerically("<", 900*time.Millisecond))
+	})
+})
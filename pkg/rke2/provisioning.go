@@ -0,0 +1,301 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rke2
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util/annotations"
+	"sigs.k8s.io/cluster-api/util/collections"
+	"sigs.k8s.io/cluster-api/util/conditions"
+
+	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+)
+
+const (
+	// InfrastructureReadyPhase is the provisioning phase from the creation of a machine to its infrastructure being
+	// ready.
+	InfrastructureReadyPhase = "infrastructure_ready"
+
+	// NodeJoinedPhase is the provisioning phase from the infrastructure of a machine being ready to its node joining
+	// the workload cluster.
+	NodeJoinedPhase = "node_joined"
+
+	// AgentHealthyPhase is the provisioning phase from the node of a machine joining the workload cluster to its
+	// agent being healthy.
+	AgentHealthyPhase = "agent_healthy"
+
+	// NodeHealthyPhase is the provisioning phase from the node of a worker machine joining the workload cluster to
+	// the node being healthy.
+	NodeHealthyPhase = "node_healthy"
+)
+
+var (
+	// provisioningBuckets range from 15 seconds to about 2 hours.
+	provisioningBuckets = prometheus.ExponentialBuckets(15, 2, 10)
+
+	machineProvisioningPhaseDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "rke2_control_plane_machine_provisioning_phase_duration_seconds",
+		Help:    "Duration of the provisioning phases of the control plane machines, by infrastructure machine kind.",
+		Buckets: provisioningBuckets,
+	}, []string{"infrastructure_kind", "phase"})
+
+	machineProvisioningDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "rke2_control_plane_machine_provisioning_duration_seconds",
+		Help:    "Duration from the creation of the control plane machines to their agent being healthy, by infrastructure machine kind.",
+		Buckets: provisioningBuckets,
+	}, []string{"infrastructure_kind"})
+
+	workerMachineProvisioningPhaseDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "rke2_worker_machine_provisioning_phase_duration_seconds",
+		Help:    "Duration of the provisioning phases of the worker machines, by infrastructure machine kind.",
+		Buckets: provisioningBuckets,
+	}, []string{"infrastructure_kind", "phase"})
+
+	workerMachineProvisioningDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "rke2_worker_machine_provisioning_duration_seconds",
+		Help:    "Duration from the creation of the worker machines to their node being healthy, by infrastructure machine kind.",
+		Buckets: provisioningBuckets,
+	}, []string{"infrastructure_kind"})
+
+	// controllerStartTime is used to only observe the provisioning of the machines created since the controller
+	// started, the others may have been observed by a previous instance of the controller.
+	controllerStartTime = time.Now()
+)
+
+func init() {
+	metrics.Registry.MustRegister(machineProvisioningPhaseDuration, machineProvisioningDuration,
+		workerMachineProvisioningPhaseDuration, workerMachineProvisioningDuration)
+}
+
+// recordMachineProvisioning records in the machine annotations the time the node of the machine joined the workload
+// cluster, and the time its agent was first reported healthy, observing the durations of the provisioning phases
+// when they are recorded. The times are reported in the status of the machine node.
+func recordMachineProvisioning(machine *clusterv1.Machine, nodeCreated *metav1.Time, machineNode *controlplanev1.MachineNode) {
+	joinedAt, provisionedAt := recordProvisioning(machine, nodeCreated, controlplanev1.MachineAgentHealthyCondition,
+		AgentHealthyPhase, machineProvisioningPhaseDuration, machineProvisioningDuration)
+
+	if joinedAt != nil {
+		machineNode.JoinedAt = &metav1.Time{Time: *joinedAt}
+	}
+
+	if provisionedAt != nil {
+		machineNode.ProvisioningDuration = &metav1.Duration{Duration: provisionedAt.Sub(machine.CreationTimestamp.Time)}
+	}
+}
+
+// RecordWorkerProvisioning records in the annotations of the worker machines the time their node joined the workload
+// cluster, and the time the node was first reported healthy by Cluster API, observing the durations of the
+// provisioning phases when they are recorded. It returns the machines whose annotations changed.
+func (w *Workload) RecordWorkerProvisioning(ctx context.Context, machines collections.Machines) ([]*clusterv1.Machine, error) {
+	changed := []*clusterv1.Machine{}
+
+	for _, machine := range machines.SortedByCreationTimestamp() {
+		if machine.Status.NodeRef == nil || !machine.DeletionTimestamp.IsZero() {
+			continue
+		}
+
+		if _, provisioned := annotationTime(machine, controlplanev1.ProvisionedAtAnnotation); provisioned {
+			continue
+		}
+
+		var nodeCreated *metav1.Time
+
+		if _, joined := annotationTime(machine, controlplanev1.JoinedAtAnnotation); !joined {
+			node := &corev1.Node{}
+			if err := w.Client.Get(ctx, ctrlclient.ObjectKey{Name: machine.Status.NodeRef.Name}, node); err != nil {
+				if apierrors.IsNotFound(err) {
+					continue
+				}
+
+				return nil, errors.Wrapf(err, "failed to get the node of machine %s", machine.Name)
+			}
+
+			nodeCreated = &node.CreationTimestamp
+		}
+
+		before := len(machine.Annotations)
+
+		recordProvisioning(machine, nodeCreated, clusterv1.MachineNodeHealthyCondition, NodeHealthyPhase,
+			workerMachineProvisioningPhaseDuration, workerMachineProvisioningDuration)
+
+		if len(machine.Annotations) != before {
+			changed = append(changed, machine)
+		}
+	}
+
+	return changed, nil
+}
+
+// recordProvisioning records in the machine annotations the time the node of the machine joined the workload cluster,
+// and the time the healthy condition of the machine first became true, observing the durations of the provisioning
+// phases, the last one being the given healthy phase, when they are recorded. It returns the recorded times.
+func recordProvisioning(
+	machine *clusterv1.Machine,
+	nodeCreated *metav1.Time,
+	healthyCondition clusterv1.ConditionType,
+	healthyPhase string,
+	phaseDuration, duration *prometheus.HistogramVec,
+) (*time.Time, *time.Time) {
+	created := machine.CreationTimestamp.Time
+	kind := machine.Spec.InfrastructureRef.Kind
+	observe := created.After(controllerStartTime)
+
+	joinedAt, joined := annotationTime(machine, controlplanev1.JoinedAtAnnotation)
+	if !joined && nodeCreated != nil && !nodeCreated.IsZero() {
+		joinedAt, joined = nodeCreated.Time, true
+		annotations.AddAnnotations(machine, map[string]string{
+			controlplanev1.JoinedAtAnnotation: joinedAt.UTC().Format(time.RFC3339),
+		})
+
+		if infrastructureReadyAt, ok := conditionTime(machine, clusterv1.InfrastructureReadyCondition); ok && observe {
+			phaseDuration.WithLabelValues(kind, InfrastructureReadyPhase).Observe(infrastructureReadyAt.Sub(created).Seconds())
+			phaseDuration.WithLabelValues(kind, NodeJoinedPhase).Observe(joinedAt.Sub(infrastructureReadyAt).Seconds())
+		}
+	}
+
+	if !joined {
+		return nil, nil
+	}
+
+	provisionedAt, provisioned := annotationTime(machine, controlplanev1.ProvisionedAtAnnotation)
+	if !provisioned {
+		if provisionedAt, provisioned = conditionTime(machine, healthyCondition); !provisioned {
+			return &joinedAt, nil
+		}
+
+		annotations.AddAnnotations(machine, map[string]string{
+			controlplanev1.ProvisionedAtAnnotation: provisionedAt.UTC().Format(time.RFC3339),
+		})
+
+		if observe {
+			phaseDuration.WithLabelValues(kind, healthyPhase).Observe(provisionedAt.Sub(joinedAt).Seconds())
+			duration.WithLabelValues(kind).Observe(provisionedAt.Sub(created).Seconds())
+		}
+	}
+
+	return &joinedAt, &provisionedAt
+}
+
+// annotationTime returns the time stored in an annotation of the machine.
+func annotationTime(machine *clusterv1.Machine, annotation string) (time.Time, bool) {
+	value, ok := machine.Annotations[annotation]
+	if !ok {
+		return time.Time{}, false
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return t, true
+}
+
+// conditionTime returns the time a condition of the machine became true.
+func conditionTime(machine *clusterv1.Machine, conditionType clusterv1.ConditionType) (time.Time, bool) {
+	condition := conditions.Get(machine, conditionType)
+	if condition == nil || condition.Status != corev1.ConditionTrue {
+		return time.Time{}, false
+	}
+
+	return condition.LastTransitionTime.Time, true
+}
+
+// provisioningSteps are the provisioning steps of a machine, in order, with the reason of the machines waiting for
+// them and the description of the step.
+var provisioningSteps = []struct {
+	reason      string
+	description string
+}{
+	{controlplanev1.WaitingForBootstrapDataReason, "bootstrap data"},
+	{controlplanev1.WaitingForInfrastructureReason, "infrastructure"},
+	{controlplanev1.WaitingForNodeRefReason, "node registration"},
+}
+
+// provisioningReason returns the reason of a machine waiting for a provisioning step, empty once it is provisioned.
+func provisioningReason(machine *clusterv1.Machine) string {
+	switch {
+	case machine.Status.NodeRef != nil:
+		return ""
+	case !machine.Status.BootstrapReady:
+		return controlplanev1.WaitingForBootstrapDataReason
+	case !machine.Status.InfrastructureReady:
+		return controlplanev1.WaitingForInfrastructureReason
+	default:
+		return controlplanev1.WaitingForNodeRefReason
+	}
+}
+
+// UpdateProvisioningConditions sets the MachineProvisionedCondition of the control plane machines, and the
+// MachinesProvisionedCondition of the control plane to the earliest provisioning step the machines wait for, so that
+// the bottleneck, this provider or the infrastructure provider, is told apart. The machines being deleted are ignored.
+func (c *ControlPlane) UpdateProvisioningConditions() {
+	waiting := map[string][]string{}
+
+	for _, machine := range c.Machines.SortedByCreationTimestamp() {
+		reason := provisioningReason(machine)
+		if reason == "" {
+			conditions.MarkTrue(machine, controlplanev1.MachineProvisionedCondition)
+
+			continue
+		}
+
+		conditions.MarkFalse(machine, controlplanev1.MachineProvisionedCondition, reason, clusterv1.ConditionSeverityInfo, "")
+
+		if machine.DeletionTimestamp.IsZero() {
+			waiting[reason] = append(waiting[reason], machine.Name)
+		}
+	}
+
+	if len(waiting) == 0 {
+		conditions.MarkTrue(c.RCP, controlplanev1.MachinesProvisionedCondition)
+
+		return
+	}
+
+	reason := ""
+	messages := []string{}
+
+	for _, step := range provisioningSteps {
+		names, ok := waiting[step.reason]
+		if !ok {
+			continue
+		}
+
+		if reason == "" {
+			reason = step.reason
+		}
+
+		messages = append(messages, fmt.Sprintf("%s waiting for %s", strings.Join(names, ", "), step.description))
+	}
+
+	conditions.MarkFalse(c.RCP, controlplanev1.MachinesProvisionedCondition, reason, clusterv1.ConditionSeverityInfo,
+		"Machines %s", strings.Join(messages, "; "))
+}
@@ -0,0 +1,275 @@
+/*
+Copyright 2023 SUSE.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rke2
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util/collections"
+	"sigs.k8s.io/cluster-api/util/conditions"
+
+	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+)
+
+var _ = Describe("recordMachineProvisioning", func() {
+	var (
+		created time.Time
+		machine *clusterv1.Machine
+	)
+
+	setCondition := func(conditionType clusterv1.ConditionType, at time.Time) {
+		machine.Status.Conditions = append(machine.Status.Conditions, clusterv1.Condition{
+			Type:               conditionType,
+			Status:             corev1.ConditionTrue,
+			LastTransitionTime: metav1.NewTime(at),
+		})
+	}
+
+	BeforeEach(func() {
+		machineProvisioningPhaseDuration.Reset()
+		machineProvisioningDuration.Reset()
+
+		created = time.Now().Add(time.Minute).Truncate(time.Second)
+		machine = &clusterv1.Machine{
+			ObjectMeta: metav1.ObjectMeta{Name: "machine", CreationTimestamp: metav1.NewTime(created)},
+			Spec: clusterv1.MachineSpec{
+				InfrastructureRef: corev1.ObjectReference{Kind: "DockerMachine"},
+			},
+		}
+	})
+
+	It("should not record anything until the node joined", func() {
+		setCondition(clusterv1.InfrastructureReadyCondition, created.Add(time.Minute))
+
+		machineNode := controlplanev1.MachineNode{}
+		recordMachineProvisioning(machine, nil, &machineNode)
+
+		Expect(machine.Annotations).To(BeEmpty())
+		Expect(machineNode.JoinedAt).To(BeNil())
+		Expect(testutil.CollectAndCount(machineProvisioningPhaseDuration)).To(Equal(0))
+	})
+
+	It("should record the provisioning phases once", func() {
+		setCondition(clusterv1.InfrastructureReadyCondition, created.Add(time.Minute))
+
+		joined := metav1.NewTime(created.Add(3 * time.Minute))
+		machineNode := controlplanev1.MachineNode{}
+		recordMachineProvisioning(machine, &joined, &machineNode)
+
+		Expect(machine.Annotations).To(Equal(map[string]string{
+			controlplanev1.JoinedAtAnnotation: joined.UTC().Format(time.RFC3339),
+		}))
+		Expect(machineNode.JoinedAt.Equal(&joined)).To(BeTrue())
+		Expect(machineNode.ProvisioningDuration).To(BeNil())
+		Expect(testutil.CollectAndCount(machineProvisioningPhaseDuration)).To(Equal(2))
+
+		setCondition(controlplanev1.MachineAgentHealthyCondition, created.Add(4*time.Minute))
+
+		for i := 0; i < 2; i++ {
+			machineNode = controlplanev1.MachineNode{}
+			recordMachineProvisioning(machine, &joined, &machineNode)
+		}
+
+		Expect(machine.Annotations).To(HaveKeyWithValue(controlplanev1.ProvisionedAtAnnotation,
+			created.Add(4*time.Minute).UTC().Format(time.RFC3339)))
+		Expect(machineNode.ProvisioningDuration.Duration).To(Equal(4 * time.Minute))
+		Expect(testutil.CollectAndCount(machineProvisioningPhaseDuration)).To(Equal(3))
+		Expect(testutil.CollectAndCount(machineProvisioningDuration)).To(Equal(1))
+	})
+
+	It("should not observe the machines created before the controller started", func() {
+		machine.CreationTimestamp = metav1.NewTime(controllerStartTime.Add(-time.Hour))
+		setCondition(clusterv1.InfrastructureReadyCondition, created)
+		setCondition(controlplanev1.MachineAgentHealthyCondition, created)
+
+		joined := metav1.NewTime(created)
+		machineNode := controlplanev1.MachineNode{}
+		recordMachineProvisioning(machine, &joined, &machineNode)
+
+		Expect(machine.Annotations).To(HaveLen(2))
+		Expect(machineNode.ProvisioningDuration).ToNot(BeNil())
+		Expect(testutil.CollectAndCount(machineProvisioningPhaseDuration)).To(Equal(0))
+		Expect(testutil.CollectAndCount(machineProvisioningDuration)).To(Equal(0))
+	})
+})
+
+var _ = Describe("RecordWorkerProvisioning", func() {
+	var (
+		created  time.Time
+		workload *Workload
+		worker   *clusterv1.Machine
+	)
+
+	BeforeEach(func() {
+		workerMachineProvisioningPhaseDuration.Reset()
+		workerMachineProvisioningDuration.Reset()
+
+		created = time.Now().Add(time.Minute).Truncate(time.Second)
+		joined := metav1.NewTime(created.Add(3 * time.Minute))
+
+		workload = &Workload{
+			Client: fake.NewClientBuilder().WithObjects(
+				&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "worker", CreationTimestamp: joined}},
+			).Build(),
+		}
+
+		worker = &clusterv1.Machine{
+			ObjectMeta: metav1.ObjectMeta{Name: "worker", CreationTimestamp: metav1.NewTime(created)},
+			Spec: clusterv1.MachineSpec{
+				InfrastructureRef: corev1.ObjectReference{Kind: "DockerMachine"},
+			},
+			Status: clusterv1.MachineStatus{
+				NodeRef: &corev1.ObjectReference{Name: "worker"},
+				Conditions: clusterv1.Conditions{{
+					Type:               clusterv1.InfrastructureReadyCondition,
+					Status:             corev1.ConditionTrue,
+					LastTransitionTime: metav1.NewTime(created.Add(time.Minute)),
+				}},
+			},
+		}
+	})
+
+	It("should record the provisioning phases of the worker machines once", func() {
+		unregistered := &clusterv1.Machine{ObjectMeta: metav1.ObjectMeta{Name: "unregistered"}}
+
+		changed, err := workload.RecordWorkerProvisioning(context.Background(), collections.FromMachines(worker, unregistered))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(changed).To(ConsistOf(worker))
+		Expect(worker.Annotations).To(Equal(map[string]string{
+			controlplanev1.JoinedAtAnnotation: created.Add(3 * time.Minute).UTC().Format(time.RFC3339),
+		}))
+		Expect(testutil.CollectAndCount(workerMachineProvisioningPhaseDuration)).To(Equal(2))
+
+		worker.Status.Conditions = append(worker.Status.Conditions, clusterv1.Condition{
+			Type:               clusterv1.MachineNodeHealthyCondition,
+			Status:             corev1.ConditionTrue,
+			LastTransitionTime: metav1.NewTime(created.Add(4 * time.Minute)),
+		})
+
+		changed, err = workload.RecordWorkerProvisioning(context.Background(), collections.FromMachines(worker))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(changed).To(ConsistOf(worker))
+		Expect(worker.Annotations).To(HaveKeyWithValue(controlplanev1.ProvisionedAtAnnotation,
+			created.Add(4*time.Minute).UTC().Format(time.RFC3339)))
+		Expect(testutil.CollectAndCount(workerMachineProvisioningPhaseDuration)).To(Equal(3))
+		Expect(testutil.CollectAndCount(workerMachineProvisioningDuration)).To(Equal(1))
+
+		changed, err = workload.RecordWorkerProvisioning(context.Background(), collections.FromMachines(worker))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(changed).To(BeEmpty())
+		Expect(testutil.CollectAndCount(workerMachineProvisioningDuration)).To(Equal(1))
+	})
+
+	It("should wait for the node of the machine", func() {
+		worker.Status.NodeRef.Name = "missing"
+
+		changed, err := workload.RecordWorkerProvisioning(context.Background(), collections.FromMachines(worker))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(changed).To(BeEmpty())
+		Expect(worker.Annotations).To(BeEmpty())
+	})
+})
+
+var _ = Describe("UpdateProvisioningConditions", func() {
+	var created time.Time
+
+	newMachine := func(name string, bootstrapReady, infrastructureReady, nodeRef bool) *clusterv1.Machine {
+		created = created.Add(time.Minute)
+		machine := &clusterv1.Machine{
+			ObjectMeta: metav1.ObjectMeta{Name: name, CreationTimestamp: metav1.NewTime(created)},
+			Status: clusterv1.MachineStatus{
+				BootstrapReady:      bootstrapReady,
+				InfrastructureReady: infrastructureReady,
+			},
+		}
+
+		if nodeRef {
+			machine.Status.NodeRef = &corev1.ObjectReference{Name: name}
+		}
+
+		return machine
+	}
+
+	BeforeEach(func() {
+		created = time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+	})
+
+	It("should report the provisioned machines", func() {
+		controlPlane := &ControlPlane{
+			RCP:      &controlplanev1.RKE2ControlPlane{},
+			Machines: collections.FromMachines(newMachine("m1", true, true, true)),
+		}
+
+		controlPlane.UpdateProvisioningConditions()
+
+		Expect(conditions.IsTrue(controlPlane.RCP, controlplanev1.MachinesProvisionedCondition)).To(BeTrue())
+		Expect(conditions.IsTrue(controlPlane.Machines["m1"], controlplanev1.MachineProvisionedCondition)).To(BeTrue())
+	})
+
+	It("should report the earliest provisioning step the machines wait for", func() {
+		controlPlane := &ControlPlane{
+			RCP: &controlplanev1.RKE2ControlPlane{},
+			Machines: collections.FromMachines(
+				newMachine("m1", true, true, true),
+				newMachine("m2", true, true, false),
+				newMachine("m3", true, false, false),
+				newMachine("m4", true, false, false),
+			),
+		}
+
+		controlPlane.UpdateProvisioningConditions()
+
+		Expect(conditions.GetReason(controlPlane.Machines["m2"], controlplanev1.MachineProvisionedCondition)).
+			To(Equal(controlplanev1.WaitingForNodeRefReason))
+		Expect(conditions.GetReason(controlPlane.Machines["m3"], controlplanev1.MachineProvisionedCondition)).
+			To(Equal(controlplanev1.WaitingForInfrastructureReason))
+		Expect(conditions.GetReason(controlPlane.RCP, controlplanev1.MachinesProvisionedCondition)).
+			To(Equal(controlplanev1.WaitingForInfrastructureReason))
+		Expect(conditions.GetMessage(controlPlane.RCP, controlplanev1.MachinesProvisionedCondition)).
+			To(Equal("Machines m3, m4 waiting for infrastructure; m2 waiting for node registration"))
+
+		controlPlane.Machines.Insert(newMachine("m5", false, false, false))
+		controlPlane.UpdateProvisioningConditions()
+
+		Expect(conditions.GetReason(controlPlane.RCP, controlplanev1.MachinesProvisionedCondition)).
+			To(Equal(controlplanev1.WaitingForBootstrapDataReason))
+	})
+
+	It("should ignore the machines being deleted", func() {
+		deleting := newMachine("m2", true, false, false)
+		now := metav1.Now()
+		deleting.DeletionTimestamp = &now
+
+		controlPlane := &ControlPlane{
+			RCP:      &controlplanev1.RKE2ControlPlane{},
+			Machines: collections.FromMachines(newMachine("m1", true, true, true), deleting),
+		}
+
+		controlPlane.UpdateProvisioningConditions()
+
+		Expect(conditions.IsTrue(controlPlane.RCP, controlplanev1.MachinesProvisionedCondition)).To(BeTrue())
+	})
+})
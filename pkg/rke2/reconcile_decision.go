@@ -0,0 +1,158 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rke2
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+
+	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+)
+
+// ReconcileDecisionKey is the key of the ConfigMap of the reconcile decisions holding the decision summary.
+const ReconcileDecisionKey = "decision.json"
+
+// ReconcileAction is the next action the controller takes on a control plane.
+type ReconcileAction string
+
+const (
+	// ReconcileActionNone is the action of a control plane with the desired number of up to date machines.
+	ReconcileActionNone ReconcileAction = "None"
+
+	// ReconcileActionInitialize is the action of a control plane creating its first machine.
+	ReconcileActionInitialize ReconcileAction = "Initialize"
+
+	// ReconcileActionScaleUp is the action of a control plane creating machines.
+	ReconcileActionScaleUp ReconcileAction = "ScaleUp"
+
+	// ReconcileActionScaleDown is the action of a control plane deleting machines.
+	ReconcileActionScaleDown ReconcileAction = "ScaleDown"
+
+	// ReconcileActionRollOut is the action of a control plane replacing its outdated machines.
+	ReconcileActionRollOut ReconcileAction = "RollOut"
+
+	// ReconcileActionRemediate is the action of a control plane replacing its unhealthy machines, or its machines about
+	// to be interrupted.
+	ReconcileActionRemediate ReconcileAction = "Remediate"
+
+	// ReconcileActionHibernate is the action of a control plane stopping rke2-server on its machines.
+	ReconcileActionHibernate ReconcileAction = "Hibernate"
+
+	// ReconcileActionResume is the action of a hibernated control plane starting rke2-server on its machines again.
+	ReconcileActionResume ReconcileAction = "Resume"
+
+	// ReconcileActionWait is the action of a control plane which is neither scaled nor rolled out until the reason of
+	// the decision is resolved.
+	ReconcileActionWait ReconcileAction = "Wait"
+)
+
+// ReconcileDecision is the machine-readable summary of the plan of the controller for a control plane, for GitOps
+// dashboards to display without parsing the logs of the controller.
+type ReconcileDecision struct {
+	// Generation is the generation of the RKE2ControlPlane the decision was made for.
+	Generation int64 `json:"generation"`
+
+	// DesiredReplicas is the number of replicas of the RKE2ControlPlane.
+	DesiredReplicas int32 `json:"desiredReplicas"`
+
+	// Replicas is the number of machines of the control plane.
+	Replicas int32 `json:"replicas"`
+
+	// OutdatedMachines are the names of the machines to roll out.
+	OutdatedMachines []string `json:"outdatedMachines,omitempty"`
+
+	// NextAction is the next action the controller takes on the control plane.
+	NextAction ReconcileAction `json:"nextAction"`
+
+	// Reason explains why the control plane is waiting, it is empty for the other actions.
+	Reason string `json:"reason,omitempty"`
+}
+
+// ReconcileDecisionConfigMapName returns the name of the ConfigMap of the reconcile decisions of the control plane of
+// the cluster.
+func ReconcileDecisionConfigMapName(clusterName string) string {
+	return clusterName + "-control-plane-decision"
+}
+
+// NewReconcileDecision returns the decision of the controller on the control plane before it is reconciled, no action
+// is taken until one is recorded with Decide or Wait as the control plane is reconciled.
+func NewReconcileDecision(controlPlane *ControlPlane) ReconcileDecision {
+	rcp := controlPlane.RCP
+	decision := ReconcileDecision{
+		Generation: rcp.Generation,
+		Replicas:   int32(controlPlane.Machines.Len()),
+		NextAction: ReconcileActionNone,
+	}
+
+	if rcp.Spec.Replicas != nil {
+		decision.DesiredReplicas = rcp.Spec.MachineReplicas()
+	}
+
+	if outdated := controlPlane.MachinesNeedingRollout(); outdated.Len() > 0 {
+		decision.OutdatedMachines = outdated.Names()
+	}
+
+	return decision
+}
+
+// Decide records the next action the controller takes on the control plane. The action is ignored once the control
+// plane is waiting, as the wait was recorded by a check preventing the action.
+func (d *ReconcileDecision) Decide(action ReconcileAction) {
+	if d == nil || d.NextAction == ReconcileActionWait {
+		return
+	}
+
+	d.NextAction = action
+}
+
+// Wait records that the control plane is neither scaled nor rolled out until the reason is resolved.
+func (d *ReconcileDecision) Wait(reason string) {
+	if d == nil {
+		return
+	}
+
+	d.NextAction = ReconcileActionWait
+	d.Reason = reason
+}
+
+// ReconcileDecisionConfigMap returns the ConfigMap of the reconcile decisions of the control plane, controlled by the
+// control plane, holding the compact JSON summary of the decision.
+func ReconcileDecisionConfigMap(controlPlane *ControlPlane, decision ReconcileDecision) (*corev1.ConfigMap, error) {
+	data, err := json.Marshal(decision)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal the reconcile decision")
+	}
+
+	rcp := controlPlane.RCP
+
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      ReconcileDecisionConfigMapName(controlPlane.Cluster.Name),
+			Namespace: rcp.Namespace,
+			Labels:    map[string]string{clusterv1.ClusterNameLabel: controlPlane.Cluster.Name},
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(rcp, controlplanev1.GroupVersion.WithKind("RKE2ControlPlane")),
+			},
+		},
+		Data: map[string]string{ReconcileDecisionKey: string(data)},
+	}, nil
+}
@@ -0,0 +1,123 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rke2
+
+import (
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/pointer"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util/collections"
+
+	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+)
+
+var _ = Describe("NewReconcileDecision", func() {
+	var controlPlane *ControlPlane
+
+	newMachine := func(name, version string) *clusterv1.Machine {
+		return &clusterv1.Machine{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       clusterv1.MachineSpec{Version: pointer.String(version)},
+		}
+	}
+
+	BeforeEach(func() {
+		controlPlane = &ControlPlane{
+			Cluster: &clusterv1.Cluster{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"}},
+			RCP: &controlplanev1.RKE2ControlPlane{
+				ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default", Generation: 2},
+				Spec: controlplanev1.RKE2ControlPlaneSpec{
+					Replicas: pointer.Int32(3),
+				},
+			},
+			Machines: collections.FromMachines(
+				newMachine("m1", "v1.26.4"),
+				newMachine("m2", "v1.26.4"),
+				newMachine("m3", "v1.26.4"),
+			),
+		}
+		controlPlane.RCP.Spec.AgentConfig.Version = "v1.26.4+rke2r1"
+	})
+
+	It("should take no action on an up to date control plane", func() {
+		Expect(NewReconcileDecision(controlPlane)).To(Equal(ReconcileDecision{
+			Generation:      2,
+			DesiredReplicas: 3,
+			Replicas:        3,
+			NextAction:      ReconcileActionNone,
+		}))
+	})
+
+	It("should list the outdated machines", func() {
+		controlPlane.Machines.Insert(newMachine("m4", "v1.25.9"))
+
+		decision := NewReconcileDecision(controlPlane)
+		Expect(decision.NextAction).To(Equal(ReconcileActionNone))
+		Expect(decision.Replicas).To(Equal(int32(4)))
+		Expect(decision.OutdatedMachines).To(Equal([]string{"m4"}))
+	})
+
+	It("should record the action", func() {
+		decision := NewReconcileDecision(controlPlane)
+
+		decision.Decide(ReconcileActionRollOut)
+		Expect(decision.NextAction).To(Equal(ReconcileActionRollOut))
+		Expect(decision.Reason).To(BeEmpty())
+	})
+
+	It("should keep waiting once a check prevented the action", func() {
+		decision := NewReconcileDecision(controlPlane)
+
+		decision.Decide(ReconcileActionScaleUp)
+		decision.Wait("etcd alarms NOSPACE")
+		decision.Decide(ReconcileActionRemediate)
+
+		Expect(decision.NextAction).To(Equal(ReconcileActionWait))
+		Expect(decision.Reason).To(Equal("etcd alarms NOSPACE"))
+	})
+
+	It("should ignore the decisions of control planes without one", func() {
+		var decision *ReconcileDecision
+
+		Expect(func() {
+			decision.Decide(ReconcileActionScaleUp)
+			decision.Wait("reason")
+		}).ToNot(Panic())
+	})
+
+	It("should write the decision to a ConfigMap controlled by the control plane", func() {
+		configMap, err := ReconcileDecisionConfigMap(controlPlane, NewReconcileDecision(controlPlane))
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(configMap.Name).To(Equal("test-control-plane-decision"))
+		Expect(configMap.Namespace).To(Equal("default"))
+		Expect(configMap.Labels).To(HaveKeyWithValue(clusterv1.ClusterNameLabel, "test"))
+		Expect(metav1.IsControlledBy(configMap, controlPlane.RCP)).To(BeTrue())
+
+		decision := ReconcileDecision{}
+		Expect(json.Unmarshal([]byte(configMap.Data[ReconcileDecisionKey]), &decision)).To(Succeed())
+		Expect(decision.NextAction).To(Equal(ReconcileActionNone))
+		Expect(configMap.Data[ReconcileDecisionKey]).To(Equal(
+			`{"generation":2,"desiredReplicas":3,"replicas":3,"nextAction":"None"}`))
+	})
+})
@@ -16,8 +16,16 @@ limitations under the License.
 package rke2
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+
+	"github.com/pkg/errors"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	bootstrapv1 "github.com/rancher-sandbox/cluster-api-provider-rke2/bootstrap/api/v1alpha1"
 	bsutil "github.com/rancher-sandbox/cluster-api-provider-rke2/pkg/util"
@@ -28,8 +36,53 @@ const (
 	DefaultRKE2RegistriesLocation string = "/etc/rancher/rke2/registries.yaml"
 
 	registryCertsPath string = "/etc/rancher/rke2/tls"
+
+	// registrySecretsHashLength is the number of hexadecimal digits of the hash of the registry secrets.
+	registrySecretsHashLength = 16
 )
 
+// RegistrySecretsHash returns the hash of the data of the secrets referenced by the registry configurations, which
+// changes when they are rotated. A secret that doesn't exist is hashed as empty, and the hash is empty when no secret
+// is referenced.
+func RegistrySecretsHash(ctx context.Context, reader client.Reader, registry bootstrapv1.Registry) (string, error) {
+	refs := registry.SecretRefs()
+	if len(refs) == 0 {
+		return "", nil
+	}
+
+	hash := sha256.New()
+
+	for _, ref := range refs {
+		hash.Write([]byte(ref.Namespace + "/" + ref.Name + "\x00"))
+
+		secret := &corev1.Secret{}
+
+		err := reader.Get(ctx, types.NamespacedName{Namespace: ref.Namespace, Name: ref.Name}, secret)
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+
+		if err != nil {
+			return "", errors.Wrapf(err, "failed to get registry secret %s/%s", ref.Namespace, ref.Name)
+		}
+
+		keys := make([]string, 0, len(secret.Data))
+		for key := range secret.Data {
+			keys = append(keys, key)
+		}
+
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			hash.Write([]byte(key + "\x00"))
+			hash.Write(secret.Data[key])
+			hash.Write([]byte("\x00"))
+		}
+	}
+
+	return hex.EncodeToString(hash.Sum(nil))[:registrySecretsHashLength], nil
+}
+
 // GenerateRegistries generates the registries.yaml file and the corresponding
 // files for the TLS certificates.
 func GenerateRegistries(rke2ConfigRegistry RegistryScope) (*Registry, []bootstrapv1.File, error) {
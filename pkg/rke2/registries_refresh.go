@@ -0,0 +1,281 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rke2
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kerrors "k8s.io/apimachinery/pkg/util/errors"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+
+	"sigs.k8s.io/cluster-api/util"
+
+	bootstrapv1 "github.com/rancher-sandbox/cluster-api-provider-rke2/bootstrap/api/v1alpha1"
+)
+
+const (
+	// registriesRefreshName is the name of the workload cluster Secret holding the registries configuration written on
+	// the nodes of the servers.
+	registriesRefreshName = "rke2-registries-refresh"
+
+	// registriesRefreshJobPrefix is the prefix of the names of the jobs writing the registries configuration on a node.
+	registriesRefreshJobPrefix = "rke2-registries-refresh-"
+
+	// registriesRefreshJobLabel is the label set on the workload cluster jobs writing the registries configuration.
+	registriesRefreshJobLabel = "controlplane.cluster.x-k8s.io/registries-refresh"
+
+	// registriesRefreshHashAnnotation is the annotation of the registries refresh jobs, and of the nodes they refreshed,
+	// holding the hash of the registries configuration.
+	registriesRefreshHashAnnotation = "controlplane.cluster.x-k8s.io/registries-hash"
+
+	// registriesRefreshConfigDir is the directory of the RKE2 configuration, holding the registries configuration.
+	registriesRefreshConfigDir = "/etc/rancher/rke2"
+
+	registriesRefreshScriptKey = "refresh.sh"
+	registriesRefreshSecretDir = "/registries"
+	registriesRefreshHostDir   = "/host"
+)
+
+// RegistriesFiles returns the registries.yaml file along with the registry certificates, as written on the nodes.
+func RegistriesFiles(registriesYAML []byte, files []bootstrapv1.File) []bootstrapv1.File {
+	return append(append([]bootstrapv1.File{}, files...), bootstrapv1.File{
+		Path:    DefaultRKE2RegistriesLocation,
+		Content: string(registriesYAML),
+	})
+}
+
+// RegistriesHash returns the hash of the registries configuration files.
+func RegistriesHash(files []bootstrapv1.File) string {
+	hash := sha256.New()
+
+	for _, file := range files {
+		hash.Write([]byte(file.Path + "\x00" + file.Content + "\x00"))
+	}
+
+	return hex.EncodeToString(hash.Sum(nil))[:registrySecretsHashLength]
+}
+
+// RefreshRegistries writes the registries configuration files on the nodes of the servers, one node at a time, with a
+// job restarting rke2-server when a file changed. The next node is only refreshed once all the nodes of the servers
+// are ready again. A refreshed node is annotated with the hash of the files and its job is deleted. It returns true
+// once the files are written on all the nodes, the secret holding them is then deleted.
+func (w *Workload) RefreshRegistries(ctx context.Context, files []bootstrapv1.File) (bool, error) {
+	hash := RegistriesHash(files)
+
+	nodes, err := w.getControlPlaneNodes(ctx)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to list the nodes of the servers")
+	}
+
+	sort.Slice(nodes.Items, func(i, j int) bool { return nodes.Items[i].Name < nodes.Items[j].Name })
+
+	for i := range nodes.Items {
+		node := &nodes.Items[i]
+		if node.Annotations[registriesRefreshHashAnnotation] == hash {
+			continue
+		}
+
+		if err := w.ensureRegistriesRefreshSecret(ctx, files); err != nil {
+			return false, err
+		}
+
+		return w.refreshNodeRegistries(ctx, node, nodes, hash)
+	}
+
+	secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: HibernationNamespace, Name: registriesRefreshName}}
+	if err := w.Client.Delete(ctx, secret); err != nil && !apierrors.IsNotFound(err) {
+		return false, errors.Wrap(err, "failed to delete the registries refresh secret")
+	}
+
+	return true, nil
+}
+
+// refreshNodeRegistries runs the job writing the registries configuration files on the node, once all the nodes of
+// the servers are ready. The node is annotated with the hash of the files once the job succeeded and the node is ready
+// again, the job is then deleted. It always returns false, the next node is refreshed afterwards.
+func (w *Workload) refreshNodeRegistries(
+	ctx context.Context,
+	node *corev1.Node,
+	nodes *corev1.NodeList,
+	hash string,
+) (bool, error) {
+	name := registriesRefreshJobPrefix + node.Name
+	job := &batchv1.Job{}
+
+	err := w.Client.Get(ctx, ctrlclient.ObjectKey{Namespace: HibernationNamespace, Name: name}, job)
+
+	switch {
+	case err == nil && job.Annotations[registriesRefreshHashAnnotation] != hash:
+		if err := w.Client.Delete(ctx, job, ctrlclient.PropagationPolicy(metav1.DeletePropagationBackground)); err != nil &&
+			!apierrors.IsNotFound(err) {
+			return false, errors.Wrapf(err, "failed to delete the previous job %s", name)
+		}
+
+		return false, nil
+	case apierrors.IsNotFound(err):
+		// rke2-server is only restarted on a node while the other servers are ready.
+		for i := range nodes.Items {
+			if !util.IsNodeReady(&nodes.Items[i]) {
+				return false, nil
+			}
+		}
+
+		if err := w.Client.Create(ctx, w.newRegistriesRefreshJob(name, node.Name, hash)); err != nil {
+			return false, errors.Wrapf(err, "failed to create job %s", name)
+		}
+
+		return false, nil
+	case err != nil:
+		return false, errors.Wrapf(err, "failed to get job %s", name)
+	case job.Status.Failed > 0 || jobFailed(job):
+		return false, fmt.Errorf("job %s/%s writing the registries configuration failed", HibernationNamespace, name)
+	case job.Status.Succeeded == 0 || !util.IsNodeReady(node):
+		return false, nil
+	}
+
+	patch := ctrlclient.MergeFrom(node.DeepCopy())
+	if node.Annotations == nil {
+		node.Annotations = map[string]string{}
+	}
+
+	node.Annotations[registriesRefreshHashAnnotation] = hash
+	if err := w.Client.Patch(ctx, node, patch); err != nil {
+		return false, errors.Wrapf(err, "failed to annotate node %s", node.Name)
+	}
+
+	if err := w.Client.Delete(ctx, job, ctrlclient.PropagationPolicy(metav1.DeletePropagationBackground)); err != nil &&
+		!apierrors.IsNotFound(err) {
+		return false, errors.Wrapf(err, "failed to delete job %s", name)
+	}
+
+	return false, nil
+}
+
+// ensureRegistriesRefreshSecret creates or updates the secret holding the registries configuration files and the
+// script of the registries refresh jobs.
+func (w *Workload) ensureRegistriesRefreshSecret(ctx context.Context, files []bootstrapv1.File) error {
+	secret := newRegistriesRefreshSecret(files)
+	existing := &corev1.Secret{}
+
+	err := w.Client.Get(ctx, ctrlclient.ObjectKeyFromObject(secret), existing)
+
+	switch {
+	case apierrors.IsNotFound(err):
+		if err := w.Client.Create(ctx, secret); err != nil {
+			return errors.Wrap(err, "failed to create the registries refresh secret")
+		}
+	case err != nil:
+		return errors.Wrap(err, "failed to get the registries refresh secret")
+	default:
+		existing.Data = secret.Data
+		if err := w.Client.Update(ctx, existing); err != nil {
+			return errors.Wrap(err, "failed to update the registries refresh secret")
+		}
+	}
+
+	return nil
+}
+
+// DeleteRegistriesRefresh deletes the jobs writing the registries configuration on the nodes, and their secret.
+func (w *Workload) DeleteRegistriesRefresh(ctx context.Context) error {
+	jobs := &batchv1.JobList{}
+	if err := w.Client.List(ctx, jobs, ctrlclient.InNamespace(HibernationNamespace),
+		ctrlclient.HasLabels{registriesRefreshJobLabel}); err != nil {
+		return errors.Wrap(err, "failed to list the registries refresh jobs")
+	}
+
+	errs := []error{}
+
+	for i := range jobs.Items {
+		if err := w.Client.Delete(ctx, &jobs.Items[i], ctrlclient.PropagationPolicy(metav1.DeletePropagationBackground)); err != nil &&
+			!apierrors.IsNotFound(err) {
+			errs = append(errs, errors.Wrapf(err, "failed to delete job %s", jobs.Items[i].Name))
+		}
+	}
+
+	secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: HibernationNamespace, Name: registriesRefreshName}}
+	if err := w.Client.Delete(ctx, secret); err != nil && !apierrors.IsNotFound(err) {
+		errs = append(errs, errors.Wrap(err, "failed to delete the registries refresh secret"))
+	}
+
+	return kerrors.NewAggregate(errs)
+}
+
+// registriesRefreshScript returns the script of the registries refresh jobs, copying the files of the secret to the
+// host and restarting rke2-server when one of them changed.
+func registriesRefreshScript(files []bootstrapv1.File) string {
+	script := []string{"set -e", "changed=false"}
+
+	for i, file := range files {
+		hostPath := registriesRefreshHostDir + file.Path
+		script = append(script, fmt.Sprintf("if ! cmp -s %[1]s/file-%[2]d %[3]s; then "+
+			"mkdir -p %[4]s && cp %[1]s/file-%[2]d %[3]s && chmod 0640 %[3]s && changed=true; fi",
+			registriesRefreshSecretDir, i, hostPath, path.Dir(hostPath)))
+	}
+
+	return strings.Join(append(script, "if $changed; then "+fmt.Sprintf(hostCommand, restartCommand)+"; fi"), "\n")
+}
+
+// newRegistriesRefreshSecret returns the secret holding the registries configuration files, along with the script of
+// the registries refresh jobs.
+func newRegistriesRefreshSecret(files []bootstrapv1.File) *corev1.Secret {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: HibernationNamespace, Name: registriesRefreshName},
+		Data:       map[string][]byte{registriesRefreshScriptKey: []byte(registriesRefreshScript(files))},
+	}
+
+	for i, file := range files {
+		secret.Data[fmt.Sprintf("file-%d", i)] = []byte(file.Content)
+	}
+
+	return secret
+}
+
+// newRegistriesRefreshJob returns the job running the registries refresh script on the node, with the secret holding
+// the files and the directory of the RKE2 configuration of the host mounted.
+func (w *Workload) newRegistriesRefreshJob(name, nodeName, hash string) *batchv1.Job {
+	job := w.newHostJob(name, nodeName, "sh "+registriesRefreshSecretDir+"/"+registriesRefreshScriptKey,
+		registriesRefreshJobLabel, "refresh")
+	job.Annotations = map[string]string{registriesRefreshHashAnnotation: hash}
+
+	podSpec := &job.Spec.Template.Spec
+	podSpec.Containers[0].VolumeMounts = []corev1.VolumeMount{
+		{Name: "registries", MountPath: registriesRefreshSecretDir, ReadOnly: true},
+		{Name: "rke2-config", MountPath: registriesRefreshHostDir + registriesRefreshConfigDir},
+	}
+	podSpec.Volumes = []corev1.Volume{
+		{Name: "registries", VolumeSource: corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{SecretName: registriesRefreshName},
+		}},
+		{Name: "rke2-config", VolumeSource: corev1.VolumeSource{
+			HostPath: &corev1.HostPathVolumeSource{Path: registriesRefreshConfigDir},
+		}},
+	}
+
+	return job
+}
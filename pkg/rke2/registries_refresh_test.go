@@ -0,0 +1,179 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rke2
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	bootstrapv1 "github.com/rancher-sandbox/cluster-api-provider-rke2/bootstrap/api/v1alpha1"
+)
+
+var _ = Describe("RefreshRegistries", func() {
+	var (
+		workload *Workload
+		files    []bootstrapv1.File
+	)
+
+	ctx := context.Background()
+	secretKey := types.NamespacedName{Namespace: HibernationNamespace, Name: registriesRefreshName}
+
+	jobs := func() []batchv1.Job {
+		jobs := &batchv1.JobList{}
+		Expect(workload.Client.List(ctx, jobs)).To(Succeed())
+
+		return jobs.Items
+	}
+
+	node := func(name string) *corev1.Node {
+		node := &corev1.Node{}
+		Expect(workload.Client.Get(ctx, client.ObjectKey{Name: name}, node)).To(Succeed())
+
+		return node
+	}
+
+	setReady := func(name string, ready corev1.ConditionStatus) {
+		n := node(name)
+		n.Status.Conditions = []corev1.NodeCondition{{Type: corev1.NodeReady, Status: ready}}
+		Expect(workload.Client.Status().Update(ctx, n)).To(Succeed())
+	}
+
+	refresh := func() bool {
+		refreshed, err := workload.RefreshRegistries(ctx, files)
+		Expect(err).ToNot(HaveOccurred())
+
+		return refreshed
+	}
+
+	completeJob := func(name string) {
+		job := &batchv1.Job{}
+		Expect(workload.Client.Get(ctx, client.ObjectKey{Namespace: HibernationNamespace, Name: name}, job)).To(Succeed())
+
+		job.Status.Succeeded = 1
+		Expect(workload.Client.Status().Update(ctx, job)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		nodes := []client.Object{}
+		for _, name := range []string{"node-1", "node-2"} {
+			nodes = append(nodes, &corev1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{labelNodeRoleControlPlane: "true"}},
+				Status:     corev1.NodeStatus{Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}}},
+			})
+		}
+
+		workload = &Workload{Client: fake.NewClientBuilder().WithObjects(nodes...).Build()}
+		files = RegistriesFiles([]byte("configs: {}\n"), []bootstrapv1.File{
+			{Path: registryCertsPath + "/ca.crt", Content: "ca"},
+		})
+	})
+
+	It("should write the files on the servers and restart rke2-server when they changed", func() {
+		Expect(refresh()).To(BeFalse())
+
+		secret := &corev1.Secret{}
+		Expect(workload.Client.Get(ctx, secretKey, secret)).To(Succeed())
+		Expect(secret.Data).To(HaveKeyWithValue("file-0", []byte("ca")))
+		Expect(secret.Data).To(HaveKeyWithValue("file-1", []byte("configs: {}\n")))
+		Expect(string(secret.Data[registriesRefreshScriptKey])).To(ContainSubstring("/host/etc/rancher/rke2/tls/ca.crt"))
+		Expect(string(secret.Data[registriesRefreshScriptKey])).To(ContainSubstring(restartCommand))
+
+		Expect(jobs()).To(HaveLen(1))
+		Expect(jobs()[0].Name).To(Equal(registriesRefreshJobPrefix + "node-1"))
+		Expect(jobs()[0].Spec.Template.Spec.NodeName).To(Equal("node-1"))
+		Expect(jobs()[0].Annotations).To(HaveKeyWithValue(registriesRefreshHashAnnotation, RegistriesHash(files)))
+	})
+
+	It("should refresh the nodes one at a time, once the servers are ready, and delete the jobs", func() {
+		Expect(refresh()).To(BeFalse())
+
+		// The node is only marked refreshed once it is ready again.
+		setReady("node-1", corev1.ConditionFalse)
+		completeJob(registriesRefreshJobPrefix + "node-1")
+		Expect(refresh()).To(BeFalse())
+		Expect(node("node-1").Annotations).ToNot(HaveKey(registriesRefreshHashAnnotation))
+
+		setReady("node-1", corev1.ConditionTrue)
+		Expect(refresh()).To(BeFalse())
+		Expect(node("node-1").Annotations).To(HaveKeyWithValue(registriesRefreshHashAnnotation, RegistriesHash(files)))
+		Expect(jobs()).To(BeEmpty())
+
+		// The next node isn't refreshed while a server is not ready.
+		setReady("node-1", corev1.ConditionFalse)
+		Expect(refresh()).To(BeFalse())
+		Expect(jobs()).To(BeEmpty())
+
+		setReady("node-1", corev1.ConditionTrue)
+		Expect(refresh()).To(BeFalse())
+		Expect(jobs()).To(HaveLen(1))
+		Expect(jobs()[0].Spec.Template.Spec.NodeName).To(Equal("node-2"))
+
+		completeJob(registriesRefreshJobPrefix + "node-2")
+		Expect(refresh()).To(BeFalse())
+		Expect(refresh()).To(BeTrue())
+		Expect(jobs()).To(BeEmpty())
+
+		err := workload.Client.Get(ctx, secretKey, &corev1.Secret{})
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+	})
+
+	It("should replace the job when the files change", func() {
+		Expect(refresh()).To(BeFalse())
+
+		files[0].Content = "rotated"
+
+		Expect(refresh()).To(BeFalse())
+		Expect(jobs()).To(BeEmpty())
+
+		Expect(refresh()).To(BeFalse())
+		Expect(jobs()).To(HaveLen(1))
+		Expect(jobs()[0].Annotations).To(HaveKeyWithValue(registriesRefreshHashAnnotation, RegistriesHash(files)))
+	})
+
+	It("should report a failed job", func() {
+		Expect(refresh()).To(BeFalse())
+
+		job := &jobs()[0]
+		job.Status.Failed = 1
+		Expect(workload.Client.Status().Update(ctx, job)).To(Succeed())
+
+		_, err := workload.RefreshRegistries(ctx, files)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should delete the jobs and their secret", func() {
+		Expect(refresh()).To(BeFalse())
+
+		Expect(workload.DeleteRegistriesRefresh(ctx)).To(Succeed())
+		Expect(workload.DeleteRegistriesRefresh(ctx)).To(Succeed())
+
+		Expect(jobs()).To(BeEmpty())
+
+		err := workload.Client.Get(ctx, secretKey, &corev1.Secret{})
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+	})
+})
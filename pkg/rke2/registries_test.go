@@ -24,6 +24,7 @@ import (
 
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 
@@ -141,6 +142,24 @@ var _ = Describe("RKE2RegistryConfig", func() {
 		Expect(registryResult.Configs["https://test-registry"].TLS.KeyFile).To(Equal(registryCertsPath + "/" + "tls.key"))
 		Expect(registryResult.Configs["https://test-registry"].TLS.InsecureSkipVerify).To(BeTrue())
 	})
+
+	It("should hash the registry secrets so that their rotation is detected", func() {
+		hash, err := RegistrySecretsHash(rke2ConfigReg.Ctx, rke2ConfigReg.Client, rke2ConfigReg.Registry)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(hash).To(HaveLen(registrySecretsHashLength))
+
+		authSecret := &corev1.Secret{}
+		Expect(rke2ConfigReg.Client.Get(rke2ConfigReg.Ctx,
+			types.NamespacedName{Namespace: "test-ns", Name: "test-auth-secret"}, authSecret)).To(Succeed())
+		authSecret.Data["password"] = []byte("rotated-password")
+		Expect(rke2ConfigReg.Client.Update(rke2ConfigReg.Ctx, authSecret)).To(Succeed())
+
+		rotated, err := RegistrySecretsHash(rke2ConfigReg.Ctx, rke2ConfigReg.Client, rke2ConfigReg.Registry)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(rotated).ToNot(Equal(hash))
+
+		Expect(RegistrySecretsHash(rke2ConfigReg.Ctx, rke2ConfigReg.Client, bootstrapv1.Registry{})).To(BeEmpty())
+	})
 },
 )
 
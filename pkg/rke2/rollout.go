@@ -0,0 +1,233 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rke2
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/cluster-api/util/collections"
+
+	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+)
+
+// RolloutReplicas returns the number of replicas to keep while the control plane is rolled out, given the desired
+// replicas and the replicas kept so far by the rollout, along with the desired replicas queued until the rollout
+// completes. A scale up is applied right away, as it only adds up to date machines and etcd members, whereas a scale
+// down is queued so that the etcd quorum isn't reduced while the outdated machines are replaced.
+func RolloutReplicas(desired int32, rolloutReplicas *int32) (int32, *int32) {
+	if rolloutReplicas == nil || desired >= *rolloutReplicas {
+		return desired, nil
+	}
+
+	return *rolloutReplicas, &desired
+}
+
+// RolloutStep is the next step of a rolling update of the control plane machines.
+type RolloutStep string
+
+const (
+	// RolloutStepScaleUp creates the replacement of an outdated machine.
+	RolloutStepScaleUp RolloutStep = "ScaleUp"
+
+	// RolloutStepWaitForNode waits for the node of a new machine to join the workload cluster.
+	RolloutStepWaitForNode RolloutStep = "WaitForNode"
+
+	// RolloutStepScaleDown deletes an outdated machine.
+	RolloutStepScaleDown RolloutStep = "ScaleDown"
+
+	// RolloutStepWaitForDeletion waits for an outdated machine to be deleted by a user or a MachineHealthCheck.
+	RolloutStepWaitForDeletion RolloutStep = "WaitForDeletion"
+)
+
+// NextRolloutStep returns the next step of a rolling update, given the number of machines of the control plane, the
+// number of their nodes in the workload cluster, and the desired replicas and surge. With a surge of 1, a new machine
+// is created before an outdated one is deleted. With a surge of 0, for infrastructures without capacity for an
+// additional machine, an outdated machine is deleted before its replacement is created. Either way, no other machine
+// is created or deleted until the node of the new machine joins, as only one machine is replaced at a time.
+func NextRolloutStep(machines, nodes, replicas, maxSurge int32) RolloutStep {
+	switch {
+	case machines < replicas+maxSurge:
+		return RolloutStepScaleUp
+	case nodes < machines:
+		return RolloutStepWaitForNode
+	default:
+		return RolloutStepScaleDown
+	}
+}
+
+// NextOnDeleteRolloutStep returns the next step of a rollout with the OnDelete strategy, given the number of machines
+// of the control plane, the number of their nodes in the workload cluster, and the desired replicas. The outdated
+// machines are only replaced once deleted, and are only deleted by the controller to scale the control plane down.
+func NextOnDeleteRolloutStep(machines, nodes, replicas int32) RolloutStep {
+	switch {
+	case machines < replicas:
+		return RolloutStepScaleUp
+	case nodes < machines:
+		return RolloutStepWaitForNode
+	case machines > replicas:
+		return RolloutStepScaleDown
+	default:
+		return RolloutStepWaitForDeletion
+	}
+}
+
+// UpgradeSnapshotName returns the name of the etcd snapshot to take before rolling the machines out, or an empty name
+// when the rollout is not an upgrade to another RKE2 version or when the snapshot is disabled. The name is derived
+// from the version, so a single snapshot is taken per upgrade.
+func UpgradeSnapshotName(rcp *controlplanev1.RKE2ControlPlane, needRollout collections.Machines) string {
+	if rcp.Spec.ServerConfig.Etcd.BackupConfig.DisableUpgradeSnapshot {
+		return ""
+	}
+
+	version := rcp.TargetVersion()
+	if needRollout.Filter(collections.Not(collections.MatchesKubernetesVersion(version))).Len() == 0 {
+		return ""
+	}
+
+	return fmt.Sprintf("%s-upgrade-%s", rcp.Name, strings.NewReplacer("+", "-", ".", "-").Replace(version))
+}
+
+// rolloutSpecHashLength is the number of hexadecimal digits of the spec hash recorded by a rollout trigger.
+const rolloutSpecHashLength = 16
+
+// rolloutFields are the fields, as paths of the managed fields, whose change triggers a rollout for each reason.
+var rolloutFields = map[controlplanev1.RolloutReason][][]string{
+	controlplanev1.SpecChangedRolloutReason: {
+		{"f:spec", "f:agentConfig"},
+		{"f:spec", "f:serverConfig"},
+		{"f:spec", "f:infrastructureRef"},
+	},
+	controlplanev1.RestartRolloutReason: {
+		{"f:metadata", "f:annotations", "f:" + controlplanev1.RestartAnnotation},
+	},
+	controlplanev1.RolloutAfterRolloutReason: {
+		{"f:spec", "f:rolloutAfter"},
+	},
+}
+
+// rolloutSpec are the fields of the spec of the control plane which roll the machines out when they change, as
+// compared with the machines by the rollout.
+type rolloutSpec struct {
+	AgentConfig            interface{} `json:"agentConfig"`
+	ServerConfig           interface{} `json:"serverConfig"`
+	InfrastructureTemplate string      `json:"infrastructureTemplate"`
+}
+
+// NewRolloutTrigger returns the trigger of a rollout of the control plane machines for the given reason starting at
+// the given time: the hash of the fields of the spec rolling the machines out, and the manager which last changed the
+// fields driving the rollout for that reason, so that an autoscaler scaling the control plane during the rollout, or
+// a manager of unrelated fields, isn't blamed for it.
+func NewRolloutTrigger(
+	rcp *controlplanev1.RKE2ControlPlane,
+	reason controlplanev1.RolloutReason,
+	now metav1.Time,
+) (*controlplanev1.RolloutTrigger, error) {
+	specHash, err := RolloutSpecHash(rcp)
+	if err != nil {
+		return nil, err
+	}
+
+	trigger := &controlplanev1.RolloutTrigger{
+		Reason:      reason,
+		SpecHash:    specHash,
+		RolloutTime: now,
+	}
+
+	if entry := rolloutFieldManager(rcp, rolloutFields[reason]); entry != nil {
+		trigger.FieldManager = entry.Manager
+		trigger.Operation = entry.Operation
+		trigger.ChangeTime = entry.Time
+	}
+
+	return trigger, nil
+}
+
+// RolloutSpecHash returns the hash of the fields of the spec of the control plane which roll the machines out when
+// they change: the agent and server configurations and the infrastructure template. The other fields, e.g. the
+// replicas or the remediation, don't change the hash.
+func RolloutSpecHash(rcp *controlplanev1.RKE2ControlPlane) (string, error) {
+	spec := rolloutSpec{
+		AgentConfig:            rcp.TargetAgentConfig(),
+		ServerConfig:           rcp.Spec.ServerConfig,
+		InfrastructureTemplate: rcp.Spec.InfrastructureRef.GroupVersionKind().GroupKind().String() + "/" + rcp.Spec.InfrastructureRef.Name,
+	}
+
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to marshal the control plane spec")
+	}
+
+	sum := sha256.Sum256(data)
+
+	return hex.EncodeToString(sum[:])[:rolloutSpecHashLength], nil
+}
+
+// rolloutFieldManager returns the managed fields entry which most recently changed one of the fields of the control
+// plane, or nil when no entry records a time.
+func rolloutFieldManager(rcp *controlplanev1.RKE2ControlPlane, fields [][]string) *metav1.ManagedFieldsEntry {
+	var latest *metav1.ManagedFieldsEntry
+
+	for i := range rcp.ManagedFields {
+		entry := &rcp.ManagedFields[i]
+		if entry.Subresource != "" || entry.Time == nil || entry.FieldsV1 == nil {
+			continue
+		}
+
+		if !managesAnyField(entry.FieldsV1.Raw, fields) {
+			continue
+		}
+
+		if latest == nil || latest.Time.Before(entry.Time) {
+			latest = entry
+		}
+	}
+
+	return latest
+}
+
+// managesAnyField returns whether the managed fields own one of the fields, or one of their subfields.
+func managesAnyField(managedFields json.RawMessage, fields [][]string) bool {
+	for _, path := range fields {
+		if managesField(managedFields, path) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// managesField returns whether the managed fields own the field at the path, or one of its subfields.
+func managesField(managedFields json.RawMessage, path []string) bool {
+	if len(path) == 0 {
+		return true
+	}
+
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(managedFields, &fields); err != nil {
+		return false
+	}
+
+	field, ok := fields[path[0]]
+
+	return ok && managesField(field, path[1:])
+}
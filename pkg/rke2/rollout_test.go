@@ -0,0 +1,224 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rke2
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/pointer"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util/collections"
+
+	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+)
+
+var _ = Describe("RolloutReplicas", func() {
+	It("should keep the desired replicas when a rollout starts", func() {
+		replicas, queued := RolloutReplicas(3, nil)
+		Expect(replicas).To(Equal(int32(3)))
+		Expect(queued).To(BeNil())
+	})
+
+	It("should scale up during a rollout", func() {
+		replicas, queued := RolloutReplicas(5, pointer.Int32(3))
+		Expect(replicas).To(Equal(int32(5)))
+		Expect(queued).To(BeNil())
+	})
+
+	It("should queue a scale down until the rollout completes", func() {
+		replicas, queued := RolloutReplicas(3, pointer.Int32(5))
+		Expect(replicas).To(Equal(int32(5)))
+		Expect(queued).To(Equal(pointer.Int32(3)))
+	})
+})
+
+var _ = Describe("UpgradeSnapshotName", func() {
+	var rcp *controlplanev1.RKE2ControlPlane
+
+	newMachine := func(name, version string) *clusterv1.Machine {
+		return &clusterv1.Machine{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       clusterv1.MachineSpec{Version: pointer.String(version)},
+		}
+	}
+
+	BeforeEach(func() {
+		rcp = &controlplanev1.RKE2ControlPlane{ObjectMeta: metav1.ObjectMeta{Name: "rcp"}}
+		rcp.Spec.AgentConfig.Version = "v1.26.4+rke2r1"
+	})
+
+	It("should name the snapshot after the version the machines are upgraded to", func() {
+		needRollout := collections.FromMachines(newMachine("m1", "v1.25.9+rke2r1"), newMachine("m2", "v1.26.4+rke2r1"))
+
+		Expect(UpgradeSnapshotName(rcp, needRollout)).To(Equal("rcp-upgrade-v1-26-4-rke2r1"))
+	})
+
+	It("should not take a snapshot when the machines are rolled out to the same version", func() {
+		needRollout := collections.FromMachines(newMachine("m1", "v1.26.4+rke2r1"))
+
+		Expect(UpgradeSnapshotName(rcp, needRollout)).To(BeEmpty())
+	})
+
+	It("should not take a snapshot when disabled", func() {
+		rcp.Spec.ServerConfig.Etcd.BackupConfig.DisableUpgradeSnapshot = true
+		needRollout := collections.FromMachines(newMachine("m1", "v1.25.9+rke2r1"))
+
+		Expect(UpgradeSnapshotName(rcp, needRollout)).To(BeEmpty())
+	})
+})
+
+var _ = Describe("NewRolloutTrigger", func() {
+	var rcp *controlplanev1.RKE2ControlPlane
+
+	now := metav1.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	managedFields := func(manager string, operation metav1.ManagedFieldsOperationType, hour int, fields string) metav1.ManagedFieldsEntry {
+		changeTime := metav1.Date(2023, 5, 1, hour, 0, 0, 0, time.UTC)
+
+		return metav1.ManagedFieldsEntry{
+			Manager:   manager,
+			Operation: operation,
+			Time:      &changeTime,
+			FieldsV1:  &metav1.FieldsV1{Raw: []byte(fields)},
+		}
+	}
+
+	BeforeEach(func() {
+		rcp = &controlplanev1.RKE2ControlPlane{ObjectMeta: metav1.ObjectMeta{Name: "rcp"}}
+		rcp.Spec.Replicas = pointer.Int32(3)
+		rcp.Spec.AgentConfig.Version = "v1.26.4+rke2r1"
+	})
+
+	It("should attribute the rollout to the last manager of the spec", func() {
+		rcp.ManagedFields = []metav1.ManagedFieldsEntry{
+			managedFields("argocd-controller", metav1.ManagedFieldsOperationApply, 9,
+				`{"f:spec":{"f:agentConfig":{"f:version":{}}}}`),
+			managedFields("kubectl-edit", metav1.ManagedFieldsOperationUpdate, 10,
+				`{"f:spec":{"f:agentConfig":{"f:version":{}}}}`),
+		}
+
+		trigger, err := NewRolloutTrigger(rcp, controlplanev1.SpecChangedRolloutReason, now)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(trigger.Reason).To(Equal(controlplanev1.SpecChangedRolloutReason))
+		Expect(trigger.SpecHash).To(HaveLen(16))
+		Expect(trigger.FieldManager).To(Equal("kubectl-edit"))
+		Expect(trigger.Operation).To(Equal(metav1.ManagedFieldsOperationUpdate))
+		Expect(trigger.ChangeTime.Hour()).To(Equal(10))
+		Expect(trigger.RolloutTime).To(Equal(now))
+	})
+
+	It("should not attribute the rollout to a manager of the replicas or the status", func() {
+		rcp.ManagedFields = []metav1.ManagedFieldsEntry{
+			managedFields("argocd-controller", metav1.ManagedFieldsOperationApply, 9,
+				`{"f:spec":{".":{},"f:agentConfig":{"f:version":{}},"f:replicas":{}}}`),
+			managedFields("autoscaler", metav1.ManagedFieldsOperationUpdate, 10, `{"f:spec":{"f:replicas":{}}}`),
+			managedFields("metadata-editor", metav1.ManagedFieldsOperationUpdate, 11, `{"f:metadata":{"f:labels":{}}}`),
+			{
+				Manager:     "rke2-control-plane-controller",
+				Operation:   metav1.ManagedFieldsOperationUpdate,
+				Subresource: "status",
+				Time:        &now,
+				FieldsV1:    &metav1.FieldsV1{Raw: []byte(`{"f:spec":{"f:agentConfig":{}}}`)},
+			},
+		}
+
+		trigger, err := NewRolloutTrigger(rcp, controlplanev1.SpecChangedRolloutReason, now)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(trigger.FieldManager).To(Equal("argocd-controller"))
+		Expect(trigger.Operation).To(Equal(metav1.ManagedFieldsOperationApply))
+	})
+
+	It("should leave the manager unknown without managed fields", func() {
+		trigger, err := NewRolloutTrigger(rcp, controlplanev1.SpecChangedRolloutReason, now)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(trigger.FieldManager).To(BeEmpty())
+		Expect(trigger.ChangeTime).To(BeNil())
+	})
+
+	It("should attribute a restart to the manager of the restart annotation", func() {
+		rcp.ManagedFields = []metav1.ManagedFieldsEntry{
+			managedFields("argocd-controller", metav1.ManagedFieldsOperationApply, 11,
+				`{"f:spec":{"f:agentConfig":{"f:version":{}}}}`),
+			managedFields("kubectl-annotate", metav1.ManagedFieldsOperationUpdate, 10,
+				`{"f:metadata":{"f:annotations":{"f:controlplane.cluster.x-k8s.io/restart":{}}}}`),
+		}
+
+		trigger, err := NewRolloutTrigger(rcp, controlplanev1.RestartRolloutReason, now)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(trigger.Reason).To(Equal(controlplanev1.RestartRolloutReason))
+		Expect(trigger.FieldManager).To(Equal("kubectl-annotate"))
+
+		trigger, err = NewRolloutTrigger(rcp, controlplanev1.CertificatesExpiryRolloutReason, now)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(trigger.FieldManager).To(BeEmpty())
+	})
+
+	It("should only hash the fields of the spec which roll the machines out", func() {
+		specHash, err := RolloutSpecHash(rcp)
+		Expect(err).ToNot(HaveOccurred())
+
+		rcp.Spec.Replicas = pointer.Int32(5)
+		rcp.Spec.Protected = true
+		rcp.Spec.RolloutStrategy = &controlplanev1.RolloutStrategy{Type: controlplanev1.OnDeleteStrategyType}
+		Expect(RolloutSpecHash(rcp)).To(Equal(specHash))
+
+		rcp.Spec.InfrastructureRef.Name = "template-2"
+		Expect(RolloutSpecHash(rcp)).ToNot(Equal(specHash))
+		rcp.Spec.InfrastructureRef.Name = ""
+
+		rcp.Spec.AgentConfig.Version = "v1.27.1+rke2r1"
+		Expect(RolloutSpecHash(rcp)).ToNot(Equal(specHash))
+	})
+})
+
+var _ = Describe("NextRolloutStep", func() {
+	It("should create a new machine before deleting an outdated one with a surge", func() {
+		Expect(NextRolloutStep(3, 3, 3, 1)).To(Equal(RolloutStepScaleUp))
+		Expect(NextRolloutStep(4, 3, 3, 1)).To(Equal(RolloutStepWaitForNode))
+		Expect(NextRolloutStep(4, 4, 3, 1)).To(Equal(RolloutStepScaleDown))
+	})
+
+	It("should delete an outdated machine before creating its replacement without a surge", func() {
+		Expect(NextRolloutStep(3, 3, 3, 0)).To(Equal(RolloutStepScaleDown))
+		Expect(NextRolloutStep(2, 2, 3, 0)).To(Equal(RolloutStepScaleUp))
+		Expect(NextRolloutStep(3, 2, 3, 0)).To(Equal(RolloutStepWaitForNode))
+	})
+
+	It("should replace a machine when the rollout scales the control plane up", func() {
+		Expect(NextRolloutStep(3, 3, 5, 0)).To(Equal(RolloutStepScaleUp))
+	})
+})
+
+var _ = Describe("NextOnDeleteRolloutStep", func() {
+	It("should wait for an outdated machine to be deleted", func() {
+		Expect(NextOnDeleteRolloutStep(3, 3, 3)).To(Equal(RolloutStepWaitForDeletion))
+	})
+
+	It("should replace a deleted machine once the nodes joined", func() {
+		Expect(NextOnDeleteRolloutStep(2, 2, 3)).To(Equal(RolloutStepScaleUp))
+		Expect(NextOnDeleteRolloutStep(3, 2, 3)).To(Equal(RolloutStepWaitForNode))
+	})
+
+	It("should scale the control plane down", func() {
+		Expect(NextOnDeleteRolloutStep(4, 4, 3)).To(Equal(RolloutStepScaleDown))
+	})
+})
@@ -0,0 +1,189 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rke2
+
+import (
+	"reflect"
+	"strings"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/yaml"
+
+	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+)
+
+// helmChartGroupKind is the kind of the HelmChart resources of the RKE2 Helm controller.
+var helmChartGroupKind = schema.GroupKind{Group: "helm.cattle.io", Kind: "HelmChart"}
+
+// podSpecFields are the fields of the pod specs of the workload kinds.
+var podSpecFields = map[schema.GroupKind][]string{
+	{Kind: "Pod"}:                        {"spec"},
+	{Group: "apps", Kind: "Deployment"}:  {"spec", "template", "spec"},
+	{Group: "apps", Kind: "DaemonSet"}:   {"spec", "template", "spec"},
+	{Group: "apps", Kind: "StatefulSet"}: {"spec", "template", "spec"},
+	{Group: "apps", Kind: "ReplicaSet"}:  {"spec", "template", "spec"},
+	{Group: "batch", Kind: "Job"}:        {"spec", "template", "spec"},
+	{Group: "batch", Kind: "CronJob"}:    {"spec", "jobTemplate", "spec", "template", "spec"},
+	{Kind: "ReplicationController"}:      {"spec", "template", "spec"},
+}
+
+// ApplyWorkloadScheduling adds the scheduling to the pod spec of a workload, or to the values of a HelmChart, as the
+// tolerations, nodeSelector and priorityClassName values most charts take. The other objects are left unchanged.
+func ApplyWorkloadScheduling(obj *unstructured.Unstructured, scheduling *controlplanev1.WorkloadScheduling) error {
+	if scheduling == nil {
+		return nil
+	}
+
+	groupKind := obj.GroupVersionKind().GroupKind()
+
+	if groupKind == helmChartGroupKind {
+		return applyChartScheduling(obj, scheduling)
+	}
+
+	fields, ok := podSpecFields[groupKind]
+	if !ok {
+		return nil
+	}
+
+	podSpec, _, err := unstructured.NestedMap(obj.Object, fields...)
+	if err != nil {
+		return errors.Wrapf(err, "invalid pod spec in %s %s", groupKind.Kind, obj.GetName())
+	}
+
+	if podSpec == nil {
+		podSpec = map[string]interface{}{}
+	}
+
+	if err := mergeWorkloadScheduling(podSpec, scheduling); err != nil {
+		return errors.Wrapf(err, "failed to schedule %s %s", groupKind.Kind, obj.GetName())
+	}
+
+	return unstructured.SetNestedMap(obj.Object, podSpec, fields...)
+}
+
+// applyChartScheduling adds the scheduling to the values of a HelmChart.
+func applyChartScheduling(obj *unstructured.Unstructured, scheduling *controlplanev1.WorkloadScheduling) error {
+	valuesContent, _, err := unstructured.NestedString(obj.Object, "spec", "valuesContent")
+	if err != nil {
+		return errors.Wrapf(err, "invalid values of HelmChart %s", obj.GetName())
+	}
+
+	values := map[string]interface{}{}
+	if err := yaml.Unmarshal([]byte(valuesContent), &values); err != nil {
+		return errors.Wrapf(err, "failed to parse the values of HelmChart %s", obj.GetName())
+	}
+
+	if values == nil {
+		values = map[string]interface{}{}
+	}
+
+	if err := mergeWorkloadScheduling(values, scheduling); err != nil {
+		return errors.Wrapf(err, "failed to schedule HelmChart %s", obj.GetName())
+	}
+
+	content, err := yaml.Marshal(values)
+	if err != nil {
+		return errors.Wrapf(err, "failed to marshal the values of HelmChart %s", obj.GetName())
+	}
+
+	return unstructured.SetNestedField(obj.Object, string(content), "spec", "valuesContent")
+}
+
+// mergeWorkloadScheduling merges the scheduling into a pod spec, or chart values: the tolerations are added unless
+// already present, the node selector labels and the priority class override the existing ones.
+func mergeWorkloadScheduling(spec map[string]interface{}, scheduling *controlplanev1.WorkloadScheduling) error {
+	if len(scheduling.Tolerations) > 0 {
+		tolerations, _, err := unstructured.NestedSlice(spec, "tolerations")
+		if err != nil {
+			return err
+		}
+
+		for i := range scheduling.Tolerations {
+			toleration, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&scheduling.Tolerations[i])
+			if err != nil {
+				return err
+			}
+
+			if !containsValue(tolerations, toleration) {
+				tolerations = append(tolerations, toleration)
+			}
+		}
+
+		spec["tolerations"] = tolerations
+	}
+
+	if len(scheduling.NodeSelector) > 0 {
+		nodeSelector, _, err := unstructured.NestedMap(spec, "nodeSelector")
+		if err != nil {
+			return err
+		}
+
+		if nodeSelector == nil {
+			nodeSelector = map[string]interface{}{}
+		}
+
+		for key, value := range scheduling.NodeSelector {
+			nodeSelector[key] = value
+		}
+
+		spec["nodeSelector"] = nodeSelector
+	}
+
+	if scheduling.PriorityClassName != "" {
+		spec["priorityClassName"] = scheduling.PriorityClassName
+	}
+
+	return nil
+}
+
+func containsValue(values []interface{}, value interface{}) bool {
+	for _, v := range values {
+		if reflect.DeepEqual(v, value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// scheduleManifests adds the scheduling to the workloads of the manifests, which are returned as YAML documents.
+func scheduleManifests(manifests string, scheduling *controlplanev1.WorkloadScheduling) (string, error) {
+	objs, err := ParseManifests(manifests)
+	if err != nil {
+		return "", err
+	}
+
+	documents := make([]string, 0, len(objs))
+
+	for _, obj := range objs {
+		if err := ApplyWorkloadScheduling(obj, scheduling); err != nil {
+			return "", err
+		}
+
+		document, err := yaml.Marshal(obj.Object)
+		if err != nil {
+			return "", errors.Wrapf(err, "failed to marshal %s %s", obj.GetKind(), obj.GetName())
+		}
+
+		documents = append(documents, string(document))
+	}
+
+	return strings.Join(documents, "---\n"), nil
+}
@@ -0,0 +1,135 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rke2
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/utils/pointer"
+
+	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+)
+
+var _ = Describe("ApplyWorkloadScheduling", func() {
+	var scheduling *controlplanev1.WorkloadScheduling
+
+	BeforeEach(func() {
+		scheduling = &controlplanev1.WorkloadScheduling{
+			Tolerations: []corev1.Toleration{
+				{Key: "node-role.kubernetes.io/control-plane", Operator: corev1.TolerationOpExists, Effect: corev1.TaintEffectNoSchedule},
+				{Key: "dedicated", Value: "infra", Effect: corev1.TaintEffectNoExecute, TolerationSeconds: pointer.Int64(60)},
+			},
+			NodeSelector:      map[string]string{"node-role.kubernetes.io/infra": "true"},
+			PriorityClassName: "system-cluster-critical",
+		}
+	})
+
+	It("should schedule the pods of the workloads", func() {
+		objs, err := ParseManifests(`
+apiVersion: apps/v1
+kind: DaemonSet
+metadata:
+  name: ccm
+spec:
+  template:
+    spec:
+      nodeSelector:
+        kubernetes.io/os: linux
+        node-role.kubernetes.io/infra: "false"
+      tolerations:
+      - key: node-role.kubernetes.io/control-plane
+        operator: Exists
+        effect: NoSchedule
+      containers:
+      - name: ccm
+        image: ccm
+---
+apiVersion: batch/v1
+kind: CronJob
+metadata:
+  name: cleanup
+spec:
+  jobTemplate:
+    spec:
+      template:
+        spec:
+          containers:
+          - name: cleanup
+            image: cleanup
+---
+apiVersion: v1
+kind: ServiceAccount
+metadata:
+  name: ccm
+`)
+		Expect(err).ToNot(HaveOccurred())
+
+		for _, obj := range objs {
+			Expect(ApplyWorkloadScheduling(obj, scheduling)).To(Succeed())
+		}
+
+		podSpec, _, err := unstructured.NestedMap(objs[0].Object, "spec", "template", "spec")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(podSpec["nodeSelector"]).To(Equal(map[string]interface{}{
+			"kubernetes.io/os":              "linux",
+			"node-role.kubernetes.io/infra": "true",
+		}))
+		Expect(podSpec["tolerations"]).To(HaveLen(2))
+		Expect(podSpec["tolerations"]).To(ContainElement(HaveKeyWithValue("tolerationSeconds", BeEquivalentTo(60))))
+		Expect(podSpec["priorityClassName"]).To(Equal("system-cluster-critical"))
+		Expect(podSpec["containers"]).To(HaveLen(1))
+
+		priorityClassName, _, err := unstructured.NestedString(objs[1].Object,
+			"spec", "jobTemplate", "spec", "template", "spec", "priorityClassName")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(priorityClassName).To(Equal("system-cluster-critical"))
+
+		Expect(objs[2].Object).ToNot(HaveKey("spec"))
+	})
+
+	It("should add the scheduling to the values of the charts", func() {
+		chart := HelmChartManifest(&controlplanev1.RKE2AddOnChart{
+			Name:          "app",
+			Chart:         "oci://registry.example.com/charts/app",
+			ValuesContent: "replicas: 2\nnodeSelector:\n  kubernetes.io/os: linux\n",
+		})
+
+		Expect(ApplyWorkloadScheduling(chart, scheduling)).To(Succeed())
+
+		valuesContent, _, err := unstructured.NestedString(chart.Object, "spec", "valuesContent")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(valuesContent).To(ContainSubstring("replicas: 2"))
+		Expect(valuesContent).To(ContainSubstring("kubernetes.io/os: linux"))
+		Expect(valuesContent).To(ContainSubstring(`node-role.kubernetes.io/infra: "true"`))
+		Expect(valuesContent).To(ContainSubstring("priorityClassName: system-cluster-critical"))
+		Expect(valuesContent).To(ContainSubstring("key: dedicated"))
+	})
+
+	It("should schedule the pods of bare manifests, unless there is no scheduling", func() {
+		manifests, err := scheduleManifests("apiVersion: v1\nkind: Pod\nmetadata:\n  name: pod\n", scheduling)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(manifests).To(ContainSubstring("priorityClassName: system-cluster-critical"))
+
+		pod, err := ParseManifests("apiVersion: v1\nkind: Pod\nmetadata:\n  name: pod\n")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ApplyWorkloadScheduling(pod[0], nil)).To(Succeed())
+		Expect(pod[0].Object).ToNot(HaveKey("spec"))
+	})
+})
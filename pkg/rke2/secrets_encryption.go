@@ -0,0 +1,186 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rke2
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/utils/pointer"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util/collections"
+
+	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+)
+
+const (
+	// secretsEncryptionJobLabel is the label set on the workload cluster jobs rotating the secrets encryption key.
+	secretsEncryptionJobLabel = "controlplane.cluster.x-k8s.io/secrets-encryption"
+
+	// secretsEncryptionStepAnnotation is the annotation set on the secrets encryption jobs with the step they run.
+	secretsEncryptionStepAnnotation = "controlplane.cluster.x-k8s.io/secrets-encryption-step"
+
+	secretsEncryptionJobName          = "rke2-secrets-encrypt"
+	secretsEncryptionRestartJobPrefix = "rke2-secrets-encrypt-restart-"
+
+	// secretsEncryptionJobDeadline is how long, in seconds, a secrets encryption job may run before it is failed, the
+	// reencryption of all the secrets of a large cluster may take a while.
+	secretsEncryptionJobDeadline = 3600
+
+	// restartCommand restarts rke2-server, the containers keep running as the service only kills its main process.
+	restartCommand = "systemctl restart rke2-server.service"
+)
+
+// secretsEncryptionKeyRotationStages are the stages of the rotation of the secrets encryption key, in order.
+var secretsEncryptionKeyRotationStages = []controlplanev1.SecretsEncryptionKeyRotationStage{
+	controlplanev1.PrepareSecretsEncryptionKeyRotationStage,
+	controlplanev1.PrepareRestartSecretsEncryptionKeyRotationStage,
+	controlplanev1.RotateSecretsEncryptionKeyRotationStage,
+	controlplanev1.RotateRestartSecretsEncryptionKeyRotationStage,
+	controlplanev1.ReencryptSecretsEncryptionKeyRotationStage,
+	controlplanev1.ReencryptRestartSecretsEncryptionKeyRotationStage,
+	controlplanev1.CompletedSecretsEncryptionKeyRotationStage,
+}
+
+// secretsEncryptionCommands are the "rke2 secrets-encrypt" commands run by the stages of the rotation. The
+// reencryption carries on in the background once the command returns, so it is waited for.
+var secretsEncryptionCommands = map[controlplanev1.SecretsEncryptionKeyRotationStage]string{
+	controlplanev1.PrepareSecretsEncryptionKeyRotationStage: "prepare",
+	controlplanev1.RotateSecretsEncryptionKeyRotationStage:  "rotate",
+	controlplanev1.ReencryptSecretsEncryptionKeyRotationStage: "reencrypt && " +
+		"until rke2 secrets-encrypt status | grep -q reencrypt_finished; do sleep 5; done",
+}
+
+// NextSecretsEncryptionKeyRotationStage returns the stage following the stage of the rotation of the secrets
+// encryption key, the completed stage is the last one.
+func NextSecretsEncryptionKeyRotationStage(
+	stage controlplanev1.SecretsEncryptionKeyRotationStage,
+) controlplanev1.SecretsEncryptionKeyRotationStage {
+	for i, s := range secretsEncryptionKeyRotationStages[:len(secretsEncryptionKeyRotationStages)-1] {
+		if s == stage {
+			return secretsEncryptionKeyRotationStages[i+1]
+		}
+	}
+
+	return controlplanev1.CompletedSecretsEncryptionKeyRotationStage
+}
+
+// SecretsEncryptionCommand returns the "rke2 secrets-encrypt" command run by the stage of the rotation of the secrets
+// encryption key, it is empty for the stages restarting the servers.
+func SecretsEncryptionCommand(stage controlplanev1.SecretsEncryptionKeyRotationStage) string {
+	return secretsEncryptionCommands[stage]
+}
+
+// SecretsEncryptionRestartOrder returns the machines in the order their rke2-server is restarted in the rotation of
+// the secrets encryption key: the machine the commands run on first, as RKE2 requires, then the oldest machines first.
+func SecretsEncryptionRestartOrder(machines collections.Machines, commandMachineName string) []*clusterv1.Machine {
+	ordered := []*clusterv1.Machine{}
+	if machine, ok := machines[commandMachineName]; ok {
+		ordered = append(ordered, machine)
+	}
+
+	for _, machine := range machines.SortedByCreationTimestamp() {
+		if machine.Name != commandMachineName {
+			ordered = append(ordered, machine)
+		}
+	}
+
+	return ordered
+}
+
+// SecretsEncrypt runs "rke2 secrets-encrypt" with the command, e.g. prepare, on the node. The step identifies the run,
+// the job left by another step is replaced. It returns true once the command succeeded.
+func (w *Workload) SecretsEncrypt(ctx context.Context, nodeName string, command string, step string) (bool, error) {
+	return w.runSecretsEncryptionJob(ctx, secretsEncryptionJobName, nodeName, "rke2 secrets-encrypt "+command, step)
+}
+
+// RestartServer restarts rke2-server on the node, so that it reloads its secrets encryption configuration. The step
+// identifies the restart, the job left by another step is replaced. It returns true once rke2-server restarted.
+func (w *Workload) RestartServer(ctx context.Context, nodeName string, step string) (bool, error) {
+	return w.runSecretsEncryptionJob(ctx, secretsEncryptionRestartJobPrefix+nodeName, nodeName, restartCommand, step)
+}
+
+// DeleteSecretsEncryptionJobs removes the jobs left by the rotation of the secrets encryption key.
+func (w *Workload) DeleteSecretsEncryptionJobs(ctx context.Context) error {
+	jobs := &batchv1.JobList{}
+	if err := w.Client.List(ctx, jobs, ctrlclient.InNamespace(HibernationNamespace),
+		ctrlclient.HasLabels{secretsEncryptionJobLabel}); err != nil {
+		return errors.Wrap(err, "failed to list the secrets encryption jobs")
+	}
+
+	errs := []error{}
+
+	for i := range jobs.Items {
+		if err := w.Client.Delete(ctx, &jobs.Items[i], ctrlclient.PropagationPolicy(metav1.DeletePropagationBackground)); err != nil &&
+			!apierrors.IsNotFound(err) {
+			errs = append(errs, errors.Wrapf(err, "failed to delete job %s", jobs.Items[i].Name))
+		}
+	}
+
+	return kerrors.NewAggregate(errs)
+}
+
+func (w *Workload) runSecretsEncryptionJob(ctx context.Context, name, nodeName, command, step string) (bool, error) {
+	job := &batchv1.Job{}
+
+	err := w.Client.Get(ctx, ctrlclient.ObjectKey{Namespace: HibernationNamespace, Name: name}, job)
+
+	switch {
+	case err == nil && job.Annotations[secretsEncryptionStepAnnotation] != step:
+		if err := w.Client.Delete(ctx, job, ctrlclient.PropagationPolicy(metav1.DeletePropagationBackground)); err != nil &&
+			!apierrors.IsNotFound(err) {
+			return false, errors.Wrapf(err, "failed to delete the previous job %s", name)
+		}
+
+		return false, nil
+	case apierrors.IsNotFound(err):
+		job = w.newHostJob(name, nodeName, fmt.Sprintf(hostCommand, command), secretsEncryptionJobLabel, "secrets-encryption")
+		job.Annotations = map[string]string{secretsEncryptionStepAnnotation: step}
+		job.Spec.ActiveDeadlineSeconds = pointer.Int64(secretsEncryptionJobDeadline)
+
+		if err := w.Client.Create(ctx, job); err != nil {
+			return false, errors.Wrapf(err, "failed to create job %s", name)
+		}
+
+		return false, nil
+	case err != nil:
+		return false, errors.Wrapf(err, "failed to get job %s", name)
+	case job.Status.Failed > 0 || jobFailed(job):
+		return false, fmt.Errorf("job %s/%s running %q failed", HibernationNamespace, name, command)
+	default:
+		return job.Status.Succeeded > 0, nil
+	}
+}
+
+// jobFailed returns true when the job failed, e.g. because it ran past its deadline.
+func jobFailed(job *batchv1.Job) bool {
+	for _, condition := range job.Status.Conditions {
+		if condition.Type == batchv1.JobFailed && condition.Status == corev1.ConditionTrue {
+			return true
+		}
+	}
+
+	return false
+}
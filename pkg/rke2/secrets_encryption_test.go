@@ -0,0 +1,161 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rke2
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util/collections"
+
+	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+)
+
+var _ = Describe("secrets encryption key rotation", func() {
+	It("should go through the stages in order", func() {
+		stages := []controlplanev1.SecretsEncryptionKeyRotationStage{controlplanev1.PrepareSecretsEncryptionKeyRotationStage}
+		for stage := stages[0]; stage != controlplanev1.CompletedSecretsEncryptionKeyRotationStage; {
+			stage = NextSecretsEncryptionKeyRotationStage(stage)
+			stages = append(stages, stage)
+		}
+
+		Expect(stages).To(Equal(secretsEncryptionKeyRotationStages))
+		Expect(NextSecretsEncryptionKeyRotationStage(controlplanev1.CompletedSecretsEncryptionKeyRotationStage)).To(
+			Equal(controlplanev1.CompletedSecretsEncryptionKeyRotationStage))
+	})
+
+	It("should run a command in the command stages only", func() {
+		Expect(SecretsEncryptionCommand(controlplanev1.PrepareSecretsEncryptionKeyRotationStage)).To(Equal("prepare"))
+		Expect(SecretsEncryptionCommand(controlplanev1.RotateSecretsEncryptionKeyRotationStage)).To(Equal("rotate"))
+		Expect(SecretsEncryptionCommand(controlplanev1.ReencryptSecretsEncryptionKeyRotationStage)).To(HavePrefix("reencrypt"))
+		Expect(SecretsEncryptionCommand(controlplanev1.RotateRestartSecretsEncryptionKeyRotationStage)).To(BeEmpty())
+	})
+
+	It("should restart the machine running the commands first", func() {
+		now := time.Now()
+		machine := func(name string, age time.Duration) *clusterv1.Machine {
+			return &clusterv1.Machine{ObjectMeta: metav1.ObjectMeta{Name: name, CreationTimestamp: metav1.NewTime(now.Add(-age))}}
+		}
+		machines := collections.FromMachines(machine("machine-1", time.Hour), machine("machine-2", 3*time.Hour),
+			machine("machine-3", 2*time.Hour))
+
+		names := []string{}
+		for _, machine := range SecretsEncryptionRestartOrder(machines, "machine-1") {
+			names = append(names, machine.Name)
+		}
+
+		Expect(names).To(Equal([]string{"machine-1", "machine-2", "machine-3"}))
+	})
+})
+
+var _ = Describe("SecretsEncrypt", func() {
+	var workload *Workload
+
+	BeforeEach(func() {
+		workload = &Workload{
+			Client: fake.NewClientBuilder().Build(),
+		}
+	})
+
+	It("should run the command until it succeeds", func() {
+		jobKey := types.NamespacedName{Namespace: HibernationNamespace, Name: secretsEncryptionJobName}
+
+		done, err := workload.SecretsEncrypt(context.Background(), "node-1", "prepare", "rotation-1/Prepare")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(done).To(BeFalse())
+
+		job := &batchv1.Job{}
+		Expect(workload.Client.Get(context.Background(), jobKey, job)).To(Succeed())
+		Expect(job.Spec.Template.Spec.NodeName).To(Equal("node-1"))
+		Expect(job.Spec.Template.Spec.Containers[0].Command).To(ContainElement(ContainSubstring("rke2 secrets-encrypt prepare")))
+		Expect(job.Spec.ActiveDeadlineSeconds).To(Equal(pointer.Int64(secretsEncryptionJobDeadline)))
+
+		job.Status.Succeeded = 1
+		Expect(workload.Client.Status().Update(context.Background(), job)).To(Succeed())
+
+		done, err = workload.SecretsEncrypt(context.Background(), "node-1", "prepare", "rotation-1/Prepare")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(done).To(BeTrue())
+
+		// The next step replaces the job.
+		done, err = workload.SecretsEncrypt(context.Background(), "node-1", "rotate", "rotation-1/Rotate")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(done).To(BeFalse())
+
+		done, err = workload.SecretsEncrypt(context.Background(), "node-1", "rotate", "rotation-1/Rotate")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(done).To(BeFalse())
+
+		Expect(workload.Client.Get(context.Background(), jobKey, job)).To(Succeed())
+		Expect(job.Spec.Template.Spec.Containers[0].Command).To(ContainElement(ContainSubstring("rke2 secrets-encrypt rotate")))
+
+		job.Status.Failed = 1
+		Expect(workload.Client.Status().Update(context.Background(), job)).To(Succeed())
+
+		_, err = workload.SecretsEncrypt(context.Background(), "node-1", "rotate", "rotation-1/Rotate")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should fail the command running past its deadline", func() {
+		jobKey := types.NamespacedName{Namespace: HibernationNamespace, Name: secretsEncryptionJobName}
+
+		_, err := workload.SecretsEncrypt(context.Background(), "node-1", "reencrypt", "rotation-1/Reencrypt")
+		Expect(err).ToNot(HaveOccurred())
+
+		job := &batchv1.Job{}
+		Expect(workload.Client.Get(context.Background(), jobKey, job)).To(Succeed())
+
+		job.Status.Conditions = []batchv1.JobCondition{
+			{Type: batchv1.JobFailed, Status: corev1.ConditionTrue, Reason: "DeadlineExceeded"},
+		}
+		Expect(workload.Client.Status().Update(context.Background(), job)).To(Succeed())
+
+		_, err = workload.SecretsEncrypt(context.Background(), "node-1", "reencrypt", "rotation-1/Reencrypt")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should restart the servers and delete the jobs", func() {
+		for _, nodeName := range []string{"node-1", "node-2"} {
+			restarted, err := workload.RestartServer(context.Background(), nodeName, "rotation-1/PrepareRestart")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(restarted).To(BeFalse())
+
+			job := &batchv1.Job{}
+			jobKey := types.NamespacedName{Namespace: HibernationNamespace, Name: secretsEncryptionRestartJobPrefix + nodeName}
+			Expect(workload.Client.Get(context.Background(), jobKey, job)).To(Succeed())
+			Expect(job.Spec.Template.Spec.NodeName).To(Equal(nodeName))
+			Expect(job.Spec.Template.Spec.Containers[0].Command).To(ContainElement(ContainSubstring(restartCommand)))
+		}
+
+		Expect(workload.DeleteSecretsEncryptionJobs(context.Background())).To(Succeed())
+
+		jobs := &batchv1.JobList{}
+		Expect(workload.Client.List(context.Background(), jobs)).To(Succeed())
+		Expect(jobs.Items).To(BeEmpty())
+	})
+})
@@ -0,0 +1,95 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rke2
+
+import (
+	"context"
+	"os"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util/collections"
+)
+
+// ControllerPod identifies the pod the controller runs in.
+type ControllerPod struct {
+	Namespace string
+	Name      string
+	UID       types.UID
+}
+
+// ControllerPodFromEnv returns the pod the controller runs in, from the POD_NAMESPACE, POD_NAME and POD_UID environment
+// variables set by the manager deployment, or nil when they are not set.
+func ControllerPodFromEnv() *ControllerPod {
+	pod := &ControllerPod{
+		Namespace: os.Getenv("POD_NAMESPACE"),
+		Name:      os.Getenv("POD_NAME"),
+		UID:       types.UID(os.Getenv("POD_UID")),
+	}
+
+	if pod.Namespace == "" || pod.Name == "" || pod.UID == "" {
+		return nil
+	}
+
+	return pod
+}
+
+// GetControllerNodeName returns the node the controller pod runs on when it runs in the workload cluster, i.e. when the
+// cluster manages itself after "clusterctl move", or an empty string otherwise.
+func (w *Workload) GetControllerNodeName(ctx context.Context, controllerPod ControllerPod) (string, error) {
+	pod := &corev1.Pod{}
+	if err := w.Client.Get(ctx, types.NamespacedName{Namespace: controllerPod.Namespace, Name: controllerPod.Name}, pod); err != nil {
+		if apierrors.IsNotFound(err) {
+			return "", nil
+		}
+
+		return "", errors.Wrap(err, "failed to get the controller pod")
+	}
+
+	if pod.UID != controllerPod.UID {
+		return "", nil
+	}
+
+	return pod.Spec.NodeName, nil
+}
+
+// IsSelfHosted returns true if the controller runs in the workload cluster of the control plane.
+func (c *ControlPlane) IsSelfHosted() bool {
+	return c.ControllerNodeName != ""
+}
+
+// withoutControllerMachine removes the machine the controller runs on from the machines, unless it is the only one, so
+// it is deleted last, once the controller can be moved to another machine.
+func (c *ControlPlane) withoutControllerMachine(machines collections.Machines) collections.Machines {
+	if !c.IsSelfHosted() {
+		return machines
+	}
+
+	otherMachines := machines.Filter(func(machine *clusterv1.Machine) bool {
+		return machine.Status.NodeRef == nil || machine.Status.NodeRef.Name != c.ControllerNodeName
+	})
+
+	if otherMachines.Len() == 0 {
+		return machines
+	}
+
+	return otherMachines
+}
@@ -0,0 +1,87 @@
+/*
+Copyright 2023 SUSE.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rke2
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util/collections"
+
+	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+)
+
+var _ = Describe("Self hosting", func() {
+	controllerPod := ControllerPod{Namespace: "rke2-control-plane-system", Name: "controller", UID: "controller-uid"}
+
+	It("should find the node of the controller pod in the workload cluster", func() {
+		workload := &Workload{
+			Client: fake.NewClientBuilder().WithObjects(&corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Namespace: controllerPod.Namespace, Name: controllerPod.Name, UID: controllerPod.UID},
+				Spec:       corev1.PodSpec{NodeName: "node-1"},
+			}).Build(),
+		}
+
+		Expect(workload.GetControllerNodeName(context.Background(), controllerPod)).To(Equal("node-1"))
+
+		otherPod := controllerPod
+		otherPod.UID = "other-uid"
+		Expect(workload.GetControllerNodeName(context.Background(), otherPod)).To(BeEmpty())
+
+		workload = &Workload{Client: fake.NewClientBuilder().Build()}
+		Expect(workload.GetControllerNodeName(context.Background(), controllerPod)).To(BeEmpty())
+	})
+
+	It("should delete the machine of the controller last", func() {
+		now := time.Now()
+		controlPlane := &ControlPlane{
+			RCP:     &controlplanev1.RKE2ControlPlane{},
+			Cluster: &clusterv1.Cluster{},
+			Machines: collections.FromMachines(
+				&clusterv1.Machine{
+					ObjectMeta: metav1.ObjectMeta{Name: "machine-1", CreationTimestamp: metav1.NewTime(now.Add(-time.Hour))},
+					Status:     clusterv1.MachineStatus{NodeRef: &corev1.ObjectReference{Name: "node-1"}},
+				},
+				&clusterv1.Machine{
+					ObjectMeta: metav1.ObjectMeta{Name: "machine-2", CreationTimestamp: metav1.NewTime(now)},
+					Status:     clusterv1.MachineStatus{NodeRef: &corev1.ObjectReference{Name: "node-2"}},
+				},
+			),
+		}
+
+		machine, err := controlPlane.MachineInFailureDomainWithMostMachines(controlPlane.Machines)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(machine.Name).To(Equal("machine-1"))
+
+		controlPlane.ControllerNodeName = "node-1"
+		machine, err = controlPlane.MachineInFailureDomainWithMostMachines(controlPlane.Machines)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(machine.Name).To(Equal("machine-2"))
+
+		controllerMachines := controlPlane.Machines.Filter(func(machine *clusterv1.Machine) bool { return machine.Name == "machine-1" })
+		machine, err = controlPlane.MachineInFailureDomainWithMostMachines(controllerMachines)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(machine.Name).To(Equal("machine-1"))
+	})
+})
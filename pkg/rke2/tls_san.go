@@ -0,0 +1,63 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rke2
+
+import (
+	"net"
+	"strings"
+
+	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+)
+
+// UncoveredRegistrationAddresses returns the registration addresses of the control plane that aren't subject
+// alternative names of its server certificate, the machines registering with them fail to join with a "certificate is
+// valid for ..., not ..." error. The server certificate is valid for the control plane endpoint and the additional
+// names of TLSSan, in addition to the names and addresses of the server nodes.
+func UncoveredRegistrationAddresses(rcp *controlplanev1.RKE2ControlPlane, controlPlaneEndpoint string) []string {
+	sans := append([]string{controlPlaneEndpoint}, rcp.Spec.ServerConfig.TLSSan...)
+
+	var uncovered []string
+
+	for _, registrationAddress := range rcp.Spec.RegistrationAddresses {
+		if !coveredBySANs(sans, registrationAddress.Address) {
+			uncovered = append(uncovered, registrationAddress.Address)
+		}
+	}
+
+	return uncovered
+}
+
+// coveredBySANs returns whether a host is one of the subject alternative names, a wildcard name matching a single
+// label.
+func coveredBySANs(sans []string, host string) bool {
+	host = strings.ToLower(NormalizeAddress(host))
+
+	for _, san := range sans {
+		san = strings.ToLower(NormalizeAddress(san))
+		if san == host {
+			return true
+		}
+
+		if strings.HasPrefix(san, "*.") && net.ParseIP(host) == nil {
+			if label, domain, found := strings.Cut(host, "."); found && label != "" && domain == san[2:] {
+				return true
+			}
+		}
+	}
+
+	return false
+}
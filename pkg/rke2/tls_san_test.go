@@ -0,0 +1,55 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rke2
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+)
+
+var _ = Describe("UncoveredRegistrationAddresses", func() {
+	var rcp *controlplanev1.RKE2ControlPlane
+
+	BeforeEach(func() {
+		rcp = &controlplanev1.RKE2ControlPlane{
+			Spec: controlplanev1.RKE2ControlPlaneSpec{
+				RegistrationAddresses: []controlplanev1.RegistrationAddress{
+					{FailureDomain: "a", Address: "api.example.com"},
+					{FailureDomain: "b", Address: "zone-b.Internal.example.com"},
+					{FailureDomain: "c", Address: "fd00::10"},
+				},
+			},
+		}
+	})
+
+	It("should report the registration addresses that aren't subject alternative names", func() {
+		Expect(UncoveredRegistrationAddresses(rcp, "api.example.com")).To(
+			Equal([]string{"zone-b.Internal.example.com", "fd00::10"}))
+	})
+
+	It("should match wildcard names on a single label and normalize the addresses", func() {
+		rcp.Spec.ServerConfig.TLSSan = []string{"*.internal.example.com", "fd00:0:0:0:0:0:0:10"}
+
+		Expect(UncoveredRegistrationAddresses(rcp, "api.example.com")).To(BeEmpty())
+
+		rcp.Spec.RegistrationAddresses[1].Address = "a.zone-b.internal.example.com"
+		Expect(UncoveredRegistrationAddresses(rcp, "api.example.com")).To(
+			Equal([]string{"a.zone-b.internal.example.com"}))
+	})
+})
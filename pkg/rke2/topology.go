@@ -0,0 +1,191 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rke2
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+
+	bootstrapv1 "github.com/rancher-sandbox/cluster-api-provider-rke2/bootstrap/api/v1alpha1"
+	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+	"github.com/rancher-sandbox/cluster-api-provider-rke2/pkg/consts"
+)
+
+const (
+	// PodCIDRVariable is the topology variable of the CIDR of the pods, used when the cluster network has none.
+	PodCIDRVariable = "podCIDR"
+
+	// ServiceCIDRVariable is the topology variable of the CIDR of the services, used when the cluster network has none.
+	ServiceCIDRVariable = "serviceCIDR"
+
+	// DNSDomainVariable is the topology variable of the cluster domain, used when the cluster network has no service
+	// domain.
+	DNSDomainVariable = "dnsDomain"
+
+	// HTTPProxyVariable is the topology variable of the proxy of the HTTP requests of the rke2 services.
+	HTTPProxyVariable = "httpProxy"
+
+	// HTTPSProxyVariable is the topology variable of the proxy of the HTTPS requests of the rke2 services.
+	HTTPSProxyVariable = "httpsProxy"
+
+	// NoProxyVariable is the topology variable of the hosts the rke2 services reach without proxy, either a comma
+	// separated string or a list of strings. RKE2 adds the cluster and service CIDRs and the cluster domain itself.
+	NoProxyVariable = "noProxy"
+
+	// ServerEnvironmentFile is the environment file of the rke2-server service.
+	ServerEnvironmentFile = "/etc/default/rke2-server"
+
+	// AgentEnvironmentFile is the environment file of the rke2-agent service.
+	AgentEnvironmentFile = "/etc/default/rke2-agent"
+)
+
+// ClusterSettings are the settings of a cluster the RKE2 configuration is defaulted from, so that they are defined
+// once in the Cluster.
+type ClusterSettings struct {
+	PodCIDR     string
+	ServiceCIDR string
+	DNSDomain   string
+	HTTPProxy   string
+	HTTPSProxy  string
+	NoProxy     string
+}
+
+// GetClusterSettings returns the settings of a cluster, from its cluster network and from the standard variables of
+// its topology. The cluster network takes precedence over the topology variables.
+func GetClusterSettings(cluster *clusterv1.Cluster) (ClusterSettings, error) {
+	settings := ClusterSettings{}
+
+	if topology := cluster.Spec.Topology; topology != nil {
+		for _, variable := range []struct {
+			name  string
+			value *string
+		}{
+			{PodCIDRVariable, &settings.PodCIDR},
+			{ServiceCIDRVariable, &settings.ServiceCIDR},
+			{DNSDomainVariable, &settings.DNSDomain},
+			{HTTPProxyVariable, &settings.HTTPProxy},
+			{HTTPSProxyVariable, &settings.HTTPSProxy},
+			{NoProxyVariable, &settings.NoProxy},
+		} {
+			value, err := topologyVariable(topology.Variables, variable.name)
+			if err != nil {
+				return ClusterSettings{}, err
+			}
+
+			*variable.value = value
+		}
+	}
+
+	// The CIDRs of the dual-stack clusters, one per address family, are joined as RKE2 expects them.
+	if network := cluster.Spec.ClusterNetwork; network != nil {
+		if network.Pods != nil && len(network.Pods.CIDRBlocks) > 0 {
+			settings.PodCIDR = strings.Join(network.Pods.CIDRBlocks, ",")
+		}
+
+		if network.Services != nil && len(network.Services.CIDRBlocks) > 0 {
+			settings.ServiceCIDR = strings.Join(network.Services.CIDRBlocks, ",")
+		}
+
+		if network.ServiceDomain != "" {
+			settings.DNSDomain = network.ServiceDomain
+		}
+	}
+
+	return settings, nil
+}
+
+// ClusterDNSDomain returns the DNS domain of the Cluster of a control plane, found by its cluster name label. The
+// domain is empty when the control plane has no cluster name label or when the Cluster doesn't exist yet.
+func ClusterDNSDomain(ctx context.Context, c client.Reader, rcp *controlplanev1.RKE2ControlPlane) (string, error) {
+	clusterName := rcp.Labels[clusterv1.ClusterNameLabel]
+	if clusterName == "" {
+		return "", nil
+	}
+
+	cluster := &clusterv1.Cluster{}
+	if err := c.Get(ctx, client.ObjectKey{Namespace: rcp.Namespace, Name: clusterName}, cluster); err != nil {
+		if apierrors.IsNotFound(err) {
+			return "", nil
+		}
+
+		return "", errors.Wrapf(err, "failed to get cluster %s", clusterName)
+	}
+
+	settings, err := GetClusterSettings(cluster)
+	if err != nil {
+		return "", err
+	}
+
+	return settings.DNSDomain, nil
+}
+
+// ProxyEnvironmentFile returns the environment file of a rke2 service setting its proxy, or nil when no proxy is set.
+func (s ClusterSettings) ProxyEnvironmentFile(path string) *bootstrapv1.File {
+	if s.HTTPProxy == "" && s.HTTPSProxy == "" {
+		return nil
+	}
+
+	var content strings.Builder
+
+	for _, env := range []struct{ name, value string }{
+		{"HTTP_PROXY", s.HTTPProxy},
+		{"HTTPS_PROXY", s.HTTPSProxy},
+		{"NO_PROXY", s.NoProxy},
+	} {
+		if env.value != "" {
+			content.WriteString(env.name + "=" + env.value + "\n")
+		}
+	}
+
+	// The proxy URLs may hold credentials.
+	return &bootstrapv1.File{
+		Path:        path,
+		Content:     content.String(),
+		Owner:       consts.DefaultFileOwner,
+		Permissions: "0600",
+	}
+}
+
+// topologyVariable returns the value of a string topology variable, a list of strings is joined with commas.
+func topologyVariable(variables []clusterv1.ClusterVariable, name string) (string, error) {
+	for _, variable := range variables {
+		if variable.Name != name {
+			continue
+		}
+
+		var value string
+		if err := json.Unmarshal(variable.Value.Raw, &value); err == nil {
+			return value, nil
+		}
+
+		var values []string
+		if err := json.Unmarshal(variable.Value.Raw, &values); err != nil {
+			return "", errors.Errorf("topology variable %s must be a string or a list of strings", name)
+		}
+
+		return strings.Join(values, ","), nil
+	}
+
+	return "", nil
+}
@@ -0,0 +1,130 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rke2
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+
+	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+)
+
+var _ = Describe("GetClusterSettings", func() {
+	var cluster *clusterv1.Cluster
+
+	variable := func(name, value string) clusterv1.ClusterVariable {
+		return clusterv1.ClusterVariable{Name: name, Value: apiextensionsv1.JSON{Raw: []byte(value)}}
+	}
+
+	BeforeEach(func() {
+		cluster = &clusterv1.Cluster{
+			Spec: clusterv1.ClusterSpec{
+				Topology: &clusterv1.Topology{
+					Variables: []clusterv1.ClusterVariable{
+						variable(PodCIDRVariable, `"10.42.0.0/16"`),
+						variable(ServiceCIDRVariable, `"10.43.0.0/16"`),
+						variable(DNSDomainVariable, `"example.local"`),
+						variable(HTTPProxyVariable, `"http://proxy:3128"`),
+						variable(NoProxyVariable, `["10.0.0.0/8", ".example.com"]`),
+					},
+				},
+			},
+		}
+	})
+
+	It("should default the settings from the topology variables", func() {
+		settings, err := GetClusterSettings(cluster)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(settings).To(Equal(ClusterSettings{
+			PodCIDR:     "10.42.0.0/16",
+			ServiceCIDR: "10.43.0.0/16",
+			DNSDomain:   "example.local",
+			HTTPProxy:   "http://proxy:3128",
+			NoProxy:     "10.0.0.0/8,.example.com",
+		}))
+
+		file := settings.ProxyEnvironmentFile(ServerEnvironmentFile)
+		Expect(file).ToNot(BeNil())
+		Expect(file.Path).To(Equal(ServerEnvironmentFile))
+		Expect(file.Content).To(Equal("HTTP_PROXY=http://proxy:3128\nNO_PROXY=10.0.0.0/8,.example.com\n"))
+	})
+
+	It("should prefer the cluster network to the topology variables", func() {
+		cluster.Spec.ClusterNetwork = &clusterv1.ClusterNetwork{
+			Pods:          &clusterv1.NetworkRanges{CIDRBlocks: []string{"192.168.0.0/16"}},
+			ServiceDomain: "cluster.local",
+		}
+
+		settings, err := GetClusterSettings(cluster)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(settings.PodCIDR).To(Equal("192.168.0.0/16"))
+		Expect(settings.ServiceCIDR).To(Equal("10.43.0.0/16"))
+		Expect(settings.DNSDomain).To(Equal("cluster.local"))
+	})
+
+	It("should join the CIDRs of a dual-stack cluster", func() {
+		cluster.Spec.ClusterNetwork = &clusterv1.ClusterNetwork{
+			Pods:     &clusterv1.NetworkRanges{CIDRBlocks: []string{"10.42.0.0/16", "fd00:42::/56"}},
+			Services: &clusterv1.NetworkRanges{CIDRBlocks: []string{"10.43.0.0/16", "fd00:43::/112"}},
+		}
+
+		settings, err := GetClusterSettings(cluster)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(settings.PodCIDR).To(Equal("10.42.0.0/16,fd00:42::/56"))
+		Expect(settings.ServiceCIDR).To(Equal("10.43.0.0/16,fd00:43::/112"))
+	})
+
+	It("should find the DNS domain of the cluster of a control plane", func() {
+		cluster.Name = "cluster"
+		cluster.Namespace = metav1.NamespaceDefault
+		rcp := &controlplanev1.RKE2ControlPlane{ObjectMeta: metav1.ObjectMeta{
+			Namespace: metav1.NamespaceDefault,
+			Labels:    map[string]string{clusterv1.ClusterNameLabel: "cluster"},
+		}}
+
+		scheme := runtime.NewScheme()
+		Expect(clusterv1.AddToScheme(scheme)).To(Succeed())
+
+		c := fake.NewClientBuilder().WithScheme(scheme).Build()
+		Expect(ClusterDNSDomain(context.Background(), c, rcp)).To(BeEmpty())
+
+		c = fake.NewClientBuilder().WithScheme(scheme).WithObjects(cluster).Build()
+		Expect(ClusterDNSDomain(context.Background(), c, rcp)).To(Equal("example.local"))
+	})
+
+	It("should reject variables which are not strings", func() {
+		cluster.Spec.Topology.Variables = []clusterv1.ClusterVariable{variable(HTTPProxyVariable, `{"url": "http://proxy"}`)}
+
+		_, err := GetClusterSettings(cluster)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should not set a proxy without topology variables", func() {
+		settings, err := GetClusterSettings(&clusterv1.Cluster{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(settings.ProxyEnvironmentFile(AgentEnvironmentFile)).To(BeNil())
+	})
+})
@@ -0,0 +1,235 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rke2
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	"sigs.k8s.io/cluster-api/util/conditions"
+
+	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+)
+
+// DefaultUpgradeTimeout is the default duration after which the upgrade of a control plane of a RKE2UpgradeGroup is
+// considered failed.
+const DefaultUpgradeTimeout = time.Hour
+
+// UpgradeGroupPlan is the next step of the upgrade of the control planes of a RKE2UpgradeGroup.
+type UpgradeGroupPlan struct {
+	// Status is the status of the RKE2UpgradeGroup once the plan is executed.
+	Status controlplanev1.RKE2UpgradeGroupStatus
+
+	// Upgrade are the control planes whose version must be set to the version of the RKE2UpgradeGroup.
+	Upgrade []*controlplanev1.RKE2ControlPlane
+
+	maxFailures int
+}
+
+// Completed returns whether all the control planes of the RKE2UpgradeGroup are upgraded.
+func (p *UpgradeGroupPlan) Completed() bool {
+	return len(p.Status.Pending) == 0 && len(p.Status.InProgress) == 0 && len(p.Status.Failed) == 0
+}
+
+// Fail records that the upgrade of a control plane being upgraded failed, halting the upgrade if more upgrades failed
+// than tolerated.
+func (p *UpgradeGroupPlan) Fail(name, message string) {
+	for i, member := range p.Status.InProgress {
+		if member.Name == name {
+			member.Message = message
+			p.Status.InProgress = append(p.Status.InProgress[:i], p.Status.InProgress[i+1:]...)
+			p.Status.Failed = append(p.Status.Failed, member)
+
+			break
+		}
+	}
+
+	p.Status.Halted = p.Status.Halted || len(p.Status.Failed) > p.maxFailures
+}
+
+// upgradeGroupMember is a control plane of a RKE2UpgradeGroup, with the index of its wave.
+type upgradeGroupMember struct {
+	rcp  *controlplanev1.RKE2ControlPlane
+	wave int
+	controlplanev1.RKE2UpgradeGroupMember
+}
+
+// PlanUpgradeGroup sorts the control planes of a RKE2UpgradeGroup by upgrade state, and selects the control planes to
+// upgrade next: the pending control planes of the current wave, up to the maximum concurrency, unless the upgrade is
+// paused or halted. The control planes must be the ones the RKE2UpgradeGroup selects.
+func PlanUpgradeGroup(
+	group *controlplanev1.RKE2UpgradeGroup,
+	rcps []controlplanev1.RKE2ControlPlane,
+	now time.Time,
+) (*UpgradeGroupPlan, error) {
+	waveSelectors := make([]labels.Selector, 0, len(group.Spec.Waves))
+
+	for i := range group.Spec.Waves {
+		selector, err := metav1.LabelSelectorAsSelector(&group.Spec.Waves[i].Selector)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid selector of wave %s", group.Spec.Waves[i].Name)
+		}
+
+		waveSelectors = append(waveSelectors, selector)
+	}
+
+	timeout := DefaultUpgradeTimeout
+	if group.Spec.UpgradeTimeout != nil {
+		timeout = group.Spec.UpgradeTimeout.Duration
+	}
+
+	maxConcurrency := 1
+	if group.Spec.MaxConcurrency != nil {
+		maxConcurrency = int(*group.Spec.MaxConcurrency)
+	}
+
+	plan := &UpgradeGroupPlan{}
+	if group.Spec.MaxFailures != nil {
+		plan.maxFailures = int(*group.Spec.MaxFailures)
+	}
+
+	// The start times of the upgrades are kept from the previous status.
+	startedAt := map[string]*metav1.Time{}
+	for _, member := range append(append([]controlplanev1.RKE2UpgradeGroupMember{},
+		group.Status.InProgress...), group.Status.Failed...) {
+		startedAt[member.Name] = member.StartedAt
+	}
+
+	members := make([]upgradeGroupMember, 0, len(rcps))
+
+	for i := range rcps {
+		member := upgradeGroupMember{rcp: &rcps[i], wave: len(waveSelectors)}
+		member.Name = rcps[i].Name
+
+		for j, selector := range waveSelectors {
+			if selector.Matches(labels.Set(rcps[i].Labels)) {
+				member.wave = j
+				member.Wave = group.Spec.Waves[j].Name
+
+				break
+			}
+		}
+
+		members = append(members, member)
+	}
+
+	sort.Slice(members, func(i, j int) bool {
+		if members[i].wave != members[j].wave {
+			return members[i].wave < members[j].wave
+		}
+
+		return members[i].Name < members[j].Name
+	})
+
+	currentWave := -1
+	pending := []upgradeGroupMember{}
+
+	for _, member := range members {
+		if member.rcp.Spec.AgentConfig.Version != group.Spec.Version {
+			plan.Status.Pending = append(plan.Status.Pending, member.RKE2UpgradeGroupMember)
+			pending = append(pending, member)
+
+			if currentWave == -1 {
+				currentWave = member.wave
+			}
+
+			continue
+		}
+
+		if UpgradeCompleted(member.rcp) {
+			plan.Status.Upgraded = append(plan.Status.Upgraded, member.RKE2UpgradeGroupMember)
+
+			continue
+		}
+
+		// The control planes whose version was set by someone else, or whose start time was lost, are timed from now.
+		member.StartedAt = startedAt[member.Name]
+		if member.StartedAt == nil {
+			member.StartedAt = &metav1.Time{Time: now}
+		}
+
+		switch {
+		case member.rcp.Status.FailureReason != "":
+			member.Message = fmt.Sprintf("%s: %s", member.rcp.Status.FailureReason, member.rcp.Status.FailureMessage)
+			plan.Status.Failed = append(plan.Status.Failed, member.RKE2UpgradeGroupMember)
+		case now.Sub(member.StartedAt.Time) > timeout:
+			member.Message = fmt.Sprintf("upgrade not completed within %s", timeout)
+			plan.Status.Failed = append(plan.Status.Failed, member.RKE2UpgradeGroupMember)
+		default:
+			plan.Status.InProgress = append(plan.Status.InProgress, member.RKE2UpgradeGroupMember)
+
+			if currentWave == -1 {
+				currentWave = member.wave
+			}
+		}
+	}
+
+	// The halt is kept until the spec of the group changes, e.g. its maximum failures are raised, even if the failed
+	// upgrades recover or complete afterwards.
+	plan.Status.Halted = len(plan.Status.Failed) > plan.maxFailures ||
+		(group.Status.Halted && group.Status.ObservedGeneration == group.Generation && !plan.Completed())
+
+	if currentWave == -1 {
+		return plan, nil
+	}
+
+	if currentWave < len(group.Spec.Waves) {
+		plan.Status.CurrentWave = group.Spec.Waves[currentWave].Name
+	}
+
+	if group.Spec.Paused || plan.Status.Halted {
+		return plan, nil
+	}
+
+	for _, member := range pending {
+		if member.wave != currentWave || len(plan.Status.InProgress) >= maxConcurrency {
+			break
+		}
+
+		member.StartedAt = &metav1.Time{Time: now}
+		plan.Status.InProgress = append(plan.Status.InProgress, member.RKE2UpgradeGroupMember)
+		plan.Status.Pending = plan.Status.Pending[1:]
+		plan.Upgrade = append(plan.Upgrade, member.rcp)
+	}
+
+	return plan, nil
+}
+
+// UpgradeCompleted returns whether all the machines of a control plane are up to date and ready, once the control
+// plane controller observed its latest spec, and whether its nodes report the version of the spec, when reported.
+func UpgradeCompleted(rcp *controlplanev1.RKE2ControlPlane) bool {
+	if rcp.Status.ObservedGeneration < rcp.Generation {
+		return false
+	}
+
+	if rcp.Status.Version != nil && *rcp.Status.Version != rcp.TargetVersion() {
+		return false
+	}
+
+	if rcp.Spec.Replicas != nil && rcp.Spec.MachineReplicas() != rcp.Status.Replicas {
+		return false
+	}
+
+	return rcp.Status.UpdatedReplicas == rcp.Status.Replicas &&
+		rcp.Status.ReadyReplicas == rcp.Status.Replicas &&
+		!conditions.IsFalse(rcp, controlplanev1.MachinesSpecUpToDateCondition)
+}
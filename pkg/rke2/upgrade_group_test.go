@@ -0,0 +1,255 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rke2
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/pointer"
+
+	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+)
+
+var _ = Describe("PlanUpgradeGroup", func() {
+	const (
+		oldVersion = "v1.25.9+rke2r1"
+		newVersion = "v1.26.4+rke2r1"
+	)
+
+	var (
+		group *controlplanev1.RKE2UpgradeGroup
+		now   time.Time
+	)
+
+	newRCP := func(name, wave, version string, upgraded bool) controlplanev1.RKE2ControlPlane {
+		rcp := controlplanev1.RKE2ControlPlane{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:       name,
+				Generation: 2,
+				Labels:     map[string]string{"fleet": "edge"},
+			},
+			Spec: controlplanev1.RKE2ControlPlaneSpec{Replicas: pointer.Int32(3)},
+			Status: controlplanev1.RKE2ControlPlaneStatus{
+				ObservedGeneration: 2,
+				Replicas:           3,
+				ReadyReplicas:      3,
+				UpdatedReplicas:    3,
+			},
+		}
+		rcp.Spec.AgentConfig.Version = version
+
+		if wave != "" {
+			rcp.Labels["wave"] = wave
+		}
+
+		if !upgraded {
+			rcp.Status.UpdatedReplicas = 1
+		}
+
+		return rcp
+	}
+
+	names := func(members []controlplanev1.RKE2UpgradeGroupMember) []string {
+		result := []string{}
+		for _, member := range members {
+			result = append(result, member.Name)
+		}
+
+		return result
+	}
+
+	BeforeEach(func() {
+		now = time.Now()
+		group = &controlplanev1.RKE2UpgradeGroup{
+			Spec: controlplanev1.RKE2UpgradeGroupSpec{
+				Version:  newVersion,
+				Selector: metav1.LabelSelector{MatchLabels: map[string]string{"fleet": "edge"}},
+				Waves: []controlplanev1.RKE2UpgradeWave{
+					{Name: "canary", Selector: metav1.LabelSelector{MatchLabels: map[string]string{"wave": "canary"}}},
+				},
+				MaxConcurrency: pointer.Int32(2),
+			},
+		}
+	})
+
+	It("should upgrade the canary wave first", func() {
+		plan, err := PlanUpgradeGroup(group, []controlplanev1.RKE2ControlPlane{
+			newRCP("rcp-a", "", oldVersion, true),
+			newRCP("rcp-b", "canary", oldVersion, true),
+			newRCP("rcp-c", "", oldVersion, true),
+		}, now)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(plan.Status.CurrentWave).To(Equal("canary"))
+		Expect(names(plan.Status.InProgress)).To(Equal([]string{"rcp-b"}))
+		Expect(names(plan.Status.Pending)).To(Equal([]string{"rcp-a", "rcp-c"}))
+		Expect(plan.Upgrade).To(HaveLen(1))
+		Expect(plan.Upgrade[0].Name).To(Equal("rcp-b"))
+	})
+
+	It("should upgrade the next wave up to the maximum concurrency once the previous wave is upgraded", func() {
+		plan, err := PlanUpgradeGroup(group, []controlplanev1.RKE2ControlPlane{
+			newRCP("rcp-a", "", oldVersion, true),
+			newRCP("rcp-b", "canary", newVersion, true),
+			newRCP("rcp-c", "", oldVersion, true),
+			newRCP("rcp-d", "", oldVersion, true),
+		}, now)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(plan.Status.CurrentWave).To(BeEmpty())
+		Expect(names(plan.Status.Upgraded)).To(Equal([]string{"rcp-b"}))
+		Expect(names(plan.Status.InProgress)).To(Equal([]string{"rcp-a", "rcp-c"}))
+		Expect(names(plan.Status.Pending)).To(Equal([]string{"rcp-d"}))
+		Expect(plan.Completed()).To(BeFalse())
+	})
+
+	It("should wait for the upgrades in progress", func() {
+		group.Status.InProgress = []controlplanev1.RKE2UpgradeGroupMember{
+			{Name: "rcp-b", Wave: "canary", StartedAt: &metav1.Time{Time: now.Add(-time.Minute)}},
+		}
+
+		plan, err := PlanUpgradeGroup(group, []controlplanev1.RKE2ControlPlane{
+			newRCP("rcp-a", "", oldVersion, true),
+			newRCP("rcp-b", "canary", newVersion, false),
+		}, now)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(names(plan.Status.InProgress)).To(Equal([]string{"rcp-b"}))
+		Expect(plan.Status.InProgress[0].StartedAt.Time).To(Equal(now.Add(-time.Minute)))
+		Expect(plan.Upgrade).To(BeEmpty())
+	})
+
+	It("should wait for the nodes to report the version", func() {
+		group.Status.InProgress = []controlplanev1.RKE2UpgradeGroupMember{
+			{Name: "rcp-b", Wave: "canary", StartedAt: &metav1.Time{Time: now.Add(-time.Minute)}},
+		}
+
+		upgrading := newRCP("rcp-b", "canary", newVersion, true)
+		upgrading.Status.Version = pointer.String(oldVersion)
+
+		plan, err := PlanUpgradeGroup(group, []controlplanev1.RKE2ControlPlane{
+			newRCP("rcp-a", "", oldVersion, true),
+			upgrading,
+		}, now)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(names(plan.Status.InProgress)).To(Equal([]string{"rcp-b"}))
+		Expect(plan.Upgrade).To(BeEmpty())
+	})
+
+	It("should halt when an upgrade times out", func() {
+		group.Status.InProgress = []controlplanev1.RKE2UpgradeGroupMember{
+			{Name: "rcp-b", Wave: "canary", StartedAt: &metav1.Time{Time: now.Add(-2 * time.Hour)}},
+		}
+
+		plan, err := PlanUpgradeGroup(group, []controlplanev1.RKE2ControlPlane{
+			newRCP("rcp-a", "", oldVersion, true),
+			newRCP("rcp-b", "canary", newVersion, false),
+		}, now)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(names(plan.Status.Failed)).To(Equal([]string{"rcp-b"}))
+		Expect(plan.Status.Failed[0].Message).To(ContainSubstring("not completed within 1h0m0s"))
+		Expect(plan.Status.Halted).To(BeTrue())
+		Expect(plan.Upgrade).To(BeEmpty())
+	})
+
+	It("should continue when the failures are tolerated", func() {
+		group.Spec.MaxFailures = pointer.Int32(1)
+
+		rcpB := newRCP("rcp-b", "canary", newVersion, false)
+		rcpB.Status.FailureReason = "UpgradeFailed"
+
+		plan, err := PlanUpgradeGroup(group, []controlplanev1.RKE2ControlPlane{
+			newRCP("rcp-a", "", oldVersion, true),
+			rcpB,
+		}, now)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(plan.Status.Halted).To(BeFalse())
+		Expect(names(plan.Status.InProgress)).To(Equal([]string{"rcp-a"}))
+
+		plan.Fail("rcp-a", "rejected")
+		Expect(names(plan.Status.Failed)).To(Equal([]string{"rcp-b", "rcp-a"}))
+		Expect(plan.Status.InProgress).To(BeEmpty())
+		Expect(plan.Status.Halted).To(BeTrue())
+	})
+
+	It("should stay halted once the failed upgrade recovers, until the spec changes", func() {
+		group.Generation = 3
+		group.Status.ObservedGeneration = 3
+		group.Status.Halted = true
+		group.Status.Failed = []controlplanev1.RKE2UpgradeGroupMember{
+			{Name: "rcp-b", Wave: "canary", StartedAt: &metav1.Time{Time: now.Add(-10 * time.Minute)}},
+		}
+
+		rcps := []controlplanev1.RKE2ControlPlane{
+			newRCP("rcp-a", "", oldVersion, true),
+			newRCP("rcp-b", "canary", newVersion, false),
+		}
+
+		plan, err := PlanUpgradeGroup(group, rcps, now)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(names(plan.Status.InProgress)).To(Equal([]string{"rcp-b"}))
+		Expect(plan.Status.Halted).To(BeTrue())
+		Expect(plan.Upgrade).To(BeEmpty())
+
+		// The failed upgrade completed, the next wave still waits for the halt to be lifted.
+		rcps[1] = newRCP("rcp-b", "canary", newVersion, true)
+
+		plan, err = PlanUpgradeGroup(group, rcps, now)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(plan.Status.Halted).To(BeTrue())
+		Expect(plan.Upgrade).To(BeEmpty())
+
+		group.Generation = 4
+		group.Spec.MaxFailures = pointer.Int32(1)
+
+		plan, err = PlanUpgradeGroup(group, rcps, now)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(plan.Status.Halted).To(BeFalse())
+		Expect(names(plan.Status.InProgress)).To(Equal([]string{"rcp-a"}))
+	})
+
+	It("should not start upgrades when paused", func() {
+		group.Spec.Paused = true
+
+		plan, err := PlanUpgradeGroup(group, []controlplanev1.RKE2ControlPlane{
+			newRCP("rcp-a", "", oldVersion, true),
+		}, now)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(names(plan.Status.Pending)).To(Equal([]string{"rcp-a"}))
+		Expect(plan.Upgrade).To(BeEmpty())
+	})
+
+	It("should be completed when all the control planes are upgraded", func() {
+		plan, err := PlanUpgradeGroup(group, []controlplanev1.RKE2ControlPlane{
+			newRCP("rcp-a", "", newVersion, true),
+			newRCP("rcp-b", "canary", newVersion, true),
+		}, now)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(plan.Completed()).To(BeTrue())
+		Expect(plan.Status.CurrentWave).To(BeEmpty())
+	})
+})
@@ -0,0 +1,102 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rke2
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/version"
+
+	"sigs.k8s.io/cluster-api/util/conditions"
+
+	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+)
+
+// DefaultVersionDriftTolerance is how long the kubelet of a control plane node may run another version than the
+// control plane before the VersionDrift condition reports it, when the control plane doesn't configure it.
+const DefaultVersionDriftTolerance = time.Hour
+
+// versionDrifts returns whether a kubelet version differs from the version of the control plane. The build metadata,
+// e.g. +rke2r1, is only compared when both versions have one. A kubelet version that can't be parsed doesn't drift.
+func versionDrifts(kubeletVersion, controlPlaneVersion string) bool {
+	kubelet, err := version.ParseSemantic(kubeletVersion)
+	if err != nil {
+		return false
+	}
+
+	controlPlane, err := version.ParseSemantic(controlPlaneVersion)
+	if err != nil {
+		return false
+	}
+
+	if kubelet.LessThan(controlPlane) || controlPlane.LessThan(kubelet) {
+		return true
+	}
+
+	return kubelet.BuildMetadata() != "" && controlPlane.BuildMetadata() != "" &&
+		kubelet.BuildMetadata() != controlPlane.BuildMetadata()
+}
+
+// versionDriftSince returns the time the kubelet of a node was first seen running another version than the control
+// plane, given the time it was previously seen drifting, or nil when the versions match.
+func versionDriftSince(previous *metav1.Time, kubeletVersion, controlPlaneVersion string, now time.Time) *metav1.Time {
+	if !versionDrifts(kubeletVersion, controlPlaneVersion) {
+		return nil
+	}
+
+	if previous != nil {
+		return previous
+	}
+
+	return &metav1.Time{Time: now}
+}
+
+// UpdateVersionDriftCondition sets the VersionDriftCondition of the control plane while the kubelet of some of its
+// nodes runs another version than the control plane for longer than the version drift tolerance, and removes it
+// otherwise. It relies on the machine nodes recorded in the status of the control plane. It returns whether the
+// condition is set.
+func (c *ControlPlane) UpdateVersionDriftCondition(now time.Time) bool {
+	tolerance := DefaultVersionDriftTolerance
+	if c.RCP.Spec.VersionDriftTolerance != nil {
+		tolerance = c.RCP.Spec.VersionDriftTolerance.Duration
+	}
+
+	drifting := []string{}
+
+	for _, machineNode := range c.RCP.Status.MachineNodes {
+		if machineNode.VersionDriftSince != nil && now.Sub(machineNode.VersionDriftSince.Time) > tolerance {
+			drifting = append(drifting, fmt.Sprintf("%s (%s)", machineNode.NodeName, machineNode.KubeletVersion))
+		}
+	}
+
+	if len(drifting) == 0 {
+		conditions.Delete(c.RCP, controlplanev1.VersionDriftCondition)
+
+		return false
+	}
+
+	condition := conditions.TrueCondition(controlplanev1.VersionDriftCondition)
+	condition.Reason = controlplanev1.VersionDriftDetectedReason
+	condition.Message = fmt.Sprintf("Nodes %s run another version than %s for more than %s",
+		strings.Join(drifting, ", "), c.RCP.TargetVersion(), tolerance)
+	conditions.Set(c.RCP, condition)
+
+	return true
+}
@@ -0,0 +1,89 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rke2
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"sigs.k8s.io/cluster-api/util/conditions"
+
+	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+)
+
+var _ = Describe("versionDrifts", func() {
+	It("should compare the build metadata only when both versions have one", func() {
+		Expect(versionDrifts("v1.26.4+rke2r1", "v1.26.4+rke2r1")).To(BeFalse())
+		Expect(versionDrifts("v1.26.4", "v1.26.4+rke2r1")).To(BeFalse())
+		Expect(versionDrifts("v1.26.4+rke2r1", "v1.26.4+rke2r2")).To(BeTrue())
+		Expect(versionDrifts("v1.25.9+rke2r1", "v1.26.4+rke2r1")).To(BeTrue())
+		Expect(versionDrifts("", "v1.26.4+rke2r1")).To(BeFalse())
+	})
+
+	It("should keep the time the drift started", func() {
+		now := time.Now()
+		previous := &metav1.Time{Time: now.Add(-time.Hour)}
+
+		Expect(versionDriftSince(nil, "v1.25.9+rke2r1", "v1.26.4+rke2r1", now).Time).To(Equal(now))
+		Expect(versionDriftSince(previous, "v1.25.9+rke2r1", "v1.26.4+rke2r1", now)).To(Equal(previous))
+		Expect(versionDriftSince(previous, "v1.26.4+rke2r1", "v1.26.4+rke2r1", now)).To(BeNil())
+	})
+})
+
+var _ = Describe("UpdateVersionDriftCondition", func() {
+	var (
+		now          time.Time
+		controlPlane *ControlPlane
+	)
+
+	BeforeEach(func() {
+		now = time.Now()
+		rcp := &controlplanev1.RKE2ControlPlane{}
+		rcp.Spec.AgentConfig.Version = "v1.26.4+rke2r1"
+		rcp.Status.MachineNodes = []controlplanev1.MachineNode{
+			{MachineName: "m1", NodeName: "n1", KubeletVersion: "v1.26.4+rke2r1"},
+			{
+				MachineName: "m2", NodeName: "n2", KubeletVersion: "v1.25.9+rke2r1",
+				VersionDriftSince: &metav1.Time{Time: now.Add(-30 * time.Minute)},
+			},
+		}
+		controlPlane = &ControlPlane{RCP: rcp}
+	})
+
+	It("should tolerate a drift shorter than the tolerance", func() {
+		Expect(controlPlane.UpdateVersionDriftCondition(now)).To(BeFalse())
+		Expect(conditions.Has(controlPlane.RCP, controlplanev1.VersionDriftCondition)).To(BeFalse())
+	})
+
+	It("should report the nodes drifting for longer than the tolerance", func() {
+		controlPlane.RCP.Spec.VersionDriftTolerance = &metav1.Duration{Duration: 10 * time.Minute}
+
+		Expect(controlPlane.UpdateVersionDriftCondition(now)).To(BeTrue())
+		Expect(conditions.IsTrue(controlPlane.RCP, controlplanev1.VersionDriftCondition)).To(BeTrue())
+		Expect(conditions.GetMessage(controlPlane.RCP, controlplanev1.VersionDriftCondition)).
+			To(Equal("Nodes n2 (v1.25.9+rke2r1) run another version than v1.26.4+rke2r1 for more than 10m0s"))
+
+		controlPlane.RCP.Status.MachineNodes[1].VersionDriftSince = nil
+
+		Expect(controlPlane.UpdateVersionDriftCondition(now)).To(BeFalse())
+		Expect(conditions.Has(controlPlane.RCP, controlplanev1.VersionDriftCondition)).To(BeFalse())
+	})
+})
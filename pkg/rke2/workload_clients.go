@@ -0,0 +1,83 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rke2
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/rest"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+
+	"sigs.k8s.io/cluster-api/controllers/remote"
+)
+
+// WorkloadClients creates the clients of the workload clusters for a controller, and reuses the client of a cluster
+// until its kubeconfig changes, instead of discovering the API of the cluster at each reconciliation.
+type WorkloadClients struct {
+	// Client reads the kubeconfig secrets of the clusters.
+	Client ctrlclient.Reader
+
+	// ControllerName is the name of the controller, in the user agent of the clients.
+	ControllerName string
+
+	lock    sync.Mutex
+	clients map[ctrlclient.ObjectKey]*workloadClient
+}
+
+// GetClient returns the client of a workload cluster.
+func (w *WorkloadClients) GetClient(ctx context.Context, clusterKey ctrlclient.ObjectKey) (ctrlclient.Client, error) {
+	restConfig, err := remote.RESTConfig(ctx, w.ControllerName, w.Client, clusterKey)
+	if err != nil {
+		// The kubeconfig is deleted with the cluster.
+		if apierrors.IsNotFound(errors.Cause(err)) {
+			w.Forget(clusterKey)
+		}
+
+		return nil, err
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if cached := w.clients[clusterKey]; cached != nil && sameRESTConfig(cached.restConfig, restConfig) {
+		return cached.client, nil
+	}
+
+	client, err := newWorkloadClient(restConfig)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create a client of the workload cluster")
+	}
+
+	if w.clients == nil {
+		w.clients = map[ctrlclient.ObjectKey]*workloadClient{}
+	}
+
+	w.clients[clusterKey] = &workloadClient{restConfig: rest.CopyConfig(restConfig), client: client}
+
+	return client, nil
+}
+
+// Forget drops the client of a workload cluster.
+func (w *WorkloadClients) Forget(clusterKey ctrlclient.ObjectKey) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	delete(w.clients, clusterKey)
+}
@@ -0,0 +1,100 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rke2
+
+import (
+	"context"
+	"fmt"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("WorkloadClients", func() {
+	var (
+		c          ctrlclient.Client
+		clients    *WorkloadClients
+		clusterKey = ctrlclient.ObjectKey{Namespace: "default", Name: "test"}
+	)
+
+	ctx := context.Background()
+
+	kubeconfig := func(server string) *corev1.Secret {
+		return &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: clusterKey.Namespace, Name: clusterKey.Name + "-kubeconfig"},
+			Data: map[string][]byte{"value": []byte(fmt.Sprintf(`apiVersion: v1
+kind: Config
+clusters:
+- name: test
+  cluster:
+    server: %s
+contexts:
+- name: test
+  context:
+    cluster: test
+    user: test
+current-context: test
+users:
+- name: test
+  user:
+    token: token
+`, server))},
+		}
+	}
+
+	BeforeEach(func() {
+		c = fake.NewClientBuilder().WithObjects(kubeconfig("https://10.0.0.1:6443")).Build()
+		clients = &WorkloadClients{Client: c, ControllerName: "test"}
+	})
+
+	It("should reuse the client of a cluster", func() {
+		first, err := clients.GetClient(ctx, clusterKey)
+		Expect(err).ToNot(HaveOccurred())
+
+		second, err := clients.GetClient(ctx, clusterKey)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(second).To(BeIdenticalTo(first))
+	})
+
+	It("should create a new client once the kubeconfig of the cluster changes", func() {
+		first, err := clients.GetClient(ctx, clusterKey)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(c.Update(ctx, kubeconfig("https://10.0.0.2:6443"))).To(Succeed())
+
+		second, err := clients.GetClient(ctx, clusterKey)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(second).ToNot(BeIdenticalTo(first))
+	})
+
+	It("should forget the client of a cluster once its kubeconfig is deleted", func() {
+		_, err := clients.GetClient(ctx, clusterKey)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(clients.clients).To(HaveKey(clusterKey))
+
+		Expect(c.Delete(ctx, kubeconfig(""))).To(Succeed())
+
+		_, err = clients.GetClient(ctx, clusterKey)
+		Expect(err).To(HaveOccurred())
+		Expect(clients.clients).ToNot(HaveKey(clusterKey))
+	})
+})
@@ -20,24 +20,47 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	kerrors "k8s.io/apimachinery/pkg/util/errors"
 	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/version"
 	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
 
 	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
 	"sigs.k8s.io/cluster-api/util"
 	"sigs.k8s.io/cluster-api/util/collections"
 	"sigs.k8s.io/cluster-api/util/conditions"
 
+	bootstrapv1 "github.com/rancher-sandbox/cluster-api-provider-rke2/bootstrap/api/v1alpha1"
 	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+	"github.com/rancher-sandbox/cluster-api-provider-rke2/pkg/secret"
 )
 
 const (
 	labelNodeRoleControlPlane = "node-role.kubernetes.io/master"
+
+	// labelNodeRoleEtcd is the label RKE2 sets on the nodes of the servers running an etcd member.
+	labelNodeRoleEtcd = "node-role.kubernetes.io/etcd"
+
+	// etcdNodeNameAnnotation is the annotation RKE2 sets on the control plane nodes with the name of their etcd member.
+	etcdNodeNameAnnotation = "etcd.rke2.cattle.io/node-name"
+
+	// etcdRemoveAnnotation is the annotation requesting RKE2 to remove the etcd member of a control plane node.
+	etcdRemoveAnnotation = "etcd.rke2.cattle.io/remove"
+
+	// etcdRemovedNodeNameAnnotation is the annotation RKE2 sets on a control plane node once its etcd member is removed.
+	etcdRemovedNodeNameAnnotation = "etcd.rke2.cattle.io/removed-node-name"
+
+	// maxConcurrentNodeInspections is the maximum number of nodes of a workload cluster inspected concurrently.
+	maxConcurrentNodeInspections = 5
 )
 
 // ErrControlPlaneMinNodes is returned when the control plane has fewer than 2 nodes.
@@ -49,11 +72,44 @@ type WorkloadCluster interface {
 	ClusterStatus(ctx context.Context) (ClusterStatus, error)
 	UpdateAgentConditions(ctx context.Context, controlPlane *ControlPlane)
 	UpdateEtcdConditions(ctx context.Context, controlPlane *ControlPlane)
+	UpdateMachineNodes(ctx context.Context, controlPlane *ControlPlane) error
+	// Monitoring related tasks.
+	UpdateControlPlaneMetrics(ctx context.Context, metrics controlplanev1.ControlPlaneMetrics, certificates secret.Certificates) error
+	ApproveKubeletServingCertificates(ctx context.Context, machines collections.Machines) error
+	RecordWorkerProvisioning(ctx context.Context, machines collections.Machines) ([]*clusterv1.Machine, error)
+	// Node related tasks.
+	GetControllerNodeName(ctx context.Context, controllerPod ControllerPod) (string, error)
+	DeleteStaleNodes(ctx context.Context, machines collections.Machines) ([]string, error)
+	// Hibernation related tasks.
+	CertificatesExpiry(ctx context.Context, nodeName string, caCert []byte) (time.Time, error)
+	HibernateControlPlane(ctx context.Context, nodeNames []string, snapshotName, runID string) (string, bool, error)
+	ResumeControlPlane(ctx context.Context) error
+	SnapshotEtcd(ctx context.Context, nodeName string, snapshotName string) (string, error)
+	// Secrets encryption related tasks.
+	SecretsEncrypt(ctx context.Context, nodeName string, command string, step string) (bool, error)
+	RestartServer(ctx context.Context, nodeName string, step string) (bool, error)
+	DeleteSecretsEncryptionJobs(ctx context.Context) error
+	// Registries related tasks.
+	RefreshRegistries(ctx context.Context, files []bootstrapv1.File) (bool, error)
+	DeleteRegistriesRefresh(ctx context.Context) error
+	// Recovery related tasks.
+	ClusterReset(ctx context.Context, nodeName string, id string) (bool, error)
+	DeleteClusterResetJobs(ctx context.Context) error
+	// Restore related tasks.
+	VerifyEtcdSnapshot(ctx context.Context, nodeName string, restorePath string, s3 bool, restoreID string) (bool, error)
+	RestoreEtcdSnapshot(ctx context.Context, nodeName string, otherNodeNames []string, restorePath string, restoreID string) error
+	EtcdSnapshotRestored(ctx context.Context, nodeName string, restoreID string) (bool, error)
+	LatestEtcdS3Snapshot(ctx context.Context) (string, error)
+	// Maintenance related tasks.
+	DefragmentEtcd(ctx context.Context, defragmented []string) (string, error)
+	RemoveOrphanedEtcdMembers(ctx context.Context, controlPlane *ControlPlane) ([]string, error)
+	// Add-on related tasks.
+	ApplyManifests(ctx context.Context, objs []*unstructured.Unstructured) ([]controlplanev1.RKE2AddOnResource, error)
+	DeleteManifests(ctx context.Context, resources []controlplanev1.RKE2AddOnResource) error
 	// Upgrade related tasks.
+	RemoveEtcdMemberForMachine(ctx context.Context, machine *clusterv1.Machine) (bool, error)
+	ForwardEtcdLeadership(ctx context.Context, machine *clusterv1.Machine, leaderCandidate *clusterv1.Machine) error
 
-	//	RemoveEtcdMemberForMachine(ctx context.Context, machine *clusterv1.Machine) error
-
-	//	ForwardEtcdLeadership(ctx context.Context, machine *clusterv1.Machine, leaderCandidate *clusterv1.Machine) error
 	//	AllowBootstrapTokensToGetNodes(ctx context.Context) error
 
 	// State recovery tasks.
@@ -63,6 +119,17 @@ type WorkloadCluster interface {
 // Workload defines operations on workload clusters.
 type Workload struct {
 	Client ctrlclient.Client
+
+	// EtcdClient inspects the etcd cluster of the workload cluster, it is nil when the etcd certificate authority is
+	// not provided by the management cluster.
+	EtcdClient EtcdClient
+
+	// HostJobImage is the image of the jobs running commands on the hosts of the nodes, DefaultHostJobImage is used
+	// when empty.
+	HostJobImage string
+
+	// pendingEtcdMembers records the pending etcd members, they are never removed as orphaned when nil.
+	pendingEtcdMembers *pendingEtcdMembers
 }
 
 // ClusterStatus holds stats information about the cluster.
@@ -71,8 +138,12 @@ type ClusterStatus struct {
 	Nodes int32
 	// ReadyNodes are the count of nodes that are reporting ready
 	ReadyNodes int32
+	// Version is the lowest kubelet version of the nodes, empty when no node reports a valid version
+	Version string
 }
 
+// getControlPlaneNodes returns the nodes of the RKE2 servers: the nodes running the control plane components, and the
+// nodes only running an etcd member when the roles of the servers are split.
 func (w *Workload) getControlPlaneNodes(ctx context.Context) (*corev1.NodeList, error) {
 	nodes := &corev1.NodeList{}
 	labels := map[string]string{
@@ -83,6 +154,36 @@ func (w *Workload) getControlPlaneNodes(ctx context.Context) (*corev1.NodeList,
 		return nil, err
 	}
 
+	etcdNodes, err := w.getEtcdNodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	names := sets.NewString()
+	for _, node := range nodes.Items {
+		names.Insert(node.Name)
+	}
+
+	for _, node := range etcdNodes.Items {
+		if !names.Has(node.Name) {
+			nodes.Items = append(nodes.Items, node)
+		}
+	}
+
+	return nodes, nil
+}
+
+// getEtcdNodes returns the nodes of the RKE2 servers running an etcd member.
+func (w *Workload) getEtcdNodes(ctx context.Context) (*corev1.NodeList, error) {
+	nodes := &corev1.NodeList{}
+	labels := map[string]string{
+		labelNodeRoleEtcd: "true",
+	}
+
+	if err := w.Client.List(ctx, nodes, ctrlclient.MatchingLabels(labels)); err != nil {
+		return nil, err
+	}
+
 	return nodes, nil
 }
 
@@ -96,6 +197,8 @@ func (w *Workload) ClusterStatus(ctx context.Context) (ClusterStatus, error) {
 		return status, err
 	}
 
+	var lowest *version.Version
+
 	for _, node := range nodes.Items {
 		nodeCopy := node
 		status.Nodes++
@@ -103,6 +206,13 @@ func (w *Workload) ClusterStatus(ctx context.Context) (ClusterStatus, error) {
 		if util.IsNodeReady(&nodeCopy) {
 			status.ReadyNodes++
 		}
+
+		// The versions are compared ignoring their build metadata, e.g. +rke2r1.
+		kubeletVersion, err := version.ParseSemantic(node.Status.NodeInfo.KubeletVersion)
+		if err == nil && (lowest == nil || kubeletVersion.LessThan(lowest)) {
+			lowest = kubeletVersion
+			status.Version = node.Status.NodeInfo.KubeletVersion
+		}
 	}
 
 	return status, nil
@@ -151,6 +261,9 @@ func (w *Workload) UpdateAgentConditions(ctx context.Context, controlPlane *Cont
 	// Update conditions for control plane components hosted as static pods on the nodes.
 	var rcpErrors []string
 
+	// The nodes of the machines to inspect, they are fetched concurrently.
+	var inspected []*clusterv1.Machine
+
 	for _, node := range controlPlaneNodes.Items {
 		// Search for the machine corresponding to the node.
 		var machine *clusterv1.Machine
@@ -196,32 +309,49 @@ func (w *Workload) UpdateAgentConditions(ctx context.Context, controlPlane *Cont
 			continue
 		}
 
-		targetnode := corev1.Node{}
-		nodeKey := ctrlclient.ObjectKey{
-			Namespace: metav1.NamespaceSystem,
-			Name:      node.Name,
-		}
+		inspected = append(inspected, machine)
+	}
+
+	targetNodes := make([]corev1.Node, len(inspected))
+	errs := make([]error, len(inspected))
+
+	// The errors are reported per machine, the group never fails.
+	group := &errgroup.Group{}
+	group.SetLimit(maxConcurrentNodeInspections)
+
+	for i, machine := range inspected {
+		i, nodeKey := i, ctrlclient.ObjectKey{Name: machine.Status.NodeRef.Name}
+
+		group.Go(func() error {
+			errs[i] = w.Client.Get(ctx, nodeKey, &targetNodes[i])
+
+			return nil
+		})
+	}
 
-		if err := w.Client.Get(ctx, nodeKey, &targetnode); err != nil {
-			// If there is an error getting the Pod, do not set any conditions.
+	_ = group.Wait()
+
+	for i, machine := range inspected {
+		if err := errs[i]; err != nil {
+			// If there is an error getting the node, do not set any conditions.
 			if apierrors.IsNotFound(err) {
 				conditions.MarkFalse(machine,
 					controlplanev1.MachineAgentHealthyCondition,
 					controlplanev1.PodMissingReason,
 					clusterv1.ConditionSeverityError,
-					"Node %s is missing", nodeKey.Name)
+					"Node %s is missing", machine.Status.NodeRef.Name)
 
-				return
+				continue
 			}
 
 			conditions.MarkUnknown(machine,
 				controlplanev1.MachineAgentHealthyCondition,
 				controlplanev1.PodInspectionFailedReason, "Failed to get node status")
 
-			return
+			continue
 		}
 
-		for _, condition := range targetnode.Status.Conditions {
+		for _, condition := range targetNodes[i].Status.Conditions {
 			if condition.Type == corev1.NodeReady && condition.Status == corev1.ConditionTrue {
 				conditions.MarkTrue(machine, controlplanev1.MachineAgentHealthyCondition)
 			}
@@ -379,18 +509,37 @@ func aggregateFromMachinesToRCP(input aggregateFromMachinesToRCPInput) {
 // This operation is best effort, in the sense that in case of problems in retrieving member status, it sets
 // the condition to Unknown state without returning any error.
 func (w *Workload) UpdateEtcdConditions(ctx context.Context, controlPlane *ControlPlane) {
-	w.updateManagedEtcdConditions(ctx, controlPlane)
+	if controlPlane.IsEtcdManaged() {
+		w.updateManagedEtcdConditions(ctx, controlPlane)
+
+		return
+	}
+
+	updateExternalEtcdConditions(controlPlane)
+}
+
+// updateExternalEtcdConditions reports the etcd cluster as healthy when the servers use an external datastore, which
+// the provider doesn't inspect, and removes the conditions of the etcd members.
+func updateExternalEtcdConditions(controlPlane *ControlPlane) {
+	conditions.MarkTrue(controlPlane.RCP, controlplanev1.EtcdClusterHealthyCondition)
+	conditions.Delete(controlPlane.RCP, controlplanev1.EtcdAlarmsClearedCondition)
+
+	for _, machine := range controlPlane.Machines {
+		conditions.Delete(machine, controlplanev1.MachineEtcdMemberHealthyCondition)
+	}
 }
 
 func (w *Workload) updateManagedEtcdConditions(ctx context.Context, controlPlane *ControlPlane) {
 	// NOTE: This methods uses control plane nodes only to get in contact with etcd but then it relies on etcd
 	// as ultimate source of truth for the list of members and for their health.
-	controlPlaneNodes, err := w.getControlPlaneNodes(ctx)
+	controlPlaneNodes, err := w.getEtcdNodes(ctx)
 	if err != nil {
 		conditions.MarkUnknown(
 			controlPlane.RCP,
 			controlplanev1.EtcdClusterHealthyCondition,
 			controlplanev1.EtcdClusterInspectionFailedReason, "Failed to list nodes which are hosting the etcd members")
+		conditions.MarkUnknown(controlPlane.RCP, controlplanev1.EtcdAlarmsClearedCondition,
+			controlplanev1.EtcdClusterInspectionFailedReason, "Failed to list nodes which are hosting the etcd members")
 
 		for _, m := range controlPlane.Machines {
 			conditions.MarkUnknown(m,
@@ -401,6 +550,8 @@ func (w *Workload) updateManagedEtcdConditions(ctx context.Context, controlPlane
 		return
 	}
 
+	etcdHealth, inspected := w.updateEtcdClusterHealth(ctx, controlPlane, controlPlaneNodes)
+
 	for _, node := range controlPlaneNodes.Items {
 		var machine *clusterv1.Machine
 
@@ -427,6 +578,271 @@ func (w *Workload) updateManagedEtcdConditions(ctx context.Context, controlPlane
 			continue
 		}
 
+		if reason, unhealthy := etcdHealth.Unhealthy[node.Annotations[etcdNodeNameAnnotation]]; inspected && unhealthy {
+			conditions.MarkFalse(machine, controlplanev1.MachineEtcdMemberHealthyCondition,
+				controlplanev1.EtcdMemberUnhealthyReason, clusterv1.ConditionSeverityError, reason)
+
+			continue
+		}
+
 		conditions.MarkTrue(machine, controlplanev1.MachineEtcdMemberHealthyCondition)
 	}
 }
+
+// updateEtcdClusterHealth sets the EtcdClusterHealthyCondition of the control plane from the health reported by the
+// etcd members, reached on the addresses of the control plane nodes. The health of the etcd cluster is returned, along
+// with whether it could be inspected.
+func (w *Workload) updateEtcdClusterHealth(
+	ctx context.Context,
+	controlPlane *ControlPlane,
+	nodes *corev1.NodeList,
+) (EtcdClusterHealth, bool) {
+	if w.EtcdClient == nil {
+		conditions.MarkUnknown(controlPlane.RCP, controlplanev1.EtcdClusterHealthyCondition,
+			controlplanev1.EtcdClientUnavailableReason,
+			"The etcd certificate authority is not provided by the management cluster, etcd can't be inspected")
+		conditions.MarkUnknown(controlPlane.RCP, controlplanev1.EtcdAlarmsClearedCondition,
+			controlplanev1.EtcdClientUnavailableReason,
+			"The etcd certificate authority is not provided by the management cluster, etcd can't be inspected")
+
+		return EtcdClusterHealth{}, false
+	}
+
+	health, err := InspectEtcdCluster(ctx, w.EtcdClient, etcdEndpoints(nodes))
+	if err != nil {
+		log.FromContext(ctx).Info("Failed to inspect the etcd cluster", "error", err.Error())
+		conditions.MarkUnknown(controlPlane.RCP, controlplanev1.EtcdClusterHealthyCondition,
+			controlplanev1.EtcdClusterInspectionFailedReason, "Failed to inspect the etcd cluster: %v", err)
+		conditions.MarkUnknown(controlPlane.RCP, controlplanev1.EtcdAlarmsClearedCondition,
+			controlplanev1.EtcdClusterInspectionFailedReason, "Failed to inspect the etcd cluster: %v", err)
+
+		return health, false
+	}
+
+	if len(health.Alarms) > 0 {
+		conditions.MarkFalse(controlPlane.RCP, controlplanev1.EtcdAlarmsClearedCondition,
+			controlplanev1.EtcdAlarmsRaisedReason, clusterv1.ConditionSeverityError, "%s", health.AlarmsSummary())
+	} else {
+		conditions.MarkTrue(controlPlane.RCP, controlplanev1.EtcdAlarmsClearedCondition)
+	}
+
+	switch {
+	case !health.HasQuorum():
+		conditions.MarkFalse(controlPlane.RCP, controlplanev1.EtcdClusterHealthyCondition,
+			controlplanev1.EtcdQuorumLostReason, clusterv1.ConditionSeverityError, health.Summary())
+	case len(health.Alarms) > 0:
+		conditions.MarkFalse(controlPlane.RCP, controlplanev1.EtcdClusterHealthyCondition,
+			controlplanev1.EtcdAlarmsRaisedReason, clusterv1.ConditionSeverityError, health.Summary())
+	case len(health.Unhealthy) > 0:
+		conditions.MarkFalse(controlPlane.RCP, controlplanev1.EtcdClusterHealthyCondition,
+			controlplanev1.EtcdMembersUnhealthyReason, clusterv1.ConditionSeverityWarning, health.Summary())
+	default:
+		// The true condition keeps the summary of the etcd cluster as its message.
+		condition := conditions.TrueCondition(controlplanev1.EtcdClusterHealthyCondition)
+		condition.Message = health.Summary()
+		conditions.Set(controlPlane.RCP, condition)
+	}
+
+	return health, true
+}
+
+// etcdEndpoints returns the etcd endpoints of the control plane nodes, on their internal addresses. The nodes of
+// dual-stack clusters have an internal address per address family, etcd may only listen on one of them.
+func etcdEndpoints(nodes *corev1.NodeList) []string {
+	endpoints := []string{}
+
+	for _, node := range nodes.Items {
+		for _, address := range node.Status.Addresses {
+			if address.Type == corev1.NodeInternalIP {
+				endpoints = append(endpoints, EtcdEndpoint(address.Address))
+			}
+		}
+	}
+
+	return endpoints
+}
+
+// UpdateMachineNodes records the node, the etcd member and the kubelet version of each machine of the control plane in
+// its status, along with the provisioning times of the machines and the time their node started running another
+// version than the control plane.
+func (w *Workload) UpdateMachineNodes(ctx context.Context, controlPlane *ControlPlane) error {
+	nodes, err := w.getControlPlaneNodes(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to list control plane nodes")
+	}
+
+	nodesCreated := map[string]metav1.Time{}
+	kubeletVersions := map[string]string{}
+
+	for _, node := range nodes.Items {
+		nodesCreated[node.Name] = node.CreationTimestamp
+		kubeletVersions[node.Name] = node.Status.NodeInfo.KubeletVersion
+	}
+
+	// The time the nodes started drifting from the version of the control plane is kept across the reconciliations,
+	// as well as the etcd member IDs while etcd can't be inspected.
+	driftingSince := map[string]*metav1.Time{}
+	etcdMemberIDs := map[string]string{}
+
+	for _, machineNode := range controlPlane.RCP.Status.MachineNodes {
+		driftingSince[machineNode.MachineName+"/"+machineNode.NodeName] = machineNode.VersionDriftSince
+		etcdMemberIDs[machineNode.NodeName] = machineNode.EtcdMemberID
+	}
+
+	if members, ok := w.listEtcdMembers(ctx, controlPlane, nodes); ok {
+		etcdMemberIDs = map[string]string{}
+
+		for _, node := range nodes.Items {
+			if member, found := etcdMemberOfNode(members, nodes, node.Name); found {
+				etcdMemberIDs[node.Name] = fmt.Sprintf("%x", member.ID)
+			}
+		}
+	}
+
+	now := time.Now()
+
+	machineNodes := []controlplanev1.MachineNode{}
+
+	for _, machine := range controlPlane.Machines.SortedByCreationTimestamp() {
+		machineNode := controlplanev1.MachineNode{MachineName: machine.Name}
+
+		var nodeCreated *metav1.Time
+
+		if machine.Status.NodeRef != nil {
+			machineNode.NodeName = machine.Status.NodeRef.Name
+			machineNode.EtcdMemberID = etcdMemberIDs[machineNode.NodeName]
+			machineNode.KubeletVersion = kubeletVersions[machineNode.NodeName]
+			machineNode.VersionDriftSince = versionDriftSince(driftingSince[machine.Name+"/"+machineNode.NodeName],
+				machineNode.KubeletVersion, controlPlane.RCP.TargetVersion(), now)
+
+			if created, ok := nodesCreated[machineNode.NodeName]; ok {
+				nodeCreated = &created
+			}
+		}
+
+		recordMachineProvisioning(machine, nodeCreated, &machineNode)
+
+		machineNodes = append(machineNodes, machineNode)
+	}
+
+	controlPlane.RCP.Status.MachineNodes = machineNodes
+
+	return nil
+}
+
+// listEtcdMembers lists the etcd members through the control plane nodes, it returns false when etcd is external or
+// can't be inspected.
+func (w *Workload) listEtcdMembers(ctx context.Context, controlPlane *ControlPlane, nodes *corev1.NodeList) ([]EtcdMember, bool) {
+	if w.EtcdClient == nil || controlPlane.RCP.Spec.ServerConfig.ExternalDatastore != nil {
+		return nil, false
+	}
+
+	members, err := w.EtcdClient.MemberList(ctx, etcdEndpoints(nodes))
+	if err != nil {
+		log.FromContext(ctx).Info("Failed to list the etcd members", "error", err.Error())
+
+		return nil, false
+	}
+
+	return members, true
+}
+
+// DeleteStaleNodes deletes the nodes left behind by the deleted machines of the cluster, when neither the machine
+// controller nor the cloud provider removed them. A node is only deleted if it is not ready and the machine it was
+// linked to by Cluster API doesn't exist anymore. The names of the etcd members of the deleted nodes, which RKE2
+// removes from etcd along with their node, are returned.
+func (w *Workload) DeleteStaleNodes(ctx context.Context, machines collections.Machines) ([]string, error) {
+	logger := log.FromContext(ctx)
+
+	nodes := &corev1.NodeList{}
+	if err := w.Client.List(ctx, nodes); err != nil {
+		return nil, errors.Wrap(err, "failed to list nodes")
+	}
+
+	machineNames := sets.NewString()
+	for _, machine := range machines {
+		machineNames.Insert(machine.Name)
+	}
+
+	errs := []error{}
+	etcdMembers := []string{}
+
+	for i := range nodes.Items {
+		node := &nodes.Items[i]
+
+		machineName, ok := node.Annotations[clusterv1.MachineAnnotation]
+		if !ok || machineName == "" || machineNames.Has(machineName) || util.IsNodeReady(node) {
+			continue
+		}
+
+		if err := w.Client.Delete(ctx, node); err != nil && !apierrors.IsNotFound(err) {
+			errs = append(errs, errors.Wrapf(err, "failed to delete node %s", node.Name))
+
+			continue
+		}
+
+		logger.Info("Deleted the node of a deleted machine", "node", node.Name, "machine", machineName)
+
+		if member := node.Annotations[etcdNodeNameAnnotation]; member != "" {
+			etcdMembers = append(etcdMembers, member)
+		}
+	}
+
+	return etcdMembers, kerrors.NewAggregate(errs)
+}
+
+// RemoveEtcdMemberForMachine requests RKE2 to remove the etcd member of the node of a control plane machine, by
+// annotating the node: the etcd controller of RKE2 removes the member with the etcd client certificates of the servers
+// and reports it with the removed node name annotation. True is returned once the member is removed, or when the
+// machine has no node to remove.
+func (w *Workload) RemoveEtcdMemberForMachine(ctx context.Context, machine *clusterv1.Machine) (bool, error) {
+	if machine == nil || machine.Status.NodeRef == nil {
+		return true, nil
+	}
+
+	node := &corev1.Node{}
+
+	err := w.Client.Get(ctx, ctrlclient.ObjectKey{Name: machine.Status.NodeRef.Name}, node)
+	if apierrors.IsNotFound(err) {
+		return true, nil
+	}
+
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to get node %s", machine.Status.NodeRef.Name)
+	}
+
+	if _, removed := node.Annotations[etcdRemovedNodeNameAnnotation]; removed {
+		return true, nil
+	}
+
+	if node.Annotations[etcdRemoveAnnotation] == "true" {
+		return false, nil
+	}
+
+	// The last etcd member can't be removed.
+	nodes, err := w.getEtcdNodes(ctx)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to list the etcd nodes")
+	}
+
+	if len(nodes.Items) < 2 {
+		return false, ErrControlPlaneMinNodes
+	}
+
+	patch := ctrlclient.MergeFrom(node.DeepCopy())
+
+	if node.Annotations == nil {
+		node.Annotations = map[string]string{}
+	}
+
+	node.Annotations[etcdRemoveAnnotation] = "true"
+
+	if err := w.Client.Patch(ctx, node, patch); err != nil {
+		return false, errors.Wrapf(err, "failed to request the removal of the etcd member of node %s", node.Name)
+	}
+
+	log.FromContext(ctx).Info("Requested the removal of the etcd member of the node", "node", node.Name,
+		"machine", machine.Name)
+
+	return false, nil
+}
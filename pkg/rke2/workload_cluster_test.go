@@ -0,0 +1,491 @@
+/*
+Copyright 2023 SUSE.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rke2
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util/collections"
+	"sigs.k8s.io/cluster-api/util/conditions"
+
+	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+)
+
+var _ = Describe("DeleteStaleNodes", func() {
+	newNode := func(name, machineName string, ready corev1.ConditionStatus, etcdMember ...string) *corev1.Node {
+		node := &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Status: corev1.NodeStatus{
+				Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: ready}},
+			},
+		}
+
+		if machineName != "" {
+			node.Annotations = map[string]string{clusterv1.MachineAnnotation: machineName}
+		}
+
+		if len(etcdMember) > 0 {
+			node.Annotations[etcdNodeNameAnnotation] = etcdMember[0]
+		}
+
+		return node
+	}
+
+	It("should only delete the not ready nodes of deleted machines", func() {
+		workload := &Workload{
+			Client: fake.NewClientBuilder().WithObjects(
+				newNode("existing-machine", "machine-1", corev1.ConditionFalse),
+				newNode("deleted-machine", "machine-2", corev1.ConditionFalse, "deleted-machine-5c6e2f1a"),
+				newNode("deleted-machine-ready", "machine-3", corev1.ConditionTrue),
+				newNode("not-managed", "", corev1.ConditionFalse),
+			).Build(),
+		}
+
+		machines := collections.FromMachines(&clusterv1.Machine{ObjectMeta: metav1.ObjectMeta{Name: "machine-1"}})
+		etcdMembers, err := workload.DeleteStaleNodes(context.Background(), machines)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(etcdMembers).To(ConsistOf("deleted-machine-5c6e2f1a"))
+
+		nodes := &corev1.NodeList{}
+		Expect(workload.Client.List(context.Background(), nodes)).To(Succeed())
+
+		nodeNames := []string{}
+		for _, node := range nodes.Items {
+			nodeNames = append(nodeNames, node.Name)
+		}
+
+		Expect(nodeNames).To(ConsistOf("existing-machine", "deleted-machine-ready", "not-managed"))
+	})
+})
+
+var _ = Describe("UpdateAgentConditions", func() {
+	It("should inspect the nodes of all the machines", func() {
+		objs := []ctrlclient.Object{}
+		machines := collections.New()
+
+		for i := 0; i < 2*maxConcurrentNodeInspections; i++ {
+			name := fmt.Sprintf("node-%d", i)
+			ready := corev1.ConditionTrue
+
+			if i%2 == 1 {
+				ready = corev1.ConditionFalse
+			}
+
+			objs = append(objs, &corev1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{labelNodeRoleControlPlane: "true", labelNodeRoleEtcd: "true"}},
+				Status: corev1.NodeStatus{
+					Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: ready}},
+				},
+			})
+			machines.Insert(&clusterv1.Machine{
+				ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("machine-%d", i)},
+				Status:     clusterv1.MachineStatus{NodeRef: &corev1.ObjectReference{Name: name}},
+			})
+		}
+
+		workload := &Workload{Client: fake.NewClientBuilder().WithObjects(objs...).Build()}
+		controlPlane := &ControlPlane{RCP: &controlplanev1.RKE2ControlPlane{}, Machines: machines}
+
+		workload.UpdateAgentConditions(context.Background(), controlPlane)
+
+		for i := 0; i < 2*maxConcurrentNodeInspections; i++ {
+			machine := machines[fmt.Sprintf("machine-%d", i)]
+			Expect(conditions.IsTrue(machine, controlplanev1.MachineAgentHealthyCondition)).To(Equal(i%2 == 0))
+		}
+	})
+})
+
+var _ = Describe("UpdateMachineNodes", func() {
+	var (
+		workload     *Workload
+		controlPlane *ControlPlane
+		etcdClient   *fakeEtcdClient
+	)
+
+	BeforeEach(func() {
+		etcdClient = &fakeEtcdClient{members: []EtcdMember{
+			{ID: 0x2a, Name: "node-1-5c6e2f1a"},
+			{ID: 0x2b, Name: "node-2-7d8e9f0a"},
+		}}
+		workload = &Workload{
+			Client: fake.NewClientBuilder().WithObjects(
+				&corev1.Node{ObjectMeta: metav1.ObjectMeta{
+					Name:        "node-1",
+					Labels:      map[string]string{labelNodeRoleControlPlane: "true", labelNodeRoleEtcd: "true"},
+					Annotations: map[string]string{etcdNodeNameAnnotation: "node-1-5c6e2f1a"},
+				}},
+			).Build(),
+			EtcdClient: etcdClient,
+		}
+
+		now := time.Now()
+		controlPlane = &ControlPlane{
+			RCP: &controlplanev1.RKE2ControlPlane{},
+			Machines: collections.FromMachines(
+				&clusterv1.Machine{
+					ObjectMeta: metav1.ObjectMeta{Name: "machine-2", CreationTimestamp: metav1.NewTime(now)},
+				},
+				&clusterv1.Machine{
+					ObjectMeta: metav1.ObjectMeta{Name: "machine-1", CreationTimestamp: metav1.NewTime(now.Add(-time.Hour))},
+					Status:     clusterv1.MachineStatus{NodeRef: &corev1.ObjectReference{Name: "node-1"}},
+				},
+			),
+		}
+
+	})
+
+	It("should map the machines to their node and etcd member", func() {
+		Expect(workload.UpdateMachineNodes(context.Background(), controlPlane)).To(Succeed())
+		Expect(controlPlane.RCP.Status.MachineNodes).To(Equal([]controlplanev1.MachineNode{
+			{MachineName: "machine-1", NodeName: "node-1", EtcdMemberID: "2a"},
+			{MachineName: "machine-2"},
+		}))
+	})
+
+	It("should keep the etcd member IDs while etcd can't be inspected", func() {
+		Expect(workload.UpdateMachineNodes(context.Background(), controlPlane)).To(Succeed())
+
+		etcdClient.err = fmt.Errorf("connection refused")
+
+		Expect(workload.UpdateMachineNodes(context.Background(), controlPlane)).To(Succeed())
+		Expect(controlPlane.RCP.Status.MachineNodes[0].EtcdMemberID).To(Equal("2a"))
+	})
+})
+
+var _ = Describe("ClusterStatus", func() {
+	It("should report the lowest kubelet version of the control plane nodes", func() {
+		objs := []ctrlclient.Object{}
+
+		for i, kubeletVersion := range []string{"v1.26.4+rke2r1", "v1.25.9+rke2r1", "", "v1.26.0+rke2r2"} {
+			objs = append(objs, &corev1.Node{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:   fmt.Sprintf("node-%d", i),
+					Labels: map[string]string{labelNodeRoleControlPlane: "true", labelNodeRoleEtcd: "true"},
+				},
+				Status: corev1.NodeStatus{NodeInfo: corev1.NodeSystemInfo{KubeletVersion: kubeletVersion}},
+			})
+		}
+
+		workload := &Workload{Client: fake.NewClientBuilder().WithObjects(objs...).Build()}
+
+		status, err := workload.ClusterStatus(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(status.Nodes).To(Equal(int32(4)))
+		Expect(status.Version).To(Equal("v1.25.9+rke2r1"))
+	})
+})
+
+var _ = Describe("RemoveEtcdMemberForMachine", func() {
+	newNode := func(name string, annotations map[string]string) *corev1.Node {
+		return &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Labels:      map[string]string{labelNodeRoleControlPlane: "true", labelNodeRoleEtcd: "true"},
+				Annotations: annotations,
+			},
+		}
+	}
+
+	newMachine := func(nodeName string) *clusterv1.Machine {
+		machine := &clusterv1.Machine{ObjectMeta: metav1.ObjectMeta{Name: "machine"}}
+		if nodeName != "" {
+			machine.Status.NodeRef = &corev1.ObjectReference{Name: nodeName}
+		}
+
+		return machine
+	}
+
+	It("should request the removal of the etcd member and wait for it", func() {
+		workload := &Workload{
+			Client: fake.NewClientBuilder().WithObjects(newNode("node-1", nil), newNode("node-2", nil)).Build(),
+		}
+
+		removed, err := workload.RemoveEtcdMemberForMachine(context.Background(), newMachine("node-1"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(removed).To(BeFalse())
+
+		node := &corev1.Node{}
+		Expect(workload.Client.Get(context.Background(), ctrlclient.ObjectKey{Name: "node-1"}, node)).To(Succeed())
+		Expect(node.Annotations).To(HaveKeyWithValue(etcdRemoveAnnotation, "true"))
+
+		node.Annotations[etcdRemovedNodeNameAnnotation] = "node-1-5c6e2f1a"
+		Expect(workload.Client.Update(context.Background(), node)).To(Succeed())
+
+		removed, err = workload.RemoveEtcdMemberForMachine(context.Background(), newMachine("node-1"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(removed).To(BeTrue())
+	})
+
+	It("should consider the member of a machine without node removed", func() {
+		workload := &Workload{Client: fake.NewClientBuilder().Build()}
+
+		removed, err := workload.RemoveEtcdMemberForMachine(context.Background(), newMachine(""))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(removed).To(BeTrue())
+
+		removed, err = workload.RemoveEtcdMemberForMachine(context.Background(), newMachine("deleted-node"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(removed).To(BeTrue())
+	})
+
+	It("should refuse to remove the last etcd member", func() {
+		workload := &Workload{Client: fake.NewClientBuilder().WithObjects(newNode("node-1", nil)).Build()}
+
+		_, err := workload.RemoveEtcdMemberForMachine(context.Background(), newMachine("node-1"))
+		Expect(err).To(MatchError(ErrControlPlaneMinNodes))
+	})
+})
+
+// fakeEtcdClient is an EtcdClient answering with a fixed set of members and alarms, it records the endpoints of the
+// defragmented members.
+type fakeEtcdClient struct {
+	members      []EtcdMember
+	alarms       []EtcdAlarm
+	unhealthy    map[string]bool
+	leader       uint64
+	defragmented []string
+	removed      []uint64
+	err          error
+}
+
+func (c *fakeEtcdClient) MemberList(_ context.Context, _ []string) ([]EtcdMember, error) {
+	return c.members, c.err
+}
+
+func (c *fakeEtcdClient) Alarms(_ context.Context, _ []string) ([]EtcdAlarm, error) {
+	return c.alarms, c.err
+}
+
+func (c *fakeEtcdClient) Health(_ context.Context, endpoint string) error {
+	if c.unhealthy[endpoint] {
+		return fmt.Errorf("etcd member %s is unhealthy", endpoint)
+	}
+
+	return nil
+}
+
+func (c *fakeEtcdClient) Status(_ context.Context, _ string) (EtcdMemberStatus, error) {
+	return EtcdMemberStatus{Leader: c.leader}, c.err
+}
+
+func (c *fakeEtcdClient) Defragment(_ context.Context, endpoint string) error {
+	c.defragmented = append(c.defragmented, endpoint)
+
+	return c.err
+}
+
+func (c *fakeEtcdClient) MemberRemove(_ context.Context, _ []string, id uint64) error {
+	c.removed = append(c.removed, id)
+
+	return c.err
+}
+
+func (c *fakeEtcdClient) MoveLeader(_ context.Context, _ string, targetID uint64) error {
+	if c.err == nil {
+		c.leader = targetID
+	}
+
+	return c.err
+}
+
+var _ = Describe("etcdEndpoints", func() {
+	It("should probe etcd on every internal address of the nodes of a dual-stack cluster", func() {
+		nodes := &corev1.NodeList{Items: []corev1.Node{{
+			Status: corev1.NodeStatus{Addresses: []corev1.NodeAddress{
+				{Type: corev1.NodeHostName, Address: "node-1"},
+				{Type: corev1.NodeInternalIP, Address: "10.0.0.1"},
+				{Type: corev1.NodeInternalIP, Address: "fd00::1"},
+				{Type: corev1.NodeExternalIP, Address: "203.0.113.1"},
+			}},
+		}}}
+
+		Expect(etcdEndpoints(nodes)).To(Equal([]string{"https://10.0.0.1:2379", "https://[fd00::1]:2379"}))
+	})
+})
+
+var _ = Describe("UpdateEtcdConditions", func() {
+	var (
+		workload     *Workload
+		controlPlane *ControlPlane
+		etcdClient   *fakeEtcdClient
+	)
+
+	BeforeEach(func() {
+		objs := []ctrlclient.Object{}
+		machines := collections.New()
+		etcdClient = &fakeEtcdClient{unhealthy: map[string]bool{}}
+
+		for i := 1; i <= 3; i++ {
+			name := fmt.Sprintf("node-%d", i)
+			address := fmt.Sprintf("10.0.0.%d", i)
+
+			objs = append(objs, &corev1.Node{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        name,
+					Labels:      map[string]string{labelNodeRoleControlPlane: "true", labelNodeRoleEtcd: "true"},
+					Annotations: map[string]string{etcdNodeNameAnnotation: name + "-5c6e2f1a"},
+				},
+				Status: corev1.NodeStatus{Addresses: []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: address}}},
+			})
+			machines.Insert(&clusterv1.Machine{
+				ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("machine-%d", i)},
+				Status:     clusterv1.MachineStatus{NodeRef: &corev1.ObjectReference{Name: name}},
+			})
+			etcdClient.members = append(etcdClient.members, EtcdMember{
+				ID:         uint64(i),
+				Name:       name + "-5c6e2f1a",
+				ClientURLs: []string{EtcdEndpoint(address)},
+			})
+		}
+
+		workload = &Workload{Client: fake.NewClientBuilder().WithObjects(objs...).Build(), EtcdClient: etcdClient}
+		controlPlane = &ControlPlane{RCP: &controlplanev1.RKE2ControlPlane{}, Machines: machines}
+	})
+
+	It("should report a healthy etcd cluster", func() {
+		workload.UpdateEtcdConditions(context.Background(), controlPlane)
+
+		Expect(conditions.IsTrue(controlPlane.RCP, controlplanev1.EtcdClusterHealthyCondition)).To(BeTrue())
+		Expect(conditions.GetMessage(controlPlane.RCP, controlplanev1.EtcdClusterHealthyCondition)).
+			To(Equal("3 members, 0 unhealthy, quorum kept; no alarm"))
+
+		for _, machine := range controlPlane.Machines {
+			Expect(conditions.IsTrue(machine, controlplanev1.MachineEtcdMemberHealthyCondition)).To(BeTrue())
+		}
+	})
+
+	It("should report the unhealthy members and the lost quorum", func() {
+		etcdClient.unhealthy[EtcdEndpoint("10.0.0.2")] = true
+
+		workload.UpdateEtcdConditions(context.Background(), controlPlane)
+
+		Expect(conditions.GetReason(controlPlane.RCP, controlplanev1.EtcdClusterHealthyCondition)).
+			To(Equal(controlplanev1.EtcdMembersUnhealthyReason))
+		Expect(conditions.IsFalse(controlPlane.Machines["machine-2"], controlplanev1.MachineEtcdMemberHealthyCondition)).To(BeTrue())
+		Expect(conditions.IsTrue(controlPlane.Machines["machine-1"], controlplanev1.MachineEtcdMemberHealthyCondition)).To(BeTrue())
+
+		etcdClient.unhealthy[EtcdEndpoint("10.0.0.3")] = true
+
+		workload.UpdateEtcdConditions(context.Background(), controlPlane)
+
+		Expect(conditions.GetReason(controlPlane.RCP, controlplanev1.EtcdClusterHealthyCondition)).
+			To(Equal(controlplanev1.EtcdQuorumLostReason))
+	})
+
+	It("should report the raised alarms", func() {
+		etcdClient.alarms = []EtcdAlarm{{MemberID: 1, Alarm: "NOSPACE"}}
+
+		workload.UpdateEtcdConditions(context.Background(), controlPlane)
+
+		Expect(conditions.GetReason(controlPlane.RCP, controlplanev1.EtcdClusterHealthyCondition)).
+			To(Equal(controlplanev1.EtcdAlarmsRaisedReason))
+		Expect(conditions.GetMessage(controlPlane.RCP, controlplanev1.EtcdClusterHealthyCondition)).
+			To(ContainSubstring("NOSPACE on node-1-5c6e2f1a"))
+
+		Expect(conditions.IsFalse(controlPlane.RCP, controlplanev1.EtcdAlarmsClearedCondition)).To(BeTrue())
+		Expect(conditions.GetMessage(controlPlane.RCP, controlplanev1.EtcdAlarmsClearedCondition)).
+			To(Equal("NOSPACE on node-1-5c6e2f1a"))
+
+		etcdClient.alarms = nil
+
+		workload.UpdateEtcdConditions(context.Background(), controlPlane)
+
+		Expect(conditions.IsTrue(controlPlane.RCP, controlplanev1.EtcdAlarmsClearedCondition)).To(BeTrue())
+	})
+
+	It("should not report the health of an etcd cluster it can't inspect", func() {
+		etcdClient.err = fmt.Errorf("connection refused")
+
+		workload.UpdateEtcdConditions(context.Background(), controlPlane)
+
+		Expect(conditions.GetReason(controlPlane.RCP, controlplanev1.EtcdClusterHealthyCondition)).
+			To(Equal(controlplanev1.EtcdClusterInspectionFailedReason))
+
+		workload.EtcdClient = nil
+
+		workload.UpdateEtcdConditions(context.Background(), controlPlane)
+
+		Expect(conditions.GetReason(controlPlane.RCP, controlplanev1.EtcdClusterHealthyCondition)).
+			To(Equal(controlplanev1.EtcdClientUnavailableReason))
+		Expect(conditions.IsTrue(controlPlane.Machines["machine-1"], controlplanev1.MachineEtcdMemberHealthyCondition)).To(BeTrue())
+	})
+
+	It("should not inspect etcd when the control plane uses an external datastore", func() {
+		etcdClient.err = fmt.Errorf("connection refused")
+		controlPlane.RCP.Spec.ServerConfig.ExternalDatastore = &controlplanev1.ExternalDatastore{
+			Endpoint: "postgres://rke2@db:5432/rke2",
+		}
+
+		workload.UpdateEtcdConditions(context.Background(), controlPlane)
+
+		Expect(conditions.IsTrue(controlPlane.RCP, controlplanev1.EtcdClusterHealthyCondition)).To(BeTrue())
+		Expect(conditions.Has(controlPlane.RCP, controlplanev1.EtcdAlarmsClearedCondition)).To(BeFalse())
+
+		for _, machine := range controlPlane.Machines {
+			Expect(conditions.Has(machine, controlplanev1.MachineEtcdMemberHealthyCondition)).To(BeFalse())
+		}
+	})
+})
+
+var _ = Describe("control plane nodes", func() {
+	It("should find the nodes of the servers of each role", func() {
+		newNode := func(name string, labels ...string) *corev1.Node {
+			node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{}}}
+			for _, label := range labels {
+				node.Labels[label] = "true"
+			}
+
+			return node
+		}
+
+		workload := &Workload{
+			Client: fake.NewClientBuilder().WithObjects(
+				newNode("server", labelNodeRoleControlPlane, labelNodeRoleEtcd),
+				newNode("etcd", labelNodeRoleEtcd),
+				newNode("control-plane", labelNodeRoleControlPlane),
+				newNode("agent"),
+			).Build(),
+		}
+
+		nodeNames := func(nodes *corev1.NodeList) []string {
+			names := []string{}
+			for _, node := range nodes.Items {
+				names = append(names, node.Name)
+			}
+
+			return names
+		}
+
+		nodes, err := workload.getControlPlaneNodes(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(nodeNames(nodes)).To(ConsistOf("server", "etcd", "control-plane"))
+
+		nodes, err = workload.getEtcdNodes(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(nodeNames(nodes)).To(ConsistOf("server", "etcd"))
+	})
+})
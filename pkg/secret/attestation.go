@@ -0,0 +1,101 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package secret
+
+import (
+	"encoding/base64"
+	"fmt"
+	"path"
+	"sort"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+
+	bootstrapv1 "github.com/rancher-sandbox/cluster-api-provider-rke2/bootstrap/api/v1alpha1"
+	"github.com/rancher-sandbox/cluster-api-provider-rke2/pkg/consts"
+)
+
+const (
+	// DefaultAttestationDirectory is the default directory of the attestation data on the nodes.
+	DefaultAttestationDirectory = "/etc/rancher/attestation"
+
+	// defaultAttestationMode is the default mode of the attestation data files, only readable by their owner.
+	defaultAttestationMode = 0o600
+)
+
+// NewAttestationFiles returns the data of the attestation secret as bootstrap files, binary data being encoded in
+// base64. The errors name the missing keys of the secret, never its data.
+func NewAttestationFiles(
+	attestation *bootstrapv1.Attestation,
+	attestationSecret *corev1.Secret,
+) ([]bootstrapv1.File, error) {
+	items := attestation.Items
+	if len(items) == 0 {
+		for key := range attestationSecret.Data {
+			items = append(items, corev1.KeyToPath{Key: key})
+		}
+
+		// The files are sorted, so that the bootstrap data doesn't change between reconciliations.
+		sort.Slice(items, func(i, j int) bool { return items[i].Key < items[j].Key })
+	}
+
+	if len(items) == 0 {
+		return nil, errors.Errorf("attestation secret %s has no data", attestationSecret.Name)
+	}
+
+	directory := attestation.Directory
+	if directory == "" {
+		directory = DefaultAttestationDirectory
+	}
+
+	files := make([]bootstrapv1.File, 0, len(items))
+
+	for _, item := range items {
+		data, ok := attestationSecret.Data[item.Key]
+		if !ok {
+			return nil, errors.Errorf("attestation secret %s has no %s key", attestationSecret.Name, item.Key)
+		}
+
+		filePath := item.Path
+		if filePath == "" {
+			filePath = item.Key
+		}
+
+		mode := int32(defaultAttestationMode)
+		if item.Mode != nil {
+			mode = *item.Mode
+		}
+
+		file := bootstrapv1.File{
+			Path:        path.Join(directory, filePath),
+			Owner:       consts.DefaultFileOwner,
+			Permissions: fmt.Sprintf("%04o", mode),
+			Content:     string(data),
+		}
+
+		// TPM enrollment data is binary, it can't be written as is to the cloud-init or ignition configuration.
+		if !utf8.Valid(data) {
+			file.Encoding = bootstrapv1.Base64
+			file.Content = base64.StdEncoding.EncodeToString(data)
+		}
+
+		files = append(files, file)
+	}
+
+	return files, nil
+}
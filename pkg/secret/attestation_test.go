@@ -0,0 +1,88 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package secret
+
+import (
+	"encoding/base64"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/pointer"
+
+	bootstrapv1 "github.com/rancher-sandbox/cluster-api-provider-rke2/bootstrap/api/v1alpha1"
+)
+
+var _ = Describe("NewAttestationFiles", func() {
+	var attestationSecret *corev1.Secret
+
+	BeforeEach(func() {
+		attestationSecret = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "attestation", Namespace: "test"},
+			Data: map[string][]byte{
+				"agent.conf": []byte("[agent]\nregistrar_ip = \"10.0.0.1\"\n"),
+				"ek.bin":     {0x00, 0xff, 0xfe, 0x80},
+			},
+		}
+	})
+
+	It("should write all the keys of the secret to the default directory", func() {
+		files, err := NewAttestationFiles(&bootstrapv1.Attestation{SecretName: attestationSecret.Name},
+			attestationSecret)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(files).To(HaveLen(2))
+		Expect(files[0].Path).To(Equal("/etc/rancher/attestation/agent.conf"))
+		Expect(files[0].Permissions).To(Equal("0600"))
+		Expect(files[0].Encoding).To(BeEmpty())
+		Expect(files[0].Content).To(Equal(string(attestationSecret.Data["agent.conf"])))
+
+		Expect(files[1].Path).To(Equal("/etc/rancher/attestation/ek.bin"))
+		Expect(files[1].Encoding).To(Equal(bootstrapv1.Base64))
+		Expect(files[1].Content).To(Equal(base64.StdEncoding.EncodeToString(attestationSecret.Data["ek.bin"])))
+	})
+
+	It("should write the selected keys to their paths", func() {
+		files, err := NewAttestationFiles(&bootstrapv1.Attestation{
+			SecretName: attestationSecret.Name,
+			Directory:  "/etc/keylime",
+			Items: []corev1.KeyToPath{
+				{Key: "agent.conf", Path: "agent.conf.d/10-capi.conf", Mode: pointer.Int32(0o640)},
+			},
+		}, attestationSecret)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(files).To(HaveLen(1))
+		Expect(files[0].Path).To(Equal("/etc/keylime/agent.conf.d/10-capi.conf"))
+		Expect(files[0].Permissions).To(Equal("0640"))
+	})
+
+	It("should name the missing keys without revealing the data", func() {
+		_, err := NewAttestationFiles(&bootstrapv1.Attestation{
+			SecretName: attestationSecret.Name,
+			Items:      []corev1.KeyToPath{{Key: "missing"}},
+		}, attestationSecret)
+		Expect(err).To(MatchError(ContainSubstring("missing")))
+		Expect(err.Error()).ToNot(ContainSubstring("registrar_ip"))
+
+		_, err = NewAttestationFiles(&bootstrapv1.Attestation{SecretName: "empty"},
+			&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "empty"}})
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,72 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package secret
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+
+	"github.com/pkg/errors"
+
+	bootstrapv1 "github.com/rancher-sandbox/cluster-api-provider-rke2/bootstrap/api/v1alpha1"
+)
+
+const (
+	// BootstrapDataValueKey is the key of the bootstrap data in its secret, as required by Cluster API.
+	BootstrapDataValueKey = "value"
+
+	// BootstrapDataFormatKey is the key of the format of the bootstrap data in its secret.
+	BootstrapDataFormatKey = "format"
+)
+
+// BootstrapData returns the data of the bootstrap data secret: the bootstrap data and its format, and the bootstrap
+// data under the key and in the encoding of the DataSecret, when set.
+func BootstrapData(data []byte, format bootstrapv1.Format, dataSecret *bootstrapv1.DataSecret) (map[string][]byte, error) {
+	secretData := map[string][]byte{
+		BootstrapDataValueKey:  data,
+		BootstrapDataFormatKey: []byte(format),
+	}
+
+	if dataSecret == nil {
+		return secretData, nil
+	}
+
+	encoded := data
+
+	switch dataSecret.Encoding {
+	case bootstrapv1.Base64DataSecretEncoding:
+		encoded = []byte(base64.StdEncoding.EncodeToString(data))
+	case bootstrapv1.GzipBase64DataSecretEncoding:
+		var buf bytes.Buffer
+
+		writer := gzip.NewWriter(&buf)
+		if _, err := writer.Write(data); err != nil {
+			return nil, errors.Wrap(err, "failed to compress bootstrap data")
+		}
+
+		if err := writer.Close(); err != nil {
+			return nil, errors.Wrap(err, "failed to compress bootstrap data")
+		}
+
+		encoded = []byte(base64.StdEncoding.EncodeToString(buf.Bytes()))
+	}
+
+	secretData[dataSecret.Key] = encoded
+
+	return secretData, nil
+}
@@ -0,0 +1,74 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package secret
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"io"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	bootstrapv1 "github.com/rancher-sandbox/cluster-api-provider-rke2/bootstrap/api/v1alpha1"
+)
+
+var _ = Describe("BootstrapData", func() {
+	data := []byte("#cloud-config\n")
+
+	It("should store the data under value", func() {
+		secretData, err := BootstrapData(data, bootstrapv1.CloudConfig, nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(secretData).To(Equal(map[string][]byte{
+			"value":  data,
+			"format": []byte("cloud-config"),
+		}))
+	})
+
+	It("should store the data under the key of the data secret as well", func() {
+		secretData, err := BootstrapData(data, bootstrapv1.Ignition, &bootstrapv1.DataSecret{Key: "userData"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(secretData).To(HaveKeyWithValue("value", data))
+		Expect(secretData).To(HaveKeyWithValue("userData", data))
+	})
+
+	It("should encode the data under the key of the data secret", func() {
+		secretData, err := BootstrapData(data, bootstrapv1.CloudConfig, &bootstrapv1.DataSecret{
+			Key:      "userData",
+			Encoding: bootstrapv1.Base64DataSecretEncoding,
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(secretData).To(HaveKeyWithValue("userData", []byte(base64.StdEncoding.EncodeToString(data))))
+
+		secretData, err = BootstrapData(data, bootstrapv1.CloudConfig, &bootstrapv1.DataSecret{
+			Key:      "userData",
+			Encoding: bootstrapv1.GzipBase64DataSecretEncoding,
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		compressed, err := base64.StdEncoding.DecodeString(string(secretData["userData"]))
+		Expect(err).ToNot(HaveOccurred())
+
+		reader, err := gzip.NewReader(bytes.NewReader(compressed))
+		Expect(err).ToNot(HaveOccurred())
+
+		decompressed, err := io.ReadAll(reader)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(decompressed).To(Equal(data))
+	})
+})
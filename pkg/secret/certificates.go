@@ -111,6 +111,18 @@ func NewCertificatesForInitialControlPlane() Certificates {
 	return certificates
 }
 
+// NewEtcdCACertificate returns the certificate authority RKE2 uses to sign the ETCD server and client certificates.
+// Providing it allows the management cluster to sign ETCD client certificates.
+func NewEtcdCACertificate() *Certificate {
+	certificatesDir := filepath.Join(DefaultCertificatesDir, "etcd")
+
+	return &Certificate{
+		Purpose:  EtcdCA,
+		CertFile: filepath.Join(certificatesDir, "server-ca.crt"),
+		KeyFile:  filepath.Join(certificatesDir, "server-ca.key"),
+	}
+}
+
 // GetByPurpose returns a certificate by the given name.
 // This could be removed if we use a map instead of a slice to hold certificates, however other code becomes more complex.
 func (c Certificates) GetByPurpose(purpose Purpose) *Certificate {
@@ -234,6 +246,44 @@ func (c Certificates) LookupOrGenerate(
 	return nil
 }
 
+// NewClientCertificate generates a client certificate signed by the certificate authority.
+func (c *Certificate) NewClientCertificate(commonName string, organization []string) (*certs.KeyPair, error) {
+	if c.KeyPair == nil {
+		return nil, errors.Errorf("certificate authority %q has no key pair", c.Purpose)
+	}
+
+	caCert, err := certs.DecodeCertPEM(c.KeyPair.Cert)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to decode certificate authority %q", c.Purpose)
+	}
+
+	caKey, err := certs.DecodePrivateKeyPEM(c.KeyPair.Key)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to decode private key of certificate authority %q", c.Purpose)
+	}
+
+	key, err := certs.NewPrivateKey()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create private key")
+	}
+
+	cfg := certs.Config{
+		CommonName:   commonName,
+		Organization: organization,
+		Usages:       []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	cert, err := cfg.NewSignedCert(key, caCert, caKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to sign client certificate")
+	}
+
+	return &certs.KeyPair{
+		Cert: certs.EncodeCertPEM(cert),
+		Key:  certs.EncodePrivateKeyPEM(key),
+	}, nil
+}
+
 // AsSecret converts a single certificate into a Kubernetes secret.
 func (c *Certificate) AsSecret(clusterName client.ObjectKey, owner metav1.OwnerReference) *corev1.Secret {
 	s := &corev1.Secret{
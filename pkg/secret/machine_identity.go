@@ -0,0 +1,157 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package secret
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math"
+	"math/big"
+	"net/url"
+	"path"
+	"time"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/util/keyutil"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util/certs"
+
+	bootstrapv1 "github.com/rancher-sandbox/cluster-api-provider-rke2/bootstrap/api/v1alpha1"
+	"github.com/rancher-sandbox/cluster-api-provider-rke2/pkg/consts"
+)
+
+const (
+	// DefaultMachineIdentityDirectory is the default directory of the machine identity certificate on the nodes.
+	DefaultMachineIdentityDirectory = "/etc/rancher/machine-identity"
+
+	// DefaultMachineIdentityValidity is the default validity of the machine identity certificates.
+	DefaultMachineIdentityValidity = time.Hour * 24 * 365
+
+	// CACrtFileName is the name of the certificate authority file next to the machine identity certificate.
+	CACrtFileName = "ca.crt"
+
+	// machineIdentityClockSkew backdates the machine identity certificates, so that they are valid on nodes whose
+	// clock is slightly behind.
+	machineIdentityClockSkew = 5 * time.Minute
+)
+
+// MachineIdentityURI returns the SPIFFE ID of a machine.
+func MachineIdentityURI(trustDomain string, machine *clusterv1.Machine) *url.URL {
+	return &url.URL{
+		Scheme: "spiffe",
+		Host:   trustDomain,
+		Path:   path.Join("/ns", machine.Namespace, "machine", machine.Name),
+	}
+}
+
+// NewMachineIdentityFiles mints a client certificate identifying the machine, signed by the certificate authority
+// of the secret, and returns the certificate, its key and the certificate authority as bootstrap files.
+func NewMachineIdentityFiles(
+	identity *bootstrapv1.MachineIdentity,
+	caSecret *corev1.Secret,
+	machine *clusterv1.Machine,
+) ([]bootstrapv1.File, error) {
+	ca, err := secretToKeyPair(caSecret)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid machine identity certificate authority %s", caSecret.Name)
+	}
+
+	caCert, err := certs.DecodeCertPEM(ca.Cert)
+	if err != nil || caCert == nil {
+		return nil, errors.Errorf("failed to decode machine identity certificate authority %s", caSecret.Name)
+	}
+
+	parsedKey, err := keyutil.ParsePrivateKeyPEM(ca.Key)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to decode private key of machine identity certificate authority %s",
+			caSecret.Name)
+	}
+
+	caKey, ok := parsedKey.(crypto.Signer)
+	if !ok {
+		return nil, errors.Errorf("unsupported private key type of machine identity certificate authority %s",
+			caSecret.Name)
+	}
+
+	key, err := certs.NewPrivateKey()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create private key")
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).SetInt64(math.MaxInt64))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to generate certificate serial number")
+	}
+
+	validity := DefaultMachineIdentityValidity
+	if identity.Validity != nil {
+		validity = identity.Validity.Duration
+	}
+
+	now := time.Now().UTC()
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			CommonName: machine.Name,
+		},
+		URIs:        []*url.URL{MachineIdentityURI(identity.TrustDomain, machine)},
+		NotBefore:   now.Add(-machineIdentityClockSkew),
+		NotAfter:    now.Add(validity),
+		KeyUsage:    x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, caCert, key.Public(), caKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to sign machine identity certificate")
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse machine identity certificate")
+	}
+
+	directory := identity.Directory
+	if directory == "" {
+		directory = DefaultMachineIdentityDirectory
+	}
+
+	return []bootstrapv1.File{
+		{
+			Path:        path.Join(directory, TLSCrtDataName),
+			Owner:       consts.DefaultFileOwner,
+			Permissions: "0640",
+			Content:     string(certs.EncodeCertPEM(cert)),
+		},
+		{
+			Path:        path.Join(directory, TLSKeyDataName),
+			Owner:       consts.DefaultFileOwner,
+			Permissions: "0600",
+			Content:     string(certs.EncodePrivateKeyPEM(key)),
+		},
+		{
+			Path:        path.Join(directory, CACrtFileName),
+			Owner:       consts.DefaultFileOwner,
+			Permissions: "0640",
+			Content:     string(ca.Cert),
+		},
+	}, nil
+}
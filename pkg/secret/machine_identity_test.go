@@ -0,0 +1,95 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package secret
+
+import (
+	"crypto/x509"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/cluster-api/util/certs"
+
+	bootstrapv1 "github.com/rancher-sandbox/cluster-api-provider-rke2/bootstrap/api/v1alpha1"
+)
+
+var _ = Describe("NewMachineIdentityFiles", func() {
+	var (
+		caSecret *corev1.Secret
+		machine  *clusterv1.Machine
+	)
+
+	BeforeEach(func() {
+		ca, err := generateCACert()
+		Expect(err).ToNot(HaveOccurred())
+
+		caSecret = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "machine-identity-ca", Namespace: "test"},
+			Data:       map[string][]byte{TLSCrtDataName: ca.Cert, TLSKeyDataName: ca.Key},
+		}
+		machine = &clusterv1.Machine{ObjectMeta: metav1.ObjectMeta{Name: "machine-1", Namespace: "test"}}
+	})
+
+	It("should mint a client certificate identifying the machine", func() {
+		files, err := NewMachineIdentityFiles(&bootstrapv1.MachineIdentity{
+			CASecretName: caSecret.Name,
+			TrustDomain:  "example.org",
+			Validity:     &metav1.Duration{Duration: time.Hour},
+		}, caSecret, machine)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(files).To(HaveLen(3))
+		Expect(files[0].Path).To(Equal("/etc/rancher/machine-identity/tls.crt"))
+		Expect(files[1].Path).To(Equal("/etc/rancher/machine-identity/tls.key"))
+		Expect(files[1].Permissions).To(Equal("0600"))
+		Expect(files[2].Path).To(Equal("/etc/rancher/machine-identity/ca.crt"))
+		Expect(files[2].Content).To(Equal(string(caSecret.Data[TLSCrtDataName])))
+
+		cert, err := certs.DecodeCertPEM([]byte(files[0].Content))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(cert.Subject.CommonName).To(Equal("machine-1"))
+		Expect(cert.URIs).To(HaveLen(1))
+		Expect(cert.URIs[0].String()).To(Equal("spiffe://example.org/ns/test/machine/machine-1"))
+		Expect(cert.NotAfter).To(BeTemporally("~", time.Now().Add(time.Hour), time.Minute))
+
+		caCert, err := certs.DecodeCertPEM(caSecret.Data[TLSCrtDataName])
+		Expect(err).ToNot(HaveOccurred())
+
+		roots := x509.NewCertPool()
+		roots.AddCert(caCert)
+		_, err = cert.Verify(x509.VerifyOptions{
+			Roots:     roots,
+			KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		})
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("should fail when the certificate authority has no key", func() {
+		delete(caSecret.Data, TLSKeyDataName)
+
+		_, err := NewMachineIdentityFiles(&bootstrapv1.MachineIdentity{
+			CASecretName: caSecret.Name,
+			TrustDomain:  "example.org",
+		}, caSecret, machine)
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,245 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package templates
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/yaml"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+
+	bootstrapv1 "github.com/rancher-sandbox/cluster-api-provider-rke2/bootstrap/api/v1alpha1"
+	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+)
+
+// ClusterClassPatchesFileName is the file name of the ClusterClass variables and patches shipped with the provider,
+// generated by ClusterClassFragment for the DefaultMachineDeploymentClass.
+const ClusterClassPatchesFileName = "rke2-clusterclass-patches.yaml"
+
+// DefaultMachineDeploymentClass is the machine deployment class patched by the shipped ClusterClass patches.
+const DefaultMachineDeploymentClass = "default-worker"
+
+const (
+	// RKE2RevisionVariable is the revision of the rke2 release of the Kubernetes version of the topology, the rke2
+	// version of the machines is the Kubernetes version followed by the revision, e.g. v1.26.4+rke2r1.
+	RKE2RevisionVariable = "rke2Revision"
+
+	// CNIVariable is the CNI plugin deployed by the control plane.
+	CNIVariable = "cni"
+
+	// CISProfileVariable is the CIS profile enforced on all the machines, none when unset.
+	CISProfileVariable = "cisProfile"
+
+	// RegistryMirrorVariable is a registry mirror, pulled from by all the machines instead of the mirrored registry.
+	RegistryMirrorVariable = "registryMirror"
+)
+
+const (
+	rke2ControlPlaneTemplateKind = "RKE2ControlPlaneTemplate"
+	rke2ConfigTemplateKind       = "RKE2ConfigTemplate"
+)
+
+// ClusterClassVariables returns the ClusterClass variables of the most common rke2 options, set by the
+// ClusterClassPatches.
+func ClusterClassVariables() []clusterv1.ClusterClassVariable {
+	return []clusterv1.ClusterClassVariable{
+		{
+			Name:     RKE2RevisionVariable,
+			Required: true,
+			Schema: clusterv1.VariableSchema{OpenAPIV3Schema: clusterv1.JSONSchemaProps{
+				Description: "Revision of the rke2 release of the Kubernetes version of the cluster, e.g. rke2r1.",
+				Type:        "string",
+				Pattern:     "^rke2r[0-9]+$",
+				Default:     jsonValue("rke2r1"),
+			}},
+		},
+		{
+			Name:     CNIVariable,
+			Required: true,
+			Schema: clusterv1.VariableSchema{OpenAPIV3Schema: clusterv1.JSONSchemaProps{
+				Description: "CNI plugin deployed by the control plane.",
+				Type:        "string",
+				Enum: jsonValues(controlplanev1.None, controlplanev1.Calico, controlplanev1.Canal,
+					controlplanev1.Cilium),
+				Default: jsonValue(controlplanev1.Calico),
+			}},
+		},
+		{
+			Name: CISProfileVariable,
+			Schema: clusterv1.VariableSchema{OpenAPIV3Schema: clusterv1.JSONSchemaProps{
+				Description: "CIS profile enforced on all the machines, none when unset.",
+				Type:        "string",
+				Enum:        jsonValues(bootstrapv1.CIS1_23, bootstrapv1.CIS1_5, bootstrapv1.CIS1_6),
+			}},
+		},
+		{
+			Name: RegistryMirrorVariable,
+			Schema: clusterv1.VariableSchema{OpenAPIV3Schema: clusterv1.JSONSchemaProps{
+				Description: "Registry mirror pulled from by all the machines instead of the mirrored registry.",
+				Type:        "object",
+				Required:    []string{"endpoint"},
+				Properties: map[string]clusterv1.JSONSchemaProps{
+					"registry": {
+						Description: "Mirrored registry, \"*\" mirrors all the registries.",
+						Type:        "string",
+						MinLength:   pointer.Int64(1),
+						Default:     jsonValue("docker.io"),
+					},
+					"endpoint": {
+						Description: "URL of the mirror, e.g. https://mirror.example.com:5000.",
+						Type:        "string",
+						MinLength:   pointer.Int64(1),
+					},
+				},
+			}},
+		},
+	}
+}
+
+// ClusterClassPatches returns the ClusterClass patches setting the ClusterClassVariables on the
+// RKE2ControlPlaneTemplate of the ClusterClass and on the RKE2ConfigTemplates of the given machine deployment classes.
+// The patched templates must declare agentConfig, and the RKE2ControlPlaneTemplate serverConfig, as a JSON patch
+// only adds a field to an existing object. The registry mirror replaces the privateRegistriesConfig of the templates.
+func ClusterClassPatches(machineDeploymentClasses ...string) []clusterv1.ClusterClassPatch {
+	controlPlane := clusterv1.PatchSelector{
+		APIVersion:     controlplanev1.GroupVersion.String(),
+		Kind:           rke2ControlPlaneTemplateKind,
+		MatchResources: clusterv1.PatchSelectorMatch{ControlPlane: true},
+	}
+	workers := clusterv1.PatchSelector{
+		APIVersion: bootstrapv1.GroupVersion.String(),
+		Kind:       rke2ConfigTemplateKind,
+		MatchResources: clusterv1.PatchSelectorMatch{
+			MachineDeploymentClass: &clusterv1.PatchSelectorMatchMachineDeploymentClass{Names: machineDeploymentClasses},
+		},
+	}
+
+	// Both templates are patched alike, their spec is a RKE2ConfigSpec.
+	allTemplates := func(patches ...clusterv1.JSONPatch) []clusterv1.PatchDefinition {
+		return []clusterv1.PatchDefinition{
+			{Selector: controlPlane, JSONPatches: patches},
+			{Selector: workers, JSONPatches: patches},
+		}
+	}
+
+	registryMirror := `mirrors:
+  "{{ .registryMirror.registry }}":
+    endpoint:
+    - "{{ .registryMirror.endpoint }}"`
+
+	return []clusterv1.ClusterClassPatch{
+		{
+			Name:        "rke2Version",
+			Description: "Sets the rke2 version of the machines from the Kubernetes version and the rke2 revision.",
+			Definitions: []clusterv1.PatchDefinition{
+				{
+					Selector: controlPlane,
+					JSONPatches: []clusterv1.JSONPatch{{
+						Op:   "add",
+						Path: "/spec/template/spec/agentConfig/version",
+						ValueFrom: &clusterv1.JSONPatchValue{
+							Template: pointer.String("{{ .builtin.controlPlane.version }}+{{ .rke2Revision }}"),
+						},
+					}},
+				},
+				{
+					Selector: workers,
+					JSONPatches: []clusterv1.JSONPatch{{
+						Op:   "add",
+						Path: "/spec/template/spec/agentConfig/version",
+						ValueFrom: &clusterv1.JSONPatchValue{
+							Template: pointer.String("{{ .builtin.machineDeployment.version }}+{{ .rke2Revision }}"),
+						},
+					}},
+				},
+			},
+		},
+		{
+			Name:        CNIVariable,
+			Description: "Sets the CNI plugin deployed by the control plane.",
+			Definitions: []clusterv1.PatchDefinition{{
+				Selector: controlPlane,
+				JSONPatches: []clusterv1.JSONPatch{{
+					Op:        "add",
+					Path:      "/spec/template/spec/serverConfig/cni",
+					ValueFrom: &clusterv1.JSONPatchValue{Variable: pointer.String(CNIVariable)},
+				}},
+			}},
+		},
+		{
+			Name:        CISProfileVariable,
+			Description: "Enforces the CIS profile on all the machines.",
+			EnabledIf:   pointer.String("{{ if .cisProfile }}true{{ end }}"),
+			Definitions: allTemplates(clusterv1.JSONPatch{
+				Op:        "add",
+				Path:      "/spec/template/spec/agentConfig/cisProfile",
+				ValueFrom: &clusterv1.JSONPatchValue{Variable: pointer.String(CISProfileVariable)},
+			}),
+		},
+		{
+			Name:        RegistryMirrorVariable,
+			Description: "Configures all the machines to pull from the registry mirror.",
+			EnabledIf:   pointer.String("{{ if .registryMirror }}true{{ end }}"),
+			Definitions: allTemplates(clusterv1.JSONPatch{
+				Op:        "add",
+				Path:      "/spec/template/spec/privateRegistriesConfig",
+				ValueFrom: &clusterv1.JSONPatchValue{Template: pointer.String(registryMirror)},
+			}),
+		},
+	}
+}
+
+// ClusterClassFragment returns the ClusterClassVariables and the ClusterClassPatches for the given machine deployment
+// classes, as the YAML of the variables and patches fields of a ClusterClass spec.
+func ClusterClassFragment(machineDeploymentClasses ...string) ([]byte, error) {
+	fragment, err := yaml.Marshal(struct {
+		Variables []clusterv1.ClusterClassVariable `json:"variables"`
+		Patches   []clusterv1.ClusterClassPatch    `json:"patches"`
+	}{
+		Variables: ClusterClassVariables(),
+		Patches:   ClusterClassPatches(machineDeploymentClasses...),
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal the ClusterClass variables and patches")
+	}
+
+	return fragment, nil
+}
+
+// jsonValue returns the JSON of a value of a variable schema.
+func jsonValue(value interface{}) *apiextensionsv1.JSON {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		panic(err)
+	}
+
+	return &apiextensionsv1.JSON{Raw: raw}
+}
+
+// jsonValues returns the JSON of the values of a variable schema enum.
+func jsonValues[T ~string](values ...T) []apiextensionsv1.JSON {
+	enum := make([]apiextensionsv1.JSON, 0, len(values))
+	for _, value := range values {
+		enum = append(enum, *jsonValue(value))
+	}
+
+	return enum
+}
@@ -0,0 +1,295 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package templates
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"regexp"
+	"text/template"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"sigs.k8s.io/yaml"
+
+	clusterv1 "sigs.k8s.io/cluster-api/api/v1beta1"
+
+	bootstrapv1 "github.com/rancher-sandbox/cluster-api-provider-rke2/bootstrap/api/v1alpha1"
+	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+)
+
+// crdProperty returns the property of the v1alpha1 schema of the CRD generated from the API types at the path.
+func crdProperty(file string, path ...string) apiextensionsv1.JSONSchemaProps {
+	raw, err := os.ReadFile(filepath.Join("..", "..", file))
+	Expect(err).ToNot(HaveOccurred())
+
+	crd := &apiextensionsv1.CustomResourceDefinition{}
+	Expect(yaml.UnmarshalStrict(raw, crd)).To(Succeed())
+
+	for _, version := range crd.Spec.Versions {
+		if version.Name != "v1alpha1" {
+			continue
+		}
+
+		property := *version.Schema.OpenAPIV3Schema
+		for _, name := range path {
+			Expect(property.Properties).To(HaveKey(name), "%s: %v", file, path)
+			property = property.Properties[name]
+		}
+
+		return property
+	}
+
+	Fail("no v1alpha1 version in " + file)
+
+	return apiextensionsv1.JSONSchemaProps{}
+}
+
+// variableSchema returns the schema of the ClusterClass variable.
+func variableSchema(name string) clusterv1.JSONSchemaProps {
+	for _, variable := range ClusterClassVariables() {
+		if variable.Name == name {
+			return variable.Schema.OpenAPIV3Schema
+		}
+	}
+
+	Fail("unknown variable " + name)
+
+	return clusterv1.JSONSchemaProps{}
+}
+
+// renderTemplate renders a template of the patches the way the ClusterClass patch engine does, the templates use
+// none of the sprig functions it adds.
+func renderTemplate(text string, variables map[string]interface{}) string {
+	tpl, err := template.New("patch").Parse(text)
+	Expect(err).ToNot(HaveOccurred())
+
+	rendered := &bytes.Buffer{}
+	Expect(tpl.Execute(rendered, variables)).To(Succeed())
+
+	return rendered.String()
+}
+
+// applyPatches applies the enabled patches selecting the template, the way the ClusterClass patch engine does.
+func applyPatches(template map[string]interface{}, matches func(clusterv1.PatchSelector) bool,
+	variables map[string]interface{},
+) []byte {
+	patched, err := json.Marshal(template)
+	Expect(err).ToNot(HaveOccurred())
+
+	for _, patch := range ClusterClassPatches(DefaultMachineDeploymentClass) {
+		if patch.EnabledIf != nil && renderTemplate(*patch.EnabledIf, variables) != "true" {
+			continue
+		}
+
+		for _, definition := range patch.Definitions {
+			if !matches(definition.Selector) {
+				continue
+			}
+
+			operations := []map[string]interface{}{}
+
+			for _, jsonPatch := range definition.JSONPatches {
+				var value interface{}
+
+				switch {
+				case jsonPatch.ValueFrom != nil && jsonPatch.ValueFrom.Variable != nil:
+					value = variables[*jsonPatch.ValueFrom.Variable]
+				case jsonPatch.ValueFrom != nil && jsonPatch.ValueFrom.Template != nil:
+					Expect(yaml.Unmarshal([]byte(renderTemplate(*jsonPatch.ValueFrom.Template, variables)), &value)).
+						To(Succeed())
+				default:
+					Expect(json.Unmarshal(jsonPatch.Value.Raw, &value)).To(Succeed())
+				}
+
+				operations = append(operations, map[string]interface{}{
+					"op":    jsonPatch.Op,
+					"path":  jsonPatch.Path,
+					"value": value,
+				})
+			}
+
+			raw, err := json.Marshal(operations)
+			Expect(err).ToNot(HaveOccurred())
+
+			decoded, err := jsonpatch.DecodePatch(raw)
+			Expect(err).ToNot(HaveOccurred())
+
+			patched, err = decoded.Apply(patched)
+			Expect(err).ToNot(HaveOccurred(), "patch %q", patch.Name)
+		}
+	}
+
+	return patched
+}
+
+// decodeStrict decodes the patched template into its API type, refusing unknown fields.
+func decodeStrict(patched []byte, obj interface{}) {
+	decoder := json.NewDecoder(bytes.NewReader(patched))
+	decoder.DisallowUnknownFields()
+	Expect(decoder.Decode(obj)).To(Succeed())
+}
+
+var _ = Describe("ClusterClass patches", func() {
+	var (
+		controlPlaneTemplate map[string]interface{}
+		configTemplate       map[string]interface{}
+		variables            map[string]interface{}
+	)
+
+	isControlPlane := func(selector clusterv1.PatchSelector) bool {
+		return selector.Kind == rke2ControlPlaneTemplateKind && selector.MatchResources.ControlPlane
+	}
+
+	isWorker := func(selector clusterv1.PatchSelector) bool {
+		return selector.Kind == rke2ConfigTemplateKind && selector.MatchResources.MachineDeploymentClass != nil
+	}
+
+	BeforeEach(func() {
+		controlPlaneTemplate = map[string]interface{}{
+			"apiVersion": controlplanev1.GroupVersion.String(),
+			"kind":       rke2ControlPlaneTemplateKind,
+			"metadata":   map[string]interface{}{"name": "rke2-control-plane"},
+			"spec": map[string]interface{}{"template": map[string]interface{}{"spec": map[string]interface{}{
+				"infrastructureRef": map[string]interface{}{"kind": "DockerMachineTemplate", "name": "control-plane"},
+				"agentConfig":       map[string]interface{}{},
+				"serverConfig":      map[string]interface{}{},
+			}}},
+		}
+		configTemplate = map[string]interface{}{
+			"apiVersion": bootstrapv1.GroupVersion.String(),
+			"kind":       rke2ConfigTemplateKind,
+			"metadata":   map[string]interface{}{"name": "rke2-worker"},
+			"spec": map[string]interface{}{"template": map[string]interface{}{"spec": map[string]interface{}{
+				"agentConfig": map[string]interface{}{},
+			}}},
+		}
+		variables = map[string]interface{}{
+			"builtin": map[string]interface{}{
+				"controlPlane":      map[string]interface{}{"version": "v1.26.4"},
+				"machineDeployment": map[string]interface{}{"version": "v1.25.9"},
+			},
+			RKE2RevisionVariable: "rke2r1",
+			CNIVariable:          "calico",
+		}
+	})
+
+	It("should render the golden file", func() {
+		fragment, err := ClusterClassFragment(DefaultMachineDeploymentClass)
+		Expect(err).ToNot(HaveOccurred())
+
+		if *update {
+			Expect(os.WriteFile(ClusterClassPatchesFileName, fragment, 0o600)).To(Succeed())
+		}
+
+		expected, err := os.ReadFile(ClusterClassPatchesFileName)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(fragment)).To(Equal(string(expected)))
+	})
+
+	It("should offer the values accepted by the API", func() {
+		enum := func(values []apiextensionsv1.JSON) []string {
+			strs := []string{}
+			for _, value := range values {
+				var str string
+				Expect(json.Unmarshal(value.Raw, &str)).To(Succeed())
+				strs = append(strs, str)
+			}
+
+			return strs
+		}
+
+		controlPlaneCRD := "controlplane/config/crd/bases/controlplane.cluster.x-k8s.io_rke2controlplanetemplates.yaml"
+		configCRD := "bootstrap/config/crd/bases/bootstrap.cluster.x-k8s.io_rke2configtemplates.yaml"
+		templateSpec := []string{"spec", "template", "spec"}
+
+		Expect(enum(variableSchema(CNIVariable).Enum)).To(ConsistOf(enum(
+			crdProperty(controlPlaneCRD, append(templateSpec, "serverConfig", "cni")...).Enum)))
+		Expect(enum(variableSchema(CISProfileVariable).Enum)).To(ConsistOf(enum(
+			crdProperty(controlPlaneCRD, append(templateSpec, "agentConfig", "cisProfile")...).Enum)))
+		Expect(enum(variableSchema(CISProfileVariable).Enum)).To(ConsistOf(enum(
+			crdProperty(configCRD, append(templateSpec, "agentConfig", "cisProfile")...).Enum)))
+
+		revision := regexp.MustCompile(variableSchema(RKE2RevisionVariable).Pattern)
+		Expect(revision.MatchString("rke2r1")).To(BeTrue())
+		Expect(revision.MatchString("rke2r12")).To(BeTrue())
+		Expect(revision.MatchString("r1")).To(BeFalse())
+		Expect(revision.MatchString("v1.26.4+rke2r1")).To(BeFalse())
+
+		mirror := variableSchema(RegistryMirrorVariable)
+		Expect(mirror.Required).To(ConsistOf("endpoint"))
+		Expect(mirror.Properties).To(HaveKey("registry"))
+	})
+
+	It("should patch the control plane template with the variables", func() {
+		variables[CNIVariable] = "cilium"
+		variables[CISProfileVariable] = "cis-1.23"
+		variables[RegistryMirrorVariable] = map[string]interface{}{
+			"registry": "docker.io",
+			"endpoint": "https://mirror.example.com",
+		}
+
+		patched := applyPatches(controlPlaneTemplate, isControlPlane, variables)
+
+		template := &controlplanev1.RKE2ControlPlaneTemplate{}
+		decodeStrict(patched, template)
+
+		spec := template.Spec.Template.Spec
+		Expect(spec.AgentConfig.Version).To(Equal("v1.26.4+rke2r1"))
+		Expect(spec.ServerConfig.CNI).To(Equal(controlplanev1.Cilium))
+		Expect(spec.AgentConfig.CISProfile).To(Equal(bootstrapv1.CIS1_23))
+		Expect(spec.PrivateRegistriesConfig.Mirrors).To(Equal(map[string]bootstrapv1.Mirror{
+			"docker.io": {Endpoint: []string{"https://mirror.example.com"}},
+		}))
+	})
+
+	It("should patch the worker templates with the variables", func() {
+		variables[CISProfileVariable] = "cis-1.6"
+		variables[RegistryMirrorVariable] = map[string]interface{}{
+			"registry": "*",
+			"endpoint": "https://mirror.example.com",
+		}
+
+		patched := applyPatches(configTemplate, isWorker, variables)
+
+		template := &bootstrapv1.RKE2ConfigTemplate{}
+		decodeStrict(patched, template)
+
+		spec := template.Spec.Template.Spec
+		Expect(spec.AgentConfig.Version).To(Equal("v1.25.9+rke2r1"))
+		Expect(spec.AgentConfig.CISProfile).To(Equal(bootstrapv1.CIS1_6))
+		Expect(spec.PrivateRegistriesConfig.Mirrors).To(HaveKey("*"))
+		Expect(template.ValidateCreate()).To(Succeed())
+	})
+
+	It("should leave the optional options unset", func() {
+		patched := applyPatches(configTemplate, isWorker, variables)
+
+		template := &bootstrapv1.RKE2ConfigTemplate{}
+		decodeStrict(patched, template)
+
+		spec := template.Spec.Template.Spec
+		Expect(spec.AgentConfig.Version).To(Equal("v1.25.9+rke2r1"))
+		Expect(spec.AgentConfig.CISProfile).To(BeEmpty())
+		Expect(spec.PrivateRegistriesConfig.Mirrors).To(BeEmpty())
+	})
+})
@@ -0,0 +1,356 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package templates contains the cluster template flavors shipped with the provider, used with
+// "clusterctl generate cluster --from", and the variables each flavor is rendered with.
+package templates
+
+import (
+	"embed"
+	"net"
+	"strconv"
+
+	"github.com/drone/envsubst/v2"
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+
+	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+	"github.com/rancher-sandbox/cluster-api-provider-rke2/pkg/compatibility"
+)
+
+//go:embed cluster-template*.yaml
+var templates embed.FS
+
+// Flavor is a cluster template flavor, the suffix of its file name.
+type Flavor string
+
+const (
+	// DevelopmentFlavor is the default flavor, a cluster on the docker infrastructure provider.
+	DevelopmentFlavor Flavor = ""
+
+	// AirGappedFlavor is a cluster whose machines run an image embedding the rke2 artifacts.
+	AirGappedFlavor Flavor = "airgapped"
+
+	// ExternalLoadBalancerFlavor is a highly available cluster behind an external load balancer.
+	ExternalLoadBalancerFlavor Flavor = "ha-external-lb"
+
+	// SingleNodeFlavor is a cluster with a single server and no agents.
+	SingleNodeFlavor Flavor = "single-node"
+)
+
+// Flavors are the flavors shipped with the provider.
+var Flavors = []Flavor{DevelopmentFlavor, AirGappedFlavor, ExternalLoadBalancerFlavor, SingleNodeFlavor}
+
+// FileName returns the file name of the template of the flavor, following the clusterctl naming convention.
+func (f Flavor) FileName() string {
+	if f == DevelopmentFlavor {
+		return "cluster-template.yaml"
+	}
+
+	return "cluster-template-" + string(f) + ".yaml"
+}
+
+// Template returns the template of the flavor.
+func (f Flavor) Template() ([]byte, error) {
+	template, err := templates.ReadFile(f.FileName())
+	if err != nil {
+		return nil, errors.Wrapf(err, "unknown flavor %q", f)
+	}
+
+	return template, nil
+}
+
+// Variables are the variables a flavor is rendered with.
+type Variables interface {
+	// Flavor returns the flavor the variables are for.
+	Flavor() Flavor
+
+	// Validate returns an error if the variables wouldn't produce a working cluster.
+	Validate() error
+
+	// Env returns the variables by name, the optional variables which are not set are empty.
+	Env() map[string]string
+}
+
+// Render renders the template of the flavor of the variables, the way clusterctl generate cluster does.
+func Render(variables Variables) ([]byte, error) {
+	if err := variables.Validate(); err != nil {
+		return nil, errors.Wrapf(err, "invalid variables for the %q flavor", variables.Flavor())
+	}
+
+	template, err := variables.Flavor().Template()
+	if err != nil {
+		return nil, err
+	}
+
+	env := variables.Env()
+	missing := []string{}
+
+	rendered, err := envsubst.Eval(string(template), func(name string) string {
+		value, ok := env[name]
+		if !ok {
+			missing = append(missing, name)
+		}
+
+		return value
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to render the %q flavor", variables.Flavor())
+	}
+
+	if len(missing) > 0 {
+		return nil, errors.Errorf("the %q flavor uses unknown variables %v", variables.Flavor(), missing)
+	}
+
+	return []byte(rendered), nil
+}
+
+// ClusterVariables are the variables of all the flavors.
+type ClusterVariables struct {
+	// ClusterName is the name of the cluster, CLUSTER_NAME.
+	ClusterName string
+
+	// Namespace is the namespace of the cluster, NAMESPACE.
+	Namespace string
+
+	// KubernetesVersion is the Kubernetes version of the cluster, e.g. v1.26.4, KUBERNETES_VERSION.
+	KubernetesVersion string
+
+	// RKE2Revision is the revision of the rke2 release of the Kubernetes version, RKE2_REVISION (default: rke2r1).
+	RKE2Revision string
+
+	// PodCIDR is the CIDR of the pods, POD_CIDR (default: 10.45.0.0/16).
+	PodCIDR string
+
+	// ServiceCIDR is the CIDR of the services, SERVICE_CIDR (default: 10.46.0.0/16).
+	ServiceCIDR string
+
+	// CNI is the CNI plugin, one of none, calico, canal, cilium, CNI (default: calico).
+	CNI string
+}
+
+// Validate returns an error if the variables wouldn't produce a working cluster.
+func (v *ClusterVariables) Validate() error {
+	return v.validate().ToAggregate()
+}
+
+func (v *ClusterVariables) validate() field.ErrorList {
+	var errs field.ErrorList
+
+	// The longest object name is the cluster name with the -control-plane suffix.
+	for _, msg := range validation.IsDNS1123Label(v.ClusterName + "-control-plane") {
+		errs = append(errs, field.Invalid(field.NewPath("CLUSTER_NAME"), v.ClusterName, msg))
+	}
+
+	for _, msg := range validation.IsDNS1123Label(v.Namespace) {
+		errs = append(errs, field.Invalid(field.NewPath("NAMESPACE"), v.Namespace, msg))
+	}
+
+	revision := v.RKE2Revision
+	if revision == "" {
+		revision = "rke2r1"
+	}
+
+	if err := compatibility.Embedded().Validate(v.KubernetesVersion + "+" + revision); err != nil {
+		errs = append(errs, field.Invalid(field.NewPath("KUBERNETES_VERSION"), v.KubernetesVersion, err.Error()))
+	}
+
+	for name, cidr := range map[string]string{"POD_CIDR": v.PodCIDR, "SERVICE_CIDR": v.ServiceCIDR} {
+		if cidr == "" {
+			continue
+		}
+
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			errs = append(errs, field.Invalid(field.NewPath(name), cidr, err.Error()))
+		}
+	}
+
+	switch controlplanev1.CNI(v.CNI) {
+	case "", controlplanev1.None, controlplanev1.Calico, controlplanev1.Canal, controlplanev1.Cilium:
+	default:
+		errs = append(errs, field.NotSupported(field.NewPath("CNI"), v.CNI, []string{
+			string(controlplanev1.None), string(controlplanev1.Calico), string(controlplanev1.Canal), string(controlplanev1.Cilium),
+		}))
+	}
+
+	return errs
+}
+
+// Env returns the variables by name.
+func (v *ClusterVariables) Env() map[string]string {
+	return map[string]string{
+		"CLUSTER_NAME":       v.ClusterName,
+		"NAMESPACE":          v.Namespace,
+		"KUBERNETES_VERSION": v.KubernetesVersion,
+		"RKE2_REVISION":      v.RKE2Revision,
+		"POD_CIDR":           v.PodCIDR,
+		"SERVICE_CIDR":       v.ServiceCIDR,
+		"CNI":                v.CNI,
+	}
+}
+
+// DevelopmentVariables are the variables of the DevelopmentFlavor.
+type DevelopmentVariables struct {
+	ClusterVariables
+
+	// ControlPlaneMachineCount is the number of servers, CONTROL_PLANE_MACHINE_COUNT.
+	ControlPlaneMachineCount int
+
+	// WorkerMachineCount is the number of agents, WORKER_MACHINE_COUNT.
+	WorkerMachineCount int
+}
+
+// Flavor returns the flavor the variables are for.
+func (v *DevelopmentVariables) Flavor() Flavor {
+	return DevelopmentFlavor
+}
+
+// Validate returns an error if the variables wouldn't produce a working cluster.
+func (v *DevelopmentVariables) Validate() error {
+	return v.validate().ToAggregate()
+}
+
+func (v *DevelopmentVariables) validate() field.ErrorList {
+	errs := v.ClusterVariables.validate()
+
+	// etcd needs a majority of the servers to be available.
+	if v.ControlPlaneMachineCount < 1 || v.ControlPlaneMachineCount%2 == 0 {
+		errs = append(errs, field.Invalid(field.NewPath("CONTROL_PLANE_MACHINE_COUNT"), v.ControlPlaneMachineCount,
+			"must be an odd number"))
+	}
+
+	if v.WorkerMachineCount < 0 {
+		errs = append(errs, field.Invalid(field.NewPath("WORKER_MACHINE_COUNT"), v.WorkerMachineCount,
+			"must not be negative"))
+	}
+
+	return errs
+}
+
+// Env returns the variables by name.
+func (v *DevelopmentVariables) Env() map[string]string {
+	env := v.ClusterVariables.Env()
+	env["CONTROL_PLANE_MACHINE_COUNT"] = strconv.Itoa(v.ControlPlaneMachineCount)
+	env["WORKER_MACHINE_COUNT"] = strconv.Itoa(v.WorkerMachineCount)
+
+	return env
+}
+
+// AirGappedVariables are the variables of the AirGappedFlavor.
+type AirGappedVariables struct {
+	DevelopmentVariables
+
+	// AirGappedImage is the image of the machines, embedding the rke2 artifacts, AIRGAPPED_IMAGE.
+	AirGappedImage string
+}
+
+// Flavor returns the flavor the variables are for.
+func (v *AirGappedVariables) Flavor() Flavor {
+	return AirGappedFlavor
+}
+
+// Validate returns an error if the variables wouldn't produce a working cluster.
+func (v *AirGappedVariables) Validate() error {
+	errs := v.DevelopmentVariables.validate()
+
+	if v.AirGappedImage == "" {
+		errs = append(errs, field.Required(field.NewPath("AIRGAPPED_IMAGE"), "the machines need an image embedding rke2"))
+	}
+
+	return errs.ToAggregate()
+}
+
+// Env returns the variables by name.
+func (v *AirGappedVariables) Env() map[string]string {
+	env := v.DevelopmentVariables.Env()
+	env["AIRGAPPED_IMAGE"] = v.AirGappedImage
+
+	return env
+}
+
+// ExternalLoadBalancerVariables are the variables of the ExternalLoadBalancerFlavor.
+type ExternalLoadBalancerVariables struct {
+	DevelopmentVariables
+
+	// ControlPlaneEndpointHost is the host name or IP address of the load balancer, CONTROL_PLANE_ENDPOINT_HOST.
+	ControlPlaneEndpointHost string
+
+	// ControlPlaneEndpointPort is the API server port of the load balancer, CONTROL_PLANE_ENDPOINT_PORT
+	// (default: 6443).
+	ControlPlaneEndpointPort int
+
+	// FailureDomain is the failure domain of the machines registering with the load balancer, FAILURE_DOMAIN
+	// (default: fd1).
+	FailureDomain string
+}
+
+// Flavor returns the flavor the variables are for.
+func (v *ExternalLoadBalancerVariables) Flavor() Flavor {
+	return ExternalLoadBalancerFlavor
+}
+
+// Validate returns an error if the variables wouldn't produce a working cluster.
+func (v *ExternalLoadBalancerVariables) Validate() error {
+	errs := v.DevelopmentVariables.validate()
+
+	if v.ControlPlaneMachineCount < 3 {
+		errs = append(errs, field.Invalid(field.NewPath("CONTROL_PLANE_MACHINE_COUNT"), v.ControlPlaneMachineCount,
+			"a highly available control plane needs at least 3 servers"))
+	}
+
+	if v.ControlPlaneEndpointHost == "" {
+		errs = append(errs, field.Required(field.NewPath("CONTROL_PLANE_ENDPOINT_HOST"), "the load balancer address is required"))
+	}
+
+	for _, msg := range validation.IsValidPortNum(v.ControlPlaneEndpointPort) {
+		if v.ControlPlaneEndpointPort != 0 {
+			errs = append(errs, field.Invalid(field.NewPath("CONTROL_PLANE_ENDPOINT_PORT"), v.ControlPlaneEndpointPort, msg))
+		}
+	}
+
+	if v.FailureDomain != "" {
+		for _, msg := range validation.IsQualifiedName(v.FailureDomain) {
+			errs = append(errs, field.Invalid(field.NewPath("FAILURE_DOMAIN"), v.FailureDomain, msg))
+		}
+	}
+
+	return errs.ToAggregate()
+}
+
+// Env returns the variables by name.
+func (v *ExternalLoadBalancerVariables) Env() map[string]string {
+	env := v.DevelopmentVariables.Env()
+	env["CONTROL_PLANE_ENDPOINT_HOST"] = v.ControlPlaneEndpointHost
+	env["CONTROL_PLANE_ENDPOINT_PORT"] = ""
+	env["FAILURE_DOMAIN"] = v.FailureDomain
+
+	if v.ControlPlaneEndpointPort != 0 {
+		env["CONTROL_PLANE_ENDPOINT_PORT"] = strconv.Itoa(v.ControlPlaneEndpointPort)
+	}
+
+	return env
+}
+
+// SingleNodeVariables are the variables of the SingleNodeFlavor.
+type SingleNodeVariables struct {
+	ClusterVariables
+}
+
+// Flavor returns the flavor the variables are for.
+func (v *SingleNodeVariables) Flavor() Flavor {
+	return SingleNodeFlavor
+}
@@ -0,0 +1,204 @@
+/*
+Copyright 2023 SUSE.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package templates
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+
+	bootstrapv1 "github.com/rancher-sandbox/cluster-api-provider-rke2/bootstrap/api/v1alpha1"
+	controlplanev1 "github.com/rancher-sandbox/cluster-api-provider-rke2/controlplane/api/v1alpha1"
+)
+
+var update = flag.Bool("update", false, "update the golden files of the rendered templates")
+
+var variableRegexp = regexp.MustCompile(`\$\{([A-Z0-9_]+)(:=[^}]*)?\}`)
+
+// exampleVariables are the variables the golden files are rendered with.
+func exampleVariables() []Variables {
+	development := DevelopmentVariables{
+		ClusterVariables: ClusterVariables{
+			ClusterName:       "rke2",
+			Namespace:         "default",
+			KubernetesVersion: "v1.26.4",
+		},
+		ControlPlaneMachineCount: 3,
+		WorkerMachineCount:       2,
+	}
+
+	return []Variables{
+		&development,
+		&AirGappedVariables{DevelopmentVariables: development, AirGappedImage: "rke2-ubuntu:v1.26.4-rke2r1"},
+		&ExternalLoadBalancerVariables{DevelopmentVariables: development, ControlPlaneEndpointHost: "lb.example.com"},
+		&SingleNodeVariables{ClusterVariables: development.ClusterVariables},
+	}
+}
+
+// decodeDocuments decodes the documents of a rendered template.
+func decodeDocuments(rendered []byte) []*unstructured.Unstructured {
+	reader := utilyaml.NewYAMLReader(bufio.NewReader(bytes.NewReader(rendered)))
+	objs := []*unstructured.Unstructured{}
+
+	for {
+		doc, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return objs
+		}
+
+		Expect(err).ToNot(HaveOccurred())
+
+		obj := &unstructured.Unstructured{}
+		Expect(utilyaml.Unmarshal(doc, &obj.Object)).To(Succeed())
+
+		objs = append(objs, obj)
+	}
+}
+
+var _ = Describe("Flavors", func() {
+	It("should have variables for each flavor", func() {
+		flavors := []Flavor{}
+		for _, variables := range exampleVariables() {
+			flavors = append(flavors, variables.Flavor())
+		}
+
+		Expect(flavors).To(ConsistOf(Flavors))
+	})
+
+	It("should define the variables used by the templates", func() {
+		for _, variables := range exampleVariables() {
+			template, err := variables.Flavor().Template()
+			Expect(err).ToNot(HaveOccurred())
+
+			used := map[string]bool{}
+			for _, match := range variableRegexp.FindAllStringSubmatch(string(template), -1) {
+				used[match[1]] = true
+			}
+
+			defined := map[string]bool{}
+			for name := range variables.Env() {
+				defined[name] = true
+			}
+
+			Expect(defined).To(Equal(used), "flavor %q", variables.Flavor())
+		}
+	})
+
+	It("should render the golden files", func() {
+		for _, variables := range exampleVariables() {
+			rendered, err := Render(variables)
+			Expect(err).ToNot(HaveOccurred())
+
+			golden := filepath.Join("testdata", variables.Flavor().FileName())
+			if *update {
+				Expect(os.WriteFile(golden, rendered, 0o600)).To(Succeed())
+			}
+
+			expected, err := os.ReadFile(golden)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(rendered)).To(Equal(string(expected)), "flavor %q", variables.Flavor())
+		}
+	})
+
+	It("should render objects accepted by the webhooks", func() {
+		for _, variables := range exampleVariables() {
+			rendered, err := Render(variables)
+			Expect(err).ToNot(HaveOccurred())
+
+			kinds := []string{}
+
+			for _, obj := range decodeDocuments(rendered) {
+				kinds = append(kinds, obj.GetKind())
+
+				switch obj.GetKind() {
+				case "RKE2ControlPlane":
+					rcp := &controlplanev1.RKE2ControlPlane{}
+					Expect(runtime.DefaultUnstructuredConverter.FromUnstructuredWithValidation(obj.Object, rcp, true)).To(Succeed())
+					rcp.Default()
+					Expect(rcp.ValidateCreate()).To(Succeed(), "flavor %q", variables.Flavor())
+				case "RKE2ConfigTemplate":
+					template := &bootstrapv1.RKE2ConfigTemplate{}
+					Expect(runtime.DefaultUnstructuredConverter.FromUnstructuredWithValidation(obj.Object, template, true)).To(Succeed())
+					template.Default()
+					Expect(template.ValidateCreate()).To(Succeed(), "flavor %q", variables.Flavor())
+				}
+			}
+
+			Expect(kinds).To(ContainElements("Cluster", "RKE2ControlPlane"), "flavor %q", variables.Flavor())
+		}
+	})
+})
+
+var _ = Describe("Render", func() {
+	It("should reject invalid variables", func() {
+		_, err := Render(&DevelopmentVariables{
+			ClusterVariables: ClusterVariables{
+				ClusterName:       "Invalid_Name",
+				Namespace:         "default",
+				KubernetesVersion: "v1.10.0",
+				CNI:               "flannel",
+			},
+			ControlPlaneMachineCount: 2,
+		})
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("CLUSTER_NAME"))
+		Expect(err.Error()).To(ContainSubstring("KUBERNETES_VERSION"))
+		Expect(err.Error()).To(ContainSubstring("CNI"))
+		Expect(err.Error()).To(ContainSubstring("CONTROL_PLANE_MACHINE_COUNT"))
+	})
+
+	It("should require a highly available control plane behind the load balancer", func() {
+		_, err := Render(&ExternalLoadBalancerVariables{
+			DevelopmentVariables: DevelopmentVariables{
+				ClusterVariables: ClusterVariables{
+					ClusterName:       "rke2",
+					Namespace:         "default",
+					KubernetesVersion: "v1.26.4",
+				},
+				ControlPlaneMachineCount: 1,
+			},
+		})
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("CONTROL_PLANE_MACHINE_COUNT"))
+		Expect(err.Error()).To(ContainSubstring("CONTROL_PLANE_ENDPOINT_HOST"))
+	})
+
+	It("should override the defaults of the optional variables", func() {
+		rendered, err := Render(&SingleNodeVariables{ClusterVariables: ClusterVariables{
+			ClusterName:       "rke2",
+			Namespace:         "default",
+			KubernetesVersion: "v1.26.4",
+			RKE2Revision:      "rke2r2",
+			CNI:               "cilium",
+		}})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(rendered)).To(ContainSubstring("version: v1.26.4+rke2r2\n"))
+		Expect(string(rendered)).To(ContainSubstring("cni: cilium\n"))
+	})
+})